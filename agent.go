@@ -28,6 +28,15 @@ type (
 		EdgeKeySet bool
 	}
 
+	// ClusterKey is the representation of a key of the cluster gossip encryption keyring.
+	ClusterKey struct {
+		// Fingerprint identifies the key without exposing it
+		Fingerprint  string
+		Primary      bool
+		Members      int
+		TotalMembers int
+	}
+
 	// ContainerPlatform represent the platform on which the agent is running (Docker, Kubernetes)
 	ContainerPlatform int
 
@@ -50,6 +59,23 @@ type (
 		LogFileContent string `json:"LogFileContent"`
 	}
 
+	// EdgeJobExecution is an execution of an Edge job, kept in the job history of the device
+	EdgeJobExecution struct {
+		JobID    int           `json:"JobID"`
+		Start    time.Time     `json:"Start"`
+		End      time.Time     `json:"End"`
+		Duration time.Duration `json:"Duration"`
+		ExitCode int           `json:"ExitCode"`
+		// TimedOut is true when the execution was killed because it exceeded the timeout of the job
+		TimedOut bool `json:"TimedOut"`
+		// Attempt is the attempt of the execution, starting at 1, out of Attempts when the job is retried
+		Attempt  int `json:"Attempt"`
+		Attempts int `json:"Attempts"`
+		// Output is the end of the output of the execution, OutputTruncated is true when its beginning was left out
+		Output          string `json:"Output"`
+		OutputTruncated bool   `json:"OutputTruncated"`
+	}
+
 	// HostInfo is the representation of the collection of host information
 	HostInfo struct {
 		PCIDevices    []PciDevice
@@ -79,40 +105,139 @@ type (
 
 	// Options are the options used to start an agent.
 	Options struct {
-		AssetsPath            string
-		AgentServerAddr       string
-		AgentServerPort       string
-		AgentSecurityShutdown time.Duration
-		ClusterAddress        string
-		ClusterProbeTimeout   time.Duration
-		ClusterProbeInterval  time.Duration
-		DataPath              string
-		SharedSecret          string
-		EdgeMode              bool
-		EdgeAsyncMode         bool
-		EdgeKey               string
-		EdgeID                string
-		EdgeUIServerAddr      string
-		EdgeUIServerPort      string
-		EdgeInactivityTimeout string
-		EdgeInsecurePoll      bool
-		EdgeTunnel            bool
-		EdgeTunnelProxy       string
-		EdgeMetaFields        EdgeMetaFields
-		LogLevel              string
-		LogMode               string
-		HealthCheck           bool
-		SSLCert               string
-		SSLKey                string
-		SSLCACert             string
-		CertRetryInterval     time.Duration
-		AWSClientCert         string
-		AWSClientKey          string
-		AWSClientBundle       string
-		AWSRoleARN            string
-		AWSTrustAnchorARN     string
-		AWSProfileARN         string
-		AWSRegion             string
+		AssetsPath                string
+		AgentServerAddr           string
+		AgentServerPort           string
+		AgentSecurityShutdown     time.Duration
+		ClusterAddress            string
+		ClusterProbeTimeout       time.Duration
+		ClusterProbeInterval      time.Duration
+		ClusterKey                string
+		ClusterMTLSCACert         string
+		ClusterMTLSCert           string
+		ClusterMTLSKey            string
+		DataPath                  string
+		SharedSecret              string
+		EdgeMode                  bool
+		EdgeAsyncMode             bool
+		EdgeJSONCodec             string
+		EdgeCompression           string
+		EdgeUserAgent             string
+		EdgeFleetTag              string
+		EdgeExtraHeaders          []string
+		EdgeRequestRetries        int
+		EdgeCircuitCooldown       time.Duration
+		EdgeKey                   string
+		EdgeID                    string
+		EdgeUIServerAddr          string
+		EdgeUIServerPort          string
+		EdgeInactivityTimeout     string
+		EdgeInsecurePoll          bool
+		EdgeServerCA              string
+		EdgeRegistryCA            string
+		EdgeServerPins            []string
+		EdgeTLSRevocation         string
+		EdgeTunnel                bool
+		EdgeTunnelProxy           string
+		EdgeDeployHTTPProxy       string
+		EdgeDeployHTTPSProxy      string
+		EdgeDeployNoProxy         []string
+		EdgeMetaFields            EdgeMetaFields
+		LogLevel                  string
+		LogMode                   string
+		HealthCheck               bool
+		DurableWrites             bool
+		EdgeProfile               string
+		EdgeSnapshotMode          string
+		EdgeStackPullProgress     string
+		MDNS                      bool
+		DockerHost                string
+		DockerContext             string
+		SSLCert                   string
+		SSLKey                    string
+		SSLCACert                 string
+		CertRetryInterval         time.Duration
+		AWSClientCert             string
+		AWSClientKey              string
+		AWSClientBundle           string
+		AWSRoleARN                string
+		AWSTrustAnchorARN         string
+		AWSProfileARN             string
+		AWSRegion                 string
+		EdgeNotifyMQTTAddr        string
+		EdgeNotifyMQTTTopic       string
+		EdgeNotifyWebhookURL      string
+		EdgeNotifyExec            string
+		EdgeStatusWebhooks        []string
+		EdgeStatusWebhookRate     time.Duration
+		EdgeStackHistoryCount     int
+		EdgeStackHistorySize      int64
+		EdgeStackChangelogReport  bool
+		EdgeStackChangelog        bool
+		EdgeStackLargeFileSize    int64
+		EdgeStackCache            string
+		EdgeStackCacheSize        int64
+		EdgeStackCacheS3URL       string
+		EdgeStackCacheS3Region    string
+		EdgeStackCacheS3AccessKey string
+		EdgeStackCacheS3SecretKey string
+		EdgeSessionRecord         string
+		EdgeSessionRecordSize     int64
+		EdgeJobHistoryCount       int
+		EdgeJobHistoryReport      bool
+		EdgeJobHistory            bool
+		EdgeJobHistoryID          int
+		EdgeJobUser               string
+		EdgeJobAllowRoot          bool
+		EdgeFileTransferPaths     []string
+		EdgeFileTransferSize      int64
+		EdgeHostInfoInterval      time.Duration
+		EdgeHostUpdatesCheck      bool
+		EdgeHostUpdateActions     []string
+		EdgePowerCriticalHours    string
+		EdgeUpgradeVerifyWindow   time.Duration
+		EdgeCredentialStore       string
+		EdgeCredentialHelper      string
+		EdgeStackOrphanPolicy     string
+		EdgeStackLint             string
+		EdgeStackSkipUnchanged    bool
+		EdgeStackNaming           string
+		EdgeStackPrefix           string
+		EdgeStackEnvPaths         []string
+		EdgeStorageQuota          int64
+		EdgeJobLogMaxSize         int64
+		EdgeStackFilesPath        string
+		EdgeJobScriptsPath        string
+		TmpPath                   string
+		FIPSMode                  bool
+		TLSMinVersion             string
+		TLSCipherSuites           []string
+		EdgeLabelsFile            string
+		EdgeBootstrapFile         string
+		EdgeAttestation           string
+		EdgeAttestationCert       string
+		EdgeAttestationKey        string
+		EdgeAttestationTPMHandle  string
+		EdgeStandby               bool
+		EdgeStandbyLease          time.Duration
+		EdgeEndpointsFile         string
+		EdgeImagePolicyFile       string
+		EdgeSecurityPolicyFile    string
+		EdgeFirewallPolicyFile    string
+		EdgeOPAPolicy             string
+		EdgeOPABinary             string
+		EdgeSetLabels             []string
+		PrintConfig               bool
+		Preflight                 bool
+		EdgeExportBackup          string
+		EdgeImportBackup          string
+		EdgePause                 time.Duration
+		EdgePauseReason           string
+		EdgeResume                bool
+		EdgeFreezeUntil           string
+		EdgeFreezeStack           int
+		EdgeFreezeReason          string
+		EdgeUnfreeze              bool
 	}
 
 	NomadConfig struct {
@@ -152,6 +277,23 @@ type (
 		Script         string
 		Version        int
 		CollectLogs    bool
+		// Timeout is how long an execution can run before its process tree is killed, unlimited when zero
+		Timeout time.Duration
+		// Retries is the number of times a failed execution is attempted again, RetryDelay apart
+		Retries    int
+		RetryDelay time.Duration
+		RunAs      JobRunAs
+	}
+
+	// JobRunAs is the identity and the environment the script of an Edge job runs with on the host
+	JobRunAs struct {
+		// User and Group are names or numeric identifiers, the default user of the agent is used when User is empty
+		// and the primary group of the user when Group is empty
+		User       string
+		Group      string
+		WorkingDir string
+		// Env are the names of the variables of the environment of the cron entries passed to the script, along with PATH
+		Env []string
 	}
 
 	// TunnelConfig contains all the required information for the agent to establish
@@ -166,6 +308,14 @@ type (
 		Proxy string
 	}
 
+	// Clock is used to read the current time and to wait for durations to elapse.
+	// It allows time based behaviors (retries, backoffs, timeouts) to be tested deterministically.
+	Clock interface {
+		Now() time.Time
+		Sleep(d time.Duration)
+		After(d time.Duration) <-chan time.Time
+	}
+
 	// ClusterService is used to manage a cluster of agents.
 	ClusterService interface {
 		Create(advertiseAddr string, joinAddr []string, probeTimeout, probeInterval time.Duration) error
@@ -176,6 +326,8 @@ type (
 		GetMemberWithEdgeKeySet() *ClusterMember
 		GetRuntimeConfiguration() *RuntimeConfiguration
 		UpdateRuntimeConfiguration(runtimeConfiguration *RuntimeConfiguration) error
+		RotateEncryptionKey(key string, overlap time.Duration) error
+		EncryptionKeys() ([]ClusterKey, error)
 	}
 
 	// DigitalSignatureService is used to validate digital signatures.
@@ -206,11 +358,24 @@ type (
 		Namespace  string
 		WorkingDir string
 		Env        []string
+		// Proxy is the proxy the deployer pulls the images through, nil to inherit the environment of the agent
+		Proxy *ProxyOptions
+	}
+
+	// ProxyOptions is the proxy passed to the processes of the deployers, in the environment variables they read
+	ProxyOptions struct {
+		HTTPProxy  string
+		HTTPSProxy string
+		// NoProxy are the hosts reached without the proxy, e.g. the registries of the local network
+		NoProxy []string
 	}
 
 	DeployOptions struct {
 		DeployerBaseOptions
 		Prune bool
+		// HostBasePath is the folder on the host the relative paths of the stack are resolved against.
+		// Only set for relative path stacks.
+		HostBasePath string
 	}
 
 	RemoveOptions struct {
@@ -304,10 +469,26 @@ const (
 	// HTTPPublicKeyHeaderName is the name of the header containing the public key
 	// of a Portainer instance.
 	HTTPPublicKeyHeaderName = "X-PortainerAgent-PublicKey"
+	// HTTPClusterSignatureHeaderName is the name of the header containing the signature
+	// of a request forwarded by another agent of the cluster.
+	HTTPClusterSignatureHeaderName = "X-PortainerAgent-Cluster-Signature"
+	// HTTPClusterTimestampHeaderName is the name of the header containing the unix timestamp
+	// a forwarded request was signed at.
+	HTTPClusterTimestampHeaderName = "X-PortainerAgent-Cluster-Timestamp"
+	// HTTPClusterNonceHeaderName is the name of the header containing the nonce
+	// used to reject the replayed forwarded requests.
+	HTTPClusterNonceHeaderName = "X-PortainerAgent-Cluster-Nonce"
+	// HTTPClusterBodyDigestHeaderName is the name of the header containing the SHA-256 digest
+	// of the body of a forwarded request.
+	HTTPClusterBodyDigestHeaderName = "X-PortainerAgent-Cluster-Body-Digest"
 	// HTTPResponseAgentTimeZone is the name of the header containing the timezone
 	HTTPResponseAgentTimeZone = "X-PortainerAgent-TimeZone"
 	// HTTPResponseUpdateIDHeaderName is the name of the header that will have the update ID that started this container
 	HTTPResponseUpdateIDHeaderName = "X-PortainerAgent-Update-ID"
+	// HTTPEngineHeaderName is the name of the header containing the container engine of the agent, e.g. podman
+	HTTPEngineHeaderName = "X-PortainerAgent-Engine"
+	// HTTPFleetTagHeaderName is the name of the header containing the fleet tag defined by the operator
+	HTTPFleetTagHeaderName = "X-PortainerAgent-Fleet-Tag"
 	// HTTPResponseAgentHeaderName is the name of the header that is automatically added
 	// to each agent response.
 	HTTPResponseAgentHeaderName = "Portainer-Agent"
@@ -354,6 +535,12 @@ const (
 	NomadTLSCertPath = "nomad-cert.pem"
 	// NomadTLSKeyPath is the default path to the Nomad TLS key file.
 	NomadTLSKeyPath = "nomad-key.pem"
+	// ClusterKeyringPath is the path of the agent cluster gossip keyring file inside the data folder.
+	ClusterKeyringPath = "cluster-keyring.json"
+	// StackArchivesFolder is the folder inside the data folder the volumes of the removed Edge stacks are archived to.
+	StackArchivesFolder = "stack-archives"
+	// StackCacheFolder is the folder inside the data folder the filesystem cache of the Edge stack payloads is kept in
+	StackCacheFolder = "stack-cache"
 	// TLSCertPath is the default path to the TLS certificate file.
 	TLSCertPath = "cert.pem"
 	// TLSKeyPath is the default path to the TLS key file.
@@ -362,14 +549,16 @@ const (
 	HostRoot = "/host"
 	// DefaultDataPath is the default folder where the data associated to the agent is persisted.
 	DefaultDataPath = "/data"
-	// ScheduleScriptDirectory is the folder where schedules are saved on the host
-	ScheduleScriptDirectory = "/opt/portainer/scripts"
+	// DefaultScheduleScriptDirectory is the default folder where schedules are saved on the host
+	DefaultScheduleScriptDirectory = "/opt/portainer/scripts"
 	// EdgeKeyFile is the name of the file used to persist the Edge key associated to the agent.
 	EdgeKeyFile = "agent_edge_key"
+	// EdgeBootstrapStateFile is the name of the file keeping the settings of the provisioning file the agent enrolled with
+	EdgeBootstrapStateFile = "agent_bootstrap.json"
 	// DefaultAssetsPath is the default path of the binaries
 	DefaultAssetsPath = "/app"
-	// EdgeStackFilesPath is the path where edge stack files are saved
-	EdgeStackFilesPath = "/tmp/edge_stacks"
+	// DefaultEdgeStackFilesPath is the default path where edge stack files are saved
+	DefaultEdgeStackFilesPath = "/tmp/edge_stacks"
 	// EdgeStackQueueSleepIntervalSeconds is the interval in seconds used to check if there's an Edge stack to deploy
 	EdgeStackQueueSleepIntervalSeconds = 5
 	// KubernetesServiceHost is the environment variable name of the kubernetes API server host
@@ -380,6 +569,57 @@ const (
 	DefaultAWSClientCertPath = "/certs/aws-client.crt"
 	// DefaultAWSClientKeyPath is the default path to the AWS client key file
 	DefaultAWSClientKeyPath = "/certs/aws-client.key"
+	// DefaultEdgeNotifyMQTTTopic is the default MQTT topic Edge stack events are published to
+	DefaultEdgeNotifyMQTTTopic = "portainer/edge/stacks"
+	// DefaultEdgeStatusWebhookRateLimit is the default minimum interval between two status webhooks for the same stack and status
+	DefaultEdgeStatusWebhookRateLimit = "5m"
+	// DefaultEdgeStandbyLease is the default time after which the standby agent takes over when the active agent stops renewing its lease
+	DefaultEdgeStandbyLease = "15s"
+	// DefaultEdgeAttestation is the default attestation of the hardware of the device when it enrolls
+	DefaultEdgeAttestation = "off"
+	// DefaultEdgeAttestationTPMHandle is the default persistent handle of the attestation key of the TPM
+	DefaultEdgeAttestationTPMHandle = "0x81010002"
+	// DefaultEdgeStackHistoryCount is the default number of successfully deployed versions kept for each Edge stack
+	DefaultEdgeStackHistoryCount = "3"
+	// DefaultEdgeJobHistoryCount is the default number of executions kept for each Edge job
+	DefaultEdgeJobHistoryCount = "20"
+	// DefaultEdgeFileTransferMaxSize is the default maximum size of the files transferred from and to the host
+	DefaultEdgeFileTransferMaxSize = "10MB"
+	// DefaultEdgeHostInfoInterval is the default interval between two collections of the information of the host
+	DefaultEdgeHostInfoInterval = "1h"
+	// DefaultEdgeRequestRetries is the default maximum number of retries of a request to the Portainer server
+	DefaultEdgeRequestRetries = "2"
+	// DefaultEdgeCircuitCooldown is the default time the requests to an unreachable Portainer server fail fast
+	DefaultEdgeCircuitCooldown = "30s"
+	// DefaultEdgeUpgradeVerifyWindow is the default window the health checks of an updated agent must pass within
+	DefaultEdgeUpgradeVerifyWindow = "5m"
+	// DefaultEdgeJobUser is the default user the Edge jobs run as on the host
+	DefaultEdgeJobUser = "nobody"
+	// DefaultEdgeStackCache is the default backend of the cache of the Edge stack payloads
+	DefaultEdgeStackCache = "filesystem"
+	// DefaultEdgeStackCacheSize is the default maximum size of the filesystem cache of the Edge stack payloads
+	DefaultEdgeStackCacheSize = "512MB"
+	// DefaultEdgeSessionRecordSize is the default maximum size of the recorded session
+	DefaultEdgeSessionRecordSize = "64MB"
+	// DefaultEdgeCredentialStore is the default backend keeping the registry credentials of the Edge stacks
+	DefaultEdgeCredentialStore = "memory"
+	// DefaultEdgeStackOrphanPolicy is the default policy applied to the resources left behind by Edge stacks
+	DefaultEdgeStackOrphanPolicy = "none"
+	// DefaultEdgeSnapshotMode is the default mode of the snapshots sent in async mode, matching the profile
+	DefaultEdgeSnapshotMode = "auto"
+	// DefaultEdgeStackPullProgress is the default mode of the reports of the image pulls of the Edge stacks, matching
+	// the profile
+	DefaultEdgeStackPullProgress = "auto"
+	// DefaultEdgeStackLint is the default mode of the linter of the Edge stack files
+	DefaultEdgeStackLint = "off"
+	// DefaultEdgeStackNaming is the default strategy naming the projects of the Edge stacks
+	DefaultEdgeStackNaming = "prefix"
+	// DefaultEdgeStackPrefix is the default prefix of the projects of the Edge stacks
+	DefaultEdgeStackPrefix = "edge_"
+	// DefaultEdgeStackEnvPaths is the default host path the env files of the Edge stacks are read from
+	DefaultEdgeStackEnvPaths = "/etc/portainer"
+	// DefaultTLSMinVersion is the default minimum TLS version of the connections of the agent
+	DefaultTLSMinVersion = "1.2"
 	// DefaultUnpackerImage is the default name of unpacker image
 	DefaultUnpackerImage = "portainer/compose-unpacker:" + Version
 	// ComposeUnpackerImageEnvVar is the default environment variable name of the unpacker image
@@ -388,6 +628,21 @@ const (
 	ComposePathPrefix = "portainer-compose-unpacker"
 	// EdgeIdEnvVarName is the environment variable name of the edge ID for per device edge stack configurations
 	EdgeIdEnvVarName = "PORTAINER_EDGE_ID"
+	// EdgeStackIdEnvVarName is the environment variable name of the Edge stack ID, used by the credential helper to identify the stack pulling images
+	EdgeStackIdEnvVarName = "PORTAINER_EDGE_STACK_ID"
+	// RegistryServerAddrEnvVarName is the environment variable name of the address of the registry credential server,
+	// set by the agent for the credential helper when the server does not listen on DefaultRegistryServerAddr
+	RegistryServerAddrEnvVarName = "PORTAINER_REGISTRY_SERVER_ADDR"
+	// DefaultRegistryServerAddr is the default address of the registry credential server
+	DefaultRegistryServerAddr = "127.0.0.1:9005"
+)
+
+var (
+	// EdgeStackFilesPath is the path where edge stack files are saved, set from the options when the agent starts
+	EdgeStackFilesPath = DefaultEdgeStackFilesPath
+	// ScheduleScriptDirectory is the folder where schedules are saved on the host, set from the options when the
+	// agent starts
+	ScheduleScriptDirectory = DefaultScheduleScriptDirectory
 )
 
 const (
@@ -400,6 +655,8 @@ const (
 	PlatformPodman
 	// PlatformNomad represent the Nomad platform (Standalone)
 	PlatformNomad
+	// PlatformContainerd represent a containerd engine managed through nerdctl (Standalone)
+	PlatformContainerd
 )
 
 const (
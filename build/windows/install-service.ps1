@@ -0,0 +1,52 @@
+# Installs the agent as a Windows service running on the host, for the Windows IoT and Server hosts where the agent
+# cannot run in a container. The Docker engine is reached through its named pipe, or DOCKER_HOST when it is set.
+#
+# .\install-service.ps1 -EdgeKey <key> -EdgeId <id>
+
+param(
+    [Parameter(Mandatory = $true)][string]$EdgeKey,
+    [Parameter(Mandatory = $true)][string]$EdgeId,
+    [string]$InstallPath = "C:\Program Files\Portainer Agent",
+    [string]$DataPath = "C:\ProgramData\Portainer Agent",
+    [string]$DockerHost = "",
+    [string]$ServiceName = "portainer-agent"
+)
+
+$ErrorActionPreference = "Stop"
+
+New-Item -ItemType Directory -Force -Path $InstallPath, $DataPath | Out-Null
+
+Copy-Item -Force -Path "$PSScriptRoot\agent.exe", "$PSScriptRoot\docker.exe", "$PSScriptRoot\docker-compose.exe", "$PSScriptRoot\docker-credential-portainer.exe" -Destination $InstallPath
+Copy-Item -Force -Recurse -Path "$PSScriptRoot\static" -Destination $InstallPath
+
+if (Get-Service -Name $ServiceName -ErrorAction SilentlyContinue) {
+    Stop-Service -Name $ServiceName
+    sc.exe delete $ServiceName | Out-Null
+}
+
+New-Service -Name $ServiceName `
+    -DisplayName "Portainer Agent" `
+    -Description "Portainer Edge agent" `
+    -BinaryPathName "`"$InstallPath\agent.exe`"" `
+    -StartupType Automatic | Out-Null
+
+# The environment of the service is read by the agent the same way as the environment of its container
+$environment = @(
+    "EDGE=1",
+    "EDGE_KEY=$EdgeKey",
+    "EDGE_ID=$EdgeId",
+    "DATA_PATH=$DataPath",
+    "ASSETS_PATH=$InstallPath",
+    "PATH=$InstallPath;$env:PATH"
+)
+
+if ($DockerHost -ne "") {
+    $environment += "DOCKER_HOST=$DockerHost"
+}
+
+Set-ItemProperty -Path "HKLM:\SYSTEM\CurrentControlSet\Services\$ServiceName" -Name Environment -Type MultiString -Value $environment
+
+# The service is restarted when it fails, e.g. when the Docker engine is not started yet
+sc.exe failure $ServiceName reset= 86400 actions= restart/5000/restart/5000/restart/30000 | Out-Null
+
+Start-Service -Name $ServiceName
@@ -0,0 +1,177 @@
+// Package chaos injects failures at defined points of the deployment pipeline, to verify on real devices that the
+// retries, the rollbacks and the status reports behave as designed. It is only active in the agents built with the
+// chaos build tag, e.g. go build -tags chaos, and configured with the EDGE_CHAOS environment variable.
+package chaos
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/rs/zerolog/log"
+)
+
+// EnvKey is the environment variable configuring the failures, a comma-separated list of point=probability[:count]
+// entries, e.g. EDGE_CHAOS=pull_timeout=0.5,status_500=1:3. The failure is injected at most count times when set.
+const EnvKey = "EDGE_CHAOS"
+
+// Points the failures are injected at
+const (
+	// PullTimeout fails the pull of the images of a stack with a timeout
+	PullTimeout = "pull_timeout"
+	// DiskFull fails the persistence of the files of a stack with no space left on the device
+	DiskFull = "disk_full"
+	// Status500 answers the status updates sent to the Portainer server with an internal server error, without
+	// sending them
+	Status500 = "status_500"
+	// PartialWrite truncates one of the persisted files of a stack
+	PartialWrite = "partial_write"
+)
+
+var points = []string{PullTimeout, DiskFull, Status500, PartialWrite}
+
+// fault is the configuration of the failures injected at a point
+type fault struct {
+	probability float64
+	// remaining is the number of failures left to inject, unlimited when negative
+	remaining int
+}
+
+var (
+	faults map[string]*fault
+	mu     sync.Mutex
+	random = rand.Float64
+)
+
+// parseConfig parses the value of EnvKey
+func parseConfig(value string) (map[string]*fault, error) {
+	parsed := make(map[string]*fault)
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		point, setting, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid chaos entry %q, expected point=probability[:count]", entry)
+		}
+
+		known := false
+		for _, p := range points {
+			known = known || p == point
+		}
+
+		if !known {
+			return nil, fmt.Errorf("unknown chaos point %q, expected one of %s", point, strings.Join(points, ", "))
+		}
+
+		f := &fault{remaining: -1}
+
+		probability, count, limited := strings.Cut(setting, ":")
+
+		var err error
+		if f.probability, err = strconv.ParseFloat(probability, 64); err != nil || f.probability < 0 || f.probability > 1 {
+			return nil, fmt.Errorf("invalid probability %q of the chaos point %s, expected a number between 0 and 1", probability, point)
+		}
+
+		if limited {
+			if f.remaining, err = strconv.Atoi(count); err != nil || f.remaining < 0 {
+				return nil, fmt.Errorf("invalid count %q of the chaos point %s", count, point)
+			}
+		}
+
+		parsed[point] = f
+	}
+
+	return parsed, nil
+}
+
+// Enabled returns true when failures are injected
+func Enabled() bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return enabled && len(faults) > 0
+}
+
+// Fail returns true when a failure must be injected at the point
+func Fail(point string) bool {
+	if !enabled {
+		return false
+	}
+
+	return inject(point)
+}
+
+// inject decides whether a failure is injected at the point, counting it
+func inject(point string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	f, ok := faults[point]
+	if !ok || f.remaining == 0 || random() >= f.probability {
+		return false
+	}
+
+	if f.remaining > 0 {
+		f.remaining--
+	}
+
+	log.Warn().Str("point", point).Msg("chaos: injecting a failure")
+
+	return true
+}
+
+// PullError returns the error the pull of the images fails with, nil when no failure is injected
+func PullError() error {
+	if !Fail(PullTimeout) {
+		return nil
+	}
+
+	return fmt.Errorf("chaos: image pull: %w", context.DeadlineExceeded)
+}
+
+// WriteError returns the error the persistence of the files of the folder fails with, nil when no failure is
+// injected
+func WriteError(folder string) error {
+	if !Fail(DiskFull) {
+		return nil
+	}
+
+	return &fs.PathError{Op: "write", Path: folder, Err: syscall.ENOSPC}
+}
+
+// TruncateFile truncates the first file of the folder to half of its size when a failure is injected
+func TruncateFile(folder string) {
+	if !Fail(PartialWrite) {
+		return
+	}
+
+	_ = filepath.WalkDir(folder, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		if err := os.Truncate(path, info.Size()/2); err != nil {
+			return err
+		}
+
+		log.Warn().Str("path", path).Msg("chaos: file truncated")
+
+		return filepath.SkipAll
+	})
+}
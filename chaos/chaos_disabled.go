@@ -0,0 +1,6 @@
+//go:build !chaos
+
+package chaos
+
+// The failures are only injected by the chaos builds
+const enabled = false
@@ -0,0 +1,18 @@
+//go:build chaos
+
+package chaos
+
+import (
+	"os"
+
+	"github.com/rs/zerolog/log"
+)
+
+const enabled = true
+
+func init() {
+	var err error
+	if faults, err = parseConfig(os.Getenv(EnvKey)); err != nil {
+		log.Fatal().Err(err).Msg("invalid chaos configuration")
+	}
+}
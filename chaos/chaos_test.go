@@ -0,0 +1,51 @@
+package chaos
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConfig(t *testing.T) {
+	parsed, err := parseConfig(" pull_timeout=0.5, status_500=1:3 ,")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]*fault{
+		PullTimeout: {probability: 0.5, remaining: -1},
+		Status500:   {probability: 1, remaining: 3},
+	}, parsed)
+
+	parsed, err = parseConfig("")
+	require.NoError(t, err)
+	assert.Empty(t, parsed)
+
+	for _, value := range []string{"pull_timeout", "unknown=1", "disk_full=2", "disk_full=x", "disk_full=1:-1"} {
+		_, err := parseConfig(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestInject(t *testing.T) {
+	parsed, err := parseConfig("status_500=1:2,disk_full=0.5")
+	require.NoError(t, err)
+
+	faults = parsed
+	defer func() { faults = nil }()
+
+	previous := random
+	random = func() float64 { return 0.7 }
+	defer func() { random = previous }()
+
+	assert.True(t, inject(Status500))
+	assert.True(t, inject(Status500))
+	assert.False(t, inject(Status500), "the count is exhausted")
+
+	assert.False(t, inject(DiskFull), "above the probability")
+	assert.False(t, inject(PullTimeout), "not configured")
+
+	// The production builds never inject failures
+	if !enabled {
+		assert.False(t, Enabled())
+		assert.False(t, Fail(Status500))
+	}
+}
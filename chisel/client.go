@@ -2,10 +2,14 @@ package chisel
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"net"
+	"strings"
 	"sync"
 
 	"github.com/portainer/agent"
+	"github.com/portainer/agent/crypto"
 
 	chclient "github.com/jpillora/chisel/client"
 	"github.com/rs/zerolog/log"
@@ -44,6 +48,8 @@ func (client *Client) CreateTunnel(tunnelConfig agent.TunnelConfig) error {
 		Proxy:       tunnelConfig.Proxy,
 	}
 
+	applyTLSPolicy(config)
+
 	chiselClient, err := chclient.NewClient(config)
 	if err != nil {
 		return err
@@ -79,3 +85,32 @@ func (client *Client) IsTunnelOpen() bool {
 
 	return client.tunnelOpen
 }
+
+// applyTLSPolicy dials the tunnel servers reached over TLS with the TLS configuration of the Portainer server, which
+// enforces the TLS policy of the agent, instead of the one of chisel. Behind a proxy, chisel dials the proxy itself and
+// only the CA bundle of the Portainer server is enforced.
+func applyTLSPolicy(config *chclient.Config) {
+	server, ok := strings.CutPrefix(config.Server, "https://")
+	if !ok {
+		if server, ok = strings.CutPrefix(config.Server, "wss://"); !ok {
+			return
+		}
+	}
+
+	if config.Proxy != "" {
+		config.TLS.CA = crypto.ServerCAFile()
+
+		return
+	}
+
+	// chisel adds the port of plain HTTP to the addresses without port
+	host, path, _ := strings.Cut(server, "/")
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), "443")
+	}
+
+	dialer := &tls.Dialer{Config: crypto.ServerTLSConfiguration()}
+
+	config.Server = "http://" + host + "/" + path
+	config.DialContext = dialer.DialContext
+}
@@ -0,0 +1,53 @@
+package clock
+
+import (
+	"context"
+	"time"
+
+	"github.com/portainer/agent"
+)
+
+// SystemClock is a Clock backed by the standard library time functions
+type SystemClock struct{}
+
+var _ agent.Clock = &SystemClock{}
+
+// NewSystemClock returns a pointer to a new instance of SystemClock
+func NewSystemClock() *SystemClock {
+	return &SystemClock{}
+}
+
+// Now returns the current local time
+func (c *SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Sleep pauses the current goroutine for at least the duration d
+func (c *SystemClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+// After waits for the duration to elapse and then sends the current time on the returned channel
+func (c *SystemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// WithTimeout returns a copy of the parent context that is canceled once the duration d
+// has elapsed on the given clock
+func WithTimeout(parent context.Context, c agent.Clock, d time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := c.(*SystemClock); ok {
+		return context.WithTimeout(parent, d)
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+
+	go func() {
+		select {
+		case <-c.After(d):
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
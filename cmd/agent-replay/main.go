@@ -0,0 +1,95 @@
+// agent-replay replays offline a session recorded by an agent with EDGE_SESSION_RECORD, feeding the recorded polls,
+// stack payloads and outcomes of the deployer to the stack manager, and reports where the calls it issues diverge
+// from the recorded ones.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/portainer/agent"
+	"github.com/portainer/agent/edge/session"
+	"github.com/portainer/agent/edge/stack"
+
+	"github.com/rs/zerolog"
+)
+
+func main() {
+	engine := flag.String("engine", "docker", "engine the session was recorded on: docker, swarm, kubernetes, nomad or containerd")
+	actions := flag.Int("actions", stack.DefaultReplayActionsPerPoll, "number of times the queue of the stacks is served after each poll")
+	filesPath := flag.String("files", "", "folder the stack files are persisted in, a temporary folder when empty")
+	verbose := flag.Bool("verbose", false, "log the stack manager at the debug level")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <session file>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+	if *verbose {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	}
+
+	if err := run(flag.Arg(0), *engine, *actions, *filesPath); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(path, engine string, actions int, filesPath string) error {
+	entries, err := session.Read(path)
+	if err != nil {
+		return fmt.Errorf("unable to read the session: %w", err)
+	}
+
+	if filesPath == "" {
+		filesPath, err = os.MkdirTemp("", "agent-replay")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(filesPath)
+	}
+
+	agent.EdgeStackFilesPath = filesPath
+
+	replayed, err := stack.Replay(entries, engine, actions)
+	if err != nil {
+		return fmt.Errorf("unable to replay the session: %w", err)
+	}
+
+	recorded := session.Outgoing(entries)
+
+	i := session.Diverge(recorded, replayed)
+	if i < 0 {
+		fmt.Printf("the %d calls replayed match the recorded ones\n", len(replayed))
+
+		return nil
+	}
+
+	for _, entry := range replayed[:i] {
+		printEntry(" ", entry)
+	}
+
+	if i < len(recorded) {
+		printEntry("-", recorded[i])
+	}
+
+	if i < len(replayed) {
+		printEntry("+", replayed[i])
+	}
+
+	return fmt.Errorf("the replay diverges from the recorded session at the call %d", i+1)
+}
+
+func printEntry(prefix string, entry session.Entry) {
+	line, _ := json.Marshal(entry)
+
+	fmt.Printf("%s %s\n", prefix, line)
+}
@@ -2,33 +2,53 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"math/rand"
+	gonet "net"
 	gohttp "net/http"
 	goos "os"
 	"os/signal"
 	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/portainer/agent"
+	"github.com/portainer/agent/containerd"
 	"github.com/portainer/agent/crypto"
 	"github.com/portainer/agent/docker"
 	"github.com/portainer/agent/edge"
 	"github.com/portainer/agent/edge/aws"
+	"github.com/portainer/agent/edge/backup"
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/agent/edge/endpoints"
+	"github.com/portainer/agent/edge/freeze"
 	httpEdge "github.com/portainer/agent/edge/http"
+	"github.com/portainer/agent/edge/jobhistory"
+	"github.com/portainer/agent/edge/labels"
+	"github.com/portainer/agent/edge/notify"
+	"github.com/portainer/agent/edge/pause"
 	"github.com/portainer/agent/edge/registry"
+	"github.com/portainer/agent/edge/stack"
 	"github.com/portainer/agent/exec"
 	"github.com/portainer/agent/filesystem"
 	"github.com/portainer/agent/ghw"
 	"github.com/portainer/agent/healthcheck"
 	"github.com/portainer/agent/http"
+	"github.com/portainer/agent/http/proxy"
+	"github.com/portainer/agent/http/security"
 	"github.com/portainer/agent/internals/updates"
 	"github.com/portainer/agent/kubernetes"
 	"github.com/portainer/agent/net"
+	"github.com/portainer/agent/net/mdns"
 	"github.com/portainer/agent/os"
+	"github.com/portainer/agent/preflight"
+	"github.com/portainer/agent/profile"
 	cluster "github.com/portainer/agent/serf"
 
 	"github.com/rs/zerolog"
@@ -49,6 +69,8 @@ func main() {
 
 	rand.Seed(time.Now().UnixNano())
 
+	serviceStop := runAsService()
+
 	options, err := parseOptions()
 	if err != nil {
 		log.Fatal().Err(err).Msg("invalid agent configuration")
@@ -57,8 +79,137 @@ func main() {
 	setLoggingLevel(options.LogLevel)
 	setLoggingMode(options.LogMode)
 
-	if options.EdgeAsyncMode && !options.EdgeMode {
-		log.Fatal().Msg("edge Async mode cannot be enabled if Edge Mode is disabled")
+	fipsStatus, err := crypto.CheckFIPS(options.FIPSMode)
+	if err != nil {
+		log.Fatal().Err(err).Msg("unable to enable the FIPS mode")
+	}
+
+	if fipsStatus.Enabled {
+		log.Info().Str("backend", fipsStatus.Backend).Strs("non_approved", fipsStatus.NonApproved).Msg("FIPS mode enabled")
+	}
+
+	if err := crypto.SetTLSPolicy(options); err != nil {
+		log.Fatal().Err(err).Msg("invalid TLS configuration")
+	}
+
+	filesystem.SetDurableWrites(options.DurableWrites)
+	profile.Apply(options.EdgeProfile)
+
+	if err := setPaths(options); err != nil {
+		log.Fatal().Err(err).Msg("invalid agent paths")
+	}
+
+	if options.PrintConfig {
+		err := printConfig()
+		if err != nil {
+			log.Fatal().Err(err).Msg("unable to print the settings")
+		}
+		goos.Exit(0)
+	}
+
+	if len(options.EdgeSetLabels) > 0 {
+		err := setLabels(options.DataPath, options.EdgeSetLabels)
+		if err != nil {
+			log.Fatal().Err(err).Msg("unable to set the labels")
+		}
+		goos.Exit(0)
+	}
+
+	if options.EdgeJobHistory {
+		err := printJobHistory(options)
+		if err != nil {
+			log.Fatal().Err(err).Msg("unable to read the job history")
+		}
+		goos.Exit(0)
+	}
+
+	if options.EdgeStackChangelog {
+		err := printStackChangelog(options)
+		if err != nil {
+			log.Fatal().Err(err).Msg("unable to read the changelogs of the Edge stacks")
+		}
+		goos.Exit(0)
+	}
+
+	if options.EdgePause > 0 || options.EdgeResume {
+		err := setPause(options)
+		if err != nil {
+			log.Fatal().Err(err).Msg("unable to update the pause of the instructions")
+		}
+		goos.Exit(0)
+	}
+
+	if options.EdgeFreezeUntil != "" || options.EdgeUnfreeze {
+		err := setFreeze(options)
+		if err != nil {
+			log.Fatal().Err(err).Msg("unable to update the freeze windows")
+		}
+		goos.Exit(0)
+	}
+
+	if options.EdgeExportBackup != "" {
+		err := exportBackup(options)
+		if err != nil {
+			log.Fatal().Err(err).Msg("unable to export the backup")
+		}
+		goos.Exit(0)
+	}
+
+	if options.EdgeImportBackup != "" {
+		err := importBackup(options)
+		if err != nil {
+			log.Fatal().Err(err).Msg("unable to import the backup")
+		}
+		goos.Exit(0)
+	}
+
+	// The endpoints are started before the Docker engine of the agent is set so that they do not inherit it
+	if options.EdgeEndpointsFile != "" {
+		if err := startEndpoints(options); err != nil {
+			log.Fatal().Err(err).Msg("unable to start the additional endpoints")
+		}
+	}
+
+	if err := setDockerEndpoint(options); err != nil {
+		log.Fatal().Err(err).Msg("unable to use the Docker engine")
+	}
+
+	// The provisioning file sets the Edge ID checked by the preflight checks
+	claimPending := false
+	if options.EdgeMode {
+		err := edge.Bootstrap(options)
+		if errors.Is(err, client.ErrEnrollmentPending) {
+			claimPending = true
+
+			log.Info().Str("edge_id", options.EdgeID).Msg("the claim of the device is pending approval on the server, the agent waits for it")
+		} else if err != nil {
+			log.Error().Err(err).Msg("unable to enroll the agent with the provisioning file")
+		}
+	}
+
+	containerPlatform := os.DetermineContainerPlatform()
+
+	report := preflight.Run(preflight.Config{
+		Options:    options,
+		Platform:   containerPlatform,
+		PingEngine: docker.Ping,
+	})
+
+	if options.Preflight {
+		err := printPreflight(report)
+		if err != nil {
+			log.Fatal().Err(err).Msg("unable to print the preflight report")
+		}
+
+		if report.Fatal() {
+			goos.Exit(1)
+		}
+		goos.Exit(0)
+	}
+
+	report.Log()
+	if report.Fatal() {
+		log.Fatal().Msg("the configuration of the agent prevents it from starting, see the preflight checks above")
 	}
 
 	if options.SSLCert != "" && options.SSLKey != "" && options.CertRetryInterval > 0 {
@@ -66,7 +217,6 @@ func main() {
 	}
 
 	systemService := ghw.NewSystemService(agent.HostRoot)
-	containerPlatform := os.DetermineContainerPlatform()
 	runtimeConfiguration := &agent.RuntimeConfiguration{
 		AgentPort: options.AgentServerPort,
 	}
@@ -78,7 +228,10 @@ func main() {
 	var nomadConfig agent.NomadConfig
 
 	var updaterCleaner updates.GhostUpdaterCleaner
-	ctx := context.Background()
+	var upgradeCanary updates.Canary
+	// ctx is done once the agent shuts down
+	ctx, shutdown := context.WithCancel(context.Background())
+	defer shutdown()
 	// !Generic
 
 	// Docker & Podman
@@ -116,11 +269,20 @@ func main() {
 		}
 
 		if containerPlatform == agent.PlatformDocker && options.EdgeMetaFields.UpdateID != 0 {
-			updaterCleaner = updates.NewDockerUpdaterCleaner(ctx, options.EdgeMetaFields.UpdateID)
+			if options.EdgeMode && options.EdgeUpgradeVerifyWindow > 0 {
+				// The previous agent is kept until the updated agent is verified, to roll back to it
+				canary := updates.NewDockerCanary(options.EdgeMetaFields.UpdateID)
+				updaterCleaner, upgradeCanary = canary, canary
+			} else {
+				updaterCleaner = updates.NewDockerUpdaterCleaner(ctx, options.EdgeMetaFields.UpdateID)
+			}
 		}
 
 		if containerPlatform == agent.PlatformDocker && clusterMode {
-			clusterService = cluster.NewClusterService(runtimeConfiguration)
+			clusterService = cluster.NewClusterService(runtimeConfiguration, cluster.EncryptionConfig{
+				Key:         options.ClusterKey,
+				KeyringFile: path.Join(options.DataPath, agent.ClusterKeyringPath),
+			})
 
 			clusterAddr := options.ClusterAddress
 			if clusterAddr == "" {
@@ -173,42 +335,50 @@ func main() {
 
 		kubernetesDeployer = exec.NewKubernetesDeployer(options.AssetsPath)
 
-		clusterService = cluster.NewClusterService(runtimeConfiguration)
-
-		advertiseAddr = os.GetKubernetesPodIP()
-		if advertiseAddr == "" {
-			log.Fatal().Err(err).Msg("KUBERNETES_POD_IP env var must be specified when running on Kubernetes")
-		}
+		if goos.Getenv(kubernetes.KubeconfigEnvVarName) != "" {
+			// Outside of the cluster, e.g. as an endpoint of the multi-endpoint mode, the agent runs alone
+			advertiseAddr = "127.0.0.1"
+		} else {
+			clusterService = cluster.NewClusterService(runtimeConfiguration, cluster.EncryptionConfig{
+				Key:         options.ClusterKey,
+				KeyringFile: path.Join(options.DataPath, agent.ClusterKeyringPath),
+			})
+
+			advertiseAddr = os.GetKubernetesPodIP()
+			if advertiseAddr == "" {
+				log.Fatal().Err(err).Msg("KUBERNETES_POD_IP env var must be specified when running on Kubernetes")
+			}
 
-		clusterAddr := options.ClusterAddress
-		if clusterAddr == "" {
-			clusterAddr = "s-portainer-agent-headless"
-		}
+			clusterAddr := options.ClusterAddress
+			if clusterAddr == "" {
+				clusterAddr = "s-portainer-agent-headless"
+			}
 
-		// TODO: Workaround. Kubernetes only adds entries in the DNS for running containers. We need to wait a bit
-		// for the container to be considered running by Kubernetes and an entry to be added to the DNS.
-		time.Sleep(3 * time.Second)
+			// TODO: Workaround. Kubernetes only adds entries in the DNS for running containers. We need to wait a bit
+			// for the container to be considered running by Kubernetes and an entry to be added to the DNS.
+			time.Sleep(3 * time.Second)
 
-		joinAddr, err := net.LookupIPAddresses(clusterAddr)
-		if err != nil {
-			log.Fatal().Str("host", clusterAddr).Err(err).
-				Msg("unable to retrieve a list of IP associated to the host")
-		}
+			joinAddr, err := net.LookupIPAddresses(clusterAddr)
+			if err != nil {
+				log.Fatal().Str("host", clusterAddr).Err(err).
+					Msg("unable to retrieve a list of IP associated to the host")
+			}
 
-		err = clusterService.Create(advertiseAddr, joinAddr, options.ClusterProbeTimeout, options.ClusterProbeInterval)
-		if err != nil {
-			log.Fatal().Err(err).Msg("unable to create cluster")
-		}
+			err = clusterService.Create(advertiseAddr, joinAddr, options.ClusterProbeTimeout, options.ClusterProbeInterval)
+			if err != nil {
+				log.Fatal().Err(err).Msg("unable to create cluster")
+			}
 
-		log.Debug().
-			Str("agent_port", options.AgentServerPort).
-			Str("cluster_address", clusterAddr).
-			Str("advertise_address", advertiseAddr).
-			Str("probe_timeout", options.ClusterProbeTimeout.String()).
-			Str("probe_interval", options.ClusterProbeInterval.String()).
-			Msg("")
+			log.Debug().
+				Str("agent_port", options.AgentServerPort).
+				Str("cluster_address", clusterAddr).
+				Str("advertise_address", advertiseAddr).
+				Str("probe_timeout", options.ClusterProbeTimeout.String()).
+				Str("probe_interval", options.ClusterProbeInterval.String()).
+				Msg("")
 
-		defer clusterService.Leave()
+			defer clusterService.Leave()
+		}
 	}
 	// !Kubernetes
 
@@ -296,6 +466,21 @@ func main() {
 	}
 	// !Nomad
 
+	// containerd
+	if containerPlatform == agent.PlatformContainerd {
+		log.Info().Msg("agent running on containerd")
+
+		if _, err := containerd.Command(options.AssetsPath); err != nil {
+			log.Fatal().Err(err).Msg("unable to manage the containerd engine")
+		}
+
+		advertiseAddr, err = net.GetLocalIP()
+		if err != nil {
+			log.Fatal().Err(err).Msg("unable to retrieve local IP associated to the agent")
+		}
+	}
+	// !containerd
+
 	// Clean the updater
 	if updaterCleaner != nil {
 		go updates.Remove(ctx, updaterCleaner)
@@ -314,6 +499,18 @@ func main() {
 		}
 	}
 
+	clusterAuth, err := newClusterAuth(options)
+	if err != nil {
+		log.Fatal().Err(err).Msg("unable to configure the authentication between agents")
+	}
+
+	// The requests sent by the other agents are verified even when stripped of their cluster headers
+	if clusterAuth != nil && clusterService != nil {
+		clusterAuth.SetMembers(clusterService.Members)
+	}
+
+	proxy.SetClusterAuth(clusterAuth)
+
 	// !Security
 
 	if options.HealthCheck {
@@ -328,6 +525,7 @@ func main() {
 	var edgeManager *edge.Manager
 	if options.EdgeMode {
 		edgeManagerParameters := &edge.ManagerParameters{
+			Context:           ctx,
 			Options:           options,
 			AdvertiseAddr:     advertiseAddr,
 			ClusterService:    clusterService,
@@ -337,6 +535,19 @@ func main() {
 
 		edgeManager = edge.NewManager(edgeManagerParameters)
 
+		notifyConfig := notify.Config{
+			MQTTAddr:   options.EdgeNotifyMQTTAddr,
+			MQTTTopic:  options.EdgeNotifyMQTTTopic,
+			WebhookURL: options.EdgeNotifyWebhookURL,
+			ExecPath:   options.EdgeNotifyExec,
+		}
+
+		var notifier *notify.Notifier
+		if notifyConfig.Enabled() {
+			notifier = notify.NewNotifier(notifyConfig)
+			notifier.Start()
+		}
+
 		edgeKey, err := edge.RetrieveEdgeKey(options.EdgeKey, clusterService, options.DataPath)
 		if err != nil {
 			log.Error().Err(err).Msg("unable to retrieve Edge key")
@@ -354,9 +565,26 @@ func main() {
 			if err != nil {
 				log.Fatal().Err(err).Msg("Unable to start Edge manager")
 			}
+
+			if notifier != nil {
+				notifier.Watch(ctx, edgeManager.StackStatus())
+			}
+
+			if upgradeCanary != nil {
+				go updates.VerifyUpgrade(ctx, upgradeCanary, edgeManager.UpgradeChecks(), options.EdgeUpgradeVerifyWindow)
+			}
 		} else {
 			log.Debug().Msg("edge key not specified. Serving Edge UI")
 			serveEdgeUI(edgeManager, options.EdgeUIServerAddr, options.EdgeUIServerPort)
+
+			if claimPending {
+				go edgeManager.WaitForClaim(ctx)
+			}
+
+			// The agent cannot poll Portainer until it is associated, the previous agent is removed without verification
+			if upgradeCanary != nil {
+				go upgradeCanary.Promote(ctx)
+			}
 		}
 	}
 
@@ -371,6 +599,7 @@ func main() {
 		ClusterService:       clusterService,
 		EdgeManager:          edgeManager,
 		SignatureService:     signatureService,
+		ClusterAuth:          clusterAuth,
 		RuntimeConfiguration: runtimeConfiguration,
 		AgentOptions:         options,
 		KubeClient:           kubeClient,
@@ -389,6 +618,13 @@ func main() {
 		log.Fatal().Err(err).Msg("unable to start registry server")
 	}
 
+	if options.MDNS {
+		responder := advertiseMDNS(options, runtimeConfiguration.NodeName, advertiseAddr)
+		if responder != nil {
+			defer responder.Stop()
+		}
+	}
+
 	err = startAPIServer(config, options.EdgeMode)
 	if err != nil && !errors.Is(err, gohttp.ErrServerClosed) {
 		log.Fatal().Err(err).Msg("unable to start Agent API server")
@@ -398,9 +634,21 @@ func main() {
 
 	sigs := make(chan goos.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-	s := <-sigs
+
+	var s goos.Signal
+	select {
+	case s = <-sigs:
+	case s = <-serviceStop:
+	}
 
 	log.Debug().Stringer("signal", s).Msg("shutting down")
+
+	// The standby agent takes over once the lease of the active agent is released
+	shutdown()
+
+	if edgeManager != nil {
+		edgeManager.Wait()
+	}
 }
 
 func startAPIServer(config *http.APIServerConfig, edgeMode bool) error {
@@ -414,6 +662,308 @@ func parseOptions() (*agent.Options, error) {
 	return optionParser.Options()
 }
 
+func newClusterAuth(options *agent.Options) (*security.ClusterAuth, error) {
+	config := security.ClusterAuthConfig{
+		Secret: options.SharedSecret,
+	}
+
+	if config.Secret == "" {
+		config.Secret = options.ClusterKey
+	}
+
+	// The Edge agents serve their API over plain HTTP
+	if !options.EdgeMode {
+		config.CACertPath = options.ClusterMTLSCACert
+		config.CertPath = options.ClusterMTLSCert
+		config.KeyPath = options.ClusterMTLSKey
+	}
+
+	return security.NewClusterAuth(config)
+}
+
+func advertiseMDNS(options *agent.Options, nodeName, advertiseAddr string) *mdns.Responder {
+	port, err := strconv.Atoi(options.AgentServerPort)
+	if err != nil {
+		log.Error().Err(err).Msg("unable to advertise the agent over mDNS")
+
+		return nil
+	}
+
+	txt := []string{
+		"name=" + nodeName,
+		"version=" + agent.Version,
+		"api_version=" + agent.APIVersion,
+	}
+
+	if options.EdgeID != "" {
+		txt = append(txt, "edge_id="+options.EdgeID)
+	}
+
+	responder, err := mdns.NewResponder(mdns.Service{
+		Instance: nodeName,
+		Host:     nodeName,
+		IP:       gonet.ParseIP(advertiseAddr),
+		Port:     port,
+		TXT:      txt,
+	})
+	if err == nil {
+		err = responder.Start()
+	}
+
+	if err != nil {
+		log.Warn().Err(err).Msg("unable to advertise the agent over mDNS")
+
+		return nil
+	}
+
+	return responder
+}
+
+func setLabels(dataPath string, values []string) error {
+	updates := make(map[string]string, len(values))
+	for _, value := range values {
+		key, value, err := labels.ParseLabel(value)
+		if err != nil {
+			return err
+		}
+
+		updates[key] = value
+	}
+
+	return labels.UpdateOverrides(dataPath, updates)
+}
+
+func setPause(options *agent.Options) error {
+	if options.EdgeResume {
+		return pause.Clear(options.DataPath)
+	}
+
+	return pause.Set(options.DataPath, options.EdgePause, options.EdgePauseReason)
+}
+
+// printJobHistory prints the executions of the Edge jobs kept in the data folder
+func printJobHistory(options *agent.Options) error {
+	executions, err := jobhistory.List(options.DataPath, options.EdgeJobHistoryID)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(goos.Stdout)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(executions)
+}
+
+// printStackChangelog prints the history of the versions of the Edge stacks kept in the data folder
+func printStackChangelog(options *agent.Options) error {
+	changelogs, err := stack.ReadChangelogs(options.DataPath)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(goos.Stdout)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(changelogs)
+}
+
+// printConfig prints the effective settings of the agent, the settings sent by the server are only known by the
+// running agent, see the /host/config endpoint
+func printConfig() error {
+	encoder := json.NewEncoder(goos.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+
+	return encoder.Encode(os.EffectiveSettings())
+}
+
+func printPreflight(report *preflight.Report) error {
+	encoder := json.NewEncoder(goos.Stdout)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(report)
+}
+
+// startEndpoints runs an agent process for each additional endpoint of the endpoints file
+func startEndpoints(options *agent.Options) error {
+	list, err := endpoints.Load(options.EdgeEndpointsFile)
+	if err != nil {
+		return err
+	}
+
+	supervisor, err := endpoints.NewSupervisor(options.DataPath, options.AgentServerPort, list)
+	if err != nil {
+		return err
+	}
+
+	log.Info().Int("endpoints", len(list)).Msg("starting the additional endpoints")
+
+	return supervisor.Start()
+}
+
+// setDockerEndpoint points the agent to the Docker engine of the docker context or host when it does not
+// manage the local engine, e.g. an agent running on a site gateway for a device that cannot run it
+func setDockerEndpoint(options *agent.Options) error {
+	endpoint := docker.Endpoint{Host: options.DockerHost}
+
+	if options.DockerContext != "" && options.DockerContext != "default" {
+		var err error
+
+		endpoint, err = docker.ResolveContext(options.AssetsPath, options.DockerContext)
+		if err != nil {
+			return err
+		}
+
+		// The docker binaries run by the agent use the resolved host
+		goos.Unsetenv(os.EnvKeyDockerContext)
+	}
+
+	if err := docker.UseEndpoint(endpoint); err != nil {
+		return err
+	}
+
+	if docker.IsRemote() {
+		log.Info().Str("host", endpoint.Host).Msg("managing a remote Docker engine")
+		log.Warn().Msg("the relative path stacks and the host management features act on the host of the agent, not on the host of the Docker engine")
+	}
+
+	return nil
+}
+
+func setFreeze(options *agent.Options) error {
+	if options.EdgeUnfreeze {
+		return freeze.Remove(options.DataPath, options.EdgeFreezeStack)
+	}
+
+	until, err := freeze.ParseUntil(options.EdgeFreezeUntil, time.Local)
+	if err != nil {
+		return err
+	}
+
+	return freeze.Add(options.DataPath, freeze.Window{
+		StackID: options.EdgeFreezeStack,
+		Until:   until,
+		Reason:  options.EdgeFreezeReason,
+	})
+}
+
+// exportBackup writes the Edge stacks retained on the device and the settings of the agent to the backup archive
+func exportBackup(options *agent.Options) error {
+	f, err := goos.OpenFile(options.EdgeExportBackup, goos.O_WRONLY|goos.O_CREATE|goos.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	manifest, err := backup.Write(f, backup.Source{
+		DataPath:   options.DataPath,
+		StacksPath: agent.EdgeStackFilesPath,
+		EdgeID:     options.EdgeID,
+		Settings:   os.Settings(),
+	}, time.Now())
+	if err != nil {
+		return err
+	}
+
+	log.Info().Str("path", options.EdgeExportBackup).Int("stacks", len(manifest.Stacks)).Msg("backup exported")
+
+	return f.Close()
+}
+
+// importBackup restores the backup archive exported from another device and prints the settings of its agent
+// in the env file format, the redacted secrets have to be set again
+func importBackup(options *agent.Options) error {
+	f, err := goos.Open(options.EdgeImportBackup)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	manifest, err := backup.Read(f, options.DataPath, agent.EdgeStackFilesPath)
+	if err != nil {
+		return err
+	}
+
+	log.Info().Str("edge_id", manifest.EdgeID).Int("stacks", len(manifest.Stacks)).Msg("backup imported")
+
+	keys := make([]string, 0, len(manifest.Settings))
+	for key := range manifest.Settings {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		fmt.Printf("%s=%s\n", key, manifest.Settings[key])
+	}
+
+	return nil
+}
+
+// setPaths validates the paths of the agent, creates its writable folders and points the agent and the processes it
+// runs to them. The data and Edge stack folders are only required in Edge mode.
+func setPaths(options *agent.Options) error {
+	paths := []struct{ name, path string }{
+		{"assets", options.AssetsPath},
+		{"data", options.DataPath},
+		{"Edge stack files", options.EdgeStackFilesPath},
+		{"Edge job scripts", options.EdgeJobScriptsPath},
+		{"temporary files", options.TmpPath},
+	}
+
+	for _, p := range paths {
+		if p.path != "" && !filepath.IsAbs(p.path) && !path.IsAbs(filepath.ToSlash(p.path)) {
+			return fmt.Errorf("the %s path %q must be absolute", p.name, p.path)
+		}
+	}
+
+	writable := []string{options.TmpPath}
+	if options.EdgeMode {
+		writable = append(writable, options.DataPath, options.EdgeStackFilesPath)
+	}
+
+	for _, folder := range writable {
+		if folder == "" {
+			continue
+		}
+
+		if err := createWritableFolder(folder); err != nil {
+			return err
+		}
+	}
+
+	agent.EdgeStackFilesPath = options.EdgeStackFilesPath
+	agent.ScheduleScriptDirectory = options.EdgeJobScriptsPath
+
+	// The temporary files of the agent and of the docker and compose binaries it runs are written there
+	if options.TmpPath != "" {
+		for _, name := range []string{"TMPDIR", "TMP", "TEMP"} {
+			if err := goos.Setenv(name, options.TmpPath); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// createWritableFolder creates the folder only readable by the agent when it does not exist, then checks that the
+// agent can write to it
+func createWritableFolder(folder string) error {
+	if err := goos.MkdirAll(folder, 0700); err != nil {
+		return fmt.Errorf("unable to create the folder %s: %w", folder, err)
+	}
+
+	f, err := goos.CreateTemp(folder, ".write-check-*")
+	if err != nil {
+		return fmt.Errorf("the folder %s is not writable: %w", folder, err)
+	}
+
+	f.Close()
+
+	return goos.Remove(f.Name())
+}
+
 func setLoggingLevel(level string) {
 	switch level {
 	case "ERROR":
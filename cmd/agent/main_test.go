@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/portainer/agent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setTestPaths keeps the paths of the agent and the temporary folder variables set by setPaths to the test
+func setTestPaths(t *testing.T) {
+	edgeStackFilesPath, scheduleScriptDirectory := agent.EdgeStackFilesPath, agent.ScheduleScriptDirectory
+	t.Cleanup(func() {
+		agent.EdgeStackFilesPath, agent.ScheduleScriptDirectory = edgeStackFilesPath, scheduleScriptDirectory
+	})
+
+	for _, name := range []string{"TMPDIR", "TMP", "TEMP"} {
+		t.Setenv(name, os.Getenv(name))
+	}
+}
+
+func TestSetPaths(t *testing.T) {
+	setTestPaths(t)
+
+	root := t.TempDir()
+
+	options := &agent.Options{
+		EdgeMode:           true,
+		AssetsPath:         "/app",
+		DataPath:           filepath.Join(root, "data"),
+		EdgeStackFilesPath: filepath.Join(root, "stacks"),
+		EdgeJobScriptsPath: "/opt/scripts",
+		TmpPath:            filepath.Join(root, "tmp"),
+	}
+
+	require.NoError(t, setPaths(options))
+
+	for _, folder := range []string{options.DataPath, options.EdgeStackFilesPath, options.TmpPath} {
+		info, err := os.Stat(folder)
+		require.NoError(t, err)
+		assert.True(t, info.IsDir())
+
+		// The write check leaves nothing behind
+		entries, err := os.ReadDir(folder)
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+	}
+
+	// The job scripts are written on the host, not in the filesystem of the agent
+	assert.NoDirExists(t, options.EdgeJobScriptsPath)
+
+	assert.Equal(t, options.EdgeStackFilesPath, agent.EdgeStackFilesPath)
+	assert.Equal(t, options.EdgeJobScriptsPath, agent.ScheduleScriptDirectory)
+
+	for _, name := range []string{"TMPDIR", "TMP", "TEMP"} {
+		assert.Equal(t, options.TmpPath, os.Getenv(name))
+	}
+}
+
+func TestSetPaths_standard(t *testing.T) {
+	setTestPaths(t)
+
+	root := t.TempDir()
+
+	options := &agent.Options{
+		DataPath:           filepath.Join(root, "data"),
+		EdgeStackFilesPath: filepath.Join(root, "stacks"),
+	}
+
+	require.NoError(t, setPaths(options))
+
+	// The Edge folders are only created in Edge mode
+	assert.NoDirExists(t, options.DataPath)
+	assert.NoDirExists(t, options.EdgeStackFilesPath)
+}
+
+func TestSetPaths_invalid(t *testing.T) {
+	setTestPaths(t)
+
+	root := t.TempDir()
+
+	file := filepath.Join(root, "file")
+	require.NoError(t, os.WriteFile(file, nil, 0600))
+
+	tests := []struct {
+		name    string
+		options agent.Options
+		err     string
+	}{
+		{
+			name:    "relative path",
+			options: agent.Options{EdgeStackFilesPath: "edge_stacks"},
+			err:     `the Edge stack files path "edge_stacks" must be absolute`,
+		},
+		{
+			name:    "relative temporary path",
+			options: agent.Options{TmpPath: "tmp"},
+			err:     `the temporary files path "tmp" must be absolute`,
+		},
+		{
+			name:    "folder under a file",
+			options: agent.Options{EdgeMode: true, DataPath: filepath.Join(file, "data")},
+			err:     "unable to create the folder",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.ErrorContains(t, setPaths(&tt.options), tt.err)
+		})
+	}
+}
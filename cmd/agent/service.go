@@ -0,0 +1,11 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import goos "os"
+
+// runAsService returns nil, the agent only runs as a service on Windows hosts
+func runAsService() <-chan goos.Signal {
+	return nil
+}
@@ -0,0 +1,63 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	goos "os"
+	"syscall"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sys/windows/svc"
+)
+
+// serviceName is the name of the Windows service created by build/windows/install-service.ps1
+const serviceName = "portainer-agent"
+
+type serviceHandler struct {
+	stop chan goos.Signal
+}
+
+// runAsService reports the agent running to the service control manager when it is started as a Windows service, and
+// returns the channel receiving the stop requests of the service. It returns nil when the agent runs in a console or
+// in a container.
+func runAsService() <-chan goos.Signal {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		log.Fatal().Err(err).Msg("unable to determine if the agent runs as a Windows service")
+	}
+
+	if !isService {
+		return nil
+	}
+
+	handler := &serviceHandler{stop: make(chan goos.Signal, 1)}
+
+	go func() {
+		if err := svc.Run(serviceName, handler); err != nil {
+			log.Fatal().Err(err).Msg("unable to run the agent as a Windows service")
+		}
+	}()
+
+	return handler.stop
+}
+
+// Execute implements svc.Handler, the agent shuts down on the stop and the shutdown requests of the service
+func (handler *serviceHandler) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for request := range requests {
+		switch request.Cmd {
+		case svc.Interrogate:
+			status <- request.CurrentStatus
+		case svc.Stop, svc.Shutdown:
+			status <- svc.Status{State: svc.StopPending}
+			handler.stop <- syscall.SIGTERM
+
+			return false, 0
+		}
+	}
+
+	return false, 0
+}
@@ -0,0 +1,36 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	goos "os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/windows/svc"
+)
+
+func TestServiceHandler_Execute(t *testing.T) {
+	handler := &serviceHandler{stop: make(chan goos.Signal, 1)}
+
+	requests := make(chan svc.ChangeRequest, 2)
+	status := make(chan svc.Status, 4)
+
+	requests <- svc.ChangeRequest{Cmd: svc.Interrogate, CurrentStatus: svc.Status{State: svc.Running}}
+	requests <- svc.ChangeRequest{Cmd: svc.Stop}
+
+	_, exitCode := handler.Execute(nil, requests, status)
+	assert.Equal(t, uint32(0), exitCode)
+
+	close(status)
+
+	var states []svc.State
+	for s := range status {
+		states = append(states, s.State)
+	}
+
+	assert.Equal(t, []svc.State{svc.StartPending, svc.Running, svc.Running, svc.StopPending}, states)
+	assert.Equal(t, syscall.SIGTERM, <-handler.stop)
+}
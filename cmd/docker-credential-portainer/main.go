@@ -3,12 +3,13 @@ package main
 import (
 	"log"
 	"os"
+	"path/filepath"
 
 	credentials "github.com/docker/docker-credential-helpers/credentials"
 )
 
 func main() {
-	f, err := os.OpenFile("/tmp/portainer-credential-helper.log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	f, err := os.OpenFile(filepath.Join(os.TempDir(), "portainer-credential-helper.log"), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
 	if err != nil {
 		log.Fatalf("error opening file: %v", err)
 	}
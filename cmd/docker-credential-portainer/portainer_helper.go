@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
 
 	credentials "github.com/docker/docker-credential-helpers/credentials"
 )
@@ -21,7 +23,7 @@ func (h *portainerHelper) Delete(serverURL string) error {
 }
 
 func (h *portainerHelper) Get(serverURL string) (string, string, error) {
-	f, err := os.OpenFile("/tmp/portainer-credential-helper.log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	f, err := os.OpenFile(filepath.Join(os.TempDir(), "portainer-credential-helper.log"), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
 	if err != nil {
 		log.Fatalf("error opening file: %v", err)
 	}
@@ -34,7 +36,21 @@ func (h *portainerHelper) Get(serverURL string) (string, string, error) {
 
 	log.Printf("GET ServerURL=%s", serverURL)
 
-	resp, err := http.Get("http://localhost:9005/lookup?serverurl=" + serverURL)
+	query := url.Values{"serverurl": {serverURL}}
+
+	// The agent sets the identifier of the stack pulling the images in the environment of the
+	// deployer, it is inherited by the helper and used to only return that stack's credentials
+	if stackID := os.Getenv("PORTAINER_EDGE_STACK_ID"); stackID != "" {
+		query.Set("stackid", stackID)
+	}
+
+	// The agents of the multi-endpoint mode each serve the credentials of their stacks on their own address
+	addr := os.Getenv("PORTAINER_REGISTRY_SERVER_ADDR")
+	if addr == "" {
+		addr = "localhost:9005"
+	}
+
+	resp, err := http.Get("http://" + addr + "/lookup?" + query.Encode())
 	if err != nil {
 		log.Printf("Error getting credentials: %v", err)
 		return "", "", credentials.NewErrCredentialsNotFound()
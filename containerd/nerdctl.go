@@ -0,0 +1,166 @@
+package containerd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/portainer/agent"
+	agentos "github.com/portainer/agent/os"
+)
+
+// NamespaceEnvVarName is the environment variable of the containerd namespace the stacks are deployed in,
+// read by nerdctl. The default namespace is used when it is not set.
+const NamespaceEnvVarName = "CONTAINERD_NAMESPACE"
+
+// Command returns the path of the nerdctl binary, from the assets path or from the PATH of the host
+func Command(binaryPath string) (string, error) {
+	command := filepath.Join(binaryPath, "nerdctl")
+	if _, err := os.Stat(command); err == nil {
+		return command, nil
+	}
+
+	command, err := exec.LookPath("nerdctl")
+	if err != nil {
+		return "", fmt.Errorf("unable to find the nerdctl binary: %w", err)
+	}
+
+	return command, nil
+}
+
+// runNerdctl runs nerdctl and returns its output, the binary is looked up in the assets path of the agent
+var runNerdctl = func(args ...string) ([]byte, error) {
+	assetsPath := os.Getenv(agentos.EnvKeyAssetsPath)
+	if assetsPath == "" {
+		assetsPath = agent.DefaultAssetsPath
+	}
+
+	command, err := Command(assetsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(command, args...)
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return output, nil
+}
+
+// Container is a container as listed by nerdctl ps
+type Container struct {
+	ID        string
+	Names     string
+	Image     string
+	Command   string
+	Status    string
+	CreatedAt string
+	Labels    map[string]string `json:"-"`
+	RawLabels string            `json:"Labels"`
+	Namespace string            `json:"-"`
+}
+
+// State returns the state of the container in the Docker format: running, exited, created, paused or restarting
+func (container Container) State() string {
+	status := strings.ToLower(container.Status)
+
+	for _, state := range []string{"exited", "created", "paused", "restarting"} {
+		if strings.HasPrefix(status, state) {
+			return state
+		}
+	}
+
+	if strings.HasPrefix(status, "up") {
+		return "running"
+	}
+
+	return status
+}
+
+// ExitCode returns the exit code of an exited container, read from its status, e.g. Exited (1) 2 minutes ago
+func (container Container) ExitCode() int {
+	var code int
+	if _, err := fmt.Sscanf(strings.ToLower(container.Status), "exited (%d)", &code); err != nil {
+		return 0
+	}
+
+	return code
+}
+
+// ListContainers returns the containers of the namespace, all of them when label is empty,
+// the ones with the label otherwise, e.g. com.docker.compose.project=edge_app
+func ListContainers(namespace, label string) ([]Container, error) {
+	args := []string{"ps", "--all", "--no-trunc", "--format", "{{json .}}"}
+	if namespace != "" {
+		args = append([]string{"--namespace", namespace}, args...)
+	}
+
+	if label != "" {
+		args = append(args, "--filter", "label="+label)
+	}
+
+	output, err := runNerdctl(args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var containers []Container
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	scanner.Buffer(nil, 1024*1024)
+
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var container Container
+		if err := json.Unmarshal(line, &container); err != nil {
+			return nil, fmt.Errorf("invalid nerdctl ps output: %w", err)
+		}
+
+		container.Labels = parseLabels(container.RawLabels)
+		container.Namespace = namespace
+
+		containers = append(containers, container)
+	}
+
+	return containers, scanner.Err()
+}
+
+// Namespaces returns the containerd namespaces
+func Namespaces() ([]string, error) {
+	output, err := runNerdctl("namespace", "ls", "--quiet")
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.Fields(string(output)), nil
+}
+
+// parseLabels parses the labels of a container listed by nerdctl, in the key=value,key=value format
+func parseLabels(raw string) map[string]string {
+	labels := make(map[string]string)
+
+	for _, label := range strings.Split(raw, ",") {
+		key, value, found := strings.Cut(label, "=")
+		if !found || key == "" {
+			continue
+		}
+
+		labels[key] = value
+	}
+
+	return labels
+}
@@ -0,0 +1,122 @@
+package containerd
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	portainer "github.com/portainer/portainer/api"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/system"
+	"github.com/rs/zerolog/log"
+)
+
+// NamespaceLabel is the label added to the containers of the snapshot with their containerd namespace
+const NamespaceLabel = "io.containerd.namespace"
+
+// CreateSnapshot returns a snapshot of the containers, images, volumes and networks of all the containerd
+// namespaces, in the format of the Docker snapshots so that Portainer can show them
+func CreateSnapshot() (*portainer.DockerSnapshot, error) {
+	namespaces, err := Namespaces()
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &portainer.DockerSnapshot{}
+
+	if err := snapshotInfo(snapshot); err != nil {
+		log.Warn().Err(err).Msg("unable to snapshot containerd information")
+	}
+
+	stacks := make(map[string]struct{})
+
+	for _, namespace := range namespaces {
+		if err := snapshotContainers(snapshot, namespace, stacks); err != nil {
+			log.Warn().Err(err).Str("namespace", namespace).Msg("unable to snapshot containers")
+		}
+
+		snapshot.ImageCount += countObjects(namespace, "images", "--quiet")
+		snapshot.VolumeCount += countObjects(namespace, "volume", "ls", "--quiet")
+	}
+
+	snapshot.StackCount = len(stacks)
+	snapshot.Time = time.Now().Unix()
+
+	return snapshot, nil
+}
+
+func snapshotInfo(snapshot *portainer.DockerSnapshot) error {
+	output, err := runNerdctl("info", "--format", "{{json .}}")
+	if err != nil {
+		return err
+	}
+
+	var info system.Info
+	if err := json.Unmarshal(output, &info); err != nil {
+		return err
+	}
+
+	snapshot.DockerVersion = info.ServerVersion
+	snapshot.TotalCPU = info.NCPU
+	snapshot.TotalMemory = info.MemTotal
+	snapshot.SnapshotRaw.Info = info
+
+	return nil
+}
+
+func snapshotContainers(snapshot *portainer.DockerSnapshot, namespace string, stacks map[string]struct{}) error {
+	containers, err := ListContainers(namespace, "")
+	if err != nil {
+		return err
+	}
+
+	for _, container := range containers {
+		switch container.State() {
+		case "running":
+			snapshot.RunningContainerCount++
+		case "exited":
+			snapshot.StoppedContainerCount++
+		}
+
+		if project, ok := container.Labels["com.docker.compose.project"]; ok {
+			stacks[namespace+"/"+project] = struct{}{}
+		}
+
+		labels := container.Labels
+		labels[NamespaceLabel] = namespace
+
+		snapshot.SnapshotRaw.Containers = append(snapshot.SnapshotRaw.Containers, portainer.DockerContainerSnapshot{
+			Container: types.Container{
+				ID:      container.ID,
+				Names:   []string{"/" + container.Names},
+				Image:   container.Image,
+				Command: container.Command,
+				State:   container.State(),
+				Status:  container.Status,
+				Labels:  labels,
+			},
+		})
+	}
+
+	snapshot.ContainerCount += len(containers)
+
+	return nil
+}
+
+// countObjects returns the number of objects listed by a nerdctl command of the namespace
+func countObjects(namespace string, args ...string) int {
+	output, err := runNerdctl(append([]string{"--namespace", namespace}, args...)...)
+	if err != nil {
+		log.Warn().Err(err).Str("namespace", namespace).Str("objects", args[0]).Msg("unable to snapshot containerd objects")
+
+		return 0
+	}
+
+	unique := make(map[string]struct{})
+	for _, id := range strings.Fields(string(output)) {
+		unique[id] = struct{}{}
+	}
+
+	return len(unique)
+}
@@ -0,0 +1,78 @@
+package containerd
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fakeNerdctl(t *testing.T, outputs map[string]string) {
+	previous := runNerdctl
+	t.Cleanup(func() { runNerdctl = previous })
+
+	runNerdctl = func(args ...string) ([]byte, error) {
+		key := strings.Join(args, " ")
+		for prefix, output := range outputs {
+			if strings.HasPrefix(key, prefix) {
+				return []byte(output), nil
+			}
+		}
+
+		return nil, errors.New("unexpected command: " + key)
+	}
+}
+
+func TestCreateSnapshot(t *testing.T) {
+	fakeNerdctl(t, map[string]string{
+		"namespace ls": "default\nk8s.io\n",
+		"info":         `{"ServerVersion": "v1.7.13", "NCPU": 4, "MemTotal": 8000000000}`,
+		"--namespace default ps": `{"ID": "a1", "Names": "app-web-1", "Image": "nginx", "Status": "Up", "Labels": "com.docker.compose.project=edge_app,com.docker.compose.service=web"}
+{"ID": "a2", "Names": "app-job-1", "Image": "busybox", "Status": "Exited (0) 2 minutes ago", "Labels": "com.docker.compose.project=edge_app"}
+`,
+		"--namespace k8s.io ps":         `{"ID": "b1", "Names": "coredns", "Image": "coredns", "Status": "Up", "Labels": ""}`,
+		"--namespace default images":    "sha256:1\nsha256:2\nsha256:1\n",
+		"--namespace k8s.io images":     "sha256:3\n",
+		"--namespace default volume ls": "data\n",
+		"--namespace k8s.io volume ls":  "",
+	})
+
+	snapshot, err := CreateSnapshot()
+	require.NoError(t, err)
+
+	assert.Equal(t, "v1.7.13", snapshot.DockerVersion)
+	assert.Equal(t, 4, snapshot.TotalCPU)
+	assert.Equal(t, 3, snapshot.ContainerCount)
+	assert.Equal(t, 2, snapshot.RunningContainerCount)
+	assert.Equal(t, 1, snapshot.StoppedContainerCount)
+	assert.Equal(t, 1, snapshot.StackCount)
+	assert.Equal(t, 3, snapshot.ImageCount)
+	assert.Equal(t, 1, snapshot.VolumeCount)
+
+	require.Len(t, snapshot.SnapshotRaw.Containers, 3)
+	assert.Equal(t, "exited", snapshot.SnapshotRaw.Containers[1].State)
+	assert.Equal(t, "k8s.io", snapshot.SnapshotRaw.Containers[2].Labels[NamespaceLabel])
+}
+
+func TestContainerState(t *testing.T) {
+	tests := []struct {
+		status   string
+		state    string
+		exitCode int
+	}{
+		{"Up", "running", 0},
+		{"Up 3 minutes", "running", 0},
+		{"Created", "created", 0},
+		{"Exited (0) 2 minutes ago", "exited", 0},
+		{"Exited (137) 5 seconds ago", "exited", 137},
+	}
+
+	for _, tt := range tests {
+		container := Container{Status: tt.status}
+
+		assert.Equal(t, tt.state, container.State(), tt.status)
+		assert.Equal(t, tt.exitCode, container.ExitCode(), tt.status)
+	}
+}
@@ -0,0 +1,153 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrFIPSUnavailable is returned when the FIPS mode is requested from an agent built without a FIPS validated crypto
+// backend
+var ErrFIPSUnavailable = errors.New("the agent was not built with a FIPS validated crypto backend, build it with GOEXPERIMENT=boringcrypto")
+
+// FIPSCipherSuites are the TLS 1.2 cipher suites approved by FIPS 140, used in FIPS mode
+var FIPSCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+}
+
+// fipsCurves are the key exchange curves approved by FIPS 140, used in FIPS mode
+var fipsCurves = []tls.CurveID{tls.CurveP256, tls.CurveP384}
+
+// fipsNonApproved are the operations of the agent that do not go through the FIPS validated backend, whatever the
+// build, because they are part of the protocols of Portainer
+var fipsNonApproved = []string{
+	"the digest of the signatures of the Portainer instance is MD5",
+	"the SSH transport of the reverse tunnel uses golang.org/x/crypto",
+}
+
+// FIPSStatus is the FIPS status of the agent, reported in the snapshots
+type FIPSStatus struct {
+	// Build is true for the agents built with a FIPS validated crypto backend
+	Build bool
+	// Enabled is true when the FIPS mode is enabled and the backend passed its self-check
+	Enabled bool
+	Backend string `json:",omitempty"`
+	// SelfCheckError is why the self-check failed, empty when it passed
+	SelfCheckError string `json:",omitempty"`
+	// NonApproved lists the operations not using the FIPS validated backend in FIPS mode
+	NonApproved []string `json:",omitempty"`
+}
+
+var (
+	fipsStatus FIPSStatus
+	fipsMu     sync.RWMutex
+)
+
+// CheckFIPS runs the self-check of the crypto backend of FIPS builds and enables the FIPS mode when requested, which
+// restricts the TLS configurations to the FIPS approved versions, cipher suites and curves. It returns an error when
+// the FIPS mode is requested but not available.
+func CheckFIPS(enable bool) (FIPSStatus, error) {
+	status := FIPSStatus{Build: fipsBuild}
+
+	var err error
+
+	switch {
+	case !fipsBuild && enable:
+		err = ErrFIPSUnavailable
+	case fipsBuild:
+		status.Backend = fipsBackend
+
+		if err = fipsSelfCheck(); err != nil {
+			status.SelfCheckError = err.Error()
+		}
+	}
+
+	if enable && err == nil {
+		status.Enabled = true
+		status.NonApproved = fipsNonApproved
+	}
+
+	fipsMu.Lock()
+	fipsStatus = status
+	fipsMu.Unlock()
+
+	if enable {
+		return status, err
+	}
+
+	return status, nil
+}
+
+// FIPS returns the FIPS status determined by CheckFIPS
+func FIPS() FIPSStatus {
+	fipsMu.RLock()
+	defer fipsMu.RUnlock()
+
+	return fipsStatus
+}
+
+func fipsEnabled() bool {
+	return FIPS().Enabled
+}
+
+// fipsSelfCheck checks that the validated backend is in use, then runs known answer tests of the digests and a
+// pairwise consistency test of the ciphers and signatures used by the agent
+func fipsSelfCheck() error {
+	if !fipsBackendEnabled() {
+		return fmt.Errorf("the %s backend is not in use", fipsBackend)
+	}
+
+	digest := sha256.Sum256([]byte("abc"))
+	if hex.EncodeToString(digest[:]) != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		return errors.New("SHA-256 known answer test failed")
+	}
+
+	mac := hmac.New(sha256.New, []byte("Jefe"))
+	mac.Write([]byte("what do ya want for nothing?"))
+	if hex.EncodeToString(mac.Sum(nil)) != "5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843" {
+		return errors.New("HMAC-SHA-256 known answer test failed")
+	}
+
+	block, err := aes.NewCipher(make([]byte, 32))
+	if err != nil {
+		return fmt.Errorf("AES-256 self-check failed: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return fmt.Errorf("AES-GCM self-check failed: %w", err)
+	}
+
+	plaintext := []byte("portainer agent self-check")
+	nonce := make([]byte, aead.NonceSize())
+
+	opened, err := aead.Open(nil, nonce, aead.Seal(nil, nonce, plaintext, nil), nil)
+	if err != nil || !bytes.Equal(opened, plaintext) {
+		return errors.New("AES-GCM pairwise consistency test failed")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("ECDSA P-256 self-check failed: %w", err)
+	}
+
+	signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil || !ecdsa.VerifyASN1(&key.PublicKey, digest[:], signature) {
+		return errors.New("ECDSA P-256 pairwise consistency test failed")
+	}
+
+	return nil
+}
@@ -0,0 +1,19 @@
+//go:build boringcrypto
+
+package crypto
+
+import (
+	"crypto/boring"
+
+	// Restricts crypto/tls to the FIPS approved settings
+	_ "crypto/tls/fipsonly"
+)
+
+const (
+	fipsBuild   = true
+	fipsBackend = "BoringCrypto"
+)
+
+func fipsBackendEnabled() bool {
+	return boring.Enabled()
+}
@@ -0,0 +1,12 @@
+//go:build !boringcrypto
+
+package crypto
+
+const (
+	fipsBuild   = false
+	fipsBackend = ""
+)
+
+func fipsBackendEnabled() bool {
+	return false
+}
@@ -0,0 +1,35 @@
+package crypto
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckFIPS(t *testing.T) {
+	t.Cleanup(func() { fipsStatus = FIPSStatus{} })
+
+	status, err := CheckFIPS(false)
+	require.NoError(t, err)
+	assert.False(t, status.Enabled)
+	assert.Equal(t, fipsBuild, status.Build)
+	assert.Equal(t, TLS12CipherSuites, CreateTLSConfiguration().CipherSuites)
+
+	status, err = CheckFIPS(true)
+	if !fipsBuild {
+		require.ErrorIs(t, err, ErrFIPSUnavailable)
+		assert.False(t, status.Enabled)
+
+		return
+	}
+
+	require.NoError(t, err)
+	assert.True(t, status.Enabled)
+	assert.Empty(t, status.SelfCheckError)
+
+	config := CreateTLSConfiguration()
+	assert.Equal(t, FIPSCipherSuites, config.CipherSuites)
+	assert.Equal(t, []tls.CurveID{tls.CurveP256, tls.CurveP384}, config.CurvePreferences)
+}
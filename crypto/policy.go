@@ -0,0 +1,251 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/portainer/agent"
+	"github.com/portainer/agent/edge/revoke"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// RevocationOff disables the revocation checks of the certificates of the Portainer server
+	RevocationOff = "off"
+	// RevocationSoft rejects the revoked certificates of the Portainer server, and accepts them when their
+	// revocation status cannot be checked
+	RevocationSoft = "soft"
+	// RevocationHard rejects the certificates of the Portainer server that are revoked or whose revocation status
+	// cannot be checked
+	RevocationHard = "hard"
+)
+
+const pinPrefix = "sha256//"
+
+// ErrCertificateNotPinned is returned when the Portainer server presents none of the pinned public keys
+var ErrCertificateNotPinned = errors.New("the certificate of the Portainer server does not match any of the pinned public keys")
+
+// tlsPolicy is the TLS settings of the agent, applied to all the TLS configurations it creates
+type tlsPolicy struct {
+	minVersion   uint16
+	cipherSuites []uint16
+	// serverCAs are the CAs the Portainer server is verified against, registryCAs the CAs added to the ones of the
+	// system for the registries
+	serverCAs    *x509.CertPool
+	serverCAFile string
+	registryCAs  *x509.CertPool
+	serverPins   [][]byte
+	revocation   string
+	revoke       *revoke.Service
+}
+
+var (
+	policy   tlsPolicy
+	policyMu sync.RWMutex
+)
+
+// SetTLSPolicy validates the TLS settings of the agent and applies them to the TLS configurations created afterwards.
+// It must be called after CheckFIPS, the cipher suites are then restricted to the FIPS approved ones.
+func SetTLSPolicy(options *agent.Options) error {
+	newPolicy := tlsPolicy{minVersion: tls.VersionTLS12, revocation: options.EdgeTLSRevocation}
+
+	switch options.TLSMinVersion {
+	case "", "1.2":
+	case "1.3":
+		newPolicy.minVersion = tls.VersionTLS13
+	default:
+		return fmt.Errorf("unsupported minimum TLS version %q, use 1.2 or 1.3", options.TLSMinVersion)
+	}
+
+	for _, name := range options.TLSCipherSuites {
+		id, err := cipherSuiteID(name)
+		if err != nil {
+			return err
+		}
+
+		newPolicy.cipherSuites = append(newPolicy.cipherSuites, id)
+	}
+
+	if options.EdgeServerCA != "" {
+		pool, err := loadCertPool(x509.NewCertPool(), options.EdgeServerCA)
+		if err != nil {
+			return errors.WithMessage(err, "unable to load the CA bundle of the Portainer server")
+		}
+
+		newPolicy.serverCAs = pool
+		newPolicy.serverCAFile = options.EdgeServerCA
+	}
+
+	if options.EdgeRegistryCA != "" {
+		systemPool, err := x509.SystemCertPool()
+		if err != nil {
+			systemPool = x509.NewCertPool()
+		}
+
+		pool, err := loadCertPool(systemPool, options.EdgeRegistryCA)
+		if err != nil {
+			return errors.WithMessage(err, "unable to load the CA bundle of the registries")
+		}
+
+		newPolicy.registryCAs = pool
+	}
+
+	for _, pin := range options.EdgeServerPins {
+		digest, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, pinPrefix))
+		if err != nil || len(digest) != sha256.Size {
+			return fmt.Errorf("invalid public key pin %q, use the sha256//<base64 SHA-256 of the public key> format", pin)
+		}
+
+		newPolicy.serverPins = append(newPolicy.serverPins, digest)
+	}
+
+	switch newPolicy.revocation {
+	case "", RevocationOff:
+	case RevocationSoft, RevocationHard:
+		newPolicy.revoke = revoke.NewService()
+		newPolicy.revoke.SetHardFail(newPolicy.revocation == RevocationHard)
+	default:
+		return fmt.Errorf("unsupported revocation check mode %q", newPolicy.revocation)
+	}
+
+	policyMu.Lock()
+	policy = newPolicy
+	policyMu.Unlock()
+
+	return nil
+}
+
+func currentPolicy() tlsPolicy {
+	policyMu.RLock()
+	defer policyMu.RUnlock()
+
+	return policy
+}
+
+// ServerTLSConfiguration creates the tls.Config of the connections to the Portainer server, verified against the CA
+// bundle of the server, its pinned public keys and the revocation status of its certificates when they are configured
+func ServerTLSConfiguration() *tls.Config {
+	config := CreateTLSConfiguration()
+	policy := currentPolicy()
+
+	config.RootCAs = policy.serverCAs
+
+	if len(policy.serverPins) > 0 {
+		// Also called when the verification of the certificates is skipped, the pins then authenticate the server
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			return verifyPins(state.PeerCertificates, policy.serverPins)
+		}
+	}
+
+	if policy.revoke != nil {
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+			for _, chain := range verifiedChains {
+				if err := policy.revoke.VerifyChain(chain); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}
+	}
+
+	return config
+}
+
+// ServerCAFile returns the path of the CA bundle of the Portainer server, empty when the CAs of the system are used
+func ServerCAFile() string {
+	return currentPolicy().serverCAFile
+}
+
+// ServerRevocation returns the revocation check mode of the certificates of the Portainer server, empty when it is not
+// configured
+func ServerRevocation() string {
+	return currentPolicy().revocation
+}
+
+// RegistryTLSConfiguration creates the tls.Config of the connections to the registries, verified against the CAs of
+// the system and the CA bundle of the registries
+func RegistryTLSConfiguration() *tls.Config {
+	config := CreateTLSConfiguration()
+	config.RootCAs = currentPolicy().registryCAs
+
+	return config
+}
+
+// applyTLSPolicy restricts the TLS versions and cipher suites of the configuration to the ones of the policy
+func applyTLSPolicy(config *tls.Config) {
+	policy := currentPolicy()
+
+	if policy.minVersion > config.MinVersion {
+		config.MinVersion = policy.minVersion
+	}
+
+	if len(policy.cipherSuites) > 0 {
+		config.CipherSuites = policy.cipherSuites
+	}
+}
+
+// cipherSuiteID returns the identifier of the TLS 1.2 cipher suite, the insecure and the TLS 1.3 cipher suites are
+// rejected, as well as the ones not approved by FIPS 140 in FIPS mode
+func cipherSuiteID(name string) (uint16, error) {
+	for _, suite := range tls.InsecureCipherSuites() {
+		if suite.Name == name {
+			return 0, fmt.Errorf("the cipher suite %s is insecure", name)
+		}
+	}
+
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name != name {
+			continue
+		}
+
+		if !slices.Contains(suite.SupportedVersions, tls.VersionTLS12) {
+			return 0, fmt.Errorf("the cipher suite %s is a TLS 1.3 cipher suite, which cannot be configured", name)
+		}
+
+		if fipsEnabled() && !slices.Contains(FIPSCipherSuites, suite.ID) {
+			return 0, fmt.Errorf("the cipher suite %s is not approved by FIPS 140", name)
+		}
+
+		return suite.ID, nil
+	}
+
+	return 0, fmt.Errorf("unknown cipher suite %s", name)
+}
+
+func loadCertPool(pool *x509.CertPool, path string) (*x509.CertPool, error) {
+	bundle, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !pool.AppendCertsFromPEM(bundle) {
+		return nil, fmt.Errorf("no PEM encoded certificate found in %s", path)
+	}
+
+	return pool, nil
+}
+
+// verifyPins checks that one of the certificates presented by the server has a pinned public key
+func verifyPins(certificates []*x509.Certificate, pins [][]byte) error {
+	for _, cert := range certificates {
+		digest := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+
+		for _, pin := range pins {
+			if bytes.Equal(digest[:], pin) {
+				return nil
+			}
+		}
+	}
+
+	return ErrCertificateNotPinned
+}
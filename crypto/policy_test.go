@@ -0,0 +1,93 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/portainer/agent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetTLSPolicy(t *testing.T) {
+	t.Cleanup(func() { policy = tlsPolicy{} })
+
+	require.NoError(t, SetTLSPolicy(&agent.Options{
+		TLSMinVersion:   "1.3",
+		TLSCipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"},
+	}))
+
+	config := CreateTLSConfiguration()
+	assert.Equal(t, uint16(tls.VersionTLS13), config.MinVersion)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384}, config.CipherSuites)
+
+	for _, options := range []agent.Options{
+		{TLSMinVersion: "1.1"},
+		{TLSCipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+		{TLSCipherSuites: []string{"TLS_AES_128_GCM_SHA256"}},
+		{TLSCipherSuites: []string{"TLS_UNKNOWN"}},
+		{EdgeServerPins: []string{"sha256//dG9vIHNob3J0"}},
+		{EdgeServerCA: filepath.Join(t.TempDir(), "missing.pem")},
+		{EdgeTLSRevocation: "strict"},
+	} {
+		assert.Error(t, SetTLSPolicy(&options), "%+v", options)
+	}
+}
+
+func TestServerTLSConfiguration_pins(t *testing.T) {
+	t.Cleanup(func() { policy = tlsPolicy{} })
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, createPEMEncodedFile(caFile, "CERTIFICATE", server.Certificate().Raw))
+
+	digest := sha256.Sum256(server.Certificate().RawSubjectPublicKeyInfo)
+	otherDigest := sha256.Sum256([]byte("other"))
+
+	get := func(options *agent.Options) error {
+		require.NoError(t, SetTLSPolicy(options))
+
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: ServerTLSConfiguration()}}
+
+		resp, err := client.Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+
+		return err
+	}
+
+	assert.NoError(t, get(&agent.Options{
+		EdgeServerCA:   caFile,
+		EdgeServerPins: []string{"sha256//" + base64.StdEncoding.EncodeToString(digest[:])},
+	}))
+
+	err := get(&agent.Options{
+		EdgeServerCA:   caFile,
+		EdgeServerPins: []string{"sha256//" + base64.StdEncoding.EncodeToString(otherDigest[:])},
+	})
+	assert.ErrorIs(t, err, ErrCertificateNotPinned)
+
+	// Without the CA bundle of the server, the CAs of the system do not trust it
+	var unknownAuthority x509.UnknownAuthorityError
+	assert.ErrorAs(t, get(&agent.Options{}), &unknownAuthority)
+}
+
+func TestRegistryTLSConfiguration(t *testing.T) {
+	t.Cleanup(func() { policy = tlsPolicy{} })
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, []byte("not a certificate"), 0600))
+	assert.Error(t, SetTLSPolicy(&agent.Options{EdgeRegistryCA: caFile}))
+
+	assert.Nil(t, RegistryTLSConfiguration().RootCAs)
+}
@@ -26,12 +26,22 @@ var TLS12CipherSuites = []uint16{
 	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
 }
 
-// CreateTLSConfiguration creates a basic tls.Config with recommended TLS settings
+// CreateTLSConfiguration creates a basic tls.Config with recommended TLS settings, restricted to the FIPS approved
+// cipher suites and curves in FIPS mode and to the minimum version and cipher suites of the TLS policy of the agent
 func CreateTLSConfiguration() *tls.Config {
-	return &tls.Config{
+	config := &tls.Config{
 		MinVersion:   tls.VersionTLS12,
 		CipherSuites: TLS12CipherSuites,
 	}
+
+	if fipsEnabled() {
+		config.CipherSuites = FIPSCipherSuites
+		config.CurvePreferences = fipsCurves
+	}
+
+	applyTLSPolicy(config)
+
+	return config
 }
 
 // TLSService is a service used to generate TLS cert and key files
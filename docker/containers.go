@@ -91,3 +91,98 @@ func ContainerWait(name string, condition container.WaitCondition) (<-chan conta
 
 	return statusCh, errCh
 }
+
+func ContainerSignal(name, signal string) error {
+	return withCli(func(cli *client.Client) error {
+		return cli.ContainerKill(context.Background(), name, signal)
+	})
+}
+
+// GetContainerExitCodes returns the exit code of the containers matching the label filter, mapped to their
+// compose or swarm service. The highest exit code is kept when several containers run the same service.
+func GetContainerExitCodes(label string) (map[string]int, error) {
+	containers, err := GetContainersWithLabel(label)
+	if err != nil {
+		return nil, err
+	}
+
+	exitCodes := make(map[string]int)
+
+	err = withCli(func(cli *client.Client) error {
+		for _, c := range containers {
+			service := c.Labels["com.docker.compose.service"]
+			if service == "" {
+				service = c.Labels["com.docker.swarm.service.name"]
+			}
+
+			inspect, err := cli.ContainerInspect(context.Background(), c.ID)
+			if err != nil {
+				return err
+			}
+
+			exitCode := 0
+			if inspect.State != nil {
+				exitCode = inspect.State.ExitCode
+			}
+
+			if current, ok := exitCodes[service]; !ok || exitCode > current {
+				exitCodes[service] = exitCode
+			}
+		}
+
+		return nil
+	})
+
+	return exitCodes, err
+}
+
+// ContainerState is the state of a container running a compose or swarm service
+type ContainerState struct {
+	Service      string
+	Status       string
+	ExitCode     int
+	RestartCount int
+	Error        string
+}
+
+// GetContainerStates returns the state of the containers matching the label filter
+func GetContainerStates(label string) ([]ContainerState, error) {
+	containers, err := GetContainersWithLabel(label)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]ContainerState, 0, len(containers))
+
+	err = withCli(func(cli *client.Client) error {
+		for _, c := range containers {
+			service := c.Labels["com.docker.compose.service"]
+			if service == "" {
+				service = c.Labels["com.docker.swarm.service.name"]
+			}
+
+			inspect, err := cli.ContainerInspect(context.Background(), c.ID)
+			if err != nil {
+				return err
+			}
+
+			state := ContainerState{
+				Service:      service,
+				Status:       c.State,
+				RestartCount: inspect.RestartCount,
+			}
+
+			if inspect.State != nil {
+				state.Status = inspect.State.Status
+				state.ExitCode = inspect.State.ExitCode
+				state.Error = inspect.State.Error
+			}
+
+			states = append(states, state)
+		}
+
+		return nil
+	})
+
+	return states, err
+}
@@ -153,11 +153,13 @@ func getSwarmConfiguration(config *agent.RuntimeConfiguration, dockerInfo system
 }
 
 func NewClient() (*client.Client, error) {
-	return client.NewClientWithOpts(
+	opts := []client.Opt{
 		client.FromEnv,
 		client.WithAPIVersionNegotiation(),
 		client.WithTimeout(clientTimeout),
-	)
+	}
+
+	return client.NewClientWithOpts(append(opts, remoteClientOpts()...)...)
 }
 
 func withCli(callback func(cli *client.Client) error) error {
@@ -169,3 +171,42 @@ func withCli(callback func(cli *client.Client) error) error {
 
 	return callback(cli)
 }
+
+// GetInfo returns the system information of the Docker engine
+func GetInfo() (info system.Info, err error) {
+	err = withCli(func(cli *client.Client) error {
+		info, err = cli.Info(context.Background())
+		return err
+	})
+
+	return info, err
+}
+
+// Ping returns an error when the Docker engine is not reachable
+func Ping(ctx context.Context) error {
+	return withCli(func(cli *client.Client) error {
+		_, err := cli.Ping(ctx)
+		return err
+	})
+}
+
+// GetComponentVersions returns the version of the Docker engine and of the components it reports, e.g. containerd and runc
+func GetComponentVersions() (map[string]string, error) {
+	versions := make(map[string]string)
+
+	err := withCli(func(cli *client.Client) error {
+		version, err := cli.ServerVersion(context.Background())
+		if err != nil {
+			return err
+		}
+
+		versions["Engine"] = version.Version
+		for _, component := range version.Components {
+			versions[component.Name] = component.Version
+		}
+
+		return nil
+	})
+
+	return versions, err
+}
@@ -17,16 +17,6 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-// CopyGitStackToHost copies src folder to the dst folder on the host
-func CopyGitStackToHost(src, dst string, stackID int, stackName, assetPath string) error {
-	return removeAndCopy(src, dst, stackID, stackName, assetPath, true)
-}
-
-// RemoveGitStackFromHost removes the copy of src folder on the host
-func RemoveGitStackFromHost(src, dst string, stackID int, stackName string) error {
-	return removeAndCopy(src, dst, stackID, stackName, "", false)
-}
-
 func buildRemoveDirCmd(src, dst string) []string {
 	gitStackPath := filepath.Join(dst, filepath.Base(src))
 
@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package docker
+
+// CopyGitStackToHost copies src folder to the dst folder on the host
+func CopyGitStackToHost(src, dst string, stackID int, stackName, assetPath string) error {
+	return removeAndCopy(src, dst, stackID, stackName, assetPath, true)
+}
+
+// RemoveGitStackFromHost removes the copy of src folder on the host
+func RemoveGitStackFromHost(src, dst string, stackID int, stackName string) error {
+	return removeAndCopy(src, dst, stackID, stackName, "", false)
+}
@@ -0,0 +1,45 @@
+//go:build windows
+// +build windows
+
+package docker
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/portainer/portainer/api/filesystem"
+)
+
+// CopyGitStackToHost copies src folder to the dst folder on the host. Windows containers cannot run the Linux unpacker
+// image, the agent runs on the host or with the host folders mounted at the same paths and copies the files itself.
+func CopyGitStackToHost(src, dst string, stackID int, stackName, assetPath string) error {
+	gitStackPath, copied := hostGitStackPath(src, dst)
+	if copied {
+		return nil
+	}
+
+	if err := os.RemoveAll(gitStackPath); err != nil {
+		return err
+	}
+
+	return filesystem.CopyDir(src, dst, true)
+}
+
+// RemoveGitStackFromHost removes the copy of src folder on the host
+func RemoveGitStackFromHost(src, dst string, stackID int, stackName string) error {
+	gitStackPath, copied := hostGitStackPath(src, dst)
+	if copied {
+		return nil
+	}
+
+	return os.RemoveAll(gitStackPath)
+}
+
+// hostGitStackPath returns the path of the copy of src folder in the dst folder, and whether src is that copy. The
+// Windows paths are compared regardless of their case.
+func hostGitStackPath(src, dst string) (string, bool) {
+	gitStackPath := filepath.Join(dst, filepath.Base(src))
+
+	return gitStackPath, strings.EqualFold(filepath.Clean(src), gitStackPath)
+}
@@ -0,0 +1,56 @@
+//go:build windows
+// +build windows
+
+package docker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHostGitStackPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		dst      string
+		expected string
+		copied   bool
+	}{
+		{
+			name:     "other folder",
+			src:      `C:\ProgramData\portainer\edge_stacks\1\repo`,
+			dst:      `D:\stacks\1`,
+			expected: `D:\stacks\1\repo`,
+		},
+		{
+			name:     "same folder",
+			src:      `C:\stacks\1\repo\`,
+			dst:      `C:\stacks\1`,
+			expected: `C:\stacks\1\repo`,
+			copied:   true,
+		},
+		{
+			name:     "same folder with another case",
+			src:      `c:\Stacks\1\REPO`,
+			dst:      `C:\stacks\1`,
+			expected: `C:\stacks\1\REPO`,
+			copied:   true,
+		},
+		{
+			name:     "forward slashes",
+			src:      "C:/stacks/1/repo",
+			dst:      `C:\stacks\1`,
+			expected: `C:\stacks\1\repo`,
+			copied:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gitStackPath, copied := hostGitStackPath(tt.src, tt.dst)
+			assert.Equal(t, tt.expected, gitStackPath)
+			assert.Equal(t, tt.copied, copied)
+		})
+	}
+}
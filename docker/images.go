@@ -2,7 +2,11 @@ package docker
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"io"
 
+	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/client"
 )
@@ -16,3 +20,109 @@ func ImageDelete(name string, opts image.RemoveOptions) (r []image.DeleteRespons
 
 	return r, err
 }
+
+// GetImageLayersSize returns the total size of the image layers stored by the engine
+func GetImageLayersSize() (size int64, err error) {
+	err = withCli(func(cli *client.Client) error {
+		usage, err := cli.DiskUsage(context.Background(), types.DiskUsageOptions{
+			Types: []types.DiskUsageObject{types.ImageObject},
+		})
+		size = usage.LayersSize
+
+		return err
+	})
+
+	return size, err
+}
+
+// LayerProgress is the download progress of a layer of an image being pulled
+type LayerProgress struct {
+	Image string
+	Layer string
+	// Current and Total are the bytes of the layer downloaded so far and to download, Total is 0 until the download starts
+	Current int64
+	Total   int64
+	// Done is set once the layer is downloaded, or when it already exists
+	Done bool
+}
+
+// PullImage pulls the image through the engine API, calling progress as its layers are downloaded.
+// registryAuth is the base64 encoded registry.AuthConfig, empty for the public images.
+func PullImage(ctx context.Context, ref, registryAuth string, progress func(LayerProgress)) error {
+	return withCli(func(cli *client.Client) error {
+		cli.HTTPClient().Timeout = largeClientTimeout
+
+		reader, err := cli.ImagePull(ctx, ref, image.PullOptions{RegistryAuth: registryAuth})
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+
+		return decodePullProgress(ref, reader, progress)
+	})
+}
+
+// pullMessage is a JSON message of an image pull, the fields used of jsonmessage.JSONMessage
+type pullMessage struct {
+	ID             string `json:"id"`
+	Status         string `json:"status"`
+	ProgressDetail *struct {
+		Current int64 `json:"current"`
+		Total   int64 `json:"total"`
+	} `json:"progressDetail"`
+	Error string `json:"error"`
+}
+
+// decodePullProgress decodes the JSON messages of an image pull, the pull fails with the error of the last message
+func decodePullProgress(ref string, reader io.Reader, progress func(LayerProgress)) error {
+	decoder := json.NewDecoder(reader)
+
+	for {
+		var message pullMessage
+
+		err := decoder.Decode(&message)
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if message.Error != "" {
+			return errors.New(message.Error)
+		}
+
+		// The messages without ID report the status of the image itself
+		if message.ID == "" {
+			continue
+		}
+
+		layer := LayerProgress{Image: ref, Layer: message.ID}
+
+		switch message.Status {
+		case "Downloading":
+			if message.ProgressDetail == nil {
+				continue
+			}
+
+			layer.Current, layer.Total = message.ProgressDetail.Current, message.ProgressDetail.Total
+		case "Download complete", "Already exists", "Pull complete":
+			layer.Done = true
+		default:
+			continue
+		}
+
+		progress(layer)
+	}
+}
+
+// ImageID returns the ID of the local image the reference points to
+func ImageID(ctx context.Context, ref string) (id string, err error) {
+	err = withCli(func(cli *client.Client) error {
+		inspect, _, err := cli.ImageInspectWithRaw(ctx, ref)
+		id = inspect.ID
+
+		return err
+	})
+
+	return id, err
+}
@@ -27,6 +27,21 @@ func GetContainersWithLabel(value string) (r []types.Container, err error) {
 	return r, err
 }
 
+// GetComposeProjects returns the compose projects of the containers on the host, mapped to their working directory
+func GetComposeProjects() (map[string]string, error) {
+	containers, err := GetContainersWithLabel("com.docker.compose.project")
+	if err != nil {
+		return nil, err
+	}
+
+	projects := make(map[string]string)
+	for _, container := range containers {
+		projects[container.Labels["com.docker.compose.project"]] = container.Labels["com.docker.compose.project.working_dir"]
+	}
+
+	return projects, nil
+}
+
 func GetContainerLogs(containerName string, tail string) ([]byte, []byte, error) {
 	cli, err := NewClient()
 	if err != nil {
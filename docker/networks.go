@@ -0,0 +1,80 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+)
+
+// HostNetwork is a macvlan or ipvlan network of a host interface
+type HostNetwork struct {
+	Name   string
+	Driver string
+	// Parent is the host interface, or its VLAN sub-interface in the parent.VLAN format
+	Parent       string
+	Mode         string
+	Subnet       string
+	Gateway      string
+	IPRange      string
+	AuxAddresses map[string]string
+	Labels       map[string]string
+}
+
+// EnsureHostNetwork creates the network of the host interface. An existing network of the same name is used as is
+// when it has the same driver and parent interface, so that the stacks sharing a VLAN share its network, an error is
+// returned otherwise.
+func EnsureHostNetwork(ctx context.Context, hostNetwork HostNetwork) error {
+	return withCli(func(cli *client.Client) error {
+		existing, err := cli.NetworkInspect(ctx, hostNetwork.Name, types.NetworkInspectOptions{})
+		if err == nil {
+			if existing.Driver != hostNetwork.Driver || existing.Options["parent"] != hostNetwork.Parent {
+				return fmt.Errorf("the network %s already exists with the %s driver on the %q interface", hostNetwork.Name, existing.Driver, existing.Options["parent"])
+			}
+
+			return nil
+		} else if !errdefs.IsNotFound(err) {
+			return err
+		}
+
+		options := map[string]string{"parent": hostNetwork.Parent}
+		if hostNetwork.Mode != "" {
+			options[hostNetwork.Driver+"_mode"] = hostNetwork.Mode
+		}
+
+		var ipam *network.IPAM
+		if hostNetwork.Subnet != "" {
+			ipam = &network.IPAM{
+				Config: []network.IPAMConfig{{
+					Subnet:     hostNetwork.Subnet,
+					Gateway:    hostNetwork.Gateway,
+					IPRange:    hostNetwork.IPRange,
+					AuxAddress: hostNetwork.AuxAddresses,
+				}},
+			}
+		}
+
+		_, err = cli.NetworkCreate(ctx, hostNetwork.Name, types.NetworkCreate{
+			Driver:  hostNetwork.Driver,
+			Options: options,
+			IPAM:    ipam,
+			Labels:  hostNetwork.Labels,
+		})
+
+		return err
+	})
+}
+
+// RemoveHostNetwork removes the network of the host interface, a network already removed is ignored
+func RemoveHostNetwork(ctx context.Context, name string) error {
+	return withCli(func(cli *client.Client) error {
+		if err := cli.NetworkRemove(ctx, name); err != nil && !errdefs.IsNotFound(err) {
+			return err
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,283 @@
+package docker
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/tlsconfig"
+)
+
+// Endpoint is the Docker engine managed by the agent, the local engine when Host is empty
+type Endpoint struct {
+	// Host is the address of the engine, e.g. tcp://192.168.1.20:2376 or ssh://admin@192.168.1.20
+	Host string
+	// CertPath is the folder holding the ca.pem, cert.pem and key.pem files used to reach the engine over TLS
+	CertPath  string
+	TLSVerify bool
+}
+
+// UseEndpoint points the Docker client of the agent, and the docker binaries it runs, to the engine of the endpoint
+func UseEndpoint(endpoint Endpoint) error {
+	if endpoint.Host == "" {
+		return nil
+	}
+
+	if _, err := client.ParseHostURL(endpoint.Host); err != nil {
+		return fmt.Errorf("invalid Docker host %q: %w", endpoint.Host, err)
+	}
+
+	os.Setenv(client.EnvOverrideHost, endpoint.Host)
+
+	if endpoint.CertPath != "" {
+		os.Setenv(client.EnvOverrideCertPath, endpoint.CertPath)
+
+		if endpoint.TLSVerify {
+			os.Setenv(client.EnvTLSVerify, "1")
+		}
+	}
+
+	return nil
+}
+
+// ResolveContext returns the endpoint of a docker context, as found by the docker binary of the assets path
+func ResolveContext(binaryPath, name string) (Endpoint, error) {
+	command := filepath.Join(binaryPath, "docker")
+	if runtime.GOOS == "windows" {
+		command = filepath.Join(binaryPath, "docker.exe")
+	}
+
+	output, err := exec.Command(command, "context", "inspect", name).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return Endpoint{}, fmt.Errorf("unable to inspect the docker context %s: %s", name, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+
+		return Endpoint{}, fmt.Errorf("unable to inspect the docker context %s: %w", name, err)
+	}
+
+	return parseContext(output)
+}
+
+type dockerContext struct {
+	Endpoints map[string]struct {
+		Host          string
+		SkipTLSVerify bool
+	}
+	TLSMaterial map[string][]string
+	Storage     struct {
+		TLSPath string
+	}
+}
+
+func parseContext(output []byte) (Endpoint, error) {
+	var contexts []dockerContext
+	if err := json.Unmarshal(output, &contexts); err != nil {
+		return Endpoint{}, fmt.Errorf("invalid docker context: %w", err)
+	}
+
+	if len(contexts) == 0 || contexts[0].Endpoints["docker"].Host == "" {
+		return Endpoint{}, errors.New("the docker context has no Docker endpoint")
+	}
+
+	dockerCtx := contexts[0]
+	endpoint := Endpoint{Host: dockerCtx.Endpoints["docker"].Host}
+
+	if len(dockerCtx.TLSMaterial["docker"]) > 0 {
+		endpoint.CertPath = filepath.Join(dockerCtx.Storage.TLSPath, "docker")
+		endpoint.TLSVerify = !dockerCtx.Endpoints["docker"].SkipTLSVerify
+	}
+
+	return endpoint, nil
+}
+
+// The local endpoints of the Docker engine, used when DOCKER_HOST does not point to another socket or named pipe
+const (
+	DefaultSocket    = "/var/run/docker.sock"
+	DefaultNamedPipe = "//./pipe/docker_engine"
+)
+
+// LocalSocket returns the socket of the local engine, the one of DOCKER_HOST when it is a unix:// address
+func LocalSocket() string {
+	if host := os.Getenv(client.EnvOverrideHost); strings.HasPrefix(host, "unix://") {
+		return strings.TrimPrefix(host, "unix://")
+	}
+
+	return DefaultSocket
+}
+
+// LocalNamedPipe returns the named pipe of the local engine on Windows, the one of DOCKER_HOST when it is an
+// npipe:// address, e.g. npipe:////./pipe/docker_engine_windows
+func LocalNamedPipe() string {
+	if host := os.Getenv(client.EnvOverrideHost); strings.HasPrefix(host, "npipe://") {
+		return strings.TrimPrefix(host, "npipe://")
+	}
+
+	return DefaultNamedPipe
+}
+
+// IsRemote returns true when the agent manages an engine reached over the network instead of the local socket
+func IsRemote() bool {
+	host := os.Getenv(client.EnvOverrideHost)
+
+	return host != "" && !strings.HasPrefix(host, "unix://") && !strings.HasPrefix(host, "npipe://")
+}
+
+// DialRemote connects to the remote engine managed by the agent, see IsRemote
+func DialRemote(ctx context.Context) (net.Conn, error) {
+	host, err := url.Parse(os.Getenv(client.EnvOverrideHost))
+	if err != nil {
+		return nil, err
+	}
+
+	switch host.Scheme {
+	case "ssh":
+		return dialSSH(host)
+	case "tcp", "http", "https":
+		dialer := &net.Dialer{Timeout: clientTimeout}
+
+		certPath := os.Getenv(client.EnvOverrideCertPath)
+		if certPath == "" {
+			return dialer.DialContext(ctx, "tcp", host.Host)
+		}
+
+		config, err := tlsconfig.Client(tlsconfig.Options{
+			CAFile:             filepath.Join(certPath, "ca.pem"),
+			CertFile:           filepath.Join(certPath, "cert.pem"),
+			KeyFile:            filepath.Join(certPath, "key.pem"),
+			InsecureSkipVerify: os.Getenv(client.EnvTLSVerify) == "",
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return (&tls.Dialer{NetDialer: dialer, Config: config}).DialContext(ctx, "tcp", host.Host)
+	}
+
+	return nil, fmt.Errorf("unsupported Docker host scheme: %s", host.Scheme)
+}
+
+// remoteClientOpts returns the options of the Docker client reaching an engine over SSH,
+// the other schemes being handled by the client itself
+func remoteClientOpts() []client.Opt {
+	if !strings.HasPrefix(os.Getenv(client.EnvOverrideHost), "ssh://") {
+		return nil
+	}
+
+	return []client.Opt{
+		// The host is only used to build the URL of the requests, they are sent through the SSH connection
+		client.WithHost("http://docker.example.com"),
+		client.WithDialContext(func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return DialRemote(ctx)
+		}),
+	}
+}
+
+// dialSSH runs docker system dial-stdio on the remote host and returns a connection over its standard streams,
+// the same way the docker binary reaches the engines of the ssh:// hosts
+func dialSSH(host *url.URL) (net.Conn, error) {
+	args := []string{"-o", "ConnectTimeout=" + strconv.Itoa(int(clientTimeout/time.Second))}
+
+	if host.User != nil {
+		args = append(args, "-l", host.User.Username())
+	}
+
+	if host.Port() != "" {
+		args = append(args, "-p", host.Port())
+	}
+
+	args = append(args, "--", host.Hostname(), "docker", "system", "dial-stdio")
+
+	cmd := exec.Command("ssh", args...)
+	cmd.Stderr = io.Discard
+
+	conn, err := newCommandConn(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("unable to run ssh: %w", err)
+	}
+
+	return conn, nil
+}
+
+// commandConn is a connection over the standard streams of a command. The streams are pipes, the deadlines of the
+// connection are the ones of the pipes, which are not supported on Windows.
+type commandConn struct {
+	cmd    *exec.Cmd
+	stdin  *os.File
+	stdout *os.File
+}
+
+// newCommandConn starts the command and returns a connection over its standard streams
+func newCommandConn(cmd *exec.Cmd) (*commandConn, error) {
+	stdinReader, stdin, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		stdinReader.Close()
+		stdin.Close()
+
+		return nil, err
+	}
+
+	cmd.Stdin = stdinReader
+	cmd.Stdout = stdoutWriter
+
+	err = cmd.Start()
+
+	// The ends of the command are only used by the command
+	stdinReader.Close()
+	stdoutWriter.Close()
+
+	if err != nil {
+		stdin.Close()
+		stdout.Close()
+
+		return nil, err
+	}
+
+	return &commandConn{cmd: cmd, stdin: stdin, stdout: stdout}, nil
+}
+
+func (c *commandConn) Read(b []byte) (int, error)  { return c.stdout.Read(b) }
+func (c *commandConn) Write(b []byte) (int, error) { return c.stdin.Write(b) }
+
+func (c *commandConn) Close() error {
+	c.stdin.Close()
+	c.cmd.Process.Kill()
+	c.cmd.Wait()
+	c.stdout.Close()
+
+	return nil
+}
+
+func (c *commandConn) LocalAddr() net.Addr  { return commandAddr{} }
+func (c *commandConn) RemoteAddr() net.Addr { return commandAddr{} }
+
+func (c *commandConn) SetDeadline(t time.Time) error {
+	return errors.Join(c.stdout.SetReadDeadline(t), c.stdin.SetWriteDeadline(t))
+}
+
+func (c *commandConn) SetReadDeadline(t time.Time) error  { return c.stdout.SetReadDeadline(t) }
+func (c *commandConn) SetWriteDeadline(t time.Time) error { return c.stdin.SetWriteDeadline(t) }
+
+type commandAddr struct{}
+
+func (commandAddr) Network() string { return "command" }
+func (commandAddr) String() string  { return "command" }
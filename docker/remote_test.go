@@ -0,0 +1,176 @@
+package docker
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseContext(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		expected Endpoint
+		err      bool
+	}{
+		{
+			name:     "plain endpoint",
+			output:   `[{"Endpoints":{"docker":{"Host":"tcp://192.168.1.20:2375"}}}]`,
+			expected: Endpoint{Host: "tcp://192.168.1.20:2375"},
+		},
+		{
+			name:     "TLS material",
+			output:   `[{"Endpoints":{"docker":{"Host":"tcp://192.168.1.20:2376"}},"TLSMaterial":{"docker":["ca.pem","cert.pem","key.pem"]},"Storage":{"TLSPath":"/root/.docker/contexts/tls/abc"}}]`,
+			expected: Endpoint{Host: "tcp://192.168.1.20:2376", CertPath: filepath.Join("/root/.docker/contexts/tls/abc", "docker"), TLSVerify: true},
+		},
+		{
+			name:     "TLS material without verification",
+			output:   `[{"Endpoints":{"docker":{"Host":"tcp://192.168.1.20:2376","SkipTLSVerify":true}},"TLSMaterial":{"docker":["ca.pem"]},"Storage":{"TLSPath":"/tls"}}]`,
+			expected: Endpoint{Host: "tcp://192.168.1.20:2376", CertPath: filepath.Join("/tls", "docker")},
+		},
+		{
+			name:   "no endpoint",
+			output: `[{"Endpoints":{}}]`,
+			err:    true,
+		},
+		{
+			name:   "no context",
+			output: `[]`,
+			err:    true,
+		},
+		{
+			name:   "invalid output",
+			output: `context not found`,
+			err:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			endpoint, err := parseContext([]byte(tt.output))
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, endpoint)
+		})
+	}
+}
+
+func TestIsRemote(t *testing.T) {
+	tests := []struct {
+		host     string
+		expected bool
+	}{
+		{"", false},
+		{"unix:///run/user/1000/docker.sock", false},
+		{"npipe:////./pipe/docker_engine_windows", false},
+		{"tcp://192.168.1.20:2376", true},
+		{"ssh://admin@192.168.1.20", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			t.Setenv(client.EnvOverrideHost, tt.host)
+
+			assert.Equal(t, tt.expected, IsRemote())
+		})
+	}
+}
+
+func TestLocalSocket(t *testing.T) {
+	tests := []struct {
+		host     string
+		expected string
+	}{
+		{"", DefaultSocket},
+		{"unix:///run/user/1000/docker.sock", "/run/user/1000/docker.sock"},
+		{"tcp://192.168.1.20:2376", DefaultSocket},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			t.Setenv(client.EnvOverrideHost, tt.host)
+
+			assert.Equal(t, tt.expected, LocalSocket())
+		})
+	}
+}
+
+func TestLocalNamedPipe(t *testing.T) {
+	tests := []struct {
+		host     string
+		expected string
+	}{
+		{"", DefaultNamedPipe},
+		{"npipe:////./pipe/docker_engine_windows", "//./pipe/docker_engine_windows"},
+		{"unix:///var/run/docker.sock", DefaultNamedPipe},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			t.Setenv(client.EnvOverrideHost, tt.host)
+
+			assert.Equal(t, tt.expected, LocalNamedPipe())
+		})
+	}
+}
+
+func TestUseEndpoint(t *testing.T) {
+	t.Setenv(client.EnvOverrideHost, "")
+	t.Setenv(client.EnvOverrideCertPath, "")
+	t.Setenv(client.EnvTLSVerify, "")
+
+	assert.Error(t, UseEndpoint(Endpoint{Host: "192.168.1.20"}))
+	assert.Empty(t, os.Getenv(client.EnvOverrideHost))
+
+	require.NoError(t, UseEndpoint(Endpoint{Host: "tcp://192.168.1.20:2376", CertPath: "/certs", TLSVerify: true}))
+	assert.Equal(t, "tcp://192.168.1.20:2376", os.Getenv(client.EnvOverrideHost))
+	assert.Equal(t, "/certs", os.Getenv(client.EnvOverrideCertPath))
+	assert.Equal(t, "1", os.Getenv(client.EnvTLSVerify))
+}
+
+func TestDialRemote_unsupportedScheme(t *testing.T) {
+	t.Setenv(client.EnvOverrideHost, "ftp://192.168.1.20")
+
+	_, err := DialRemote(context.Background())
+	assert.ErrorContains(t, err, "unsupported Docker host scheme")
+}
+
+func TestCommandConn_deadline(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the pipes have no deadlines on Windows")
+	}
+
+	if _, err := exec.LookPath("cat"); err != nil {
+		t.Skip("cat is not available")
+	}
+
+	conn, err := newCommandConn(exec.Command("cat"))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+
+	b := make([]byte, 4)
+	_, err = conn.Read(b)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(b))
+
+	// A read waiting for data the command never sends times out
+	require.NoError(t, conn.SetDeadline(time.Now().Add(10*time.Millisecond)))
+
+	_, err = conn.Read(b)
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+}
@@ -0,0 +1,88 @@
+package docker
+
+import (
+	"context"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+)
+
+// ResourceKind is the kind of a Docker resource
+type ResourceKind string
+
+const (
+	ResourceContainer ResourceKind = "container"
+	ResourceNetwork   ResourceKind = "network"
+	ResourceVolume    ResourceKind = "volume"
+)
+
+// LabeledResource is a container, network or volume matching a label filter
+type LabeledResource struct {
+	Kind   ResourceKind
+	ID     string
+	Name   string
+	Labels map[string]string
+}
+
+// GetLabeledResources returns the containers, networks and volumes matching the label filter,
+// containers first so that they can be removed before the networks and volumes they use
+func GetLabeledResources(label string) (resources []LabeledResource, err error) {
+	args := filters.NewArgs(filters.KeyValuePair{Key: "label", Value: label})
+
+	err = withCli(func(cli *client.Client) error {
+		containers, err := cli.ContainerList(context.Background(), container.ListOptions{All: true, Filters: args})
+		if err != nil {
+			return err
+		}
+
+		for _, c := range containers {
+			name := c.ID
+			if len(c.Names) > 0 {
+				name = c.Names[0]
+			}
+
+			resources = append(resources, LabeledResource{Kind: ResourceContainer, ID: c.ID, Name: name, Labels: c.Labels})
+		}
+
+		networks, err := cli.NetworkList(context.Background(), types.NetworkListOptions{Filters: args})
+		if err != nil {
+			return err
+		}
+
+		for _, n := range networks {
+			resources = append(resources, LabeledResource{Kind: ResourceNetwork, ID: n.ID, Name: n.Name, Labels: n.Labels})
+		}
+
+		volumes, err := cli.VolumeList(context.Background(), volume.ListOptions{Filters: args})
+		if err != nil {
+			return err
+		}
+
+		for _, v := range volumes.Volumes {
+			resources = append(resources, LabeledResource{Kind: ResourceVolume, ID: v.Name, Name: v.Name, Labels: v.Labels})
+		}
+
+		return nil
+	})
+
+	return resources, err
+}
+
+// RemoveResource removes a container, network or volume, containers are stopped first
+func RemoveResource(resource LabeledResource) error {
+	return withCli(func(cli *client.Client) error {
+		switch resource.Kind {
+		case ResourceContainer:
+			return cli.ContainerRemove(context.Background(), resource.ID, container.RemoveOptions{Force: true})
+		case ResourceNetwork:
+			return cli.NetworkRemove(context.Background(), resource.ID)
+		case ResourceVolume:
+			return cli.VolumeRemove(context.Background(), resource.ID, false)
+		}
+
+		return nil
+	})
+}
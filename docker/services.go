@@ -0,0 +1,41 @@
+package docker
+
+import (
+	"context"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+)
+
+func GetServicesWithLabel(value string) (r []swarm.Service, err error) {
+	err = withCli(func(cli *client.Client) error {
+		r, err = cli.ServiceList(context.Background(), types.ServiceListOptions{
+			Filters: filters.NewArgs(filters.KeyValuePair{
+				Key:   "label",
+				Value: value,
+			}),
+		})
+
+		return err
+	})
+
+	return r, err
+}
+
+// ServiceForceUpdate redeploys the tasks of a service without changing its specification
+func ServiceForceUpdate(serviceID string) error {
+	return withCli(func(cli *client.Client) error {
+		service, _, err := cli.ServiceInspectWithRaw(context.Background(), serviceID, types.ServiceInspectOptions{})
+		if err != nil {
+			return err
+		}
+
+		service.Spec.TaskTemplate.ForceUpdate++
+
+		_, err = cli.ServiceUpdate(context.Background(), serviceID, service.Version, service.Spec, types.ServiceUpdateOptions{})
+
+		return err
+	})
+}
@@ -15,6 +15,17 @@ import (
 )
 
 func CreateSnapshot() (*portainer.DockerSnapshot, error) {
+	return createSnapshot(false)
+}
+
+// CreateMinimalSnapshot returns the counts and the engine information of the snapshot, without the raw containers,
+// images, volumes and networks. The containers are not inspected, which saves the memory and the engine calls on the
+// constrained devices.
+func CreateMinimalSnapshot() (*portainer.DockerSnapshot, error) {
+	return createSnapshot(true)
+}
+
+func createSnapshot(minimal bool) (*portainer.DockerSnapshot, error) {
 	cli, err := NewClient()
 	if err != nil {
 		return nil, err
@@ -47,7 +58,7 @@ func CreateSnapshot() (*portainer.DockerSnapshot, error) {
 		}
 	}
 
-	err = snapshotContainers(snapshot, cli)
+	err = snapshotContainers(snapshot, cli, !minimal)
 	if err != nil {
 		log.Warn().Err(err).Msg("unable to snapshot containers")
 	}
@@ -62,9 +73,15 @@ func CreateSnapshot() (*portainer.DockerSnapshot, error) {
 		log.Warn().Err(err).Msg("unable to snapshot volumes")
 	}
 
-	err = snapshotNetworks(snapshot, cli)
-	if err != nil {
-		log.Warn().Err(err).Msg("unable to snapshot networks")
+	if minimal {
+		snapshot.SnapshotRaw.Containers = nil
+		snapshot.SnapshotRaw.Images = nil
+		snapshot.SnapshotRaw.Volumes = volume.ListResponse{}
+	} else {
+		err = snapshotNetworks(snapshot, cli)
+		if err != nil {
+			log.Warn().Err(err).Msg("unable to snapshot networks")
+		}
 	}
 
 	err = snapshotVersion(snapshot, cli)
@@ -132,7 +149,8 @@ func snapshotSwarmServices(snapshot *portainer.DockerSnapshot, cli *client.Clien
 	return nil
 }
 
-func snapshotContainers(snapshot *portainer.DockerSnapshot, cli *client.Client) error {
+// snapshotContainers snapshots the containers, their environment is only read when inspect is true
+func snapshotContainers(snapshot *portainer.DockerSnapshot, cli *client.Client, inspect bool) error {
 	rawContainers, err := cli.ContainerList(context.Background(), container.ListOptions{All: true})
 	if err != nil {
 		return err
@@ -147,6 +165,12 @@ func snapshotContainers(snapshot *portainer.DockerSnapshot, cli *client.Client)
 	containers := make([]portainer.DockerContainerSnapshot, 0)
 
 	for _, container := range rawContainers {
+		if !inspect {
+			containers = append(containers, portainer.DockerContainerSnapshot{Container: container})
+
+			continue
+		}
+
 		response, err := cli.ContainerInspect(context.Background(), container.ID)
 		if err != nil {
 			log.Warn().Err(err).Msg("failed to retrieve env for container " + container.ID + ". Skipping.")
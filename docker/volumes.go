@@ -1,13 +1,166 @@
 package docker
 
 import (
+	"compress/gzip"
 	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"time"
 
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/client"
 )
 
+const (
+	// volumeArchiveMountPoint is where the archived volume is mounted in the helper container
+	volumeArchiveMountPoint = "/volume"
+
+	// anonymousVolumeLabel is set by the engine on the volumes it creates without a name
+	anonymousVolumeLabel = "com.docker.volume.anonymous"
+)
+
 func VolumeDelete(name string, force bool) error {
 	return withCli(func(cli *client.Client) error {
 		return cli.VolumeRemove(context.Background(), name, force)
 	})
 }
+
+// GetAnonymousVolumes returns the anonymous volumes mounted by the containers matching the label filter.
+// The engines older than 23.0 do not label the anonymous volumes, their random 64 hexadecimal characters name is used instead.
+func GetAnonymousVolumes(label string) ([]string, error) {
+	containers, err := GetContainersWithLabel(label)
+	if err != nil {
+		return nil, err
+	}
+
+	var volumes []string
+	seen := make(map[string]struct{})
+
+	err = withCli(func(cli *client.Client) error {
+		for _, c := range containers {
+			for _, m := range c.Mounts {
+				if m.Type != mount.TypeVolume || m.Name == "" {
+					continue
+				}
+
+				if _, ok := seen[m.Name]; ok {
+					continue
+				}
+
+				seen[m.Name] = struct{}{}
+
+				v, err := cli.VolumeInspect(context.Background(), m.Name)
+				if err != nil {
+					return err
+				}
+
+				if _, ok := v.Labels[anonymousVolumeLabel]; ok || (len(v.Labels) == 0 && isRandomVolumeName(v.Name)) {
+					volumes = append(volumes, v.Name)
+				}
+			}
+		}
+
+		return nil
+	})
+
+	return volumes, err
+}
+
+func isRandomVolumeName(name string) bool {
+	if len(name) != 64 {
+		return false
+	}
+
+	_, err := hex.DecodeString(name)
+
+	return err == nil
+}
+
+// ArchiveVolume writes the content of the volume to w as a gzip compressed tarball. The volume is read through
+// a helper container created from the unpacker image, which is never started.
+func ArchiveVolume(name string, w io.Writer) error {
+	if err := pullUnpackerImage(); err != nil {
+		return err
+	}
+
+	return withCli(func(cli *client.Client) error {
+		ctx := context.Background()
+
+		helper, err := cli.ContainerCreate(ctx,
+			&container.Config{Image: getUnpackerImage()},
+			&container.HostConfig{Binds: []string{name + ":" + volumeArchiveMountPoint + ":ro"}},
+			nil, nil, "",
+		)
+		if err != nil {
+			return err
+		}
+
+		defer cli.ContainerRemove(ctx, helper.ID, container.RemoveOptions{Force: true})
+
+		reader, _, err := cli.CopyFromContainer(ctx, helper.ID, volumeArchiveMountPoint+"/.")
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+
+		gz := gzip.NewWriter(w)
+		if _, err := io.Copy(gz, reader); err != nil {
+			return err
+		}
+
+		return gz.Close()
+	})
+}
+
+// RunContainer runs a one-off container until it exits, it is killed when the timeout elapses. It returns an error
+// when the container exits with a non-zero code, the container is removed afterwards.
+func RunContainer(image string, cmd, env, binds []string, timeout time.Duration) error {
+	reader, err := ImagePull(image, types.ImagePullOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to pull the image %s: %w", image, err)
+	}
+
+	_, _ = io.Copy(io.Discard, reader)
+	reader.Close()
+
+	return withCli(func(cli *client.Client) error {
+		cli.HTTPClient().Timeout = largeClientTimeout
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		created, err := cli.ContainerCreate(ctx,
+			&container.Config{Image: image, Cmd: cmd, Env: env},
+			&container.HostConfig{Binds: binds},
+			nil, nil, "",
+		)
+		if err != nil {
+			return err
+		}
+
+		defer cli.ContainerRemove(context.Background(), created.ID, container.RemoveOptions{Force: true})
+
+		if err := cli.ContainerStart(ctx, created.ID, container.StartOptions{}); err != nil {
+			return err
+		}
+
+		statusCh, errCh := cli.ContainerWait(ctx, created.ID, container.WaitConditionNotRunning)
+		select {
+		case err := <-errCh:
+			if ctx.Err() != nil {
+				return fmt.Errorf("the container did not exit within %s", timeout)
+			}
+
+			return err
+		case status := <-statusCh:
+			if status.StatusCode != 0 {
+				return fmt.Errorf("the container exited with code %d", status.StatusCode)
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,74 @@
+package attestation
+
+import (
+	"crypto/sha256"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/portainer/agent"
+)
+
+// The types of the evidence, set as the mode of the attestation of the device
+const (
+	TypeOff         = "off"
+	TypeTPM         = "tpm"
+	TypeCertificate = "certificate"
+)
+
+// Evidence proves the identity of the hardware of the device during its enrollment. The server checks the
+// certificates against the CAs of the known hardware batches, e.g. the EK CA of a TPM vendor or the CA of the device
+// certificates provisioned in the secure elements at manufacturing, and the signature of the challenge with the
+// public key, so that the devices of a batch are approved without sharing a generic Edge key.
+type Evidence struct {
+	Type string `json:"type"`
+	// Certificates is the PEM certificate chain of the device, the EK certificate of a TPM or the device certificate
+	// of a secure element followed by its intermediates
+	Certificates string `json:"certificates"`
+	// PublicKey is the PEM public key the challenge is signed with, the attestation key of a TPM
+	PublicKey string `json:"publicKey,omitempty"`
+	// Nonce is the challenge of the server, as returned by the server
+	Nonce string `json:"nonce"`
+	// Quote is the base64 encoded TPMS_ATTEST structure of the TPM quote, signed along with the PCRs
+	Quote string `json:"quote,omitempty"`
+	// PCRs is the base64 encoded values of the PCRs of the quote
+	PCRs string `json:"pcrs,omitempty"`
+	// Signature is the base64 encoded signature of the challenge digest, or of the quote of a TPM
+	Signature string `json:"signature"`
+}
+
+// Provider attests the identity of the hardware of the device
+type Provider interface {
+	// Attest returns the evidence answering the challenge of the server for the given Edge ID
+	Attest(nonce, edgeID string) (*Evidence, error)
+}
+
+// NewProvider returns the provider of the attestation mode of the agent, nil when the attestation is off
+func NewProvider(options *agent.Options) (Provider, error) {
+	switch options.EdgeAttestation {
+	case "", TypeOff:
+		return nil, nil
+	case TypeTPM:
+		return NewTPMProvider(options.EdgeAttestationTPMHandle), nil
+	case TypeCertificate:
+		return NewCertificateProvider(options.EdgeAttestationCert, options.EdgeAttestationKey)
+	}
+
+	return nil, fmt.Errorf("unsupported attestation mode %q", options.EdgeAttestation)
+}
+
+// challengeDigest binds the challenge of the server to the Edge ID of the device, so that the evidence cannot be
+// replayed for another device
+func challengeDigest(nonce, edgeID string) []byte {
+	digest := sha256.Sum256([]byte(nonce + "|" + edgeID))
+
+	return digest[:]
+}
+
+func encodePEM(blockType string, der ...[]byte) string {
+	var data []byte
+	for _, block := range der {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: block})...)
+	}
+
+	return string(data)
+}
@@ -0,0 +1,145 @@
+package attestation
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newDeviceCertificate(t *testing.T) (*ecdsa.PrivateKey, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "device-1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	return key, der
+}
+
+func TestCertificateProvider_Attest(t *testing.T) {
+	key, der := newDeviceCertificate(t)
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "device.crt"), filepath.Join(dir, "device.key")
+	require.NoError(t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600))
+
+	provider, err := NewCertificateProvider(certPath, keyPath)
+	require.NoError(t, err)
+
+	evidence, err := provider.Attest("nonce", "device-1")
+	require.NoError(t, err)
+
+	assert.Equal(t, TypeCertificate, evidence.Type)
+	assert.Equal(t, "nonce", evidence.Nonce)
+
+	block, _ := pem.Decode([]byte(evidence.Certificates))
+	require.NotNil(t, block)
+	assert.Equal(t, der, block.Bytes)
+
+	signature, err := base64.StdEncoding.DecodeString(evidence.Signature)
+	require.NoError(t, err)
+
+	digest := sha256.Sum256([]byte("nonce|device-1"))
+	assert.True(t, ecdsa.VerifyASN1(&key.PublicKey, digest[:], signature))
+
+	// The evidence is bound to the Edge ID of the device
+	assert.False(t, ecdsa.VerifyASN1(&key.PublicKey, challengeDigest("nonce", "device-2"), signature))
+}
+
+func TestNewCertificateProvider_keyMismatch(t *testing.T) {
+	_, der := newDeviceCertificate(t)
+	otherKey, _ := newDeviceCertificate(t)
+
+	keyDER, err := x509.MarshalECPrivateKey(otherKey)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "device.crt"), filepath.Join(dir, "device.key")
+	require.NoError(t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+
+	_, err = NewCertificateProvider(certPath, keyPath)
+	assert.Error(t, err)
+}
+
+func TestTPMProvider_Attest(t *testing.T) {
+	_, ekCertificate := newDeviceCertificate(t)
+
+	var quoteArgs []string
+	provider := NewTPMProvider("0x81010002")
+	provider.run = func(ctx context.Context, binary string, args []string) error {
+		output := func(flag string) string {
+			for i := range args[:len(args)-1] {
+				if args[i] == flag {
+					return args[i+1]
+				}
+			}
+
+			return ""
+		}
+
+		switch binary {
+		case "tpm2_nvread":
+			// Only the ECC EK certificate is provisioned, padded with zeros
+			if args[len(args)-1] != ekCertificateECCIndex {
+				return errors.New("the index is not defined")
+			}
+
+			return os.WriteFile(output("-o"), append(ekCertificate, 0, 0, 0), 0600)
+		case "tpm2_readpublic":
+			return os.WriteFile(output("-o"), []byte("-----BEGIN PUBLIC KEY-----\n"), 0600)
+		case "tpm2_quote":
+			quoteArgs = args
+
+			for flag, content := range map[string]string{"-m": "quote", "-s": "signature", "-o": "pcrs"} {
+				if err := os.WriteFile(output(flag), []byte(content), 0600); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}
+
+		return errors.New("unexpected command")
+	}
+
+	evidence, err := provider.Attest("nonce", "device-1")
+	require.NoError(t, err)
+
+	assert.Equal(t, TypeTPM, evidence.Type)
+	assert.Equal(t, encodePEM("CERTIFICATE", ekCertificate), evidence.Certificates)
+	assert.Equal(t, "-----BEGIN PUBLIC KEY-----\n", evidence.PublicKey)
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("quote")), evidence.Quote)
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("signature")), evidence.Signature)
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("pcrs")), evidence.PCRs)
+
+	assert.Contains(t, quoteArgs, hex.EncodeToString(challengeDigest("nonce", "device-1")))
+	assert.Contains(t, quoteArgs, "0x81010002")
+}
@@ -0,0 +1,148 @@
+package attestation
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// CertificateProvider attests the device with the certificate provisioned at manufacturing, e.g. by the secure
+// element of the device, and its private key
+type CertificateProvider struct {
+	certificates [][]byte
+	signer       crypto.Signer
+}
+
+// NewCertificateProvider returns a pointer to a new instance of CertificateProvider, certPath is the PEM certificate
+// chain of the device and keyPath its PEM private key
+func NewCertificateProvider(certPath, keyPath string) (*CertificateProvider, error) {
+	if certPath == "" || keyPath == "" {
+		return nil, errors.New("the certificate and the private key of the device are required")
+	}
+
+	certificates, err := readCertificates(certPath)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := readPrivateKey(keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	leaf, err := x509.ParseCertificate(certificates[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid device certificate: %w", err)
+	}
+
+	if !publicKeyEqual(leaf.PublicKey, signer.Public()) {
+		return nil, errors.New("the private key does not match the device certificate")
+	}
+
+	return &CertificateProvider{certificates: certificates, signer: signer}, nil
+}
+
+// Attest signs the digest of the challenge with the private key of the device
+func (provider *CertificateProvider) Attest(nonce, edgeID string) (*Evidence, error) {
+	digest := challengeDigest(nonce, edgeID)
+
+	var signature []byte
+	var err error
+
+	if _, ok := provider.signer.(ed25519.PrivateKey); ok {
+		signature, err = provider.signer.Sign(rand.Reader, digest, crypto.Hash(0))
+	} else {
+		signature, err = provider.signer.Sign(rand.Reader, digest, crypto.SHA256)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to sign the challenge: %w", err)
+	}
+
+	return &Evidence{
+		Type:         TypeCertificate,
+		Certificates: encodePEM("CERTIFICATE", provider.certificates...),
+		Nonce:        nonce,
+		Signature:    base64.StdEncoding.EncodeToString(signature),
+	}, nil
+}
+
+func readCertificates(path string) ([][]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var certificates [][]byte
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		if block.Type == "CERTIFICATE" {
+			certificates = append(certificates, block.Bytes)
+		}
+	}
+
+	if len(certificates) == 0 {
+		return nil, fmt.Errorf("no certificate found in %s", path)
+	}
+
+	return certificates, nil
+}
+
+func readPrivateKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no private key found in %s", path)
+	}
+
+	var key any
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %w", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+
+	return signer, nil
+}
+
+func publicKeyEqual(a, b crypto.PublicKey) bool {
+	switch key := a.(type) {
+	case *ecdsa.PublicKey:
+		return key.Equal(b)
+	case *rsa.PublicKey:
+		return key.Equal(b)
+	case ed25519.PublicKey:
+		return key.Equal(b)
+	}
+
+	return false
+}
@@ -0,0 +1,140 @@
+package attestation
+
+import (
+	"bytes"
+	"context"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The NV indexes of the EK certificates provisioned by the TPM vendors, RSA 2048 and ECC NIST P256
+const (
+	ekCertificateRSAIndex = "0x01c00002"
+	ekCertificateECCIndex = "0x01c0000a"
+)
+
+// quotePCRs are the PCRs of the boot chain of the device included in the quote
+const quotePCRs = "sha256:0,1,2,3,4,5,6,7"
+
+// tpmTimeout bounds each command run on the TPM
+const tpmTimeout = 30 * time.Second
+
+// TPMProvider attests the device with its TPM, through the tpm2-tools binaries. The challenge is answered with a
+// quote of the attestation key persisted at the given handle, the server checks the EK certificate of the TPM against
+// the CAs of the TPM vendors and that the attestation key belongs to the TPM.
+type TPMProvider struct {
+	handle string
+	run    func(ctx context.Context, binary string, args []string) error
+}
+
+// NewTPMProvider returns a pointer to a new instance of TPMProvider, handle is the persistent handle of the
+// attestation key, e.g. 0x81010002
+func NewTPMProvider(handle string) *TPMProvider {
+	return &TPMProvider{handle: handle, run: runTPM}
+}
+
+// Attest quotes the PCRs of the TPM with the digest of the challenge as qualifying data
+func (provider *TPMProvider) Attest(nonce, edgeID string) (*Evidence, error) {
+	dir, err := os.MkdirTemp("", "attestation")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithTimeout(context.Background(), tpmTimeout)
+	defer cancel()
+
+	ekCertificate, err := provider.readEKCertificate(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKeyPath := filepath.Join(dir, "ak.pem")
+	if err := provider.run(ctx, "tpm2_readpublic", []string{"-c", provider.handle, "-f", "pem", "-o", publicKeyPath}); err != nil {
+		return nil, fmt.Errorf("unable to read the attestation key: %w", err)
+	}
+
+	quotePath, signaturePath, pcrsPath := filepath.Join(dir, "quote.msg"), filepath.Join(dir, "quote.sig"), filepath.Join(dir, "quote.pcrs")
+
+	args := []string{
+		"-c", provider.handle,
+		"-l", quotePCRs,
+		"-q", hex.EncodeToString(challengeDigest(nonce, edgeID)),
+		"-g", "sha256",
+		"-m", quotePath,
+		"-s", signaturePath,
+		"-o", pcrsPath,
+	}
+
+	if err := provider.run(ctx, "tpm2_quote", args); err != nil {
+		return nil, fmt.Errorf("unable to quote the PCRs: %w", err)
+	}
+
+	files := make(map[string][]byte)
+	for _, path := range []string{publicKeyPath, quotePath, signaturePath, pcrsPath} {
+		if files[path], err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Evidence{
+		Type:         TypeTPM,
+		Certificates: encodePEM("CERTIFICATE", ekCertificate),
+		PublicKey:    string(files[publicKeyPath]),
+		Nonce:        nonce,
+		Quote:        base64.StdEncoding.EncodeToString(files[quotePath]),
+		PCRs:         base64.StdEncoding.EncodeToString(files[pcrsPath]),
+		Signature:    base64.StdEncoding.EncodeToString(files[signaturePath]),
+	}, nil
+}
+
+// readEKCertificate returns the DER EK certificate of the TPM, the RSA certificate or the ECC one
+func (provider *TPMProvider) readEKCertificate(ctx context.Context, dir string) ([]byte, error) {
+	path := filepath.Join(dir, "ek.der")
+
+	var err error
+	for _, index := range []string{ekCertificateRSAIndex, ekCertificateECCIndex} {
+		if err = provider.run(ctx, "tpm2_nvread", []string{"-o", path, index}); err != nil {
+			continue
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		return trimCertificate(data)
+	}
+
+	return nil, fmt.Errorf("unable to read the EK certificate of the TPM: %w", err)
+}
+
+// trimCertificate removes the padding some vendors store after the certificate in the NV index
+func trimCertificate(data []byte) ([]byte, error) {
+	rest, err := asn1.Unmarshal(data, &asn1.RawValue{})
+	if err != nil {
+		return nil, fmt.Errorf("invalid EK certificate: %w", err)
+	}
+
+	return data[:len(data)-len(rest)], nil
+}
+
+func runTPM(ctx context.Context, binary string, args []string) error {
+	cmd := exec.CommandContext(ctx, binary, args...)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
@@ -0,0 +1,322 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/portainer/agent"
+	"github.com/portainer/agent/edge/stack"
+	agentfs "github.com/portainer/agent/filesystem"
+)
+
+const (
+	// Export writes the state of the device to an archive
+	Export = "export"
+	// Import restores the state exported from another device
+	Import = "import"
+)
+
+// formatVersion is the version of the layout of the archives, the archives of another version are refused
+const formatVersion = 1
+
+// maxImportSize is the maximum size of the files extracted from an archive, so that a malformed archive
+// cannot fill the disk of the device
+const maxImportSize = 256 << 20
+
+const (
+	manifestFileName = "manifest.json"
+	dataFolder       = "data"
+	stacksFolder     = "stacks"
+)
+
+// dataFiles are the files of the data folder exported along with the stacks, the label overrides and the freeze
+// windows set on the device. The state specific to the device, such as its keys, is left out.
+var dataFiles = []string{"labels.json", "freeze.json"}
+
+// ErrInvalidArchive is returned when an archive cannot be imported
+var ErrInvalidArchive = errors.New("invalid backup archive")
+
+// Source is the state of the device exported to the archives
+type Source struct {
+	DataPath string
+	// StacksPath is the folder the files of the Edge stacks are written to, along with their retained versions
+	StacksPath string
+	EdgeID     string
+	// Settings are the settings of the agent indexed by environment variable, with their secrets redacted
+	Settings map[string]string
+}
+
+// Manifest describes the content of an archive
+type Manifest struct {
+	FormatVersion int               `json:"formatVersion"`
+	CreatedAt     int64             `json:"createdAt"`
+	AgentVersion  string            `json:"agentVersion"`
+	EdgeID        string            `json:"edgeId"`
+	Settings      map[string]string `json:"settings"`
+	Stacks        []Stack           `json:"stacks"`
+}
+
+// Stack is an Edge stack of an archive with its retained versions, the most recently deployed first
+type Stack struct {
+	ID       int                          `json:"id"`
+	Name     string                       `json:"name"`
+	Versions []stack.StackVersionMetadata `json:"versions"`
+}
+
+// Write exports the retained versions of the stacks, the data files and the settings of the device to a gzipped
+// tar archive. The stacks whose versions are not retained cannot be exported.
+func Write(w io.Writer, source Source, now time.Time) (*Manifest, error) {
+	manifest := &Manifest{
+		FormatVersion: formatVersion,
+		CreatedAt:     now.Unix(),
+		AgentVersion:  agent.Version,
+		EdgeID:        source.EdgeID,
+		Settings:      source.Settings,
+	}
+
+	stacks, err := listStacks(source.StacksPath)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest.Stacks = stacks
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeFile(tw, manifestFileName, data, 0600, now); err != nil {
+		return nil, err
+	}
+
+	for _, name := range dataFiles {
+		data, err := os.ReadFile(filepath.Join(source.DataPath, name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		if err := writeFile(tw, path.Join(dataFolder, name), data, 0600, now); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range stacks {
+		historyFolder := stack.HistoryStackFileFolder(filepath.Join(source.StacksPath, strconv.Itoa(s.ID)))
+
+		for _, version := range s.Versions {
+			if err := writeFolder(tw, filepath.Join(historyFolder, strconv.Itoa(version.Version)), path.Join(stacksFolder, strconv.Itoa(s.ID), strconv.Itoa(version.Version))); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// Read imports an archive written by Write into the data folder and the stacks folder of the device. The retained
+// versions of the stacks are restored so that they can be rolled back to once the stacks are deployed again by the
+// server, the settings of the manifest are returned to configure the agent with.
+func Read(r io.Reader, dataPath, stacksPath string) (*Manifest, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+	}
+	defer gr.Close()
+
+	tr := tar.NewReader(io.LimitReader(gr, maxImportSize))
+
+	var manifest *Manifest
+
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := header.Name
+
+		// The manifest is written first, so that the archives of another version are refused before being extracted
+		if manifest == nil {
+			if name != manifestFileName {
+				return nil, fmt.Errorf("%w: the archive does not start with its manifest", ErrInvalidArchive)
+			}
+
+			manifest = &Manifest{}
+			if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+			}
+
+			if manifest.FormatVersion != formatVersion {
+				return nil, fmt.Errorf("%w: unsupported format version %d", ErrInvalidArchive, manifest.FormatVersion)
+			}
+
+			continue
+		}
+
+		destination, err := extractPath(name, dataPath, stacksPath)
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+		}
+
+		if err := agentfs.WriteFile(filepath.Dir(destination), filepath.Base(destination), data, uint32(header.Mode&0755)); err != nil {
+			return nil, err
+		}
+	}
+
+	if manifest == nil {
+		return nil, fmt.Errorf("%w: the archive is empty", ErrInvalidArchive)
+	}
+
+	return manifest, nil
+}
+
+// extractPath returns where a file of an archive is extracted to, only the data files and the files of the retained
+// versions of the stacks are extracted
+func extractPath(name, dataPath, stacksPath string) (string, error) {
+	if path.IsAbs(name) || path.Clean(name) != name || strings.HasPrefix(name, "../") {
+		return "", fmt.Errorf("%w: %s is not a clean relative path", ErrInvalidArchive, name)
+	}
+
+	parts := strings.SplitN(name, "/", 4)
+
+	switch {
+	case len(parts) == 2 && parts[0] == dataFolder:
+		for _, dataFile := range dataFiles {
+			if parts[1] == dataFile {
+				return filepath.Join(dataPath, dataFile), nil
+			}
+		}
+	case len(parts) == 4 && parts[0] == stacksFolder:
+		stackID, err := strconv.Atoi(parts[1])
+		if err != nil || stackID <= 0 {
+			break
+		}
+
+		version, err := strconv.Atoi(parts[2])
+		if err != nil || version <= 0 {
+			break
+		}
+
+		historyFolder := stack.HistoryStackFileFolder(filepath.Join(stacksPath, strconv.Itoa(stackID)))
+
+		return filepath.Join(historyFolder, strconv.Itoa(version), filepath.FromSlash(parts[3])), nil
+	}
+
+	return "", fmt.Errorf("%w: unexpected file %s", ErrInvalidArchive, name)
+}
+
+// listStacks returns the stacks whose versions are retained in the folder, sorted by identifier
+func listStacks(stacksPath string) ([]Stack, error) {
+	entries, err := os.ReadDir(stacksPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var stacks []Stack
+
+	for _, entry := range entries {
+		folder := strings.TrimSuffix(entry.Name(), stack.HistoryStackFileFolder(""))
+		if !entry.IsDir() || folder == entry.Name() {
+			continue
+		}
+
+		stackID, err := strconv.Atoi(folder)
+		if err != nil {
+			continue
+		}
+
+		versions, err := stack.ListStackHistory(filepath.Join(stacksPath, folder))
+		if err != nil {
+			return nil, err
+		}
+
+		if len(versions) == 0 {
+			continue
+		}
+
+		stacks = append(stacks, Stack{ID: stackID, Name: versions[0].Name, Versions: versions})
+	}
+
+	sort.Slice(stacks, func(i, j int) bool { return stacks[i].ID < stacks[j].ID })
+
+	return stacks, nil
+}
+
+func writeFolder(tw *tar.Writer, folder, prefix string) error {
+	return filepath.WalkDir(folder, func(p string, entry os.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+
+		rel, err := filepath.Rel(folder, p)
+		if err != nil {
+			return err
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		return writeFile(tw, path.Join(prefix, filepath.ToSlash(rel)), data, int64(info.Mode().Perm()), info.ModTime())
+	})
+}
+
+func writeFile(tw *tar.Writer, name string, data []byte, mode int64, modTime time.Time) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Mode:     mode,
+		Size:     int64(len(data)),
+		ModTime:  modTime,
+		Typeflag: tar.TypeReg,
+	}); err != nil {
+		return err
+	}
+
+	_, err := tw.Write(data)
+
+	return err
+}
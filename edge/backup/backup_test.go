@@ -0,0 +1,117 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/portainer/agent/edge/stack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeHistory(t *testing.T, stacksPath string, stackID, version int, name string) {
+	t.Helper()
+
+	versionFolder := filepath.Join(stack.HistoryStackFileFolder(filepath.Join(stacksPath, strconv.Itoa(stackID))), strconv.Itoa(version))
+
+	require.NoError(t, os.MkdirAll(versionFolder, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(versionFolder, "docker-compose.yml"), []byte("services: {}\n"), 0644))
+
+	metadata, err := json.Marshal(stack.StackVersionMetadata{Version: version, DeployedAt: int64(version), Name: name, Env: map[string]string{"DB_PASSWORD": "<redacted>"}})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(versionFolder, "metadata.json"), metadata, 0644))
+}
+
+func TestWriteRead(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	source := Source{
+		DataPath:   t.TempDir(),
+		StacksPath: t.TempDir(),
+		EdgeID:     "edge-1",
+		Settings:   map[string]string{"EDGE_INSECURE_POLL": "true", "EDGE_KEY": "<redacted>"},
+	}
+
+	require.NoError(t, os.WriteFile(filepath.Join(source.DataPath, "labels.json"), []byte(`{"site":"paris"}`), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(source.DataPath, "agent_edge_key"), []byte("key"), 0600))
+
+	writeHistory(t, source.StacksPath, 1, 1, "web")
+	writeHistory(t, source.StacksPath, 1, 2, "web")
+	writeHistory(t, source.StacksPath, 3, 1, "db")
+
+	var archive bytes.Buffer
+
+	manifest, err := Write(&archive, source, now)
+	require.NoError(t, err)
+	require.Len(t, manifest.Stacks, 2)
+	assert.Equal(t, "web", manifest.Stacks[0].Name)
+	assert.Len(t, manifest.Stacks[0].Versions, 2)
+	assert.Equal(t, 3, manifest.Stacks[1].ID)
+
+	dataPath, stacksPath := t.TempDir(), t.TempDir()
+
+	imported, err := Read(&archive, dataPath, stacksPath)
+	require.NoError(t, err)
+	assert.Equal(t, manifest, imported)
+
+	labels, err := os.ReadFile(filepath.Join(dataPath, "labels.json"))
+	require.NoError(t, err)
+	assert.Equal(t, `{"site":"paris"}`, string(labels))
+
+	// The state specific to the device is not exported
+	assert.NoFileExists(t, filepath.Join(dataPath, "agent_edge_key"))
+
+	history, err := stack.ListStackHistory(filepath.Join(stacksPath, "1"))
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, 2, history[0].Version)
+	assert.Equal(t, map[string]string{"DB_PASSWORD": "<redacted>"}, history[0].Env)
+
+	assert.FileExists(t, filepath.Join(stack.HistoryStackFileFolder(filepath.Join(stacksPath, "3")), "1", "docker-compose.yml"))
+}
+
+func TestRead_invalidArchive(t *testing.T) {
+	archive := func(names ...string) *bytes.Buffer {
+		var b bytes.Buffer
+
+		gw := gzip.NewWriter(&b)
+		tw := tar.NewWriter(gw)
+
+		for _, name := range names {
+			data := []byte("{}")
+			if name == manifestFileName {
+				data = []byte(`{"formatVersion":1}`)
+			}
+
+			require.NoError(t, writeFile(tw, name, data, 0600, time.Now()))
+		}
+
+		require.NoError(t, tw.Close())
+		require.NoError(t, gw.Close())
+
+		return &b
+	}
+
+	for _, names := range [][]string{
+		{},
+		{"data/labels.json"},
+		{manifestFileName, "../etc/passwd"},
+		{manifestFileName, "/etc/passwd"},
+		{manifestFileName, "data/../../etc/passwd"},
+		{manifestFileName, "data/agent_edge_key"},
+		{manifestFileName, "stacks/1/../../../etc/passwd"},
+		{manifestFileName, "stacks/one/1/docker-compose.yml"},
+	} {
+		dataPath := t.TempDir()
+
+		_, err := Read(archive(names...), dataPath, t.TempDir())
+		assert.ErrorIs(t, err, ErrInvalidArchive, names)
+	}
+}
@@ -0,0 +1,176 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// FileStore keeps the blobs in a folder. Reading a blob marks it as used, the least recently used blobs are evicted
+// when the folder exceeds its maximum size.
+type FileStore struct {
+	path    string
+	maxSize int64
+	mu      sync.Mutex
+}
+
+// NewFileStore returns a pointer to a new instance of FileStore, maxSize is unlimited when 0
+func NewFileStore(path string, maxSize int64) (*FileStore, error) {
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return nil, err
+	}
+
+	return &FileStore{path: path, maxSize: maxSize}, nil
+}
+
+func (s *FileStore) blobPath(key string) (string, error) {
+	if !filepath.IsLocal(filepath.FromSlash(key)) {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+
+	return filepath.Join(s.path, filepath.FromSlash(key)), nil
+}
+
+func (s *FileStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.blobPath(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+
+	return file, nil
+}
+
+func (s *FileStore) Put(ctx context.Context, key string, content io.Reader, size int64) error {
+	if s.maxSize > 0 && size > s.maxSize {
+		return ErrTooLarge
+	}
+
+	path, err := s.blobPath(key)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	written, err := io.Copy(tmp, content)
+	if err != nil {
+		return err
+	}
+
+	if written != size {
+		return fmt.Errorf("read %d bytes of the blob instead of %d", written, size)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	return s.evict(path)
+}
+
+func (s *FileStore) Delete(ctx context.Context, key string) error {
+	path, err := s.blobPath(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+type storedBlob struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// evict removes the least recently used blobs until the folder fits its maximum size, the blob just stored is kept.
+// The caller must hold the lock.
+func (s *FileStore) evict(kept string) error {
+	if s.maxSize <= 0 {
+		return nil
+	}
+
+	var blobs []storedBlob
+	var total int64
+
+	err := filepath.WalkDir(s.path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		total += info.Size()
+		blobs = append(blobs, storedBlob{path: path, size: info.Size(), modTime: info.ModTime()})
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].modTime.Before(blobs[j].modTime) })
+
+	for _, blob := range blobs {
+		if total <= s.maxSize {
+			break
+		}
+
+		if blob.path == kept {
+			continue
+		}
+
+		if err := os.Remove(blob.path); err != nil {
+			return err
+		}
+
+		total -= blob.size
+
+		log.Debug().Str("path", blob.path).Int64("size", blob.size).Msg("blob evicted from the store")
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package blobstore
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func put(t *testing.T, store Store, key, content string) {
+	t.Helper()
+
+	require.NoError(t, store.Put(context.Background(), key, strings.NewReader(content), int64(len(content))))
+}
+
+func get(t *testing.T, store Store, key string) (string, error) {
+	t.Helper()
+
+	content, err := store.Get(context.Background(), key)
+	if err != nil {
+		return "", err
+	}
+	defer content.Close()
+
+	data, err := io.ReadAll(content)
+	require.NoError(t, err)
+
+	return string(data), nil
+}
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := NewFileStore(dir, 10)
+	require.NoError(t, err)
+
+	put(t, store, "payloads/1/a", "aaaa")
+	put(t, store, "payloads/1/b", "bbbb")
+
+	// a is used more recently than b
+	past := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "payloads", "1", "b"), past, past))
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "payloads", "1", "a"), past.Add(-time.Minute), past.Add(-time.Minute)))
+
+	content, err := get(t, store, "payloads/1/a")
+	require.NoError(t, err)
+	assert.Equal(t, "aaaa", content)
+
+	// The least recently used blob is evicted
+	put(t, store, "payloads/2/c", "cccc")
+
+	_, err = get(t, store, "payloads/1/b")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = get(t, store, "payloads/1/a")
+	assert.NoError(t, err)
+
+	assert.ErrorIs(t, store.Put(context.Background(), "large", strings.NewReader("too large blob"), 14), ErrTooLarge)
+	assert.Error(t, store.Put(context.Background(), "truncated", strings.NewReader("abc"), 4))
+	assert.Error(t, store.Put(context.Background(), "../escape", strings.NewReader("abc"), 3))
+
+	require.NoError(t, store.Delete(context.Background(), "payloads/1/a"))
+	require.NoError(t, store.Delete(context.Background(), "payloads/1/a"))
+
+	_, err = get(t, store, "payloads/1/a")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
@@ -0,0 +1,146 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/portainer/agent/crypto"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+const (
+	// s3DefaultRegion is the region the requests are signed for when none is configured, MinIO accepts it
+	s3DefaultRegion = "us-east-1"
+	// s3UnsignedPayload signs the requests without hashing their body, so that the blobs are streamed
+	s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+	s3HeaderTimeout   = 30 * time.Second
+)
+
+// S3Store keeps the blobs in an S3 compatible bucket, addressed in the path style so that any MinIO instance works
+// without a wildcard DNS record
+type S3Store struct {
+	baseURL     *url.URL
+	region      string
+	credentials aws.Credentials
+	signer      *v4.Signer
+	httpClient  *http.Client
+}
+
+// NewS3Store returns a pointer to a new instance of S3Store for the bucket of bucketURL, e.g.
+// https://minio.site.local:9000/edge-cache, the path following the bucket prefixes the keys
+func NewS3Store(bucketURL, region, accessKey, secretKey string) (*S3Store, error) {
+	baseURL, err := url.Parse(bucketURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL of the bucket: %w", err)
+	}
+
+	if baseURL.Scheme != "http" && baseURL.Scheme != "https" || baseURL.Host == "" || strings.Trim(baseURL.Path, "/") == "" {
+		return nil, fmt.Errorf("invalid URL of the bucket %q, expected http(s)://host/bucket[/prefix]", bucketURL)
+	}
+
+	if region == "" {
+		region = s3DefaultRegion
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = crypto.CreateTLSConfiguration()
+	transport.ResponseHeaderTimeout = s3HeaderTimeout
+
+	return &S3Store{
+		baseURL:     baseURL,
+		region:      region,
+		credentials: aws.Credentials{AccessKeyID: accessKey, SecretAccessKey: secretKey},
+		signer:      v4.NewSigner(),
+		httpClient:  &http.Client{Transport: transport},
+	}, nil
+}
+
+func (s *S3Store) do(ctx context.Context, method, key string, body io.Reader, size int64) (*http.Response, error) {
+	objectURL := *s.baseURL
+	objectURL.Path = strings.TrimSuffix(s.baseURL.Path, "/") + "/" + strings.TrimPrefix(key, "/")
+
+	req, err := http.NewRequestWithContext(ctx, method, objectURL.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req.ContentLength = size
+	}
+
+	if s.credentials.AccessKeyID != "" {
+		req.Header.Set("X-Amz-Content-Sha256", s3UnsignedPayload)
+
+		if err := s.signer.SignHTTP(ctx, s.credentials, req, s3UnsignedPayload, "s3", s.region, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.httpClient.Do(req)
+}
+
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+
+		return nil, ErrNotFound
+	}
+
+	return nil, s3Error(resp)
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, content io.Reader, size int64) error {
+	resp, err := s.do(ctx, http.MethodPut, key, content, size)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp)
+	}
+
+	resp.Body.Close()
+
+	return nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, 0)
+	if err != nil {
+		return err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		resp.Body.Close()
+
+		return nil
+	}
+
+	return s3Error(resp)
+}
+
+// s3Error returns the error of the response along with the message of the bucket, and closes its body
+func s3Error(resp *http.Response) error {
+	defer resp.Body.Close()
+
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	return errors.New(strings.TrimSpace(fmt.Sprintf("unexpected status code %d from the bucket %s", resp.StatusCode, message)))
+}
@@ -0,0 +1,75 @@
+package blobstore
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestS3Store(t *testing.T) {
+	var mu sync.Mutex
+	objects := map[string]string{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=access/") {
+			w.WriteHeader(http.StatusForbidden)
+
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPut:
+			body, _ := io.ReadAll(r.Body)
+			objects[r.URL.Path] = string(body)
+		case http.MethodGet:
+			object, ok := objects[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+
+				return
+			}
+
+			_, _ = w.Write([]byte(object))
+		case http.MethodDelete:
+			delete(objects, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	store, err := NewS3Store(server.URL+"/edge-cache/site-a", "", "access", "secret")
+	require.NoError(t, err)
+
+	put(t, store, "payloads/1/a", "aaaa")
+	assert.Equal(t, map[string]string{"/edge-cache/site-a/payloads/1/a": "aaaa"}, objects)
+
+	content, err := get(t, store, "payloads/1/a")
+	require.NoError(t, err)
+	assert.Equal(t, "aaaa", content)
+
+	_, err = get(t, store, "payloads/1/b")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, store.Delete(context.Background(), "payloads/1/a"))
+	assert.Empty(t, objects)
+
+	// Anonymous requests are refused by the bucket
+	anonymous, err := NewS3Store(server.URL+"/edge-cache", "", "", "")
+	require.NoError(t, err)
+
+	_, err = get(t, anonymous, "payloads/1/a")
+	assert.ErrorContains(t, err, "403")
+
+	_, err = NewS3Store(server.URL, "", "", "")
+	assert.Error(t, err)
+}
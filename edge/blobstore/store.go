@@ -0,0 +1,66 @@
+// Package blobstore keeps the payloads downloaded from the Portainer server so that they are not downloaded again,
+// in the data folder of the agent or in a bucket shared by the devices of a site.
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// BackendOff disables the store
+	BackendOff = "off"
+	// BackendFilesystem keeps the blobs in a folder, the least recently used ones are evicted beyond its maximum size
+	BackendFilesystem = "filesystem"
+	// BackendS3 keeps the blobs in an S3 compatible bucket, e.g. a MinIO instance acting as the cache of a site.
+	// The blobs are never evicted by the agent, the lifecycle rules of the bucket are expected to expire them.
+	BackendS3 = "s3"
+)
+
+var (
+	// ErrNotFound is returned when the blob is not in the store
+	ErrNotFound = errors.New("blob not found")
+	// ErrTooLarge is returned when the blob exceeds the maximum size of the store, it is not stored
+	ErrTooLarge = errors.New("blob larger than the store")
+)
+
+// Store keeps blobs indexed by key, the keys are slash-separated relative paths
+type Store interface {
+	// Get returns the content of a blob, ErrNotFound when it is not in the store
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Put stores the size bytes read from content as the blob, replacing the previous one
+	Put(ctx context.Context, key string, content io.Reader, size int64) error
+	// Delete removes a blob, it succeeds when the blob is not in the store
+	Delete(ctx context.Context, key string) error
+}
+
+// Config is used to create a Store
+type Config struct {
+	Backend string
+	// Path is the folder of the filesystem backend and MaxSize its maximum size in bytes, unlimited when 0
+	Path    string
+	MaxSize int64
+	// S3URL is the URL of the bucket of the s3 backend, in the path style and optionally followed by a prefix of
+	// the keys, e.g. https://minio.site.local:9000/edge-cache/portainer-a
+	S3URL    string
+	S3Region string
+	// S3AccessKey and S3SecretKey sign the requests to the bucket, they are sent anonymously when empty
+	S3AccessKey string
+	S3SecretKey string
+}
+
+// NewStore returns the store matching the configured backend, nil when it is disabled
+func NewStore(config Config) (Store, error) {
+	switch config.Backend {
+	case "", BackendOff:
+		return nil, nil
+	case BackendFilesystem:
+		return NewFileStore(config.Path, config.MaxSize)
+	case BackendS3:
+		return NewS3Store(config.S3URL, config.S3Region, config.S3AccessKey, config.S3SecretKey)
+	}
+
+	return nil, fmt.Errorf("unsupported blob store backend: %s", config.Backend)
+}
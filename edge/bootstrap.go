@@ -0,0 +1,424 @@
+package edge
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/portainer/agent"
+	"github.com/portainer/agent/edge/attestation"
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/agent/edge/labels"
+	"github.com/portainer/agent/filesystem"
+
+	"github.com/rs/zerolog/log"
+)
+
+// enrollmentClient is implemented by the Edge HTTP client
+type enrollmentClient interface {
+	EnrollmentChallenge(serverURL, edgeID string) (string, error)
+	Enroll(serverURL, token string, request client.EnrollmentRequest) (string, error)
+	Claim(serverURL, groupKey string, request client.EnrollmentRequest) (string, error)
+}
+
+// errAttestation is returned when the device cannot attest its hardware, the enrollment is not retried
+var errAttestation = errors.New("unable to attest the device")
+
+const (
+	// bootstrapTimeout is how long the agent tries to enroll on first boot, it waits for its key on its page
+	// afterwards and enrolls again on the next start
+	bootstrapTimeout = 10 * time.Minute
+	minEnrollDelay   = 5 * time.Second
+	maxEnrollDelay   = time.Minute
+)
+
+// BootstrapFile is the provisioning file baked into the device image, shared by the devices of a fleet for their
+// zero-touch enrollment
+type BootstrapFile struct {
+	// ServerURL is the URL of the Portainer server the device enrolls with, e.g. https://portainer.example.com
+	ServerURL string
+	// Token is the enrollment token exchanged for the Edge key of the device, it can be omitted when the device
+	// attests its hardware and the server approves its hardware batch
+	Token string
+	// GroupKey is the group enrollment key shared by the devices of a fleet, the device is registered in the pending
+	// claim pool of the key and receives its Edge key once its claim is approved on the server
+	GroupKey string
+	// EdgeID is the identifier of the device, a random identifier is generated when neither the provisioning file
+	// nor the EDGE_ID environment variable set it
+	EdgeID     string
+	Labels     map[string]string
+	HTTPProxy  string
+	HTTPSProxy string
+	NoProxy    []string
+}
+
+// bootstrapState keeps in the data folder the settings of the provisioning file the agent enrolled with, they are
+// applied on the next starts once the provisioning file is deleted. The enrollment token is not kept, only its hash
+// so that a provisioning file that could not be deleted is not used again.
+type bootstrapState struct {
+	EdgeID     string
+	HTTPProxy  string
+	HTTPSProxy string
+	NoProxy    []string
+	TokenHash  string
+	EnrolledAt int64
+	// ClaimedAt is when the device claimed its registration with the group enrollment key, while it is pending
+	ClaimedAt int64
+}
+
+// Bootstrap applies the provisioning file of the device, or the settings it left in the data folder once consumed.
+// On first boot, the agent enrolls itself with the enrollment token to obtain its Edge key, keeps the key and the
+// settings in the data folder, and deletes the provisioning file, or locks it when the image is read-only. With a group
+// enrollment key, ErrEnrollmentPending is returned while the claim of the device waits for its approval, the file is
+// kept and WaitForClaim polls the claim.
+func Bootstrap(options *agent.Options) error {
+	state, err := readBootstrapState(options.DataPath)
+	if err != nil {
+		return err
+	}
+
+	var file *BootstrapFile
+	if options.EdgeBootstrapFile != "" {
+		file, err = readBootstrapFile(options.EdgeBootstrapFile, attested(options))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	if file != nil && state != nil && state.TokenHash == credentialHash(file) {
+		log.Debug().Msg("the provisioning file was already used to enroll the agent")
+
+		lockBootstrapFile(options.EdgeBootstrapFile)
+
+		file = nil
+	}
+
+	if file == nil {
+		if state != nil {
+			applyBootstrapSettings(options, state)
+		}
+
+		return nil
+	}
+
+	newState := &bootstrapState{
+		EdgeID:     firstNonEmpty(options.EdgeID, file.EdgeID),
+		HTTPProxy:  file.HTTPProxy,
+		HTTPSProxy: file.HTTPSProxy,
+		NoProxy:    file.NoProxy,
+	}
+
+	if state != nil {
+		newState.EdgeID = firstNonEmpty(newState.EdgeID, state.EdgeID)
+		newState.ClaimedAt = state.ClaimedAt
+	}
+
+	if newState.EdgeID == "" {
+		if newState.EdgeID, err = newEdgeID(); err != nil {
+			return err
+		}
+	}
+
+	applyBootstrapSettings(options, newState)
+
+	// The generated Edge ID is kept for the next attempts when the enrollment fails
+	if err := writeBootstrapState(options.DataPath, newState); err != nil {
+		return err
+	}
+
+	keySet, err := edgeKeySet(options)
+	if err != nil {
+		return err
+	}
+
+	if keySet {
+		log.Info().Msg("an Edge key is already set, the provisioning file is not used to enroll the agent")
+	} else {
+		key, err := enroll(options, file, newState.EdgeID)
+		if errors.Is(err, client.ErrEnrollmentPending) && newState.ClaimedAt == 0 {
+			newState.ClaimedAt = time.Now().Unix()
+
+			if writeErr := writeBootstrapState(options.DataPath, newState); writeErr != nil {
+				return writeErr
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := filesystem.WriteFile(options.DataPath, agent.EdgeKeyFile, []byte(key), 0600); err != nil {
+			return err
+		}
+
+		options.EdgeKey = key
+		newState.EnrolledAt = time.Now().Unix()
+
+		log.Info().Str("edge_id", newState.EdgeID).Msg("agent enrolled with the provisioning file")
+	}
+
+	return completeBootstrap(options, file, newState)
+}
+
+// completeBootstrap keeps the labels and the settings of the provisioning file once the agent is enrolled, and
+// deletes the file
+func completeBootstrap(options *agent.Options, file *BootstrapFile, state *bootstrapState) error {
+	if len(file.Labels) > 0 {
+		if err := labels.UpdateOverrides(options.DataPath, file.Labels); err != nil {
+			return fmt.Errorf("unable to set the labels of the provisioning file: %w", err)
+		}
+	}
+
+	state.TokenHash = credentialHash(file)
+	state.ClaimedAt = 0
+	if err := writeBootstrapState(options.DataPath, state); err != nil {
+		return err
+	}
+
+	lockBootstrapFile(options.EdgeBootstrapFile)
+
+	return nil
+}
+
+func readBootstrapFile(path string, attested bool) (*BootstrapFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file BootstrapFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid provisioning file: %w", err)
+	}
+
+	if !strings.HasPrefix(file.ServerURL, "https://") && !strings.HasPrefix(file.ServerURL, "http://") {
+		return nil, fmt.Errorf("invalid server URL %q in the provisioning file", file.ServerURL)
+	}
+
+	if file.Token != "" && file.GroupKey != "" {
+		return nil, errors.New("only one of the enrollment token and the group enrollment key of the provisioning file can be set")
+	}
+
+	if file.Token == "" && file.GroupKey == "" && !attested {
+		return nil, errors.New("the enrollment token or the group enrollment key of the provisioning file is required without the attestation of the device")
+	}
+
+	for key := range file.Labels {
+		if _, _, err := labels.ParseLabel(key + "="); err != nil {
+			return nil, fmt.Errorf("invalid label in the provisioning file: %w", err)
+		}
+	}
+
+	return &file, nil
+}
+
+func readBootstrapState(dataPath string) (*bootstrapState, error) {
+	data, err := os.ReadFile(filepath.Join(dataPath, agent.EdgeBootstrapStateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var state bootstrapState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("invalid provisioning state: %w", err)
+	}
+
+	return &state, nil
+}
+
+func writeBootstrapState(dataPath string, state *bootstrapState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return filesystem.WriteFile(dataPath, agent.EdgeBootstrapStateFile, data, 0600)
+}
+
+// applyBootstrapSettings sets the Edge ID and the proxies of the provisioning file, the settings of the environment
+// take precedence. The proxies are also set in the environment for the requests sent to the server and the processes
+// run by the agent.
+func applyBootstrapSettings(options *agent.Options, state *bootstrapState) {
+	if options.EdgeID == "" {
+		options.EdgeID = state.EdgeID
+	}
+
+	if options.EdgeTunnelProxy == "" {
+		options.EdgeTunnelProxy = firstNonEmpty(state.HTTPSProxy, state.HTTPProxy)
+	}
+
+	if options.EdgeDeployHTTPProxy == "" {
+		options.EdgeDeployHTTPProxy = state.HTTPProxy
+	}
+
+	if options.EdgeDeployHTTPSProxy == "" {
+		options.EdgeDeployHTTPSProxy = state.HTTPSProxy
+	}
+
+	for _, host := range state.NoProxy {
+		if !slices.Contains(options.EdgeDeployNoProxy, host) {
+			options.EdgeDeployNoProxy = append(options.EdgeDeployNoProxy, host)
+		}
+	}
+
+	for name, value := range map[string]string{
+		"HTTP_PROXY":  state.HTTPProxy,
+		"HTTPS_PROXY": state.HTTPSProxy,
+		"NO_PROXY":    strings.Join(state.NoProxy, ","),
+	} {
+		if _, ok := os.LookupEnv(name); !ok && value != "" {
+			os.Setenv(name, value)
+		}
+	}
+}
+
+// enroll exchanges the enrollment token, or the attestation of the hardware of the device, for the Edge key of the
+// device, retrying while the server is unreachable
+func enroll(options *agent.Options, file *BootstrapFile, edgeID string) (string, error) {
+	provider, err := attestation.NewProvider(options)
+	if err != nil {
+		return "", fmt.Errorf("unable to attest the device: %w", err)
+	}
+
+	httpClient := client.BuildHTTPClient(30, options)
+
+	deadline := time.Now().Add(bootstrapTimeout)
+	delay := minEnrollDelay
+
+	for {
+		key, err := enrollOnce(httpClient, provider, file, edgeID)
+		if err == nil {
+			if _, err := ParseEdgeKey(key); err != nil {
+				return "", fmt.Errorf("invalid Edge key returned by the server: %w", err)
+			}
+
+			return key, nil
+		}
+
+		// A pending claim is polled by WaitForClaim, the agent starts meanwhile
+		if errors.Is(err, client.ErrEnrollmentPending) {
+			return "", err
+		}
+
+		if errors.Is(err, client.ErrEnrollmentRefused) || errors.Is(err, errAttestation) || time.Now().Add(delay).After(deadline) {
+			return "", fmt.Errorf("unable to enroll the agent: %w", err)
+		}
+
+		log.Warn().Err(err).Dur("retry_in", delay).Msg("unable to enroll the agent, retrying")
+
+		time.Sleep(delay)
+		delay = min(2*delay, maxEnrollDelay)
+	}
+}
+
+// enrollOnce sends the enrollment request, or the claim of the device with a group enrollment key, with the evidence
+// answering a new challenge of the server when the device attests its hardware
+func enrollOnce(httpClient enrollmentClient, provider attestation.Provider, file *BootstrapFile, edgeID string) (string, error) {
+	request := client.EnrollmentRequest{EdgeID: edgeID, Labels: file.Labels, Metadata: deviceMetadata()}
+
+	if provider != nil {
+		nonce, err := httpClient.EnrollmentChallenge(file.ServerURL, edgeID)
+		if err != nil {
+			return "", err
+		}
+
+		if request.Attestation, err = provider.Attest(nonce, edgeID); err != nil {
+			return "", fmt.Errorf("%w: %w", errAttestation, err)
+		}
+	}
+
+	if file.GroupKey != "" {
+		return httpClient.Claim(file.ServerURL, file.GroupKey, request)
+	}
+
+	return httpClient.Enroll(file.ServerURL, file.Token, request)
+}
+
+// deviceMetadata describes the device to the operator approving its claim
+func deviceMetadata() map[string]string {
+	metadata := map[string]string{
+		"os":           runtime.GOOS,
+		"arch":         runtime.GOARCH,
+		"agentVersion": agent.Version,
+	}
+
+	if hostname, err := os.Hostname(); err == nil {
+		metadata["hostname"] = hostname
+	}
+
+	return metadata
+}
+
+func attested(options *agent.Options) bool {
+	return options.EdgeAttestation != "" && options.EdgeAttestation != attestation.TypeOff
+}
+
+// lockBootstrapFile deletes the provisioning file so that its enrollment token does not stay on the device. The file
+// of a read-only image is made unreadable instead, it is ignored on the next starts as its token was used.
+func lockBootstrapFile(path string) {
+	err := os.Remove(path)
+	if err == nil || errors.Is(err, os.ErrNotExist) {
+		return
+	}
+
+	if chmodErr := os.Chmod(path, 0); chmodErr != nil {
+		log.Warn().Err(err).Str("path", path).Msg("unable to delete or lock the provisioning file, its enrollment token stays on the device")
+
+		return
+	}
+
+	log.Info().Str("path", path).Msg("the provisioning file cannot be deleted, it was locked")
+}
+
+func edgeKeySet(options *agent.Options) (bool, error) {
+	if options.EdgeKey != "" {
+		return true, nil
+	}
+
+	return filesystem.FileExists(filepath.Join(options.DataPath, agent.EdgeKeyFile))
+}
+
+// credentialHash is the hash of the enrollment token or of the group enrollment key of the provisioning file
+func credentialHash(file *BootstrapFile) string {
+	return tokenHash(firstNonEmpty(file.Token, file.GroupKey))
+}
+
+func tokenHash(token string) string {
+	hash := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(hash[:])
+}
+
+// newEdgeID returns a random identifier in the UUID format
+func newEdgeID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:]), nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
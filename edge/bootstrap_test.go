@@ -0,0 +1,217 @@
+package edge
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/portainer/agent"
+	"github.com/portainer/agent/edge/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBootstrap(t *testing.T) {
+	key := encodeKey(&edgeKey{PortainerInstanceURL: "https://portainer.example.com", TunnelServerAddr: "portainer.example.com:8000", EndpointID: 7})
+
+	var enrollment client.EnrollmentRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/endpoints/edge/enroll" || r.Header.Get("Authorization") != "Bearer s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&enrollment))
+		json.NewEncoder(w).Encode(map[string]string{"edgeKey": key})
+	}))
+	defer server.Close()
+
+	dataPath := t.TempDir()
+	bootstrapFile := filepath.Join(t.TempDir(), "bootstrap.json")
+
+	data, err := json.Marshal(BootstrapFile{
+		ServerURL: server.URL,
+		Token:     "s3cret",
+		EdgeID:    "device-1",
+		Labels:    map[string]string{"site": "lyon"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(bootstrapFile, data, 0600))
+
+	options := &agent.Options{DataPath: dataPath, EdgeBootstrapFile: bootstrapFile}
+	require.NoError(t, Bootstrap(options))
+
+	assert.Equal(t, "device-1", enrollment.EdgeID)
+	assert.Equal(t, map[string]string{"site": "lyon"}, enrollment.Labels)
+	assert.Equal(t, key, options.EdgeKey)
+	assert.Equal(t, "device-1", options.EdgeID)
+
+	storedKey, err := os.ReadFile(filepath.Join(dataPath, agent.EdgeKeyFile))
+	require.NoError(t, err)
+	assert.Equal(t, key, string(storedKey))
+
+	// The enrollment token does not stay on the device
+	assert.NoFileExists(t, bootstrapFile)
+
+	state, err := os.ReadFile(filepath.Join(dataPath, agent.EdgeBootstrapStateFile))
+	require.NoError(t, err)
+	assert.NotContains(t, string(state), "s3cret")
+
+	// The settings of the provisioning file are applied on the next starts
+	options = &agent.Options{DataPath: dataPath, EdgeBootstrapFile: bootstrapFile}
+	require.NoError(t, Bootstrap(options))
+	assert.Equal(t, "device-1", options.EdgeID)
+	assert.Empty(t, options.EdgeKey)
+}
+
+func TestBootstrap_refused(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	dataPath := t.TempDir()
+	bootstrapFile := filepath.Join(t.TempDir(), "bootstrap.json")
+	require.NoError(t, os.WriteFile(bootstrapFile, []byte(`{"ServerURL": "`+server.URL+`", "Token": "revoked"}`), 0600))
+
+	options := &agent.Options{DataPath: dataPath, EdgeBootstrapFile: bootstrapFile}
+	require.ErrorIs(t, Bootstrap(options), client.ErrEnrollmentRefused)
+
+	// The provisioning file is kept for the next start, with the generated Edge ID
+	assert.FileExists(t, bootstrapFile)
+	assert.NotEmpty(t, options.EdgeID)
+
+	generatedID := options.EdgeID
+
+	options = &agent.Options{DataPath: dataPath, EdgeBootstrapFile: bootstrapFile}
+	require.ErrorIs(t, Bootstrap(options), client.ErrEnrollmentRefused)
+	assert.Equal(t, generatedID, options.EdgeID)
+}
+
+func TestBootstrap_attestation(t *testing.T) {
+	key := encodeKey(&edgeKey{PortainerInstanceURL: "https://portainer.example.com", TunnelServerAddr: "portainer.example.com:8000", EndpointID: 7})
+
+	var enrollment client.EnrollmentRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/endpoints/edge/enroll/challenge":
+			json.NewEncoder(w).Encode(map[string]string{"nonce": "n0nce"})
+		case "/api/endpoints/edge/enroll":
+			assert.Empty(t, r.Header.Get("Authorization"))
+
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&enrollment))
+			json.NewEncoder(w).Encode(map[string]string{"edgeKey": key})
+		}
+	}))
+	defer server.Close()
+
+	deviceKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "device-1"}, NotAfter: time.Now().Add(time.Hour)}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &deviceKey.PublicKey, deviceKey)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(deviceKey)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certPath, keyPath, bootstrapFile := filepath.Join(dir, "device.crt"), filepath.Join(dir, "device.key"), filepath.Join(dir, "bootstrap.json")
+	require.NoError(t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate}), 0600))
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+
+	// The enrollment token is not required when the device attests its hardware
+	require.NoError(t, os.WriteFile(bootstrapFile, []byte(`{"ServerURL": "`+server.URL+`", "EdgeID": "device-1"}`), 0600))
+
+	options := &agent.Options{
+		DataPath:            t.TempDir(),
+		EdgeBootstrapFile:   bootstrapFile,
+		EdgeAttestation:     "certificate",
+		EdgeAttestationCert: certPath,
+		EdgeAttestationKey:  keyPath,
+	}
+	require.NoError(t, Bootstrap(options))
+
+	assert.Equal(t, key, options.EdgeKey)
+	require.NotNil(t, enrollment.Attestation)
+	assert.Equal(t, "certificate", enrollment.Attestation.Type)
+	assert.Equal(t, "n0nce", enrollment.Attestation.Nonce)
+	assert.NotEmpty(t, enrollment.Attestation.Signature)
+
+	// Without the attestation, the provisioning file needs a token
+	require.NoError(t, os.WriteFile(bootstrapFile, []byte(`{"ServerURL": "`+server.URL+`"}`), 0600))
+	assert.Error(t, Bootstrap(&agent.Options{DataPath: t.TempDir(), EdgeBootstrapFile: bootstrapFile}))
+}
+
+func TestBootstrap_claim(t *testing.T) {
+	key := encodeKey(&edgeKey{PortainerInstanceURL: "https://portainer.example.com", TunnelServerAddr: "portainer.example.com:8000", EndpointID: 7})
+
+	approved := false
+	var claim client.EnrollmentRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/endpoints/edge/claims" || r.Header.Get("Authorization") != "Bearer fleet-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&claim))
+
+		if !approved {
+			w.WriteHeader(http.StatusAccepted)
+
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{"edgeKey": key})
+	}))
+	defer server.Close()
+
+	dataPath := t.TempDir()
+	bootstrapFile := filepath.Join(t.TempDir(), "bootstrap.json")
+	require.NoError(t, os.WriteFile(bootstrapFile, []byte(`{"ServerURL": "`+server.URL+`", "GroupKey": "fleet-key", "Labels": {"site": "lyon"}}`), 0600))
+
+	options := &agent.Options{DataPath: dataPath, EdgeBootstrapFile: bootstrapFile}
+	require.ErrorIs(t, Bootstrap(options), client.ErrEnrollmentPending)
+
+	assert.Empty(t, options.EdgeKey)
+	assert.NotEmpty(t, claim.Metadata["arch"])
+	assert.Equal(t, options.EdgeID, claim.EdgeID)
+
+	// The provisioning file is kept while the claim is pending
+	assert.FileExists(t, bootstrapFile)
+
+	state, err := readBootstrapState(dataPath)
+	require.NoError(t, err)
+	assert.NotZero(t, state.ClaimedAt)
+
+	manager := &Manager{agentOptions: options}
+	httpClient := client.BuildHTTPClient(30, options)
+
+	_, err = manager.claim(httpClient)
+	require.ErrorIs(t, err, client.ErrEnrollmentPending)
+
+	approved = true
+
+	claimedKey, err := manager.claim(httpClient)
+	require.NoError(t, err)
+	assert.Equal(t, key, claimedKey)
+	assert.NoFileExists(t, bootstrapFile)
+
+	state, err = readBootstrapState(dataPath)
+	require.NoError(t, err)
+	assert.Zero(t, state.ClaimedAt)
+	assert.Equal(t, options.EdgeID, state.EdgeID)
+}
@@ -0,0 +1,118 @@
+package edge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/portainer/agent/edge/attestation"
+	"github.com/portainer/agent/edge/client"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	minClaimDelay = 30 * time.Second
+	maxClaimDelay = 5 * time.Minute
+)
+
+// WaitForClaim polls the claim of the device registered with the group enrollment key of its provisioning file until
+// it is approved on the server, then associates the Edge key of the device and starts the manager. The agent does not
+// poll Portainer nor process any stack while its claim is pending. It returns when the claim is rejected, or when a
+// key is associated to the agent meanwhile, e.g. on its page.
+func (manager *Manager) WaitForClaim(ctx context.Context) {
+	httpClient := client.BuildHTTPClient(30, manager.agentOptions)
+	delay := minClaimDelay
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		if manager.IsKeySet() {
+			return
+		}
+
+		key, err := manager.claim(httpClient)
+		switch {
+		case err == nil:
+		case errors.Is(err, client.ErrEnrollmentPending):
+			log.Debug().Dur("retry_in", delay).Msg("the claim of the device is still pending approval")
+
+			delay = min(2*delay, maxClaimDelay)
+
+			continue
+		case errors.Is(err, client.ErrEnrollmentRefused), errors.Is(err, errAttestation):
+			log.Error().Err(err).Msg("the claim of the device was rejected, the agent waits for its key to be entered on its page")
+
+			return
+		case errors.Is(err, os.ErrNotExist):
+			log.Error().Err(err).Msg("the provisioning file was removed, the claim of the device is not polled anymore")
+
+			return
+		default:
+			log.Warn().Err(err).Dur("retry_in", delay).Msg("unable to poll the claim of the device, retrying")
+
+			delay = min(2*delay, maxClaimDelay)
+
+			continue
+		}
+
+		if err := manager.SetKey(key); err != nil {
+			log.Error().Err(err).Msg("unable to associate the Edge key of the approved claim")
+
+			return
+		}
+
+		if err := manager.Start(); err != nil {
+			log.Error().Err(err).Msg("unable to start the Edge manager")
+
+			return
+		}
+
+		log.Info().Str("edge_id", manager.agentOptions.EdgeID).Msg("the claim of the device was approved")
+
+		return
+	}
+}
+
+// claim sends the claim of the device once, and keeps the settings of the provisioning file once it is approved
+func (manager *Manager) claim(httpClient enrollmentClient) (string, error) {
+	options := manager.agentOptions
+
+	file, err := readBootstrapFile(options.EdgeBootstrapFile, attested(options))
+	if err != nil {
+		return "", err
+	}
+
+	state, err := readBootstrapState(options.DataPath)
+	if err != nil {
+		return "", err
+	}
+
+	if state == nil {
+		state = &bootstrapState{EdgeID: options.EdgeID, HTTPProxy: file.HTTPProxy, HTTPSProxy: file.HTTPSProxy, NoProxy: file.NoProxy}
+	}
+
+	provider, err := attestation.NewProvider(options)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", errAttestation, err)
+	}
+
+	key, err := enrollOnce(httpClient, provider, file, options.EdgeID)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := ParseEdgeKey(key); err != nil {
+		return "", fmt.Errorf("invalid Edge key returned by the server: %w", err)
+	}
+
+	state.EnrolledAt = time.Now().Unix()
+
+	return key, completeBootstrap(options, file, state)
+}
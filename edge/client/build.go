@@ -3,15 +3,19 @@ package client
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"io"
 	"net/http"
 	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rs/zerolog/log"
 
 	"github.com/pkg/errors"
 	"github.com/portainer/agent"
+	"github.com/portainer/agent/chaos"
 	"github.com/portainer/agent/crypto"
 	"github.com/portainer/agent/edge/revoke"
 )
@@ -24,6 +28,16 @@ type edgeHTTPClient struct {
 	keyMTime      time.Time
 	caMTime       time.Time
 	mu            sync.RWMutex
+
+	// serverAcceptsGzip is true once the server advertised that it accepts the gzip compressed request bodies
+	serverAcceptsGzip atomic.Bool
+
+	// metadata and extraHeaders are added to the requests, see setMetadataHeaders
+	metadata     AgentMetadata
+	extraHeaders http.Header
+
+	// resilience retries the requests and fails them fast while the server is unreachable
+	resilience *resilience
 }
 
 func BuildHTTPClient(timeout float64, options *agent.Options) *edgeHTTPClient {
@@ -35,6 +49,18 @@ func BuildHTTPClient(timeout float64, options *agent.Options) *edgeHTTPClient {
 		},
 		options:       options,
 		revokeService: revokeService,
+		metadata:      newAgentMetadata(options),
+		resilience:    newResilience(options),
+	}
+
+	if options != nil {
+		// The headers are validated by the preflight checks
+		extraHeaders, err := ParseExtraHeaders(options.EdgeExtraHeaders)
+		if err != nil {
+			log.Warn().Err(err).Msg("ignoring the extra headers")
+		}
+
+		c.extraHeaders = extraHeaders
 	}
 
 	c.mu.Lock()
@@ -44,7 +70,32 @@ func BuildHTTPClient(timeout float64, options *agent.Options) *edgeHTTPClient {
 	return c
 }
 
+// Do sends the request to the Portainer server, retrying it while the server is unavailable. It returns
+// ErrCircuitOpen without sending the request while the server is considered unreachable.
 func (c *edgeHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	return c.resilience.do(req, c.send)
+}
+
+// RequestStats returns the metrics of the requests sent to the Portainer server
+func (c *edgeHTTPClient) RequestStats() RequestStats {
+	return c.resilience.report()
+}
+
+func (c *edgeHTTPClient) send(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && strings.HasSuffix(req.URL.Path, "/status") && chaos.Fail(chaos.Status500) {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+
+		return &http.Response{
+			Status:     "500 Internal Server Error",
+			StatusCode: http.StatusInternalServerError,
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader("chaos: status update failure")),
+			Request:    req,
+		}, nil
+	}
+
 	if c.certsNeedsRotation() {
 		log.Debug().Msg("reloading certificates")
 
@@ -56,7 +107,39 @@ func (c *edgeHTTPClient) Do(req *http.Request) (*http.Response, error) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	return c.httpClient.Do(req)
+	c.setMetadataHeaders(req)
+
+	getBody, contentLength := req.GetBody, req.ContentLength
+
+	compressed := false
+	if c.compressRequests() {
+		var err error
+		if compressed, err = compressRequest(req); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	c.learnCompression(resp)
+
+	if compressed && resp.StatusCode == http.StatusUnsupportedMediaType {
+		log.Debug().Msg("the server refused the compressed request, sending it uncompressed")
+
+		resp.Body.Close()
+
+		retry, err := uncompressedRequest(req, getBody, contentLength)
+		if err != nil {
+			return nil, err
+		}
+
+		return c.httpClient.Do(retry)
+	}
+
+	return resp, nil
 }
 
 func fileModified(filename string, mtime time.Time) bool {
@@ -78,7 +161,7 @@ func (c *edgeHTTPClient) certsNeedsRotation() bool {
 func (c *edgeHTTPClient) buildTransport() *http.Transport {
 	transport := http.DefaultTransport.(*http.Transport).Clone()
 
-	transport.TLSClientConfig = crypto.CreateTLSConfiguration()
+	transport.TLSClientConfig = crypto.ServerTLSConfiguration()
 	transport.TLSClientConfig.ClientSessionCache = tls.NewLRUClientSessionCache(0)
 
 	if c.options.EdgeInsecurePoll {
@@ -122,6 +205,11 @@ func (c *edgeHTTPClient) buildTransport() *http.Transport {
 		return &cert, err
 	}
 
+	// The revocation is checked by the TLS configuration of the server when its mode is configured
+	if crypto.ServerRevocation() != "" {
+		return transport
+	}
+
 	transport.TLSClientConfig.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
 		for _, chain := range verifiedChains {
 			for _, cert := range chain {
@@ -2,7 +2,6 @@ package client
 
 import (
 	"bytes"
-	"encoding/json"
 	"fmt"
 	"net/http"
 	"time"
@@ -51,7 +50,7 @@ func (client *APIClient) GetEdgeKey(serverAddr string) (string, error) {
 	}
 
 	var data getEdgeKeyResponse
-	err = json.NewDecoder(resp.Body).Decode(&data)
+	err = codec().NewDecoder(resp.Body).Decode(&data)
 	if err != nil {
 		return "", err
 	}
@@ -69,7 +68,7 @@ func (client *APIClient) SetEdgeKey(serverAddr, key string) error {
 		Key: key,
 	}
 
-	data, err := json.Marshal(payload)
+	data, err := codec().Marshal(payload)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,94 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+const (
+	// CodecStandard encodes the payloads with the encoding/json package of the standard library
+	CodecStandard = "std"
+	// CodecJSONIter encodes the payloads with json-iterator, faster and lighter on the large snapshots and stack
+	// payloads while producing the same JSON
+	CodecJSONIter = "jsoniter"
+)
+
+// Codec encodes and decodes the JSON payloads exchanged with the Portainer server
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	NewEncoder(w io.Writer) Encoder
+	NewDecoder(r io.Reader) Decoder
+}
+
+// Encoder writes JSON values to a stream
+type Encoder interface {
+	Encode(v any) error
+}
+
+// Decoder reads JSON values from a stream
+type Decoder interface {
+	Decode(v any) error
+}
+
+type standardCodec struct{}
+
+func (standardCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (standardCodec) NewEncoder(w io.Writer) Encoder {
+	return json.NewEncoder(w)
+}
+
+func (standardCodec) NewDecoder(r io.Reader) Decoder {
+	return json.NewDecoder(r)
+}
+
+type jsoniterCodec struct {
+	api jsoniter.API
+}
+
+func (codec jsoniterCodec) Marshal(v any) ([]byte, error) {
+	return codec.api.Marshal(v)
+}
+
+func (codec jsoniterCodec) NewEncoder(w io.Writer) Encoder {
+	return codec.api.NewEncoder(w)
+}
+
+func (codec jsoniterCodec) NewDecoder(r io.Reader) Decoder {
+	return codec.api.NewDecoder(r)
+}
+
+// codecHolder wraps the codecs so that they are stored in the atomic.Value under the same type
+type codecHolder struct {
+	codec Codec
+}
+
+var currentCodec atomic.Value
+
+func init() {
+	currentCodec.Store(codecHolder{standardCodec{}})
+}
+
+// SetCodec sets the codec of the payloads exchanged with the Portainer server, CodecStandard or CodecJSONIter
+func SetCodec(name string) error {
+	switch name {
+	case "", CodecStandard:
+		currentCodec.Store(codecHolder{standardCodec{}})
+	case CodecJSONIter:
+		currentCodec.Store(codecHolder{jsoniterCodec{api: jsoniter.ConfigCompatibleWithStandardLibrary}})
+	default:
+		return fmt.Errorf("unsupported JSON codec %q, use %s or %s", name, CodecStandard, CodecJSONIter)
+	}
+
+	return nil
+}
+
+func codec() Codec {
+	return currentCodec.Load().(codecHolder).codec
+}
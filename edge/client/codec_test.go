@@ -0,0 +1,46 @@
+package client
+
+import (
+	"compress/gzip"
+	"testing"
+
+	"github.com/portainer/portainer/api/edge"
+	"github.com/portainer/portainer/api/filesystem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodecs(t *testing.T) {
+	payload := StackPayload{
+		StackPayload: edge.StackPayload{
+			Name:          "web",
+			EntryFileName: "docker-compose.yml",
+			DirEntries:    []filesystem.DirEntry{{Name: "docker-compose.yml", Content: "c2VydmljZXM6IHt9", IsFile: true}},
+		},
+		FileChecksums: map[string]string{"docker-compose.yml": "sha256:<checksum>"},
+		Priority:      StackPriorityHigh,
+	}
+
+	defer SetCodec(CodecStandard)
+
+	require.NoError(t, SetCodec(CodecStandard))
+	expected, err := codec().Marshal(payload)
+	require.NoError(t, err)
+
+	require.NoError(t, SetCodec(CodecJSONIter))
+	data, err := codec().Marshal(payload)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(expected), string(data))
+
+	buf, err := gzipEncode(payload)
+	require.NoError(t, err)
+
+	gz, err := gzip.NewReader(buf)
+	require.NoError(t, err)
+
+	var decoded StackPayload
+	require.NoError(t, codec().NewDecoder(gz).Decode(&decoded))
+	assert.Equal(t, payload, decoded)
+
+	assert.Error(t, SetCodec("unknown"))
+}
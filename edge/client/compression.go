@@ -0,0 +1,118 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// The request bodies are only compressed with gzip, the other encodings advertised by the server such as zstd are
+// not used
+const (
+	// CompressionAuto compresses the request bodies once the Portainer server advertised that it accepts them
+	// compressed with gzip, through the Accept-Encoding header of its responses
+	CompressionAuto = "auto"
+	// CompressionAlways compresses the request bodies without waiting for the server to advertise it
+	CompressionAlways = "always"
+	// CompressionOff sends the request bodies uncompressed, including the snapshots of the async mode
+	CompressionOff = "off"
+)
+
+// compressionMinSize is the size from which the request bodies are compressed, the smaller ones barely shrink
+const compressionMinSize = 1024
+
+func (c *edgeHTTPClient) compressionMode() string {
+	if c.options == nil || c.options.EdgeCompression == "" {
+		return CompressionAuto
+	}
+
+	return c.options.EdgeCompression
+}
+
+// compressRequests returns true when the request bodies are compressed
+func (c *edgeHTTPClient) compressRequests() bool {
+	switch c.compressionMode() {
+	case CompressionAlways:
+		return true
+	case CompressionAuto:
+		return c.serverAcceptsGzip.Load()
+	}
+
+	return false
+}
+
+// learnCompression records whether the server accepts the gzip compressed request bodies from its response
+func (c *edgeHTTPClient) learnCompression(resp *http.Response) {
+	if resp.StatusCode == http.StatusUnsupportedMediaType {
+		c.serverAcceptsGzip.Store(false)
+
+		return
+	}
+
+	for _, encoding := range strings.Split(resp.Header.Get("Accept-Encoding"), ",") {
+		if strings.EqualFold(strings.TrimSpace(encoding), "gzip") {
+			c.serverAcceptsGzip.Store(true)
+
+			return
+		}
+	}
+}
+
+// compressRequest replaces the body of the request with its gzip compression, it returns false when the request is
+// left as it is: small, without a body that can be read again, or already encoded
+func compressRequest(req *http.Request) (bool, error) {
+	if req.GetBody == nil || req.ContentLength < compressionMinSize || req.Header.Get("Content-Encoding") != "" {
+		return false, nil
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return false, err
+	}
+	defer body.Close()
+
+	buf := &bytes.Buffer{}
+
+	gz, err := gzip.NewWriterLevel(buf, gzip.BestCompression)
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := io.Copy(gz, body); err != nil {
+		return false, err
+	}
+
+	if err := gz.Close(); err != nil {
+		return false, err
+	}
+
+	compressed := buf.Bytes()
+
+	req.Body = io.NopCloser(bytes.NewReader(compressed))
+	req.ContentLength = int64(len(compressed))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(compressed)), nil
+	}
+	req.Header.Set("Content-Encoding", "gzip")
+
+	return true, nil
+}
+
+// uncompressedRequest returns a copy of the request with its original body, sent again when the server refused
+// the compressed one
+func uncompressedRequest(req *http.Request, getBody func() (io.ReadCloser, error), contentLength int64) (*http.Request, error) {
+	body, err := getBody()
+	if err != nil {
+		return nil, err
+	}
+
+	retry := req.Clone(req.Context())
+	retry.Body = body
+	retry.GetBody = getBody
+	retry.ContentLength = contentLength
+	retry.Header.Del("Content-Encoding")
+
+	return retry, nil
+}
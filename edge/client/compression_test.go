@@ -0,0 +1,77 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/portainer/agent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEdgeHTTPClient_compression(t *testing.T) {
+	payload := strings.Repeat(`{"status":"running"}`, 100)
+
+	var encodings []string
+	refuse := false
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encodings = append(encodings, r.Header.Get("Content-Encoding"))
+
+		body := io.Reader(r.Body)
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			if refuse {
+				w.WriteHeader(http.StatusUnsupportedMediaType)
+
+				return
+			}
+
+			gz, err := gzip.NewReader(r.Body)
+			require.NoError(t, err)
+			body = gz
+		}
+
+		received, err := io.ReadAll(body)
+		require.NoError(t, err)
+		assert.Equal(t, payload, string(received))
+
+		w.Header().Set("Accept-Encoding", "gzip")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	options := &agent.Options{EdgeCompression: CompressionAuto}
+	c := &edgeHTTPClient{httpClient: server.Client(), options: options, resilience: newResilience(options)}
+
+	put := func() int {
+		req, err := http.NewRequest(http.MethodPut, server.URL, bytes.NewReader([]byte(payload)))
+		require.NoError(t, err)
+
+		resp, err := c.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	// The first request is sent uncompressed until the server advertises it accepts gzip
+	put()
+	put()
+	assert.Equal(t, []string{"", "gzip"}, encodings)
+
+	// The request refused compressed is sent again uncompressed
+	refuse = true
+	encodings = nil
+	assert.Equal(t, http.StatusNoContent, put())
+	assert.Equal(t, []string{"gzip", ""}, encodings)
+
+	c.options.EdgeCompression = CompressionOff
+	encodings = nil
+	put()
+	assert.Equal(t, []string{""}, encodings)
+}
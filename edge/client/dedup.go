@@ -0,0 +1,109 @@
+package client
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/portainer/agent"
+	"github.com/portainer/agent/clock"
+	portainer "github.com/portainer/portainer/api"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultErrorReportWindow is the interval during which an identical error status of a stack is reported only once
+const DefaultErrorReportWindow = 10 * time.Minute
+
+// DeduplicatingClient is a PortainerClient that deduplicates the error statuses of the Edge stacks.
+// The first occurrence of an error is reported, the identical ones reported within the window are
+// suppressed and the next one reported after the window summarizes how many times it occurred.
+type DeduplicatingClient struct {
+	PortainerClient
+
+	window time.Duration
+	clock  agent.Clock
+
+	mu     sync.Mutex
+	errors map[int]*reportedError
+}
+
+// reportedError is the last error status reported for a stack
+type reportedError struct {
+	Message     string
+	FirstSeen   time.Time
+	LastSent    time.Time
+	Occurrences int
+}
+
+// NewDeduplicatingClient returns a pointer to a new DeduplicatingClient wrapping cli
+func NewDeduplicatingClient(cli PortainerClient, window time.Duration) *DeduplicatingClient {
+	return &DeduplicatingClient{
+		PortainerClient: cli,
+		window:          window,
+		clock:           clock.NewSystemClock(),
+		errors:          make(map[int]*reportedError),
+	}
+}
+
+// SetEdgeStackStatus reports the status of an Edge stack, identical error statuses are deduplicated
+func (client *DeduplicatingClient) SetEdgeStackStatus(edgeStackID int, edgeStackStatus portainer.EdgeStackStatusType, rollbackTo *int, errMessage string) error {
+	message, send := client.deduplicate(edgeStackID, edgeStackStatus, errMessage)
+	if !send {
+		log.Debug().Int("stack_identifier", edgeStackID).Str("error", errMessage).Msg("duplicate Edge stack error status suppressed")
+
+		return nil
+	}
+
+	return client.PortainerClient.SetEdgeStackStatus(edgeStackID, edgeStackStatus, rollbackTo, message)
+}
+
+// SetEdgeStackError reports the Error status of an Edge stack with the class of the error, identical errors are
+// deduplicated the same way as the error statuses
+func (client *DeduplicatingClient) SetEdgeStackError(edgeStackID int, rollbackTo *int, errMessage, errorClass string) error {
+	message, send := client.deduplicate(edgeStackID, portainer.EdgeStackStatusError, errMessage)
+	if !send {
+		log.Debug().Int("stack_identifier", edgeStackID).Str("error", errMessage).Msg("duplicate Edge stack error status suppressed")
+
+		return nil
+	}
+
+	return client.PortainerClient.SetEdgeStackError(edgeStackID, rollbackTo, message, errorClass)
+}
+
+// deduplicate returns the message to report for the status and whether it must be reported
+func (client *DeduplicatingClient) deduplicate(edgeStackID int, edgeStackStatus portainer.EdgeStackStatusType, errMessage string) (string, bool) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	if edgeStackStatus != portainer.EdgeStackStatusError {
+		// Any other status ends the failure
+		delete(client.errors, edgeStackID)
+
+		return errMessage, true
+	}
+
+	now := client.clock.Now()
+
+	reported, ok := client.errors[edgeStackID]
+	if !ok || reported.Message != errMessage {
+		client.errors[edgeStackID] = &reportedError{
+			Message:     errMessage,
+			FirstSeen:   now,
+			LastSent:    now,
+			Occurrences: 1,
+		}
+
+		return errMessage, true
+	}
+
+	reported.Occurrences++
+
+	if now.Sub(reported.LastSent) < client.window {
+		return "", false
+	}
+
+	reported.LastSent = now
+
+	return fmt.Sprintf("%s (occurred %d times since %s)", errMessage, reported.Occurrences, reported.FirstSeen.UTC().Format(time.RFC3339)), true
+}
@@ -0,0 +1,94 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/portainer/agent/clock"
+	portainer "github.com/portainer/portainer/api"
+	"github.com/stretchr/testify/assert"
+)
+
+type sentStatus struct {
+	Status  portainer.EdgeStackStatusType
+	Message string
+	Class   string
+}
+
+type fakeStatusClient struct {
+	PortainerClient
+
+	sent []sentStatus
+}
+
+func (client *fakeStatusClient) SetEdgeStackStatus(edgeStackID int, edgeStackStatus portainer.EdgeStackStatusType, rollbackTo *int, errMessage string) error {
+	client.sent = append(client.sent, sentStatus{Status: edgeStackStatus, Message: errMessage})
+
+	return nil
+}
+
+func (client *fakeStatusClient) SetEdgeStackError(edgeStackID int, rollbackTo *int, errMessage, errorClass string) error {
+	client.sent = append(client.sent, sentStatus{Status: portainer.EdgeStackStatusError, Message: errMessage, Class: errorClass})
+
+	return nil
+}
+
+func TestDeduplicatingClient_SetEdgeStackStatus(t *testing.T) {
+	fake := &fakeStatusClient{}
+	fakeClock := clock.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	cli := NewDeduplicatingClient(fake, 10*time.Minute)
+	cli.clock = fakeClock
+
+	report := func(status portainer.EdgeStackStatusType, message string) {
+		assert.NoError(t, cli.SetEdgeStackStatus(1, status, nil, message))
+	}
+
+	report(portainer.EdgeStackStatusError, "pull failed")
+
+	// Identical errors within the window are suppressed
+	for i := 0; i < 3; i++ {
+		fakeClock.Advance(time.Minute)
+		report(portainer.EdgeStackStatusError, "pull failed")
+	}
+
+	// The next one after the window summarizes the occurrences
+	fakeClock.Advance(10 * time.Minute)
+	report(portainer.EdgeStackStatusError, "pull failed")
+
+	// A different error or another status are always reported
+	report(portainer.EdgeStackStatusError, "deploy failed")
+	report(portainer.EdgeStackStatusRunning, "")
+	report(portainer.EdgeStackStatusError, "deploy failed")
+
+	assert.Equal(t, []sentStatus{
+		{Status: portainer.EdgeStackStatusError, Message: "pull failed"},
+		{Status: portainer.EdgeStackStatusError, Message: "pull failed (occurred 5 times since 2024-01-01T00:00:00Z)"},
+		{Status: portainer.EdgeStackStatusError, Message: "deploy failed"},
+		{Status: portainer.EdgeStackStatusRunning},
+		{Status: portainer.EdgeStackStatusError, Message: "deploy failed"},
+	}, fake.sent)
+}
+
+func TestDeduplicatingClient_SetEdgeStackError(t *testing.T) {
+	fake := &fakeStatusClient{}
+	fakeClock := clock.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	cli := NewDeduplicatingClient(fake, 10*time.Minute)
+	cli.clock = fakeClock
+
+	assert.NoError(t, cli.SetEdgeStackError(1, nil, "pull failed", StackErrorTransient))
+
+	// The errors reported with a class are deduplicated along with the error statuses
+	fakeClock.Advance(time.Minute)
+	assert.NoError(t, cli.SetEdgeStackStatus(1, portainer.EdgeStackStatusError, nil, "pull failed"))
+
+	fakeClock.Advance(10 * time.Minute)
+	assert.NoError(t, cli.SetEdgeStackError(1, nil, "pull failed", StackErrorTerminal))
+
+	// The message is left as is, the class is sent separately
+	assert.Equal(t, []sentStatus{
+		{Status: portainer.EdgeStackStatusError, Message: "pull failed", Class: StackErrorTransient},
+		{Status: portainer.EdgeStackStatusError, Message: "pull failed (occurred 3 times since 2024-01-01T00:00:00Z)", Class: StackErrorTerminal},
+	}, fake.sent)
+}
@@ -0,0 +1,148 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/portainer/agent"
+	"github.com/portainer/agent/edge/attestation"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ErrEnrollmentRefused is returned when the server refuses the enrollment token or the attestation of the device,
+// e.g. because the token was revoked, the hardware batch is unknown or the claim of the device was rejected
+var ErrEnrollmentRefused = errors.New("the enrollment was refused by the server")
+
+// ErrEnrollmentPending is returned while the claim of the device waits for its approval on the server
+var ErrEnrollmentPending = errors.New("the claim of the device is pending approval")
+
+// EnrollmentRequest is sent by a device enrolling itself with the enrollment token of its provisioning file, or with
+// the attestation of its hardware, or claiming its registration with a group enrollment key
+type EnrollmentRequest struct {
+	EdgeID      string                `json:"edgeID"`
+	Labels      map[string]string     `json:"labels,omitempty"`
+	Attestation *attestation.Evidence `json:"attestation,omitempty"`
+	// Metadata describes the device to the operator approving its claim, e.g. its hostname and its architecture
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+type enrollmentResponse struct {
+	EdgeKey string `json:"edgeKey"`
+}
+
+type enrollmentChallengeResponse struct {
+	Nonce string `json:"nonce"`
+}
+
+// EnrollmentChallenge returns the nonce the attestation of the device answers when it enrolls
+func (c *edgeHTTPClient) EnrollmentChallenge(serverURL, edgeID string) (string, error) {
+	requestURL := fmt.Sprintf("%s/api/endpoints/edge/enroll/challenge", strings.TrimSuffix(serverURL, "/"))
+
+	req, err := http.NewRequest(http.MethodPost, requestURL, nil)
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set(agent.HTTPEdgeIdentifierHeaderName, edgeID)
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+
+		log.Error().Int("response_code", resp.StatusCode).Msg("EnrollmentChallenge operation failed")
+
+		return "", errors.New("EnrollmentChallenge operation failed")
+	}
+
+	var response enrollmentChallengeResponse
+	if err := codec().NewDecoder(resp.Body).Decode(&response); err != nil {
+		return "", err
+	}
+
+	if response.Nonce == "" {
+		return "", errors.New("the server did not return a challenge")
+	}
+
+	return response.Nonce, nil
+}
+
+// Enroll exchanges the enrollment token of the provisioning file of the device, or the attestation of its hardware,
+// for its Edge key
+func (c *edgeHTTPClient) Enroll(serverURL, token string, request EnrollmentRequest) (string, error) {
+	requestURL := fmt.Sprintf("%s/api/endpoints/edge/enroll", strings.TrimSuffix(serverURL, "/"))
+
+	return c.enroll("Enroll", requestURL, token, request)
+}
+
+// Claim registers the device in the pending claim pool of the group enrollment key of its provisioning file. It
+// returns ErrEnrollmentPending until the claim is approved on the server, and the Edge key of the device once it is.
+// Sending the claim again is how its status is polled.
+func (c *edgeHTTPClient) Claim(serverURL, groupKey string, request EnrollmentRequest) (string, error) {
+	requestURL := fmt.Sprintf("%s/api/endpoints/edge/claims", strings.TrimSuffix(serverURL, "/"))
+
+	return c.enroll("Claim", requestURL, groupKey, request)
+}
+
+func (c *edgeHTTPClient) enroll(operation, requestURL, token string, request EnrollmentRequest) (string, error) {
+	data, err := codec().Marshal(request)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, requestURL, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	req.Header.Set(agent.HTTPEdgeIdentifierHeaderName, request.EdgeID)
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusAccepted {
+		io.Copy(io.Discard, resp.Body)
+
+		return "", ErrEnrollmentPending
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+
+		log.Error().Int("response_code", resp.StatusCode).Msg(operation + " operation failed")
+
+		switch resp.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden, http.StatusGone:
+			return "", ErrEnrollmentRefused
+		}
+
+		return "", errors.New(operation + " operation failed")
+	}
+
+	var response enrollmentResponse
+	if err := codec().NewDecoder(resp.Body).Decode(&response); err != nil {
+		return "", err
+	}
+
+	if response.EdgeKey == "" {
+		return "", errors.New("the server did not return an Edge key")
+	}
+
+	return response.EdgeKey, nil
+}
@@ -1,7 +1,6 @@
 package client
 
 import (
-	"encoding/json"
 	"net/http"
 
 	"github.com/rs/zerolog/log"
@@ -15,7 +14,7 @@ type errorData struct {
 func parseError(resp *http.Response) *errorData {
 	errorData := &errorData{}
 
-	err := json.NewDecoder(resp.Body).Decode(&errorData)
+	err := codec().NewDecoder(resp.Body).Decode(&errorData)
 	if err != nil {
 		log.Debug().CallerSkipFrame(1).
 			Err(err).
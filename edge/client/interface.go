@@ -8,6 +8,7 @@ import (
 	"github.com/portainer/portainer/api/filesystem"
 
 	"github.com/portainer/agent"
+	"github.com/portainer/agent/crypto"
 )
 
 const (
@@ -21,9 +22,28 @@ const (
 type PortainerClient interface {
 	GetEnvironmentID() (portainer.EndpointID, error)
 	GetEnvironmentStatus(flags ...string) (*PollStatusResponse, error)
-	GetEdgeStackConfig(edgeStackID int, version *int) (*edge.StackPayload, error)
+	GetEdgeStackConfig(edgeStackID int, version *int) (*StackPayload, error)
 	SetEdgeStackStatus(edgeStackID int, edgeStackStatus portainer.EdgeStackStatusType, rollbackTo *int, errMessage string) error
+	SetEdgeStackError(edgeStackID int, rollbackTo *int, errMessage, errorClass string) error
+	SetEdgeStackBatchStatus(batchID int, status StackBatchStatus) error
+	SetEdgeStackJobResult(edgeStackID int, result StackJobResult) error
+	SetEdgeStackServicesStatus(edgeStackID int, services map[string]StackServiceStatus) error
+	SetEdgeStackUsage(edgeStackID int, usage StackUsage) error
+	SetEdgeStackStaged(edgeStackID int, staged StackStaged) error
+	SetEdgeStackPullProgress(edgeStackID int, progress StackPullProgress) error
+	SetEdgeStackConfigHash(edgeStackID int, hash StackConfigHash) error
+	SetEdgeStackChangelog(edgeStackID int, changelog StackChangelog) error
+	SetEdgeStackRemediation(edgeStackID int, remediation StackRemediation) error
+	SetEdgeStackInventory(stacks []DeployedStack) error
 	SetEdgeJobStatus(edgeJobStatus agent.EdgeJobStatus) error
+	SetEdgeJobHistory(edgeJobID int, executions []agent.EdgeJobExecution) error
+	SetEdgeJobFailure(execution agent.EdgeJobExecution) error
+	SetFileTransferResult(transferID int, result FileTransferResult) error
+	SetStackCommandResult(commandID int, result StackCommandResult) error
+	SetBackupResult(backupID int, result BackupResult) error
+	SetLabels(labels map[string]string) error
+	SetHostInfo(info HostInfo) error
+	SetPowerStatus(status PowerStatus) error
 	GetEdgeConfig(id EdgeConfigID) (*EdgeConfig, error)
 	SetEdgeConfigState(id EdgeConfigID, state EdgeConfigStateType) error
 	SetTimeout(t time.Duration)
@@ -31,6 +51,209 @@ type PortainerClient interface {
 	EnqueueLogCollectionForStack(logCmd LogCommandData) error
 }
 
+// StackFormatSystemd is the format of the stacks of native workloads, see systemd.StackFile
+const StackFormatSystemd = "systemd"
+
+const (
+	// StackPriorityCritical is the priority of the stacks deployed before any other stack waiting in the queue
+	StackPriorityCritical = "critical"
+	// StackPriorityHigh is the priority of the stacks deployed before the stacks of normal priority
+	StackPriorityHigh = "high"
+)
+
+// The classes of the errors the pull and the deployment of the Edge stacks fail with, reported along with the Error
+// status by SetEdgeStackError
+const (
+	// StackErrorTransient is the class of the errors that may go away when the operation is retried, e.g. a timeout
+	StackErrorTransient = "transient"
+	// StackErrorTerminal is the class of the errors retrying does not fix, e.g. an unknown image or a rejected credential
+	StackErrorTerminal = "terminal"
+)
+
+// StackPayload is the configuration of an Edge stack sent by Portainer,
+// extended with the fields only used by the agent
+type StackPayload struct {
+	edge.StackPayload `mapstructure:",squash"`
+
+	// FileChecksums maps the path of each file of the stack to its hex encoded SHA-256 checksum
+	FileChecksums map[string]string
+
+	// Artifact references the OCI artifact holding the stack files, DirEntries is ignored when it is set
+	Artifact *StackArtifact
+
+	// StatusTimeout is how long the stack can take to reach its deployed or removed status, the default is used when zero
+	StatusTimeout time.Duration
+	// StatusCheckInterval is how long each check of the stack status lasts before the other stacks are processed
+	// and the stack is checked again, the whole StatusTimeout when zero
+	StatusCheckInterval time.Duration
+
+	// Job is set for the stacks running to completion, such as batch jobs
+	Job *StackJob
+
+	// Requirements are the capabilities the device needs to run the stack, the stack is not deployed when they are not met
+	Requirements *StackRequirements
+
+	// Activation defers the deployment of the stack, its images are pulled and its files staged beforehand
+	Activation *StackActivation
+
+	// Barrier identifies the fleet-wide barrier the stack waits at once staged, until the server releases it.
+	// The stack is deployed as soon as it is staged when empty.
+	Barrier string
+
+	// Rollout limits the devices the stack version is applied to, it is applied to all of them when nil
+	Rollout *StackRollout
+
+	// Retention is what is done with the data of the stack when it is removed, its volumes are kept when nil
+	Retention *StackRetention
+
+	// Format is the format of the stack files, compose files or Kubernetes manifests for the engine of the device when empty,
+	// StackFormatSystemd for the native workloads run as systemd units
+	Format string
+
+	// PatchServiceAccounts references the pull secrets of the registry credentials in the service accounts
+	// of the Kubernetes manifest, for the pods whose pod templates are not known to the agent
+	PatchServiceAccounts bool
+
+	// Priority is StackPriorityCritical or StackPriorityHigh for the stacks deployed ahead of the routine ones
+	// waiting in the queue of the agent, e.g. security updates. The stack has the normal priority when empty.
+	Priority string
+
+	// ProjectName is the project the stack is deployed as, overriding the project named after the stack by the
+	// naming strategy of the agent. A project of the host not deployed by the agent is adopted.
+	ProjectName string
+
+	// EnvFiles are the host paths of the env files merged with EnvVars when the stack is deployed, so that the
+	// site-specific values stay on the device. EnvVars take precedence over the files, the later files over the
+	// earlier ones.
+	EnvFiles []string
+
+	// ExpandEnvFiles are the paths of the files of the stack, other than the entry file interpolated by the
+	// deployer, whose ${VAR} placeholders are replaced with the variables of the stack before they are persisted,
+	// e.g. nginx.conf. The placeholders of the undefined variables are kept as they are.
+	ExpandEnvFiles []string
+
+	// FileEncodings are the encodings of the content of the files of DirEntries, indexed by their path: base64 by
+	// default, or gzip for the base64 encoded gzip compressed content of the binary files such as SQLite seeds
+	FileEncodings map[string]string
+
+	// LargeFiles are the files of the stack too large to be sent in DirEntries, e.g. model files or firmware blobs.
+	// They are downloaded by the agent and written to the stack folder as they are received.
+	LargeFiles []StackLargeFile
+
+	// Networks are the macvlan or ipvlan networks of the host interfaces the services of the stack are attached to,
+	// created by the agent before the stack is deployed, e.g. to keep the OT and the IT traffic of an industrial site
+	// on separate VLANs
+	Networks []StackNetwork
+}
+
+// The drivers of the networks of the host interfaces
+const (
+	StackNetworkMacvlan = "macvlan"
+	StackNetworkIpvlan  = "ipvlan"
+)
+
+// StackNetwork is a network of a host interface the containers of a stack are pinned to, the containers attached to
+// a macvlan network get their own MAC address on the VLAN of the interface
+type StackNetwork struct {
+	// Name of the network, the services of the stack refer to it by this name
+	Name string
+	// Driver is StackNetworkMacvlan, the default, or StackNetworkIpvlan
+	Driver string
+	// Parent is the host interface the network is created on, e.g. eth1
+	Parent string
+	// VLAN is the 802.1Q VLAN identifier, the engine creates the parent.VLAN sub-interface when it is set
+	VLAN int
+	// Mode is the macvlan mode, bridge by default, or the ipvlan mode, l2 by default
+	Mode    string
+	Subnet  string
+	Gateway string
+	// IPRange is the range of the subnet the engine assigns the addresses of the containers from
+	IPRange string
+	// AuxAddresses are the addresses of the subnet the engine does not assign, e.g. those of the PLCs of the VLAN
+	AuxAddresses map[string]string
+	// Services are the services of the stack attached to the network, all of them when empty
+	Services []string
+	// IPv4Addresses are the static addresses of the services on the network, indexed by service name
+	IPv4Addresses map[string]string
+}
+
+// StackLargeFile is a file of an Edge stack downloaded from a URL instead of being sent in the stack payload
+type StackLargeFile struct {
+	// Path of the file relative to the stack folder
+	Path string
+	// URL the file is downloaded from
+	URL string
+	// Size of the file in bytes, the download fails when it is larger
+	Size int64
+	// Checksum is the hex encoded SHA-256 checksum of the file, optionally prefixed with sha256:
+	Checksum string
+}
+
+// StackRetention is the data retention policy applied when a stack is removed
+type StackRetention struct {
+	// Volumes is "keep" or "remove", for the named volumes of the stack
+	Volumes string
+	// AnonymousVolumes is "keep" or "remove", for the volumes created without a name for the services of the stack
+	AnonymousVolumes string
+	// Networks is "keep" or "remove", for the networks of the stack left behind by its removal
+	Networks string
+	// DryRun reports the resources the policy would remove without removing them
+	DryRun bool
+	// Archive writes the content of the named volumes to tarballs in the data folder of the agent before they are removed
+	Archive bool
+	// CleanupHook is run once the stack is removed, before its volumes are archived and removed
+	CleanupHook *StackCleanupHook
+}
+
+// StackCleanupHook is a one-off container run when a stack is removed, the named volumes of the stack
+// are mounted in /volumes/<volume name>
+type StackCleanupHook struct {
+	Image   string
+	Command []string
+	// Timeout is how long the hook can run, the default is used when zero
+	Timeout time.Duration
+}
+
+// StackRollout is a staged rollout computed by each device, the Edge ID of the device is hashed with
+// the salt into a bucket between 0 and 99 and the stack is only applied when the bucket is below Percentage
+type StackRollout struct {
+	Percentage int
+	Salt       string
+}
+
+// StackActivation schedules the deployment of a stack
+type StackActivation struct {
+	// Time is an RFC 3339 timestamp, or a 2006-01-02T15:04:05 wall clock time when Local is set
+	Time string
+	// Local interprets Time in the time zone of the device, so that each device is activated at the same local time
+	Local bool
+}
+
+// StackRequirements describes the capabilities a device needs to run a stack
+type StackRequirements struct {
+	// Arch lists the supported architectures using the Go names, e.g. amd64 or arm64, any architecture when empty
+	Arch []string
+	// MinMemory is the minimum total memory of the device in bytes
+	MinMemory int64
+	GPU       bool
+	// Privileged requires the device to allow privileged containers, which rootless engines do not
+	Privileged bool
+}
+
+// StackArtifact references an OCI artifact holding the files of a stack, as pushed by ORAS
+type StackArtifact struct {
+	// Reference of the artifact, e.g. registry.example.com/stacks/web:1.0 or registry.example.com/stacks/web@sha256:...
+	Reference string
+	// Digest is the expected digest of the artifact manifest, required unless the reference is pinned by digest or Signature is set
+	Digest string
+	// PublicKey is the PEM encoded public key verifying Signature
+	PublicKey string
+	// Signature is the base64 encoded signature of the manifest digest
+	Signature string
+	// PlainHTTP pulls the artifact over HTTP instead of HTTPS
+	PlainHTTP bool
+}
+
 type EdgeConfigID int
 type EdgeConfigStateType int
 
@@ -58,6 +281,35 @@ type EdgeConfig struct {
 	DirEntries []filesystem.DirEntry
 	Prev       *EdgeConfig
 	Invalid    bool
+
+	// Templated renders all the files as Go templates against the device facts
+	Templated bool
+	// Files holds the options of the files, indexed by their path relative to BaseDir
+	Files map[string]EdgeConfigFileOptions
+	// Reload lists the containers signaled once the files are updated
+	Reload []EdgeConfigReload
+}
+
+// EdgeConfigFileOptions describes how a file of an Edge configuration is written
+type EdgeConfigFileOptions struct {
+	// Template renders the file as a Go template against the device facts
+	Template bool
+	// Target is the path the file is written to, relative to BaseDir unless absolute. It is rendered as a template.
+	Target string
+	// Mode is the octal permission of the file, e.g. "0640"
+	Mode string
+	// Encoding is the encoding of the content of the file, base64 by default or gzip, see StackPayload.FileEncodings
+	Encoding string
+	// UID and GID own the file when set
+	UID *int
+	GID *int
+}
+
+// EdgeConfigReload describes a container signaled once an Edge configuration is updated
+type EdgeConfigReload struct {
+	Container string
+	// Signal sent to the container, SIGHUP by default
+	Signal string
 }
 
 type PollStatusResponse struct {
@@ -69,6 +321,14 @@ type PollStatusResponse struct {
 	Stacks             []StackStatus                        `json:"stacks"`
 	EdgeConfigurations map[EdgeConfigID]EdgeConfigStateType `json:"edge_configurations"`
 
+	// Default status timeout and check interval of the stacks that do not define them
+	StackStatusTimeout       time.Duration `json:"stackStatusTimeout"`
+	StackStatusCheckInterval time.Duration `json:"stackStatusCheckInterval"`
+
+	// StackRecovery is set by a server that lost the records of its stacks, e.g. rebuilt from scratch. The agent
+	// reports the stacks deployed on the device and keeps the ones not sent by the server until it is unset.
+	StackRecovery bool `json:"stackRecovery"`
+
 	// Async mode only
 	EndpointID       int            `json:"endpointID"`
 	PingInterval     time.Duration  `json:"pingInterval"`
@@ -83,6 +343,197 @@ type StackStatus struct {
 	Name             string // used in async mode
 	CommandOperation string // used in async mode
 	ReadyRePullImage bool
+	// Hash is the hash of the stack content at this version, a stack is redeployed when it changes
+	Hash string
+	// BatchID groups the stacks deployed as a single unit, 0 when the stack is not part of a batch
+	BatchID int
+	// BarrierReleaseAt is the unix timestamp the staged stack is deployed at, set once the server
+	// released the barrier of the stack at this version
+	BarrierReleaseAt int64
+	// RestartRequestedAt is the unix timestamp of the last restart of the stack requested by the server, the deployed
+	// stack is restarted in place each time it increases, without a new version
+	RestartRequestedAt int64
+}
+
+// DeployedStack is an Edge stack deployed on the device, reported to a server recovering its stacks so that it can
+// adopt the stack again instead of the stack becoming unmanaged
+type DeployedStack struct {
+	ID      int
+	Name    string `json:",omitempty"`
+	Version int
+	// ProjectName is the project the stack is deployed as, sending a stack with this project name adopts the
+	// deployed stack under another identifier
+	ProjectName string `json:",omitempty"`
+	// Containers is the number of containers of the stack found on the device
+	Containers int
+	// Managed is true for the stacks still managed by the agent, the other ones are only found on disk or
+	// through the labels of their resources
+	Managed bool
+}
+
+// StackStaged reports an Edge stack staged at its barrier, waiting for the server to release it
+type StackStaged struct {
+	Barrier string
+	Version int
+	Time    int64
+}
+
+// StackPullProgress is the progress of the image pull of an Edge stack, reported periodically while the images are
+// pulled so that a slow link can be told apart from a hung pull
+type StackPullProgress struct {
+	// Image is the image being pulled
+	Image string
+	// File is the large file of the stack being downloaded, Image is empty then
+	File string
+	// Percent of the bytes downloaded, over the layers whose size is known so far
+	Percent      int
+	CurrentBytes int64
+	TotalBytes   int64
+	// Detail is the progress displayed along with the stack status, e.g. "pulling 42%"
+	Detail string
+	// Priority is the priority of the stack in the queue of the agent, empty for the normal priority
+	Priority string
+	Time     int64
+}
+
+// StackJob describes a stack running to completion. The agent waits for all its services to exit
+// and reports their exit codes, a service exiting is not an error unless its exit code is not zero.
+type StackJob struct {
+	// TTL is how long the completed stack is kept on the host before it is removed, forever when zero
+	TTL time.Duration
+}
+
+// StackJobResult is the outcome of a stack running to completion
+type StackJobResult struct {
+	// ExitCodes maps each service of the stack to the exit code of its containers, the highest one when several containers run the service
+	ExitCodes map[string]int
+	Succeeded bool
+	Time      int64
+}
+
+// StackConfigHash is the hash of the effective configuration of an Edge stack, once its variables are interpolated and
+// its registry credentials injected, reported along with its running status. Devices running the same version of a
+// stack with the same hash run identical configurations.
+type StackConfigHash struct {
+	Version int
+	// Hash is the hex encoded SHA-256 of the normalized configuration
+	Hash string
+	Time int64
+}
+
+// The results of the versions of the Edge stacks, a version being deployed has no result
+const (
+	StackVersionDeployed  = "deployed"
+	StackVersionCompleted = "completed"
+	StackVersionFailed    = "failed"
+	// StackVersionSuperseded is the result of a version replaced by a newer one before it was deployed
+	StackVersionSuperseded = "superseded"
+)
+
+// StackVersionRecord is a version of an Edge stack received by the agent, and what became of it
+type StackVersionRecord struct {
+	Version int
+	// Hash is the hash of the stack content sent by the server, Digests the checksums of the files of the version
+	Hash    string            `json:",omitempty"`
+	Digests map[string]string `json:",omitempty"`
+	Result  string            `json:",omitempty"`
+	// Error is the error the version failed with
+	Error string `json:",omitempty"`
+	// RolledBackTo is the retained version redeployed by a rollback, 0 if none
+	RolledBackTo int `json:",omitempty"`
+	// Attempts is the number of times the version was queued for deployment
+	Attempts int
+	// ReceivedAt and FinishedAt are unix timestamps, FinishedAt is zero until the version has a result
+	ReceivedAt int64
+	FinishedAt int64         `json:",omitempty"`
+	Duration   time.Duration `json:",omitempty"`
+}
+
+// StackChangelog is the history of the versions of an Edge stack received by the agent, telling how far the device is
+// behind the server and why
+type StackChangelog struct {
+	StackID int
+	Name    string `json:",omitempty"`
+	// Version is the last version received and RunningVersion the last version deployed, 0 if none
+	Version        int
+	RunningVersion int
+	// Behind is the number of versions received since RunningVersion that were not deployed
+	Behind int
+	// Versions are the versions received, the most recent first
+	Versions []StackVersionRecord
+}
+
+// The codes of the remediations attached to the failures of the Edge stacks
+const (
+	// StackRemediationRegistryAuth is the code of the failures of the authentication to a registry
+	StackRemediationRegistryAuth = "registryAuth"
+	// StackRemediationImageNotFound is the code of the failures to find an image or one of its platforms
+	StackRemediationImageNotFound = "imageNotFound"
+	// StackRemediationRateLimited is the code of the pulls refused by a registry enforcing a rate limit
+	StackRemediationRateLimited = "rateLimited"
+	// StackRemediationRegistryUnreachable is the code of the failures to reach a registry
+	StackRemediationRegistryUnreachable = "registryUnreachable"
+	// StackRemediationDiskFull is the code of the failures caused by a full disk
+	StackRemediationDiskFull = "diskFull"
+	// StackRemediationInvalidStackFile is the code of the stack files rejected by the engine
+	StackRemediationInvalidStackFile = "invalidStackFile"
+	// StackRemediationForbidden is the code of the resources the agent is not allowed to manage
+	StackRemediationForbidden = "forbidden"
+)
+
+// StackRemediation is the guidance attached to the failure of an Edge stack, reported when the failure changes. A
+// remediation without code clears the previous one, once the stack is deployed.
+type StackRemediation struct {
+	// Code identifies the failure, e.g. StackRemediationRegistryAuth
+	Code string `json:",omitempty"`
+	// Class is the class of the error, StackErrorTransient or StackErrorTerminal
+	Class string `json:",omitempty"`
+	// Target is what the remediation applies to, e.g. the host of the registry or the path of the full disk
+	Target string `json:",omitempty"`
+	// Hint is the remediation displayed to the user
+	Hint  string `json:",omitempty"`
+	Error string `json:",omitempty"`
+	Time  int64
+}
+
+// StackServiceStatus is the status of a service of an Edge stack, aggregated over the containers running it
+type StackServiceStatus struct {
+	// Status is one of running, exited or restarting, the Docker state of the containers otherwise
+	Status string
+	// Containers is the number of containers running the service
+	Containers   int
+	RestartCount int
+	// ExitCode is the highest exit code of the exited containers
+	ExitCode int
+	// Error is the last error reported for the containers of the service
+	Error string
+}
+
+// StackUsage holds the cumulative data and time spent by the agent on an Edge stack, retries included
+type StackUsage struct {
+	// FileBytes is the size of the stack files received, from the payload or an OCI artifact
+	FileBytes int64
+	// ImageBytes is the uncompressed size of the image layers added while pulling and deploying the stack
+	ImageBytes int64
+	// SyncTime, PullTime and DeployTime are the time spent receiving the files, pulling the images and deploying the stack
+	SyncTime   time.Duration
+	PullTime   time.Duration
+	DeployTime time.Duration
+	Pulls      int
+	Deploys    int
+}
+
+// StackBatchStatus is the composite status of a batch of Edge stacks deployed as a single unit
+type StackBatchStatus struct {
+	// Status is one of Deploying, Completed, RollingBack, RolledBack or Failed
+	Status string
+	// Stacks holds the status of each stack of the batch
+	Stacks map[int]string
+	// Ready is the number of stacks of the batch that reached their target status
+	Ready int
+	Total int
+	Error string
+	Time  int64
 }
 
 type setEndpointIDFn func(portainer.EndpointID)
@@ -96,3 +547,97 @@ func NewPortainerClient(serverAddress string, setEIDFn setEndpointIDFn, getEIDFn
 
 	return NewPortainerEdgeClient(serverAddress, setEIDFn, getEIDFn, edgeID, agentPlatform, metaFields, httpClient)
 }
+
+// HostInfo is the operating system of the device, its container runtime and its package updates, reported for the
+// compliance dashboards
+type HostInfo struct {
+	// OS is the pretty name of the distribution, Distribution and Version its identifier and version
+	OS           string
+	Distribution string
+	Version      string
+	Kernel       string
+	Architecture string
+	// Runtimes maps the components of the container runtime to their version
+	Runtimes map[string]string `json:",omitempty"`
+	// PendingUpdates is nil when the pending updates are not checked
+	PendingUpdates *PendingUpdates `json:",omitempty"`
+	// LastUpdate is the last package update run on the host, nil when none was run since the agent started
+	LastUpdate *HostUpdateStatus `json:",omitempty"`
+	// Storage is the disk used by the files of the Edge stacks and the job logs, nil when it is not bounded
+	Storage *StorageUsage `json:",omitempty"`
+	// FIPS is the FIPS status of the agent
+	FIPS crypto.FIPSStatus
+}
+
+// StorageUsage is the disk used by the files of the Edge stacks and the job logs, in bytes, as measured by the last
+// check of the storage quota
+type StorageUsage struct {
+	// Stacks is the size of the files of the stacks, Success the size of their last successfully deployed files
+	// and History the size of their retained versions
+	Stacks  int64
+	Success int64
+	History int64
+	Logs    int64
+	// Quota is the maximum size of the files, 0 when unlimited
+	Quota int64
+	// EvictedVersions, TruncatedLogs and EvictedBytes count the evictions since the agent started
+	EvictedVersions int
+	TruncatedLogs   int
+	EvictedBytes    int64
+	// CheckedAt is the unix timestamp of the check
+	CheckedAt int64
+}
+
+// PendingUpdates are the package updates available for the host
+type PendingUpdates struct {
+	PackageManager string
+	Packages       int
+	// Security is nil when the package manager does not tell the security updates apart
+	Security *int `json:",omitempty"`
+	// CheckedAt is the unix timestamp of the check
+	CheckedAt int64
+}
+
+// The statuses of the package updates of the host
+const (
+	HostUpdateRunning   = "running"
+	HostUpdateSucceeded = "succeeded"
+	HostUpdateFailed    = "failed"
+)
+
+// HostUpdateStatus is the status of a package update of the host
+type HostUpdateStatus struct {
+	ID     int
+	Action string
+	Status string
+	// Start and End are unix timestamps, End is zero while the update is running
+	Start int64
+	End   int64
+	// Output is the end of the output of the package manager
+	Output string `json:",omitempty"`
+	Error  string `json:",omitempty"`
+}
+
+// The statuses of the reboots and shutdowns of the device
+const (
+	// StatusPendingReboot and StatusPendingShutdown are reported during the grace period, while the stacks are stopped
+	StatusPendingReboot   = "pendingReboot"
+	StatusPendingShutdown = "pendingShutdown"
+	// StatusBooted confirms the device started again after the reboot or the shutdown
+	StatusBooted = "booted"
+	// StatusPowerRefused is reported when the reboot or the shutdown is requested during the critical hours of the device
+	StatusPowerRefused = "refused"
+	StatusPowerFailed  = "failed"
+)
+
+// PowerStatus is the status of a reboot or a shutdown of the device
+type PowerStatus struct {
+	ID     int
+	Action string
+	Status string
+	// ScheduledAt is the unix timestamp the device reboots or shuts down at, the end of the grace period
+	ScheduledAt int64 `json:",omitempty"`
+	// Time is the unix timestamp of the status
+	Time  int64
+	Error string `json:",omitempty"`
+}
@@ -0,0 +1,131 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"strings"
+
+	"github.com/portainer/agent"
+)
+
+// AgentMetadata identifies the agent in the requests sent to the Portainer server and in the async polls, so that
+// the analytics and the WAF rules of the server can segment the traffic of the agents
+type AgentMetadata struct {
+	Version  string `json:"version"`
+	OS       string `json:"os"`
+	Arch     string `json:"arch"`
+	Engine   string `json:"engine,omitempty"`
+	FleetTag string `json:"fleetTag,omitempty"`
+}
+
+// reservedHeaders cannot be set by the extra headers, the server relies on them
+var reservedHeaders = []string{
+	"Content-Encoding",
+	"Content-Length",
+	"Content-Type",
+	"Host",
+	"If-None-Match",
+	agent.HTTPResponseAgentHeaderName,
+	agent.HTTPResponseAgentPlatform,
+}
+
+// ParseExtraHeaders parses the headers in the Name: value format added to the requests sent to the server
+func ParseExtraHeaders(headers []string) (http.Header, error) {
+	parsed := make(http.Header)
+
+	for _, header := range headers {
+		name, value, ok := strings.Cut(header, ":")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("invalid header %q, use the Name: value format", header)
+		}
+
+		name = http.CanonicalHeaderKey(name)
+		if isReservedHeader(name) {
+			return nil, fmt.Errorf("the header %s cannot be overridden", name)
+		}
+
+		parsed.Add(name, value)
+	}
+
+	return parsed, nil
+}
+
+func isReservedHeader(name string) bool {
+	if strings.HasPrefix(name, "X-Portaineragent-") {
+		return true
+	}
+
+	for _, reserved := range reservedHeaders {
+		if http.CanonicalHeaderKey(reserved) == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SetEngine sets the container engine reported along with the requests, e.g. podman
+func (c *edgeHTTPClient) SetEngine(engine string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.metadata.Engine = engine
+}
+
+// Metadata returns the metadata identifying the agent
+func (c *edgeHTTPClient) Metadata() AgentMetadata {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.metadata
+}
+
+// userAgent returns the User-Agent of the requests, portainer-agent/<version> (<os>; <arch>; <engine>) by default.
+// The caller must hold the lock.
+func (c *edgeHTTPClient) userAgent() string {
+	if c.options != nil && c.options.EdgeUserAgent != "" {
+		return c.options.EdgeUserAgent
+	}
+
+	details := []string{c.metadata.OS, c.metadata.Arch}
+	if c.metadata.Engine != "" {
+		details = append(details, c.metadata.Engine)
+	}
+
+	return fmt.Sprintf("portainer-agent/%s (%s)", c.metadata.Version, strings.Join(details, "; "))
+}
+
+// setMetadataHeaders adds the headers identifying the agent and the extra headers to the request.
+// The caller must hold the lock.
+func (c *edgeHTTPClient) setMetadataHeaders(req *http.Request) {
+	req.Header.Set("User-Agent", c.userAgent())
+
+	if c.metadata.Engine != "" {
+		req.Header.Set(agent.HTTPEngineHeaderName, c.metadata.Engine)
+	}
+
+	if c.metadata.FleetTag != "" {
+		req.Header.Set(agent.HTTPFleetTagHeaderName, c.metadata.FleetTag)
+	}
+
+	for name, values := range c.extraHeaders {
+		req.Header[name] = values
+	}
+}
+
+func newAgentMetadata(options *agent.Options) AgentMetadata {
+	metadata := AgentMetadata{
+		Version: agent.Version,
+		OS:      runtime.GOOS,
+		Arch:    runtime.GOARCH,
+	}
+
+	if options != nil {
+		metadata.FleetTag = options.EdgeFleetTag
+	}
+
+	return metadata
+}
@@ -0,0 +1,49 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/portainer/agent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseExtraHeaders(t *testing.T) {
+	headers, err := ParseExtraHeaders([]string{"x-waf-token: secret", "X-Region:eu-west"})
+	require.NoError(t, err)
+	assert.Equal(t, http.Header{"X-Waf-Token": {"secret"}, "X-Region": {"eu-west"}}, headers)
+
+	for _, header := range []string{"no-separator", ": value", "X-PortainerAgent-EdgeID: other", "portainer-agent: 1.0"} {
+		_, err := ParseExtraHeaders([]string{header})
+		assert.Error(t, err, header)
+	}
+}
+
+func TestEdgeHTTPClient_metadataHeaders(t *testing.T) {
+	var received http.Header
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header
+	}))
+	defer server.Close()
+
+	c := BuildHTTPClient(10, &agent.Options{EdgeFleetTag: "stores-eu", EdgeExtraHeaders: []string{"X-Waf-Token: secret"}})
+	c.httpClient = server.Client()
+	c.SetEngine("podman")
+
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+
+	resp, err := c.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Contains(t, received.Get("User-Agent"), "portainer-agent/"+agent.Version)
+	assert.Contains(t, received.Get("User-Agent"), "; podman)")
+	assert.Equal(t, "podman", received.Get(agent.HTTPEngineHeaderName))
+	assert.Equal(t, "stores-eu", received.Get(agent.HTTPFleetTagHeaderName))
+	assert.Equal(t, "secret", received.Get("X-Waf-Token"))
+	assert.Equal(t, "stores-eu", c.Metadata().FleetTag)
+}
@@ -3,7 +3,6 @@ package client
 import (
 	"bytes"
 	"compress/gzip"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"hash/fnv"
@@ -16,10 +15,10 @@ import (
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/image"
 	"github.com/portainer/agent"
+	"github.com/portainer/agent/containerd"
 	"github.com/portainer/agent/docker"
 	"github.com/portainer/agent/kubernetes"
 	portainer "github.com/portainer/portainer/api"
-	"github.com/portainer/portainer/api/edge"
 	"github.com/rs/zerolog/log"
 	"github.com/wI2L/jsondiff"
 )
@@ -74,6 +73,7 @@ type AsyncRequest struct {
 	Snapshot         *snapshot            `json:"snapshot,omitempty"`
 	EndpointId       portainer.EndpointID `json:"endpointId,omitempty"`
 	MetaFields       *MetaFields          `json:"metaFields"`
+	Agent            *AgentMetadata       `json:"agent,omitempty"`
 }
 
 type EndpointLog struct {
@@ -96,10 +96,31 @@ type snapshot struct {
 	KubernetesPatch jsondiff.Patch                `json:"kubernetesPatch,omitempty"`
 	KubernetesHash  *uint32                       `json:"kubernetesHash,omitempty"`
 
-	StackLogs        []EdgeStackLog                                                  `json:"stackLogs,omitempty"`
-	StackStatusArray map[portainer.EdgeStackID][]portainer.EdgeStackDeploymentStatus `json:"stackStatusArray,omitempty"`
-	JobsStatus       map[portainer.EdgeJobID]agent.EdgeJobStatus                     `json:"jobsStatus,omitempty"`
-	EdgeConfigStates map[EdgeConfigID]EdgeConfigStateType                            `json:"edgeConfigStates,omitempty"`
+	StackLogs         []EdgeStackLog                                                  `json:"stackLogs,omitempty"`
+	StackStatusArray  map[portainer.EdgeStackID][]portainer.EdgeStackDeploymentStatus `json:"stackStatusArray,omitempty"`
+	JobsStatus        map[portainer.EdgeJobID]agent.EdgeJobStatus                     `json:"jobsStatus,omitempty"`
+	EdgeConfigStates  map[EdgeConfigID]EdgeConfigStateType                            `json:"edgeConfigStates,omitempty"`
+	StackBatches      map[int]StackBatchStatus                                        `json:"stackBatches,omitempty"`
+	StackJobResults   map[int]StackJobResult                                          `json:"stackJobResults,omitempty"`
+	StackServices     map[int]map[string]StackServiceStatus                           `json:"stackServices,omitempty"`
+	StackUsage        map[int]StackUsage                                              `json:"stackUsage,omitempty"`
+	StagedStacks      map[int]StackStaged                                             `json:"stagedStacks,omitempty"`
+	StackPulls        map[int]StackPullProgress                                       `json:"stackPulls,omitempty"`
+	StackConfigs      map[int]StackConfigHash                                         `json:"stackConfigs,omitempty"`
+	StackChangelogs   map[int]StackChangelog                                          `json:"stackChangelogs,omitempty"`
+	StackRemediations map[int]StackRemediation                                        `json:"stackRemediations,omitempty"`
+	StackErrorClasses map[int]string                                                  `json:"stackErrorClasses,omitempty"`
+	StackInventory    []DeployedStack                                                 `json:"stackInventory,omitempty"`
+	JobHistory        map[portainer.EdgeJobID][]agent.EdgeJobExecution                `json:"jobHistory,omitempty"`
+	JobFailures       map[portainer.EdgeJobID]agent.EdgeJobExecution                  `json:"jobFailures,omitempty"`
+	FileTransfers     map[int]FileTransferResult                                      `json:"fileTransfers,omitempty"`
+	StackCommands     map[int]StackCommandResult                                      `json:"stackCommands,omitempty"`
+	Backups           map[int]BackupResult                                            `json:"backups,omitempty"`
+	Labels            map[string]string                                               `json:"labels,omitempty"`
+	Host              *HostInfo                                                       `json:"host,omitempty"`
+	Power             *PowerStatus                                                    `json:"power,omitempty"`
+	// Profile is the profile the agent runs with, standard or minimal
+	Profile string `json:"profile,omitempty"`
 }
 
 type AsyncResponse struct {
@@ -129,12 +150,19 @@ type EdgeJobData struct {
 	CronExpression    string
 	ScriptFileContent string
 	Version           int
+	Timeout           time.Duration
+	Retries           int
+	RetryDelay        time.Duration
+	RunAs             agent.JobRunAs
 }
 
 type LogCommandData struct {
 	EdgeStackID   portainer.EdgeStackID
 	EdgeStackName string
 	Tail          int
+	// ProjectName is the project the stack is deployed as, resolved by the agent.
+	// The project is named after the stack with the default prefix when empty.
+	ProjectName string
 }
 
 type ContainerCommandData struct {
@@ -162,6 +190,145 @@ type NormalStackCommandData struct {
 	StackOperation   string
 }
 
+// StackBatchCommandData is used to deploy several Edge stacks as a single unit
+type StackBatchCommandData struct {
+	BatchID int
+	Stacks  []StackPayload
+}
+
+// StackBarrierCommandData is used to release the barrier an Edge stack is staged at
+type StackBarrierCommandData struct {
+	StackID int
+	Version int
+	// ReleaseAt is the unix timestamp the stack is deployed at
+	ReleaseAt int64
+}
+
+// ClusterKeyCommandData is used to rotate the encryption key of the agent cluster gossip
+type ClusterKeyCommandData struct {
+	// Key is the base64 encoded new encryption key
+	Key string
+	// Overlap is how long the previous key is still accepted, in seconds
+	Overlap int
+}
+
+// FreezeCommandData is used to freeze the device, or a single stack, at its current version until a date
+type FreezeCommandData struct {
+	// StackID is the frozen stack, zero freezes all the stacks of the device
+	StackID int
+	// Until is the end of the freeze window, in unix seconds
+	Until  int64
+	Reason string
+}
+
+// FileTransferCommandData is used to push a file to the host, or to pull a file of the host, with the push and
+// pull operations
+type FileTransferCommandData struct {
+	ID   int
+	Path string
+	// Content is the base64 encoded content of the pushed file, Checksum its hex encoded SHA-256
+	Content  string
+	Checksum string
+	// Mode is the mode of the pushed file, 0644 when zero
+	Mode uint32
+}
+
+// FileTransferResult is the result of a file transfer
+type FileTransferResult struct {
+	Direction string
+	Path      string
+	Size      int64
+	Checksum  string
+	// Content is the base64 encoded content of the pulled file
+	Content string `json:",omitempty"`
+	Error   string `json:",omitempty"`
+}
+
+// StackRunCommandData is used to run a one-off command with the image, the environment and the volumes of a service
+// of a deployed Edge stack, the same way as the docker compose run --rm command
+type StackRunCommandData struct {
+	ID      int
+	StackID int
+	Service string
+	// Command replaces the command of the service, the command of the service is run when it is empty
+	Command []string
+	// Timeout is how long the command can run in seconds, the default of the agent when zero
+	Timeout int
+}
+
+// StackCommandResult is the result of a one-off command run in the context of a service of an Edge stack
+type StackCommandResult struct {
+	StackID int
+	Service string
+	// ExitCode is the exit code of the command, -1 when it could not run
+	ExitCode int
+	// Output holds the combined standard and error outputs of the command, only their end is kept when they are too
+	// large to be reported and Truncated is set
+	Output    string
+	Truncated bool
+	Error     string `json:",omitempty"`
+	Started   int64
+	Finished  int64
+}
+
+// BackupCommandData is used to export the Edge stacks and the settings of the agent to an archive, or to import
+// the archive exported from another device, with the export and import operations
+type BackupCommandData struct {
+	ID int
+	// Content is the base64 encoded archive to import, Checksum its hex encoded SHA-256
+	Content  string
+	Checksum string
+}
+
+// BackupResult is the result of an export or an import of the Edge stacks and the settings of the agent
+type BackupResult struct {
+	Operation string
+	Size      int64
+	Checksum  string
+	// Content is the base64 encoded exported archive
+	Content string `json:",omitempty"`
+	// Stacks are the identifiers of the Edge stacks of the archive
+	Stacks []int
+	// Settings are the settings of the agent of the imported archive, with their secrets redacted
+	Settings map[string]string `json:",omitempty"`
+	Error    string            `json:",omitempty"`
+}
+
+// HostUpdateCommandData is used to update the packages of the host with one of the actions allowed on the device
+type HostUpdateCommandData struct {
+	ID     int
+	Action string
+}
+
+// PowerCommandData is used to reboot or shut down the device, with the reboot and shutdown actions
+type PowerCommandData struct {
+	ID     int
+	Action string
+	// GracePeriod is the time given to the stacks to stop, in seconds
+	GracePeriod int
+}
+
+// StackRollbackCommandData is used to redeploy a version of an Edge stack retained by the agent
+type StackRollbackCommandData struct {
+	StackID int
+	Version int
+}
+
+// StackRestartCommandData is used to restart the workloads of a deployed Edge stack without a new version
+type StackRestartCommandData struct {
+	StackID int
+}
+
+// reportedPlatform returns the platform sent to Portainer, the containerd engines are reported as Docker
+// engines as their snapshots are in the Docker format
+func (client *PortainerAsyncClient) reportedPlatform() agent.ContainerPlatform {
+	if client.agentPlatformIdentifier == agent.PlatformContainerd {
+		return agent.PlatformDocker
+	}
+
+	return client.agentPlatformIdentifier
+}
+
 func (client *PortainerAsyncClient) GetEnvironmentID() (portainer.EndpointID, error) {
 	return 0, errors.New("GetEnvironmentID is not available in async mode")
 }
@@ -183,11 +350,16 @@ func (client *PortainerAsyncClient) GetEnvironmentStatus(flags ...string) (*Poll
 
 	var currentSnapshot snapshot
 	if doSnapshot {
-		payload.Snapshot = &snapshot{}
+		payload.Snapshot = &snapshot{Profile: client.httpClient.profile()}
 
 		switch client.agentPlatformIdentifier {
 		case agent.PlatformDocker:
-			dockerSnapshot, err := docker.CreateSnapshot()
+			createSnapshot := docker.CreateSnapshot
+			if client.httpClient.minimalSnapshots() {
+				createSnapshot = docker.CreateMinimalSnapshot
+			}
+
+			dockerSnapshot, err := createSnapshot()
 			if err != nil {
 				log.Warn().Err(err).Msg("could not create the Docker snapshot")
 			}
@@ -195,7 +367,11 @@ func (client *PortainerAsyncClient) GetEnvironmentStatus(flags ...string) (*Poll
 			optimizeDockerSnapshot(dockerSnapshot)
 
 			payload.Snapshot.Docker = dockerSnapshot
-			currentSnapshot.Docker = dockerSnapshot
+
+			// The minimal snapshots are sent whole, they are smaller than the previous snapshot kept for the patches
+			if !client.httpClient.minimalSnapshots() {
+				currentSnapshot.Docker = dockerSnapshot
+			}
 
 			if client.lastSnapshot.Docker != nil && !client.snapshotRetried {
 				h, ok := snapshotHash(client.lastSnapshot.Docker)
@@ -212,7 +388,12 @@ func (client *PortainerAsyncClient) GetEnvironmentStatus(flags ...string) (*Poll
 			}
 
 			for _, stack := range client.stackLogCollectionQueue {
-				cs, err := docker.GetContainersWithLabel("com.docker.compose.project=edge_" + stack.EdgeStackName)
+				project := stack.ProjectName
+				if project == "" {
+					project = "edge_" + stack.EdgeStackName
+				}
+
+				cs, err := docker.GetContainersWithLabel("com.docker.compose.project=" + project)
 				if err != nil {
 					log.Warn().
 						Str("stack", stack.EdgeStackName).
@@ -222,7 +403,7 @@ func (client *PortainerAsyncClient) GetEnvironmentStatus(flags ...string) (*Poll
 					continue
 				}
 
-				cs2, err := docker.GetContainersWithLabel("com.docker.stack.namespace=edge_" + stack.EdgeStackName)
+				cs2, err := docker.GetContainersWithLabel("com.docker.stack.namespace=" + project)
 				if err != nil {
 					log.Warn().Err(err).Msg("could not retrieve containers for stack")
 
@@ -258,6 +439,15 @@ func (client *PortainerAsyncClient) GetEnvironmentStatus(flags ...string) (*Poll
 				}
 			}
 
+		case agent.PlatformContainerd:
+			containerdSnapshot, err := containerd.CreateSnapshot()
+			if err != nil {
+				log.Warn().Err(err).Msg("could not create the containerd snapshot")
+			}
+
+			payload.Snapshot.Docker = containerdSnapshot
+			currentSnapshot.Docker = containerdSnapshot
+
 		case agent.PlatformKubernetes:
 			kubeSnapshot, err := kubernetes.CreateSnapshot()
 			if err != nil {
@@ -286,6 +476,25 @@ func (client *PortainerAsyncClient) GetEnvironmentStatus(flags ...string) (*Poll
 		payload.Snapshot.StackStatusArray = client.nextSnapshot.StackStatusArray
 		payload.Snapshot.JobsStatus = client.nextSnapshot.JobsStatus
 		payload.Snapshot.EdgeConfigStates = client.nextSnapshot.EdgeConfigStates
+		payload.Snapshot.StackBatches = client.nextSnapshot.StackBatches
+		payload.Snapshot.StackJobResults = client.nextSnapshot.StackJobResults
+		payload.Snapshot.StackServices = client.nextSnapshot.StackServices
+		payload.Snapshot.StackUsage = client.nextSnapshot.StackUsage
+		payload.Snapshot.StagedStacks = client.nextSnapshot.StagedStacks
+		payload.Snapshot.StackPulls = client.nextSnapshot.StackPulls
+		payload.Snapshot.StackConfigs = client.nextSnapshot.StackConfigs
+		payload.Snapshot.StackChangelogs = client.nextSnapshot.StackChangelogs
+		payload.Snapshot.StackRemediations = client.nextSnapshot.StackRemediations
+		payload.Snapshot.StackErrorClasses = client.nextSnapshot.StackErrorClasses
+		payload.Snapshot.StackInventory = client.nextSnapshot.StackInventory
+		payload.Snapshot.JobHistory = client.nextSnapshot.JobHistory
+		payload.Snapshot.JobFailures = client.nextSnapshot.JobFailures
+		payload.Snapshot.FileTransfers = client.nextSnapshot.FileTransfers
+		payload.Snapshot.StackCommands = client.nextSnapshot.StackCommands
+		payload.Snapshot.Backups = client.nextSnapshot.Backups
+		payload.Snapshot.Labels = client.nextSnapshot.Labels
+		payload.Snapshot.Host = client.nextSnapshot.Host
+		payload.Snapshot.Power = client.nextSnapshot.Power
 		client.nextSnapshotMutex.Unlock()
 	}
 
@@ -293,6 +502,9 @@ func (client *PortainerAsyncClient) GetEnvironmentStatus(flags ...string) (*Poll
 		payload.CommandTimestamp = client.commandTimestamp
 	}
 
+	metadata := client.httpClient.Metadata()
+	payload.Agent = &metadata
+
 	if len(client.metaFields.EdgeGroupsIDs) > 0 || len(client.metaFields.TagsIDs) > 0 || client.metaFields.EnvironmentGroupID > 0 {
 		payload.MetaFields = &MetaFields{
 			EdgeGroupsIDs:      client.metaFields.EdgeGroupsIDs,
@@ -331,6 +543,25 @@ func (client *PortainerAsyncClient) GetEnvironmentStatus(flags ...string) (*Poll
 		client.nextSnapshot.StackStatusArray = nil
 		client.nextSnapshot.JobsStatus = nil
 		client.nextSnapshot.EdgeConfigStates = nil
+		client.nextSnapshot.StackBatches = nil
+		client.nextSnapshot.StackJobResults = nil
+		client.nextSnapshot.StackServices = nil
+		client.nextSnapshot.StackUsage = nil
+		client.nextSnapshot.StagedStacks = nil
+		client.nextSnapshot.StackPulls = nil
+		client.nextSnapshot.StackConfigs = nil
+		client.nextSnapshot.StackChangelogs = nil
+		client.nextSnapshot.StackRemediations = nil
+		client.nextSnapshot.StackErrorClasses = nil
+		client.nextSnapshot.StackInventory = nil
+		client.nextSnapshot.JobHistory = nil
+		client.nextSnapshot.JobFailures = nil
+		client.nextSnapshot.FileTransfers = nil
+		client.nextSnapshot.StackCommands = nil
+		client.nextSnapshot.Backups = nil
+		client.nextSnapshot.Labels = nil
+		client.nextSnapshot.Host = nil
+		client.nextSnapshot.Power = nil
 		client.stackLogCollectionQueue = nil
 	}
 
@@ -348,7 +579,8 @@ func (client *PortainerAsyncClient) GetEnvironmentStatus(flags ...string) (*Poll
 	return response, nil
 }
 
-func gzipCompress(data []byte) (*bytes.Buffer, error) {
+// gzipEncode encodes the payload straight into the gzip stream, without holding its whole JSON encoding in memory
+func gzipEncode(payload any) (*bytes.Buffer, error) {
 	buf := &bytes.Buffer{}
 
 	gz, err := gzip.NewWriterLevel(buf, gzip.BestCompression)
@@ -356,7 +588,7 @@ func gzipCompress(data []byte) (*bytes.Buffer, error) {
 		return nil, err
 	}
 
-	_, err = gz.Write(data)
+	err = codec().NewEncoder(gz).Encode(payload)
 	if err != nil {
 		return nil, err
 	}
@@ -370,18 +602,20 @@ func gzipCompress(data []byte) (*bytes.Buffer, error) {
 }
 
 func (client *PortainerAsyncClient) executeAsyncRequest(payload AsyncRequest, pollURL string) (*AsyncResponse, error) {
-	data, err := json.Marshal(payload)
-	if err != nil {
-		return nil, err
-	}
-
 	var buf *bytes.Buffer
-	if payload.Snapshot != nil {
-		buf, err = gzipCompress(data)
+	var err error
+
+	if payload.Snapshot != nil && client.httpClient.compressionMode() != CompressionOff {
+		buf, err = gzipEncode(payload)
 		if err != nil {
 			return nil, err
 		}
 	} else {
+		data, err := codec().Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+
 		buf = bytes.NewBuffer(data)
 	}
 
@@ -390,7 +624,7 @@ func (client *PortainerAsyncClient) executeAsyncRequest(payload AsyncRequest, po
 		return nil, err
 	}
 
-	if payload.Snapshot != nil {
+	if payload.Snapshot != nil && client.httpClient.compressionMode() != CompressionOff {
 		req.Header.Set("Content-Encoding", "gzip")
 	}
 
@@ -398,12 +632,12 @@ func (client *PortainerAsyncClient) executeAsyncRequest(payload AsyncRequest, po
 	req.Header.Set(agent.HTTPResponseAgentHeaderName, agent.Version)
 	req.Header.Set(agent.HTTPResponseAgentTimeZone, time.Local.String())
 	req.Header.Set(agent.HTTPResponseUpdateIDHeaderName, strconv.Itoa(client.metaFields.UpdateID))
-	req.Header.Set(agent.HTTPResponseAgentPlatform, strconv.Itoa(int(client.agentPlatformIdentifier)))
+	req.Header.Set(agent.HTTPResponseAgentPlatform, strconv.Itoa(int(client.reportedPlatform())))
 
 	log.Debug().
 		Str(agent.HTTPEdgeIdentifierHeaderName, client.edgeID).
 		Int(agent.HTTPResponseUpdateIDHeaderName, (client.metaFields.UpdateID)).
-		Int(agent.HTTPResponseAgentPlatform, (int(client.agentPlatformIdentifier))).
+		Int(agent.HTTPResponseAgentPlatform, (int(client.reportedPlatform()))).
 		Str(agent.HTTPResponseAgentHeaderName, agent.Version).
 		Str(agent.HTTPResponseAgentTimeZone, time.Local.String()).
 		Msg("sending async request with headers")
@@ -428,7 +662,7 @@ func (client *PortainerAsyncClient) executeAsyncRequest(payload AsyncRequest, po
 	}
 
 	var asyncResponse AsyncResponse
-	err = json.NewDecoder(resp.Body).Decode(&asyncResponse)
+	err = codec().NewDecoder(resp.Body).Decode(&asyncResponse)
 	if err != nil {
 		return nil, err
 	}
@@ -441,6 +675,30 @@ func (client *PortainerAsyncClient) SetEdgeStackStatus(edgeStackID int, edgeStac
 	client.nextSnapshotMutex.Lock()
 	defer client.nextSnapshotMutex.Unlock()
 
+	client.addEdgeStackStatus(edgeStackID, edgeStackStatus, rollbackTo, err)
+
+	return nil
+}
+
+// SetEdgeStackError updates the status of an Edge stack to Error on the Portainer server, the class of the error is
+// added to the next snapshot along with the status
+func (client *PortainerAsyncClient) SetEdgeStackError(edgeStackID int, rollbackTo *int, errMessage, errorClass string) error {
+	client.nextSnapshotMutex.Lock()
+	defer client.nextSnapshotMutex.Unlock()
+
+	client.addEdgeStackStatus(edgeStackID, portainer.EdgeStackStatusError, rollbackTo, errMessage)
+
+	if client.nextSnapshot.StackErrorClasses == nil {
+		client.nextSnapshot.StackErrorClasses = make(map[int]string)
+	}
+
+	client.nextSnapshot.StackErrorClasses[edgeStackID] = errorClass
+
+	return nil
+}
+
+// addEdgeStackStatus adds the status of an Edge stack to the next snapshot. The caller must hold the snapshot lock.
+func (client *PortainerAsyncClient) addEdgeStackStatus(edgeStackID int, edgeStackStatus portainer.EdgeStackStatusType, rollbackTo *int, err string) {
 	if client.nextSnapshot.StackStatusArray == nil {
 		client.nextSnapshot.StackStatusArray = make(map[portainer.EdgeStackID][]portainer.EdgeStackDeploymentStatus)
 	}
@@ -462,6 +720,161 @@ func (client *PortainerAsyncClient) SetEdgeStackStatus(edgeStackID int, edgeStac
 	}
 
 	client.nextSnapshot.StackStatusArray[portainer.EdgeStackID(edgeStackID)] = status
+}
+
+// SetEdgeStackBatchStatus adds the composite status of a batch of Edge stacks to the next snapshot
+func (client *PortainerAsyncClient) SetEdgeStackBatchStatus(batchID int, status StackBatchStatus) error {
+	client.nextSnapshotMutex.Lock()
+	defer client.nextSnapshotMutex.Unlock()
+
+	if client.nextSnapshot.StackBatches == nil {
+		client.nextSnapshot.StackBatches = make(map[int]StackBatchStatus)
+	}
+
+	client.nextSnapshot.StackBatches[batchID] = status
+
+	return nil
+}
+
+// SetEdgeStackJobResult adds the outcome of an Edge stack running to completion to the next snapshot
+func (client *PortainerAsyncClient) SetEdgeStackJobResult(edgeStackID int, result StackJobResult) error {
+	client.nextSnapshotMutex.Lock()
+	defer client.nextSnapshotMutex.Unlock()
+
+	if client.nextSnapshot.StackJobResults == nil {
+		client.nextSnapshot.StackJobResults = make(map[int]StackJobResult)
+	}
+
+	client.nextSnapshot.StackJobResults[edgeStackID] = result
+
+	return nil
+}
+
+// SetEdgeStackServicesStatus adds the status of each service of an Edge stack to the next snapshot
+func (client *PortainerAsyncClient) SetEdgeStackServicesStatus(edgeStackID int, services map[string]StackServiceStatus) error {
+	client.nextSnapshotMutex.Lock()
+	defer client.nextSnapshotMutex.Unlock()
+
+	if client.nextSnapshot.StackServices == nil {
+		client.nextSnapshot.StackServices = make(map[int]map[string]StackServiceStatus)
+	}
+
+	client.nextSnapshot.StackServices[edgeStackID] = services
+
+	return nil
+}
+
+// SetEdgeStackUsage adds the cumulative usage of an Edge stack to the next snapshot
+func (client *PortainerAsyncClient) SetEdgeStackUsage(edgeStackID int, usage StackUsage) error {
+	client.nextSnapshotMutex.Lock()
+	defer client.nextSnapshotMutex.Unlock()
+
+	if client.nextSnapshot.StackUsage == nil {
+		client.nextSnapshot.StackUsage = make(map[int]StackUsage)
+	}
+
+	client.nextSnapshot.StackUsage[edgeStackID] = usage
+
+	return nil
+}
+
+// SetEdgeStackStaged adds an Edge stack staged at its barrier to the next snapshot
+func (client *PortainerAsyncClient) SetEdgeStackStaged(edgeStackID int, staged StackStaged) error {
+	client.nextSnapshotMutex.Lock()
+	defer client.nextSnapshotMutex.Unlock()
+
+	if client.nextSnapshot.StagedStacks == nil {
+		client.nextSnapshot.StagedStacks = make(map[int]StackStaged)
+	}
+
+	client.nextSnapshot.StagedStacks[edgeStackID] = staged
+
+	return nil
+}
+
+// SetEdgeStackPullProgress adds the progress of the image pull of an Edge stack to the next snapshot,
+// replacing the progress reported since the previous one
+func (client *PortainerAsyncClient) SetEdgeStackPullProgress(edgeStackID int, progress StackPullProgress) error {
+	client.nextSnapshotMutex.Lock()
+	defer client.nextSnapshotMutex.Unlock()
+
+	if client.nextSnapshot.StackPulls == nil {
+		client.nextSnapshot.StackPulls = make(map[int]StackPullProgress)
+	}
+
+	client.nextSnapshot.StackPulls[edgeStackID] = progress
+
+	return nil
+}
+
+// SetEdgeStackConfigHash adds the hash of the effective configuration of an Edge stack to the next snapshot
+func (client *PortainerAsyncClient) SetEdgeStackConfigHash(edgeStackID int, hash StackConfigHash) error {
+	client.nextSnapshotMutex.Lock()
+	defer client.nextSnapshotMutex.Unlock()
+
+	if client.nextSnapshot.StackConfigs == nil {
+		client.nextSnapshot.StackConfigs = make(map[int]StackConfigHash)
+	}
+
+	client.nextSnapshot.StackConfigs[edgeStackID] = hash
+
+	return nil
+}
+
+// SetEdgeStackChangelog adds the history of the versions of an Edge stack to the next snapshot
+func (client *PortainerAsyncClient) SetEdgeStackChangelog(edgeStackID int, changelog StackChangelog) error {
+	client.nextSnapshotMutex.Lock()
+	defer client.nextSnapshotMutex.Unlock()
+
+	if client.nextSnapshot.StackChangelogs == nil {
+		client.nextSnapshot.StackChangelogs = make(map[int]StackChangelog)
+	}
+
+	client.nextSnapshot.StackChangelogs[edgeStackID] = changelog
+
+	return nil
+}
+
+// SetEdgeStackRemediation adds the remediation of the failure of an Edge stack to the next snapshot
+func (client *PortainerAsyncClient) SetEdgeStackRemediation(edgeStackID int, remediation StackRemediation) error {
+	client.nextSnapshotMutex.Lock()
+	defer client.nextSnapshotMutex.Unlock()
+
+	if client.nextSnapshot.StackRemediations == nil {
+		client.nextSnapshot.StackRemediations = make(map[int]StackRemediation)
+	}
+
+	client.nextSnapshot.StackRemediations[edgeStackID] = remediation
+
+	return nil
+}
+
+// SetEdgeStackInventory adds the Edge stacks deployed on the device to the next snapshot
+func (client *PortainerAsyncClient) SetEdgeStackInventory(stacks []DeployedStack) error {
+	client.nextSnapshotMutex.Lock()
+	defer client.nextSnapshotMutex.Unlock()
+
+	client.nextSnapshot.StackInventory = stacks
+
+	return nil
+}
+
+// SetLabels adds the labels of the device to the next snapshot
+func (client *PortainerAsyncClient) SetLabels(labels map[string]string) error {
+	client.nextSnapshotMutex.Lock()
+	defer client.nextSnapshotMutex.Unlock()
+
+	client.nextSnapshot.Labels = labels
+
+	return nil
+}
+
+// SetHostInfo adds the information of the host to the next snapshot
+func (client *PortainerAsyncClient) SetHostInfo(info HostInfo) error {
+	client.nextSnapshotMutex.Lock()
+	defer client.nextSnapshotMutex.Unlock()
+
+	client.nextSnapshot.Host = &info
 
 	return nil
 }
@@ -480,6 +893,88 @@ func (client *PortainerAsyncClient) SetEdgeJobStatus(edgeJobStatus agent.EdgeJob
 	return nil
 }
 
+// SetEdgeJobHistory adds the executions of an Edge job to the next snapshot, along with the ones added since the previous one
+func (client *PortainerAsyncClient) SetEdgeJobHistory(edgeJobID int, executions []agent.EdgeJobExecution) error {
+	client.nextSnapshotMutex.Lock()
+	defer client.nextSnapshotMutex.Unlock()
+
+	if client.nextSnapshot.JobHistory == nil {
+		client.nextSnapshot.JobHistory = make(map[portainer.EdgeJobID][]agent.EdgeJobExecution)
+	}
+
+	id := portainer.EdgeJobID(edgeJobID)
+	client.nextSnapshot.JobHistory[id] = append(client.nextSnapshot.JobHistory[id], executions...)
+
+	return nil
+}
+
+// SetEdgeJobFailure adds to the next snapshot an execution of an Edge job that failed after all its attempts,
+// replacing the failure of the job added since the previous one
+func (client *PortainerAsyncClient) SetEdgeJobFailure(execution agent.EdgeJobExecution) error {
+	client.nextSnapshotMutex.Lock()
+	defer client.nextSnapshotMutex.Unlock()
+
+	if client.nextSnapshot.JobFailures == nil {
+		client.nextSnapshot.JobFailures = make(map[portainer.EdgeJobID]agent.EdgeJobExecution)
+	}
+
+	client.nextSnapshot.JobFailures[portainer.EdgeJobID(execution.JobID)] = execution
+
+	return nil
+}
+
+// SetFileTransferResult adds the result of a file transfer to the next snapshot
+func (client *PortainerAsyncClient) SetFileTransferResult(transferID int, result FileTransferResult) error {
+	client.nextSnapshotMutex.Lock()
+	defer client.nextSnapshotMutex.Unlock()
+
+	if client.nextSnapshot.FileTransfers == nil {
+		client.nextSnapshot.FileTransfers = make(map[int]FileTransferResult)
+	}
+
+	client.nextSnapshot.FileTransfers[transferID] = result
+
+	return nil
+}
+
+// SetStackCommandResult adds the result of a one-off command of an Edge stack to the next snapshot
+func (client *PortainerAsyncClient) SetStackCommandResult(commandID int, result StackCommandResult) error {
+	client.nextSnapshotMutex.Lock()
+	defer client.nextSnapshotMutex.Unlock()
+
+	if client.nextSnapshot.StackCommands == nil {
+		client.nextSnapshot.StackCommands = make(map[int]StackCommandResult)
+	}
+
+	client.nextSnapshot.StackCommands[commandID] = result
+
+	return nil
+}
+
+// SetBackupResult adds the result of an export or an import of the Edge stacks to the next snapshot
+func (client *PortainerAsyncClient) SetBackupResult(backupID int, result BackupResult) error {
+	client.nextSnapshotMutex.Lock()
+	defer client.nextSnapshotMutex.Unlock()
+
+	if client.nextSnapshot.Backups == nil {
+		client.nextSnapshot.Backups = make(map[int]BackupResult)
+	}
+
+	client.nextSnapshot.Backups[backupID] = result
+
+	return nil
+}
+
+// SetPowerStatus adds the status of a reboot or a shutdown of the device to the next snapshot
+func (client *PortainerAsyncClient) SetPowerStatus(status PowerStatus) error {
+	client.nextSnapshotMutex.Lock()
+	defer client.nextSnapshotMutex.Unlock()
+
+	client.nextSnapshot.Power = &status
+
+	return nil
+}
+
 func (client *PortainerAsyncClient) SetLastCommandTimestamp(timestamp time.Time) {
 	client.commandTimestamp = &timestamp
 }
@@ -489,7 +984,7 @@ func (client *PortainerAsyncClient) DeleteEdgeStackStatus(edgeStackID int) error
 }
 
 // GetEdgeStackConfig retrieves the configuration associated to an Edge stack
-func (client *PortainerAsyncClient) GetEdgeStackConfig(edgeStackID int, version *int) (*edge.StackPayload, error) {
+func (client *PortainerAsyncClient) GetEdgeStackConfig(edgeStackID int, version *int) (*StackPayload, error) {
 	// Async mode MUST NOT make any extra requests to Portainer, all the
 	// information exchange needs to happen via the async polling loop, which
 	// uses /endpoints/edge/async. This is a strict requirement.
@@ -525,7 +1020,7 @@ func (client *PortainerAsyncClient) EnqueueLogCollectionForStack(logCmd LogComma
 func snapshotHash(snapshot any) (uint32, bool) {
 	b := &bytes.Buffer{}
 
-	err := json.NewEncoder(b).Encode(snapshot)
+	err := codec().NewEncoder(b).Encode(snapshot)
 	if err != nil {
 		log.Error().Err(err).Msg("could not encode the snapshot")
 
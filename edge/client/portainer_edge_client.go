@@ -2,7 +2,6 @@ package client
 
 import (
 	"bytes"
-	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -12,7 +11,6 @@ import (
 
 	"github.com/portainer/agent"
 	portainer "github.com/portainer/portainer/api"
-	"github.com/portainer/portainer/api/edge"
 
 	lru "github.com/hashicorp/golang-lru"
 	"github.com/pkg/errors"
@@ -39,6 +37,7 @@ type globalKeyResponse struct {
 
 type setEdgeStackStatusPayload struct {
 	Error      string
+	ErrorClass string `json:",omitempty"`
 	Status     portainer.EdgeStackStatusType
 	EndpointID portainer.EndpointID
 	RollbackTo *int `json:",omitempty"`
@@ -102,7 +101,7 @@ func (client *PortainerEdgeClient) GetEnvironmentID() (portainer.EndpointID, err
 		}
 
 		var err error
-		payloadJson, err = json.Marshal(payload)
+		payloadJson, err = codec().Marshal(payload)
 		if err != nil {
 			return 0, errors.WithMessage(err, "failed to marshal meta fields")
 		}
@@ -129,7 +128,7 @@ func (client *PortainerEdgeClient) GetEnvironmentID() (portainer.EndpointID, err
 	}
 
 	var responseData globalKeyResponse
-	err = json.NewDecoder(resp.Body).Decode(&responseData)
+	err = codec().NewDecoder(resp.Body).Decode(&responseData)
 	if err != nil {
 		return 0, err
 	}
@@ -180,7 +179,7 @@ func (client *PortainerEdgeClient) GetEnvironmentStatus(flags ...string) (*PollS
 	}
 
 	var responseData PollStatusResponse
-	err = json.NewDecoder(resp.Body).Decode(&responseData)
+	err = codec().NewDecoder(resp.Body).Decode(&responseData)
 	if err != nil {
 		return nil, err
 	}
@@ -191,7 +190,7 @@ func (client *PortainerEdgeClient) GetEnvironmentStatus(flags ...string) (*PollS
 }
 
 // GetEdgeStackConfig retrieves the configuration associated to an Edge stack
-func (client *PortainerEdgeClient) GetEdgeStackConfig(edgeStackID int, version *int) (*edge.StackPayload, error) {
+func (client *PortainerEdgeClient) GetEdgeStackConfig(edgeStackID int, version *int) (*StackPayload, error) {
 	requestURL := fmt.Sprintf("%s/api/endpoints/%d/edge/stacks/%d", client.serverAddress, client.getEndpointIDFn(), edgeStackID)
 
 	if version != nil {
@@ -217,8 +216,8 @@ func (client *PortainerEdgeClient) GetEdgeStackConfig(edgeStackID int, version *
 		return nil, errors.New("GetEdgeStackConfig operation failed")
 	}
 
-	var data edge.StackPayload
-	err = json.NewDecoder(resp.Body).Decode(&data)
+	var data StackPayload
+	err = codec().NewDecoder(resp.Body).Decode(&data)
 	if err != nil {
 		return nil, err
 	}
@@ -233,21 +232,36 @@ func (client *PortainerEdgeClient) SetEdgeStackStatus(
 	rollbackTo *int,
 	error string,
 ) error {
-	payload := setEdgeStackStatusPayload{
+	return client.setEdgeStackStatus(edgeStackID, setEdgeStackStatusPayload{
 		Error:      error,
 		Status:     edgeStackStatus,
 		EndpointID: client.getEndpointIDFn(),
 		RollbackTo: rollbackTo,
 		Time:       time.Now().Unix(),
-	}
+	})
+}
+
+// SetEdgeStackError updates the status of an Edge stack to Error on the Portainer server, along with the class of the
+// error
+func (client *PortainerEdgeClient) SetEdgeStackError(edgeStackID int, rollbackTo *int, errMessage, errorClass string) error {
+	return client.setEdgeStackStatus(edgeStackID, setEdgeStackStatusPayload{
+		Error:      errMessage,
+		ErrorClass: errorClass,
+		Status:     portainer.EdgeStackStatusError,
+		EndpointID: client.getEndpointIDFn(),
+		RollbackTo: rollbackTo,
+		Time:       time.Now().Unix(),
+	})
+}
 
+func (client *PortainerEdgeClient) setEdgeStackStatus(edgeStackID int, payload setEdgeStackStatusPayload) error {
 	log.Debug().
 		Int("edgeStackID", edgeStackID).
-		Int("edgeStackStatus", int(edgeStackStatus)).
+		Int("edgeStackStatus", int(payload.Status)).
 		Int("time_check", int(payload.Time)).
 		Msg("SetEdgeStackStatus")
 
-	data, err := json.Marshal(payload)
+	data, err := codec().Marshal(payload)
 	if err != nil {
 		return err
 	}
@@ -266,8 +280,13 @@ func (client *PortainerEdgeClient) SetEdgeStackStatus(
 
 		resp, err = client.httpClient.Do(req)
 		if err != nil {
-			log.Error().
-				Err(err).
+			// The unavailability of the server is logged once by the HTTP client
+			event := log.Error()
+			if errors.Is(err, ErrCircuitOpen) {
+				event = log.Debug()
+			}
+
+			event.Err(err).
 				Int("edgeStackID", edgeStackID).
 				Msg("could not set edge stack status, retrying...")
 
@@ -300,13 +319,442 @@ func (client *PortainerEdgeClient) SetEdgeStackStatus(
 	return nil
 }
 
+// SetEdgeStackBatchStatus sends the composite status of a batch of Edge stacks to the Portainer server
+func (client *PortainerEdgeClient) SetEdgeStackBatchStatus(batchID int, status StackBatchStatus) error {
+	data, err := codec().Marshal(status)
+	if err != nil {
+		return err
+	}
+
+	requestURL := fmt.Sprintf("%s/api/endpoints/%d/edge/stacks/batches/%d/status", client.serverAddress, client.getEndpointIDFn(), batchID)
+
+	req, err := http.NewRequest(http.MethodPut, requestURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set(agent.HTTPEdgeIdentifierHeaderName, client.edgeID)
+
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		log.Error().Int("response_code", resp.StatusCode).Msg("SetEdgeStackBatchStatus operation failed")
+
+		return errors.New("SetEdgeStackBatchStatus operation failed")
+	}
+
+	return nil
+}
+
+// SetEdgeStackJobResult sends the outcome of an Edge stack running to completion to the Portainer server
+func (client *PortainerEdgeClient) SetEdgeStackJobResult(edgeStackID int, result StackJobResult) error {
+	data, err := codec().Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	requestURL := fmt.Sprintf("%s/api/endpoints/%d/edge/stacks/%d/job", client.serverAddress, client.getEndpointIDFn(), edgeStackID)
+
+	req, err := http.NewRequest(http.MethodPut, requestURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set(agent.HTTPEdgeIdentifierHeaderName, client.edgeID)
+
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		log.Error().Int("response_code", resp.StatusCode).Msg("SetEdgeStackJobResult operation failed")
+
+		return errors.New("SetEdgeStackJobResult operation failed")
+	}
+
+	return nil
+}
+
+// SetEdgeStackServicesStatus sends the status of each service of an Edge stack to the Portainer server
+func (client *PortainerEdgeClient) SetEdgeStackServicesStatus(edgeStackID int, services map[string]StackServiceStatus) error {
+	data, err := codec().Marshal(services)
+	if err != nil {
+		return err
+	}
+
+	requestURL := fmt.Sprintf("%s/api/endpoints/%d/edge/stacks/%d/services", client.serverAddress, client.getEndpointIDFn(), edgeStackID)
+
+	req, err := http.NewRequest(http.MethodPut, requestURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set(agent.HTTPEdgeIdentifierHeaderName, client.edgeID)
+
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		log.Error().Int("response_code", resp.StatusCode).Msg("SetEdgeStackServicesStatus operation failed")
+
+		return errors.New("SetEdgeStackServicesStatus operation failed")
+	}
+
+	return nil
+}
+
+// SetEdgeStackUsage sends the cumulative usage of an Edge stack to the Portainer server
+func (client *PortainerEdgeClient) SetEdgeStackUsage(edgeStackID int, usage StackUsage) error {
+	data, err := codec().Marshal(usage)
+	if err != nil {
+		return err
+	}
+
+	requestURL := fmt.Sprintf("%s/api/endpoints/%d/edge/stacks/%d/usage", client.serverAddress, client.getEndpointIDFn(), edgeStackID)
+
+	req, err := http.NewRequest(http.MethodPut, requestURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set(agent.HTTPEdgeIdentifierHeaderName, client.edgeID)
+
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		log.Error().Int("response_code", resp.StatusCode).Msg("SetEdgeStackUsage operation failed")
+
+		return errors.New("SetEdgeStackUsage operation failed")
+	}
+
+	return nil
+}
+
+// SetEdgeStackStaged notifies the Portainer server that an Edge stack is staged at its barrier
+func (client *PortainerEdgeClient) SetEdgeStackStaged(edgeStackID int, staged StackStaged) error {
+	data, err := codec().Marshal(staged)
+	if err != nil {
+		return err
+	}
+
+	requestURL := fmt.Sprintf("%s/api/endpoints/%d/edge/stacks/%d/staged", client.serverAddress, client.getEndpointIDFn(), edgeStackID)
+
+	req, err := http.NewRequest(http.MethodPut, requestURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set(agent.HTTPEdgeIdentifierHeaderName, client.edgeID)
+
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		log.Error().Int("response_code", resp.StatusCode).Msg("SetEdgeStackStaged operation failed")
+
+		return errors.New("SetEdgeStackStaged operation failed")
+	}
+
+	return nil
+}
+
+// SetEdgeStackPullProgress sends the progress of the image pull of an Edge stack to the Portainer server
+func (client *PortainerEdgeClient) SetEdgeStackPullProgress(edgeStackID int, progress StackPullProgress) error {
+	data, err := codec().Marshal(progress)
+	if err != nil {
+		return err
+	}
+
+	requestURL := fmt.Sprintf("%s/api/endpoints/%d/edge/stacks/%d/pull_progress", client.serverAddress, client.getEndpointIDFn(), edgeStackID)
+
+	req, err := http.NewRequest(http.MethodPut, requestURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set(agent.HTTPEdgeIdentifierHeaderName, client.edgeID)
+
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		log.Error().Int("response_code", resp.StatusCode).Msg("SetEdgeStackPullProgress operation failed")
+
+		return errors.New("SetEdgeStackPullProgress operation failed")
+	}
+
+	return nil
+}
+
+// SetEdgeStackConfigHash sends the hash of the effective configuration of an Edge stack to the Portainer server
+func (client *PortainerEdgeClient) SetEdgeStackConfigHash(edgeStackID int, hash StackConfigHash) error {
+	data, err := codec().Marshal(hash)
+	if err != nil {
+		return err
+	}
+
+	requestURL := fmt.Sprintf("%s/api/endpoints/%d/edge/stacks/%d/config_hash", client.serverAddress, client.getEndpointIDFn(), edgeStackID)
+
+	req, err := http.NewRequest(http.MethodPut, requestURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set(agent.HTTPEdgeIdentifierHeaderName, client.edgeID)
+
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		log.Error().Int("response_code", resp.StatusCode).Msg("SetEdgeStackConfigHash operation failed")
+
+		return errors.New("SetEdgeStackConfigHash operation failed")
+	}
+
+	return nil
+}
+
+// SetEdgeStackChangelog sends the history of the versions of an Edge stack to the Portainer server
+func (client *PortainerEdgeClient) SetEdgeStackChangelog(edgeStackID int, changelog StackChangelog) error {
+	data, err := codec().Marshal(changelog)
+	if err != nil {
+		return err
+	}
+
+	requestURL := fmt.Sprintf("%s/api/endpoints/%d/edge/stacks/%d/changelog", client.serverAddress, client.getEndpointIDFn(), edgeStackID)
+
+	req, err := http.NewRequest(http.MethodPut, requestURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set(agent.HTTPEdgeIdentifierHeaderName, client.edgeID)
+
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		log.Error().Int("response_code", resp.StatusCode).Msg("SetEdgeStackChangelog operation failed")
+
+		return errors.New("SetEdgeStackChangelog operation failed")
+	}
+
+	return nil
+}
+
+// SetEdgeStackRemediation sends the remediation of the failure of an Edge stack to the Portainer server
+func (client *PortainerEdgeClient) SetEdgeStackRemediation(edgeStackID int, remediation StackRemediation) error {
+	data, err := codec().Marshal(remediation)
+	if err != nil {
+		return err
+	}
+
+	requestURL := fmt.Sprintf("%s/api/endpoints/%d/edge/stacks/%d/remediation", client.serverAddress, client.getEndpointIDFn(), edgeStackID)
+
+	req, err := http.NewRequest(http.MethodPut, requestURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set(agent.HTTPEdgeIdentifierHeaderName, client.edgeID)
+
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		log.Error().Int("response_code", resp.StatusCode).Msg("SetEdgeStackRemediation operation failed")
+
+		return errors.New("SetEdgeStackRemediation operation failed")
+	}
+
+	return nil
+}
+
+// SetEdgeStackInventory sends the Edge stacks deployed on the device to the Portainer server
+func (client *PortainerEdgeClient) SetEdgeStackInventory(stacks []DeployedStack) error {
+	data, err := codec().Marshal(stacks)
+	if err != nil {
+		return err
+	}
+
+	requestURL := fmt.Sprintf("%s/api/endpoints/%d/edge/stacks/inventory", client.serverAddress, client.getEndpointIDFn())
+
+	req, err := http.NewRequest(http.MethodPut, requestURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set(agent.HTTPEdgeIdentifierHeaderName, client.edgeID)
+
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		log.Error().Int("response_code", resp.StatusCode).Msg("SetEdgeStackInventory operation failed")
+
+		return errors.New("SetEdgeStackInventory operation failed")
+	}
+
+	return nil
+}
+
+// SetLabels sends the labels of the device to the Portainer server
+func (client *PortainerEdgeClient) SetLabels(labels map[string]string) error {
+	data, err := codec().Marshal(labels)
+	if err != nil {
+		return err
+	}
+
+	requestURL := fmt.Sprintf("%s/api/endpoints/%d/edge/labels", client.serverAddress, client.getEndpointIDFn())
+
+	req, err := http.NewRequest(http.MethodPut, requestURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set(agent.HTTPEdgeIdentifierHeaderName, client.edgeID)
+
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		log.Error().Int("response_code", resp.StatusCode).Msg("SetLabels operation failed")
+
+		return errors.New("SetLabels operation failed")
+	}
+
+	return nil
+}
+
+// SetHostInfo sends the information of the host to the Portainer server
+func (client *PortainerEdgeClient) SetHostInfo(info HostInfo) error {
+	data, err := codec().Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	requestURL := fmt.Sprintf("%s/api/endpoints/%d/edge/host", client.serverAddress, client.getEndpointIDFn())
+
+	req, err := http.NewRequest(http.MethodPut, requestURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set(agent.HTTPEdgeIdentifierHeaderName, client.edgeID)
+
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		log.Error().Int("response_code", resp.StatusCode).Msg("SetHostInfo operation failed")
+
+		return errors.New("SetHostInfo operation failed")
+	}
+
+	return nil
+}
+
+// SetPowerStatus sends the status of a reboot or a shutdown of the device to the Portainer server
+func (client *PortainerEdgeClient) SetPowerStatus(status PowerStatus) error {
+	data, err := codec().Marshal(status)
+	if err != nil {
+		return err
+	}
+
+	requestURL := fmt.Sprintf("%s/api/endpoints/%d/edge/power", client.serverAddress, client.getEndpointIDFn())
+
+	req, err := http.NewRequest(http.MethodPut, requestURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set(agent.HTTPEdgeIdentifierHeaderName, client.edgeID)
+
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		log.Error().Int("response_code", resp.StatusCode).Msg("SetPowerStatus operation failed")
+
+		return errors.New("SetPowerStatus operation failed")
+	}
+
+	return nil
+}
+
 // SetEdgeJobStatus sends the jobID log to the Portainer server
 func (client *PortainerEdgeClient) SetEdgeJobStatus(edgeJobStatus agent.EdgeJobStatus) error {
 	payload := logFilePayload{
 		FileContent: edgeJobStatus.LogFileContent,
 	}
 
-	data, err := json.Marshal(payload)
+	data, err := codec().Marshal(payload)
 	if err != nil {
 		return err
 	}
@@ -337,6 +785,171 @@ func (client *PortainerEdgeClient) SetEdgeJobStatus(edgeJobStatus agent.EdgeJobS
 	return nil
 }
 
+// SetEdgeJobHistory sends the executions of an Edge job recorded since the previous report to the Portainer server
+func (client *PortainerEdgeClient) SetEdgeJobHistory(edgeJobID int, executions []agent.EdgeJobExecution) error {
+	data, err := codec().Marshal(executions)
+	if err != nil {
+		return err
+	}
+
+	requestURL := fmt.Sprintf("%s/api/endpoints/%d/edge/jobs/%d/history", client.serverAddress, client.getEndpointIDFn(), edgeJobID)
+
+	req, err := http.NewRequest(http.MethodPost, requestURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set(agent.HTTPEdgeIdentifierHeaderName, client.edgeID)
+
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		log.Error().Int("response_code", resp.StatusCode).Msg("SetEdgeJobHistory operation failed")
+
+		return errors.New("SetEdgeJobHistory operation failed")
+	}
+
+	return nil
+}
+
+// SetEdgeJobFailure reports to the Portainer server an execution of an Edge job that failed after all its attempts
+func (client *PortainerEdgeClient) SetEdgeJobFailure(execution agent.EdgeJobExecution) error {
+	data, err := codec().Marshal(execution)
+	if err != nil {
+		return err
+	}
+
+	requestURL := fmt.Sprintf("%s/api/endpoints/%d/edge/jobs/%d/failure", client.serverAddress, client.getEndpointIDFn(), execution.JobID)
+
+	req, err := http.NewRequest(http.MethodPost, requestURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set(agent.HTTPEdgeIdentifierHeaderName, client.edgeID)
+
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		log.Error().Int("response_code", resp.StatusCode).Msg("SetEdgeJobFailure operation failed")
+
+		return errors.New("SetEdgeJobFailure operation failed")
+	}
+
+	return nil
+}
+
+// SetFileTransferResult sends the result of a file transfer to the Portainer server
+func (client *PortainerEdgeClient) SetFileTransferResult(transferID int, result FileTransferResult) error {
+	data, err := codec().Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	requestURL := fmt.Sprintf("%s/api/endpoints/%d/edge/transfers/%d", client.serverAddress, client.getEndpointIDFn(), transferID)
+
+	req, err := http.NewRequest(http.MethodPut, requestURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set(agent.HTTPEdgeIdentifierHeaderName, client.edgeID)
+
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		log.Error().Int("response_code", resp.StatusCode).Msg("SetFileTransferResult operation failed")
+
+		return errors.New("SetFileTransferResult operation failed")
+	}
+
+	return nil
+}
+
+// SetStackCommandResult sends the result of a one-off command of an Edge stack to the Portainer server
+func (client *PortainerEdgeClient) SetStackCommandResult(commandID int, result StackCommandResult) error {
+	data, err := codec().Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	requestURL := fmt.Sprintf("%s/api/endpoints/%d/edge/stacks/%d/commands/%d", client.serverAddress, client.getEndpointIDFn(), result.StackID, commandID)
+
+	req, err := http.NewRequest(http.MethodPut, requestURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set(agent.HTTPEdgeIdentifierHeaderName, client.edgeID)
+
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		log.Error().Int("response_code", resp.StatusCode).Msg("SetStackCommandResult operation failed")
+
+		return errors.New("SetStackCommandResult operation failed")
+	}
+
+	return nil
+}
+
+// SetBackupResult sends the result of an export or an import of the Edge stacks to the Portainer server
+func (client *PortainerEdgeClient) SetBackupResult(backupID int, result BackupResult) error {
+	data, err := codec().Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	requestURL := fmt.Sprintf("%s/api/endpoints/%d/edge/backups/%d", client.serverAddress, client.getEndpointIDFn(), backupID)
+
+	req, err := http.NewRequest(http.MethodPut, requestURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set(agent.HTTPEdgeIdentifierHeaderName, client.edgeID)
+
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		log.Error().Int("response_code", resp.StatusCode).Msg("SetBackupResult operation failed")
+
+		return errors.New("SetBackupResult operation failed")
+	}
+
+	return nil
+}
+
 func (client *PortainerEdgeClient) GetEdgeConfig(id EdgeConfigID) (*EdgeConfig, error) {
 	requestURL := fmt.Sprintf("%s/api/edge_configurations/%d/files", client.serverAddress, id)
 
@@ -363,7 +976,7 @@ func (client *PortainerEdgeClient) GetEdgeConfig(id EdgeConfigID) (*EdgeConfig,
 	}
 
 	var data EdgeConfig
-	err = json.NewDecoder(resp.Body).Decode(&data)
+	err = codec().NewDecoder(resp.Body).Decode(&data)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,26 @@
+package client
+
+import "github.com/portainer/agent/profile"
+
+const (
+	// SnapshotModeFull sends the raw containers, images, volumes and networks of the engine with the snapshots, as
+	// patches of the previous snapshot once the server has it
+	SnapshotModeFull = "full"
+	// SnapshotModeMinimal sends the counts and the engine information only, the previous snapshot is not retained to
+	// compute the patches
+	SnapshotModeMinimal = "minimal"
+)
+
+// profile returns the profile of the agent reported with the snapshots
+func (c *edgeHTTPClient) profile() string {
+	if c.options == nil || c.options.EdgeProfile == "" {
+		return profile.Standard
+	}
+
+	return c.options.EdgeProfile
+}
+
+// minimalSnapshots returns true when the snapshots only hold the counts and the engine information
+func (c *edgeHTTPClient) minimalSnapshots() bool {
+	return c.options != nil && c.options.EdgeSnapshotMode == SnapshotModeMinimal
+}
@@ -0,0 +1,312 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/portainer/agent"
+	"github.com/portainer/agent/clock"
+
+	"github.com/rs/zerolog/log"
+)
+
+// States of the circuit breaker of the requests sent to the Portainer server
+const (
+	// CircuitClosed sends the requests
+	CircuitClosed = "closed"
+	// CircuitOpen fails the requests without sending them, the server is considered unreachable
+	CircuitOpen = "open"
+	// CircuitHalfOpen sends a single request probing whether the server is reachable again
+	CircuitHalfOpen = "half-open"
+)
+
+const (
+	// circuitThreshold is the number of consecutive failed attempts after which the server is considered unreachable
+	circuitThreshold = 5
+	// retryBaseDelay is the wait before the first retry, doubled for each following retry up to retryMaxDelay
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 10 * time.Second
+	// Each request adds retryBudgetRatio to the retry budget, a retry spends 1. The budget is capped to
+	// retryBudgetMax so that an outage cannot be met with more than a burst of retries.
+	retryBudgetRatio = 0.2
+	retryBudgetMax   = 10
+)
+
+// ErrCircuitOpen is returned without sending the request while the Portainer server is considered unreachable
+var ErrCircuitOpen = errors.New("the Portainer server is unreachable, the request was not sent")
+
+// RequestStats are the metrics of the requests sent to the Portainer server since the agent started
+type RequestStats struct {
+	// Requests counts the requests, Retries their retries, Failures the requests that failed after their retries
+	// and Rejected the ones failed without being sent while the circuit was open
+	Requests int64 `json:"requests"`
+	Retries  int64 `json:"retries"`
+	Failures int64 `json:"failures"`
+	Rejected int64 `json:"rejected"`
+	// RetryBudget is the number of retries currently available
+	RetryBudget float64 `json:"retryBudget"`
+	// CircuitState is the state of the circuit breaker and CircuitOpenings the number of times it opened
+	CircuitState    string `json:"circuitState"`
+	CircuitOpenings int64  `json:"circuitOpenings"`
+	// LastOpened is the Unix timestamp of the last opening of the circuit, 0 when it never opened
+	LastOpened int64 `json:"lastOpened,omitempty"`
+	// LastError is the last error of a failed attempt
+	LastError string `json:"lastError,omitempty"`
+}
+
+// resilience retries the requests failing because the Portainer server is unreachable or unavailable, within a
+// budget shared by all the requests, and stops sending them once the server is considered unreachable. The requests
+// then fail fast with ErrCircuitOpen until the cooldown elapses and a single request probes the server.
+type resilience struct {
+	maxRetries int
+	cooldown   time.Duration
+	clock      agent.Clock
+	sleep      func(ctx context.Context, d time.Duration) error
+	jitter     func() float64
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	budget   float64
+	stats    RequestStats
+}
+
+func newResilience(options *agent.Options) *resilience {
+	r := &resilience{
+		maxRetries: 2,
+		cooldown:   30 * time.Second,
+		clock:      clock.NewSystemClock(),
+		sleep:      sleepContext,
+		jitter:     rand.Float64,
+		state:      CircuitClosed,
+		budget:     retryBudgetMax,
+	}
+
+	if options != nil {
+		r.maxRetries = options.EdgeRequestRetries
+		if options.EdgeCircuitCooldown > 0 {
+			r.cooldown = options.EdgeCircuitCooldown
+		}
+	}
+
+	return r
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// do sends the request with send, retrying it while the server is unavailable. The request is sent again only when
+// its body can be read again.
+func (r *resilience) do(req *http.Request, send func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	if err := r.allow(); err != nil {
+		return nil, err
+	}
+
+	replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+
+	for attempt := 0; ; attempt++ {
+		// Each attempt is sent on a copy so that the request is left as it is for the next one
+		attemptReq := req.Clone(req.Context())
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+
+			attemptReq.Body = body
+		}
+
+		resp, err := send(attemptReq)
+
+		unavailable := unavailableAttempt(req.Context(), resp, err)
+		r.record(unavailable, resp, err)
+
+		if !unavailable && (err != nil || resp.StatusCode != http.StatusTooManyRequests) {
+			if err != nil {
+				r.failed()
+			}
+
+			return resp, err
+		}
+
+		if attempt >= r.maxRetries || !replayable || !r.retry() {
+			r.failed()
+
+			return resp, err
+		}
+
+		delay := r.backoff(attempt, resp)
+
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		log.Debug().Err(err).Str("url", req.URL.Path).Int("attempt", attempt+1).Dur("delay", delay).Msg("retrying the request to the Portainer server")
+
+		if err := r.sleep(req.Context(), delay); err != nil {
+			r.failed()
+
+			return nil, err
+		}
+	}
+}
+
+// unavailableAttempt returns true when the server could not be reached or answered that it is unavailable, such
+// attempts are retried and count towards opening the circuit
+func unavailableAttempt(ctx context.Context, resp *http.Response, err error) bool {
+	if err != nil {
+		// The caller gave up on the request, it says nothing about the server
+		return ctx.Err() == nil
+	}
+
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
+
+// allow returns ErrCircuitOpen when the request must not be sent. Once the cooldown elapsed, the circuit turns
+// half-open and lets the request through to probe the server.
+func (r *resilience) allow() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.stats.Requests++
+
+	switch r.state {
+	case CircuitOpen:
+		if r.clock.Now().Sub(r.openedAt) < r.cooldown {
+			r.stats.Rejected++
+
+			return ErrCircuitOpen
+		}
+
+		r.state = CircuitHalfOpen
+	case CircuitHalfOpen:
+		// Only the probe is sent until it tells whether the server is reachable
+		r.stats.Rejected++
+
+		return ErrCircuitOpen
+	}
+
+	r.budget = min(r.budget+retryBudgetRatio, retryBudgetMax)
+
+	return nil
+}
+
+// record updates the circuit with the outcome of an attempt
+func (r *resilience) record(unavailable bool, resp *http.Response, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !unavailable {
+		if err != nil {
+			// A canceled request leaves the circuit as it was, a canceled probe lets the next request probe
+			if r.state == CircuitHalfOpen {
+				r.state = CircuitOpen
+			}
+
+			return
+		}
+
+		if r.state != CircuitClosed {
+			log.Info().Int("failures", r.failures).Msg("the Portainer server is reachable again")
+		}
+
+		r.state = CircuitClosed
+		r.failures = 0
+
+		return
+	}
+
+	r.failures++
+
+	if err != nil {
+		r.stats.LastError = err.Error()
+	} else {
+		r.stats.LastError = resp.Status
+	}
+
+	switch {
+	case r.state == CircuitHalfOpen:
+		log.Debug().Str("error", r.stats.LastError).Msg("the Portainer server is still unreachable")
+	case r.state == CircuitClosed && r.failures >= circuitThreshold:
+		log.Warn().Str("error", r.stats.LastError).Int("failures", r.failures).Dur("cooldown", r.cooldown).Msg("the Portainer server is unreachable, the requests fail fast until it is probed again")
+
+		r.stats.CircuitOpenings++
+	default:
+		return
+	}
+
+	r.state = CircuitOpen
+	r.openedAt = r.clock.Now()
+	r.stats.LastOpened = r.openedAt.Unix()
+}
+
+// retry spends a retry from the budget, it returns false when the budget is exhausted or the circuit opened
+func (r *resilience) retry() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.state != CircuitClosed || r.budget < 1 {
+		return false
+	}
+
+	r.budget--
+	r.stats.Retries++
+
+	return true
+}
+
+func (r *resilience) failed() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.stats.Failures++
+}
+
+// backoff returns the jittered wait before the retry following the attempt, the Retry-After header of the response
+// takes precedence when it is set
+func (r *resilience) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+			return min(time.Duration(seconds)*time.Second, retryMaxDelay)
+		}
+	}
+
+	delay := retryMaxDelay
+	if attempt < 8 {
+		delay = min(retryBaseDelay<<attempt, retryMaxDelay)
+	}
+
+	return delay/2 + time.Duration(r.jitter()*float64(delay/2))
+}
+
+// report returns the metrics of the requests
+func (r *resilience) report() RequestStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := r.stats
+	stats.RetryBudget = r.budget
+	stats.CircuitState = r.state
+
+	return stats
+}
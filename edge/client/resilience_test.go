@@ -0,0 +1,181 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/portainer/agent"
+	"github.com/portainer/agent/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestResilience(fakeClock *clock.FakeClock, maxRetries int) (*resilience, *[]time.Duration) {
+	var delays []time.Duration
+
+	r := newResilience(&agent.Options{EdgeRequestRetries: maxRetries, EdgeCircuitCooldown: time.Minute})
+	r.clock = fakeClock
+	r.jitter = func() float64 { return 1 }
+	r.sleep = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		fakeClock.Advance(d)
+
+		return nil
+	}
+
+	return r, &delays
+}
+
+func TestResilience_retries(t *testing.T) {
+	statuses := []int{http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusOK}
+	var bodies []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+
+		w.WriteHeader(statuses[len(bodies)-1])
+	}))
+	defer server.Close()
+
+	r, delays := newTestResilience(clock.NewFakeClock(time.Now()), 2)
+
+	req, err := http.NewRequest(http.MethodPut, server.URL, bytes.NewReader([]byte("status")))
+	require.NoError(t, err)
+
+	resp, err := r.do(req, server.Client().Do)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{"status", "status", "status"}, bodies)
+	assert.Equal(t, []time.Duration{retryBaseDelay, 2 * retryBaseDelay}, *delays)
+
+	stats := r.report()
+	assert.EqualValues(t, 1, stats.Requests)
+	assert.EqualValues(t, 2, stats.Retries)
+	assert.EqualValues(t, 0, stats.Failures)
+	assert.Equal(t, CircuitClosed, stats.CircuitState)
+
+	// Client errors are not retried
+	statuses, bodies = []int{http.StatusBadRequest}, nil
+
+	resp, err = r.do(req, server.Client().Do)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Len(t, bodies, 1)
+}
+
+func TestResilience_retryAfter(t *testing.T) {
+	r, _ := newTestResilience(clock.NewFakeClock(time.Now()), 2)
+
+	resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": []string{"3"}}}
+	assert.Equal(t, 3*time.Second, r.backoff(0, resp))
+
+	resp.Header.Set("Retry-After", "3600")
+	assert.Equal(t, retryMaxDelay, r.backoff(0, resp))
+
+	assert.Equal(t, retryMaxDelay, r.backoff(20, nil))
+}
+
+func TestResilience_budget(t *testing.T) {
+	attempts := 0
+
+	send := func(req *http.Request) (*http.Response, error) {
+		attempts++
+
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	r, _ := newTestResilience(clock.NewFakeClock(time.Now()), 100)
+
+	req, err := http.NewRequest(http.MethodGet, "http://portainer", nil)
+	require.NoError(t, err)
+
+	_, err = r.do(req, send)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// The circuit opens before the budget is exhausted
+	assert.Equal(t, circuitThreshold, attempts)
+
+	stats := r.report()
+	assert.EqualValues(t, circuitThreshold-1, stats.Retries)
+	assert.EqualValues(t, 1, stats.Failures)
+	assert.Equal(t, CircuitOpen, stats.CircuitState)
+
+	// Without the circuit, the retries stop once the budget is exhausted
+	r, _ = newTestResilience(clock.NewFakeClock(time.Now()), 100)
+	r.failures = -100
+	attempts = 0
+
+	_, err = r.do(req, send)
+	assert.Error(t, err)
+	assert.Equal(t, retryBudgetMax+1, attempts)
+	assert.Less(t, r.report().RetryBudget, 1.0)
+}
+
+func TestResilience_circuit(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Now())
+	r, _ := newTestResilience(fakeClock, 0)
+
+	attempts := 0
+	reachable := false
+
+	send := func(req *http.Request) (*http.Response, error) {
+		attempts++
+
+		if !reachable {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "http://portainer", nil)
+	require.NoError(t, err)
+
+	for i := 0; i < circuitThreshold; i++ {
+		_, err := r.do(req, send)
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	}
+
+	// The requests fail fast while the circuit is open
+	_, err = r.do(req, send)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, circuitThreshold, attempts)
+
+	// The probe sent after the cooldown fails and opens the circuit again
+	fakeClock.Advance(time.Minute)
+
+	_, err = r.do(req, send)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Equal(t, circuitThreshold+1, attempts)
+
+	_, err = r.do(req, send)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+
+	// The successful probe closes the circuit
+	fakeClock.Advance(time.Minute)
+	reachable = true
+
+	resp, err := r.do(req, send)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	_, err = r.do(req, send)
+	require.NoError(t, err)
+
+	stats := r.report()
+	assert.Equal(t, CircuitClosed, stats.CircuitState)
+	assert.EqualValues(t, 1, stats.CircuitOpenings)
+	assert.EqualValues(t, 2, stats.Rejected)
+	assert.EqualValues(t, circuitThreshold+1, stats.Failures)
+	assert.Equal(t, io.ErrUnexpectedEOF.Error(), stats.LastError)
+}
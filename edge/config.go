@@ -0,0 +1,126 @@
+package edge
+
+import (
+	"errors"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/portainer/agent"
+	"github.com/portainer/agent/docker"
+	"github.com/portainer/agent/edge/edgeconfig"
+	"github.com/portainer/portainer/api/filesystem"
+
+	"github.com/rs/zerolog/log"
+)
+
+const swarmServiceIDLabel = "com.docker.swarm.service.id"
+
+func (manager *Manager) edgeConfigManager() *edgeconfig.Manager {
+	var configRuntime edgeconfig.Runtime
+	if manager.containerPlatform == agent.PlatformDocker || manager.containerPlatform == agent.PlatformPodman {
+		configRuntime = dockerConfigRuntime{}
+	}
+
+	return edgeconfig.NewManager(agent.HostRoot, manager.edgeConfigFacts, configRuntime)
+}
+
+// dockerConfigRuntime reloads the containers and services consuming the Edge configurations
+type dockerConfigRuntime struct{}
+
+func (dockerConfigRuntime) Signal(container, signal string) error {
+	return docker.ContainerSignal(container, signal)
+}
+
+// RestartConsumers force updates the Swarm services and restarts the standalone containers
+// labeled as consumers of the configuration
+func (dockerConfigRuntime) RestartConsumers(configName string) error {
+	var errs []error
+
+	services, err := docker.GetServicesWithLabel(edgeconfig.ConsumerLabel)
+	if err != nil {
+		// The node is not part of a Swarm cluster
+		log.Debug().Err(err).Msg("unable to list the services consuming Edge configurations")
+	}
+
+	for _, service := range services {
+		if !edgeconfig.Consumes(service.Spec.Labels[edgeconfig.ConsumerLabel], configName) {
+			continue
+		}
+
+		log.Info().Str("config", configName).Str("service", service.Spec.Name).Msg("restarting the service consuming the Edge configuration")
+
+		if err := docker.ServiceForceUpdate(service.ID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	containers, err := docker.GetContainersWithLabel(edgeconfig.ConsumerLabel)
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+
+	for _, container := range containers {
+		// The tasks of the Swarm services are restarted by their service
+		if _, ok := container.Labels[swarmServiceIDLabel]; ok {
+			continue
+		}
+
+		if container.State != "running" || !edgeconfig.Consumes(container.Labels[edgeconfig.ConsumerLabel], configName) {
+			continue
+		}
+
+		log.Info().Str("config", configName).Str("container", container.ID).Msg("restarting the container consuming the Edge configuration")
+
+		if err := docker.ContainerRestart(container.ID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// edgeConfigFacts returns the facts of the device available to the Edge configuration templates
+func (manager *Manager) edgeConfigFacts() edgeconfig.Facts {
+	return edgeconfig.Facts{
+		EdgeID:       manager.agentOptions.EdgeID,
+		EndpointID:   int(manager.GetEndpointID()),
+		Hostname:     hostHostname(),
+		Platform:     platformName(manager.containerPlatform),
+		OS:           runtime.GOOS,
+		Arch:         runtime.GOARCH,
+		AgentVersion: agent.Version,
+		EdgeGroupIDs: manager.agentOptions.EdgeMetaFields.EdgeGroupsIDs,
+		TagIDs:       manager.agentOptions.EdgeMetaFields.TagsIDs,
+	}
+}
+
+// hostHostname returns the hostname of the host when its filesystem is mounted, the one of the agent otherwise
+func hostHostname() string {
+	if data, err := os.ReadFile(filesystem.JoinPaths(agent.HostRoot, "etc", "hostname")); err == nil {
+		if hostname := strings.TrimSpace(string(data)); hostname != "" {
+			return hostname
+		}
+	}
+
+	hostname, _ := os.Hostname()
+
+	return hostname
+}
+
+func platformName(platform agent.ContainerPlatform) string {
+	switch platform {
+	case agent.PlatformDocker:
+		return "docker"
+	case agent.PlatformKubernetes:
+		return "kubernetes"
+	case agent.PlatformPodman:
+		return "podman"
+	case agent.PlatformNomad:
+		return "nomad"
+	case agent.PlatformContainerd:
+		return "containerd"
+	}
+
+	return ""
+}
@@ -0,0 +1,182 @@
+package credstore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	agentfs "github.com/portainer/agent/filesystem"
+	"github.com/portainer/portainer/api/edge"
+)
+
+const (
+	credentialsFileName    = "registry_credentials.enc"
+	credentialsKeyFileName = "registry_credentials.key"
+)
+
+// FileStore keeps the credentials in a file of the data folder, encrypted with AES-GCM
+// using a key generated on first use and only readable by the agent
+type FileStore struct {
+	path string
+	key  []byte
+	mu   sync.Mutex
+}
+
+// NewFileStore returns a pointer to a new instance of FileStore
+func NewFileStore(dataPath string) (*FileStore, error) {
+	key, err := loadOrCreateKey(filepath.Join(dataPath, credentialsKeyFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileStore{
+		path: filepath.Join(dataPath, credentialsFileName),
+		key:  key,
+	}, nil
+}
+
+func loadOrCreateKey(path string) ([]byte, error) {
+	key, err := os.ReadFile(path)
+	if err == nil {
+		if len(key) != 32 {
+			return nil, errors.New("invalid registry credentials key")
+		}
+
+		return key, nil
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	if err := agentfs.WriteFile(filepath.Dir(path), filepath.Base(path), key, 0600); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func (s *FileStore) Save(stackID int, credentials []edge.RegistryCredentials) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	if len(credentials) == 0 {
+		if _, ok := all[stackID]; !ok {
+			return nil
+		}
+
+		delete(all, stackID)
+	} else {
+		all[stackID] = credentials
+	}
+
+	return s.write(all)
+}
+
+func (s *FileStore) Get(stackID int) ([]edge.RegistryCredentials, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	return all[stackID], nil
+}
+
+func (s *FileStore) Delete(stackID int) error {
+	return s.Save(stackID, nil)
+}
+
+func (s *FileStore) List() (map[int][]edge.RegistryCredentials, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.read()
+}
+
+func (s *FileStore) read() (map[int][]edge.RegistryCredentials, error) {
+	all := make(map[int][]edge.RegistryCredentials)
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return all, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	gcm, err := s.cipher()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("invalid registry credentials file")
+	}
+
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(plaintext, &all); err != nil {
+		return nil, err
+	}
+
+	return all, nil
+}
+
+func (s *FileStore) write(all map[int][]edge.RegistryCredentials) error {
+	plaintext, err := json.Marshal(all)
+	if err != nil {
+		return err
+	}
+
+	gcm, err := s.cipher()
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+
+	data := gcm.Seal(nonce, nonce, plaintext, nil)
+
+	// Write to a temporary file first so that a crash never leaves a truncated file behind
+	tmpName := filepath.Base(s.path) + ".tmp"
+	if err := agentfs.WriteFile(filepath.Dir(s.path), tmpName, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(filepath.Join(filepath.Dir(s.path), tmpName), s.path)
+}
+
+func (s *FileStore) cipher() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(s.key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
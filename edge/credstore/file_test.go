@@ -0,0 +1,61 @@
+package credstore
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/portainer/portainer/api/edge"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStore(t *testing.T) {
+	dataPath := t.TempDir()
+
+	store, err := NewFileStore(dataPath)
+	require.NoError(t, err)
+
+	credentials := []edge.RegistryCredentials{{ServerURL: "registry.example.com", Username: "user", Secret: "s3cret"}}
+	require.NoError(t, store.Save(1, credentials))
+	require.NoError(t, store.Save(2, []edge.RegistryCredentials{{ServerURL: "other.example.com"}}))
+
+	data, err := os.ReadFile(filepath.Join(dataPath, credentialsFileName))
+	require.NoError(t, err)
+	assert.False(t, strings.Contains(string(data), "s3cret"), "credentials must be encrypted")
+
+	info, err := os.Stat(filepath.Join(dataPath, credentialsKeyFileName))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// The credentials survive a restart
+	store, err = NewFileStore(dataPath)
+	require.NoError(t, err)
+
+	got, err := store.Get(1)
+	require.NoError(t, err)
+	assert.Equal(t, credentials, got)
+
+	require.NoError(t, store.Delete(2))
+
+	all, err := store.List()
+	require.NoError(t, err)
+	assert.Equal(t, map[int][]edge.RegistryCredentials{1: credentials}, all)
+}
+
+func TestFileStoreInvalidKey(t *testing.T) {
+	dataPath := t.TempDir()
+
+	store, err := NewFileStore(dataPath)
+	require.NoError(t, err)
+	require.NoError(t, store.Save(1, []edge.RegistryCredentials{{ServerURL: "registry.example.com"}}))
+
+	require.NoError(t, os.Remove(filepath.Join(dataPath, credentialsKeyFileName)))
+
+	store, err = NewFileStore(dataPath)
+	require.NoError(t, err)
+
+	_, err = store.Get(1)
+	assert.Error(t, err)
+}
@@ -0,0 +1,150 @@
+package credstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/portainer/portainer/api/edge"
+)
+
+// helperServerURLPrefix identifies the entries written by the agent in the credential helper,
+// each stack is stored as a single entry whose secret holds all its registry credentials
+const helperServerURLPrefix = "portainer-agent://edge-stacks/"
+
+const helperUsername = "portainer-agent"
+
+// helperCredentialsNotFound is the message returned by the docker credential helpers for missing entries
+const helperCredentialsNotFound = "credentials not found in native keychain"
+
+// HelperStore delegates the storage of the credentials to an external binary implementing
+// the docker credential helpers protocol, e.g. docker-credential-pass or docker-credential-secretservice
+type HelperStore struct {
+	path string
+}
+
+type helperCredentials struct {
+	ServerURL string
+	Username  string
+	Secret    string
+}
+
+// NewHelperStore returns a pointer to a new instance of HelperStore
+func NewHelperStore(path string) *HelperStore {
+	return &HelperStore{path: path}
+}
+
+func (s *HelperStore) Save(stackID int, credentials []edge.RegistryCredentials) error {
+	if len(credentials) == 0 {
+		return s.Delete(stackID)
+	}
+
+	secret, err := json.Marshal(credentials)
+	if err != nil {
+		return err
+	}
+
+	input, err := json.Marshal(helperCredentials{
+		ServerURL: helperServerURL(stackID),
+		Username:  helperUsername,
+		Secret:    string(secret),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.run("store", input)
+
+	return err
+}
+
+func (s *HelperStore) Get(stackID int) ([]edge.RegistryCredentials, error) {
+	output, err := s.run("get", []byte(helperServerURL(stackID)))
+	if err != nil {
+		if strings.Contains(err.Error(), helperCredentialsNotFound) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	var entry helperCredentials
+	if err := json.Unmarshal(output, &entry); err != nil {
+		return nil, err
+	}
+
+	var credentials []edge.RegistryCredentials
+
+	return credentials, json.Unmarshal([]byte(entry.Secret), &credentials)
+}
+
+func (s *HelperStore) Delete(stackID int) error {
+	_, err := s.run("erase", []byte(helperServerURL(stackID)))
+	if err != nil && strings.Contains(err.Error(), helperCredentialsNotFound) {
+		return nil
+	}
+
+	return err
+}
+
+func (s *HelperStore) List() (map[int][]edge.RegistryCredentials, error) {
+	output, err := s.run("list", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries map[string]string
+	if err := json.Unmarshal(output, &entries); err != nil {
+		return nil, err
+	}
+
+	all := make(map[int][]edge.RegistryCredentials)
+
+	for serverURL := range entries {
+		id, ok := strings.CutPrefix(serverURL, helperServerURLPrefix)
+		if !ok {
+			continue
+		}
+
+		stackID, err := strconv.Atoi(id)
+		if err != nil {
+			continue
+		}
+
+		credentials, err := s.Get(stackID)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(credentials) > 0 {
+			all[stackID] = credentials
+		}
+	}
+
+	return all, nil
+}
+
+func (s *HelperStore) run(action string, input []byte) ([]byte, error) {
+	cmd := exec.Command(s.path, action)
+	cmd.Stdin = bytes.NewReader(input)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		// The helpers write their error messages to stdout
+		msg := strings.TrimSpace(stdout.String() + " " + stderr.String())
+
+		return nil, fmt.Errorf("credential helper %s failed: %w: %s", action, err, msg)
+	}
+
+	return stdout.Bytes(), nil
+}
+
+func helperServerURL(stackID int) string {
+	return helperServerURLPrefix + strconv.Itoa(stackID)
+}
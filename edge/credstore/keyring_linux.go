@@ -0,0 +1,198 @@
+//go:build linux
+// +build linux
+
+package credstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/portainer/portainer/api/edge"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	keyringKeyType     = "user"
+	keyringIndexKey    = "portainer-agent:registry:index"
+	keyringStackPrefix = "portainer-agent:registry:stack:"
+)
+
+// KeyringStore keeps the credentials in the persistent kernel keyring of the agent user.
+// The keyring survives restarts of the agent but not reboots of the host.
+type KeyringStore struct {
+	keyring int
+	mu      sync.Mutex
+}
+
+// NewKeyringStore returns a pointer to a new instance of KeyringStore
+func NewKeyringStore() (*KeyringStore, error) {
+	keyring, err := unix.KeyctlInt(unix.KEYCTL_GET_PERSISTENT, -1, unix.KEY_SPEC_PROCESS_KEYRING, 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("unable to access the persistent keyring: %w", err)
+	}
+
+	return &KeyringStore{keyring: keyring}, nil
+}
+
+func (s *KeyringStore) Save(stackID int, credentials []edge.RegistryCredentials) error {
+	if len(credentials) == 0 {
+		return s.Delete(stackID)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := json.Marshal(credentials)
+	if err != nil {
+		return err
+	}
+
+	if _, err := unix.AddKey(keyringKeyType, keyringStackKey(stackID), data, s.keyring); err != nil {
+		return err
+	}
+
+	index, err := s.readIndex()
+	if err != nil {
+		return err
+	}
+
+	index[stackID] = struct{}{}
+
+	return s.writeIndex(index)
+}
+
+func (s *KeyringStore) Get(stackID int) ([]edge.RegistryCredentials, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := s.read(keyringStackKey(stackID))
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	var credentials []edge.RegistryCredentials
+
+	return credentials, json.Unmarshal(data, &credentials)
+}
+
+func (s *KeyringStore) Delete(stackID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, err := unix.KeyctlSearch(s.keyring, keyringKeyType, keyringStackKey(stackID), 0)
+	if err == nil {
+		if _, err := unix.KeyctlInt(unix.KEYCTL_UNLINK, id, s.keyring, 0, 0); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, unix.ENOKEY) {
+		return err
+	}
+
+	index, err := s.readIndex()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := index[stackID]; !ok {
+		return nil
+	}
+
+	delete(index, stackID)
+
+	return s.writeIndex(index)
+}
+
+func (s *KeyringStore) List() (map[int][]edge.RegistryCredentials, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index, err := s.readIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	all := make(map[int][]edge.RegistryCredentials, len(index))
+
+	for stackID := range index {
+		data, err := s.read(keyringStackKey(stackID))
+		if err != nil {
+			return nil, err
+		} else if data == nil {
+			continue
+		}
+
+		var credentials []edge.RegistryCredentials
+		if err := json.Unmarshal(data, &credentials); err != nil {
+			return nil, err
+		}
+
+		all[stackID] = credentials
+	}
+
+	return all, nil
+}
+
+// read returns the payload of the key, nil if the key does not exist
+func (s *KeyringStore) read(description string) ([]byte, error) {
+	id, err := unix.KeyctlSearch(s.keyring, keyringKeyType, description, 0)
+	if errors.Is(err, unix.ENOKEY) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	size, err := unix.KeyctlBuffer(unix.KEYCTL_READ, id, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, size)
+	if _, err := unix.KeyctlBuffer(unix.KEYCTL_READ, id, data, 0); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (s *KeyringStore) readIndex() (map[int]struct{}, error) {
+	index := make(map[int]struct{})
+
+	data, err := s.read(keyringIndexKey)
+	if err != nil || data == nil {
+		return index, err
+	}
+
+	var stackIDs []int
+	if err := json.Unmarshal(data, &stackIDs); err != nil {
+		return nil, err
+	}
+
+	for _, stackID := range stackIDs {
+		index[stackID] = struct{}{}
+	}
+
+	return index, nil
+}
+
+func (s *KeyringStore) writeIndex(index map[int]struct{}) error {
+	stackIDs := make([]int, 0, len(index))
+	for stackID := range index {
+		stackIDs = append(stackIDs, stackID)
+	}
+
+	data, err := json.Marshal(stackIDs)
+	if err != nil {
+		return err
+	}
+
+	_, err = unix.AddKey(keyringKeyType, keyringIndexKey, data, s.keyring)
+
+	return err
+}
+
+func keyringStackKey(stackID int) string {
+	return keyringStackPrefix + strconv.Itoa(stackID)
+}
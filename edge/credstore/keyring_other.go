@@ -0,0 +1,34 @@
+//go:build !linux
+// +build !linux
+
+package credstore
+
+import (
+	"errors"
+
+	"github.com/portainer/portainer/api/edge"
+)
+
+// KeyringStore is only supported on Linux
+type KeyringStore struct{}
+
+// NewKeyringStore returns an error as the keyring backend is only supported on Linux
+func NewKeyringStore() (*KeyringStore, error) {
+	return nil, errors.New("the keyring credential store backend is only supported on Linux")
+}
+
+func (s *KeyringStore) Save(stackID int, credentials []edge.RegistryCredentials) error {
+	return errors.ErrUnsupported
+}
+
+func (s *KeyringStore) Get(stackID int) ([]edge.RegistryCredentials, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (s *KeyringStore) Delete(stackID int) error {
+	return errors.ErrUnsupported
+}
+
+func (s *KeyringStore) List() (map[int][]edge.RegistryCredentials, error) {
+	return nil, errors.ErrUnsupported
+}
@@ -0,0 +1,63 @@
+package credstore
+
+import (
+	"sync"
+
+	"github.com/portainer/portainer/api/edge"
+)
+
+// MemoryStore keeps the credentials in memory
+type MemoryStore struct {
+	credentials map[int][]edge.RegistryCredentials
+	mu          sync.Mutex
+}
+
+// NewMemoryStore returns a pointer to a new instance of MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		credentials: make(map[int][]edge.RegistryCredentials),
+	}
+}
+
+func (s *MemoryStore) Save(stackID int, credentials []edge.RegistryCredentials) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(credentials) == 0 {
+		delete(s.credentials, stackID)
+
+		return nil
+	}
+
+	s.credentials[stackID] = credentials
+
+	return nil
+}
+
+func (s *MemoryStore) Get(stackID int) ([]edge.RegistryCredentials, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.credentials[stackID], nil
+}
+
+func (s *MemoryStore) Delete(stackID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.credentials, stackID)
+
+	return nil
+}
+
+func (s *MemoryStore) List() (map[int][]edge.RegistryCredentials, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	credentials := make(map[int][]edge.RegistryCredentials, len(s.credentials))
+	for stackID, c := range s.credentials {
+		credentials[stackID] = c
+	}
+
+	return credentials, nil
+}
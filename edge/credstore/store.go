@@ -0,0 +1,59 @@
+package credstore
+
+import (
+	"fmt"
+
+	"github.com/portainer/portainer/api/edge"
+)
+
+const (
+	// BackendMemory keeps the credentials in memory, they are lost on restart
+	BackendMemory = "memory"
+	// BackendFile keeps the credentials in an encrypted file inside the data folder
+	BackendFile = "file"
+	// BackendKeyring keeps the credentials in the OS keyring
+	BackendKeyring = "keyring"
+	// BackendHelper delegates the storage to an external docker credential helper binary
+	BackendHelper = "helper"
+)
+
+// Store persists the registry credentials of each Edge stack
+type Store interface {
+	// Save replaces the credentials of a stack
+	Save(stackID int, credentials []edge.RegistryCredentials) error
+	// Get returns the credentials of a stack, nil if there are none
+	Get(stackID int) ([]edge.RegistryCredentials, error)
+	// Delete removes the credentials of a stack
+	Delete(stackID int) error
+	// List returns the credentials of all the stacks, indexed by stack identifier
+	List() (map[int][]edge.RegistryCredentials, error)
+}
+
+// Config is used to create a Store
+type Config struct {
+	Backend string
+	// DataPath is the folder used by the file backend
+	DataPath string
+	// HelperPath is the path of the binary used by the helper backend, e.g. docker-credential-pass
+	HelperPath string
+}
+
+// NewStore returns the store matching the configured backend
+func NewStore(config Config) (Store, error) {
+	switch config.Backend {
+	case "", BackendMemory:
+		return NewMemoryStore(), nil
+	case BackendFile:
+		return NewFileStore(config.DataPath)
+	case BackendKeyring:
+		return NewKeyringStore()
+	case BackendHelper:
+		if config.HelperPath == "" {
+			return nil, fmt.Errorf("a credential helper binary is required by the %s backend", BackendHelper)
+		}
+
+		return NewHelperStore(config.HelperPath), nil
+	}
+
+	return nil, fmt.Errorf("unsupported credential store backend: %s", config.Backend)
+}
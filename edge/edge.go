@@ -1,19 +1,36 @@
 package edge
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
 	"github.com/portainer/agent"
+	"github.com/portainer/agent/docker"
 	"github.com/portainer/agent/edge/aws"
+	"github.com/portainer/agent/edge/backup"
+	"github.com/portainer/agent/edge/blobstore"
 	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/agent/edge/credstore"
+	"github.com/portainer/agent/edge/filetransfer"
+	"github.com/portainer/agent/edge/firewall"
+	"github.com/portainer/agent/edge/hostinfo"
+	"github.com/portainer/agent/edge/imagepolicy"
+	"github.com/portainer/agent/edge/jobhistory"
+	"github.com/portainer/agent/edge/labels"
+	"github.com/portainer/agent/edge/notify"
+	"github.com/portainer/agent/edge/opa"
+	"github.com/portainer/agent/edge/power"
 	"github.com/portainer/agent/edge/scheduler"
+	"github.com/portainer/agent/edge/securitypolicy"
+	"github.com/portainer/agent/edge/session"
 	"github.com/portainer/agent/edge/stack"
+	"github.com/portainer/agent/edge/standby"
+	agentos "github.com/portainer/agent/os"
 	portainer "github.com/portainer/portainer/api"
-	"github.com/portainer/portainer/api/filesystem"
 
 	"github.com/rs/zerolog/log"
 )
@@ -27,14 +44,28 @@ type (
 		clusterService    agent.ClusterService
 		dockerInfoService agent.DockerInfoService
 		key               *edgeKey
+		rawKey            string
 		logsManager       *scheduler.LogsManager
 		pollService       *PollService
 		stackManager      *stack.StackManager
-		mu                sync.Mutex
+		jobHistory        *jobhistory.Store
+		lease             *standby.Lease
+		statusWebhook     *notify.StatusWebhook
+		// leaseDone is closed once the lease is released, after shutdownCtx is done
+		leaseDone   chan struct{}
+		shutdownCtx context.Context
+		// requestStats returns the metrics of the requests sent to the Portainer server, nil until the manager is
+		// started
+		requestStats func() client.RequestStats
+		// serverSettings are the settings sent by the Portainer server, see EffectiveSettings
+		serverSettings serverSettings
+		mu             sync.Mutex
 	}
 
 	// ManagerParameters represents an object used to create a Manager
 	ManagerParameters struct {
+		// Context is the shutdown context of the agent, the Edge duties are handed over once it is done
+		Context           context.Context
 		Options           *agent.Options
 		AdvertiseAddr     string
 		ClusterService    agent.ClusterService
@@ -47,15 +78,63 @@ func (manager *Manager) GetStackManager() *stack.StackManager {
 	return manager.stackManager
 }
 
+// StackStatus returns the read-only view of the Edge stacks, nil until the manager is started
+func (manager *Manager) StackStatus() stack.StatusProvider {
+	if manager.stackManager == nil {
+		return nil
+	}
+
+	return manager.stackManager
+}
+
+// StackChangelogs returns the history of the versions of the Edge stacks received, nil until the manager is started
+func (manager *Manager) StackChangelogs() []client.StackChangelog {
+	if manager.stackManager == nil {
+		return nil
+	}
+
+	return manager.stackManager.StackChangelogs()
+}
+
 // NewManager returns a pointer to a new instance of Manager
 func NewManager(parameters *ManagerParameters) *Manager {
-	return &Manager{
+	manager := &Manager{
 		clusterService:    parameters.ClusterService,
 		dockerInfoService: parameters.DockerInfoService,
 		agentOptions:      parameters.Options,
 		advertiseAddr:     parameters.AdvertiseAddr,
 		containerPlatform: parameters.ContainerPlatform,
+		shutdownCtx:       parameters.Context,
+	}
+
+	if manager.shutdownCtx == nil {
+		manager.shutdownCtx = context.Background()
+	}
+
+	if parameters.Options.EdgeJobHistoryCount > 0 {
+		manager.jobHistory = jobhistory.NewStore(parameters.Options.DataPath, parameters.Options.EdgeJobHistoryCount)
+	}
+
+	return manager
+}
+
+// JobHistory returns the history of the executions of the Edge jobs, nil when it is disabled
+func (manager *Manager) JobHistory() *jobhistory.Store {
+	return manager.jobHistory
+}
+
+// RequestStats returns the metrics of the requests sent to the Portainer server, nil until the manager is started
+func (manager *Manager) RequestStats() *client.RequestStats {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	if manager.requestStats == nil {
+		return nil
 	}
+
+	stats := manager.requestStats()
+
+	return &stats
 }
 
 // Start starts the manager
@@ -77,6 +156,12 @@ func (manager *Manager) Start() error {
 		TunnelServerFingerprint: manager.key.TunnelServerFingerprint,
 		TunnelProxy:             manager.agentOptions.EdgeTunnelProxy,
 		ContainerPlatform:       manager.containerPlatform,
+		LabelService:            labels.NewService(manager.agentOptions.DataPath, agent.HostRoot, manager.agentOptions.EdgeLabelsFile),
+		DataPath:                manager.agentOptions.DataPath,
+		JobRunAs: scheduler.RunAsPolicy{
+			DefaultUser: manager.agentOptions.EdgeJobUser,
+			AllowRoot:   manager.agentOptions.EdgeJobAllowRoot,
+		},
 	}
 
 	log.Debug().
@@ -95,7 +180,18 @@ func (manager *Manager) Start() error {
 		agentPlatform = agent.PlatformDocker
 	}
 
-	portainerClient := client.NewPortainerClient(
+	if err := client.SetCodec(manager.agentOptions.EdgeJSONCodec); err != nil {
+		return err
+	}
+
+	httpClient := client.BuildHTTPClient(30, manager.agentOptions)
+	httpClient.SetEngine(platformName(manager.containerPlatform))
+
+	manager.mu.Lock()
+	manager.requestStats = httpClient.RequestStats
+	manager.mu.Unlock()
+
+	var portainerClient client.PortainerClient = client.NewDeduplicatingClient(client.NewPortainerClient(
 		manager.key.PortainerInstanceURL,
 		manager.SetEndpointID,
 		manager.GetEndpointID,
@@ -103,8 +199,21 @@ func (manager *Manager) Start() error {
 		manager.agentOptions.EdgeAsyncMode,
 		agentPlatform,
 		manager.agentOptions.EdgeMetaFields,
-		client.BuildHTTPClient(30, manager.agentOptions),
-	)
+		httpClient,
+	), client.DefaultErrorReportWindow)
+
+	var sessionRecorder *session.Recorder
+	if path := manager.agentOptions.EdgeSessionRecord; path != "" {
+		var err error
+		sessionRecorder, err = session.NewRecorder(path, manager.agentOptions.EdgeSessionRecordSize)
+		if err != nil {
+			return fmt.Errorf("unable to record the session: %w", err)
+		}
+
+		log.Warn().Str("path", path).Msg("the session with the server is recorded for debugging")
+
+		portainerClient = session.NewRecordingClient(portainerClient, sessionRecorder)
+	}
 
 	manager.stackManager = stack.NewStackManager(
 		portainerClient,
@@ -112,10 +221,135 @@ func (manager *Manager) Start() error {
 		aws.ExtractAwsConfig(manager.agentOptions),
 		manager.agentOptions.EdgeID,
 	)
+	manager.stackManager.SetHistoryRetention(manager.agentOptions.EdgeStackHistoryCount, manager.agentOptions.EdgeStackHistorySize)
+	manager.stackManager.SetLargeFileMaxSize(manager.agentOptions.EdgeStackLargeFileSize)
+	manager.stackManager.SetSessionRecorder(sessionRecorder)
+
+	credentialStore, err := credstore.NewStore(credstore.Config{
+		Backend:    manager.agentOptions.EdgeCredentialStore,
+		DataPath:   manager.agentOptions.DataPath,
+		HelperPath: manager.agentOptions.EdgeCredentialHelper,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create the registry credential store: %w", err)
+	}
+
+	manager.stackManager.SetCredentialStore(credentialStore)
+	manager.stackManager.SetOrphanPolicy(manager.agentOptions.EdgeStackOrphanPolicy)
+	manager.stackManager.SetLintMode(manager.agentOptions.EdgeStackLint)
+	manager.stackManager.SetSkipUnchanged(manager.agentOptions.EdgeStackSkipUnchanged)
+	manager.stackManager.SetPullProgress(manager.agentOptions.EdgeStackPullProgress != "off")
+	manager.stackManager.SetProjectNaming(manager.agentOptions.EdgeStackNaming, manager.agentOptions.EdgeStackPrefix)
+	manager.stackManager.SetEnvFilePaths(agent.HostRoot, manager.agentOptions.EdgeStackEnvPaths)
+
+	if options := manager.agentOptions; options.EdgeDeployHTTPProxy != "" || options.EdgeDeployHTTPSProxy != "" {
+		manager.stackManager.SetDeployProxy(&agent.ProxyOptions{
+			HTTPProxy:  options.EdgeDeployHTTPProxy,
+			HTTPSProxy: options.EdgeDeployHTTPSProxy,
+			NoProxy:    options.EdgeDeployNoProxy,
+		})
+	}
+
+	manager.stackManager.SetStorageQuota(manager.agentOptions.EdgeStorageQuota, agent.HostRoot+agent.ScheduleScriptDirectory, manager.agentOptions.EdgeJobLogMaxSize)
+	manager.stackManager.SetFreezeDataPath(manager.agentOptions.DataPath)
+	manager.stackManager.SetChangelog(manager.agentOptions.DataPath, manager.agentOptions.EdgeStackChangelogReport)
+	manager.stackManager.SetArchivePath(filepath.Join(manager.agentOptions.DataPath, agent.StackArchivesFolder))
+
+	payloadCache, err := blobstore.NewStore(blobstore.Config{
+		Backend:     manager.agentOptions.EdgeStackCache,
+		Path:        filepath.Join(manager.agentOptions.DataPath, agent.StackCacheFolder),
+		MaxSize:     manager.agentOptions.EdgeStackCacheSize,
+		S3URL:       manager.agentOptions.EdgeStackCacheS3URL,
+		S3Region:    manager.agentOptions.EdgeStackCacheS3Region,
+		S3AccessKey: manager.agentOptions.EdgeStackCacheS3AccessKey,
+		S3SecretKey: manager.agentOptions.EdgeStackCacheS3SecretKey,
+	})
+	if err != nil {
+		// The stacks are still deployed without the cache
+		log.Warn().Err(err).Msg("unable to create the cache of the stack payloads, it is disabled")
+	} else {
+		manager.stackManager.SetPayloadCache(payloadCache)
+	}
+
+	if manager.agentOptions.EdgeImagePolicyFile != "" {
+		policy, err := imagepolicy.Load(manager.agentOptions.EdgeImagePolicyFile)
+		if err != nil {
+			return fmt.Errorf("unable to load the image policy: %w", err)
+		}
+
+		manager.stackManager.SetImagePolicy(policy)
+	}
+
+	if manager.agentOptions.EdgeSecurityPolicyFile != "" {
+		policy, err := securitypolicy.Load(manager.agentOptions.EdgeSecurityPolicyFile)
+		if err != nil {
+			return fmt.Errorf("unable to load the security policy: %w", err)
+		}
+
+		manager.stackManager.SetSecurityPolicy(policy)
+	}
+
+	if manager.agentOptions.EdgeFirewallPolicyFile != "" {
+		policy, err := firewall.Load(manager.agentOptions.EdgeFirewallPolicyFile)
+		if err != nil {
+			return fmt.Errorf("unable to load the firewall policy: %w", err)
+		}
+
+		manager.stackManager.SetFirewall(firewall.New(policy))
+	}
+
+	if manager.agentOptions.EdgeOPAPolicy != "" {
+		pollServiceConfig.PayloadPolicy = opa.NewEvaluator(manager.agentOptions.EdgeOPABinary, manager.agentOptions.EdgeOPAPolicy)
+		manager.stackManager.SetPayloadPolicy(pollServiceConfig.PayloadPolicy)
+	}
+
+	if len(manager.agentOptions.EdgeFileTransferPaths) > 0 {
+		pollServiceConfig.FileTransfers = filetransfer.NewManager(agent.HostRoot, manager.agentOptions.DataPath, manager.agentOptions.EdgeFileTransferPaths, manager.agentOptions.EdgeFileTransferSize)
+	}
+
+	pollServiceConfig.Backup = &backup.Source{
+		DataPath:   manager.agentOptions.DataPath,
+		StacksPath: agent.EdgeStackFilesPath,
+		EdgeID:     manager.agentOptions.EdgeID,
+		Settings:   agentos.Settings(),
+	}
+
+	var runtimes func() (map[string]string, error)
+	if manager.containerPlatform == agent.PlatformDocker {
+		runtimes = docker.GetComponentVersions
+	}
+
+	hostInfo, err := hostinfo.NewCollector(agent.HostRoot, runtimes, manager.agentOptions.EdgeHostUpdatesCheck, manager.agentOptions.EdgeHostUpdateActions)
+	if err != nil {
+		return err
+	}
+
+	hostInfo.Start(manager.agentOptions.EdgeHostInfoInterval)
+	pollServiceConfig.HostInfo = hostInfo
+
+	criticalHours, err := power.ParseCriticalHours(manager.agentOptions.EdgePowerCriticalHours)
+	if err != nil {
+		return err
+	}
+
+	pollServiceConfig.Power = power.NewManager(manager.agentOptions.DataPath, agent.HostRoot, criticalHours, portainerClient, manager.stackManager.StopStacks)
+
+	// The manager can be started again, the hooks of the webhook are registered once
+	if len(manager.agentOptions.EdgeStatusWebhooks) > 0 && manager.statusWebhook == nil {
+		manager.statusWebhook = notify.NewStatusWebhook(notify.StatusWebhookConfig{
+			URLs:      manager.agentOptions.EdgeStatusWebhooks,
+			EdgeID:    manager.agentOptions.EdgeID,
+			EdgeKey:   manager.rawKey,
+			RateLimit: manager.agentOptions.EdgeStatusWebhookRate,
+		})
+		manager.statusWebhook.Start()
+	}
 
 	manager.logsManager = scheduler.NewLogsManager(portainerClient)
 	manager.logsManager.Start()
 
+	scheduler.NewHistoryRecorder(manager.jobHistory, portainerClient, manager.agentOptions.EdgeJobHistoryReport).Start()
+
 	pollService, err := newPollService(
 		manager,
 		manager.stackManager,
@@ -129,6 +363,10 @@ func (manager *Manager) Start() error {
 	}
 	manager.pollService = pollService
 
+	if manager.agentOptions.EdgeStandby {
+		manager.startStandbyElection()
+	}
+
 	return manager.startEdgeBackgroundProcess()
 }
 
@@ -176,11 +414,20 @@ func (manager *Manager) startEdgeBackgroundProcessOnDocker(runtimeCheckFrequency
 }
 
 func (manager *Manager) startEdgeBackgroundProcessOnKubernetes(runtimeCheckFrequency time.Duration) error {
-	manager.pollService.Start()
+	if manager.isActive() {
+		manager.pollService.Start()
+	}
 
 	go func() {
 		ticker := time.NewTicker(runtimeCheckFrequency)
 		for range ticker.C {
+			if !manager.isActive() {
+				manager.pollService.Stop()
+				manager.stackManager.Stop()
+
+				continue
+			}
+
 			manager.pollService.Start()
 
 			err := manager.stackManager.SetEngineStatus(stack.EngineTypeKubernetes)
@@ -203,11 +450,20 @@ func (manager *Manager) startEdgeBackgroundProcessOnKubernetes(runtimeCheckFrequ
 }
 
 func (manager *Manager) startEdgeBackgroundProcessOnNomad(runtimeCheckFrequency time.Duration) error {
-	manager.pollService.Start()
+	if manager.isActive() {
+		manager.pollService.Start()
+	}
 
 	go func() {
 		ticker := time.NewTicker(runtimeCheckFrequency)
 		for range ticker.C {
+			if !manager.isActive() {
+				manager.pollService.Stop()
+				manager.stackManager.Stop()
+
+				continue
+			}
+
 			manager.pollService.Start()
 
 			err := manager.stackManager.SetEngineStatus(stack.EngineTypeNomad)
@@ -229,6 +485,42 @@ func (manager *Manager) startEdgeBackgroundProcessOnNomad(runtimeCheckFrequency
 	return nil
 }
 
+func (manager *Manager) startEdgeBackgroundProcessOnContainerd(runtimeCheckFrequency time.Duration) error {
+	if manager.isActive() {
+		manager.pollService.Start()
+	}
+
+	go func() {
+		ticker := time.NewTicker(runtimeCheckFrequency)
+		for range ticker.C {
+			if !manager.isActive() {
+				manager.pollService.Stop()
+				manager.stackManager.Stop()
+
+				continue
+			}
+
+			manager.pollService.Start()
+
+			err := manager.stackManager.SetEngineStatus(stack.EngineTypeContainerd)
+			if err != nil {
+				log.Error().Err(err).Msg("unable to set engine status")
+
+				return
+			}
+
+			err = manager.stackManager.Start()
+			if err != nil {
+				log.Error().Err(err).Msg("unable to start stack manager")
+
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
 func (manager *Manager) startEdgeBackgroundProcess() error {
 	runtimeCheckFrequency, err := time.ParseDuration(agent.DefaultConfigCheckInterval)
 	if err != nil {
@@ -242,6 +534,8 @@ func (manager *Manager) startEdgeBackgroundProcess() error {
 		return manager.startEdgeBackgroundProcessOnKubernetes(runtimeCheckFrequency)
 	case agent.PlatformNomad:
 		return manager.startEdgeBackgroundProcessOnNomad(runtimeCheckFrequency)
+	case agent.PlatformContainerd:
+		return manager.startEdgeBackgroundProcessOnContainerd(runtimeCheckFrequency)
 	}
 
 	return nil
@@ -261,7 +555,7 @@ func (manager *Manager) checkDockerRuntimeConfig() error {
 		Bool("leader_node", agentRunsOnLeaderNode).
 		Msg("Docker runtime configuration check")
 
-	if !agentRunsOnSwarm || agentRunsOnLeaderNode {
+	if (!agentRunsOnSwarm || agentRunsOnLeaderNode) && manager.isActive() {
 		engineStatus := stack.EngineTypeDockerStandalone
 		if agentRunsOnSwarm {
 			engineStatus = stack.EngineTypeDockerSwarm
@@ -283,46 +577,13 @@ func (manager *Manager) checkDockerRuntimeConfig() error {
 }
 
 func (manager *Manager) CreateEdgeConfig(config *client.EdgeConfig) error {
-	baseDir := filesystem.JoinPaths(agent.HostRoot, config.BaseDir)
-
-	err := filesystem.DecodeDirEntries(config.DirEntries)
-	if err != nil {
-		return err
-	}
-
-	for _, file := range config.DirEntries {
-		log.Debug().Str("base", baseDir).Str("path", file.Name).Msg("creating file")
-	}
-
-	return filesystem.PersistDir(baseDir, config.DirEntries)
+	return manager.edgeConfigManager().Apply(config)
 }
 
 func (manager *Manager) DeleteEdgeConfig(config *client.EdgeConfig) error {
-	baseDir := filesystem.JoinPaths(agent.HostRoot, config.BaseDir)
-
-	for _, dirEntry := range config.DirEntries {
-		path := filesystem.JoinPaths(baseDir, dirEntry.Name)
-
-		if !dirEntry.IsFile {
-			continue
-		}
-
-		log.Debug().Str("base", baseDir).Str("path", dirEntry.Name).Msg("removing file")
-
-		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
-			log.Error().Err(err).Str("base", baseDir).Str("path", dirEntry.Name).Msg("failed to remove file")
-
-			return err
-		}
-	}
-
-	return nil
+	return manager.edgeConfigManager().Remove(config)
 }
 
 func (manager *Manager) UpdateEdgeConfig(config *client.EdgeConfig) error {
-	if err := manager.DeleteEdgeConfig(config.Prev); err != nil {
-		return err
-	}
-
-	return manager.CreateEdgeConfig(config)
+	return manager.edgeConfigManager().Update(config)
 }
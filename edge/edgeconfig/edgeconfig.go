@@ -0,0 +1,305 @@
+package edgeconfig
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/portainer/agent/edge/client"
+	agentfs "github.com/portainer/agent/filesystem"
+	"github.com/portainer/portainer/api/filesystem"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultReloadSignal is the signal sent to the containers reloaded after an update
+const DefaultReloadSignal = "SIGHUP"
+
+// ConsumerLabel is the label of the containers and services consuming Edge configurations,
+// its value is a comma-separated list of configuration names. They are restarted when the
+// content of one of these configurations changes.
+const ConsumerLabel = "io.portainer.edge.configs"
+
+// Runtime acts on the workloads consuming the Edge configurations
+type Runtime interface {
+	// Signal sends a signal to a container
+	Signal(container, signal string) error
+	// RestartConsumers restarts the workloads labeled with ConsumerLabel that consume the configuration
+	RestartConsumers(configName string) error
+}
+
+// Manager writes the files of the Edge configurations to the host
+type Manager struct {
+	hostRoot string
+	facts    func() Facts
+	runtime  Runtime
+}
+
+// NewManager returns a pointer to a new instance of Manager. The facts are retrieved each time
+// a configuration is written, runtime can be nil when the platform does not support reloading workloads.
+func NewManager(hostRoot string, facts func() Facts, runtime Runtime) *Manager {
+	return &Manager{
+		hostRoot: hostRoot,
+		facts:    facts,
+		runtime:  runtime,
+	}
+}
+
+// Apply writes the files of the configuration, then reloads the containers when a file changed
+func (manager *Manager) Apply(config *client.EdgeConfig) error {
+	_, err := manager.apply(config)
+
+	return err
+}
+
+// Update writes the files of the configuration and removes the files of the previous version
+// that are no longer part of it. Unchanged files are left untouched, so that the containers are
+// only reloaded when the content of a file changed.
+func (manager *Manager) Update(config *client.EdgeConfig) error {
+	targets, err := manager.apply(config)
+	if err != nil || config.Prev == nil {
+		return err
+	}
+
+	return manager.remove(config.Prev, targets)
+}
+
+func (manager *Manager) apply(config *client.EdgeConfig) (map[string]struct{}, error) {
+	encodings := make(map[string]string, len(config.Files))
+	for name, options := range config.Files {
+		if options.Encoding != "" {
+			encodings[name] = options.Encoding
+		}
+	}
+
+	if err := agentfs.DecodeDirEntries(config.DirEntries, encodings); err != nil {
+		return nil, err
+	}
+
+	facts := manager.facts()
+	baseDir := filesystem.JoinPaths(manager.hostRoot, config.BaseDir)
+
+	var changed bool
+	targets := make(map[string]struct{})
+
+	for _, dirEntry := range config.DirEntries {
+		if !dirEntry.IsFile {
+			if err := os.MkdirAll(filesystem.JoinPaths(baseDir, dirEntry.Name), dirEntry.Permissions); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		options := config.Files[dirEntry.Name]
+
+		target, err := manager.targetPath(config, dirEntry.Name, options, facts)
+		if err != nil {
+			return nil, err
+		}
+
+		targets[target] = struct{}{}
+
+		content := dirEntry.Content
+
+		// The binary files of a templated configuration are written as they are
+		binary := agentfs.IsBinary(content)
+		if binary && options.Template {
+			return nil, fmt.Errorf("unable to render %s: binary files cannot be rendered as templates", dirEntry.Name)
+		}
+
+		if (config.Templated && !binary) || options.Template {
+			if content, err = render(dirEntry.Name, content, facts); err != nil {
+				return nil, fmt.Errorf("unable to render %s: %w", dirEntry.Name, err)
+			}
+		}
+
+		mode := dirEntry.Permissions
+		if options.Mode != "" {
+			m, err := strconv.ParseUint(options.Mode, 8, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid mode %q for %s: %w", options.Mode, dirEntry.Name, err)
+			}
+
+			mode = os.FileMode(m)
+		}
+
+		log.Debug().Str("base", baseDir).Str("path", dirEntry.Name).Str("target", target).Msg("creating file")
+
+		fileChanged, err := writeFile(target, []byte(content), mode, options)
+		if err != nil {
+			return nil, err
+		}
+
+		changed = changed || fileChanged
+	}
+
+	if !changed {
+		return targets, nil
+	}
+
+	return targets, errors.Join(manager.reload(config), manager.restartConsumers(config))
+}
+
+// Remove removes the files of the configuration
+func (manager *Manager) Remove(config *client.EdgeConfig) error {
+	return manager.remove(config, nil)
+}
+
+// remove removes the files of the configuration, except the kept ones
+func (manager *Manager) remove(config *client.EdgeConfig, kept map[string]struct{}) error {
+	facts := manager.facts()
+
+	for _, dirEntry := range config.DirEntries {
+		if !dirEntry.IsFile {
+			continue
+		}
+
+		target, err := manager.targetPath(config, dirEntry.Name, config.Files[dirEntry.Name], facts)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := kept[target]; ok {
+			continue
+		}
+
+		log.Debug().Str("path", dirEntry.Name).Str("target", target).Msg("removing file")
+
+		if err := os.Remove(target); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Error().Err(err).Str("path", dirEntry.Name).Str("target", target).Msg("failed to remove file")
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+// targetPath returns the host path the file is written to
+func (manager *Manager) targetPath(config *client.EdgeConfig, name string, options client.EdgeConfigFileOptions, facts Facts) (string, error) {
+	if options.Target == "" {
+		return filesystem.JoinPaths(manager.hostRoot, config.BaseDir, name), nil
+	}
+
+	target, err := render(name+" target", options.Target, facts)
+	if err != nil {
+		return "", fmt.Errorf("unable to render the target of %s: %w", name, err)
+	}
+
+	if filepath.IsAbs(target) {
+		return filesystem.JoinPaths(manager.hostRoot, target), nil
+	}
+
+	return filesystem.JoinPaths(manager.hostRoot, config.BaseDir, target), nil
+}
+
+// writeFile atomically replaces the file when its content, mode or owner differ and returns true when its content changed
+func writeFile(path string, content []byte, mode os.FileMode, options client.EdgeConfigFileOptions) (bool, error) {
+	current, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+
+	changed := err != nil || !bytes.Equal(current, content)
+
+	if !changed {
+		if info, err := os.Stat(path); err == nil && info.Mode().Perm() == mode.Perm() && options.UID == nil && options.GID == nil {
+			return false, nil
+		}
+	}
+
+	dir, name := filepath.Split(path)
+	tmpName := "." + name + ".tmp"
+	tmpPath := filepath.Join(dir, tmpName)
+
+	if err := agentfs.WriteFile(dir, tmpName, content, uint32(mode.Perm())); err != nil {
+		return false, err
+	}
+
+	// The mode is set explicitly as it is filtered by the umask on creation
+	if err := os.Chmod(tmpPath, mode.Perm()); err != nil {
+		os.Remove(tmpPath)
+
+		return false, err
+	}
+
+	if options.UID != nil || options.GID != nil {
+		uid, gid := -1, -1
+		if options.UID != nil {
+			uid = *options.UID
+		}
+
+		if options.GID != nil {
+			gid = *options.GID
+		}
+
+		if err := os.Lchown(tmpPath, uid, gid); err != nil {
+			os.Remove(tmpPath)
+
+			return false, err
+		}
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+
+		return false, err
+	}
+
+	return changed, agentfs.SyncDir(dir)
+}
+
+// reload signals the containers of the configuration
+func (manager *Manager) reload(config *client.EdgeConfig) error {
+	var errs []error
+
+	for _, reload := range config.Reload {
+		if manager.runtime == nil {
+			log.Warn().Str("container", reload.Container).Msg("reloading containers is not supported on this platform")
+
+			continue
+		}
+
+		signal := reload.Signal
+		if signal == "" {
+			signal = DefaultReloadSignal
+		}
+
+		log.Debug().Str("config", config.Name).Str("container", reload.Container).Str("signal", signal).Msg("reloading container")
+
+		if err := manager.runtime.Signal(reload.Container, signal); err != nil {
+			errs = append(errs, fmt.Errorf("unable to reload the container %s: %w", reload.Container, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// restartConsumers restarts the workloads consuming the configuration
+func (manager *Manager) restartConsumers(config *client.EdgeConfig) error {
+	if manager.runtime == nil {
+		return nil
+	}
+
+	if err := manager.runtime.RestartConsumers(config.Name); err != nil {
+		return fmt.Errorf("unable to restart the consumers of the configuration %s: %w", config.Name, err)
+	}
+
+	return nil
+}
+
+// Consumes returns true when the value of ConsumerLabel lists the configuration
+func Consumes(labelValue, configName string) bool {
+	for _, name := range strings.Split(labelValue, ",") {
+		if strings.TrimSpace(name) == configName {
+			return true
+		}
+	}
+
+	return false
+}
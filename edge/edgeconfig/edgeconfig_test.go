@@ -0,0 +1,158 @@
+package edgeconfig
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/portainer/api/filesystem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type signaled struct {
+	container string
+	signal    string
+}
+
+type fakeRuntime struct {
+	signals  []signaled
+	restarts []string
+}
+
+func (runtime *fakeRuntime) Signal(container, signal string) error {
+	runtime.signals = append(runtime.signals, signaled{container, signal})
+
+	return nil
+}
+
+func (runtime *fakeRuntime) RestartConsumers(configName string) error {
+	runtime.restarts = append(runtime.restarts, configName)
+
+	return nil
+}
+
+func newTestManager(t *testing.T) (*Manager, string, *fakeRuntime) {
+	hostRoot := t.TempDir()
+	runtime := &fakeRuntime{}
+
+	manager := NewManager(hostRoot, func() Facts {
+		return Facts{EdgeID: "device-1", Hostname: "edge-host", Platform: "docker"}
+	}, runtime)
+
+	return manager, hostRoot, runtime
+}
+
+func encode(content string) string {
+	return base64.StdEncoding.EncodeToString([]byte(content))
+}
+
+func newConfig() *client.EdgeConfig {
+	return &client.EdgeConfig{
+		Name:    "app",
+		BaseDir: "/etc/app",
+		DirEntries: []filesystem.DirEntry{
+			{Name: "app.conf", Content: encode("id={{ .EdgeID }}\nhost={{ .Hostname | upper }}\n"), IsFile: true, Permissions: 0644},
+			{Name: "static.txt", Content: encode("{{ not templated }}"), IsFile: true, Permissions: 0644},
+		},
+		Files: map[string]client.EdgeConfigFileOptions{
+			"app.conf": {Template: true, Target: "devices/{{ .EdgeID }}.conf", Mode: "0600"},
+		},
+		Reload: []client.EdgeConfigReload{{Container: "app"}},
+	}
+}
+
+func TestApply(t *testing.T) {
+	manager, hostRoot, runtime := newTestManager(t)
+
+	require.NoError(t, manager.Apply(newConfig()))
+
+	target := filepath.Join(hostRoot, "etc", "app", "devices", "device-1.conf")
+
+	content, err := os.ReadFile(target)
+	require.NoError(t, err)
+	assert.Equal(t, "id=device-1\nhost=EDGE-HOST\n", string(content))
+
+	info, err := os.Stat(target)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	content, err = os.ReadFile(filepath.Join(hostRoot, "etc", "app", "static.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "{{ not templated }}", string(content))
+
+	assert.Equal(t, []signaled{{"app", DefaultReloadSignal}}, runtime.signals)
+	assert.Equal(t, []string{"app"}, runtime.restarts)
+
+	// Applying the same configuration again does not reload nor restart the containers
+	require.NoError(t, manager.Apply(newConfig()))
+	assert.Len(t, runtime.signals, 1)
+	assert.Len(t, runtime.restarts, 1)
+}
+
+func TestApplyInvalidTemplate(t *testing.T) {
+	manager, _, runtime := newTestManager(t)
+
+	config := newConfig()
+	config.DirEntries[0].Content = encode("{{ .Unknown }}")
+
+	assert.Error(t, manager.Apply(config))
+	assert.Empty(t, runtime.signals)
+	assert.Empty(t, runtime.restarts)
+}
+
+func TestApplyTemplatedBinary(t *testing.T) {
+	manager, hostRoot, _ := newTestManager(t)
+
+	binary := string([]byte{0x30, 0x82, 0x00, 0xff, '{', '{'})
+
+	newBinaryConfig := func() *client.EdgeConfig {
+		config := newConfig()
+		config.Templated = true
+		config.DirEntries = append(config.DirEntries[:1], filesystem.DirEntry{Name: "ca.der", Content: encode(binary), IsFile: true, Permissions: 0644})
+
+		return config
+	}
+
+	require.NoError(t, manager.Apply(newBinaryConfig()))
+
+	// The binary files are written as they are
+	content, err := os.ReadFile(filepath.Join(hostRoot, "etc", "app", "ca.der"))
+	require.NoError(t, err)
+	assert.Equal(t, binary, string(content))
+
+	config := newBinaryConfig()
+	config.Files["ca.der"] = client.EdgeConfigFileOptions{Template: true}
+	assert.ErrorContains(t, manager.Apply(config), "binary files cannot be rendered")
+}
+
+func TestUpdate(t *testing.T) {
+	manager, hostRoot, runtime := newTestManager(t)
+
+	prev := newConfig()
+	require.NoError(t, manager.Apply(prev))
+
+	config := newConfig()
+	config.DirEntries = config.DirEntries[:1]
+	config.Prev = newConfig()
+
+	require.NoError(t, manager.Update(config))
+
+	// The file removed from the configuration is deleted, the unchanged one is kept without reloading
+	assert.NoFileExists(t, filepath.Join(hostRoot, "etc", "app", "static.txt"))
+	assert.FileExists(t, filepath.Join(hostRoot, "etc", "app", "devices", "device-1.conf"))
+	assert.Len(t, runtime.signals, 1)
+	assert.Len(t, runtime.restarts, 1)
+
+	require.NoError(t, manager.Remove(config))
+	assert.NoFileExists(t, filepath.Join(hostRoot, "etc", "app", "devices", "device-1.conf"))
+}
+
+func TestConsumes(t *testing.T) {
+	assert.True(t, Consumes("app", "app"))
+	assert.True(t, Consumes("nginx, app", "app"))
+	assert.False(t, Consumes("application", "app"))
+	assert.False(t, Consumes("", "app"))
+}
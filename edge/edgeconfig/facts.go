@@ -0,0 +1,53 @@
+package edgeconfig
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// Facts describes the device, they are available to the templated files and targets
+type Facts struct {
+	EdgeID       string
+	EndpointID   int
+	Hostname     string
+	Platform     string
+	OS           string
+	Arch         string
+	AgentVersion string
+	EdgeGroupIDs []int
+	TagIDs       []int
+}
+
+var templateFuncs = template.FuncMap{
+	"env":   os.Getenv,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"trim":  strings.TrimSpace,
+	"replace": func(old, new, s string) string {
+		return strings.ReplaceAll(s, old, new)
+	},
+	"default": func(def, value string) string {
+		if value == "" {
+			return def
+		}
+
+		return value
+	},
+}
+
+// render executes the content as a Go template against the facts, missing keys are errors
+func render(name, content string, facts Facts) (string, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(content)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, facts); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
@@ -0,0 +1,217 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/portainer/agent"
+	"github.com/portainer/agent/kubernetes"
+	agentos "github.com/portainer/agent/os"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// Folder is the folder of the data folder the data of the endpoints is kept in, one folder per endpoint
+	Folder = "endpoints"
+
+	// registryServerBasePort is the port the registry credential server of the first endpoint listens on,
+	// the next ports being used by the next endpoints
+	registryServerBasePort = 9006
+
+	minRestartDelay = time.Second
+	maxRestartDelay = time.Minute
+)
+
+var validName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// Endpoint is an additional environment managed by the agent and registered as a separate Portainer endpoint,
+// e.g. a k3s cluster running next to the Docker engine of the device
+type Endpoint struct {
+	// Name identifies the endpoint, its data is kept in the endpoints/<Name> folder of the data folder
+	Name    string
+	EdgeID  string
+	EdgeKey string
+	// Platform is docker, podman, kubernetes, nomad or containerd
+	Platform string
+	// DockerHost is the address of the Docker engine of the endpoint, the local engine when empty
+	DockerHost string
+	// Kubeconfig is the kubeconfig file of the Kubernetes cluster of the endpoint
+	Kubeconfig string
+	// Port is the port of the API of the endpoint, it must differ from the ports of the agent and the other endpoints
+	Port int
+	// Env holds the other options of the endpoint, as environment variables, e.g. EDGE_ASYNC=1
+	Env map[string]string
+}
+
+// Load reads the endpoints from a JSON file
+func Load(path string) ([]Endpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var endpoints []Endpoint
+	if err := json.Unmarshal(data, &endpoints); err != nil {
+		return nil, fmt.Errorf("invalid endpoints file: %w", err)
+	}
+
+	names := make(map[string]bool)
+	ports := make(map[int]bool)
+
+	for _, endpoint := range endpoints {
+		if !validName.MatchString(endpoint.Name) {
+			return nil, fmt.Errorf("invalid endpoint name %q, only lowercase letters, digits and dashes are allowed", endpoint.Name)
+		}
+
+		if names[endpoint.Name] {
+			return nil, fmt.Errorf("the endpoint name %s is used several times", endpoint.Name)
+		}
+
+		if endpoint.EdgeID == "" || endpoint.EdgeKey == "" {
+			return nil, fmt.Errorf("the Edge ID and the Edge key of the endpoint %s are required", endpoint.Name)
+		}
+
+		switch endpoint.Platform {
+		case "docker", "podman", "nomad", "containerd":
+		case "kubernetes":
+			if endpoint.Kubeconfig == "" {
+				return nil, fmt.Errorf("the kubeconfig of the Kubernetes endpoint %s is required", endpoint.Name)
+			}
+		default:
+			return nil, fmt.Errorf("invalid platform %q of the endpoint %s", endpoint.Platform, endpoint.Name)
+		}
+
+		if endpoint.Port <= 0 || ports[endpoint.Port] {
+			return nil, fmt.Errorf("the endpoint %s requires a port of its own", endpoint.Name)
+		}
+
+		names[endpoint.Name] = true
+		ports[endpoint.Port] = true
+	}
+
+	return endpoints, nil
+}
+
+// Supervisor runs an agent process for each endpoint and restarts it when it exits. The processes inherit
+// the environment of the agent, not its flags, with the options of their endpoint.
+type Supervisor struct {
+	executable string
+	dataPath   string
+	endpoints  []Endpoint
+}
+
+// NewSupervisor returns a pointer to a new instance of Supervisor
+func NewSupervisor(dataPath, agentPort string, endpoints []Endpoint) (*Supervisor, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, endpoint := range endpoints {
+		if strconv.Itoa(endpoint.Port) == agentPort {
+			return nil, fmt.Errorf("the endpoint %s uses the port of the agent", endpoint.Name)
+		}
+	}
+
+	return &Supervisor{
+		executable: executable,
+		dataPath:   dataPath,
+		endpoints:  endpoints,
+	}, nil
+}
+
+// Start starts the agents of the endpoints in the background
+func (supervisor *Supervisor) Start() error {
+	for i, endpoint := range supervisor.endpoints {
+		if err := os.MkdirAll(supervisor.endpointDataPath(endpoint), 0700); err != nil {
+			return err
+		}
+
+		go supervisor.run(endpoint, supervisor.environment(i, endpoint))
+	}
+
+	return nil
+}
+
+func (supervisor *Supervisor) run(endpoint Endpoint, env []string) {
+	delay := minRestartDelay
+
+	for {
+		startedAt := time.Now()
+
+		log.Info().Str("endpoint", endpoint.Name).Str("platform", endpoint.Platform).Msg("starting the agent of the endpoint")
+
+		cmd := exec.Command(supervisor.executable)
+		cmd.Env = env
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+
+		err := cmd.Run()
+		if err == nil {
+			err = errors.New("exited")
+		}
+
+		// The delay grows while the agent keeps failing at startup
+		if time.Since(startedAt) > maxRestartDelay {
+			delay = minRestartDelay
+		}
+
+		log.Error().Err(err).Str("endpoint", endpoint.Name).Dur("restart_in", delay).Msg("the agent of the endpoint stopped")
+
+		time.Sleep(delay)
+
+		delay = min(delay*2, maxRestartDelay)
+	}
+}
+
+func (supervisor *Supervisor) endpointDataPath(endpoint Endpoint) string {
+	return filepath.Join(supervisor.dataPath, Folder, endpoint.Name)
+}
+
+// environment returns the environment of the agent of the endpoint, the options of the agent
+// identifying it or its engine are replaced by the ones of the endpoint
+func (supervisor *Supervisor) environment(index int, endpoint Endpoint) []string {
+	overrides := map[string]string{
+		agentos.EnvKeyEdge:                 "1",
+		agentos.EnvKeyEdgeID:               endpoint.EdgeID,
+		agentos.EnvKeyEdgeKey:              endpoint.EdgeKey,
+		agentos.EnvKeyDataPath:             supervisor.endpointDataPath(endpoint),
+		agentos.EnvKeyAgentPort:            strconv.Itoa(endpoint.Port),
+		agentos.EnvKeyMDNS:                 "false",
+		agentos.EnvKeyEdgeEndpointsFile:    "",
+		agentos.EnvKeyDockerHost:           endpoint.DockerHost,
+		agentos.EnvKeyDockerContext:        "",
+		agentos.PlatformOverride:           endpoint.Platform,
+		agent.RegistryServerAddrEnvVarName: "127.0.0.1:" + strconv.Itoa(registryServerBasePort+index),
+		kubernetes.KubeconfigEnvVarName:    endpoint.Kubeconfig,
+	}
+
+	for key, value := range endpoint.Env {
+		overrides[key] = value
+	}
+
+	env := make([]string, 0, len(os.Environ())+len(overrides))
+	for _, variable := range os.Environ() {
+		key, _, _ := strings.Cut(variable, "=")
+		if _, ok := overrides[key]; !ok {
+			env = append(env, variable)
+		}
+	}
+
+	for key, value := range overrides {
+		if value != "" {
+			env = append(env, key+"="+value)
+		}
+	}
+
+	return env
+}
@@ -0,0 +1,103 @@
+package endpoints
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeEndpoints(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "endpoints.json")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	path := writeEndpoints(t, `[
+		{"Name": "k3s", "EdgeID": "id-1", "EdgeKey": "key-1", "Platform": "kubernetes", "Kubeconfig": "/etc/rancher/k3s/k3s.yaml", "Port": 9011},
+		{"Name": "lab", "EdgeID": "id-2", "EdgeKey": "key-2", "Platform": "docker", "DockerHost": "tcp://10.0.0.2:2376", "Port": 9012, "Env": {"EDGE_ASYNC": "1"}}
+	]`)
+
+	list, err := Load(path)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+
+	assert.Equal(t, "k3s", list[0].Name)
+	assert.Equal(t, "/etc/rancher/k3s/k3s.yaml", list[0].Kubeconfig)
+	assert.Equal(t, "1", list[1].Env["EDGE_ASYNC"])
+}
+
+func TestLoad_invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"invalid name", `[{"Name": "K3S/1", "EdgeID": "id", "EdgeKey": "key", "Platform": "docker", "Port": 9011}]`},
+		{"duplicated name", `[
+			{"Name": "lab", "EdgeID": "id-1", "EdgeKey": "key-1", "Platform": "docker", "Port": 9011},
+			{"Name": "lab", "EdgeID": "id-2", "EdgeKey": "key-2", "Platform": "docker", "Port": 9012}
+		]`},
+		{"missing key", `[{"Name": "lab", "EdgeID": "id", "Platform": "docker", "Port": 9011}]`},
+		{"unknown platform", `[{"Name": "lab", "EdgeID": "id", "EdgeKey": "key", "Platform": "lxc", "Port": 9011}]`},
+		{"missing kubeconfig", `[{"Name": "k3s", "EdgeID": "id", "EdgeKey": "key", "Platform": "kubernetes", "Port": 9011}]`},
+		{"duplicated port", `[
+			{"Name": "lab", "EdgeID": "id-1", "EdgeKey": "key-1", "Platform": "docker", "Port": 9011},
+			{"Name": "k3s", "EdgeID": "id-2", "EdgeKey": "key-2", "Platform": "kubernetes", "Kubeconfig": "/k3s.yaml", "Port": 9011}
+		]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeEndpoints(t, tt.content))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestNewSupervisor_agentPort(t *testing.T) {
+	_, err := NewSupervisor(t.TempDir(), "9001", []Endpoint{{Name: "lab", Port: 9001}})
+	assert.Error(t, err)
+}
+
+func TestSupervisorEnvironment(t *testing.T) {
+	t.Setenv("EDGE_ID", "parent-id")
+	t.Setenv("EDGE_KEY", "parent-key")
+	t.Setenv("DOCKER_HOST", "tcp://parent:2376")
+	t.Setenv("LOG_LEVEL", "DEBUG")
+
+	dataPath := t.TempDir()
+	supervisor, err := NewSupervisor(dataPath, "9001", nil)
+	require.NoError(t, err)
+
+	env := supervisor.environment(1, Endpoint{
+		Name:       "k3s",
+		EdgeID:     "id-1",
+		EdgeKey:    "key-1",
+		Platform:   "kubernetes",
+		Kubeconfig: "/etc/rancher/k3s/k3s.yaml",
+		Port:       9011,
+		Env:        map[string]string{"EDGE_ASYNC": "1"},
+	})
+
+	values := make(map[string][]string)
+	for _, variable := range env {
+		key, value, _ := strings.Cut(variable, "=")
+		values[key] = append(values[key], value)
+	}
+
+	assert.Equal(t, []string{"id-1"}, values["EDGE_ID"])
+	assert.Equal(t, []string{"key-1"}, values["EDGE_KEY"])
+	assert.Equal(t, []string{filepath.Join(dataPath, Folder, "k3s")}, values["DATA_PATH"])
+	assert.Equal(t, []string{"9011"}, values["AGENT_PORT"])
+	assert.Equal(t, []string{"kubernetes"}, values["AGENT_PLATFORM"])
+	assert.Equal(t, []string{"/etc/rancher/k3s/k3s.yaml"}, values["KUBECONFIG"])
+	assert.Equal(t, []string{"127.0.0.1:9007"}, values["PORTAINER_REGISTRY_SERVER_ADDR"])
+	assert.Equal(t, []string{"1"}, values["EDGE_ASYNC"])
+	assert.Equal(t, []string{"DEBUG"}, values["LOG_LEVEL"])
+	assert.NotContains(t, values, "DOCKER_HOST")
+}
@@ -0,0 +1,265 @@
+package filetransfer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// auditFileName is the file of the data folder the transfers are audited in, one JSON record per line
+const auditFileName = "file_transfers.log"
+
+// defaultMode is the mode of the files pushed without one
+const defaultMode = 0644
+
+// The directions of the transfers
+const (
+	Push = "push"
+	Pull = "pull"
+)
+
+var (
+	// ErrPathNotAllowed is returned for the transfers of the paths outside of the allowlist of the device
+	ErrPathNotAllowed = errors.New("the path is not allowed for file transfers")
+	// ErrTooLarge is returned for the files exceeding the maximum size of the transfers
+	ErrTooLarge = errors.New("the file exceeds the maximum size of the transfers")
+	// ErrChecksumMismatch is returned when the content of a pushed file does not match its checksum
+	ErrChecksumMismatch = errors.New("the content of the file does not match its checksum")
+)
+
+// AuditRecord is the record of a transfer, kept in the data folder whether the transfer succeeded or not
+type AuditRecord struct {
+	Time      time.Time
+	ID        int
+	Direction string
+	Path      string
+	Size      int64  `json:",omitempty"`
+	Checksum  string `json:",omitempty"`
+	Error     string `json:",omitempty"`
+}
+
+// Manager pushes the files sent by the Portainer server to the host, and pulls files of the host for it. Only the
+// paths of the allowlist of the device can be transferred, the symbolic links are not followed.
+type Manager struct {
+	hostRoot     string
+	dataPath     string
+	allowedPaths []string
+	maxSize      int64
+	mu           sync.Mutex
+}
+
+// NewManager returns a pointer to a new instance of Manager transferring the files under the allowed paths of the
+// host, mounted at hostRoot, up to maxSize bytes
+func NewManager(hostRoot, dataPath string, allowedPaths []string, maxSize int64) *Manager {
+	cleaned := make([]string, 0, len(allowedPaths))
+	for _, p := range allowedPaths {
+		cleaned = append(cleaned, path.Clean(p))
+	}
+
+	return &Manager{
+		hostRoot:     hostRoot,
+		dataPath:     dataPath,
+		allowedPaths: cleaned,
+		maxSize:      maxSize,
+	}
+}
+
+// Push writes the content to the path of the host, replacing the file atomically. The checksum is the hex encoded
+// SHA-256 of the content, the mode defaults to 0644.
+func (manager *Manager) Push(id int, hostPath string, content []byte, checksum string, mode os.FileMode) error {
+	err := manager.push(hostPath, content, checksum, mode)
+
+	manager.audit(AuditRecord{ID: id, Direction: Push, Path: hostPath, Size: int64(len(content)), Checksum: checksum}, err)
+
+	return err
+}
+
+func (manager *Manager) push(hostPath string, content []byte, checksum string, mode os.FileMode) error {
+	target, err := manager.resolve(hostPath)
+	if err != nil {
+		return err
+	}
+
+	if int64(len(content)) > manager.maxSize {
+		return ErrTooLarge
+	}
+
+	if !strings.EqualFold(Checksum(content), checksum) {
+		return ErrChecksumMismatch
+	}
+
+	if mode == 0 {
+		mode = defaultMode
+	}
+
+	dir := filepath.Dir(target)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(dir, "."+filepath.Base(target)+".transfer-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+
+		return err
+	}
+
+	if err := file.Chmod(mode.Perm()); err != nil {
+		file.Close()
+
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), target)
+}
+
+// Pull returns the content of the file of the host and its checksum
+func (manager *Manager) Pull(id int, hostPath string) ([]byte, string, error) {
+	content, err := manager.pull(hostPath)
+
+	record := AuditRecord{ID: id, Direction: Pull, Path: hostPath}
+	if err == nil {
+		record.Size = int64(len(content))
+		record.Checksum = Checksum(content)
+	}
+
+	manager.audit(record, err)
+
+	return content, record.Checksum, err
+}
+
+func (manager *Manager) pull(hostPath string) ([]byte, error) {
+	target, err := manager.resolve(hostPath)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(target)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", hostPath)
+	}
+
+	// The file can grow while it is read
+	content, err := io.ReadAll(io.LimitReader(file, manager.maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(content)) > manager.maxSize {
+		return nil, ErrTooLarge
+	}
+
+	return content, nil
+}
+
+// resolve returns the location of the path of the host in the agent, once checked against the allowlist
+func (manager *Manager) resolve(hostPath string) (string, error) {
+	if !path.IsAbs(hostPath) || path.Clean(hostPath) != hostPath {
+		return "", fmt.Errorf("%w: %s is not a clean absolute path", ErrPathNotAllowed, hostPath)
+	}
+
+	if !manager.allowed(hostPath) {
+		return "", fmt.Errorf("%w: %s", ErrPathNotAllowed, hostPath)
+	}
+
+	// The symbolic links are resolved against the root of the agent instead of the one of the host, they could
+	// point outside of the allowlist
+	current := manager.hostRoot
+	for _, element := range strings.Split(strings.TrimPrefix(hostPath, "/"), "/") {
+		current = filepath.Join(current, element)
+
+		info, err := os.Lstat(current)
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		} else if err != nil {
+			return "", err
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("%w: %s goes through a symbolic link", ErrPathNotAllowed, hostPath)
+		}
+	}
+
+	return filepath.Join(manager.hostRoot, hostPath), nil
+}
+
+func (manager *Manager) allowed(hostPath string) bool {
+	for _, allowed := range manager.allowedPaths {
+		if allowed == "/" || hostPath == allowed || strings.HasPrefix(hostPath, allowed+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (manager *Manager) audit(record AuditRecord, err error) {
+	record.Time = time.Now().UTC()
+
+	logger := log.Info()
+	if err != nil {
+		record.Error = err.Error()
+		logger = log.Error().Err(err)
+	}
+
+	logger.Int("transfer_id", record.ID).Str("direction", record.Direction).Str("path", record.Path).Int64("size", record.Size).Msg("file transfer")
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		log.Error().Err(err).Msg("unable to audit the file transfer")
+
+		return
+	}
+
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	file, err := os.OpenFile(filepath.Join(manager.dataPath, auditFileName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Error().Err(err).Msg("unable to audit the file transfer")
+
+		return
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		log.Error().Err(err).Msg("unable to audit the file transfer")
+	}
+}
+
+// Checksum returns the hex encoded SHA-256 of the content
+func Checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,113 @@
+package filetransfer
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestManager(t *testing.T) (*Manager, string, string) {
+	hostRoot := t.TempDir()
+	dataPath := t.TempDir()
+
+	return NewManager(hostRoot, dataPath, []string{"/etc/app/", "/var/log/app"}, 16), hostRoot, dataPath
+}
+
+func auditRecords(t *testing.T, dataPath string) []AuditRecord {
+	file, err := os.Open(filepath.Join(dataPath, auditFileName))
+	require.NoError(t, err)
+	defer file.Close()
+
+	var records []AuditRecord
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record AuditRecord
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+
+		records = append(records, record)
+	}
+
+	return records
+}
+
+func TestManager_Push(t *testing.T) {
+	manager, hostRoot, dataPath := newTestManager(t)
+
+	content := []byte("level=debug\n")
+
+	require.NoError(t, manager.Push(1, "/etc/app/conf.d/app.conf", content, Checksum(content), 0600))
+
+	written, err := os.ReadFile(filepath.Join(hostRoot, "etc/app/conf.d/app.conf"))
+	require.NoError(t, err)
+	assert.Equal(t, content, written)
+
+	info, err := os.Stat(filepath.Join(hostRoot, "etc/app/conf.d/app.conf"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	err = manager.Push(2, "/etc/app/app.conf", content, Checksum([]byte("other")), 0)
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+
+	large := []byte("this content is too large")
+	err = manager.Push(3, "/etc/app/app.conf", large, Checksum(large), 0)
+	assert.ErrorIs(t, err, ErrTooLarge)
+
+	for _, hostPath := range []string{"/etc/passwd", "/etc/application/app.conf", "/etc/app/../passwd", "etc/app/app.conf"} {
+		err := manager.Push(4, hostPath, content, Checksum(content), 0)
+		assert.ErrorIs(t, err, ErrPathNotAllowed, hostPath)
+	}
+
+	assert.NoFileExists(t, filepath.Join(hostRoot, "etc/app/app.conf"))
+
+	records := auditRecords(t, dataPath)
+	require.Len(t, records, 7)
+	assert.Equal(t, AuditRecord{Time: records[0].Time, ID: 1, Direction: Push, Path: "/etc/app/conf.d/app.conf", Size: int64(len(content)), Checksum: Checksum(content)}, records[0])
+	assert.Equal(t, ErrChecksumMismatch.Error(), records[1].Error)
+}
+
+func TestManager_Pull(t *testing.T) {
+	manager, hostRoot, dataPath := newTestManager(t)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(hostRoot, "var/log/app"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(hostRoot, "var/log/app/app.log"), []byte("started\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(hostRoot, "var/log/app/large.log"), []byte("this content is too large"), 0644))
+
+	content, checksum, err := manager.Pull(1, "/var/log/app/app.log")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("started\n"), content)
+	assert.Equal(t, Checksum(content), checksum)
+
+	_, _, err = manager.Pull(2, "/var/log/app/large.log")
+	assert.ErrorIs(t, err, ErrTooLarge)
+
+	_, _, err = manager.Pull(3, "/var/log/app")
+	assert.Error(t, err)
+
+	_, _, err = manager.Pull(4, "/var/log/syslog")
+	assert.ErrorIs(t, err, ErrPathNotAllowed)
+
+	records := auditRecords(t, dataPath)
+	require.Len(t, records, 4)
+	assert.Equal(t, checksum, records[0].Checksum)
+	assert.NotEmpty(t, records[3].Error)
+}
+
+func TestManager_SymbolicLinks(t *testing.T) {
+	manager, hostRoot, _ := newTestManager(t)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(hostRoot, "etc/app"), 0755))
+	require.NoError(t, os.Symlink("/etc", filepath.Join(hostRoot, "etc/app/system")))
+
+	_, _, err := manager.Pull(1, "/etc/app/system/passwd")
+	assert.ErrorIs(t, err, ErrPathNotAllowed)
+
+	content := []byte("root::0:0")
+	err = manager.Push(2, "/etc/app/system/passwd", content, Checksum(content), 0)
+	assert.ErrorIs(t, err, ErrPathNotAllowed)
+}
@@ -0,0 +1,202 @@
+package firewall
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/netip"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// commentPrefix identifies the rules managed by the agent, the comment of a rule is the prefix followed by its owner
+const commentPrefix = "portainer-agent:"
+
+// commandTimeout bounds each command run on the firewall
+const commandTimeout = 30 * time.Second
+
+// Port is a port published on the host
+type Port struct {
+	Number   uint16
+	Protocol string
+}
+
+func (port Port) String() string {
+	return fmt.Sprintf("%d/%s", port.Number, port.Protocol)
+}
+
+// Firewall opens the ports published by the Edge stacks in the host firewall, with the nft or the iptables binary.
+// The rules of each owner, e.g. a stack, are tagged with a comment so that they are replaced and removed together.
+type Firewall struct {
+	policy *Policy
+	run    func(ctx context.Context, binary string, args []string) ([]byte, error)
+}
+
+// New returns a pointer to a new instance of Firewall enforcing the policy
+func New(policy *Policy) *Firewall {
+	return &Firewall{policy: policy, run: runCommand}
+}
+
+// Open replaces the rules of the owner with rules accepting the ports allowed by the policy, and returns the ports
+// the policy denies, which stay closed
+func (firewall *Firewall) Open(owner string, ports []Port) ([]Port, error) {
+	if err := firewall.Close(owner); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	var denied []Port
+	for _, port := range ports {
+		if !firewall.policy.Allows(port) {
+			denied = append(denied, port)
+
+			continue
+		}
+
+		for _, args := range firewall.openArgs(owner, port) {
+			if _, err := firewall.run(ctx, firewall.binary(), args); err != nil {
+				return denied, fmt.Errorf("unable to open the port %s: %w", port, err)
+			}
+		}
+	}
+
+	return denied, nil
+}
+
+// Close removes the rules of the owner
+func (firewall *Firewall) Close(owner string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	output, err := firewall.run(ctx, firewall.binary(), firewall.listArgs())
+	if err != nil {
+		return fmt.Errorf("unable to list the rules of the firewall: %w", err)
+	}
+
+	for _, args := range firewall.deleteArgs(owner, string(output)) {
+		if _, err := firewall.run(ctx, firewall.binary(), args); err != nil {
+			return fmt.Errorf("unable to remove a rule of the firewall: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (firewall *Firewall) binary() string {
+	if firewall.policy.Backend == BackendIptables {
+		return "iptables"
+	}
+
+	return "nft"
+}
+
+// openArgs returns the commands inserting the rules of the port, one for each source of the policy. The original
+// destination port of the connection is matched, it is the published port before the NAT rules of the engine.
+func (firewall *Firewall) openArgs(owner string, port Port) [][]string {
+	sources := firewall.policy.Sources
+	if len(sources) == 0 {
+		sources = []string{""}
+	}
+
+	commands := make([][]string, 0, len(sources))
+	for _, source := range sources {
+		if firewall.policy.Backend == BackendIptables {
+			args := []string{"-w", "-I", firewall.policy.Chain, "-p", port.Protocol}
+			if source != "" {
+				args = append(args, "-s", source)
+			}
+
+			args = append(args, "-m", "conntrack", "--ctorigdstport", strconv.Itoa(int(port.Number)), "-m", "comment", "--comment", commentPrefix+owner, "-j", "ACCEPT")
+			commands = append(commands, args)
+
+			continue
+		}
+
+		args := append([]string{"insert", "rule"}, strings.Fields(firewall.policy.Table)...)
+		args = append(args, firewall.policy.Chain)
+
+		if source != "" {
+			family := "ip"
+			if prefix, err := netip.ParsePrefix(source); err == nil && !prefix.Addr().Is4() {
+				family = "ip6"
+			}
+
+			args = append(args, family, "saddr", source)
+		}
+
+		args = append(args, "meta", "l4proto", port.Protocol, "ct", "original", "proto-dst", strconv.Itoa(int(port.Number)), "accept", "comment", strconv.Quote(commentPrefix+owner))
+		commands = append(commands, args)
+	}
+
+	return commands
+}
+
+func (firewall *Firewall) listArgs() []string {
+	if firewall.policy.Backend == BackendIptables {
+		return []string{"-w", "-S", firewall.policy.Chain}
+	}
+
+	args := append([]string{"-a", "list", "chain"}, strings.Fields(firewall.policy.Table)...)
+
+	return append(args, firewall.policy.Chain)
+}
+
+// deleteArgs returns the commands removing the rules of the owner from the listing of the chain
+func (firewall *Firewall) deleteArgs(owner, listing string) [][]string {
+	var commands [][]string
+
+	for _, line := range strings.Split(listing, "\n") {
+		if firewall.policy.Backend == BackendIptables {
+			fields := strings.Fields(line)
+			if len(fields) < 2 || fields[0] != "-A" || !hasComment(fields, commentPrefix+owner) {
+				continue
+			}
+
+			commands = append(commands, append([]string{"-w", "-D"}, fields[1:]...))
+
+			continue
+		}
+
+		if !strings.Contains(line, "comment "+strconv.Quote(commentPrefix+owner)+" ") {
+			continue
+		}
+
+		_, handle, ok := strings.Cut(line, "# handle ")
+		if !ok {
+			continue
+		}
+
+		args := append([]string{"delete", "rule"}, strings.Fields(firewall.policy.Table)...)
+		commands = append(commands, append(args, firewall.policy.Chain, "handle", strings.TrimSpace(handle)))
+	}
+
+	return commands
+}
+
+func hasComment(fields []string, comment string) bool {
+	for i := 0; i+1 < len(fields); i++ {
+		if fields[i] == "--comment" && strings.Trim(fields[i+1], `"`) == comment {
+			return true
+		}
+	}
+
+	return false
+}
+
+func runCommand(ctx context.Context, binary string, args []string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, binary, args...)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.Bytes(), nil
+}
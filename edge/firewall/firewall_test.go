@@ -0,0 +1,121 @@
+package firewall
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func loadPolicy(t *testing.T, content string) (*Policy, error) {
+	path := filepath.Join(t.TempDir(), "firewall.json")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+
+	return Load(path)
+}
+
+func TestPolicy_Allows(t *testing.T) {
+	policy, err := loadPolicy(t, `{"allowedPorts": ["80", "443/tcp", "8000-8100/tcp", "502/udp"]}`)
+	require.NoError(t, err)
+
+	assert.Equal(t, BackendNftables, policy.Backend)
+	assert.Equal(t, "inet filter", policy.Table)
+	assert.Equal(t, "input", policy.Chain)
+
+	assert.True(t, policy.Allows(Port{80, ProtocolTCP}))
+	assert.True(t, policy.Allows(Port{80, ProtocolUDP}))
+	assert.True(t, policy.Allows(Port{443, ProtocolTCP}))
+	assert.False(t, policy.Allows(Port{443, ProtocolUDP}))
+	assert.True(t, policy.Allows(Port{8050, ProtocolTCP}))
+	assert.False(t, policy.Allows(Port{8101, ProtocolTCP}))
+	assert.True(t, policy.Allows(Port{502, ProtocolUDP}))
+	assert.False(t, policy.Allows(Port{22, ProtocolTCP}))
+
+	for _, invalid := range []string{
+		`{"backend": "pf"}`,
+		`{"allowedPorts": ["80/sctp"]}`,
+		`{"allowedPorts": ["9000-8000"]}`,
+		`{"backend": "iptables", "sources": ["fd00::/8"]}`,
+		`{"table": "filter"}`,
+	} {
+		_, err := loadPolicy(t, invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestFirewall_nftables(t *testing.T) {
+	policy, err := loadPolicy(t, `{"allowedPorts": ["80/tcp"], "sources": ["192.168.10.0/24"]}`)
+	require.NoError(t, err)
+
+	listing := `table inet filter {
+	chain input { # handle 1
+		type filter hook input priority filter; policy drop;
+		meta l4proto tcp ct original proto-dst 80 accept comment "portainer-agent:stack-1" # handle 7
+		meta l4proto tcp ct original proto-dst 81 accept comment "portainer-agent:stack-12" # handle 8
+		ct state established,related accept # handle 2
+	}
+}
+`
+
+	var commands []string
+	firewall := New(policy)
+	firewall.run = func(ctx context.Context, binary string, args []string) ([]byte, error) {
+		commands = append(commands, binary+" "+strings.Join(args, " "))
+
+		if args[0] == "-a" {
+			return []byte(listing), nil
+		}
+
+		return nil, nil
+	}
+
+	denied, err := firewall.Open("stack-1", []Port{{80, ProtocolTCP}, {22, ProtocolTCP}})
+	require.NoError(t, err)
+	assert.Equal(t, []Port{{22, ProtocolTCP}}, denied)
+
+	assert.Equal(t, []string{
+		"nft -a list chain inet filter input",
+		"nft delete rule inet filter input handle 7",
+		`nft insert rule inet filter input ip saddr 192.168.10.0/24 meta l4proto tcp ct original proto-dst 80 accept comment "portainer-agent:stack-1"`,
+	}, commands)
+}
+
+func TestFirewall_iptables(t *testing.T) {
+	policy, err := loadPolicy(t, `{"backend": "iptables", "chain": "DOCKER-USER", "allowedPorts": ["1883"]}`)
+	require.NoError(t, err)
+
+	listing := `-N DOCKER-USER
+-A DOCKER-USER -p tcp -m conntrack --ctorigdstport 1883 -m comment --comment portainer-agent:stack-1 -j ACCEPT
+-A DOCKER-USER -p tcp -m conntrack --ctorigdstport 1884 -m comment --comment portainer-agent:stack-12 -j ACCEPT
+-A DOCKER-USER -j RETURN
+`
+
+	var commands []string
+	firewall := New(policy)
+	firewall.run = func(ctx context.Context, binary string, args []string) ([]byte, error) {
+		commands = append(commands, binary+" "+strings.Join(args, " "))
+
+		if args[1] == "-S" {
+			return []byte(listing), nil
+		}
+
+		return nil, nil
+	}
+
+	require.NoError(t, firewall.Close("stack-1"))
+
+	assert.Equal(t, []string{
+		"iptables -w -S DOCKER-USER",
+		"iptables -w -D DOCKER-USER -p tcp -m conntrack --ctorigdstport 1883 -m comment --comment portainer-agent:stack-1 -j ACCEPT",
+	}, commands)
+
+	commands = nil
+	_, err = firewall.Open("stack-1", []Port{{1883, ProtocolUDP}})
+	require.NoError(t, err)
+
+	assert.Equal(t, "iptables -w -I DOCKER-USER -p udp -m conntrack --ctorigdstport 1883 -m comment --comment portainer-agent:stack-1 -j ACCEPT", commands[len(commands)-1])
+}
@@ -0,0 +1,158 @@
+package firewall
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The backends managing the rules of the host firewall
+const (
+	BackendNftables = "nftables"
+	BackendIptables = "iptables"
+)
+
+// The protocols of the published ports
+const (
+	ProtocolTCP = "tcp"
+	ProtocolUDP = "udp"
+)
+
+// Policy restricts the ports of the Edge stacks opened in the host firewall. Like the security policy, it is
+// configured on the device rather than sent by the server, the ports it does not allow stay closed. e.g.
+//
+//	{
+//	  "backend": "nftables",
+//	  "table": "inet filter",
+//	  "chain": "input",
+//	  "allowedPorts": ["80", "443/tcp", "8000-8100/tcp", "502/udp"],
+//	  "sources": ["192.168.10.0/24"]
+//	}
+type Policy struct {
+	// Backend is BackendNftables, the default, or BackendIptables for the IPv4 rules of the legacy hosts
+	Backend string `json:"backend"`
+	// Table is the family and the name of the nftables table holding the chain, inet filter by default
+	Table string `json:"table"`
+	// Chain is the chain the rules are inserted at the top of, input for nftables and INPUT for iptables by default.
+	// The rules match the original destination port of the connections, so that the chains filtering the forwarded
+	// traffic, such as DOCKER-USER, can be used for the ports the engine publishes through its NAT rules.
+	Chain string `json:"chain"`
+	// AllowedPorts are the ports or the ranges of ports that can be opened, of both protocols unless one is set
+	AllowedPorts []string `json:"allowedPorts"`
+	// Sources are the networks the opened ports are reachable from, any when empty
+	Sources []string `json:"sources"`
+
+	allowed []portRange
+}
+
+type portRange struct {
+	first, last uint16
+	protocol    string
+}
+
+// Load reads the policy of a JSON file
+func Load(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &Policy{}
+	if err := json.Unmarshal(data, policy); err != nil {
+		return nil, fmt.Errorf("invalid firewall policy %s: %w", path, err)
+	}
+
+	if err := policy.validate(); err != nil {
+		return nil, fmt.Errorf("invalid firewall policy %s: %w", path, err)
+	}
+
+	return policy, nil
+}
+
+func (policy *Policy) validate() error {
+	switch policy.Backend {
+	case "":
+		policy.Backend = BackendNftables
+	case BackendNftables, BackendIptables:
+	default:
+		return fmt.Errorf("unsupported backend %q", policy.Backend)
+	}
+
+	if policy.Backend == BackendNftables {
+		if policy.Table == "" {
+			policy.Table = "inet filter"
+		}
+
+		if len(strings.Fields(policy.Table)) != 2 {
+			return fmt.Errorf("the table %q is not in the <family> <name> format", policy.Table)
+		}
+	}
+
+	if policy.Chain == "" {
+		policy.Chain = "input"
+		if policy.Backend == BackendIptables {
+			policy.Chain = "INPUT"
+		}
+	}
+
+	for _, source := range policy.Sources {
+		prefix, err := netip.ParsePrefix(source)
+		if err != nil {
+			return fmt.Errorf("invalid source: %w", err)
+		}
+
+		if policy.Backend == BackendIptables && !prefix.Addr().Is4() {
+			return fmt.Errorf("the IPv6 source %s requires the nftables backend", source)
+		}
+	}
+
+	for _, port := range policy.AllowedPorts {
+		allowed, err := parsePortRange(port)
+		if err != nil {
+			return err
+		}
+
+		policy.allowed = append(policy.allowed, allowed)
+	}
+
+	return nil
+}
+
+// parsePortRange parses a port or a range of ports, e.g. 80, 443/tcp or 8000-8100/udp
+func parsePortRange(value string) (portRange, error) {
+	ports, protocol, _ := strings.Cut(value, "/")
+	if protocol != "" && protocol != ProtocolTCP && protocol != ProtocolUDP {
+		return portRange{}, fmt.Errorf("invalid protocol of the allowed port %s", value)
+	}
+
+	first, last, isRange := strings.Cut(ports, "-")
+	if !isRange {
+		last = first
+	}
+
+	firstPort, err := strconv.ParseUint(first, 10, 16)
+	if err != nil || firstPort == 0 {
+		return portRange{}, fmt.Errorf("invalid allowed port %s", value)
+	}
+
+	lastPort, err := strconv.ParseUint(last, 10, 16)
+	if err != nil || lastPort < firstPort {
+		return portRange{}, fmt.Errorf("invalid allowed port %s", value)
+	}
+
+	return portRange{first: uint16(firstPort), last: uint16(lastPort), protocol: protocol}, nil
+}
+
+// Allows returns true when the policy allows the port to be opened
+func (policy *Policy) Allows(port Port) bool {
+	for _, allowed := range policy.allowed {
+		if port.Number >= allowed.first && port.Number <= allowed.last && (allowed.protocol == "" || allowed.protocol == port.Protocol) {
+			return true
+		}
+	}
+
+	return false
+}
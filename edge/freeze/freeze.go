@@ -0,0 +1,133 @@
+package freeze
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	agentfs "github.com/portainer/agent/filesystem"
+)
+
+// fileName is the file of the data folder the freeze windows are kept in, so that they can be set
+// by the local CLI while the agent is running
+const fileName = "freeze.json"
+
+// Window freezes the stacks of the device, or a single stack, at their current version until a date
+type Window struct {
+	// StackID is the frozen stack, zero freezes all the stacks of the device
+	StackID int
+	Until   time.Time
+	Reason  string
+}
+
+// dateLayout is the layout of the dates a window can end at, interpreted in the time zone of the device
+const dateLayout = "2006-01-02"
+
+// ParseUntil parses the end of a window, either a RFC3339 time or a date ending the window at midnight
+// in the time zone of the device
+func ParseUntil(value string, loc *time.Location) (time.Time, error) {
+	if t, err := time.ParseInLocation(dateLayout, value, loc); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid freeze end %q, expected a date or a RFC3339 time", value)
+	}
+
+	return t, nil
+}
+
+// Add freezes the stack, or the device, until the end of the window. It replaces the window
+// previously set for the same stack.
+func Add(dataPath string, window Window) error {
+	windows, err := load(dataPath)
+	if err != nil {
+		return err
+	}
+
+	kept := []Window{window}
+	for _, w := range windows {
+		if w.StackID != window.StackID && time.Now().Before(w.Until) {
+			kept = append(kept, w)
+		}
+	}
+
+	return save(dataPath, kept)
+}
+
+// Remove lifts the freeze of the stack, or of the device when stackID is zero
+func Remove(dataPath string, stackID int) error {
+	windows, err := load(dataPath)
+	if err != nil {
+		return err
+	}
+
+	kept := make([]Window, 0, len(windows))
+	for _, w := range windows {
+		if w.StackID != stackID {
+			kept = append(kept, w)
+		}
+	}
+
+	return save(dataPath, kept)
+}
+
+// Active returns the window the stack is frozen by at now, nil when it is not frozen.
+// When both the device and the stack are frozen, the window ending last is returned.
+func Active(dataPath string, stackID int, now time.Time) (*Window, error) {
+	windows, err := load(dataPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var active *Window
+	for i, w := range windows {
+		if (w.StackID != 0 && w.StackID != stackID) || !now.Before(w.Until) {
+			continue
+		}
+
+		if active == nil || w.Until.After(active.Until) {
+			active = &windows[i]
+		}
+	}
+
+	return active, nil
+}
+
+func load(dataPath string) ([]Window, error) {
+	data, err := os.ReadFile(filepath.Join(dataPath, fileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var windows []Window
+	if err := json.Unmarshal(data, &windows); err != nil {
+		return nil, err
+	}
+
+	return windows, nil
+}
+
+func save(dataPath string, windows []Window) error {
+	if len(windows) == 0 {
+		err := os.Remove(filepath.Join(dataPath, fileName))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return err
+	}
+
+	data, err := json.Marshal(windows)
+	if err != nil {
+		return err
+	}
+
+	return agentfs.WriteFile(dataPath, fileName, data, 0600)
+}
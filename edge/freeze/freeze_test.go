@@ -0,0 +1,67 @@
+package freeze
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestActive(t *testing.T) {
+	dataPath := t.TempDir()
+	now := time.Now()
+
+	window, err := Active(dataPath, 1, now)
+	require.NoError(t, err)
+	assert.Nil(t, window)
+
+	require.NoError(t, Add(dataPath, Window{StackID: 1, Until: now.Add(2 * time.Hour), Reason: "stack"}))
+	require.NoError(t, Add(dataPath, Window{Until: now.Add(time.Hour), Reason: "device"}))
+
+	// The window ending last applies
+	window, err = Active(dataPath, 1, now)
+	require.NoError(t, err)
+	require.NotNil(t, window)
+	assert.Equal(t, "stack", window.Reason)
+
+	window, err = Active(dataPath, 2, now)
+	require.NoError(t, err)
+	require.NotNil(t, window)
+	assert.Equal(t, "device", window.Reason)
+
+	window, err = Active(dataPath, 2, now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Nil(t, window)
+
+	// Adding a window replaces the one of the same stack
+	require.NoError(t, Add(dataPath, Window{StackID: 1, Until: now.Add(time.Minute), Reason: "shorter"}))
+
+	window, err = Active(dataPath, 1, now.Add(30*time.Minute))
+	require.NoError(t, err)
+	require.NotNil(t, window)
+	assert.Equal(t, "device", window.Reason)
+
+	require.NoError(t, Remove(dataPath, 0))
+	require.NoError(t, Remove(dataPath, 1))
+
+	window, err = Active(dataPath, 1, now)
+	require.NoError(t, err)
+	assert.Nil(t, window)
+}
+
+func TestParseUntil(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	require.NoError(t, err)
+
+	until, err := ParseUntil("2024-12-26", paris)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 12, 25, 23, 0, 0, 0, time.UTC), until.UTC())
+
+	until, err = ParseUntil("2024-12-26T06:00:00Z", paris)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 12, 26, 6, 0, 0, 0, time.UTC), until.UTC())
+
+	_, err = ParseUntil("after christmas", paris)
+	assert.Error(t, err)
+}
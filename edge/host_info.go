@@ -0,0 +1,42 @@
+package edge
+
+import (
+	"reflect"
+
+	"github.com/portainer/agent/crypto"
+
+	"github.com/rs/zerolog/log"
+)
+
+// reportHostInfo sends the information of the host to the server when it changed since the last report
+func (service *PollService) reportHostInfo() {
+	if service.hostInfo == nil {
+		return
+	}
+
+	info := service.hostInfo.Info()
+	info.FIPS = crypto.FIPS()
+	if service.edgeStackManager != nil {
+		info.Storage = service.edgeStackManager.StorageUsage()
+	}
+	if service.reportedHostInfo != nil && reflect.DeepEqual(info, *service.reportedHostInfo) {
+		return
+	}
+
+	if err := service.portainerClient.SetHostInfo(info); err != nil {
+		log.Error().Err(err).Msg("unable to report the information of the host")
+
+		return
+	}
+
+	log.Debug().Str("os", info.OS).Str("kernel", info.Kernel).Msg("host information reported")
+
+	service.reportedHostInfo = &info
+}
+
+// reportBoot confirms the reboot or the shutdown of the device requested before the agent restarted
+func (service *PollService) reportBoot() {
+	if service.power != nil {
+		service.power.ReportBoot()
+	}
+}
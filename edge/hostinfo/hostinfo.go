@@ -0,0 +1,352 @@
+package hostinfo
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/portainer/agent/edge/client"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// osReleasePath is the os-release file of the host, osReleaseFallbackPath is read when it is missing
+	osReleasePath         = "etc/os-release"
+	osReleaseFallbackPath = "usr/lib/os-release"
+	// kernelReleasePath is the release of the running kernel, shared by the host and the agent container
+	kernelReleasePath = "/proc/sys/kernel/osrelease"
+	// checkTimeout bounds the check of the pending updates, updateTimeout the updates
+	checkTimeout  = 10 * time.Minute
+	updateTimeout = time.Hour
+	// maxOutputSize is the size of the output of the updates kept, the end of the output is kept
+	maxOutputSize = 4096
+)
+
+var (
+	// ErrActionNotAllowed is returned for the update actions the device does not allow
+	ErrActionNotAllowed = errors.New("the package update action is not allowed on the device")
+	// ErrUpdateRunning is returned when an update is requested while another one is running
+	ErrUpdateRunning = errors.New("a package update is already running")
+	// ErrNoPackageManager is returned when no supported package manager is found on the host
+	ErrNoPackageManager = errors.New("no supported package manager found on the host")
+)
+
+// Collector collects the operating system and the container runtime of the host, and optionally its pending package
+// updates. It runs the package updates allowed on the device, with the package manager of the host.
+type Collector struct {
+	hostRoot       string
+	runtimes       func() (map[string]string, error)
+	checkUpdates   bool
+	allowedActions map[string]bool
+	run            runFunc
+	osInfo         client.HostInfo
+	pending        *client.PendingUpdates
+	lastUpdate     *client.HostUpdateStatus
+	mu             sync.Mutex
+}
+
+// NewCollector returns a pointer to a new instance of Collector for the host mounted at hostRoot, runtimes returns
+// the versions of the components of the container runtime and can be nil. The pending updates are only checked
+// when checkUpdates is true.
+func NewCollector(hostRoot string, runtimes func() (map[string]string, error), checkUpdates bool, allowedActions []string) (*Collector, error) {
+	allowed := make(map[string]bool)
+	for _, action := range allowedActions {
+		if !isAction(action) {
+			return nil, fmt.Errorf("unknown package update action %q, expected one of %s", action, strings.Join(Actions, ", "))
+		}
+
+		allowed[action] = true
+	}
+
+	collector := &Collector{
+		hostRoot:       hostRoot,
+		runtimes:       runtimes,
+		checkUpdates:   checkUpdates,
+		allowedActions: allowed,
+	}
+	collector.run = collector.runOnHost
+
+	return collector, nil
+}
+
+// Start collects the information of the host, then again at each interval
+func (collector *Collector) Start(interval time.Duration) {
+	go func() {
+		collector.Collect(context.Background())
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			collector.Collect(context.Background())
+		}
+	}()
+}
+
+// Info returns the information of the host last collected, along with the last package update
+func (collector *Collector) Info() client.HostInfo {
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+
+	info := collector.osInfo
+	info.Runtimes = maps.Clone(collector.osInfo.Runtimes)
+
+	if collector.pending != nil {
+		pending := *collector.pending
+		info.PendingUpdates = &pending
+	}
+
+	if collector.lastUpdate != nil {
+		lastUpdate := *collector.lastUpdate
+		info.LastUpdate = &lastUpdate
+	}
+
+	return info
+}
+
+// Collect collects the operating system and the container runtime of the host, and its pending updates when enabled
+func (collector *Collector) Collect(ctx context.Context) {
+	info := collector.collectOS()
+
+	if collector.runtimes != nil {
+		runtimes, err := collector.runtimes()
+		if err != nil {
+			log.Warn().Err(err).Msg("unable to retrieve the versions of the container runtime")
+		}
+
+		info.Runtimes = runtimes
+	}
+
+	var pending *client.PendingUpdates
+	if collector.checkUpdates {
+		pending = collector.collectPendingUpdates(ctx)
+	}
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+
+	collector.osInfo = info
+	if pending != nil || !collector.checkUpdates {
+		collector.pending = pending
+	}
+}
+
+func (collector *Collector) collectOS() client.HostInfo {
+	info := client.HostInfo{Architecture: runtime.GOARCH}
+
+	for _, path := range []string{osReleasePath, osReleaseFallbackPath} {
+		data, err := os.ReadFile(filepath.Join(collector.hostRoot, path))
+		if err != nil {
+			continue
+		}
+
+		release := parseOSRelease(data)
+		info.OS = release["PRETTY_NAME"]
+		info.Distribution = release["ID"]
+		info.Version = release["VERSION_ID"]
+
+		break
+	}
+
+	if data, err := os.ReadFile(kernelReleasePath); err == nil {
+		info.Kernel = strings.TrimSpace(string(data))
+	}
+
+	return info
+}
+
+// collectPendingUpdates returns nil when the pending updates cannot be checked
+func (collector *Collector) collectPendingUpdates(ctx context.Context) *client.PendingUpdates {
+	manager, ok := collector.packageManager()
+	if !ok {
+		log.Debug().Msg("no supported package manager found on the host, the pending updates are not checked")
+
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	packages, security, err := manager.pending(ctx, collector.run)
+	if err != nil {
+		log.Warn().Str("package_manager", manager.name).Err(err).Msg("unable to check the pending updates of the host")
+
+		return nil
+	}
+
+	return &client.PendingUpdates{
+		PackageManager: manager.name,
+		Packages:       packages,
+		Security:       security,
+		CheckedAt:      time.Now().Unix(),
+	}
+}
+
+// Update runs the package update action in the background, its status is part of the information of the host
+func (collector *Collector) Update(id int, action string) error {
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+
+	if collector.lastUpdate != nil && collector.lastUpdate.Status == client.HostUpdateRunning {
+		return ErrUpdateRunning
+	}
+
+	status := &client.HostUpdateStatus{
+		ID:     id,
+		Action: action,
+		Status: client.HostUpdateFailed,
+		Start:  time.Now().Unix(),
+	}
+	collector.lastUpdate = status
+
+	if !collector.allowedActions[action] {
+		status.End, status.Error = status.Start, ErrActionNotAllowed.Error()
+
+		return ErrActionNotAllowed
+	}
+
+	manager, ok := collector.packageManager()
+	if !ok {
+		status.End, status.Error = status.Start, ErrNoPackageManager.Error()
+
+		return ErrNoPackageManager
+	}
+
+	command, ok := manager.actions[action]
+	if !ok {
+		err := fmt.Errorf("the %s action is not supported by %s", action, manager.name)
+		status.End, status.Error = status.Start, err.Error()
+
+		return err
+	}
+
+	status.Status = client.HostUpdateRunning
+
+	go collector.runUpdate(id, manager, command)
+
+	return nil
+}
+
+func (collector *Collector) runUpdate(id int, manager packageManager, command []string) {
+	log.Info().Int("update_id", id).Str("package_manager", manager.name).Strs("command", command).Msg("updating the packages of the host")
+
+	ctx, cancel := context.WithTimeout(context.Background(), updateTimeout)
+	defer cancel()
+
+	output, err := collector.run(ctx, manager.env, true, command[0], command[1:]...)
+
+	collector.mu.Lock()
+
+	status := collector.lastUpdate
+	status.End = time.Now().Unix()
+	status.Output = tail(output)
+	status.Status = client.HostUpdateSucceeded
+
+	if err != nil {
+		status.Status, status.Error = client.HostUpdateFailed, err.Error()
+
+		log.Error().Int("update_id", id).Err(err).Msg("unable to update the packages of the host")
+	}
+
+	collector.mu.Unlock()
+
+	// The versions of the runtime and the pending updates change with the update
+	collector.Collect(context.Background())
+}
+
+// packageManager returns the first supported package manager found on the host
+func (collector *Collector) packageManager() (packageManager, bool) {
+	for _, manager := range packageManagers {
+		if _, err := os.Stat(filepath.Join(collector.hostRoot, manager.binary)); err == nil {
+			return manager, true
+		}
+	}
+
+	return packageManager{}, false
+}
+
+// runOnHost runs the command inside the root of the host when it is mounted in the agent container, directly when
+// the agent runs on the host
+func (collector *Collector) runOnHost(ctx context.Context, env []string, combined bool, name string, args ...string) ([]byte, error) {
+	var cmd *exec.Cmd
+	if root := filepath.Clean(collector.hostRoot); root != "/" {
+		cmd = exec.CommandContext(ctx, "chroot", append([]string{root, name}, args...)...)
+	} else {
+		cmd = exec.CommandContext(ctx, name, args...)
+	}
+
+	cmd.Env = append([]string{"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"}, env...)
+
+	if combined {
+		return cmd.CombinedOutput()
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return output, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return output, nil
+}
+
+// parseOSRelease parses the KEY=value lines of an os-release file, the values can be quoted
+func parseOSRelease(data []byte) map[string]string {
+	release := make(map[string]string)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else {
+			value = strings.Trim(value, `'"`)
+		}
+
+		release[key] = value
+	}
+
+	return release
+}
+
+func isAction(action string) bool {
+	for _, a := range Actions {
+		if a == action {
+			return true
+		}
+	}
+
+	return false
+}
+
+// tail returns the end of the output, at most maxOutputSize bytes
+func tail(output []byte) string {
+	if len(output) > maxOutputSize {
+		output = output[len(output)-maxOutputSize:]
+	}
+
+	return string(output)
+}
@@ -0,0 +1,149 @@
+package hostinfo
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/portainer/agent/edge/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const ubuntuRelease = `PRETTY_NAME="Ubuntu 22.04.4 LTS"
+NAME="Ubuntu"
+VERSION_ID="22.04"
+ID=ubuntu
+# comment
+`
+
+// newTestHost returns the root of a host with an os-release file and the apt package manager
+func newTestHost(t *testing.T) string {
+	hostRoot := t.TempDir()
+
+	require.NoError(t, os.MkdirAll(filepath.Join(hostRoot, "etc"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(hostRoot, osReleasePath), []byte(ubuntuRelease), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(hostRoot, "usr/bin"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(hostRoot, "usr/bin/apt-get"), nil, 0755))
+
+	return hostRoot
+}
+
+func TestParseOSRelease(t *testing.T) {
+	release := parseOSRelease([]byte(ubuntuRelease + "VARIANT='Server Edition'\n"))
+
+	assert.Equal(t, map[string]string{
+		"PRETTY_NAME": "Ubuntu 22.04.4 LTS",
+		"NAME":        "Ubuntu",
+		"VERSION_ID":  "22.04",
+		"ID":          "ubuntu",
+		"VARIANT":     "Server Edition",
+	}, release)
+}
+
+func TestCollector_Collect(t *testing.T) {
+	hostRoot := newTestHost(t)
+
+	runtimes := func() (map[string]string, error) {
+		return map[string]string{"Engine": "26.1.5", "containerd": "1.6.33"}, nil
+	}
+
+	collector, err := NewCollector(hostRoot, runtimes, true, nil)
+	require.NoError(t, err)
+
+	collector.run = func(ctx context.Context, env []string, combined bool, name string, args ...string) ([]byte, error) {
+		assert.Equal(t, "apt-get", name)
+
+		return []byte(strings.Join([]string{
+			"Reading package lists...",
+			"Inst libc6 [2.35-0ubuntu3.1] (2.35-0ubuntu3.4 Ubuntu:22.04/jammy-updates, Ubuntu:22.04/jammy-security [amd64])",
+			"Inst curl [7.81.0-1ubuntu1.14] (7.81.0-1ubuntu1.15 Ubuntu:22.04/jammy-updates [amd64])",
+			"Conf libc6 (2.35-0ubuntu3.4 Ubuntu:22.04/jammy-updates, Ubuntu:22.04/jammy-security [amd64])",
+		}, "\n")), nil
+	}
+
+	collector.Collect(context.Background())
+
+	info := collector.Info()
+	assert.Equal(t, "Ubuntu 22.04.4 LTS", info.OS)
+	assert.Equal(t, "ubuntu", info.Distribution)
+	assert.Equal(t, "22.04", info.Version)
+	assert.Equal(t, map[string]string{"Engine": "26.1.5", "containerd": "1.6.33"}, info.Runtimes)
+
+	require.NotNil(t, info.PendingUpdates)
+	assert.Equal(t, "apt", info.PendingUpdates.PackageManager)
+	assert.Equal(t, 2, info.PendingUpdates.Packages)
+	require.NotNil(t, info.PendingUpdates.Security)
+	assert.Equal(t, 1, *info.PendingUpdates.Security)
+
+	// A failed check keeps the previous pending updates
+	collector.run = func(ctx context.Context, env []string, combined bool, name string, args ...string) ([]byte, error) {
+		return nil, errors.New("could not get lock")
+	}
+
+	collector.Collect(context.Background())
+	assert.Equal(t, info.PendingUpdates, collector.Info().PendingUpdates)
+}
+
+func TestCollector_Update(t *testing.T) {
+	hostRoot := newTestHost(t)
+
+	_, err := NewCollector(hostRoot, nil, false, []string{"dist-upgrade"})
+	assert.Error(t, err)
+
+	collector, err := NewCollector(hostRoot, nil, false, []string{ActionSecurity})
+	require.NoError(t, err)
+
+	release := make(chan struct{})
+
+	var commands []string
+	collector.run = func(ctx context.Context, env []string, combined bool, name string, args ...string) ([]byte, error) {
+		commands = append(commands, strings.Join(append([]string{name}, args...), " "))
+		<-release
+
+		return []byte("Packages upgraded: libc6\n"), nil
+	}
+
+	assert.ErrorIs(t, collector.Update(1, ActionUpgrade), ErrActionNotAllowed)
+	assert.Equal(t, client.HostUpdateFailed, collector.Info().LastUpdate.Status)
+
+	require.NoError(t, collector.Update(2, ActionSecurity))
+	assert.Equal(t, client.HostUpdateRunning, collector.Info().LastUpdate.Status)
+	assert.ErrorIs(t, collector.Update(3, ActionSecurity), ErrUpdateRunning)
+
+	close(release)
+
+	require.Eventually(t, func() bool {
+		return collector.Info().LastUpdate.Status != client.HostUpdateRunning
+	}, 5*time.Second, 10*time.Millisecond)
+
+	lastUpdate := collector.Info().LastUpdate
+	assert.Equal(t, 2, lastUpdate.ID)
+	assert.Equal(t, client.HostUpdateSucceeded, lastUpdate.Status)
+	assert.Equal(t, "Packages upgraded: libc6\n", lastUpdate.Output)
+	assert.Equal(t, []string{"unattended-upgrade -v"}, commands)
+}
+
+func TestParseDnf(t *testing.T) {
+	checkUpdate := strings.Join([]string{
+		"",
+		"kernel.x86_64                 5.14.0-427.el9        baseos",
+		"a-very-long-package-name-that-wraps.noarch",
+		"                              1.2-3.el9             appstream",
+		"openssl.x86_64                1:3.0.7-27.el9        baseos",
+		"Obsoleting Packages",
+		"grub2-tools.x86_64            1:2.06-77.el9         baseos",
+	}, "\n")
+	assert.Equal(t, 2, parseDnfCheckUpdate(checkUpdate))
+
+	updateInfo := strings.Join([]string{
+		"RHSA-2024:1234 Important/Sec. openssl-1:3.0.7-27.el9.x86_64",
+		"RHSA-2024:1250 Moderate/Sec.  openssl-1:3.0.7-27.el9.x86_64",
+		"RHSA-2024:1300 Important/Sec. kernel-5.14.0-427.el9.x86_64",
+	}, "\n")
+	assert.Equal(t, 2, parseDnfUpdateInfo(updateInfo))
+}
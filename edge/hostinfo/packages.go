@@ -0,0 +1,179 @@
+package hostinfo
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+)
+
+// The package update actions the Portainer server can trigger, when allowed on the device
+const (
+	// ActionRefresh refreshes the package lists, so that the pending updates are up to date
+	ActionRefresh = "refresh"
+	// ActionUpgrade installs all the pending updates
+	ActionUpgrade = "upgrade"
+	// ActionSecurity installs the pending security updates only
+	ActionSecurity = "security"
+)
+
+// Actions are the package update actions supported
+var Actions = []string{ActionRefresh, ActionUpgrade, ActionSecurity}
+
+// runFunc runs a command of the host and returns its standard output, or its combined output when combined is true
+type runFunc func(ctx context.Context, env []string, combined bool, name string, args ...string) ([]byte, error)
+
+// packageManager runs the package manager of a distribution
+type packageManager struct {
+	name string
+	// binary is looked up on the host to detect the package manager
+	binary  string
+	env     []string
+	pending func(ctx context.Context, run runFunc) (packages int, security *int, err error)
+	// actions maps the update actions to the commands running them, the actions missing are not supported
+	actions map[string][]string
+}
+
+var packageManagers = []packageManager{
+	{
+		name:    "apt",
+		binary:  "/usr/bin/apt-get",
+		env:     []string{"DEBIAN_FRONTEND=noninteractive", "LC_ALL=C"},
+		pending: aptPending,
+		actions: map[string][]string{
+			ActionRefresh:  {"apt-get", "update"},
+			ActionUpgrade:  {"apt-get", "-y", "upgrade"},
+			ActionSecurity: {"unattended-upgrade", "-v"},
+		},
+	},
+	dnfManager("dnf", "/usr/bin/dnf"),
+	dnfManager("yum", "/usr/bin/yum"),
+	{
+		name:    "apk",
+		binary:  "/sbin/apk",
+		pending: apkPending,
+		actions: map[string][]string{
+			ActionRefresh: {"apk", "update"},
+			ActionUpgrade: {"apk", "upgrade"},
+		},
+	},
+}
+
+func dnfManager(name, binary string) packageManager {
+	return packageManager{
+		name:   name,
+		binary: binary,
+		env:    []string{"LC_ALL=C"},
+		pending: func(ctx context.Context, run runFunc) (int, *int, error) {
+			return dnfPending(ctx, run, name)
+		},
+		actions: map[string][]string{
+			ActionRefresh:  {name, "makecache"},
+			ActionUpgrade:  {name, "-y", "upgrade"},
+			ActionSecurity: {name, "-y", "upgrade", "--security"},
+		},
+	}
+}
+
+// aptPending simulates an upgrade, the security updates come from the security pockets of the distribution
+func aptPending(ctx context.Context, run runFunc) (int, *int, error) {
+	output, err := run(ctx, []string{"LC_ALL=C"}, false, "apt-get", "-s", "-o", "Debug::NoLocking=1", "upgrade")
+	if err != nil {
+		return 0, nil, err
+	}
+
+	packages, security := parseAptSimulation(string(output))
+
+	return packages, &security, nil
+}
+
+func parseAptSimulation(output string) (packages, security int) {
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "Inst ") {
+			continue
+		}
+
+		packages++
+
+		if strings.Contains(line, "-security") {
+			security++
+		}
+	}
+
+	return packages, security
+}
+
+// dnfPending lists the available updates then the security advisories, check-update exits with 100 when updates
+// are available
+func dnfPending(ctx context.Context, run runFunc, name string) (int, *int, error) {
+	output, err := run(ctx, nil, false, name, "-q", "check-update")
+
+	var exitErr *exec.ExitError
+	if err != nil && !(errors.As(err, &exitErr) && exitErr.ExitCode() == 100) {
+		return 0, nil, err
+	}
+
+	packages := parseDnfCheckUpdate(string(output))
+
+	output, err = run(ctx, nil, false, name, "-q", "updateinfo", "list", "--security", "--available")
+	if err != nil {
+		return 0, nil, err
+	}
+
+	security := parseDnfUpdateInfo(string(output))
+
+	return packages, &security, nil
+}
+
+func parseDnfCheckUpdate(output string) int {
+	packages := 0
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "Obsoleting") {
+			break
+		}
+
+		// The continuation lines of the long package names start with a space
+		if fields := strings.Fields(line); len(fields) == 3 && !strings.HasPrefix(line, " ") {
+			packages++
+		}
+	}
+
+	return packages
+}
+
+// parseDnfUpdateInfo counts the packages of the advisories, a package fixed by several advisories is counted once
+func parseDnfUpdateInfo(output string) int {
+	packages := make(map[string]bool)
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) >= 3 {
+			packages[fields[len(fields)-1]] = true
+		}
+	}
+
+	return len(packages)
+}
+
+// apkPending lists the upgradable packages, apk does not tell the security updates apart
+func apkPending(ctx context.Context, run runFunc) (int, *int, error) {
+	output, err := run(ctx, nil, false, "apk", "version", "-l", "<")
+	if err != nil {
+		return 0, nil, err
+	}
+
+	packages := 0
+	for _, line := range strings.Split(string(output), "\n") {
+		if strings.Contains(line, " < ") {
+			packages++
+		}
+	}
+
+	return packages, nil, nil
+}
@@ -0,0 +1,160 @@
+package imagepolicy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/portainer/agent/edge/oci"
+	"github.com/portainer/portainer/api/edge"
+)
+
+// ErrImageDenied is returned when an image is not allowed by the image policy of the device
+var ErrImageDenied = errors.New("image denied by the image policy")
+
+// Policy restricts the images the Edge stacks can run. It is configured on the device rather than sent by the
+// server, so that a compromised server cannot have arbitrary images run. e.g.
+//
+//	{
+//	  "allowedRegistries": ["registry.example.com", "docker.io"],
+//	  "allowedImages": ["registry.example.com/*", "docker.io/library/*"],
+//	  "deniedImages": ["*:latest"],
+//	  "requireSignature": true,
+//	  "publicKeyFile": "/etc/portainer/cosign.pub"
+//	}
+//
+// The denials take precedence over the allowances, the images without a tag are matched as :latest.
+type Policy struct {
+	// AllowedRegistries lists the registries the images can be pulled from, any registry when empty
+	AllowedRegistries []string `json:"allowedRegistries"`
+	DeniedRegistries  []string `json:"deniedRegistries"`
+	// AllowedImages and DeniedImages are patterns matched against the fully qualified name of the images, with and
+	// without their tag, e.g. docker.io/library/nginx:1.25. * matches any sequence of characters, / included.
+	// Any image is allowed when AllowedImages is empty.
+	AllowedImages []string `json:"allowedImages"`
+	DeniedImages  []string `json:"deniedImages"`
+	// RequireSignature requires the images to be signed with cosign by the key of PublicKeyFile
+	RequireSignature bool   `json:"requireSignature"`
+	PublicKeyFile    string `json:"publicKeyFile"`
+
+	publicKey       string
+	allowedImages   []*regexp.Regexp
+	deniedImages    []*regexp.Regexp
+	verifySignature func(ctx context.Context, ref, publicKey string, credentials *edge.RegistryCredentials) (string, error)
+}
+
+// Load reads the policy of a JSON file
+func Load(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &Policy{}
+	if err := json.Unmarshal(data, policy); err != nil {
+		return nil, fmt.Errorf("invalid image policy %s: %w", path, err)
+	}
+
+	if err := policy.compile(); err != nil {
+		return nil, fmt.Errorf("invalid image policy %s: %w", path, err)
+	}
+
+	return policy, nil
+}
+
+func (policy *Policy) compile() error {
+	for i, registry := range policy.AllowedRegistries {
+		policy.AllowedRegistries[i] = oci.NormalizeRegistry(registry)
+	}
+
+	for i, registry := range policy.DeniedRegistries {
+		policy.DeniedRegistries[i] = oci.NormalizeRegistry(registry)
+	}
+
+	policy.allowedImages = compilePatterns(policy.AllowedImages)
+	policy.deniedImages = compilePatterns(policy.DeniedImages)
+
+	if !policy.RequireSignature {
+		return nil
+	}
+
+	if policy.PublicKeyFile == "" {
+		return errors.New("a public key file is required to verify the signatures")
+	}
+
+	publicKey, err := os.ReadFile(policy.PublicKeyFile)
+	if err != nil {
+		return fmt.Errorf("unable to read the public key: %w", err)
+	}
+
+	policy.publicKey = string(publicKey)
+	policy.verifySignature = oci.NewClient().VerifyImageSignature
+
+	return nil
+}
+
+// compilePatterns turns the patterns into regular expressions, * being the only wildcard
+func compilePatterns(patterns []string) []*regexp.Regexp {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		expr := strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*")
+		compiled = append(compiled, regexp.MustCompile("^"+expr+"$"))
+	}
+
+	return compiled
+}
+
+func matchesAny(patterns []*regexp.Regexp, names ...string) bool {
+	for _, pattern := range patterns {
+		for _, name := range names {
+			if pattern.MatchString(name) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Evaluate returns an ErrImageDenied error when the image is not allowed by the policy. The signature of the image is
+// verified when the policy requires it, credentials authenticate the lookup of the signature and are optional.
+func (policy *Policy) Evaluate(ctx context.Context, image string, credentials *edge.RegistryCredentials) error {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return fmt.Errorf("%w: the image %s cannot be evaluated: %w", ErrImageDenied, image, err)
+	}
+
+	registry := reference.Domain(named)
+
+	if slices.Contains(policy.DeniedRegistries, registry) {
+		return fmt.Errorf("%w: the registry %s of %s is denied", ErrImageDenied, registry, image)
+	}
+
+	if len(policy.AllowedRegistries) > 0 && !slices.Contains(policy.AllowedRegistries, registry) {
+		return fmt.Errorf("%w: the registry %s of %s is not allowed", ErrImageDenied, registry, image)
+	}
+
+	names := []string{named.Name(), reference.TagNameOnly(named).String()}
+
+	if matchesAny(policy.deniedImages, names...) {
+		return fmt.Errorf("%w: %s is denied", ErrImageDenied, image)
+	}
+
+	if len(policy.allowedImages) > 0 && !matchesAny(policy.allowedImages, names...) {
+		return fmt.Errorf("%w: %s is not allowed", ErrImageDenied, image)
+	}
+
+	if policy.RequireSignature {
+		if _, err := policy.verifySignature(ctx, image, policy.publicKey, credentials); err != nil {
+			return fmt.Errorf("%w: the signature of %s cannot be verified: %w", ErrImageDenied, image, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package imagepolicy
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/portainer/portainer/api/edge"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writePolicy(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+
+	return path
+}
+
+func TestEvaluate(t *testing.T) {
+	policy, err := Load(writePolicy(t, `{
+		"allowedRegistries": ["https://registry.example.com", "index.docker.io"],
+		"deniedRegistries": ["quay.io"],
+		"allowedImages": ["registry.example.com/*", "docker.io/library/*"],
+		"deniedImages": ["*:latest", "docker.io/library/busybox"]
+	}`))
+	require.NoError(t, err)
+
+	for _, image := range []string{"nginx:1.25", "registry.example.com/team/web:1.0", "postgres@sha256:0123456789012345678901234567890123456789012345678901234567890123"} {
+		assert.NoError(t, policy.Evaluate(context.Background(), image, nil), image)
+	}
+
+	for _, image := range []string{"nginx", "nginx:latest", "busybox:1.36", "ghcr.io/org/app:1.0", "quay.io/org/app:1.0", "someone/nginx:1.25", "${REGISTRY}/app:1.0"} {
+		assert.ErrorIs(t, policy.Evaluate(context.Background(), image, nil), ErrImageDenied, image)
+	}
+}
+
+func TestEvaluate_signature(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "cosign.pub")
+	require.NoError(t, os.WriteFile(keyFile, []byte("public key"), 0600))
+
+	_, err := Load(writePolicy(t, `{"requireSignature": true}`))
+	assert.Error(t, err)
+
+	policy, err := Load(writePolicy(t, `{"requireSignature": true, "publicKeyFile": "`+keyFile+`"}`))
+	require.NoError(t, err)
+
+	credentials := &edge.RegistryCredentials{ServerURL: "registry.example.com", Username: "deploy", Secret: "s3cret"}
+
+	policy.verifySignature = func(ctx context.Context, ref, publicKey string, creds *edge.RegistryCredentials) (string, error) {
+		assert.Equal(t, "public key", publicKey)
+		assert.Equal(t, credentials, creds)
+
+		if ref == "registry.example.com/team/unsigned:1.0" {
+			return "", errors.New("no signature found")
+		}
+
+		return "sha256:0123", nil
+	}
+
+	assert.NoError(t, policy.Evaluate(context.Background(), "registry.example.com/team/web:1.0", credentials))
+	assert.ErrorIs(t, policy.Evaluate(context.Background(), "registry.example.com/team/unsigned:1.0", credentials), ErrImageDenied)
+}
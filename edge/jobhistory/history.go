@@ -0,0 +1,122 @@
+package jobhistory
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/portainer/agent"
+	agentfs "github.com/portainer/agent/filesystem"
+)
+
+// fileName is the file of the data folder the history is kept in, so that it can be read by the local CLI
+const fileName = "job_history.json"
+
+// MaxOutputSize is the size of the output kept for each execution, the end of the output is kept
+const MaxOutputSize = 4096
+
+// Store keeps the last executions of each Edge job in the data folder
+type Store struct {
+	dataPath string
+	count    int
+	mu       sync.Mutex
+}
+
+// NewStore returns a pointer to a new instance of Store keeping count executions of each job
+func NewStore(dataPath string, count int) *Store {
+	return &Store{dataPath: dataPath, count: count}
+}
+
+// Add records the executions, only the count most recent executions of each job are kept
+func (store *Store) Add(executions ...agent.EdgeJobExecution) error {
+	if len(executions) == 0 {
+		return nil
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	history, err := read(store.dataPath)
+	if err != nil {
+		return err
+	}
+
+	for _, execution := range executions {
+		jobExecutions := append(history[execution.JobID], execution)
+
+		sort.SliceStable(jobExecutions, func(i, j int) bool {
+			return jobExecutions[i].Start.Before(jobExecutions[j].Start)
+		})
+
+		if len(jobExecutions) > store.count {
+			jobExecutions = jobExecutions[len(jobExecutions)-store.count:]
+		}
+
+		history[execution.JobID] = jobExecutions
+	}
+
+	data, err := json.Marshal(history)
+	if err != nil {
+		return err
+	}
+
+	return agentfs.WriteFile(store.dataPath, fileName, data, 0600)
+}
+
+// List returns the executions of the job, of all the jobs when jobID is 0
+func (store *Store) List(jobID int) ([]agent.EdgeJobExecution, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	return List(store.dataPath, jobID)
+}
+
+// List returns the executions of the job kept in the data folder, of all the jobs when jobID is 0, ordered by start time
+func List(dataPath string, jobID int) ([]agent.EdgeJobExecution, error) {
+	history, err := read(dataPath)
+	if err != nil {
+		return nil, err
+	}
+
+	executions := []agent.EdgeJobExecution{}
+	for id, jobExecutions := range history {
+		if jobID == 0 || id == jobID {
+			executions = append(executions, jobExecutions...)
+		}
+	}
+
+	sort.SliceStable(executions, func(i, j int) bool {
+		if executions[i].Start.Equal(executions[j].Start) {
+			return executions[i].JobID < executions[j].JobID
+		}
+
+		return executions[i].Start.Before(executions[j].Start)
+	})
+
+	return executions, nil
+}
+
+// TruncateOutput returns the end of the output, at most MaxOutputSize bytes, and whether its beginning was left out
+func TruncateOutput(output []byte) (string, bool) {
+	if len(output) <= MaxOutputSize {
+		return string(output), false
+	}
+
+	return string(output[len(output)-MaxOutputSize:]), true
+}
+
+func read(dataPath string) (map[int][]agent.EdgeJobExecution, error) {
+	history := make(map[int][]agent.EdgeJobExecution)
+
+	data, err := os.ReadFile(filepath.Join(dataPath, fileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return history, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return history, json.Unmarshal(data, &history)
+}
@@ -0,0 +1,47 @@
+package jobhistory
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/portainer/agent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	dataPath := t.TempDir()
+	store := NewStore(dataPath, 2)
+
+	execution := func(jobID int, start int64, exitCode int) agent.EdgeJobExecution {
+		return agent.EdgeJobExecution{JobID: jobID, Start: time.Unix(start, 0).UTC(), End: time.Unix(start+1, 0).UTC(), Duration: time.Second, ExitCode: exitCode}
+	}
+
+	executions, err := store.List(0)
+	require.NoError(t, err)
+	assert.Empty(t, executions)
+
+	require.NoError(t, store.Add(execution(1, 100, 0), execution(2, 150, 0)))
+	require.NoError(t, store.Add(execution(1, 300, 1), execution(1, 200, 0)))
+
+	// Only the most recent executions of each job are kept
+	executions, err = store.List(1)
+	require.NoError(t, err)
+	assert.Equal(t, []agent.EdgeJobExecution{execution(1, 200, 0), execution(1, 300, 1)}, executions)
+
+	executions, err = List(dataPath, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []agent.EdgeJobExecution{execution(2, 150, 0), execution(1, 200, 0), execution(1, 300, 1)}, executions)
+}
+
+func TestTruncateOutput(t *testing.T) {
+	output, truncated := TruncateOutput([]byte("done"))
+	assert.Equal(t, "done", output)
+	assert.False(t, truncated)
+
+	output, truncated = TruncateOutput([]byte(strings.Repeat("a", MaxOutputSize) + "end"))
+	assert.Len(t, output, MaxOutputSize)
+	assert.True(t, strings.HasSuffix(output, "aend"))
+	assert.True(t, truncated)
+}
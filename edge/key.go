@@ -48,6 +48,7 @@ func (manager *Manager) SetKey(key string) error {
 	}
 
 	manager.key = edgeKey
+	manager.rawKey = key
 
 	if manager.clusterService != nil {
 		tags := manager.clusterService.GetRuntimeConfiguration()
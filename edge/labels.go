@@ -0,0 +1,29 @@
+package edge
+
+import (
+	"maps"
+
+	"github.com/rs/zerolog/log"
+)
+
+// reportLabels sends the labels of the device to the server when they changed since the last report
+func (service *PollService) reportLabels() {
+	if service.labelService == nil {
+		return
+	}
+
+	labels := service.labelService.Labels()
+	if service.reportedLabels != nil && maps.Equal(labels, service.reportedLabels) {
+		return
+	}
+
+	if err := service.portainerClient.SetLabels(labels); err != nil {
+		log.Error().Err(err).Msg("unable to report the labels of the device")
+
+		return
+	}
+
+	log.Debug().Int("label_count", len(labels)).Msg("labels reported")
+
+	service.reportedLabels = labels
+}
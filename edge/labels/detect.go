@@ -0,0 +1,133 @@
+package labels
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	dmiPath       = "sys/class/dmi/id"
+	cloudInitPath = "run/cloud-init/instance-data.json"
+)
+
+// dmiAssetTags maps the DMI files read to the labels they are reported as
+var dmiAssetTags = map[string]string{
+	"chassis_asset_tag": "dmi.chassis-asset-tag",
+	"board_asset_tag":   "dmi.board-asset-tag",
+	"product_serial":    "dmi.serial",
+}
+
+// dmiPlaceholders are the values left by the manufacturers in the DMI fields they do not fill
+var dmiPlaceholders = []string{
+	"",
+	"default string",
+	"to be filled by o.e.m.",
+	"not specified",
+	"not applicable",
+	"none",
+	"0",
+}
+
+// dmiLabels returns the asset tags of the device read from the DMI tables of the host
+func dmiLabels(hostRoot string) map[string]string {
+	labels := make(map[string]string)
+
+	for file, key := range dmiAssetTags {
+		data, err := os.ReadFile(filepath.Join(hostRoot, dmiPath, file))
+		if err != nil {
+			continue
+		}
+
+		value := strings.TrimSpace(string(data))
+		if isDMIPlaceholder(value) {
+			continue
+		}
+
+		labels[key] = value
+	}
+
+	return labels
+}
+
+func isDMIPlaceholder(value string) bool {
+	for _, placeholder := range dmiPlaceholders {
+		if strings.EqualFold(value, placeholder) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cloudInitInstanceData is the part of the cloud-init instance data the labels are read from
+type cloudInitInstanceData struct {
+	V1 struct {
+		CloudName        string `json:"cloud_name"`
+		Region           string `json:"region"`
+		AvailabilityZone string `json:"availability_zone"`
+		InstanceID       string `json:"instance_id"`
+	} `json:"v1"`
+	DS struct {
+		MetaData struct {
+			Tags json.RawMessage `json:"tags"`
+		} `json:"meta_data"`
+	} `json:"ds"`
+}
+
+// cloudInitLabels returns the cloud metadata and instance tags of the device exposed by cloud-init
+func cloudInitLabels(hostRoot string) map[string]string {
+	labels := make(map[string]string)
+
+	data, err := os.ReadFile(filepath.Join(hostRoot, cloudInitPath))
+	if err != nil {
+		return labels
+	}
+
+	var instanceData cloudInitInstanceData
+	if err := json.Unmarshal(data, &instanceData); err != nil {
+		return labels
+	}
+
+	for key, value := range map[string]string{
+		"cloud.name":              instanceData.V1.CloudName,
+		"cloud.region":            instanceData.V1.Region,
+		"cloud.availability-zone": instanceData.V1.AvailabilityZone,
+		"cloud.instance-id":       instanceData.V1.InstanceID,
+	} {
+		if value != "" {
+			labels[key] = value
+		}
+	}
+
+	for key, value := range cloudInitTags(instanceData.DS.MetaData.Tags) {
+		if validateKey(key) == nil && value != "" {
+			labels["cloud.tag."+key] = value
+		}
+	}
+
+	return labels
+}
+
+// cloudInitTags reads the instance tags, which are either a map of tags or,
+// on AWS, nested under the instance key
+func cloudInitTags(raw json.RawMessage) map[string]string {
+	if len(raw) == 0 {
+		return nil
+	}
+
+	var tags map[string]string
+	if err := json.Unmarshal(raw, &tags); err == nil {
+		return tags
+	}
+
+	var nested struct {
+		Instance map[string]string `json:"instance"`
+	}
+	if err := json.Unmarshal(raw, &nested); err == nil {
+		return nested.Instance
+	}
+
+	return nil
+}
@@ -0,0 +1,192 @@
+package labels
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	agentfs "github.com/portainer/agent/filesystem"
+
+	"github.com/rs/zerolog/log"
+)
+
+// overridesFileName is the file of the data folder the labels set at runtime are kept in
+const overridesFileName = "labels.json"
+
+// Service merges the labels of the device declared in a configuration file, detected from the DMI
+// asset tags and the cloud-init metadata, and set at runtime. The labels set at runtime take
+// precedence over the configuration file, which takes precedence over the detected labels.
+type Service struct {
+	overridesPath string
+	base          map[string]string
+	overrides     map[string]string
+	modTime       time.Time
+	mu            sync.Mutex
+}
+
+// NewService returns a pointer to a new instance of Service. The configuration file and the
+// detected labels are read once, the labels set at runtime are read again whenever they change.
+func NewService(dataPath, hostRoot, configPath string) *Service {
+	base := dmiLabels(hostRoot)
+	maps.Copy(base, cloudInitLabels(hostRoot))
+
+	if configPath != "" {
+		configured, err := readConfigFile(configPath)
+		if err != nil {
+			log.Warn().Err(err).Str("path", configPath).Msg("unable to read the labels configuration file")
+		}
+
+		maps.Copy(base, configured)
+	}
+
+	return &Service{
+		overridesPath: filepath.Join(dataPath, overridesFileName),
+		base:          base,
+	}
+}
+
+// Labels returns the labels of the device
+func (service *Service) Labels() map[string]string {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	service.reloadOverrides()
+
+	labels := maps.Clone(service.base)
+	maps.Copy(labels, service.overrides)
+
+	return labels
+}
+
+func (service *Service) reloadOverrides() {
+	info, err := os.Stat(service.overridesPath)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Warn().Err(err).Msg("unable to read the labels set at runtime")
+		}
+
+		service.overrides = nil
+		service.modTime = time.Time{}
+
+		return
+	}
+
+	if info.ModTime().Equal(service.modTime) {
+		return
+	}
+
+	overrides, err := readOverrides(service.overridesPath)
+	if err != nil {
+		log.Warn().Err(err).Msg("unable to read the labels set at runtime")
+
+		return
+	}
+
+	service.overrides = overrides
+	service.modTime = info.ModTime()
+}
+
+// UpdateOverrides sets the labels set at runtime kept in the data folder, a label set to
+// an empty value is removed. It is used by the local CLI while the agent is running.
+func UpdateOverrides(dataPath string, updates map[string]string) error {
+	path := filepath.Join(dataPath, overridesFileName)
+
+	overrides, err := readOverrides(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if overrides == nil {
+		overrides = make(map[string]string)
+	}
+
+	for key, value := range updates {
+		if err := validateKey(key); err != nil {
+			return err
+		}
+
+		if value == "" {
+			delete(overrides, key)
+
+			continue
+		}
+
+		overrides[key] = value
+	}
+
+	data, err := json.Marshal(overrides)
+	if err != nil {
+		return err
+	}
+
+	return agentfs.WriteFile(dataPath, overridesFileName, data, 0600)
+}
+
+// ParseLabel parses a label in the key=value format
+func ParseLabel(label string) (string, string, error) {
+	key, value, ok := strings.Cut(label, "=")
+	if !ok {
+		return "", "", fmt.Errorf("invalid label %q, expected key=value", label)
+	}
+
+	key = strings.TrimSpace(key)
+
+	return key, strings.TrimSpace(value), validateKey(key)
+}
+
+func validateKey(key string) error {
+	if key == "" || strings.ContainsAny(key, " \t=") {
+		return fmt.Errorf("invalid label key %q", key)
+	}
+
+	return nil
+}
+
+func readOverrides(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var overrides map[string]string
+
+	return overrides, json.Unmarshal(data, &overrides)
+}
+
+// readConfigFile reads a labels configuration file made of key=value lines,
+// empty lines and lines starting with # are ignored
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := make(map[string]string)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, err := ParseLabel(line)
+		if err != nil {
+			return nil, err
+		}
+
+		if value != "" {
+			labels[key] = value
+		}
+	}
+
+	return labels, scanner.Err()
+}
@@ -0,0 +1,75 @@
+package labels
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+}
+
+func TestService_Labels(t *testing.T) {
+	hostRoot := t.TempDir()
+	dataPath := t.TempDir()
+	configPath := filepath.Join(t.TempDir(), "labels.conf")
+
+	writeFile(t, filepath.Join(hostRoot, dmiPath, "chassis_asset_tag"), "ASSET-42\n")
+	writeFile(t, filepath.Join(hostRoot, dmiPath, "board_asset_tag"), "Default string\n")
+	writeFile(t, filepath.Join(hostRoot, cloudInitPath), `{
+		"v1": {"cloud_name": "aws", "region": "eu-west-1"},
+		"ds": {"meta_data": {"tags": {"instance": {"site": "paris", "Name": "gw-1"}}}}
+	}`)
+	writeFile(t, configPath, "# site labels\nsite = lyon\nrole=gateway\n\n")
+
+	service := NewService(dataPath, hostRoot, configPath)
+
+	assert.Equal(t, map[string]string{
+		"dmi.chassis-asset-tag": "ASSET-42",
+		"cloud.name":            "aws",
+		"cloud.region":          "eu-west-1",
+		"cloud.tag.site":        "paris",
+		"cloud.tag.Name":        "gw-1",
+		"site":                  "lyon",
+		"role":                  "gateway",
+	}, service.Labels())
+
+	// The labels set at runtime override the configured ones
+	require.NoError(t, UpdateOverrides(dataPath, map[string]string{"site": "nantes", "role": ""}))
+	require.NoError(t, os.Chtimes(filepath.Join(dataPath, overridesFileName), time.Now(), time.Now().Add(time.Second)))
+
+	labels := service.Labels()
+	assert.Equal(t, "nantes", labels["site"])
+	assert.Equal(t, "gateway", labels["role"])
+
+	require.NoError(t, UpdateOverrides(dataPath, map[string]string{"site": ""}))
+	require.NoError(t, os.Chtimes(filepath.Join(dataPath, overridesFileName), time.Now(), time.Now().Add(2*time.Second)))
+
+	assert.Equal(t, "lyon", service.Labels()["site"])
+}
+
+func TestParseLabel(t *testing.T) {
+	key, value, err := ParseLabel("site=paris")
+	require.NoError(t, err)
+	assert.Equal(t, "site", key)
+	assert.Equal(t, "paris", value)
+
+	key, value, err = ParseLabel("site=")
+	require.NoError(t, err)
+	assert.Equal(t, "site", key)
+	assert.Empty(t, value)
+
+	_, _, err = ParseLabel("site")
+	assert.Error(t, err)
+
+	_, _, err = ParseLabel("=paris")
+	assert.Error(t, err)
+}
@@ -0,0 +1,113 @@
+package notify
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// A minimal MQTT 3.1.1 client, only supporting QoS 0 publishing which is enough
+// for fire-and-forget notifications to a local broker
+
+const (
+	mqttPacketConnect    = 0x10
+	mqttPacketConnAck    = 0x20
+	mqttPacketPublish    = 0x30
+	mqttPacketDisconnect = 0xe0
+
+	mqttKeepAliveSeconds = 30
+)
+
+func publishMQTT(addr, topic string, payload []byte, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+
+	clientID := "portainer-agent-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	if _, err := conn.Write(mqttConnectPacket(clientID)); err != nil {
+		return err
+	}
+
+	ack := make([]byte, 4)
+	if _, err := io.ReadFull(conn, ack); err != nil {
+		return err
+	}
+
+	if ack[0] != mqttPacketConnAck {
+		return errors.New("unexpected MQTT packet received instead of CONNACK")
+	}
+
+	if ack[3] != 0 {
+		return fmt.Errorf("MQTT connection refused with return code %d", ack[3])
+	}
+
+	if _, err := conn.Write(mqttPublishPacket(topic, payload)); err != nil {
+		return err
+	}
+
+	_, err = conn.Write([]byte{mqttPacketDisconnect, 0})
+
+	return err
+}
+
+func mqttConnectPacket(clientID string) []byte {
+	var body bytes.Buffer
+
+	writeMQTTString(&body, "MQTT")
+	body.WriteByte(4)    // protocol level 3.1.1
+	body.WriteByte(0x02) // clean session
+	body.Write([]byte{byte(mqttKeepAliveSeconds >> 8), byte(mqttKeepAliveSeconds & 0xff)})
+	writeMQTTString(&body, clientID)
+
+	return mqttPacket(mqttPacketConnect, body.Bytes())
+}
+
+func mqttPublishPacket(topic string, payload []byte) []byte {
+	var body bytes.Buffer
+
+	writeMQTTString(&body, topic)
+	body.Write(payload)
+
+	return mqttPacket(mqttPacketPublish, body.Bytes())
+}
+
+func mqttPacket(header byte, body []byte) []byte {
+	var packet bytes.Buffer
+
+	packet.WriteByte(header)
+
+	// variable length encoding of the remaining length
+	length := len(body)
+	for {
+		b := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			b |= 0x80
+		}
+
+		packet.WriteByte(b)
+
+		if length == 0 {
+			break
+		}
+	}
+
+	packet.Write(body)
+
+	return packet.Bytes()
+}
+
+func writeMQTTString(buf *bytes.Buffer, s string) {
+	buf.Write([]byte{byte(len(s) >> 8), byte(len(s) & 0xff)})
+	buf.WriteString(s)
+}
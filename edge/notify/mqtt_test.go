@@ -0,0 +1,55 @@
+package notify
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMQTTPacket_remainingLength(t *testing.T) {
+	packet := mqttPacket(mqttPacketPublish, make([]byte, 321))
+
+	// 321 = 65 + 2*128
+	assert.Equal(t, []byte{mqttPacketPublish, 0xc1, 0x02}, packet[:3])
+	assert.Len(t, packet, 3+321)
+}
+
+func TestPublishMQTT(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	received := make(chan []byte, 1)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+
+		if _, err := io.ReadFull(conn, make([]byte, header[1])); err != nil {
+			return
+		}
+
+		conn.Write([]byte{mqttPacketConnAck, 2, 0, 0})
+
+		data, _ := io.ReadAll(conn)
+		received <- data
+	}()
+
+	err = publishMQTT(listener.Addr().String(), "a/b", []byte("hello"), time.Second)
+	require.NoError(t, err)
+
+	expected := append(mqttPublishPacket("a/b", []byte("hello")), mqttPacketDisconnect, 0)
+	assert.Equal(t, expected, <-received)
+}
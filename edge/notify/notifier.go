@@ -0,0 +1,201 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/portainer/agent/edge/stack"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	eventQueueSize = 100
+	outputTimeout  = 10 * time.Second
+)
+
+// Config holds the local outputs stack lifecycle events are sent to, an empty value disables the output
+type Config struct {
+	// MQTTAddr is the address of the MQTT broker, in the host:port format
+	MQTTAddr string
+	// MQTTTopic is the topic events are published to
+	MQTTTopic string
+	// WebhookURL is the URL events are POSTed to as JSON
+	WebhookURL string
+	// ExecPath is the path of a script called for each event
+	ExecPath string
+}
+
+// Enabled returns true when at least one output is configured
+func (c Config) Enabled() bool {
+	return c.MQTTAddr != "" || c.WebhookURL != "" || c.ExecPath != ""
+}
+
+// Event is the payload sent to the configured outputs
+type Event struct {
+	Type      string    `json:"type"`
+	StackID   int       `json:"stackId"`
+	Name      string    `json:"name"`
+	Version   int       `json:"version"`
+	Namespace string    `json:"namespace,omitempty"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	Time      time.Time `json:"time"`
+	// PreviousStatus and Message are only set for the status changes
+	PreviousStatus string `json:"previousStatus,omitempty"`
+	Message        string `json:"message,omitempty"`
+}
+
+// Notifier publishes Edge stack lifecycle events to local outputs so that
+// on-site systems can react to deployments without going through Portainer
+type Notifier struct {
+	config     Config
+	events     chan Event
+	httpClient *http.Client
+}
+
+// NewNotifier returns a pointer to a new instance of Notifier
+func NewNotifier(config Config) *Notifier {
+	return &Notifier{
+		config:     config,
+		events:     make(chan Event, eventQueueSize),
+		httpClient: &http.Client{Timeout: outputTimeout},
+	}
+}
+
+// Start subscribes the notifier to the stack lifecycle hooks and starts sending events
+func (n *Notifier) Start() {
+	stack.RegisterHooks(stack.Hooks{
+		OnAcknowledged: n.hook("acknowledged"),
+		OnBeforeDeploy: n.hook("deploying"),
+		OnDeployed:     n.hook("deployed"),
+		OnError:        n.hook("error"),
+		OnRemoved:      n.hook("removed"),
+	})
+
+	go func() {
+		for event := range n.events {
+			n.send(event)
+		}
+	}()
+}
+
+// Watch sends the status changes of the stacks as statusChanged events until the context is done
+func (n *Notifier) Watch(ctx context.Context, provider stack.StatusProvider) {
+	changes := provider.Subscribe(ctx)
+
+	go func() {
+		for change := range changes {
+			if change.Removed || change.From == change.Stack.Status {
+				continue
+			}
+
+			n.enqueue(Event{
+				Type:           "statusChanged",
+				StackID:        change.Stack.ID,
+				Name:           change.Stack.Name,
+				Version:        change.Stack.Version,
+				Status:         change.Stack.Status.String(),
+				Time:           change.Time,
+				PreviousStatus: change.From.String(),
+				Message:        change.Message,
+			})
+		}
+	}()
+}
+
+func (n *Notifier) hook(eventType string) func(event stack.StackEvent) {
+	return func(event stack.StackEvent) {
+		e := Event{
+			Type:      eventType,
+			StackID:   event.StackID,
+			Name:      event.Name,
+			Version:   event.Version,
+			Namespace: event.Namespace,
+			Status:    event.Status,
+			Error:     event.Error,
+			Time:      time.Now(),
+		}
+
+		n.enqueue(e)
+	}
+}
+
+// enqueue queues the event to send, the hooks are called by the stack manager and must never block it
+func (n *Notifier) enqueue(e Event) {
+	select {
+	case n.events <- e:
+	default:
+		log.Warn().Int("stack_identifier", e.StackID).Str("event", e.Type).Msg("notification queue is full, dropping event")
+	}
+}
+
+func (n *Notifier) send(event Event) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Error().Err(err).Msg("unable to encode the stack event")
+
+		return
+	}
+
+	if n.config.MQTTAddr != "" {
+		if err := publishMQTT(n.config.MQTTAddr, n.config.MQTTTopic, payload, outputTimeout); err != nil {
+			log.Error().Err(err).Str("address", n.config.MQTTAddr).Msg("unable to publish the stack event to MQTT")
+		}
+	}
+
+	if n.config.WebhookURL != "" {
+		if err := n.postWebhook(payload); err != nil {
+			log.Error().Err(err).Str("url", n.config.WebhookURL).Msg("unable to send the stack event to the webhook")
+		}
+	}
+
+	if n.config.ExecPath != "" {
+		if err := n.runExec(event, payload); err != nil {
+			log.Error().Err(err).Str("path", n.config.ExecPath).Msg("unable to run the stack event script")
+		}
+	}
+}
+
+func (n *Notifier) postWebhook(payload []byte) error {
+	resp, err := n.httpClient.Post(n.config.WebhookURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// runExec calls the script with the event as JSON on stdin and its main fields as environment variables
+func (n *Notifier) runExec(event Event, payload []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), outputTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, n.config.ExecPath)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Env = append(cmd.Environ(),
+		"EDGE_STACK_EVENT="+event.Type,
+		"EDGE_STACK_ID="+strconv.Itoa(event.StackID),
+		"EDGE_STACK_NAME="+event.Name,
+		"EDGE_STACK_VERSION="+strconv.Itoa(event.Version),
+		"EDGE_STACK_ERROR="+event.Error,
+	)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, output)
+	}
+
+	return nil
+}
@@ -0,0 +1,40 @@
+package notify
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/portainer/agent/edge/stack"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeStatusProvider struct {
+	changes chan stack.StackChange
+}
+
+func (p fakeStatusProvider) ListStacks() []stack.StackInfo { return nil }
+
+func (p fakeStatusProvider) GetStack(stackID int) (stack.StackInfo, bool) {
+	return stack.StackInfo{}, false
+}
+
+func (p fakeStatusProvider) Subscribe(ctx context.Context) <-chan stack.StackChange { return p.changes }
+
+func TestNotifier_Watch(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	provider := fakeStatusProvider{changes: make(chan stack.StackChange, 3)}
+	provider.changes <- stack.StackChange{Stack: stack.StackInfo{ID: 1, Name: "web", Version: 2, Status: stack.StatusPending}, Time: now}
+	// The changes keeping the status are not sent
+	provider.changes <- stack.StackChange{Stack: stack.StackInfo{ID: 1, Name: "web", Version: 3, Status: stack.StatusPending}, From: stack.StatusPending, Time: now}
+	provider.changes <- stack.StackChange{Stack: stack.StackInfo{ID: 1, Name: "web", Version: 3, Status: stack.StatusError}, From: stack.StatusPending, Time: now, Message: "failed to pull image"}
+	close(provider.changes)
+
+	n := NewNotifier(Config{})
+	n.Watch(context.Background(), provider)
+
+	assert.Equal(t, Event{Type: "statusChanged", StackID: 1, Name: "web", Version: 2, Status: "Pending", PreviousStatus: "None", Time: now}, <-n.events)
+	assert.Equal(t, Event{Type: "statusChanged", StackID: 1, Name: "web", Version: 3, Status: "Error", PreviousStatus: "Pending", Message: "failed to pull image", Time: now}, <-n.events)
+}
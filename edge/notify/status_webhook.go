@@ -0,0 +1,202 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/portainer/agent"
+	"github.com/portainer/agent/clock"
+	"github.com/portainer/agent/edge/stack"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// SignatureHeader contains the HMAC-SHA256 signature of the timestamp and body of the request
+	SignatureHeader = "X-Portainer-Signature"
+	// TimestampHeader contains the unix timestamp used to compute the signature
+	TimestampHeader = "X-Portainer-Timestamp"
+
+	signingKeyContext = "portainer-edge-status-webhook"
+)
+
+// StatusWebhookConfig holds the configuration of the status webhooks
+type StatusWebhookConfig struct {
+	URLs   []string
+	EdgeID string
+	// EdgeKey is used to derive the key signing the requests
+	EdgeKey string
+	// RateLimit is the minimum interval between two notifications for the same stack and status
+	RateLimit time.Duration
+}
+
+type statusWebhookPayload struct {
+	// Text makes the payload readable by Slack-compatible incoming webhooks
+	Text      string    `json:"text"`
+	EdgeID    string    `json:"edgeId"`
+	StackID   int       `json:"stackId"`
+	StackName string    `json:"stackName"`
+	Version   int       `json:"version"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// StatusWebhook notifies third-party systems when an Edge stack fails or completes.
+// Requests are signed with a key derived from the Edge key so receivers can
+// authenticate the agent.
+type StatusWebhook struct {
+	config     StatusWebhookConfig
+	signingKey []byte
+	httpClient *http.Client
+	events     chan statusWebhookPayload
+	clock      agent.Clock
+
+	lastSent map[string]time.Time
+	mu       sync.Mutex
+}
+
+// NewStatusWebhook returns a pointer to a new instance of StatusWebhook
+func NewStatusWebhook(config StatusWebhookConfig) *StatusWebhook {
+	return &StatusWebhook{
+		config:     config,
+		signingKey: DeriveSigningKey(config.EdgeKey),
+		httpClient: &http.Client{Timeout: outputTimeout},
+		events:     make(chan statusWebhookPayload, eventQueueSize),
+		clock:      clock.NewSystemClock(),
+		lastSent:   make(map[string]time.Time),
+	}
+}
+
+// DeriveSigningKey returns the key used to sign the status webhooks for the given Edge key
+func DeriveSigningKey(edgeKey string) []byte {
+	mac := hmac.New(sha256.New, []byte(edgeKey))
+	mac.Write([]byte(signingKeyContext))
+
+	return mac.Sum(nil)
+}
+
+// Sign returns the hex encoded signature of a status webhook request
+func Sign(signingKey []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, signingKey)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Start subscribes the webhook to the stack lifecycle hooks and starts sending notifications, the hooks cannot be
+// unsubscribed so it is called once
+func (w *StatusWebhook) Start() {
+	stack.RegisterHooks(stack.Hooks{
+		OnDeployed: func(event stack.StackEvent) {
+			if event.Status == stack.StatusCompleted.String() {
+				w.enqueue(event)
+			}
+		},
+		OnError: w.enqueue,
+	})
+
+	go func() {
+		for payload := range w.events {
+			w.send(payload)
+		}
+	}()
+}
+
+func (w *StatusWebhook) enqueue(event stack.StackEvent) {
+	now := w.clock.Now()
+	if !w.allow(event.StackID, event.Status, now) {
+		log.Debug().Int("stack_identifier", event.StackID).Str("status", event.Status).Msg("status webhook rate limited")
+
+		return
+	}
+
+	text := fmt.Sprintf("Edge stack %s (version %d) is now %s on %s", event.Name, event.Version, event.Status, w.config.EdgeID)
+	if event.Error != "" {
+		text += ": " + event.Error
+	}
+
+	payload := statusWebhookPayload{
+		Text:      text,
+		EdgeID:    w.config.EdgeID,
+		StackID:   event.StackID,
+		StackName: event.Name,
+		Version:   event.Version,
+		Status:    event.Status,
+		Error:     event.Error,
+		Time:      now,
+	}
+
+	select {
+	case w.events <- payload:
+	default:
+		log.Warn().Int("stack_identifier", event.StackID).Msg("status webhook queue is full, dropping notification")
+	}
+}
+
+// allow returns false if a notification was already sent for the same stack and status within the rate limit interval
+func (w *StatusWebhook) allow(stackID int, status string, now time.Time) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	key := strconv.Itoa(stackID) + "/" + status
+
+	if last, ok := w.lastSent[key]; ok && now.Sub(last) < w.config.RateLimit {
+		return false
+	}
+
+	w.lastSent[key] = now
+
+	return true
+}
+
+func (w *StatusWebhook) send(payload statusWebhookPayload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Error().Err(err).Msg("unable to encode the status webhook payload")
+
+		return
+	}
+
+	timestamp := strconv.FormatInt(payload.Time.Unix(), 10)
+	signature := Sign(w.signingKey, timestamp, body)
+
+	for _, url := range w.config.URLs {
+		if err := w.post(url, timestamp, signature, body); err != nil {
+			log.Error().Err(err).Str("url", url).Msg("unable to send the status webhook")
+		}
+	}
+}
+
+func (w *StatusWebhook) post(url, timestamp, signature string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, "sha256="+signature)
+
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package notify
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/portainer/agent/clock"
+	"github.com/portainer/agent/edge/stack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusWebhook_send(t *testing.T) {
+	signingKey := DeriveSigningKey("edge-key")
+
+	var verified bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		expected := "sha256=" + Sign(signingKey, r.Header.Get(TimestampHeader), body)
+		verified = r.Header.Get(SignatureHeader) == expected
+	}))
+	defer server.Close()
+
+	webhook := NewStatusWebhook(StatusWebhookConfig{
+		URLs:      []string{server.URL},
+		EdgeID:    "edge-id",
+		EdgeKey:   "edge-key",
+		RateLimit: time.Minute,
+	})
+
+	fakeClock := clock.NewFakeClock(time.Unix(1700000000, 0))
+	webhook.clock = fakeClock
+
+	event := stack.StackEvent{StackID: 1, Name: "my-stack", Status: "Error", Error: "deploy failed"}
+
+	webhook.enqueue(event)
+
+	payload := <-webhook.events
+	assert.Equal(t, fakeClock.Now(), payload.Time)
+
+	webhook.send(payload)
+	assert.True(t, verified)
+
+	// The notifications are rate limited with the clock of the webhook
+	fakeClock.Advance(30 * time.Second)
+	webhook.enqueue(event)
+	assert.Empty(t, webhook.events)
+
+	fakeClock.Advance(time.Minute)
+	webhook.enqueue(event)
+	assert.Len(t, webhook.events, 1)
+}
+
+func TestStatusWebhook_allow(t *testing.T) {
+	webhook := NewStatusWebhook(StatusWebhookConfig{RateLimit: time.Minute})
+	now := time.Now()
+
+	assert.True(t, webhook.allow(1, "Error", now))
+	assert.False(t, webhook.allow(1, "Error", now.Add(30*time.Second)))
+	assert.True(t, webhook.allow(1, "Completed", now.Add(30*time.Second)))
+	assert.True(t, webhook.allow(2, "Error", now.Add(30*time.Second)))
+	assert.True(t, webhook.allow(1, "Error", now.Add(2*time.Minute)))
+}
@@ -0,0 +1,88 @@
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/portainer/portainer/api/edge"
+)
+
+// cosignSignatureAnnotation holds the base64 encoded signature of the payload of a layer of a cosign signature manifest
+const cosignSignatureAnnotation = "dev.cosignproject.cosign/signature"
+
+// imageManifestTypes are the media types of the manifests an image reference can resolve to
+var imageManifestTypes = strings.Join([]string{
+	ocispec.MediaTypeImageIndex,
+	ocispec.MediaTypeImageManifest,
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}, ", ")
+
+// simpleSigning is the payload signed by cosign, only the digest of the signed manifest is checked
+type simpleSigning struct {
+	Critical struct {
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+	} `json:"critical"`
+}
+
+// VerifyImageSignature checks that the image is signed with cosign by the PEM encoded public key, the signatures being
+// looked up in the sha256-<digest>.sig tag of the repository of the image as pushed by cosign sign --key.
+// It returns the verified digest of the image, the signatures of the tags resolved to another digest are rejected.
+func (client *Client) VerifyImageSignature(ctx context.Context, ref, publicKey string, credentials *edge.RegistryCredentials) (string, error) {
+	repo, err := parseReference(ref, false)
+	if err != nil {
+		return "", err
+	}
+
+	session := &registrySession{client: client.httpClient, repo: repo, credentials: credentials}
+
+	digest := repo.digest
+	if digest == "" {
+		if _, digest, err = session.fetchNamedManifest(ctx, repo.tag, imageManifestTypes); err != nil {
+			return "", err
+		}
+	}
+
+	signatureTag := strings.Replace(digest, ":", "-", 1) + ".sig"
+
+	data, _, err := session.fetchNamedManifest(ctx, signatureTag, ocispec.MediaTypeImageManifest)
+	if err != nil {
+		return "", fmt.Errorf("%w: no signature found for %s: %w", ErrVerification, digest, err)
+	}
+
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return "", fmt.Errorf("%w: invalid signature manifest: %w", ErrVerification, err)
+	}
+
+	for _, layer := range manifest.Layers {
+		signature := layer.Annotations[cosignSignatureAnnotation]
+		if signature == "" || layer.Size > maxManifestSize {
+			continue
+		}
+
+		payload, err := session.fetchBlob(ctx, layer)
+		if err != nil {
+			return "", err
+		}
+
+		valid, err := verifyPayload(publicKey, signature, payload)
+		if err != nil {
+			return "", err
+		}
+
+		var signed simpleSigning
+		if !valid || json.Unmarshal(payload, &signed) != nil || signed.Critical.Image.DockerManifestDigest != digest {
+			continue
+		}
+
+		return digest, nil
+	}
+
+	return "", fmt.Errorf("%w: no valid signature of %s", ErrVerification, digest)
+}
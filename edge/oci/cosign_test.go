@@ -0,0 +1,84 @@
+package oci
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyImageSignature(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	require.NoError(t, err)
+
+	pemKey := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	imageManifest := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`)
+	imageDigest := sha256Digest(imageManifest)
+
+	payload := []byte(`{"critical":{"identity":{"docker-reference":"registry/app/web"},"image":{"docker-manifest-digest":"` + imageDigest + `"},"type":"cosign container image signature"}}`)
+
+	signatureManifest, err := json.Marshal(ocispec.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Layers: []ocispec.Descriptor{{
+			MediaType: "application/vnd.dev.cosign.simplesigning.v1+json",
+			Digest:    digest.Digest(sha256Digest(payload)),
+			Size:      int64(len(payload)),
+			Annotations: map[string]string{
+				cosignSignatureAnnotation: base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, payload)),
+			},
+		}},
+	})
+	require.NoError(t, err)
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/app/web/manifests/1.0":
+			assert.Contains(t, r.Header.Get("Accept"), ocispec.MediaTypeImageIndex)
+			_, _ = w.Write(imageManifest)
+		case "/v2/app/web/manifests/" + strings.Replace(imageDigest, ":", "-", 1) + ".sig":
+			_, _ = w.Write(signatureManifest)
+		case "/v2/app/web/blobs/" + sha256Digest(payload):
+			_, _ = w.Write(payload)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client := &Client{httpClient: server.Client()}
+	registry := strings.TrimPrefix(server.URL, "https://")
+
+	verified, err := client.VerifyImageSignature(context.Background(), registry+"/app/web:1.0", pemKey, nil)
+	require.NoError(t, err)
+	assert.Equal(t, imageDigest, verified)
+
+	// Another key
+	otherKey, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	der, err = x509.MarshalPKIXPublicKey(otherKey)
+	require.NoError(t, err)
+
+	_, err = client.VerifyImageSignature(context.Background(), registry+"/app/web:1.0", string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil)
+	assert.ErrorIs(t, err, ErrVerification)
+
+	// Unsigned image
+	_, err = client.VerifyImageSignature(context.Background(), registry+"/app/web@"+sha256Digest([]byte("other")), pemKey, nil)
+	assert.ErrorIs(t, err, ErrVerification)
+}
@@ -0,0 +1,447 @@
+package oci
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/portainer/agent/crypto"
+	"github.com/portainer/portainer/api/edge"
+	"github.com/portainer/portainer/api/filesystem"
+
+	"github.com/rs/zerolog/log"
+)
+
+// maxManifestSize is the maximum size of an artifact manifest
+const maxManifestSize = 4 << 20
+
+// maxArtifactSize is the maximum size of all the layers of an artifact
+const maxArtifactSize = 64 << 20
+
+// dockerHubRegistry is the host serving the docker.io images
+const dockerHubRegistry = "registry-1.docker.io"
+
+// ErrVerification is returned when the pulled artifact does not match its expected digest or signature
+var ErrVerification = errors.New("OCI artifact verification failed")
+
+// PullOptions describes the artifact to pull and how it is verified
+type PullOptions struct {
+	// Reference of the artifact, e.g. registry.example.com/stacks/web:1.0 or registry.example.com/stacks/web@sha256:...
+	Reference string
+	// Digest is the expected digest of the artifact manifest
+	Digest string
+	// PublicKey is the PEM encoded public key verifying Signature
+	PublicKey string
+	// Signature is the base64 encoded signature of the manifest digest
+	Signature string
+	// PlainHTTP pulls the artifact over HTTP instead of HTTPS
+	PlainHTTP bool
+	// Credentials used to authenticate against the registry, optional
+	Credentials *edge.RegistryCredentials
+}
+
+// Client pulls OCI artifacts from registries
+type Client struct {
+	httpClient *http.Client
+}
+
+// NewClient returns a pointer to a new instance of Client, verifying the registries with the TLS policy of the agent
+func NewClient() *Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = crypto.RegistryTLSConfiguration()
+
+	return &Client{
+		httpClient: &http.Client{Timeout: 5 * time.Minute, Transport: transport},
+	}
+}
+
+// RegistryDomain returns the registry of the artifact reference, docker.io when it has none
+func RegistryDomain(ref string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", fmt.Errorf("invalid artifact reference %q: %w", ref, err)
+	}
+
+	return reference.Domain(named), nil
+}
+
+// NormalizeRegistry returns the host of the registry, with its port, without scheme nor path.
+// The Docker Hub aliases are all normalized to docker.io.
+func NormalizeRegistry(registry string) string {
+	registry = strings.ToLower(registry)
+	registry = strings.TrimPrefix(registry, "https://")
+	registry = strings.TrimPrefix(registry, "http://")
+	registry, _, _ = strings.Cut(registry, "/")
+
+	switch registry {
+	case "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+		return "docker.io"
+	}
+
+	return registry
+}
+
+// Pull downloads the artifact, verifies it and returns its files. Each layer is a file named after its
+// org.opencontainers.image.title annotation, layers annotated with io.deis.oras.content.unpack are
+// tar archives of a folder, as pushed by ORAS.
+func (client *Client) Pull(ctx context.Context, options PullOptions) ([]filesystem.DirEntry, error) {
+	repo, err := parseReference(options.Reference, options.PlainHTTP)
+	if err != nil {
+		return nil, err
+	}
+
+	expectedDigest := options.Digest
+	if repo.digest != "" {
+		if expectedDigest != "" && expectedDigest != repo.digest {
+			return nil, fmt.Errorf("%w: the reference digest %s differs from the expected digest %s", ErrVerification, repo.digest, expectedDigest)
+		}
+
+		expectedDigest = repo.digest
+	}
+
+	if expectedDigest == "" && options.Signature == "" {
+		return nil, fmt.Errorf("%w: the artifact must be pinned by digest or signed", ErrVerification)
+	}
+
+	session := &registrySession{client: client.httpClient, repo: repo, credentials: options.Credentials}
+
+	manifestData, manifestDigest, err := session.fetchManifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if expectedDigest != "" && manifestDigest != expectedDigest {
+		return nil, fmt.Errorf("%w: manifest digest %s, expected %s", ErrVerification, manifestDigest, expectedDigest)
+	}
+
+	if options.Signature != "" {
+		if err := verifySignature(options.PublicKey, options.Signature, manifestDigest); err != nil {
+			return nil, err
+		}
+	}
+
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid artifact manifest: %w", err)
+	}
+
+	log.Debug().
+		Str("reference", options.Reference).
+		Str("digest", manifestDigest).
+		Int("layers", len(manifest.Layers)).
+		Msg("pulling OCI artifact")
+
+	var (
+		dirEntries []filesystem.DirEntry
+		totalSize  int64
+	)
+
+	for _, layer := range manifest.Layers {
+		totalSize += layer.Size
+		if layer.Size < 0 || totalSize > maxArtifactSize {
+			return nil, fmt.Errorf("the artifact exceeds the maximum size of %d bytes", maxArtifactSize)
+		}
+
+		data, err := session.fetchBlob(ctx, layer)
+		if err != nil {
+			return nil, err
+		}
+
+		entries, err := layerEntries(layer, data)
+		if err != nil {
+			return nil, err
+		}
+
+		dirEntries = append(dirEntries, entries...)
+	}
+
+	return dirEntries, nil
+}
+
+type repository struct {
+	scheme string
+	host   string
+	path   string
+	// tag or digest of the manifest
+	tag    string
+	digest string
+}
+
+func parseReference(ref string, plainHTTP bool) (*repository, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return nil, fmt.Errorf("invalid artifact reference %q: %w", ref, err)
+	}
+
+	repo := &repository{
+		scheme: "https",
+		host:   reference.Domain(named),
+		path:   reference.Path(named),
+		tag:    "latest",
+	}
+
+	if plainHTTP {
+		repo.scheme = "http"
+	}
+
+	if repo.host == "docker.io" {
+		repo.host = dockerHubRegistry
+	}
+
+	if tagged, ok := named.(reference.Tagged); ok {
+		repo.tag = tagged.Tag()
+	}
+
+	if digested, ok := named.(reference.Digested); ok {
+		repo.digest = digested.Digest().String()
+	}
+
+	return repo, nil
+}
+
+func (repo *repository) url(kind, name string) string {
+	return fmt.Sprintf("%s://%s/v2/%s/%s/%s", repo.scheme, repo.host, repo.path, kind, name)
+}
+
+// registrySession runs the requests against a repository, authenticating on the first challenge
+type registrySession struct {
+	client      *http.Client
+	repo        *repository
+	credentials *edge.RegistryCredentials
+	// authorization is the value of the Authorization header, set once the registry challenged the session
+	authorization string
+}
+
+func (session *registrySession) fetchManifest(ctx context.Context) ([]byte, string, error) {
+	name := session.repo.tag
+	if session.repo.digest != "" {
+		name = session.repo.digest
+	}
+
+	return session.fetchNamedManifest(ctx, name, ocispec.MediaTypeImageManifest)
+}
+
+// fetchNamedManifest fetches the manifest of a tag or a digest of the repository, accept lists the media types
+// of the manifests accepted, separated by commas
+func (session *registrySession) fetchNamedManifest(ctx context.Context, name, accept string) ([]byte, string, error) {
+	resp, err := session.get(ctx, session.repo.url("manifests", name), accept)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(data) > maxManifestSize {
+		return nil, "", fmt.Errorf("the artifact manifest exceeds the maximum size of %d bytes", maxManifestSize)
+	}
+
+	return data, sha256Digest(data), nil
+}
+
+func (session *registrySession) fetchBlob(ctx context.Context, descriptor ocispec.Descriptor) ([]byte, error) {
+	resp, err := session.get(ctx, session.repo.url("blobs", descriptor.Digest.String()), "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, descriptor.Size+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(data)) != descriptor.Size {
+		return nil, fmt.Errorf("%w: blob %s has an unexpected size", ErrVerification, descriptor.Digest)
+	}
+
+	if digest := sha256Digest(data); digest != descriptor.Digest.String() {
+		return nil, fmt.Errorf("%w: blob digest %s, expected %s", ErrVerification, digest, descriptor.Digest)
+	}
+
+	return data, nil
+}
+
+// get runs the request, authenticating and retrying once when the registry challenges it
+func (session *registrySession) get(ctx context.Context, u, accept string) (*http.Response, error) {
+	resp, err := session.do(ctx, u, accept)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized && session.authorization == "" {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+
+		if err := session.authenticate(ctx, challenge); err != nil {
+			return nil, err
+		}
+
+		if resp, err = session.do(ctx, u, accept); err != nil {
+			return nil, err
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+
+		return nil, fmt.Errorf("unable to fetch %s: unexpected status %s", u, resp.Status)
+	}
+
+	return resp, nil
+}
+
+func (session *registrySession) do(ctx context.Context, u, accept string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+
+	if session.authorization != "" {
+		req.Header.Set("Authorization", session.authorization)
+	}
+
+	return session.client.Do(req)
+}
+
+// authenticate answers the Basic or Bearer challenge of the registry
+func (session *registrySession) authenticate(ctx context.Context, challenge string) error {
+	scheme, params := parseChallenge(challenge)
+
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if session.credentials == nil {
+			return errors.New("the registry requires credentials")
+		}
+
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.SetBasicAuth(session.credentials.Username, session.credentials.Secret)
+		session.authorization = req.Header.Get("Authorization")
+
+		return nil
+	case "bearer":
+		token, err := session.fetchToken(ctx, params)
+		if err != nil {
+			return err
+		}
+
+		session.authorization = "Bearer " + token
+
+		return nil
+	}
+
+	return fmt.Errorf("unsupported registry authentication challenge: %q", challenge)
+}
+
+func (session *registrySession) fetchToken(ctx context.Context, params map[string]string) (string, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Host == "" {
+		return "", fmt.Errorf("invalid token realm %q", params["realm"])
+	}
+
+	query := realm.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+
+	scope := params["scope"]
+	if scope == "" {
+		scope = fmt.Sprintf("repository:%s:pull", session.repo.path)
+	}
+
+	query.Set("scope", scope)
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	if session.credentials != nil {
+		req.SetBasicAuth(session.credentials.Username, session.credentials.Secret)
+	}
+
+	resp, err := session.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unable to retrieve a registry token: unexpected status %s", resp.Status)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxManifestSize)).Decode(&token); err != nil {
+		return "", fmt.Errorf("invalid registry token response: %w", err)
+	}
+
+	if token.Token != "" {
+		return token.Token, nil
+	}
+
+	if token.AccessToken != "" {
+		return token.AccessToken, nil
+	}
+
+	return "", errors.New("the registry token response is empty")
+}
+
+// parseChallenge parses a WWW-Authenticate header such as
+// Bearer realm="https://auth.example.com/token",service="registry.example.com"
+func parseChallenge(challenge string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	params := make(map[string]string)
+
+	for rest != "" {
+		var pair string
+
+		rest = strings.TrimLeft(rest, " ,")
+		key, value, found := strings.Cut(rest, "=")
+		if !found {
+			break
+		}
+
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end == -1 {
+				break
+			}
+
+			pair, rest = value[1:end+1], value[end+2:]
+		} else {
+			pair, rest, _ = strings.Cut(value, ",")
+		}
+
+		params[strings.ToLower(strings.TrimSpace(key))] = pair
+	}
+
+	return scheme, params
+}
+
+func sha256Digest(data []byte) string {
+	h := sha256.Sum256(data)
+
+	return "sha256:" + hex.EncodeToString(h[:])
+}
@@ -0,0 +1,257 @@
+package oci
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/portainer/portainer/api/edge"
+	"github.com/portainer/portainer/api/filesystem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeRegistry struct {
+	*httptest.Server
+	manifest []byte
+	blobs    map[string][]byte
+}
+
+func newFakeRegistry(t *testing.T, layers map[string][]byte, annotations map[string]map[string]string) *fakeRegistry {
+	registry := &fakeRegistry{blobs: make(map[string][]byte)}
+
+	manifest := ocispec.Manifest{MediaType: ocispec.MediaTypeImageManifest}
+	for title, data := range layers {
+		d := sha256Digest(data)
+		registry.blobs[d] = data
+
+		layerAnnotations := map[string]string{ocispec.AnnotationTitle: title}
+		for k, v := range annotations[title] {
+			layerAnnotations[k] = v
+		}
+
+		manifest.Layers = append(manifest.Layers, ocispec.Descriptor{
+			MediaType:   "application/vnd.oci.image.layer.v1.tar",
+			Digest:      digest.Digest(d),
+			Size:        int64(len(data)),
+			Annotations: layerAnnotations,
+		})
+	}
+
+	var err error
+	registry.manifest, err = json.Marshal(manifest)
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		assert.Equal(t, "repository:stacks/web:pull", r.URL.Query().Get("scope"))
+
+		_, _ = w.Write([]byte(`{"token":"valid-token"}`))
+	})
+	mux.HandleFunc("/v2/stacks/web/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer valid-token" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="fake"`, registry.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/v2/stacks/web/manifests/"):
+			_, _ = w.Write(registry.manifest)
+		case strings.HasPrefix(r.URL.Path, "/v2/stacks/web/blobs/"):
+			data, ok := registry.blobs[strings.TrimPrefix(r.URL.Path, "/v2/stacks/web/blobs/")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+
+				return
+			}
+
+			_, _ = w.Write(data)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	registry.Server = httptest.NewServer(mux)
+	t.Cleanup(registry.Close)
+
+	return registry
+}
+
+func (registry *fakeRegistry) reference() string {
+	return strings.TrimPrefix(registry.URL, "http://") + "/stacks/web:1.0"
+}
+
+func tarGz(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "config/", Typeflag: tar.TypeDir, Mode: 0755}))
+
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+
+	return buf.Bytes()
+}
+
+func TestPull(t *testing.T) {
+	compose := []byte("services:\n  web:\n    image: nginx\n")
+	archive := tarGz(t, map[string]string{"config/nginx.conf": "server {}"})
+
+	registry := newFakeRegistry(t,
+		map[string][]byte{"docker-compose.yml": compose, "config": archive},
+		map[string]map[string]string{"config": {annotationUnpack: "true"}},
+	)
+
+	credentials := &edge.RegistryCredentials{Username: "user", Secret: "secret"}
+
+	dirEntries, err := NewClient().Pull(context.Background(), PullOptions{
+		Reference:   registry.reference(),
+		Digest:      sha256Digest(registry.manifest),
+		PlainHTTP:   true,
+		Credentials: credentials,
+	})
+	require.NoError(t, err)
+
+	files := make(map[string]string)
+	for _, entry := range dirEntries {
+		if entry.IsFile {
+			files[entry.Name] = entry.Content
+		}
+	}
+
+	assert.Equal(t, map[string]string{
+		"docker-compose.yml": string(compose),
+		"config/nginx.conf":  "server {}",
+	}, files)
+
+	// The digest must match
+	_, err = NewClient().Pull(context.Background(), PullOptions{
+		Reference:   registry.reference(),
+		Digest:      sha256Digest([]byte("other")),
+		PlainHTTP:   true,
+		Credentials: credentials,
+	})
+	assert.ErrorIs(t, err, ErrVerification)
+
+	// Unverifiable artifacts are rejected
+	_, err = NewClient().Pull(context.Background(), PullOptions{
+		Reference:   registry.reference(),
+		PlainHTTP:   true,
+		Credentials: credentials,
+	})
+	assert.ErrorIs(t, err, ErrVerification)
+
+	// The credentials are required
+	_, err = NewClient().Pull(context.Background(), PullOptions{
+		Reference: registry.reference(),
+		Digest:    sha256Digest(registry.manifest),
+		PlainHTTP: true,
+	})
+	assert.Error(t, err)
+}
+
+func TestPullSigned(t *testing.T) {
+	registry := newFakeRegistry(t, map[string][]byte{"docker-compose.yml": []byte("services: {}\n")}, nil)
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	require.NoError(t, err)
+
+	pemKey := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, []byte(sha256Digest(registry.manifest))))
+
+	options := PullOptions{
+		Reference:   registry.reference(),
+		PublicKey:   pemKey,
+		Signature:   signature,
+		PlainHTTP:   true,
+		Credentials: &edge.RegistryCredentials{Username: "user", Secret: "secret"},
+	}
+
+	dirEntries, err := NewClient().Pull(context.Background(), options)
+	require.NoError(t, err)
+	assert.Equal(t, []filesystem.DirEntry{{Name: "docker-compose.yml", Content: "services: {}\n", IsFile: true, Permissions: 0644}}, dirEntries)
+
+	options.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, []byte("sha256:other")))
+
+	_, err = NewClient().Pull(context.Background(), options)
+	assert.ErrorIs(t, err, ErrVerification)
+}
+
+func TestLayerEntriesRejectsNonLocalPaths(t *testing.T) {
+	_, err := layerEntries(ocispec.Descriptor{Annotations: map[string]string{ocispec.AnnotationTitle: "../etc/passwd"}}, nil)
+	assert.Error(t, err)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "../evil", Typeflag: tar.TypeReg, Size: 1}))
+	_, _ = tw.Write([]byte("x"))
+	require.NoError(t, tw.Close())
+
+	_, err = layerEntries(ocispec.Descriptor{Annotations: map[string]string{ocispec.AnnotationTitle: "dir", annotationUnpack: "true"}}, buf.Bytes())
+	assert.Error(t, err)
+}
+
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:a/b:pull"`)
+
+	assert.Equal(t, "Bearer", scheme)
+	assert.Equal(t, map[string]string{
+		"realm":   "https://auth.example.com/token",
+		"service": "registry.example.com",
+		"scope":   "repository:a/b:pull",
+	}, params)
+}
+
+func TestNormalizeRegistry(t *testing.T) {
+	tests := []struct {
+		registry string
+		expected string
+	}{
+		{"registry.example.com", "registry.example.com"},
+		{"https://Registry.Example.com:5000/v2/", "registry.example.com:5000"},
+		{"http://localhost:5000", "localhost:5000"},
+		{"index.docker.io", "docker.io"},
+		{"https://index.docker.io/v1/", "docker.io"},
+		{"registry-1.docker.io", "docker.io"},
+		{"registry.hub.docker.com", "docker.io"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.registry, func(t *testing.T) {
+			assert.Equal(t, tt.expected, NormalizeRegistry(tt.registry))
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package oci
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/portainer/portainer/api/filesystem"
+
+	"github.com/rs/zerolog/log"
+)
+
+// annotationUnpack marks the layers holding a tar archive of a folder, as pushed by ORAS
+const annotationUnpack = "io.deis.oras.content.unpack"
+
+// layerEntries returns the files of a layer
+func layerEntries(layer ocispec.Descriptor, data []byte) ([]filesystem.DirEntry, error) {
+	title := layer.Annotations[ocispec.AnnotationTitle]
+	if title == "" {
+		log.Debug().Str("digest", layer.Digest.String()).Msg("ignoring OCI artifact layer without title")
+
+		return nil, nil
+	}
+
+	if !isLocalPath(title) {
+		return nil, fmt.Errorf("invalid OCI artifact file path %q", title)
+	}
+
+	if layer.Annotations[annotationUnpack] == "true" {
+		return untar(data)
+	}
+
+	return []filesystem.DirEntry{{
+		Name:        path.Clean(title),
+		Content:     string(data),
+		IsFile:      true,
+		Permissions: 0644,
+	}}, nil
+}
+
+// untar returns the files of a tar archive, optionally gzip compressed
+func untar(data []byte) ([]filesystem.DirEntry, error) {
+	var r io.Reader = bytes.NewReader(data)
+
+	if len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+
+		r = io.LimitReader(gz, maxArtifactSize)
+	}
+
+	var dirEntries []filesystem.DirEntry
+
+	tr := tar.NewReader(r)
+
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid OCI artifact archive: %w", err)
+		}
+
+		if !isLocalPath(header.Name) {
+			return nil, fmt.Errorf("invalid OCI artifact file path %q", header.Name)
+		}
+
+		name := path.Clean(header.Name)
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			dirEntries = append(dirEntries, filesystem.DirEntry{
+				Name:        name,
+				Permissions: os.FileMode(header.Mode).Perm() | 0700,
+			})
+		case tar.TypeReg:
+			content, err := io.ReadAll(tr)
+			if err != nil {
+				return nil, err
+			}
+
+			dirEntries = append(dirEntries, filesystem.DirEntry{
+				Name:        name,
+				Content:     string(content),
+				IsFile:      true,
+				Permissions: os.FileMode(header.Mode).Perm() | 0600,
+			})
+		default:
+			// Links and special files are not part of stack definitions
+			log.Debug().Str("name", header.Name).Msg("ignoring unsupported OCI artifact archive entry")
+		}
+	}
+
+	return dirEntries, nil
+}
+
+func isLocalPath(p string) bool {
+	return p != "." && filepath.IsLocal(filepath.FromSlash(path.Clean(p)))
+}
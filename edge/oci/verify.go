@@ -0,0 +1,67 @@
+package oci
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+)
+
+// verifySignature checks the signature of the manifest digest with the PEM encoded public key.
+// ECDSA (ASN.1), Ed25519 and RSA PKCS#1 v1.5 signatures are supported.
+func verifySignature(publicKey, signature, manifestDigest string) error {
+	valid, err := verifyPayload(publicKey, signature, []byte(manifestDigest))
+	if err != nil {
+		return err
+	}
+
+	if !valid {
+		return fmt.Errorf("%w: invalid signature of %s", ErrVerification, manifestDigest)
+	}
+
+	return nil
+}
+
+// verifyPayload returns whether the signature of the payload is valid for the PEM encoded public key, see verifySignature
+func verifyPayload(publicKey, signature string, payload []byte) (bool, error) {
+	if publicKey == "" {
+		return false, fmt.Errorf("%w: a public key is required to verify the signature", ErrVerification)
+	}
+
+	block, _ := pem.Decode([]byte(publicKey))
+	if block == nil {
+		return false, fmt.Errorf("%w: invalid PEM public key", ErrVerification)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return false, fmt.Errorf("%w: invalid public key: %w", ErrVerification, err)
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false, fmt.Errorf("%w: invalid signature encoding: %w", ErrVerification, err)
+	}
+
+	hash := sha256.Sum256(payload)
+
+	var valid bool
+
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		valid = ecdsa.VerifyASN1(k, hash[:], sig)
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(k, payload, sig)
+	case *rsa.PublicKey:
+		valid = rsa.VerifyPKCS1v15(k, crypto.SHA256, hash[:], sig) == nil
+	default:
+		return false, fmt.Errorf("%w: unsupported public key type %T", ErrVerification, key)
+	}
+
+	return valid, nil
+}
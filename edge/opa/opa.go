@@ -0,0 +1,149 @@
+package opa
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// ErrDenied is returned when an incoming payload is denied by the OPA policies of the device
+var ErrDenied = errors.New("denied by the OPA policy")
+
+// The kinds of the payloads evaluated, set as the kind of the input documents
+const (
+	KindStack   = "stack"
+	KindJob     = "job"
+	KindCommand = "command"
+)
+
+// DenyQuery is the rule evaluated, the set of the reasons a payload is denied for. e.g.
+//
+//	package portainer.agent
+//
+//	deny contains msg if {
+//	  input.kind == "job"
+//	  contains(input.payload.script, "rm -rf")
+//	  msg := "jobs cannot remove files recursively"
+//	}
+const DenyQuery = "data.portainer.agent.deny"
+
+// evaluationTimeout bounds the evaluation of a payload, the payload is denied when it is exceeded
+const evaluationTimeout = 30 * time.Second
+
+// Evaluator evaluates the incoming payloads against the Rego policies provided by the operator of the device,
+// with the opa binary
+type Evaluator struct {
+	binary     string
+	policyPath string
+	run        func(ctx context.Context, binary string, args []string, input []byte) ([]byte, error)
+}
+
+// input is the document the policies are evaluated against
+type input struct {
+	Kind    string `json:"kind"`
+	Payload any    `json:"payload"`
+}
+
+type evalOutput struct {
+	Result []struct {
+		Expressions []struct {
+			Value any `json:"value"`
+		} `json:"expressions"`
+	} `json:"result"`
+}
+
+// NewEvaluator returns a pointer to a new instance of Evaluator, policyPath is a Rego file or a folder of Rego
+// files. The opa binary is looked up in the PATH when binary is empty.
+func NewEvaluator(binary, policyPath string) *Evaluator {
+	if binary == "" {
+		binary = "opa"
+	}
+
+	return &Evaluator{binary: binary, policyPath: policyPath, run: runOPA}
+}
+
+// Evaluate returns an ErrDenied error holding the deny reasons of the policies for the payload of the given kind.
+// The payloads that cannot be evaluated are denied.
+func (evaluator *Evaluator) Evaluate(ctx context.Context, kind string, payload any) error {
+	document, err := json.Marshal(input{Kind: kind, Payload: payload})
+	if err != nil {
+		return fmt.Errorf("%w: unable to render the input document: %w", ErrDenied, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, evaluationTimeout)
+	defer cancel()
+
+	args := []string{"eval", "--format", "json", "--stdin-input", "--data", evaluator.policyPath, DenyQuery}
+
+	output, err := evaluator.run(ctx, evaluator.binary, args, document)
+	if err != nil {
+		return fmt.Errorf("%w: unable to evaluate the policies: %w", ErrDenied, err)
+	}
+
+	reasons, err := denyReasons(output)
+	if err != nil {
+		return fmt.Errorf("%w: unable to evaluate the policies: %w", ErrDenied, err)
+	}
+
+	if len(reasons) > 0 {
+		return fmt.Errorf("%w: %s", ErrDenied, strings.Join(reasons, "; "))
+	}
+
+	return nil
+}
+
+// denyReasons returns the reasons of the output of opa eval, the rule is undefined when no policy denies the payload
+func denyReasons(output []byte) ([]string, error) {
+	var result evalOutput
+	if err := json.Unmarshal(output, &result); err != nil {
+		return nil, err
+	}
+
+	var reasons []string
+
+	for _, r := range result.Result {
+		for _, expression := range r.Expressions {
+			values, ok := expression.Value.([]any)
+			if !ok {
+				return nil, fmt.Errorf("%s is not a set", DenyQuery)
+			}
+
+			for _, value := range values {
+				if reason, ok := value.(string); ok {
+					reasons = append(reasons, reason)
+
+					continue
+				}
+
+				reason, err := json.Marshal(value)
+				if err != nil {
+					return nil, err
+				}
+
+				reasons = append(reasons, string(reason))
+			}
+		}
+	}
+
+	return reasons, nil
+}
+
+func runOPA(ctx context.Context, binary string, args []string, input []byte) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, binary, args...)
+	cmd.Stdin = bytes.NewReader(input)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,48 @@
+package opa
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvaluator_Evaluate(t *testing.T) {
+	var document map[string]any
+	var arguments []string
+	output := `{"result": [{"expressions": [{"value": ["jobs cannot remove files", {"code": 42}], "text": "data.portainer.agent.deny"}]}]}`
+
+	evaluator := NewEvaluator("", "/etc/portainer/policies")
+	evaluator.run = func(ctx context.Context, binary string, args []string, input []byte) ([]byte, error) {
+		arguments = append([]string{binary}, args...)
+
+		return []byte(output), json.Unmarshal(input, &document)
+	}
+
+	err := evaluator.Evaluate(context.Background(), KindJob, map[string]string{"script": "rm -rf /"})
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrDenied))
+	assert.Equal(t, `denied by the OPA policy: jobs cannot remove files; {"code":42}`, err.Error())
+
+	assert.Equal(t, []string{"opa", "eval", "--format", "json", "--stdin-input", "--data", "/etc/portainer/policies", DenyQuery}, arguments)
+	assert.Equal(t, map[string]any{"kind": "job", "payload": map[string]any{"script": "rm -rf /"}}, document)
+
+	// The rule is undefined when no policy denies the payload
+	output = `{}`
+	assert.NoError(t, evaluator.Evaluate(context.Background(), KindJob, nil))
+
+	output = `{"result": [{"expressions": [{"value": []}]}]}`
+	assert.NoError(t, evaluator.Evaluate(context.Background(), KindJob, nil))
+
+	// The payloads are denied when the policies cannot be evaluated
+	output = `{"result": [{"expressions": [{"value": true}]}]}`
+	assert.ErrorIs(t, evaluator.Evaluate(context.Background(), KindJob, nil), ErrDenied)
+
+	evaluator.run = func(ctx context.Context, binary string, args []string, input []byte) ([]byte, error) {
+		return nil, errors.New("1 error occurred: policy.rego:3: rego_parse_error")
+	}
+	assert.ErrorIs(t, evaluator.Evaluate(context.Background(), KindJob, nil), ErrDenied)
+}
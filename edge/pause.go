@@ -0,0 +1,60 @@
+package edge
+
+import (
+	"time"
+
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/agent/edge/pause"
+
+	"github.com/rs/zerolog/log"
+)
+
+// instructionsPaused returns true while a critical local operation paused the processing of the instructions
+func (service *PollService) instructionsPaused() bool {
+	if service.dataPath == "" {
+		return false
+	}
+
+	state, err := pause.Active(service.dataPath, service.clock.Now())
+	if err != nil {
+		log.Error().Err(err).Msg("unable to read the pause of the instructions, resuming")
+	}
+
+	paused := state != nil
+	if paused && !service.paused {
+		log.Info().Time("until", state.Until).Str("reason", state.Reason).Msg("processing of the instructions paused")
+	} else if !paused && service.paused {
+		log.Info().Int("queued_command_count", len(service.queuedCommands)).Msg("processing of the instructions resumed")
+	}
+
+	service.paused = paused
+
+	return paused
+}
+
+// queueAsyncCommands queues the commands while the instructions are paused and returns the commands to process
+// once they are resumed. The commands are only acknowledged when processed so they are received again
+// at each poll, only the ones newer than the queued ones are added.
+func (service *PollService) queueAsyncCommands(commands []client.AsyncCommand) []client.AsyncCommand {
+	var lastQueued time.Time
+	if n := len(service.queuedCommands); n > 0 {
+		lastQueued = service.queuedCommands[n-1].Timestamp
+	}
+
+	for _, command := range commands {
+		if !lastQueued.IsZero() && !command.Timestamp.After(lastQueued) {
+			continue
+		}
+
+		service.queuedCommands = append(service.queuedCommands, command)
+	}
+
+	if service.instructionsPaused() {
+		return nil
+	}
+
+	queued := service.queuedCommands
+	service.queuedCommands = nil
+
+	return queued
+}
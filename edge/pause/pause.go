@@ -0,0 +1,67 @@
+package pause
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	agentfs "github.com/portainer/agent/filesystem"
+)
+
+// fileName is the file of the data folder the pause is kept in, so that it can be set
+// by the local CLI and the updater while the agent is running
+const fileName = "pause.json"
+
+// State describes a pause of the processing of the instructions sent by Portainer
+type State struct {
+	Until  time.Time
+	Reason string
+}
+
+// Set pauses the processing of the instructions for the duration, the pause ends
+// on its own so that a failed operation does not leave the device unmanaged
+func Set(dataPath string, duration time.Duration, reason string) error {
+	if duration <= 0 {
+		return errors.New("the pause duration must be positive")
+	}
+
+	data, err := json.Marshal(State{Until: time.Now().Add(duration), Reason: reason})
+	if err != nil {
+		return err
+	}
+
+	return agentfs.WriteFile(dataPath, fileName, data, 0600)
+}
+
+// Clear resumes the processing of the instructions
+func Clear(dataPath string) error {
+	err := os.Remove(filepath.Join(dataPath, fileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// Active returns the pause in effect at now, nil when the instructions are processed
+func Active(dataPath string, now time.Time) (*State, error) {
+	data, err := os.ReadFile(filepath.Join(dataPath, fileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+
+	if !now.Before(state.Until) {
+		return nil, nil
+	}
+
+	return &state, nil
+}
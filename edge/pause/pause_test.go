@@ -0,0 +1,38 @@
+package pause
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPause(t *testing.T) {
+	dataPath := t.TempDir()
+
+	state, err := Active(dataPath, time.Now())
+	require.NoError(t, err)
+	assert.Nil(t, state)
+
+	require.NoError(t, Set(dataPath, time.Hour, "os update"))
+
+	state, err = Active(dataPath, time.Now())
+	require.NoError(t, err)
+	require.NotNil(t, state)
+	assert.Equal(t, "os update", state.Reason)
+
+	// The pause ends on its own
+	state, err = Active(dataPath, time.Now().Add(2*time.Hour))
+	require.NoError(t, err)
+	assert.Nil(t, state)
+
+	require.NoError(t, Clear(dataPath))
+	require.NoError(t, Clear(dataPath))
+
+	state, err = Active(dataPath, time.Now())
+	require.NoError(t, err)
+	assert.Nil(t, state)
+
+	assert.Error(t, Set(dataPath, 0, ""))
+}
@@ -0,0 +1,34 @@
+package edge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/portainer/agent/clock"
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/agent/edge/pause"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPollService_queueAsyncCommands(t *testing.T) {
+	dataPath := t.TempDir()
+
+	service := &PollService{dataPath: dataPath, clock: clock.NewSystemClock()}
+
+	first := client.AsyncCommand{ID: 1, Timestamp: time.Unix(100, 0)}
+	second := client.AsyncCommand{ID: 2, Timestamp: time.Unix(200, 0)}
+
+	require.NoError(t, pause.Set(dataPath, time.Hour, "migration"))
+
+	assert.Empty(t, service.queueAsyncCommands([]client.AsyncCommand{first}))
+
+	// The commands are received again until they are acknowledged
+	assert.Empty(t, service.queueAsyncCommands([]client.AsyncCommand{first, second}))
+
+	require.NoError(t, pause.Clear(dataPath))
+
+	assert.Equal(t, []client.AsyncCommand{first, second}, service.queueAsyncCommands([]client.AsyncCommand{first, second}))
+	assert.Empty(t, service.queueAsyncCommands(nil))
+}
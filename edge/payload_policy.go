@@ -0,0 +1,133 @@
+package edge
+
+import (
+	"context"
+	"time"
+
+	"github.com/portainer/agent"
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/agent/edge/opa"
+
+	"github.com/rs/zerolog/log"
+)
+
+// hostCommandTypes are the types of the async commands run on the host, evaluated against the OPA policies
+var hostCommandTypes = map[string]bool{
+	"container":    true,
+	"image":        true,
+	"volume":       true,
+	"normalStack":  true,
+	"fileTransfer": true,
+	"hostUpdate":   true,
+	"power":        true,
+	"backup":       true,
+}
+
+// jobPolicyInput is the payload of the input documents of the jobs
+type jobPolicyInput struct {
+	ID             int    `json:"id"`
+	CronExpression string `json:"cronExpression"`
+	Script         string `json:"script"`
+	Version        int    `json:"version"`
+	CollectLogs    bool   `json:"collectLogs"`
+	// TimeoutSeconds is 0 when the executions of the job are not bounded
+	TimeoutSeconds int64 `json:"timeoutSeconds"`
+	Retries        int   `json:"retries"`
+	// RunAs is the identity the job runs with, resolved with the default user of the agent
+	RunAs jobRunAsInput `json:"runAs"`
+}
+
+type jobRunAsInput struct {
+	User       string   `json:"user"`
+	Group      string   `json:"group"`
+	WorkingDir string   `json:"workingDir"`
+	Env        []string `json:"env"`
+}
+
+// commandPolicyInput is the payload of the input documents of the host commands
+type commandPolicyInput struct {
+	Type      string `json:"type"`
+	Operation string `json:"operation"`
+	Value     any    `json:"value"`
+}
+
+// checkSchedule returns an error when the job cannot run with the identity it sets, a scheduler.ErrPrivilegedJob
+// error when it runs as root without the agent allowing it, or an opa.ErrDenied error when the OPA policies deny it.
+// The denials are reported as the logs of the job, once per version of the job.
+func (service *PollService) checkSchedule(ctx context.Context, schedule agent.Schedule) error {
+	err := service.evaluateSchedule(ctx, schedule)
+	if err == nil {
+		delete(service.deniedSchedules, schedule.ID)
+
+		return nil
+	}
+
+	if version, ok := service.deniedSchedules[schedule.ID]; ok && version == schedule.Version {
+		return err
+	}
+
+	log.Error().Int("schedule_id", schedule.ID).Err(err).Msg("job denied")
+
+	if statusErr := service.portainerClient.SetEdgeJobStatus(agent.EdgeJobStatus{JobID: schedule.ID, LogFileContent: err.Error()}); statusErr != nil {
+		log.Error().Err(statusErr).Msg("unable to report the denial of the job")
+
+		return err
+	}
+
+	service.deniedSchedules[schedule.ID] = schedule.Version
+
+	return err
+}
+
+func (service *PollService) evaluateSchedule(ctx context.Context, schedule agent.Schedule) error {
+	runAs, err := service.jobRunAs.Resolve(schedule.RunAs)
+	if err != nil {
+		return err
+	}
+
+	if service.payloadPolicy == nil {
+		return nil
+	}
+
+	return service.payloadPolicy.Evaluate(ctx, opa.KindJob, jobPolicyInput{
+		ID:             schedule.ID,
+		CronExpression: schedule.CronExpression,
+		Script:         schedule.Script,
+		Version:        schedule.Version,
+		CollectLogs:    schedule.CollectLogs,
+		TimeoutSeconds: int64(schedule.Timeout / time.Second),
+		Retries:        schedule.Retries,
+		RunAs: jobRunAsInput{
+			User:       runAs.User,
+			Group:      runAs.Group,
+			WorkingDir: runAs.WorkingDir,
+			Env:        runAs.Env,
+		},
+	})
+}
+
+// allowedSchedules returns the schedules that are not denied
+func (service *PollService) allowedSchedules(schedules []agent.Schedule) []agent.Schedule {
+	allowed := make([]agent.Schedule, 0, len(schedules))
+	for _, schedule := range schedules {
+		if service.checkSchedule(context.Background(), schedule) == nil {
+			allowed = append(allowed, schedule)
+		}
+	}
+
+	return allowed
+}
+
+// checkHostCommand returns an opa.ErrDenied error when the OPA policies deny a command run on the host, the other
+// commands are not evaluated
+func (service *PollService) checkHostCommand(ctx context.Context, command client.AsyncCommand) error {
+	if service.payloadPolicy == nil || !hostCommandTypes[command.Type] {
+		return nil
+	}
+
+	return service.payloadPolicy.Evaluate(ctx, opa.KindCommand, commandPolicyInput{
+		Type:      command.Type,
+		Operation: command.Operation,
+		Value:     command.Value,
+	})
+}
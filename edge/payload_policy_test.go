@@ -0,0 +1,105 @@
+package edge
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/portainer/agent"
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/agent/edge/opa"
+	"github.com/portainer/agent/edge/scheduler"
+	"github.com/portainer/agent/internals/mocks"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gomock "go.uber.org/mock/gomock"
+)
+
+// fakeOPA returns an opa binary denying the input documents containing the given text
+func fakeOPA(t *testing.T, denied string) string {
+	binary := filepath.Join(t.TempDir(), "opa")
+	script := `#!/bin/sh
+if grep -q '` + denied + `'; then
+  echo '{"result": [{"expressions": [{"value": ["` + denied + ` is forbidden"]}]}]}'
+else
+  echo '{}'
+fi
+`
+	require.NoError(t, os.WriteFile(binary, []byte(script), 0755))
+
+	return binary
+}
+
+func TestPollService_checkSchedule(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClient := mocks.NewMockPortainerClient(ctrl)
+
+	service := &PollService{
+		portainerClient: mockClient,
+		payloadPolicy:   opa.NewEvaluator(fakeOPA(t, "reboot"), "/etc/portainer/policies"),
+		jobRunAs:        scheduler.RunAsPolicy{DefaultUser: "nobody"},
+		deniedSchedules: make(map[int]int),
+	}
+
+	schedules := []agent.Schedule{
+		{ID: 1, Version: 1, Script: "df -h"},
+		{ID: 2, Version: 1, Script: "reboot"},
+	}
+
+	// The denial is reported once per version of the job
+	mockClient.EXPECT().SetEdgeJobStatus(agent.EdgeJobStatus{JobID: 2, LogFileContent: "denied by the OPA policy: reboot is forbidden"}).Times(2)
+
+	assert.Equal(t, schedules[:1], service.allowedSchedules(schedules))
+	assert.Equal(t, schedules[:1], service.allowedSchedules(schedules))
+
+	schedules[1].Version = 2
+	assert.ErrorIs(t, service.checkSchedule(context.Background(), schedules[1]), opa.ErrDenied)
+
+	schedules[1].Script = "uptime"
+	assert.NoError(t, service.checkSchedule(context.Background(), schedules[1]))
+	assert.Empty(t, service.deniedSchedules)
+}
+
+func TestPollService_checkSchedulePrivileged(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClient := mocks.NewMockPortainerClient(ctrl)
+
+	service := &PollService{
+		portainerClient: mockClient,
+		jobRunAs:        scheduler.RunAsPolicy{DefaultUser: "nobody"},
+		deniedSchedules: make(map[int]int),
+	}
+
+	schedules := []agent.Schedule{
+		{ID: 1, Version: 1, Script: "df -h"},
+		{ID: 2, Version: 1, Script: "apt-get upgrade -y", RunAs: agent.JobRunAs{User: "root"}},
+	}
+
+	mockClient.EXPECT().SetEdgeJobStatus(agent.EdgeJobStatus{JobID: 2, LogFileContent: scheduler.ErrPrivilegedJob.Error()})
+
+	assert.Equal(t, schedules[:1], service.allowedSchedules(schedules))
+
+	// Running as root requires the agent to allow it explicitly
+	service.jobRunAs.AllowRoot = true
+	assert.Equal(t, schedules, service.allowedSchedules(schedules))
+}
+
+func TestPollService_checkHostCommand(t *testing.T) {
+	service := &PollService{payloadPolicy: opa.NewEvaluator(fakeOPA(t, "portainer_agent"), "/etc/portainer/policies")}
+
+	deleteAgent := client.AsyncCommand{Type: "container", Operation: "replace", Value: map[string]any{"containerName": "portainer_agent", "containerOperation": "delete"}}
+	assert.ErrorIs(t, service.checkHostCommand(context.Background(), deleteAgent), opa.ErrDenied)
+
+	deleteAgent.Value = map[string]any{"containerName": "web", "containerOperation": "delete"}
+	assert.NoError(t, service.checkHostCommand(context.Background(), deleteAgent))
+
+	// Only the commands run on the host are evaluated
+	log := client.AsyncCommand{Type: "edgeLog", Value: map[string]any{"stackName": "portainer_agent"}}
+	assert.NoError(t, service.checkHostCommand(context.Background(), log))
+}
@@ -6,11 +6,20 @@ import (
 	"math/rand"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/portainer/agent"
 	"github.com/portainer/agent/chisel"
+	"github.com/portainer/agent/clock"
+	"github.com/portainer/agent/edge/backup"
 	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/agent/edge/filetransfer"
+	"github.com/portainer/agent/edge/hostinfo"
+	"github.com/portainer/agent/edge/labels"
+	"github.com/portainer/agent/edge/opa"
+	"github.com/portainer/agent/edge/power"
 	"github.com/portainer/agent/edge/scheduler"
 	"github.com/portainer/agent/edge/stack"
 	"github.com/portainer/portainer/pkg/libcrypto"
@@ -42,6 +51,26 @@ type PollService struct {
 	tunnelServerAddr         string
 	tunnelServerFingerprint  string
 	tunnelProxy              string
+	clock                    agent.Clock
+	labelService             *labels.Service
+	reportedLabels           map[string]string
+	dataPath                 string
+	paused                   bool
+	payloadPolicy            *opa.Evaluator
+	jobRunAs                 scheduler.RunAsPolicy
+	fileTransfers            *filetransfer.Manager
+	hostInfo                 *hostinfo.Collector
+	reportedHostInfo         *client.HostInfo
+	power                    *power.Manager
+	backup                   *backup.Source
+	// stackCommands holds a slot for each one-off command of the stacks running
+	stackCommands chan struct{}
+	// tunnelMu serializes the operations on the tunnel of the poll loops and of the standby election
+	tunnelMu sync.Mutex
+	// lastPoll is the unix time of the last successful poll, read by the health checks of an updated agent
+	lastPoll atomic.Int64
+	// deniedSchedules maps the denied jobs to their version whose denial was reported
+	deniedSchedules map[int]int
 
 	// Async mode only
 	pingInterval     time.Duration
@@ -50,6 +79,7 @@ type PollService struct {
 	pingTicker       *time.Ticker
 	snapshotTicker   *time.Ticker
 	commandTicker    *time.Ticker
+	queuedCommands   []client.AsyncCommand
 }
 
 type pollServiceConfig struct {
@@ -63,6 +93,14 @@ type pollServiceConfig struct {
 	TunnelServerFingerprint string
 	TunnelProxy             string
 	ContainerPlatform       agent.ContainerPlatform
+	LabelService            *labels.Service
+	DataPath                string
+	PayloadPolicy           *opa.Evaluator
+	JobRunAs                scheduler.RunAsPolicy
+	FileTransfers           *filetransfer.Manager
+	HostInfo                *hostinfo.Collector
+	Power                   *power.Manager
+	Backup                  *backup.Source
 }
 
 // newPollService returns a pointer to a new instance of PollService, and will start two loops in go routines.
@@ -87,7 +125,7 @@ func newPollService(edgeManager *Manager, edgeStackManager *stack.StackManager,
 		edgeID:                   config.EdgeID,
 		pollIntervalInSeconds:    pollFrequency.Seconds(),
 		inactivityTimeout:        inactivityTimeout,
-		scheduleManager:          scheduler.NewCronManager(logsManager),
+		scheduleManager:          scheduler.NewCronManager(logsManager, config.JobRunAs),
 		updateLastActivitySignal: make(chan struct{}),
 		startSignal:              make(chan struct{}),
 		stopSignal:               make(chan struct{}),
@@ -98,6 +136,17 @@ func newPollService(edgeManager *Manager, edgeStackManager *stack.StackManager,
 		tunnelServerFingerprint:  config.TunnelServerFingerprint,
 		tunnelProxy:              config.TunnelProxy,
 		portainerClient:          portainerClient,
+		clock:                    clock.NewSystemClock(),
+		labelService:             config.LabelService,
+		dataPath:                 config.DataPath,
+		payloadPolicy:            config.PayloadPolicy,
+		jobRunAs:                 config.JobRunAs,
+		fileTransfers:            config.FileTransfers,
+		hostInfo:                 config.HostInfo,
+		power:                    config.Power,
+		backup:                   config.Backup,
+		deniedSchedules:          make(map[int]int),
+		stackCommands:            make(chan struct{}, maxStackCommands),
 	}
 
 	if config.TunnelCapability {
@@ -146,13 +195,17 @@ func (service *PollService) startStatusPollLoop() {
 			if lastPollFailed {
 				lastPollFailed = false
 				t := time.Duration(rand.Float64() * service.pollIntervalInSeconds * float64(time.Second))
-				time.Sleep(t)
+				service.clock.Sleep(t)
 				service.pollTicker.Reset(time.Duration(service.pollIntervalInSeconds) * time.Second)
 			}
 
 			err := service.poll()
 			if err != nil {
-				log.Error().Err(err).Msg("an error occured during short poll")
+				if errors.Is(err, client.ErrCircuitOpen) {
+					log.Debug().Err(err).Msg("an error occured during short poll")
+				} else {
+					log.Error().Err(err).Msg("an error occured during short poll")
+				}
 
 				lastPollFailed = true
 				service.pollTicker.Reset(time.Duration(service.pollIntervalInSeconds) * time.Second)
@@ -182,24 +235,19 @@ func (service *PollService) startActivityMonitoringLoop() {
 				continue
 			}
 
-			elapsed := time.Since(service.lastActivity)
+			elapsed := service.clock.Now().Sub(service.lastActivity)
 
 			log.Debug().
 				Float64("tunnel_last_activity_seconds", elapsed.Seconds()).
 				Msg("tunnel activity monitoring")
 
-			if service.tunnelClient != nil && service.tunnelClient.IsTunnelOpen() && elapsed.Seconds() > service.inactivityTimeout.Seconds() {
+			if elapsed.Seconds() > service.inactivityTimeout.Seconds() && service.closeTunnel() {
 				log.Info().
 					Float64("tunnel_last_activity_seconds", elapsed.Seconds()).
-					Msg("shutting down tunnel after inactivity period")
-
-				err := service.tunnelClient.CloseTunnel()
-				if err != nil {
-					log.Error().Err(err).Msg("unable to shutdown tunnel")
-				}
+					Msg("tunnel shut down after inactivity period")
 			}
 		case <-service.updateLastActivitySignal:
-			service.lastActivity = time.Now()
+			service.lastActivity = service.clock.Now()
 		}
 	}
 }
@@ -212,8 +260,14 @@ func (service *PollService) poll() error {
 		}
 
 		service.edgeManager.SetEndpointID(endpointID)
+		service.reportedLabels = nil
+		service.reportedHostInfo = nil
 	}
 
+	service.reportLabels()
+	service.reportHostInfo()
+	service.reportBoot()
+
 	environmentStatus, err := service.portainerClient.GetEnvironmentStatus()
 	if err != nil {
 		var nonOkError *client.NonOkResponseError
@@ -224,6 +278,8 @@ func (service *PollService) poll() error {
 		return err
 	}
 
+	service.lastPoll.Store(service.clock.Now().Unix())
+
 	log.Debug().
 		Str("status", environmentStatus.Status).
 		Int("port", environmentStatus.Port).
@@ -236,8 +292,6 @@ func (service *PollService) poll() error {
 		return tunnelErr
 	}
 
-	service.processSchedules(environmentStatus.Schedules)
-
 	if environmentStatus.CheckinInterval > 0 && environmentStatus.CheckinInterval != service.pollIntervalInSeconds {
 		log.Debug().
 			Float64("old_interval", service.pollIntervalInSeconds).
@@ -245,12 +299,24 @@ func (service *PollService) poll() error {
 			Msg("updating poll interval")
 
 		service.pollIntervalInSeconds = environmentStatus.CheckinInterval
+		service.edgeManager.serverSettings.set("checkin_interval", (time.Duration(service.pollIntervalInSeconds) * time.Second).String(), agent.DefaultEdgePollInterval)
 		service.portainerClient.SetTimeout(time.Duration(environmentStatus.CheckinInterval) * time.Second)
 		service.pollTicker.Reset(time.Duration(service.pollIntervalInSeconds) * time.Second)
 	}
 
+	// The status is declarative, the one received after the pause supersedes the ones received during it
+	if service.instructionsPaused() {
+		return nil
+	}
+
+	service.processSchedules(environmentStatus.Schedules)
+
 	service.processEdgeConfigs(environmentStatus.EdgeConfigurations)
 
+	service.edgeStackManager.SetStatusWaitDefaults(environmentStatus.StackStatusTimeout, environmentStatus.StackStatusCheckInterval)
+
+	service.edgeStackManager.SetRecovery(environmentStatus.StackRecovery)
+
 	return service.processStacks(environmentStatus.Stacks)
 }
 
@@ -259,31 +325,75 @@ func (service *PollService) manageUpdateTunnel(environmentStatus client.PollStat
 		return nil
 	}
 
-	if environmentStatus.Status == agent.TunnelStatusIdle && service.tunnelClient.IsTunnelOpen() {
+	if environmentStatus.Status == agent.TunnelStatusIdle && service.closeTunnel() {
 		log.Debug().
 			Str("status", environmentStatus.Status).
-			Msg("idle status detected, shutting down tunnel")
-
-		err := service.tunnelClient.CloseTunnel()
-		if err != nil {
-			log.Error().Err(err).Msg("unable to shutdown tunnel")
-		}
+			Msg("idle status detected, tunnel shut down")
 	}
 
-	if environmentStatus.Status == agent.TunnelStatusRequired && !service.tunnelClient.IsTunnelOpen() {
-		log.Debug().Msg("required status detected, creating reverse tunnel")
-
-		err := service.createTunnel(environmentStatus.Credentials, environmentStatus.Port)
+	if environmentStatus.Status == agent.TunnelStatusRequired {
+		created, err := service.openTunnel(environmentStatus.Credentials, environmentStatus.Port)
 		if err != nil {
 			log.Error().Err(err).Msg("unable to create tunnel")
 
 			return err
 		}
+
+		// The activity monitoring loop takes the tunnel lock, the timer is reset once it is released
+		if created {
+			service.resetActivityTimer()
+		}
 	}
 
 	return nil
 }
 
+// openTunnel creates the tunnel when it is not open and returns whether it was created, it can be called from any
+// goroutine. The tunnel of a standby agent is not created, the lease can be lost while the poll is in flight.
+func (service *PollService) openTunnel(encodedCredentials string, remotePort int) (bool, error) {
+	service.tunnelMu.Lock()
+	defer service.tunnelMu.Unlock()
+
+	if service.tunnelClient.IsTunnelOpen() {
+		return false, nil
+	}
+
+	if service.edgeManager != nil && !service.edgeManager.isActive() {
+		return false, nil
+	}
+
+	log.Debug().Msg("required status detected, creating reverse tunnel")
+
+	if err := service.createTunnel(encodedCredentials, remotePort); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// closeTunnel closes the tunnel when it is open and returns whether it was closed, it can be called from any
+// goroutine
+func (service *PollService) closeTunnel() bool {
+	if service.tunnelClient == nil {
+		return false
+	}
+
+	service.tunnelMu.Lock()
+	defer service.tunnelMu.Unlock()
+
+	if !service.tunnelClient.IsTunnelOpen() {
+		return false
+	}
+
+	if err := service.tunnelClient.CloseTunnel(); err != nil {
+		log.Error().Err(err).Msg("unable to shutdown tunnel")
+
+		return false
+	}
+
+	return true
+}
+
 func (service *PollService) createTunnel(encodedCredentials string, remotePort int) error {
 	decodedCredentials, err := base64.RawStdEncoding.DecodeString(encodedCredentials)
 	if err != nil {
@@ -304,17 +414,11 @@ func (service *PollService) createTunnel(encodedCredentials string, remotePort i
 		RemotePort:        strconv.Itoa(remotePort),
 	}
 
-	err = service.tunnelClient.CreateTunnel(tunnelConfig)
-	if err != nil {
-		return err
-	}
-
-	service.resetActivityTimer()
-	return nil
+	return service.tunnelClient.CreateTunnel(tunnelConfig)
 }
 
 func (service *PollService) processSchedules(schedules []agent.Schedule) {
-	err := service.scheduleManager.Schedule(schedules)
+	err := service.scheduleManager.Schedule(service.allowedSchedules(schedules))
 	if err != nil {
 		log.Error().Err(err).Msg("an error occurred during schedule management")
 	}
@@ -1,16 +1,22 @@
 package edge
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"os"
 	"time"
 
 	"github.com/portainer/agent"
 	"github.com/portainer/agent/docker"
+	"github.com/portainer/agent/edge/backup"
 	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/agent/edge/filetransfer"
+	"github.com/portainer/agent/edge/freeze"
+	"github.com/portainer/agent/serf"
 	portainer "github.com/portainer/portainer/api"
-	"github.com/portainer/portainer/api/edge"
 
 	"github.com/mitchellh/mapstructure"
 	"github.com/rs/zerolog/log"
@@ -21,14 +27,21 @@ const (
 	coalescingInterval = 100 * time.Millisecond
 	failSafeInterval   = time.Minute
 
-	EdgeAsyncCommandTypeConfig      EdgeAsyncCommandType = "edgeConfig"
-	EdgeAsyncCommandTypeStack       EdgeAsyncCommandType = "edgeStack"
-	EdgeAsyncCommandTypeJob         EdgeAsyncCommandType = "edgeJob"
-	EdgeAsyncCommandTypeLog         EdgeAsyncCommandType = "edgeLog"
-	EdgeAsyncCommandTypeContainer   EdgeAsyncCommandType = "container"
-	EdgeAsyncCommandTypeImage       EdgeAsyncCommandType = "image"
-	EdgeAsyncCommandTypeVolume      EdgeAsyncCommandType = "volume"
-	EdgeAsyncCommandTypeNormalStack EdgeAsyncCommandType = "normalStack"
+	// maxStackCommands is the number of one-off commands of the stacks running at the same time, the next ones wait
+	// for a running command to finish
+	maxStackCommands = 2
+
+	EdgeAsyncCommandTypeConfig        EdgeAsyncCommandType = "edgeConfig"
+	EdgeAsyncCommandTypeStack         EdgeAsyncCommandType = "edgeStack"
+	EdgeAsyncCommandTypeJob           EdgeAsyncCommandType = "edgeJob"
+	EdgeAsyncCommandTypeLog           EdgeAsyncCommandType = "edgeLog"
+	EdgeAsyncCommandTypeContainer     EdgeAsyncCommandType = "container"
+	EdgeAsyncCommandTypeImage         EdgeAsyncCommandType = "image"
+	EdgeAsyncCommandTypeVolume        EdgeAsyncCommandType = "volume"
+	EdgeAsyncCommandTypeNormalStack   EdgeAsyncCommandType = "normalStack"
+	EdgeAsyncCommandTypeStackRollback EdgeAsyncCommandType = "edgeStackRollback"
+	EdgeAsyncCommandTypeStackRestart  EdgeAsyncCommandType = "edgeStackRestart"
+	EdgeAsyncCommandTypeStackRun      EdgeAsyncCommandType = "edgeStackRun"
 
 	EdgeAsyncCommandOpAdd     EdgeAsyncCommandOperation = "add"
 	EdgeAsyncCommandOpRemove  EdgeAsyncCommandOperation = "remove"
@@ -148,7 +161,11 @@ func (service *PollService) startStatusPollLoopAsync() {
 
 			err := service.pollAsync(snapshotFlag, commandFlag)
 			if err != nil {
-				log.Error().Err(err).Msg("an error occurred during async poll")
+				if errors.Is(err, client.ErrCircuitOpen) {
+					log.Debug().Err(err).Msg("an error occurred during async poll")
+				} else {
+					log.Error().Err(err).Msg("an error occurred during async poll")
+				}
 			}
 
 			snapshotFlag, commandFlag, coalescingFlag = false, false, false
@@ -175,6 +192,10 @@ func (service *PollService) pollAsync(doSnapshot, doCommand bool) error {
 
 	if doSnapshot {
 		flags = append(flags, "snapshot")
+
+		service.reportLabels()
+		service.reportHostInfo()
+		service.reportBoot()
 	}
 
 	if doCommand {
@@ -186,7 +207,11 @@ func (service *PollService) pollAsync(doSnapshot, doCommand bool) error {
 		return err
 	}
 
-	service.processAsyncCommands(status.AsyncCommands)
+	service.lastPoll.Store(service.clock.Now().Unix())
+
+	service.edgeStackManager.SetStatusWaitDefaults(status.StackStatusTimeout, status.StackStatusCheckInterval)
+
+	service.processAsyncCommands(service.queueAsyncCommands(status.AsyncCommands))
 
 	service.scheduleManager.ProcessScheduleLogsCollection()
 
@@ -198,6 +223,10 @@ func (service *PollService) pollAsync(doSnapshot, doCommand bool) error {
 		service.snapshotInterval = status.SnapshotInterval
 		service.commandInterval = status.CommandInterval
 
+		service.edgeManager.serverSettings.set("ping_interval", status.PingInterval.String(), "")
+		service.edgeManager.serverSettings.set("snapshot_interval", status.SnapshotInterval.String(), "")
+		service.edgeManager.serverSettings.set("command_interval", status.CommandInterval.String(), "")
+
 		updateTicker(service.pingTicker, status.PingInterval)
 		updateTicker(service.snapshotTicker, status.SnapshotInterval)
 		updateTicker(service.commandTicker, status.CommandInterval)
@@ -212,7 +241,13 @@ func (service *PollService) processAsyncCommands(commands []client.AsyncCommand)
 	ctx := context.Background()
 
 	for _, command := range commands {
-		var err error
+		err := service.checkHostCommand(ctx, command)
+		if err != nil {
+			log.Error().Str("command", command.Type).Str("operation", command.Operation).Err(err).Msg("command denied")
+			service.portainerClient.SetLastCommandTimestamp(command.Timestamp)
+
+			continue
+		}
 
 		switch command.Type {
 		case "edgeStack":
@@ -231,6 +266,30 @@ func (service *PollService) processAsyncCommands(commands []client.AsyncCommand)
 			err = service.processNormalStackCommand(ctx, command)
 		case "edgeConfig":
 			err = service.processEdgeConfigCommand(command)
+		case "edgeStackRollback":
+			err = service.processStackRollbackCommand(command)
+		case "edgeStackRestart":
+			err = service.processStackRestartCommand(command)
+		case "edgeStackRun":
+			err = service.processStackRunCommand(ctx, command)
+		case "edgeStackBatch":
+			err = service.processStackBatchCommand(ctx, command)
+		case "edgeStackBarrier":
+			err = service.processStackBarrierCommand(command)
+		case "clusterKey":
+			err = service.processClusterKeyCommand(command)
+		case "freeze":
+			err = service.processFreezeCommand(command)
+		case "fileTransfer":
+			err = service.processFileTransferCommand(command)
+		case "hostUpdate":
+			err = service.processHostUpdateCommand(command)
+		case "power":
+			err = service.processPowerCommand(command)
+		case "backup":
+			err = service.processBackupCommand(command)
+		case "edgeStackInventory":
+			err = newOperationError("edgeStackInventory", command.Operation, service.edgeStackManager.ReportInventory())
 		default:
 			err = newOperationError(command.Type, "n/a", errors.New("command type not supported"))
 		}
@@ -249,7 +308,7 @@ func (service *PollService) processAsyncCommands(commands []client.AsyncCommand)
 }
 
 func (service *PollService) processStackCommand(ctx context.Context, command client.AsyncCommand) error {
-	var stackData edge.StackPayload
+	var stackData client.StackPayload
 	err := mapstructure.Decode(command.Value, &stackData)
 	if err != nil {
 		return newOperationError("stack", command.Operation, err)
@@ -303,11 +362,17 @@ func (service *PollService) processScheduleCommand(command client.AsyncCommand)
 		Script:         jobData.ScriptFileContent,
 		Version:        jobData.Version,
 		CollectLogs:    jobData.CollectLogs,
+		Timeout:        jobData.Timeout,
+		Retries:        jobData.Retries,
+		RetryDelay:     jobData.RetryDelay,
+		RunAs:          jobData.RunAs,
 	}
 
 	switch command.Operation {
 	case "add", "replace":
-		err = service.scheduleManager.AddSchedule(schedule)
+		if err = service.checkSchedule(context.Background(), schedule); err == nil {
+			err = service.scheduleManager.AddSchedule(schedule)
+		}
 
 	case "remove":
 		err = service.scheduleManager.RemoveSchedule(schedule)
@@ -327,6 +392,10 @@ func (service *PollService) processLogCommand(command client.AsyncCommand) error
 		return newOperationError("log", "n/a", err)
 	}
 
+	if stack, ok := service.edgeManager.stackManager.GetStack(int(logCmd.EdgeStackID)); ok {
+		logCmd.ProjectName = stack.ProjectName
+	}
+
 	service.portainerClient.EnqueueLogCollectionForStack(logCmd)
 
 	return nil
@@ -402,6 +471,260 @@ func (service *PollService) processNormalStackCommand(ctx context.Context, comma
 
 	return newOperationError("normalStack", command.Operation, err)
 }
+
+func (service *PollService) processStackRollbackCommand(command client.AsyncCommand) error {
+	var rollbackCommand client.StackRollbackCommandData
+	err := mapstructure.Decode(command.Value, &rollbackCommand)
+	if err != nil {
+		return newOperationError("edgeStackRollback", "n/a", err)
+	}
+
+	err = service.edgeStackManager.RollbackStack(rollbackCommand.StackID, rollbackCommand.Version)
+
+	return newOperationError("edgeStackRollback", command.Operation, err)
+}
+
+func (service *PollService) processStackRestartCommand(command client.AsyncCommand) error {
+	var restartCommand client.StackRestartCommandData
+	err := mapstructure.Decode(command.Value, &restartCommand)
+	if err != nil {
+		return newOperationError("edgeStackRestart", "n/a", err)
+	}
+
+	err = service.edgeStackManager.RestartStack(restartCommand.StackID)
+
+	return newOperationError("edgeStackRestart", command.Operation, err)
+}
+
+func (service *PollService) processStackRunCommand(ctx context.Context, command client.AsyncCommand) error {
+	var runCommand client.StackRunCommandData
+	err := mapstructure.Decode(command.Value, &runCommand)
+	if err != nil {
+		return newOperationError("edgeStackRun", "n/a", err)
+	}
+
+	// The command runs for up to its timeout while the next commands are processed, its result and its error are
+	// reported by the stack manager
+	go func() {
+		service.stackCommands <- struct{}{}
+		defer func() { <-service.stackCommands }()
+
+		_ = service.edgeStackManager.RunStackCommand(ctx, runCommand)
+	}()
+
+	return nil
+}
+
+func (service *PollService) processStackBarrierCommand(command client.AsyncCommand) error {
+	var barrierCommand client.StackBarrierCommandData
+	err := mapstructure.Decode(command.Value, &barrierCommand)
+	if err != nil {
+		return newOperationError("edgeStackBarrier", "n/a", err)
+	}
+
+	err = service.edgeStackManager.ReleaseStackBarrier(barrierCommand.StackID, barrierCommand.Version, time.Unix(barrierCommand.ReleaseAt, 0))
+
+	return newOperationError("edgeStackBarrier", command.Operation, err)
+}
+
+func (service *PollService) processClusterKeyCommand(command client.AsyncCommand) error {
+	var keyCommand client.ClusterKeyCommandData
+	err := mapstructure.Decode(command.Value, &keyCommand)
+	if err != nil {
+		return newOperationError("clusterKey", "n/a", err)
+	}
+
+	if service.edgeManager.clusterService == nil {
+		return newOperationError("clusterKey", command.Operation, errors.New("the agent is not part of a cluster"))
+	}
+
+	overlap := serf.DefaultKeyRotationOverlap
+	if keyCommand.Overlap > 0 {
+		overlap = time.Duration(keyCommand.Overlap) * time.Second
+	}
+
+	err = service.edgeManager.clusterService.RotateEncryptionKey(keyCommand.Key, overlap)
+
+	return newOperationError("clusterKey", command.Operation, err)
+}
+
+func (service *PollService) processFreezeCommand(command client.AsyncCommand) error {
+	var freezeCommand client.FreezeCommandData
+	err := mapstructure.Decode(command.Value, &freezeCommand)
+	if err != nil {
+		return newOperationError("freeze", "n/a", err)
+	}
+
+	switch command.Operation {
+	case "add", "replace":
+		err = freeze.Add(service.dataPath, freeze.Window{
+			StackID: freezeCommand.StackID,
+			Until:   time.Unix(freezeCommand.Until, 0),
+			Reason:  freezeCommand.Reason,
+		})
+	case "remove":
+		err = freeze.Remove(service.dataPath, freezeCommand.StackID)
+	default:
+		err = errors.New("operation not supported")
+	}
+
+	return newOperationError("freeze", command.Operation, err)
+}
+
+func (service *PollService) processFileTransferCommand(command client.AsyncCommand) error {
+	var transferCommand client.FileTransferCommandData
+	err := mapstructure.Decode(command.Value, &transferCommand)
+	if err != nil {
+		return newOperationError("fileTransfer", "n/a", err)
+	}
+
+	result := client.FileTransferResult{Direction: command.Operation, Path: transferCommand.Path}
+
+	switch {
+	case service.fileTransfers == nil:
+		err = errors.New("file transfers are disabled on the device")
+	case command.Operation == filetransfer.Push:
+		var content []byte
+		if content, err = base64.StdEncoding.DecodeString(transferCommand.Content); err == nil {
+			err = service.fileTransfers.Push(transferCommand.ID, transferCommand.Path, content, transferCommand.Checksum, os.FileMode(transferCommand.Mode))
+			result.Size, result.Checksum = int64(len(content)), transferCommand.Checksum
+		}
+	case command.Operation == filetransfer.Pull:
+		var content []byte
+		if content, result.Checksum, err = service.fileTransfers.Pull(transferCommand.ID, transferCommand.Path); err == nil {
+			result.Size, result.Content = int64(len(content)), base64.StdEncoding.EncodeToString(content)
+		}
+	default:
+		err = errors.New("operation not supported")
+	}
+
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	if resultErr := service.portainerClient.SetFileTransferResult(transferCommand.ID, result); resultErr != nil {
+		log.Error().Int("transfer_id", transferCommand.ID).Err(resultErr).Msg("unable to report the result of the file transfer")
+	}
+
+	return newOperationError("fileTransfer", command.Operation, err)
+}
+
+func (service *PollService) processBackupCommand(command client.AsyncCommand) error {
+	var backupCommand client.BackupCommandData
+	err := mapstructure.Decode(command.Value, &backupCommand)
+	if err != nil {
+		return newOperationError("backup", "n/a", err)
+	}
+
+	result := client.BackupResult{Operation: command.Operation}
+
+	var manifest *backup.Manifest
+
+	switch {
+	case service.backup == nil:
+		err = errors.New("backups are disabled on the device")
+	case command.Operation == backup.Export:
+		var archive bytes.Buffer
+		if manifest, err = backup.Write(&archive, *service.backup, service.clock.Now()); err == nil {
+			result.Size, result.Checksum = int64(archive.Len()), filetransfer.Checksum(archive.Bytes())
+			result.Content = base64.StdEncoding.EncodeToString(archive.Bytes())
+		}
+	case command.Operation == backup.Import:
+		var content []byte
+		if content, err = base64.StdEncoding.DecodeString(backupCommand.Content); err != nil {
+			break
+		}
+
+		result.Size, result.Checksum = int64(len(content)), filetransfer.Checksum(content)
+		if result.Checksum != backupCommand.Checksum {
+			err = errors.New("checksum mismatch")
+
+			break
+		}
+
+		if manifest, err = backup.Read(bytes.NewReader(content), service.backup.DataPath, service.backup.StacksPath); err == nil {
+			result.Settings = manifest.Settings
+		}
+	default:
+		err = errors.New("operation not supported")
+	}
+
+	if manifest != nil {
+		for _, s := range manifest.Stacks {
+			result.Stacks = append(result.Stacks, s.ID)
+		}
+	}
+
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	if resultErr := service.portainerClient.SetBackupResult(backupCommand.ID, result); resultErr != nil {
+		log.Error().Int("backup_id", backupCommand.ID).Err(resultErr).Msg("unable to report the result of the backup")
+	}
+
+	return newOperationError("backup", command.Operation, err)
+}
+
+func (service *PollService) processHostUpdateCommand(command client.AsyncCommand) error {
+	var updateCommand client.HostUpdateCommandData
+	err := mapstructure.Decode(command.Value, &updateCommand)
+	if err != nil {
+		return newOperationError("hostUpdate", "n/a", err)
+	}
+
+	if service.hostInfo == nil {
+		return newOperationError("hostUpdate", command.Operation, errors.New("the host information is not collected"))
+	}
+
+	// The status of the update is reported with the information of the host
+	err = service.hostInfo.Update(updateCommand.ID, updateCommand.Action)
+
+	return newOperationError("hostUpdate", command.Operation, err)
+}
+
+func (service *PollService) processPowerCommand(command client.AsyncCommand) error {
+	var powerCommand client.PowerCommandData
+	err := mapstructure.Decode(command.Value, &powerCommand)
+	if err != nil {
+		return newOperationError("power", "n/a", err)
+	}
+
+	// The refusals and the failures are reported by the power manager
+	err = service.power.Request(powerCommand.ID, command.Operation, time.Duration(powerCommand.GracePeriod)*time.Second)
+
+	return newOperationError("power", command.Operation, err)
+}
+
+func (service *PollService) processStackBatchCommand(ctx context.Context, command client.AsyncCommand) error {
+	var batchCommand client.StackBatchCommandData
+	err := mapstructure.Decode(command.Value, &batchCommand)
+	if err != nil {
+		return newOperationError("edgeStackBatch", "n/a", err)
+	}
+
+	service.edgeStackManager.BeginStackBatch(batchCommand.BatchID, batchCommand.Stacks)
+
+	for _, stackData := range batchCommand.Stacks {
+		if err := service.portainerClient.SetEdgeStackStatus(stackData.ID, portainer.EdgeStackStatusAcknowledged, stackData.RollbackTo, ""); err != nil {
+			return newOperationError("edgeStackBatch", command.Operation, err)
+		}
+
+		if err := service.edgeStackManager.DeployStack(ctx, stackData); err != nil {
+			err = fmt.Errorf("failed to deploy stack %d: %w", stackData.ID, err)
+			service.edgeStackManager.AbortStackBatch(batchCommand.BatchID, err.Error())
+
+			return newOperationError("edgeStackBatch", command.Operation, err)
+		}
+
+		if err := service.portainerClient.SetEdgeStackStatus(stackData.ID, portainer.EdgeStackStatusDeploying, stackData.RollbackTo, ""); err != nil {
+			return newOperationError("edgeStackBatch", command.Operation, err)
+		}
+	}
+
+	return nil
+}
+
 func (service *PollService) processEdgeConfigCommand(cmd client.AsyncCommand) error {
 	var configData client.EdgeConfig
 	err := mapstructure.Decode(cmd.Value, &configData)
@@ -0,0 +1,57 @@
+package edge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/agent/edge/stack"
+	"github.com/portainer/agent/internals/mocks"
+
+	"github.com/stretchr/testify/assert"
+	gomock "go.uber.org/mock/gomock"
+)
+
+func TestPollService_processStackRunCommand(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClient := mocks.NewMockPortainerClient(ctrl)
+
+	service := &PollService{
+		portainerClient:  mockClient,
+		edgeStackManager: stack.NewStackManager(mockClient, "", nil, "edge-id"),
+		stackCommands:    make(chan struct{}, 1),
+	}
+
+	reported := make(chan int, 2)
+	release := make(chan struct{})
+
+	// The commands of unknown stacks fail right away, their reports are held until released
+	mockClient.EXPECT().SetStackCommandResult(gomock.Any(), gomock.Any()).DoAndReturn(func(commandID int, result client.StackCommandResult) error {
+		reported <- commandID
+		<-release
+
+		return nil
+	}).Times(2)
+	mockClient.EXPECT().SetLastCommandTimestamp(gomock.Any()).Times(2)
+
+	// The commands are acknowledged without waiting for them
+	service.processAsyncCommands([]client.AsyncCommand{
+		{Type: "edgeStackRun", Value: map[string]any{"ID": 1, "StackID": 10, "Service": "web"}},
+		{Type: "edgeStackRun", Value: map[string]any{"ID": 2, "StackID": 10, "Service": "web"}},
+	})
+
+	first := <-reported
+
+	// The second command waits for the first one to finish
+	select {
+	case <-reported:
+		assert.Fail(t, "the commands ran at the same time")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+
+	assert.ElementsMatch(t, []int{1, 2}, []int{first, <-reported})
+}
@@ -0,0 +1,105 @@
+package power
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// window is a daily window of the critical hours, as offsets from midnight. The windows ending before they start span
+// midnight.
+type window struct {
+	start time.Duration
+	end   time.Duration
+}
+
+// CriticalHours are the daily windows, in the local time of the device, during which it refuses to reboot or shut down
+type CriticalHours []window
+
+// ParseCriticalHours parses a comma-separated list of HH:MM-HH:MM windows, e.g. 08:00-18:00,22:00-02:00
+func ParseCriticalHours(value string) (CriticalHours, error) {
+	var hours CriticalHours
+
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		from, to, ok := strings.Cut(item, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid critical hours %q, expected HH:MM-HH:MM", item)
+		}
+
+		start, err := parseClock(from)
+		if err != nil {
+			return nil, fmt.Errorf("invalid critical hours %q: %w", item, err)
+		}
+
+		end, err := parseClock(to)
+		if err != nil {
+			return nil, fmt.Errorf("invalid critical hours %q: %w", item, err)
+		}
+
+		if start == end {
+			return nil, fmt.Errorf("invalid critical hours %q, the window is empty", item)
+		}
+
+		hours = append(hours, window{start: start, end: end})
+	}
+
+	return hours, nil
+}
+
+func parseClock(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Contains returns true when t is within the critical hours
+func (hours CriticalHours) Contains(t time.Time) bool {
+	offset := sinceMidnight(t)
+
+	for _, w := range hours {
+		if w.start < w.end && offset >= w.start && offset < w.end {
+			return true
+		}
+
+		if w.start > w.end && (offset >= w.start || offset < w.end) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Overlaps returns true when a part of the period from start to end is within the critical hours
+func (hours CriticalHours) Overlaps(start, end time.Time) bool {
+	if hours.Contains(start) {
+		return true
+	}
+
+	offset := sinceMidnight(start)
+
+	for _, w := range hours {
+		// The next start of the window after the start of the period
+		next := w.start - offset
+		if next <= 0 {
+			next += 24 * time.Hour
+		}
+
+		if !start.Add(next).After(end) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func sinceMidnight(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+}
@@ -0,0 +1,290 @@
+package power
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/portainer/agent/edge/client"
+
+	"github.com/rs/zerolog/log"
+)
+
+// The actions of the power instructions
+const (
+	ActionReboot   = "reboot"
+	ActionShutdown = "shutdown"
+)
+
+const (
+	// fileName is the file of the data folder the pending operation is kept in, to confirm it once the device booted
+	fileName = "power.json"
+	// bootIDPath changes at each boot of the host, it is shared by the host and the agent container
+	bootIDPath = "/proc/sys/kernel/random/boot_id"
+	// commandTimeout bounds each of the commands rebooting or shutting down the host
+	commandTimeout = time.Minute
+)
+
+var (
+	// ErrCriticalHours is returned when the reboot or the shutdown would happen during the critical hours of the device
+	ErrCriticalHours = errors.New("the device does not reboot or shut down during its critical hours")
+	// ErrPending is returned when a reboot or a shutdown is requested while another one is pending
+	ErrPending = errors.New("a reboot or a shutdown is already pending")
+)
+
+// Operation is a reboot or a shutdown of the device, kept in the data folder until the device booted again
+type Operation struct {
+	ID          int
+	Action      string
+	RequestedAt int64
+	ScheduledAt int64
+	// BootID identifies the boot of the host the operation was requested during
+	BootID string
+}
+
+// Manager reboots and shuts down the device on the instructions of the Portainer server. The stacks are stopped during
+// the grace period, and the device confirms it booted again once the agent restarted.
+type Manager struct {
+	dataPath        string
+	hostRoot        string
+	criticalHours   CriticalHours
+	portainerClient client.PortainerClient
+	stopStacks      func(ctx context.Context) error
+	run             func(ctx context.Context, action string) error
+	bootID          func() (string, error)
+	now             func() time.Time
+	pending         bool
+	checked         bool
+	mu              sync.Mutex
+}
+
+// NewManager returns a pointer to a new instance of Manager for the host mounted at hostRoot. The device refuses the
+// instructions during the critical hours, stopStacks stops the stacks during the grace period and can be nil.
+func NewManager(dataPath, hostRoot string, criticalHours CriticalHours, portainerClient client.PortainerClient, stopStacks func(ctx context.Context) error) *Manager {
+	manager := &Manager{
+		dataPath:        dataPath,
+		hostRoot:        hostRoot,
+		criticalHours:   criticalHours,
+		portainerClient: portainerClient,
+		stopStacks:      stopStacks,
+		bootID:          readBootID,
+		now:             time.Now,
+	}
+	manager.run = manager.runOnHost
+
+	return manager
+}
+
+// Request reboots or shuts down the device once the grace period is over. The request is refused when a part of the
+// grace period falls within the critical hours of the device.
+func (manager *Manager) Request(id int, action string, grace time.Duration) error {
+	if action != ActionReboot && action != ActionShutdown {
+		return fmt.Errorf("unknown power action %q, expected %s or %s", action, ActionReboot, ActionShutdown)
+	}
+
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	if manager.pending {
+		return ErrPending
+	}
+
+	now := manager.now()
+	scheduledAt := now.Add(grace)
+
+	if manager.criticalHours.Overlaps(now, scheduledAt) {
+		manager.report(client.PowerStatus{ID: id, Action: action, Status: client.StatusPowerRefused, Error: ErrCriticalHours.Error()})
+
+		return ErrCriticalHours
+	}
+
+	bootID, err := manager.bootID()
+	if err != nil {
+		log.Warn().Err(err).Msg("unable to retrieve the boot identifier of the host, the reboot cannot be confirmed")
+	}
+
+	operation := Operation{
+		ID:          id,
+		Action:      action,
+		RequestedAt: now.Unix(),
+		ScheduledAt: scheduledAt.Unix(),
+		BootID:      bootID,
+	}
+
+	if err := manager.save(operation); err != nil {
+		manager.report(client.PowerStatus{ID: id, Action: action, Status: client.StatusPowerFailed, Error: err.Error()})
+
+		return err
+	}
+
+	status := client.StatusPendingReboot
+	if action == ActionShutdown {
+		status = client.StatusPendingShutdown
+	}
+
+	manager.report(client.PowerStatus{ID: id, Action: action, Status: status, ScheduledAt: operation.ScheduledAt})
+
+	manager.pending = true
+
+	go manager.execute(operation, scheduledAt)
+
+	return nil
+}
+
+func (manager *Manager) execute(operation Operation, scheduledAt time.Time) {
+	log.Info().Int("power_id", operation.ID).Str("action", operation.Action).Time("scheduled_at", scheduledAt).Msg("stopping the stacks before the power action")
+
+	ctx, cancel := context.WithDeadline(context.Background(), scheduledAt)
+	defer cancel()
+
+	if manager.stopStacks != nil {
+		// The device reboots at the end of the grace period whether the stacks are stopped or not
+		if err := manager.stopStacks(ctx); err != nil {
+			log.Error().Int("power_id", operation.ID).Err(err).Msg("unable to stop the stacks cleanly")
+		}
+	}
+
+	<-ctx.Done()
+
+	log.Info().Int("power_id", operation.ID).Str("action", operation.Action).Msg("running the power action")
+
+	err := manager.run(context.Background(), operation.Action)
+	if err == nil {
+		return
+	}
+
+	log.Error().Int("power_id", operation.ID).Str("action", operation.Action).Err(err).Msg("unable to run the power action")
+
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	manager.pending = false
+	manager.remove()
+	manager.report(client.PowerStatus{ID: operation.ID, Action: operation.Action, Status: client.StatusPowerFailed, Error: err.Error()})
+}
+
+// ReportBoot confirms the reboot or the shutdown of the device once it booted again. The operation is reported as
+// failed when the host did not boot since it was requested, i.e. only the agent restarted.
+func (manager *Manager) ReportBoot() {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	if manager.checked || manager.pending {
+		return
+	}
+
+	operation, err := manager.load()
+	if errors.Is(err, os.ErrNotExist) {
+		manager.checked = true
+
+		return
+	} else if err != nil {
+		log.Error().Err(err).Msg("unable to read the pending power action")
+
+		return
+	}
+
+	status := client.PowerStatus{ID: operation.ID, Action: operation.Action, Status: client.StatusBooted}
+
+	bootID, err := manager.bootID()
+	if err == nil && operation.BootID != "" && bootID == operation.BootID {
+		status.Status, status.Error = client.StatusPowerFailed, "the agent restarted before the device rebooted"
+	}
+
+	if !manager.report(status) {
+		return
+	}
+
+	manager.remove()
+	manager.checked = true
+}
+
+// report returns false when the status could not be sent to the Portainer server
+func (manager *Manager) report(status client.PowerStatus) bool {
+	status.Time = manager.now().Unix()
+
+	if err := manager.portainerClient.SetPowerStatus(status); err != nil {
+		log.Error().Int("power_id", status.ID).Str("status", status.Status).Err(err).Msg("unable to report the status of the power action")
+
+		return false
+	}
+
+	return true
+}
+
+func (manager *Manager) save(operation Operation) error {
+	data, err := json.Marshal(operation)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(manager.dataPath, fileName), data, 0600)
+}
+
+func (manager *Manager) load() (Operation, error) {
+	var operation Operation
+
+	data, err := os.ReadFile(filepath.Join(manager.dataPath, fileName))
+	if err != nil {
+		return operation, err
+	}
+
+	return operation, json.Unmarshal(data, &operation)
+}
+
+func (manager *Manager) remove() {
+	if err := os.Remove(filepath.Join(manager.dataPath, fileName)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Error().Err(err).Msg("unable to remove the pending power action")
+	}
+}
+
+// runOnHost reboots or shuts down the host with systemd, then with shutdown when systemd is not available. The
+// commands run inside the root of the host when it is mounted in the agent container.
+func (manager *Manager) runOnHost(ctx context.Context, action string) error {
+	commands := [][]string{{"systemctl", "reboot"}, {"shutdown", "-r", "now"}}
+	if action == ActionShutdown {
+		commands = [][]string{{"systemctl", "poweroff"}, {"shutdown", "-h", "now"}}
+	}
+
+	var errs []error
+
+	for _, command := range commands {
+		ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+
+		var cmd *exec.Cmd
+		if root := filepath.Clean(manager.hostRoot); root != "/" {
+			cmd = exec.CommandContext(ctx, "chroot", append([]string{root}, command...)...)
+		} else {
+			cmd = exec.CommandContext(ctx, command[0], command[1:]...)
+		}
+
+		cmd.Env = []string{"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"}
+
+		output, err := cmd.CombinedOutput()
+		cancel()
+
+		if err == nil {
+			return nil
+		}
+
+		errs = append(errs, fmt.Errorf("%s: %w: %s", strings.Join(command, " "), err, strings.TrimSpace(string(output))))
+	}
+
+	return errors.Join(errs...)
+}
+
+func readBootID() (string, error) {
+	data, err := os.ReadFile(bootIDPath)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,169 @@
+package power
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/agent/internals/mocks"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gomock "go.uber.org/mock/gomock"
+)
+
+func at(clock string) time.Time {
+	t, err := time.ParseInLocation("2006-01-02 15:04", "2024-05-06 "+clock, time.Local)
+	if err != nil {
+		panic(err)
+	}
+
+	return t
+}
+
+func TestParseCriticalHours(t *testing.T) {
+	hours, err := ParseCriticalHours("08:00-18:00, 22:00-02:00")
+	require.NoError(t, err)
+
+	assert.True(t, hours.Contains(at("08:00")))
+	assert.True(t, hours.Contains(at("17:59")))
+	assert.False(t, hours.Contains(at("18:00")))
+	assert.True(t, hours.Contains(at("23:30")))
+	assert.True(t, hours.Contains(at("01:00")))
+	assert.False(t, hours.Contains(at("03:00")))
+
+	assert.True(t, hours.Overlaps(at("07:50"), at("08:10")))
+	assert.False(t, hours.Overlaps(at("18:00"), at("21:59")))
+	assert.True(t, hours.Overlaps(at("18:00"), at("22:00")))
+
+	empty, err := ParseCriticalHours("")
+	require.NoError(t, err)
+	assert.False(t, empty.Overlaps(at("00:00"), at("23:59")))
+
+	for _, value := range []string{"08:00", "8h-18h", "25:00-02:00", "08:00-08:00"} {
+		_, err := ParseCriticalHours(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func newTestManager(t *testing.T, portainerClient client.PortainerClient, now time.Time) (*Manager, chan string) {
+	hours, err := ParseCriticalHours("08:00-18:00")
+	require.NoError(t, err)
+
+	manager := NewManager(t.TempDir(), "/", hours, portainerClient, nil)
+	manager.now = func() time.Time { return now }
+	manager.bootID = func() (string, error) { return "boot-1", nil }
+
+	actions := make(chan string, 1)
+	manager.run = func(ctx context.Context, action string) error {
+		actions <- action
+
+		return nil
+	}
+
+	return manager, actions
+}
+
+func TestManager_Request(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClient := mocks.NewMockPortainerClient(ctrl)
+
+	now := at("19:00")
+	manager, actions := newTestManager(t, mockClient, now)
+
+	stopped := make(chan struct{})
+	manager.stopStacks = func(ctx context.Context) error {
+		close(stopped)
+
+		return nil
+	}
+
+	mockClient.EXPECT().SetPowerStatus(client.PowerStatus{ID: 1, Action: ActionReboot, Status: client.StatusPendingReboot, ScheduledAt: now.Unix(), Time: now.Unix()})
+
+	require.NoError(t, manager.Request(1, ActionReboot, 0))
+	assert.FileExists(t, filepath.Join(manager.dataPath, fileName))
+
+	<-stopped
+	assert.Equal(t, ActionReboot, <-actions)
+
+	assert.ErrorIs(t, manager.Request(2, ActionShutdown, 0), ErrPending)
+
+	// The agent restarted once the device booted again
+	manager.pending = false
+	manager.bootID = func() (string, error) { return "boot-2", nil }
+
+	mockClient.EXPECT().SetPowerStatus(client.PowerStatus{ID: 1, Action: ActionReboot, Status: client.StatusBooted, Time: now.Unix()})
+
+	manager.ReportBoot()
+	manager.ReportBoot()
+	assert.NoFileExists(t, filepath.Join(manager.dataPath, fileName))
+}
+
+func TestManager_RequestCriticalHours(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClient := mocks.NewMockPortainerClient(ctrl)
+
+	now := at("07:30")
+	manager, _ := newTestManager(t, mockClient, now)
+
+	mockClient.EXPECT().SetPowerStatus(client.PowerStatus{ID: 1, Action: ActionShutdown, Status: client.StatusPowerRefused, Time: now.Unix(), Error: ErrCriticalHours.Error()})
+
+	// The grace period ends during the critical hours
+	assert.ErrorIs(t, manager.Request(1, ActionShutdown, time.Hour), ErrCriticalHours)
+	assert.NoFileExists(t, filepath.Join(manager.dataPath, fileName))
+	assert.False(t, manager.pending)
+}
+
+func TestManager_RequestFailed(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClient := mocks.NewMockPortainerClient(ctrl)
+
+	now := at("19:00")
+	manager, _ := newTestManager(t, mockClient, now)
+
+	reported := make(chan struct{})
+	manager.run = func(ctx context.Context, action string) error {
+		return errors.New("systemctl not found")
+	}
+
+	gomock.InOrder(
+		mockClient.EXPECT().SetPowerStatus(gomock.Any()),
+		mockClient.EXPECT().SetPowerStatus(client.PowerStatus{ID: 1, Action: ActionShutdown, Status: client.StatusPowerFailed, Time: now.Unix(), Error: "systemctl not found"}).
+			Do(func(client.PowerStatus) { close(reported) }),
+	)
+
+	require.NoError(t, manager.Request(1, ActionShutdown, 0))
+	<-reported
+
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	assert.False(t, manager.pending)
+	assert.NoFileExists(t, filepath.Join(manager.dataPath, fileName))
+}
+
+func TestManager_ReportBootAgentRestarted(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClient := mocks.NewMockPortainerClient(ctrl)
+
+	now := at("19:00")
+	manager, _ := newTestManager(t, mockClient, now)
+
+	require.NoError(t, manager.save(Operation{ID: 3, Action: ActionReboot, BootID: "boot-1"}))
+
+	mockClient.EXPECT().SetPowerStatus(client.PowerStatus{ID: 3, Action: ActionReboot, Status: client.StatusPowerFailed, Time: now.Unix(), Error: "the agent restarted before the device rebooted"})
+
+	manager.ReportBoot()
+	assert.NoFileExists(t, filepath.Join(manager.dataPath, fileName))
+}
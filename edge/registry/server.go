@@ -4,6 +4,7 @@ import (
 	"errors"
 	"net/http"
 	"net/url"
+	"os"
 	"strings"
 	"time"
 
@@ -69,31 +70,27 @@ func (handler *Handler) LookupHandler(rw http.ResponseWriter, r *http.Request) *
 		}
 	}
 
-	credentials := stackManager.GetEdgeRegistryCredentials()
-	if len(credentials) > 0 {
-		var key string
-		if strings.HasPrefix(serverUrl, "http") {
-			u, err := url.Parse(serverUrl)
-			if err != nil {
-				return httperror.BadRequest("Invalid server URL", err)
-			}
-
-			if strings.HasSuffix(u.Hostname(), "docker.io") {
-				key = "docker.io"
-			} else {
-				key = u.Hostname()
-			}
-		} else {
-			key = serverUrl
+	key := serverUrl
+	if strings.HasPrefix(serverUrl, "http") {
+		u, err := url.Parse(serverUrl)
+		if err != nil {
+			return httperror.BadRequest("Invalid server URL", err)
 		}
 
-		for _, c := range credentials {
-			if key == c.ServerURL {
-				return response.JSON(rw, c)
-			}
+		if strings.HasSuffix(u.Hostname(), "docker.io") {
+			key = "docker.io"
+		} else {
+			key = u.Hostname()
 		}
 	}
 
+	// The stack identifier is reported by the credential helper when the pull is run by the agent
+	stackID, _ := request.RetrieveNumericQueryParameter(r, "stackid", true)
+
+	if c := stackManager.GetEdgeRegistryCredentials(stackID, key); c != nil {
+		return response.JSON(rw, c)
+	}
+
 	return response.Empty(rw)
 }
 
@@ -102,8 +99,13 @@ func StartRegistryServer(edgeManager *edge.Manager, awsConfig *agent.AWSConfig)
 
 	h := NewEdgeRegistryHandler(edgeManager, awsConfig)
 
+	addr := os.Getenv(agent.RegistryServerAddrEnvVarName)
+	if addr == "" {
+		addr = agent.DefaultRegistryServerAddr
+	}
+
 	server := &http.Server{
-		Addr:         "127.0.0.1:9005",
+		Addr:         addr,
 		WriteTimeout: time.Second * 15,
 		ReadTimeout:  time.Second * 15,
 		IdleTimeout:  time.Second * 60,
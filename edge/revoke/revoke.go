@@ -18,6 +18,7 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/ocsp"
 )
 
 const (
@@ -32,7 +33,10 @@ type Service struct {
 	hardFail bool
 	// crlSet associates a PKIX certificate list with the URL the CRL is
 	// fetched from.
-	crlSet  map[string]*pkix.CertificateList
+	crlSet map[string]*pkix.CertificateList
+	// ocspSet associates an OCSP response with the serial number of the
+	// certificate it is about, until its next update.
+	ocspSet map[string]*ocsp.Response
 	crlLock sync.Mutex
 }
 
@@ -43,12 +47,46 @@ func NewService() *Service {
 		},
 		hardFail: false,
 		crlSet:   make(map[string]*pkix.CertificateList),
+		ocspSet:  make(map[string]*ocsp.Response),
 	}
 }
 
+// SetHardFail determines whether the failure to check the revocation status
+// of a certificate causes its verification to fail.
+func (service *Service) SetHardFail(hardFail bool) {
+	service.hardFail = hardFail
+}
+
+// VerifyChain verifies each certificate of a verified chain, the OCSP
+// requests of a certificate use the next certificate of the chain as its
+// issuer.
+func (service *Service) VerifyChain(chain []*x509.Certificate) error {
+	for i, cert := range chain {
+		var issuer *x509.Certificate
+		if i+1 < len(chain) {
+			issuer = chain[i+1]
+		}
+
+		revoked, err := service.verifyCertificate(cert, issuer)
+		if revoked {
+			if err != nil {
+				return errors.Wrap(err, "certificate has been revoked")
+			}
+
+			return errors.New("certificate has been revoked")
+		}
+	}
+
+	return nil
+}
+
 // VerifyCertificate ensures that the certificate passed in hasn't
 // expired and checks the CRL for the server.
 func (service *Service) VerifyCertificate(cert *x509.Certificate) (revoked bool, err error) {
+	return service.verifyCertificate(cert, nil)
+}
+
+func (service *Service) verifyCertificate(cert, issuer *x509.Certificate) (revoked bool, err error) {
 	// certificate expired
 	if !time.Now().Before(cert.NotAfter) {
 		log.Info().Time("not_after", cert.NotAfter).Msg("certificate expired")
@@ -63,11 +101,12 @@ func (service *Service) VerifyCertificate(cert *x509.Certificate) (revoked bool,
 		return true, fmt.Errorf("certificate isn't valid until %s", cert.NotBefore)
 	}
 
-	return service.revCheck(cert)
+	return service.revCheck(cert, issuer)
 }
 
-// revCheck should check the certificate for any revocations.
-func (service *Service) revCheck(cert *x509.Certificate) (revoked bool, err error) {
+// revCheck should check the certificate for any revocations, through its
+// CRLs then its OCSP responders. The issuer is fetched when it is nil.
+func (service *Service) revCheck(cert, issuer *x509.Certificate) (revoked bool, err error) {
 	for _, url := range cert.CRLDistributionPoints {
 		if ldapURL(url) {
 			log.Info().Str("url", url).Msg("skipping LDAP CRL")
@@ -89,7 +128,32 @@ func (service *Service) revCheck(cert *x509.Certificate) (revoked bool, err erro
 		}
 	}
 
-	return false, nil
+	if len(cert.OCSPServer) == 0 {
+		return false, nil
+	}
+
+	if issuer == nil {
+		issuer = service.getIssuer(cert)
+	}
+
+	if issuer == nil {
+		log.Warn().Msg("unable to check the revocation via OCSP, the issuer is unknown")
+
+		return service.hardFail, errors.New("unknown issuer of the certificate")
+	}
+
+	revoked, err = service.certIsRevokedOCSP(cert, issuer)
+	if err != nil {
+		log.Warn().Err(err).Msg("error checking revocation via OCSP")
+
+		return service.hardFail, err
+	}
+
+	if revoked {
+		log.Info().Msg("certificate is revoked via OCSP")
+	}
+
+	return revoked, nil
 }
 
 // We can't handle LDAP certificates, so this checks to see if the
@@ -158,6 +222,75 @@ func (service *Service) certIsRevokedCRL(cert *x509.Certificate, url string) (re
 	return false, nil
 }
 
+// certIsRevokedOCSP asks the OCSP responders of the certificate about its
+// status, the responses are cached until their next update.
+func (service *Service) certIsRevokedOCSP(cert, issuer *x509.Certificate) (revoked bool, err error) {
+	key := cert.SerialNumber.String()
+
+	service.crlLock.Lock()
+	response, ok := service.ocspSet[key]
+	service.crlLock.Unlock()
+
+	if ok && time.Now().Before(response.NextUpdate) {
+		return response.Status == ocsp.Revoked, nil
+	}
+
+	request, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return false, err
+	}
+
+	err = errors.New("no OCSP responder knows the certificate")
+
+	for _, url := range cert.OCSPServer {
+		var response *ocsp.Response
+
+		response, err = service.fetchOCSP(url, request, cert, issuer)
+		if err != nil {
+			log.Warn().Str("url", url).Err(err).Msg("failed fetching OCSP response")
+
+			continue
+		}
+
+		if response.Status == ocsp.Unknown {
+			err = errors.New("the OCSP responder does not know the certificate")
+
+			continue
+		}
+
+		if !response.NextUpdate.IsZero() {
+			service.crlLock.Lock()
+			service.ocspSet[key] = response
+			service.crlLock.Unlock()
+		}
+
+		return response.Status == ocsp.Revoked, nil
+	}
+
+	return false, err
+}
+
+// fetchOCSP posts an OCSP request and parses the response.
+func (service *Service) fetchOCSP(url string, request []byte, cert, issuer *x509.Certificate) (*ocsp.Response, error) {
+	resp, err := service.httpClient.Post(url, "application/ocsp-request", bytes.NewReader(request))
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return nil, errors.New("failed to retrieve OCSP response")
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return ocsp.ParseResponseForCert(body, cert, issuer)
+}
+
 // fetchCRL fetches and parses a CRL.
 func (service *Service) fetchCRL(url string) (*pkix.CertificateList, error) {
 	resp, err := service.httpClient.Get(url)
@@ -0,0 +1,199 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/portainer/agent"
+	"github.com/portainer/agent/clock"
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/agent/edge/jobhistory"
+
+	"github.com/rs/zerolog/log"
+)
+
+// historyInterval is how often the executions of the jobs are collected into the job history
+const historyInterval = time.Minute
+
+// HistoryRecorder collects the executions of the jobs recorded by the wrappers of their cron entries into
+// the job history, and reports them to the Portainer server when enabled. The executions failing after all
+// their attempts are always reported as failures of the jobs.
+type HistoryRecorder struct {
+	store           *jobhistory.Store
+	portainerClient client.PortainerClient
+	reportHistory   bool
+	scriptsPath     string
+	clock           agent.Clock
+}
+
+// NewHistoryRecorder returns a pointer to a new instance of HistoryRecorder, the executions are not kept when
+// store is nil and are only reported to the Portainer server when reportHistory is true
+func NewHistoryRecorder(store *jobhistory.Store, portainerClient client.PortainerClient, reportHistory bool) *HistoryRecorder {
+	return &HistoryRecorder{
+		store:           store,
+		portainerClient: portainerClient,
+		reportHistory:   reportHistory,
+		scriptsPath:     filepath.Join(agent.HostRoot, agent.ScheduleScriptDirectory),
+		clock:           clock.NewSystemClock(),
+	}
+}
+
+func (recorder *HistoryRecorder) Start() {
+	log.Debug().Msg("job history recorder started")
+
+	go func() {
+		for {
+			<-recorder.clock.After(historyInterval)
+
+			recorder.Collect()
+		}
+	}()
+}
+
+// Collect moves the executions recorded since the previous collection into the job history. The log file of a job
+// only holds the output of its last execution, the output is attached to the last execution collected.
+func (recorder *HistoryRecorder) Collect() {
+	files, err := filepath.Glob(filepath.Join(recorder.scriptsPath, "schedule_*.runs"))
+	if err != nil {
+		log.Error().Err(err).Msg("unable to find the executions of the jobs")
+
+		return
+	}
+
+	for _, file := range files {
+		jobID, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(filepath.Base(file), "schedule_"), ".runs"))
+		if err != nil {
+			continue
+		}
+
+		executions, err := recorder.collectJob(jobID, file)
+		if err != nil {
+			log.Error().Int("job_identifier", jobID).Err(err).Msg("unable to collect the executions of the job")
+
+			continue
+		}
+
+		recorder.reportFailures(executions)
+
+		if recorder.store != nil {
+			if err := recorder.store.Add(executions...); err != nil {
+				log.Error().Int("job_identifier", jobID).Err(err).Msg("unable to record the executions of the job")
+
+				continue
+			}
+		}
+
+		if !recorder.reportHistory || recorder.portainerClient == nil || len(executions) == 0 {
+			continue
+		}
+
+		if err := recorder.portainerClient.SetEdgeJobHistory(jobID, executions); err != nil {
+			log.Error().Int("job_identifier", jobID).Err(err).Msg("unable to report the executions of the job")
+		}
+	}
+}
+
+// reportFailures reports the executions whose last attempt failed, unlike the logs of the jobs which are only
+// collected on demand
+func (recorder *HistoryRecorder) reportFailures(executions []agent.EdgeJobExecution) {
+	for _, execution := range executions {
+		if execution.ExitCode == 0 || execution.Attempt < execution.Attempts {
+			continue
+		}
+
+		log.Warn().
+			Int("job_identifier", execution.JobID).
+			Int("exit_code", execution.ExitCode).
+			Bool("timed_out", execution.TimedOut).
+			Int("attempts", execution.Attempts).
+			Msg("job failed")
+
+		if recorder.portainerClient == nil {
+			continue
+		}
+
+		if err := recorder.portainerClient.SetEdgeJobFailure(execution); err != nil {
+			log.Error().Int("job_identifier", execution.JobID).Err(err).Msg("unable to report the failure of the job")
+		}
+	}
+}
+
+func (recorder *HistoryRecorder) collectJob(jobID int, file string) ([]agent.EdgeJobExecution, error) {
+	// The file is moved away first so that the executions ending meanwhile are recorded in a new one
+	collecting := file + ".collecting"
+	if err := os.Rename(file, collecting); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(collecting)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Remove(collecting); err != nil {
+		return nil, err
+	}
+
+	executions, err := parseRuns(jobID, string(data))
+	if err != nil || len(executions) == 0 {
+		return executions, err
+	}
+
+	output, err := os.ReadFile(filepath.Join(recorder.scriptsPath, fmt.Sprintf("schedule_%d.log", jobID)))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	last := &executions[len(executions)-1]
+	last.Output, last.OutputTruncated = jobhistory.TruncateOutput(output)
+
+	return executions, nil
+}
+
+// parseRuns parses the lines written by the wrappers of the cron entries, the start and the end of an execution
+// as Unix times followed by its exit code, then the attempt, the number of attempts and whether it timed out.
+// The lines written by the wrappers without the attempts are executions attempted once.
+func parseRuns(jobID int, content string) ([]agent.EdgeJobExecution, error) {
+	var executions []agent.EdgeJobExecution
+
+	for _, line := range strings.Split(strings.TrimSpace(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if len(fields) != 3 && len(fields) != 6 {
+			return nil, fmt.Errorf("invalid execution record %q", line)
+		}
+
+		values := [6]int64{3: 1, 4: 1}
+		for i, field := range fields {
+			value, err := strconv.ParseInt(field, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid execution record %q: %w", line, err)
+			}
+
+			values[i] = value
+		}
+
+		start, end := time.Unix(values[0], 0).UTC(), time.Unix(values[1], 0).UTC()
+
+		executions = append(executions, agent.EdgeJobExecution{
+			JobID:    jobID,
+			Start:    start,
+			End:      end,
+			Duration: end.Sub(start),
+			ExitCode: int(values[2]),
+			Attempt:  int(values[3]),
+			Attempts: int(values[4]),
+			TimedOut: values[5] == 1,
+		})
+	}
+
+	return executions, nil
+}
@@ -0,0 +1,92 @@
+package scheduler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/portainer/agent"
+	"github.com/portainer/agent/edge/jobhistory"
+	"github.com/portainer/agent/internals/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gomock "go.uber.org/mock/gomock"
+)
+
+func TestParseRuns(t *testing.T) {
+	executions, err := parseRuns(3, "100 160 0\n200 205 2\n300 310 124 1 2 1\n")
+	require.NoError(t, err)
+	assert.Equal(t, []agent.EdgeJobExecution{
+		{JobID: 3, Start: time.Unix(100, 0).UTC(), End: time.Unix(160, 0).UTC(), Duration: time.Minute, ExitCode: 0, Attempt: 1, Attempts: 1},
+		{JobID: 3, Start: time.Unix(200, 0).UTC(), End: time.Unix(205, 0).UTC(), Duration: 5 * time.Second, ExitCode: 2, Attempt: 1, Attempts: 1},
+		{JobID: 3, Start: time.Unix(300, 0).UTC(), End: time.Unix(310, 0).UTC(), Duration: 10 * time.Second, ExitCode: 124, Attempt: 1, Attempts: 2, TimedOut: true},
+	}, executions)
+
+	_, err = parseRuns(3, "100 0\n")
+	assert.Error(t, err)
+}
+
+func TestHistoryRecorder_Collect(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	scriptsPath := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(scriptsPath, "schedule_1.runs"), []byte("100 160 0\n200 205 1\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(scriptsPath, "schedule_1.log"), []byte("disk full\n"), 0644))
+
+	store := jobhistory.NewStore(t.TempDir(), 10)
+	mockClient := mocks.NewMockPortainerClient(ctrl)
+
+	recorder := NewHistoryRecorder(store, mockClient, true)
+	recorder.scriptsPath = scriptsPath
+
+	expected := []agent.EdgeJobExecution{
+		{JobID: 1, Start: time.Unix(100, 0).UTC(), End: time.Unix(160, 0).UTC(), Duration: time.Minute, Attempt: 1, Attempts: 1},
+		{JobID: 1, Start: time.Unix(200, 0).UTC(), End: time.Unix(205, 0).UTC(), Duration: 5 * time.Second, ExitCode: 1, Attempt: 1, Attempts: 1, Output: "disk full\n"},
+	}
+
+	mockClient.EXPECT().SetEdgeJobFailure(expected[1])
+	mockClient.EXPECT().SetEdgeJobHistory(1, expected)
+
+	recorder.Collect()
+
+	executions, err := store.List(1)
+	require.NoError(t, err)
+	assert.Equal(t, expected, executions)
+
+	// The executions are only collected once
+	recorder.Collect()
+	assert.NoFileExists(t, filepath.Join(scriptsPath, "schedule_1.runs"))
+
+	executions, err = store.List(1)
+	require.NoError(t, err)
+	assert.Len(t, executions, 2)
+}
+
+func TestHistoryRecorder_CollectFailures(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	scriptsPath := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(scriptsPath, "schedule_2.runs"), []byte("100 130 124 1 2 1\n140 150 3 2 2 0\n"), 0644))
+
+	mockClient := mocks.NewMockPortainerClient(ctrl)
+
+	// Without the job history, only the last failed attempt is reported
+	recorder := NewHistoryRecorder(nil, mockClient, false)
+	recorder.scriptsPath = scriptsPath
+
+	mockClient.EXPECT().SetEdgeJobFailure(agent.EdgeJobExecution{
+		JobID:    2,
+		Start:    time.Unix(140, 0).UTC(),
+		End:      time.Unix(150, 0).UTC(),
+		Duration: 10 * time.Second,
+		ExitCode: 3,
+		Attempt:  2,
+		Attempts: 2,
+	})
+
+	recorder.Collect()
+	assert.NoFileExists(t, filepath.Join(scriptsPath, "schedule_2.runs"))
+}
@@ -0,0 +1,77 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+
+	"github.com/portainer/agent"
+)
+
+// ErrPrivilegedJob is returned for the jobs running as root when the agent does not allow it
+var ErrPrivilegedJob = errors.New("the job runs as root, which the agent does not allow")
+
+var (
+	identityPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*\$?$`)
+	envNamePattern  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// RunAsPolicy is the user the jobs run as when they do not set one, and whether they can run as root
+type RunAsPolicy struct {
+	DefaultUser string
+	AllowRoot   bool
+}
+
+// Resolve returns the identity the job runs with, with the default user when it does not set one. An
+// ErrPrivilegedJob error is returned when the job runs as root, or with the root group, and it is not allowed. The
+// other names of root are only known on the host, the wrapper of the job checks the resolved identity.
+func (policy RunAsPolicy) Resolve(runAs agent.JobRunAs) (agent.JobRunAs, error) {
+	if runAs.User == "" {
+		runAs.User = policy.DefaultUser
+	}
+
+	if runAs.User == "" {
+		runAs.User = "root"
+	}
+
+	if !identityPattern.MatchString(runAs.User) {
+		return runAs, fmt.Errorf("invalid user %q", runAs.User)
+	}
+
+	if runAs.Group != "" && !identityPattern.MatchString(runAs.Group) {
+		return runAs, fmt.Errorf("invalid group %q", runAs.Group)
+	}
+
+	if runAs.WorkingDir != "" && !path.IsAbs(runAs.WorkingDir) {
+		return runAs, fmt.Errorf("the working directory %q is not an absolute path", runAs.WorkingDir)
+	}
+
+	for _, name := range runAs.Env {
+		if !envNamePattern.MatchString(name) {
+			return runAs, fmt.Errorf("invalid environment variable name %q", name)
+		}
+	}
+
+	if isRoot(runAs) && !policy.AllowRoot {
+		return runAs, ErrPrivilegedJob
+	}
+
+	return runAs, nil
+}
+
+// isRoot returns whether the job runs as the root user or with the root group, by name or by ID
+func isRoot(runAs agent.JobRunAs) bool {
+	return isRootIdentity(runAs.User) || isRootIdentity(runAs.Group)
+}
+
+func isRootIdentity(value string) bool {
+	if value == "root" {
+		return true
+	}
+
+	id, err := strconv.Atoi(value)
+
+	return err == nil && id == 0
+}
@@ -0,0 +1,46 @@
+package scheduler
+
+import (
+	"testing"
+
+	"github.com/portainer/agent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunAsPolicy_Resolve(t *testing.T) {
+	policy := RunAsPolicy{DefaultUser: "nobody"}
+
+	runAs, err := policy.Resolve(agent.JobRunAs{WorkingDir: "/srv", Env: []string{"HTTP_PROXY"}})
+	require.NoError(t, err)
+	assert.Equal(t, agent.JobRunAs{User: "nobody", WorkingDir: "/srv", Env: []string{"HTTP_PROXY"}}, runAs)
+
+	for _, runAs := range []agent.JobRunAs{
+		{User: "root"},
+		{User: "0"},
+		{User: "00"},
+		{User: "backup", Group: "root"},
+		{User: "backup", Group: "000"},
+	} {
+		_, err := policy.Resolve(runAs)
+		assert.ErrorIs(t, err, ErrPrivilegedJob, runAs)
+	}
+
+	_, err = RunAsPolicy{}.Resolve(agent.JobRunAs{})
+	assert.ErrorIs(t, err, ErrPrivilegedJob)
+
+	runAs, err = RunAsPolicy{AllowRoot: true}.Resolve(agent.JobRunAs{})
+	require.NoError(t, err)
+	assert.Equal(t, "root", runAs.User)
+
+	for _, runAs := range []agent.JobRunAs{
+		{User: "nobody; reboot"},
+		{User: "nobody", Group: "$(id)"},
+		{User: "nobody", WorkingDir: "srv"},
+		{User: "nobody", Env: []string{"A=B"}},
+	} {
+		_, err := policy.Resolve(runAs)
+		assert.Error(t, err, runAs)
+		assert.NotErrorIs(t, err, ErrPrivilegedJob, runAs)
+	}
+}
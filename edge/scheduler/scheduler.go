@@ -26,14 +26,18 @@ type CronManager struct {
 	logsManager      *LogsManager
 	cronFileExists   bool
 	managedSchedules map[int]agent.Schedule
+	runAsPolicy      RunAsPolicy
+	wrapperClock     wrapperClock
 }
 
-// NewCronManager returns a pointer to a new instance of CronManager.
-func NewCronManager(logsManager *LogsManager) *CronManager {
+// NewCronManager returns a pointer to a new instance of CronManager, the jobs run with the identity resolved by runAsPolicy.
+func NewCronManager(logsManager *LogsManager, runAsPolicy RunAsPolicy) *CronManager {
 	return &CronManager{
 		logsManager:      logsManager,
 		cronFileExists:   false,
 		managedSchedules: make(map[int]agent.Schedule),
+		runAsPolicy:      runAsPolicy,
+		wrapperClock:     systemWrapperClock,
 	}
 }
 
@@ -124,7 +128,7 @@ func (manager *CronManager) flushEntries(schedules map[int]agent.Schedule) error
 	cronEntries = append(cronEntries, header...)
 
 	for _, schedule := range schedules {
-		cronEntry, err := createCronEntry(&schedule)
+		cronEntry, err := manager.createCronEntry(&schedule)
 		if err != nil {
 			log.Error().Int("schedule_id", schedule.ID).Err(err).Msg("unable to create cron entry")
 
@@ -149,7 +153,12 @@ func (manager *CronManager) flushEntries(schedules map[int]agent.Schedule) error
 	return nil
 }
 
-func createCronEntry(schedule *agent.Schedule) (string, error) {
+func (manager *CronManager) createCronEntry(schedule *agent.Schedule) (string, error) {
+	runAs, err := manager.runAsPolicy.Resolve(schedule.RunAs)
+	if err != nil {
+		return "", err
+	}
+
 	decodedScript, err := base64.RawStdEncoding.DecodeString(schedule.Script)
 	if err != nil {
 		return "", err
@@ -160,11 +169,14 @@ func createCronEntry(schedule *agent.Schedule) (string, error) {
 		return "", err
 	}
 
-	cronExpression := schedule.CronExpression
-	command := fmt.Sprintf("%s/schedule_%d", agent.ScheduleScriptDirectory, schedule.ID)
-	logFile := fmt.Sprintf("%s/schedule_%d.log", agent.ScheduleScriptDirectory, schedule.ID)
+	wrapper := jobWrapper(agent.ScheduleScriptDirectory, schedule, runAs, manager.runAsPolicy.AllowRoot, manager.wrapperClock)
+
+	err = filesystem.WriteFile(fmt.Sprintf("%s%s", agent.HostRoot, agent.ScheduleScriptDirectory), fmt.Sprintf("schedule_%d_run", schedule.ID), []byte(wrapper), 0744)
+	if err != nil {
+		return "", err
+	}
 
-	return fmt.Sprintf("%s %s %s > %s 2>&1", cronExpression, cronJobUser, command, logFile), nil
+	return fmt.Sprintf("%s %s %s/schedule_%d_run", schedule.CronExpression, cronJobUser, agent.ScheduleScriptDirectory, schedule.ID), nil
 }
 
 func (manager *CronManager) ProcessScheduleLogsCollection() {
@@ -0,0 +1,164 @@
+//go:build !windows
+// +build !windows
+
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/portainer/agent"
+)
+
+// killGracePeriod is how long the process tree of a job that timed out has to stop once terminated before it is killed
+const killGracePeriod = 10 * time.Second
+
+// setupFailedExitCode is the exit code recorded when the job cannot be run with its identity or from its working
+// directory, the one of the commands that cannot be executed
+const setupFailedExitCode = 126
+
+// timedOutExitCode is the exit code recorded for the executions killed because they exceeded the timeout of the job,
+// the one of the timeout command
+const timedOutExitCode = 124
+
+// wrapperClock holds the shell commands the wrapper of a job reads the current Unix time and waits for a number of
+// seconds with, the cron entries run on their own and cannot use the clock of the agent
+type wrapperClock struct {
+	Now   string
+	Sleep string
+}
+
+// systemWrapperClock is the clock of the host
+var systemWrapperClock = wrapperClock{Now: "date +%s", Sleep: "sleep"}
+
+// jobWrapper returns the script the cron entry of the job runs as root, located in directory with the script of the
+// job. The script of the job runs with the identity resolved for the job, from its working directory and with PATH
+// and the variables of the allowlist of the job as its only environment. It runs in its own session so that its whole
+// process tree can be killed when it exceeds the timeout, a failed execution is attempted again up to the number of
+// retries of the job. Each attempt is recorded for the job history with its start and end as Unix times, its exit
+// code, the attempt, the number of attempts and whether it timed out. The times are read and waited for with clock.
+// Unless allowRoot is set, the job is not run when its user or group resolves to root on the host, e.g. for a user
+// sharing the ID of root.
+func jobWrapper(directory string, schedule *agent.Schedule, runAs agent.JobRunAs, allowRoot bool, clock wrapperClock) string {
+	command := fmt.Sprintf("%s/schedule_%d", directory, schedule.ID)
+	logFile := fmt.Sprintf("%s/schedule_%d.log", directory, schedule.ID)
+	runsFile := fmt.Sprintf("%s/schedule_%d.runs", directory, schedule.ID)
+
+	lines := []string{
+		"#!/bin/sh",
+		fmt.Sprintf("timeout=%d", durationSeconds(schedule.Timeout)),
+		fmt.Sprintf("attempts=%d", max(schedule.Retries, 0)+1),
+		fmt.Sprintf("retry_delay=%d", durationSeconds(schedule.RetryDelay)),
+		fmt.Sprintf("grace=%d", durationSeconds(killGracePeriod)),
+		"fail() {",
+		fmt.Sprintf("  now=$(%s)", clock.Now),
+		fmt.Sprintf(`  echo "$1" > %s`, logFile),
+		fmt.Sprintf(`  echo "$now $now %d 1 $attempts 0" >> %s`, setupFailedExitCode, runsFile),
+		fmt.Sprintf("  exit %d", setupFailedExitCode),
+		"}",
+	}
+
+	if runAs.WorkingDir != "" {
+		lines = append(lines, fmt.Sprintf("cd %s || fail %s",
+			shellQuote(runAs.WorkingDir), shellQuote("unable to enter the working directory "+runAs.WorkingDir)))
+	}
+
+	identity := ""
+	if !isRoot(runAs) {
+		lines = append(lines, fmt.Sprintf("uid=$(id -u %s) || fail %s", shellQuote(runAs.User), shellQuote("unknown user "+runAs.User)))
+
+		groups := "--init-groups"
+
+		switch {
+		case runAs.Group == "":
+			lines = append(lines, fmt.Sprintf("gid=$(id -g %s) || fail %s", shellQuote(runAs.User), shellQuote("unknown user "+runAs.User)))
+		case isNumeric(runAs.Group):
+			lines = append(lines, "gid="+runAs.Group)
+			groups = "--clear-groups"
+		default:
+			lines = append(lines,
+				fmt.Sprintf("gid=$(getent group %s | cut -d: -f3)", shellQuote(runAs.Group)),
+				fmt.Sprintf(`[ -n "$gid" ] || fail %s`, shellQuote("unknown group "+runAs.Group)))
+			groups = "--clear-groups"
+		}
+
+		if !allowRoot {
+			lines = append(lines, fmt.Sprintf(`[ "$uid" -ne 0 ] && [ "$gid" -ne 0 ] || fail %s`, shellQuote(ErrPrivilegedJob.Error())))
+		}
+
+		lines = append(lines,
+			fmt.Sprintf("command -v setpriv > /dev/null 2>&1 || fail %s", shellQuote("setpriv is required to run the job as "+runAs.User)),
+			fmt.Sprintf(`chown "$uid:$gid" %s && chmod 0500 %s || fail %s`, command, command, shellQuote("unable to give the script to "+runAs.User)))
+
+		identity = fmt.Sprintf("setpriv --reuid=$uid --regid=$gid %s ", groups)
+	}
+
+	environment := []string{`PATH="$PATH"`}
+	for _, name := range runAs.Env {
+		environment = append(environment, fmt.Sprintf(`%s="$%s"`, name, name))
+	}
+
+	lines = append(lines,
+		`session=""`,
+		`command -v setsid > /dev/null 2>&1 && session=setsid`,
+		"attempt=1",
+		"while :; do",
+		fmt.Sprintf("  start=$(%s)", clock.Now),
+		"  timed_out=0",
+		fmt.Sprintf("  $session %senv -i %s %s > %s 2>&1 &", identity, strings.Join(environment, " "), command, logFile),
+		"  pid=$!",
+		`  if [ "$timeout" -gt 0 ]; then`,
+		"    elapsed=0",
+		`    while kill -0 "$pid" 2> /dev/null; do`,
+		`      if [ "$elapsed" -ge "$timeout" ]; then`,
+		"        timed_out=1",
+		`        kill -TERM "-$pid" 2> /dev/null || kill -TERM "$pid" 2> /dev/null`,
+		"        waited=0",
+		`        while kill -0 "$pid" 2> /dev/null && [ "$waited" -lt "$grace" ]; do`,
+		fmt.Sprintf("          %s 1", clock.Sleep),
+		"          waited=$((waited + 1))",
+		"        done",
+		`        kill -KILL "-$pid" 2> /dev/null || kill -KILL "$pid" 2> /dev/null`,
+		"        break",
+		"      fi",
+		fmt.Sprintf("      %s 1", clock.Sleep),
+		"      elapsed=$((elapsed + 1))",
+		"    done",
+		"  fi",
+		`  wait "$pid"`,
+		"  code=$?",
+		fmt.Sprintf(`  [ "$timed_out" -eq 1 ] && code=%d`, timedOutExitCode),
+		fmt.Sprintf(`  echo "$start $(%s) $code $attempt $attempts $timed_out" >> %s`, clock.Now, runsFile),
+		`  if [ "$code" -eq 0 ] || [ "$attempt" -ge "$attempts" ]; then`,
+		`    exit "$code"`,
+		"  fi",
+		"  attempt=$((attempt + 1))",
+		fmt.Sprintf(`  %s "$retry_delay"`, clock.Sleep),
+		"done",
+		"",
+	)
+
+	return strings.Join(lines, "\n")
+}
+
+// shellQuote returns the value quoted for the shell
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+func isNumeric(value string) bool {
+	_, err := strconv.Atoi(value)
+
+	return err == nil
+}
+
+// durationSeconds returns the duration in seconds, rounded up
+func durationSeconds(duration time.Duration) int64 {
+	if duration <= 0 {
+		return 0
+	}
+
+	return int64((duration + time.Second - 1) / time.Second)
+}
@@ -0,0 +1,222 @@
+//go:build !windows
+// +build !windows
+
+package scheduler
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/portainer/agent"
+	"github.com/portainer/agent/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var root = agent.JobRunAs{User: "root"}
+
+var epoch = time.Unix(1700000000, 0)
+
+// fakeWrapperClock returns a wrapper clock reading its time from a file set to the time of fakeClock, waiting only
+// moves the time of the file forward. The waits are held until the file named by FAKE_SLEEP_GATE exists when the
+// variable is set. The returned function advances fakeClock to the time of the file.
+func fakeWrapperClock(t *testing.T, fakeClock *clock.FakeClock) (wrapperClock, func()) {
+	t.Helper()
+
+	directory := t.TempDir()
+	now := filepath.Join(directory, "now")
+	sleep := filepath.Join(directory, "sleep")
+
+	require.NoError(t, os.WriteFile(now, []byte(strconv.FormatInt(fakeClock.Now().Unix(), 10)), 0644))
+	require.NoError(t, os.WriteFile(sleep, []byte(fmt.Sprintf(`#!/bin/sh
+while [ -n "$FAKE_SLEEP_GATE" ] && [ ! -e "$FAKE_SLEEP_GATE" ]; do :; done
+echo $(($(cat %s) + $1)) > %s
+`, now, now)), 0755))
+
+	advance := func() {
+		content, err := os.ReadFile(now)
+		require.NoError(t, err)
+
+		seconds, err := strconv.ParseInt(strings.TrimSpace(string(content)), 10, 64)
+		require.NoError(t, err)
+
+		fakeClock.Advance(time.Unix(seconds, 0).Sub(fakeClock.Now()))
+	}
+
+	return wrapperClock{Now: "cat " + now, Sleep: sleep}, advance
+}
+
+func runWrapper(t *testing.T, fakeClock *clock.FakeClock, schedule *agent.Schedule, runAs agent.JobRunAs, script string, env ...string) (int, []string, string) {
+	t.Helper()
+
+	wrapperClock, advanceClock := fakeWrapperClock(t, fakeClock)
+	defer advanceClock()
+
+	// The scripts folder of the host is readable by the users the jobs run as
+	directory := t.TempDir()
+	require.NoError(t, os.Chmod(filepath.Dir(directory), 0755))
+	require.NoError(t, os.Chmod(directory, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(directory, fmt.Sprintf("schedule_%d", schedule.ID)), []byte(script), 0744))
+
+	wrapper := filepath.Join(directory, fmt.Sprintf("schedule_%d_run", schedule.ID))
+	require.NoError(t, os.WriteFile(wrapper, []byte(jobWrapper(directory, schedule, runAs, false, wrapperClock)), 0744))
+
+	code := 0
+
+	cmd := exec.Command(wrapper)
+	cmd.Env = append(os.Environ(), env...)
+
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		code = exitErr.ExitCode()
+	} else {
+		require.NoError(t, err)
+	}
+
+	runs, err := os.ReadFile(filepath.Join(directory, fmt.Sprintf("schedule_%d.runs", schedule.ID)))
+	require.NoError(t, err)
+
+	var attempts []string
+	for _, line := range strings.Split(strings.TrimSpace(string(runs)), "\n") {
+		fields := strings.Fields(line)
+		require.Len(t, fields, 6)
+
+		attempts = append(attempts, strings.Join(fields[2:], " "))
+	}
+
+	output, err := os.ReadFile(filepath.Join(directory, fmt.Sprintf("schedule_%d.log", schedule.ID)))
+	require.NoError(t, err)
+
+	return code, attempts, string(output)
+}
+
+func TestJobWrapper_Retries(t *testing.T) {
+	fakeClock := clock.NewFakeClock(epoch)
+
+	code, attempts, _ := runWrapper(t, fakeClock, &agent.Schedule{ID: 1, Retries: 2, RetryDelay: time.Minute}, root, "#!/bin/sh\nexit 3\n")
+	assert.Equal(t, 3, code)
+	assert.Equal(t, []string{"3 1 3 0", "3 2 3 0", "3 3 3 0"}, attempts)
+
+	// The failed attempts are retried after the delay
+	assert.Equal(t, epoch.Add(2*time.Minute), fakeClock.Now())
+
+	code, attempts, _ = runWrapper(t, fakeClock, &agent.Schedule{ID: 2, Retries: 2}, root, "#!/bin/sh\necho done\n")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, []string{"0 1 3 0"}, attempts)
+}
+
+func TestJobWrapper_Timeout(t *testing.T) {
+	fakeClock := clock.NewFakeClock(epoch)
+	pidFile := filepath.Join(t.TempDir(), "pid")
+
+	// The script starts a child and is held until the child is running
+	script := fmt.Sprintf("#!/bin/sh\nsh -c 'echo $$ > %s.tmp && mv %s.tmp %s && exec sleep 30' &\nsleep 30\n", pidFile, pidFile, pidFile)
+
+	code, attempts, _ := runWrapper(t, fakeClock, &agent.Schedule{ID: 3, Timeout: time.Second}, root, script, "FAKE_SLEEP_GATE="+pidFile)
+
+	assert.Equal(t, timedOutExitCode, code)
+	assert.Equal(t, []string{"124 1 1 1"}, attempts)
+
+	// The script is killed once the timeout elapsed, after its grace period at most
+	assert.GreaterOrEqual(t, fakeClock.Now().Sub(epoch), time.Second)
+	assert.LessOrEqual(t, fakeClock.Now().Sub(epoch), time.Second+killGracePeriod)
+
+	// The child of the script is killed along with it
+	content, err := os.ReadFile(pidFile)
+	require.NoError(t, err)
+
+	pid := strings.TrimSpace(string(content))
+	assert.Eventually(t, func() bool { return processExited(pid) }, 5*time.Second, 10*time.Millisecond)
+}
+
+// processExited returns whether the process is gone or only waits to be reaped
+func processExited(pid string) bool {
+	stat, err := os.ReadFile(filepath.Join("/proc", pid, "stat"))
+	if err != nil {
+		return true
+	}
+
+	// The state follows the command name, which is between parentheses
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+
+	return len(fields) > 0 && fields[0] == "Z"
+}
+
+func TestJobWrapper_Environment(t *testing.T) {
+	workingDir := t.TempDir()
+
+	runAs := agent.JobRunAs{User: "root", WorkingDir: workingDir, Env: []string{"ALLOWED"}}
+	script := "#!/bin/sh\necho \"$(pwd) $ALLOWED $DENIED\"\n"
+
+	fakeClock := clock.NewFakeClock(epoch)
+
+	code, _, output := runWrapper(t, fakeClock, &agent.Schedule{ID: 4}, runAs, script, "ALLOWED=allowed", "DENIED=denied")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, workingDir+" allowed \n", output)
+
+	runAs.WorkingDir = filepath.Join(workingDir, "missing")
+
+	code, attempts, output := runWrapper(t, fakeClock, &agent.Schedule{ID: 5, Retries: 1}, runAs, script)
+	assert.Equal(t, setupFailedExitCode, code)
+	assert.Equal(t, []string{"126 1 2 0"}, attempts)
+	assert.Contains(t, output, "unable to enter the working directory")
+}
+
+func TestJobWrapper_User(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("running the jobs as another user requires root")
+	}
+
+	if _, err := exec.LookPath("setpriv"); err != nil {
+		t.Skip("setpriv is not available")
+	}
+
+	uid, err := exec.Command("id", "-u", "nobody").Output()
+	if err != nil {
+		t.Skip("the nobody user does not exist")
+	}
+
+	fakeClock := clock.NewFakeClock(epoch)
+
+	code, _, output := runWrapper(t, fakeClock, &agent.Schedule{ID: 6}, agent.JobRunAs{User: "nobody"}, "#!/bin/sh\nid -u\n")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, string(uid), output)
+
+	code, _, output = runWrapper(t, fakeClock, &agent.Schedule{ID: 7}, agent.JobRunAs{User: "missing-user"}, "#!/bin/sh\nid -u\n")
+	assert.Equal(t, setupFailedExitCode, code)
+	assert.Contains(t, output, "unknown user missing-user")
+}
+
+func TestJobWrapper_RootAlias(t *testing.T) {
+	// The id and getent commands of the host resolve the names to the IDs of root
+	bin := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "id"), []byte("#!/bin/sh\n[ \"$2\" = toor ] && echo 0 || echo 65534\n"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "getent"), []byte("#!/bin/sh\necho \"$2:x:0:\"\n"), 0755))
+
+	path := "PATH=" + bin + string(os.PathListSeparator) + os.Getenv("PATH")
+
+	fakeClock := clock.NewFakeClock(epoch)
+
+	for i, runAs := range []agent.JobRunAs{{User: "toor"}, {User: "nobody", Group: "wheel"}} {
+		code, attempts, output := runWrapper(t, fakeClock, &agent.Schedule{ID: 8 + i}, runAs, "#!/bin/sh\necho ran\n", path)
+		assert.Equal(t, setupFailedExitCode, code, runAs)
+		assert.Equal(t, []string{"126 1 1 0"}, attempts, runAs)
+		assert.Equal(t, ErrPrivilegedJob.Error()+"\n", output, runAs)
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	assert.Equal(t, `'/srv/it'\''s'`, shellQuote("/srv/it's"))
+}
+
+func TestDurationSeconds(t *testing.T) {
+	assert.Equal(t, int64(0), durationSeconds(0))
+	assert.Equal(t, int64(1), durationSeconds(500*time.Millisecond))
+	assert.Equal(t, int64(90), durationSeconds(90*time.Second))
+}
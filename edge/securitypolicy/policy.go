@@ -0,0 +1,134 @@
+package securitypolicy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/portainer/agent/edge/yaml"
+)
+
+// ErrPolicyViolation is returned when a stack does not comply with the security policy of the device
+var ErrPolicyViolation = errors.New("security policy violation")
+
+// Policy restricts the security settings of the workloads of the Edge stacks. Like the image policy, it is configured
+// on the device rather than sent by the server. e.g.
+//
+//	{
+//	  "denyPrivileged": true,
+//	  "denyHostNetwork": true,
+//	  "denyHostPID": true,
+//	  "denyUnconfinedSeccomp": true,
+//	  "deniedCapabilities": ["SYS_ADMIN", "NET_ADMIN"],
+//	  "deniedBindMounts": ["/var/run/docker.sock", "/etc"]
+//	}
+type Policy struct {
+	DenyPrivileged        bool `json:"denyPrivileged"`
+	DenyHostNetwork       bool `json:"denyHostNetwork"`
+	DenyHostPID           bool `json:"denyHostPID"`
+	DenyUnconfinedSeccomp bool `json:"denyUnconfinedSeccomp"`
+	// DeniedCapabilities are the capabilities the containers cannot add, with or without the CAP_ prefix.
+	// Adding ALL is denied as soon as a capability is.
+	DeniedCapabilities []string `json:"deniedCapabilities"`
+	// DeniedBindMounts are the paths of the host that cannot be mounted in the containers, nor any path under them.
+	// / denies every bind mount.
+	DeniedBindMounts []string `json:"deniedBindMounts"`
+}
+
+// Load reads the policy of a JSON file
+func Load(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &Policy{}
+	if err := json.Unmarshal(data, policy); err != nil {
+		return nil, fmt.Errorf("invalid security policy %s: %w", path, err)
+	}
+
+	for i, capability := range policy.DeniedCapabilities {
+		policy.DeniedCapabilities[i] = strings.TrimPrefix(strings.ToUpper(capability), "CAP_")
+	}
+
+	for i, mount := range policy.DeniedBindMounts {
+		if !strings.HasPrefix(mount, "/") {
+			return nil, fmt.Errorf("invalid security policy %s: the denied bind mount %s is not an absolute path", path, mount)
+		}
+
+		policy.DeniedBindMounts[i] = cleanPath(mount)
+	}
+
+	return policy, nil
+}
+
+// Evaluate returns an ErrPolicyViolation error listing the settings of the workloads the policy denies. The bind
+// mounts must be absolute paths, the relative ones are denied.
+func (policy *Policy) Evaluate(workloads []yaml.WorkloadSecurity) error {
+	var violations []string
+
+	for _, workload := range workloads {
+		if policy.DenyPrivileged && workload.Privileged {
+			violations = append(violations, fmt.Sprintf("%s is privileged", workload.Name))
+		}
+
+		if policy.DenyHostNetwork && workload.HostNetwork {
+			violations = append(violations, fmt.Sprintf("%s uses the host network", workload.Name))
+		}
+
+		if policy.DenyHostPID && workload.HostPID {
+			violations = append(violations, fmt.Sprintf("%s uses the host PID namespace", workload.Name))
+		}
+
+		if policy.DenyUnconfinedSeccomp && workload.UnconfinedSeccomp {
+			violations = append(violations, fmt.Sprintf("%s runs without a seccomp profile", workload.Name))
+		}
+
+		for _, capability := range workload.Capabilities {
+			if slices.Contains(policy.DeniedCapabilities, capability) || (capability == "ALL" && len(policy.DeniedCapabilities) > 0) {
+				violations = append(violations, fmt.Sprintf("%s adds the %s capability", workload.Name, capability))
+			}
+		}
+
+		for _, mount := range workload.BindMounts {
+			if policy.deniedBindMount(mount) {
+				violations = append(violations, fmt.Sprintf("%s mounts %s", workload.Name, mount))
+			}
+		}
+	}
+
+	if len(violations) > 0 {
+		return fmt.Errorf("%w: %s", ErrPolicyViolation, strings.Join(violations, "; "))
+	}
+
+	return nil
+}
+
+func (policy *Policy) deniedBindMount(mount string) bool {
+	if len(policy.DeniedBindMounts) == 0 {
+		return false
+	}
+
+	if !strings.HasPrefix(mount, "/") {
+		return true
+	}
+
+	mount = cleanPath(mount)
+
+	for _, denied := range policy.DeniedBindMounts {
+		if denied == "/" || mount == denied || strings.HasPrefix(mount, denied+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cleanPath cleans the paths of the host, they are always Linux paths
+func cleanPath(p string) string {
+	return path.Clean(p)
+}
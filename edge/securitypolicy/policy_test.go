@@ -0,0 +1,73 @@
+package securitypolicy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/portainer/agent/edge/yaml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func loadPolicy(t *testing.T, content string) *Policy {
+	file := filepath.Join(t.TempDir(), "policy.json")
+	require.NoError(t, os.WriteFile(file, []byte(content), 0600))
+
+	policy, err := Load(file)
+	require.NoError(t, err)
+
+	return policy
+}
+
+func TestLoad(t *testing.T) {
+	policy := loadPolicy(t, `{"deniedCapabilities": ["cap_sys_admin"], "deniedBindMounts": ["/etc/"]}`)
+	assert.Equal(t, []string{"SYS_ADMIN"}, policy.DeniedCapabilities)
+	assert.Equal(t, []string{"/etc"}, policy.DeniedBindMounts)
+
+	file := filepath.Join(t.TempDir(), "policy.json")
+	require.NoError(t, os.WriteFile(file, []byte(`{"deniedBindMounts": ["etc"]}`), 0600))
+
+	_, err := Load(file)
+	assert.Error(t, err)
+}
+
+func TestPolicy_Evaluate(t *testing.T) {
+	policy := loadPolicy(t, `{
+		"denyPrivileged": true,
+		"denyHostNetwork": true,
+		"denyHostPID": true,
+		"denyUnconfinedSeccomp": true,
+		"deniedCapabilities": ["SYS_ADMIN"],
+		"deniedBindMounts": ["/var/run/docker.sock", "/etc"]
+	}`)
+
+	assert.NoError(t, policy.Evaluate([]yaml.WorkloadSecurity{
+		{Name: "web", Capabilities: []string{"NET_BIND_SERVICE"}, BindMounts: []string{"/srv/web", "/etcd"}},
+	}))
+
+	err := policy.Evaluate([]yaml.WorkloadSecurity{
+		{Name: "web"},
+		{
+			Name:              "monitor",
+			Privileged:        true,
+			HostNetwork:       true,
+			HostPID:           true,
+			UnconfinedSeccomp: true,
+			Capabilities:      []string{"SYS_ADMIN", "ALL"},
+			BindMounts:        []string{"/var/run/docker.sock", "/etc/../etc/ssl", "config"},
+		},
+	})
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrPolicyViolation))
+	assert.Equal(t, "security policy violation: monitor is privileged; monitor uses the host network; "+
+		"monitor uses the host PID namespace; monitor runs without a seccomp profile; "+
+		"monitor adds the SYS_ADMIN capability; monitor adds the ALL capability; "+
+		"monitor mounts /var/run/docker.sock; monitor mounts /etc/../etc/ssl; monitor mounts config", err.Error())
+
+	// Nothing is denied by an empty policy
+	assert.NoError(t, loadPolicy(t, `{}`).Evaluate([]yaml.WorkloadSecurity{
+		{Name: "monitor", Privileged: true, Capabilities: []string{"ALL"}, BindMounts: []string{"config"}},
+	}))
+}
@@ -0,0 +1,117 @@
+package session
+
+import (
+	"github.com/portainer/agent/edge/client"
+	portainer "github.com/portainer/portainer/api"
+)
+
+// Methods of the Portainer client recorded in the sessions
+const (
+	MethodGetEnvironmentStatus       = "GetEnvironmentStatus"
+	MethodGetEdgeStackConfig         = "GetEdgeStackConfig"
+	MethodSetEdgeStackStatus         = "SetEdgeStackStatus"
+	MethodSetEdgeStackBatchStatus    = "SetEdgeStackBatchStatus"
+	MethodSetEdgeStackJobResult      = "SetEdgeStackJobResult"
+	MethodSetEdgeStackServicesStatus = "SetEdgeStackServicesStatus"
+	MethodSetEdgeStackStaged         = "SetEdgeStackStaged"
+	MethodSetEdgeStackConfigHash     = "SetEdgeStackConfigHash"
+	MethodSetEdgeStackInventory      = "SetEdgeStackInventory"
+)
+
+// StackArgs are the recorded arguments of the calls about an Edge stack
+type StackArgs struct {
+	EdgeStackID int  `json:"edgeStackID"`
+	Version     *int `json:"version,omitempty"`
+}
+
+// StatusArgs are the recorded arguments of SetEdgeStackStatus and SetEdgeStackError
+type StatusArgs struct {
+	EdgeStackID int                           `json:"edgeStackID"`
+	Status      portainer.EdgeStackStatusType `json:"status"`
+	RollbackTo  *int                          `json:"rollbackTo,omitempty"`
+	Error       string                        `json:"error,omitempty"`
+	ErrorClass  string                        `json:"errorClass,omitempty"`
+}
+
+// RecordingClient is a PortainerClient recording the polls of the server and the calls about the Edge stacks in a
+// session. The periodic reports, such as the usage and the pull progress of the stacks, are not recorded.
+type RecordingClient struct {
+	client.PortainerClient
+
+	recorder *Recorder
+}
+
+// NewRecordingClient returns a pointer to a new RecordingClient wrapping cli
+func NewRecordingClient(cli client.PortainerClient, recorder *Recorder) *RecordingClient {
+	return &RecordingClient{PortainerClient: cli, recorder: recorder}
+}
+
+func (c *RecordingClient) GetEnvironmentStatus(flags ...string) (*client.PollStatusResponse, error) {
+	status, err := c.PortainerClient.GetEnvironmentStatus(flags...)
+	c.recorder.Record(KindClient, MethodGetEnvironmentStatus, flags, status, err)
+
+	return status, err
+}
+
+func (c *RecordingClient) GetEdgeStackConfig(edgeStackID int, version *int) (*client.StackPayload, error) {
+	payload, err := c.PortainerClient.GetEdgeStackConfig(edgeStackID, version)
+	c.recorder.Record(KindClient, MethodGetEdgeStackConfig, StackArgs{EdgeStackID: edgeStackID, Version: version}, payload, err)
+
+	return payload, err
+}
+
+func (c *RecordingClient) SetEdgeStackStatus(edgeStackID int, edgeStackStatus portainer.EdgeStackStatusType, rollbackTo *int, errMessage string) error {
+	err := c.PortainerClient.SetEdgeStackStatus(edgeStackID, edgeStackStatus, rollbackTo, errMessage)
+	c.recorder.Record(KindClient, MethodSetEdgeStackStatus, StatusArgs{EdgeStackID: edgeStackID, Status: edgeStackStatus, RollbackTo: rollbackTo, Error: errMessage}, nil, err)
+
+	return err
+}
+
+func (c *RecordingClient) SetEdgeStackError(edgeStackID int, rollbackTo *int, errMessage, errorClass string) error {
+	err := c.PortainerClient.SetEdgeStackError(edgeStackID, rollbackTo, errMessage, errorClass)
+	c.recorder.Record(KindClient, MethodSetEdgeStackStatus, StatusArgs{EdgeStackID: edgeStackID, Status: portainer.EdgeStackStatusError, RollbackTo: rollbackTo, Error: errMessage, ErrorClass: errorClass}, nil, err)
+
+	return err
+}
+
+func (c *RecordingClient) SetEdgeStackBatchStatus(batchID int, status client.StackBatchStatus) error {
+	err := c.PortainerClient.SetEdgeStackBatchStatus(batchID, status)
+	c.recorder.Record(KindClient, MethodSetEdgeStackBatchStatus, map[string]any{"batchID": batchID, "status": status}, nil, err)
+
+	return err
+}
+
+func (c *RecordingClient) SetEdgeStackJobResult(edgeStackID int, result client.StackJobResult) error {
+	err := c.PortainerClient.SetEdgeStackJobResult(edgeStackID, result)
+	c.recorder.Record(KindClient, MethodSetEdgeStackJobResult, map[string]any{"edgeStackID": edgeStackID, "result": result}, nil, err)
+
+	return err
+}
+
+func (c *RecordingClient) SetEdgeStackServicesStatus(edgeStackID int, services map[string]client.StackServiceStatus) error {
+	err := c.PortainerClient.SetEdgeStackServicesStatus(edgeStackID, services)
+	c.recorder.Record(KindClient, MethodSetEdgeStackServicesStatus, map[string]any{"edgeStackID": edgeStackID, "services": services}, nil, err)
+
+	return err
+}
+
+func (c *RecordingClient) SetEdgeStackStaged(edgeStackID int, staged client.StackStaged) error {
+	err := c.PortainerClient.SetEdgeStackStaged(edgeStackID, staged)
+	c.recorder.Record(KindClient, MethodSetEdgeStackStaged, map[string]any{"edgeStackID": edgeStackID, "staged": staged}, nil, err)
+
+	return err
+}
+
+func (c *RecordingClient) SetEdgeStackConfigHash(edgeStackID int, hash client.StackConfigHash) error {
+	err := c.PortainerClient.SetEdgeStackConfigHash(edgeStackID, hash)
+	c.recorder.Record(KindClient, MethodSetEdgeStackConfigHash, map[string]any{"edgeStackID": edgeStackID, "hash": hash}, nil, err)
+
+	return err
+}
+
+func (c *RecordingClient) SetEdgeStackInventory(stacks []client.DeployedStack) error {
+	err := c.PortainerClient.SetEdgeStackInventory(stacks)
+	c.recorder.Record(KindClient, MethodSetEdgeStackInventory, map[string]any{"stacks": stacks}, nil, err)
+
+	return err
+}
@@ -0,0 +1,118 @@
+package session
+
+import (
+	"context"
+	"errors"
+
+	"github.com/portainer/agent"
+	"github.com/portainer/portainer/pkg/libstack"
+)
+
+// Methods of the deployer recorded in the sessions
+const (
+	MethodDeploy        = "Deploy"
+	MethodRemove        = "Remove"
+	MethodPull          = "Pull"
+	MethodValidate      = "Validate"
+	MethodRestart       = "Restart"
+	MethodRun           = "Run"
+	MethodWaitForStatus = "WaitForStatus"
+)
+
+// DeployerArgs are the recorded arguments of the calls to the deployer
+type DeployerArgs struct {
+	Name      string          `json:"name"`
+	FilePaths []string        `json:"filePaths,omitempty"`
+	Status    libstack.Status `json:"status,omitempty"`
+}
+
+// RecordingDeployer is a Deployer recording the outcome of its calls in a session
+type RecordingDeployer struct {
+	agent.Deployer
+
+	recorder *Recorder
+}
+
+// NewRecordingDeployer returns a pointer to a new RecordingDeployer wrapping deployer
+func NewRecordingDeployer(deployer agent.Deployer, recorder *Recorder) *RecordingDeployer {
+	return &RecordingDeployer{Deployer: deployer, recorder: recorder}
+}
+
+func (d *RecordingDeployer) Deploy(ctx context.Context, name string, filePaths []string, options agent.DeployOptions) error {
+	err := d.Deployer.Deploy(ctx, name, filePaths, options)
+	d.recorder.Record(KindDeployer, MethodDeploy, DeployerArgs{Name: name, FilePaths: filePaths}, nil, err)
+
+	return err
+}
+
+func (d *RecordingDeployer) Remove(ctx context.Context, name string, filePaths []string, options agent.RemoveOptions) error {
+	err := d.Deployer.Remove(ctx, name, filePaths, options)
+	d.recorder.Record(KindDeployer, MethodRemove, DeployerArgs{Name: name, FilePaths: filePaths}, nil, err)
+
+	return err
+}
+
+func (d *RecordingDeployer) Pull(ctx context.Context, name string, filePaths []string, options agent.PullOptions) error {
+	err := d.Deployer.Pull(ctx, name, filePaths, options)
+	d.recorder.Record(KindDeployer, MethodPull, DeployerArgs{Name: name, FilePaths: filePaths}, nil, err)
+
+	return err
+}
+
+func (d *RecordingDeployer) Validate(ctx context.Context, name string, filePaths []string, options agent.ValidateOptions) error {
+	err := d.Deployer.Validate(ctx, name, filePaths, options)
+	d.recorder.Record(KindDeployer, MethodValidate, DeployerArgs{Name: name, FilePaths: filePaths}, nil, err)
+
+	return err
+}
+
+// Restart restarts the stack when the wrapped deployer supports it
+func (d *RecordingDeployer) Restart(ctx context.Context, name string, filePaths []string, options agent.DeployOptions) error {
+	restarter, ok := d.Deployer.(interface {
+		Restart(ctx context.Context, name string, filePaths []string, options agent.DeployOptions) error
+	})
+	if !ok {
+		return errors.New("the deployer does not support restarting the stacks")
+	}
+
+	err := restarter.Restart(ctx, name, filePaths, options)
+	d.recorder.Record(KindDeployer, MethodRestart, DeployerArgs{Name: name, FilePaths: filePaths}, nil, err)
+
+	return err
+}
+
+// Run runs a one-off command of the stack when the wrapped deployer supports it, its output is not recorded
+func (d *RecordingDeployer) Run(ctx context.Context, name string, filePaths []string, service string, command []string, options agent.DeployOptions) ([]byte, int, error) {
+	runner, ok := d.Deployer.(interface {
+		Run(ctx context.Context, name string, filePaths []string, service string, command []string, options agent.DeployOptions) ([]byte, int, error)
+	})
+	if !ok {
+		return nil, -1, errors.New("the deployer does not support running commands")
+	}
+
+	output, exitCode, err := runner.Run(ctx, name, filePaths, service, command, options)
+	d.recorder.Record(KindDeployer, MethodRun, DeployerArgs{Name: name, FilePaths: filePaths}, exitCode, err)
+
+	return output, exitCode, err
+}
+
+// WaitForStatus records the result once it is received
+func (d *RecordingDeployer) WaitForStatus(ctx context.Context, name string, status libstack.Status) <-chan libstack.WaitResult {
+	results := d.Deployer.WaitForStatus(ctx, name, status)
+	recorded := make(chan libstack.WaitResult, 1)
+
+	go func() {
+		result, ok := <-results
+		if !ok {
+			close(recorded)
+
+			return
+		}
+
+		d.recorder.Record(KindDeployer, MethodWaitForStatus, DeployerArgs{Name: name, Status: status}, result, nil)
+
+		recorded <- result
+	}()
+
+	return recorded
+}
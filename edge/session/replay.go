@@ -0,0 +1,395 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/portainer/agent"
+	"github.com/portainer/agent/edge/client"
+	portainer "github.com/portainer/portainer/api"
+	"github.com/portainer/portainer/pkg/libstack"
+)
+
+// Poll is a poll of the Portainer server recorded in a session
+type Poll struct {
+	Time     time.Time
+	Response client.PollStatusResponse
+}
+
+// Polls returns the successful polls of the session, in the order they were received
+func Polls(entries []Entry) ([]Poll, error) {
+	var polls []Poll
+
+	for i, entry := range entries {
+		if entry.Kind != KindClient || entry.Method != MethodGetEnvironmentStatus || entry.Error != "" {
+			continue
+		}
+
+		poll := Poll{Time: entry.Time}
+		if err := json.Unmarshal(entry.Result, &poll.Response); err != nil {
+			return nil, fmt.Errorf("invalid poll response of the entry %d: %w", i+1, err)
+		}
+
+		polls = append(polls, poll)
+	}
+
+	return polls, nil
+}
+
+// Outgoing returns the calls issued by the stack manager in the session, the statuses reported to the server and the
+// calls to the deployer
+func Outgoing(entries []Entry) []Entry {
+	var outgoing []Entry
+
+	for _, entry := range entries {
+		if entry.Kind == KindClient && (entry.Method == MethodGetEnvironmentStatus || entry.Method == MethodGetEdgeStackConfig) {
+			continue
+		}
+
+		outgoing = append(outgoing, entry)
+	}
+
+	return outgoing
+}
+
+// Diverge returns the index of the first call of replayed differing from the recorded ones, -1 when they are the
+// same. The timestamps and the stack file paths, which depend on where and when the session is replayed, are not
+// compared.
+func Diverge(recorded, replayed []Entry) int {
+	for i := 0; i < max(len(recorded), len(replayed)); i++ {
+		if i >= len(recorded) || i >= len(replayed) || !sameCall(recorded[i], replayed[i]) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func sameCall(a, b Entry) bool {
+	if a.Kind != b.Kind || a.Method != b.Method || (a.Error == "") != (b.Error == "") {
+		return false
+	}
+
+	return bytes.Equal(normalizeArgs(a), normalizeArgs(b))
+}
+
+func normalizeArgs(entry Entry) []byte {
+	var args any
+	if err := json.Unmarshal(entry.Args, &args); err != nil {
+		return entry.Args
+	}
+
+	if fields, ok := args.(map[string]any); ok && entry.Kind == KindDeployer {
+		delete(fields, "filePaths")
+	}
+
+	normalized, _ := json.Marshal(withoutTimes(args))
+
+	return normalized
+}
+
+func withoutTimes(v any) any {
+	switch value := v.(type) {
+	case map[string]any:
+		delete(value, "Time")
+
+		for key, field := range value {
+			value[key] = withoutTimes(field)
+		}
+	case []any:
+		for i, item := range value {
+			value[i] = withoutTimes(item)
+		}
+	}
+
+	return v
+}
+
+// Replayer feeds the payloads and the outcomes of the deployer recorded in a session back to a stack manager, and
+// captures the calls it issues instead of sending them
+type Replayer struct {
+	clock agent.Clock
+
+	mu       sync.Mutex
+	payloads map[payloadKey][]Entry
+	deployer map[deployerKey][]Entry
+	calls    []Entry
+}
+
+// NewReplayer returns a pointer to a new instance of Replayer for the recorded entries, the captured calls are
+// timestamped with clock
+func NewReplayer(entries []Entry, clock agent.Clock) (*Replayer, error) {
+	r := &Replayer{
+		clock:    clock,
+		payloads: make(map[payloadKey][]Entry),
+		deployer: make(map[deployerKey][]Entry),
+	}
+
+	for i, entry := range entries {
+		switch {
+		case entry.Kind == KindClient && entry.Method == MethodGetEdgeStackConfig:
+			var args StackArgs
+			if err := json.Unmarshal(entry.Args, &args); err != nil {
+				return nil, fmt.Errorf("invalid arguments of the entry %d: %w", i+1, err)
+			}
+
+			key := newPayloadKey(args.EdgeStackID, args.Version)
+			r.payloads[key] = append(r.payloads[key], entry)
+
+		case entry.Kind == KindDeployer:
+			var args DeployerArgs
+			if err := json.Unmarshal(entry.Args, &args); err != nil {
+				return nil, fmt.Errorf("invalid arguments of the entry %d: %w", i+1, err)
+			}
+
+			key := deployerKey{method: entry.Method, name: args.Name, status: args.Status}
+			r.deployer[key] = append(r.deployer[key], entry)
+		}
+	}
+
+	return r, nil
+}
+
+// payloadKey indexes the recorded payloads by stack and version, latest is set for the payloads requested without
+// a version
+type payloadKey struct {
+	edgeStackID int
+	version     int
+	latest      bool
+}
+
+func newPayloadKey(edgeStackID int, version *int) payloadKey {
+	if version == nil {
+		return payloadKey{edgeStackID: edgeStackID, latest: true}
+	}
+
+	return payloadKey{edgeStackID: edgeStackID, version: *version}
+}
+
+// deployerKey indexes the recorded outcomes of the deployer by call and stack
+type deployerKey struct {
+	method string
+	name   string
+	status libstack.Status
+}
+
+// Calls returns the calls issued by the stack manager so far
+func (r *Replayer) Calls() []Entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]Entry(nil), r.calls...)
+}
+
+// Client returns the PortainerClient of the stack manager replaying the session
+func (r *Replayer) Client() client.PortainerClient {
+	return &replayClient{replayer: r}
+}
+
+// Deployer returns the deployer of the stack manager replaying the session, the calls not recorded succeed
+func (r *Replayer) Deployer() agent.Deployer {
+	return &replayDeployer{replayer: r}
+}
+
+func (r *Replayer) capture(kind, method string, args, result any, callErr error) {
+	entry := Entry{Time: r.clock.Now(), Kind: kind, Method: method}
+	entry.Args, _ = redact(args)
+	entry.Result, _ = redact(result)
+
+	if callErr != nil {
+		entry.Error = callErr.Error()
+	}
+
+	r.mu.Lock()
+	r.calls = append(r.calls, entry)
+	r.mu.Unlock()
+}
+
+// nextPayloadEntry returns the next recorded payload of the stack version, the last one is returned again once the
+// others were replayed
+func (r *Replayer) nextPayloadEntry(edgeStackID int, version *int) (Entry, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := newPayloadKey(edgeStackID, version)
+
+	queue := r.payloads[key]
+	if len(queue) == 0 {
+		return Entry{}, false
+	}
+
+	if len(queue) > 1 {
+		r.payloads[key] = queue[1:]
+	}
+
+	return queue[0], true
+}
+
+// nextDeployerEntry returns the next recorded outcome of the call of the deployer
+func (r *Replayer) nextDeployerEntry(method, name string, status libstack.Status) (Entry, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := deployerKey{method: method, name: name, status: status}
+
+	queue := r.deployer[key]
+	if len(queue) == 0 {
+		return Entry{}, false
+	}
+
+	r.deployer[key] = queue[1:]
+
+	return queue[0], true
+}
+
+func (r *Replayer) payload(edgeStackID int, version *int) (*client.StackPayload, error) {
+	entry, ok := r.nextPayloadEntry(edgeStackID, version)
+	if !ok {
+		return nil, fmt.Errorf("the payload of the Edge stack %d is not recorded in the session", edgeStackID)
+	}
+
+	if entry.Error != "" {
+		return nil, errors.New(entry.Error)
+	}
+
+	var payload client.StackPayload
+	if err := json.Unmarshal(entry.Result, &payload); err != nil {
+		return nil, err
+	}
+
+	return &payload, nil
+}
+
+// replayClient is the PortainerClient of a replayed session, only the calls of the stack manager are supported
+type replayClient struct {
+	client.PortainerClient
+
+	replayer *Replayer
+}
+
+func (c *replayClient) GetEdgeStackConfig(edgeStackID int, version *int) (*client.StackPayload, error) {
+	return c.replayer.payload(edgeStackID, version)
+}
+
+func (c *replayClient) SetEdgeStackStatus(edgeStackID int, edgeStackStatus portainer.EdgeStackStatusType, rollbackTo *int, errMessage string) error {
+	c.replayer.capture(KindClient, MethodSetEdgeStackStatus, StatusArgs{EdgeStackID: edgeStackID, Status: edgeStackStatus, RollbackTo: rollbackTo, Error: errMessage}, nil, nil)
+
+	return nil
+}
+
+func (c *replayClient) SetEdgeStackError(edgeStackID int, rollbackTo *int, errMessage, errorClass string) error {
+	c.replayer.capture(KindClient, MethodSetEdgeStackStatus, StatusArgs{EdgeStackID: edgeStackID, Status: portainer.EdgeStackStatusError, RollbackTo: rollbackTo, Error: errMessage, ErrorClass: errorClass}, nil, nil)
+
+	return nil
+}
+
+func (c *replayClient) SetEdgeStackBatchStatus(batchID int, status client.StackBatchStatus) error {
+	c.replayer.capture(KindClient, MethodSetEdgeStackBatchStatus, map[string]any{"batchID": batchID, "status": status}, nil, nil)
+
+	return nil
+}
+
+func (c *replayClient) SetEdgeStackJobResult(edgeStackID int, result client.StackJobResult) error {
+	c.replayer.capture(KindClient, MethodSetEdgeStackJobResult, map[string]any{"edgeStackID": edgeStackID, "result": result}, nil, nil)
+
+	return nil
+}
+
+func (c *replayClient) SetEdgeStackServicesStatus(edgeStackID int, services map[string]client.StackServiceStatus) error {
+	c.replayer.capture(KindClient, MethodSetEdgeStackServicesStatus, map[string]any{"edgeStackID": edgeStackID, "services": services}, nil, nil)
+
+	return nil
+}
+
+func (c *replayClient) SetEdgeStackStaged(edgeStackID int, staged client.StackStaged) error {
+	c.replayer.capture(KindClient, MethodSetEdgeStackStaged, map[string]any{"edgeStackID": edgeStackID, "staged": staged}, nil, nil)
+
+	return nil
+}
+
+func (c *replayClient) SetEdgeStackConfigHash(edgeStackID int, hash client.StackConfigHash) error {
+	c.replayer.capture(KindClient, MethodSetEdgeStackConfigHash, map[string]any{"edgeStackID": edgeStackID, "hash": hash}, nil, nil)
+
+	return nil
+}
+
+func (c *replayClient) SetEdgeStackInventory(stacks []client.DeployedStack) error {
+	c.replayer.capture(KindClient, MethodSetEdgeStackInventory, map[string]any{"stacks": stacks}, nil, nil)
+
+	return nil
+}
+
+// SetEdgeStackUsage, SetEdgeStackPullProgress, SetEdgeStackChangelog and SetEdgeStackRemediation are not recorded,
+// they are ignored
+func (c *replayClient) SetEdgeStackUsage(edgeStackID int, usage client.StackUsage) error {
+	return nil
+}
+
+func (c *replayClient) SetEdgeStackPullProgress(edgeStackID int, progress client.StackPullProgress) error {
+	return nil
+}
+
+func (c *replayClient) SetEdgeStackChangelog(edgeStackID int, changelog client.StackChangelog) error {
+	return nil
+}
+
+func (c *replayClient) SetEdgeStackRemediation(edgeStackID int, remediation client.StackRemediation) error {
+	return nil
+}
+
+// replayDeployer is the deployer of a replayed session, it returns the recorded outcomes of the calls in order
+type replayDeployer struct {
+	replayer *Replayer
+}
+
+func (d *replayDeployer) replay(method, name string, filePaths []string) error {
+	var err error
+	if entry, ok := d.replayer.nextDeployerEntry(method, name, ""); ok && entry.Error != "" {
+		err = errors.New(entry.Error)
+	}
+
+	d.replayer.capture(KindDeployer, method, DeployerArgs{Name: name, FilePaths: filePaths}, nil, err)
+
+	return err
+}
+
+func (d *replayDeployer) Deploy(ctx context.Context, name string, filePaths []string, options agent.DeployOptions) error {
+	return d.replay(MethodDeploy, name, filePaths)
+}
+
+func (d *replayDeployer) Remove(ctx context.Context, name string, filePaths []string, options agent.RemoveOptions) error {
+	return d.replay(MethodRemove, name, filePaths)
+}
+
+func (d *replayDeployer) Pull(ctx context.Context, name string, filePaths []string, options agent.PullOptions) error {
+	return d.replay(MethodPull, name, filePaths)
+}
+
+func (d *replayDeployer) Validate(ctx context.Context, name string, filePaths []string, options agent.ValidateOptions) error {
+	return d.replay(MethodValidate, name, filePaths)
+}
+
+func (d *replayDeployer) Restart(ctx context.Context, name string, filePaths []string, options agent.DeployOptions) error {
+	return d.replay(MethodRestart, name, filePaths)
+}
+
+// WaitForStatus returns the recorded result, the required status when none is recorded
+func (d *replayDeployer) WaitForStatus(ctx context.Context, name string, status libstack.Status) <-chan libstack.WaitResult {
+	result := libstack.WaitResult{Status: status}
+
+	if entry, ok := d.replayer.nextDeployerEntry(MethodWaitForStatus, name, status); ok {
+		_ = json.Unmarshal(entry.Result, &result)
+	}
+
+	d.replayer.capture(KindDeployer, MethodWaitForStatus, DeployerArgs{Name: name, Status: status}, result, nil)
+
+	results := make(chan libstack.WaitResult, 1)
+	results <- result
+
+	return results
+}
@@ -0,0 +1,232 @@
+// Package session records the sessions of the agent with the Portainer server, the poll responses, the stack payloads,
+// the statuses reported and the outcomes of the deployer, so that they can be replayed offline against the stack
+// manager to reproduce deterministically the bugs reported by the devices of the fleet.
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/portainer/agent"
+	"github.com/portainer/agent/clock"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// KindClient are the calls to the Portainer server
+	KindClient = "client"
+	// KindDeployer are the calls to the deployer of the engine
+	KindDeployer = "deployer"
+)
+
+// Redacted replaces the secrets in the recorded sessions
+const Redacted = "<redacted>"
+
+var (
+	// secretField matches the fields whose string values are redacted, e.g. the tunnel credentials of the poll
+	// responses or the secrets of the registry credentials
+	secretField = regexp.MustCompile(`(?i)(password|passwd|secret|token|credentials|apikey|privatekey)`)
+	// secretName matches the names of the variables whose values are redacted
+	secretName = regexp.MustCompile(`(?i)(password|passwd|secret|token|key|credential)`)
+)
+
+// Entry is a call recorded in a session
+type Entry struct {
+	Time   time.Time       `json:"time"`
+	Kind   string          `json:"kind"`
+	Method string          `json:"method"`
+	Args   json.RawMessage `json:"args,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// Recorder appends the calls of a session to a file, one JSON entry per line, with their secrets redacted
+type Recorder struct {
+	file    *os.File
+	maxSize int64
+	written int64
+	clock   agent.Clock
+	mu      sync.Mutex
+}
+
+// NewRecorder returns a pointer to a new instance of Recorder appending to the file at path, the recording stops
+// once the file reaches maxSize bytes, unlimited when 0
+func NewRecorder(path string, maxSize int64) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+
+		return nil, err
+	}
+
+	return &Recorder{file: file, maxSize: maxSize, written: info.Size(), clock: clock.NewSystemClock()}, nil
+}
+
+// Record appends a call to the session
+func (r *Recorder) Record(kind, method string, args, result any, callErr error) {
+	entry := Entry{Kind: kind, Method: method}
+
+	var err error
+	if entry.Args, err = redact(args); err != nil {
+		log.Warn().Err(err).Str("method", method).Msg("unable to record the call")
+
+		return
+	}
+
+	if callErr != nil {
+		entry.Error = callErr.Error()
+	} else if entry.Result, err = redact(result); err != nil {
+		log.Warn().Err(err).Str("method", method).Msg("unable to record the call")
+
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return
+	}
+
+	entry.Time = r.clock.Now()
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Warn().Err(err).Str("method", method).Msg("unable to record the call")
+
+		return
+	}
+
+	line = append(line, '\n')
+
+	if r.maxSize > 0 && r.written+int64(len(line)) > r.maxSize {
+		log.Warn().Str("path", r.file.Name()).Int64("max_size", r.maxSize).Msg("the recorded session reached its maximum size, the recording is stopped")
+
+		r.file.Close()
+		r.file = nil
+
+		return
+	}
+
+	n, err := r.file.Write(line)
+	r.written += int64(n)
+	if err != nil {
+		log.Warn().Err(err).Str("path", r.file.Name()).Msg("unable to record the call")
+	}
+}
+
+// Close stops the recording
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+
+	err := r.file.Close()
+	r.file = nil
+
+	return err
+}
+
+// Read returns the entries of the session recorded in the file at path. The last entry is ignored when it was only
+// partially written.
+func Read(path string) ([]Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []Entry
+
+	decoder := json.NewDecoder(file)
+	for {
+		var entry Entry
+
+		err := decoder.Decode(&entry)
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		} else if errors.Is(err, io.ErrUnexpectedEOF) {
+			log.Warn().Str("path", path).Msg("the last entry of the session is truncated")
+
+			return entries, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid entry %d of the session: %w", len(entries)+1, err)
+		}
+
+		entries = append(entries, entry)
+	}
+}
+
+// redact returns the JSON encoding of v with its secrets redacted
+func redact(v any) (json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var decoded any
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(redactValue(decoded))
+}
+
+func redactValue(v any) any {
+	switch value := v.(type) {
+	case map[string]any:
+		for key, field := range value {
+			if _, ok := field.(string); ok && secretField.MatchString(key) {
+				value[key] = Redacted
+
+				continue
+			}
+
+			value[key] = redactValue(field)
+		}
+
+		redactPair(value)
+	case []any:
+		for i, item := range value {
+			value[i] = redactValue(item)
+		}
+	}
+
+	return v
+}
+
+// redactPair redacts the value of the name and value pairs naming a secret, e.g. the environment variables of the
+// stacks
+func redactPair(pair map[string]any) {
+	for _, nameKey := range []string{"name", "Name"} {
+		name, ok := pair[nameKey].(string)
+		if !ok || !secretName.MatchString(name) {
+			continue
+		}
+
+		for _, valueKey := range []string{"value", "Value"} {
+			if _, ok := pair[valueKey].(string); ok {
+				pair[valueKey] = Redacted
+			}
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/portainer/agent/edge/client"
+	portainer "github.com/portainer/portainer/api"
+	"github.com/portainer/portainer/api/edge"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder_redactsSecrets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+
+	recorder, err := NewRecorder(path, 0)
+	require.NoError(t, err)
+
+	recorder.Record(KindClient, MethodGetEnvironmentStatus, nil, &client.PollStatusResponse{Credentials: "tunnel:s3cret"}, nil)
+	recorder.Record(KindClient, MethodGetEdgeStackConfig, StackArgs{EdgeStackID: 1}, &client.StackPayload{StackPayload: edge.StackPayload{
+		ID:                  1,
+		RegistryCredentials: []edge.RegistryCredentials{{ServerURL: "registry.example.com", Username: "edge", Secret: "s3cret"}},
+		EnvVars:             []portainer.Pair{{Name: "DB_PASSWORD", Value: "s3cret"}, {Name: "PORT", Value: "8080"}},
+	}}, nil)
+	recorder.Record(KindDeployer, MethodDeploy, DeployerArgs{Name: "edge_web"}, nil, errors.New("pull timeout"))
+	require.NoError(t, recorder.Close())
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(content), "s3cret")
+
+	entries, err := Read(path)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+
+	var status client.PollStatusResponse
+	require.NoError(t, json.Unmarshal(entries[0].Result, &status))
+	assert.Equal(t, Redacted, status.Credentials)
+
+	var payload client.StackPayload
+	require.NoError(t, json.Unmarshal(entries[1].Result, &payload))
+	assert.Equal(t, "edge", payload.RegistryCredentials[0].Username)
+	assert.Equal(t, Redacted, payload.RegistryCredentials[0].Secret)
+	assert.Equal(t, []portainer.Pair{{Name: "DB_PASSWORD", Value: Redacted}, {Name: "PORT", Value: "8080"}}, payload.EnvVars)
+
+	assert.Equal(t, "pull timeout", entries[2].Error)
+	assert.Empty(t, entries[2].Result)
+}
+
+func TestRecorder_maxSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+
+	recorder, err := NewRecorder(path, 250)
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		recorder.Record(KindDeployer, MethodPull, DeployerArgs{Name: "edge_web"}, nil, nil)
+	}
+
+	entries, err := Read(path)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, info.Size(), int64(250))
+}
+
+func TestRead_truncated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+
+	err := os.WriteFile(path, []byte(`{"kind":"deployer","method":"Pull"}`+"\n"+`{"kind":"deployer","met`), 0600)
+	require.NoError(t, err)
+
+	entries, err := Read(path)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestDiverge(t *testing.T) {
+	entry := func(kind, method, args string) Entry {
+		return Entry{Time: time.Now(), Kind: kind, Method: method, Args: json.RawMessage(args)}
+	}
+
+	recorded := []Entry{
+		entry(KindDeployer, MethodDeploy, `{"name":"edge_web","filePaths":["/tmp/edge_stacks/1/docker-compose.yml"]}`),
+		entry(KindClient, MethodSetEdgeStackStaged, `{"edgeStackID":1,"staged":{"Barrier":"b","Version":2,"Time":1000}}`),
+		entry(KindClient, MethodSetEdgeStackStatus, `{"edgeStackID":1,"status":7}`),
+	}
+
+	replayed := []Entry{
+		entry(KindDeployer, MethodDeploy, `{"name":"edge_web","filePaths":["/tmp/agent-replay/1/docker-compose.yml"]}`),
+		entry(KindClient, MethodSetEdgeStackStaged, `{"edgeStackID":1,"staged":{"Barrier":"b","Version":2,"Time":2000}}`),
+		entry(KindClient, MethodSetEdgeStackStatus, `{"edgeStackID":1,"status":7}`),
+	}
+
+	assert.Equal(t, -1, Diverge(recorded, replayed))
+	assert.Equal(t, 2, Diverge(recorded, replayed[:2]))
+
+	replayed[2] = entry(KindClient, MethodSetEdgeStackStatus, `{"edgeStackID":1,"status":4,"error":"failed"}`)
+	assert.Equal(t, 2, Diverge(recorded, replayed))
+}
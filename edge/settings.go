@@ -0,0 +1,64 @@
+package edge
+
+import (
+	"sync"
+
+	agentos "github.com/portainer/agent/os"
+)
+
+// serverSettings are the settings sent by the Portainer server, reported along with the settings of the agent
+type serverSettings struct {
+	values   map[string]string
+	defaults map[string]string
+	mu       sync.Mutex
+}
+
+// set records the value of a setting sent by the server
+func (s *serverSettings) set(name, value, defaultValue string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.values == nil {
+		s.values = make(map[string]string)
+		s.defaults = make(map[string]string)
+	}
+
+	s.values[name] = value
+	s.defaults[name] = defaultValue
+}
+
+func (s *serverSettings) list() []agentos.Setting {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	settings := make([]agentos.Setting, 0, len(s.values))
+	for name, value := range s.values {
+		settings = append(settings, agentos.Setting{
+			Name:      name,
+			Subsystem: "edge",
+			Value:     value,
+			Default:   s.defaults[name],
+			Source:    agentos.SourceServer,
+		})
+	}
+
+	return settings
+}
+
+// EffectiveSettings returns the settings of the agent along with the ones resolved once running, the Edge key read
+// from the data folder and the intervals sent by the Portainer server
+func (manager *Manager) EffectiveSettings() []agentos.Setting {
+	settings := agentos.EffectiveSettings()
+
+	for i, setting := range settings {
+		if setting.Name == agentos.EnvKeyEdgeKey && setting.Source == agentos.SourceDefault && manager.IsKeySet() {
+			settings[i].Value = agentos.RedactedValue
+			settings[i].Source = agentos.SourceFile
+		}
+	}
+
+	settings = append(settings, manager.serverSettings.list()...)
+	agentos.SortSettings(settings)
+
+	return settings
+}
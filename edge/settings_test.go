@@ -0,0 +1,29 @@
+package edge
+
+import (
+	"testing"
+
+	agentos "github.com/portainer/agent/os"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManager_EffectiveSettings(t *testing.T) {
+	manager := &Manager{}
+	manager.serverSettings.set("checkin_interval", "1m0s", "5s")
+
+	settings := manager.EffectiveSettings()
+
+	assert.Contains(t, settings, agentos.Setting{
+		Name:      "checkin_interval",
+		Subsystem: "edge",
+		Value:     "1m0s",
+		Default:   "5s",
+		Source:    agentos.SourceServer,
+	})
+
+	for _, setting := range settings {
+		if setting.Name == agentos.EnvKeyEdgeKey {
+			assert.Equal(t, agentos.SourceDefault, setting.Source)
+		}
+	}
+}
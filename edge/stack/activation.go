@@ -0,0 +1,79 @@
+package stack
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/portainer/agent/edge/client"
+
+	"github.com/rs/zerolog/log"
+)
+
+// localActivationLayout is the layout of the activation times interpreted in the time zone of the device
+const localActivationLayout = "2006-01-02T15:04:05"
+
+// activationTime returns the time the stack must be deployed at, the zero time when it is not deferred
+func activationTime(activation *client.StackActivation, loc *time.Location) (time.Time, error) {
+	if activation == nil || activation.Time == "" {
+		return time.Time{}, nil
+	}
+
+	if activation.Local {
+		t, err := time.ParseInLocation(localActivationLayout, activation.Time, loc)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid local activation time %q: %w", activation.Time, err)
+		}
+
+		return t, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, activation.Time)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid activation time %q: %w", activation.Time, err)
+	}
+
+	return t, nil
+}
+
+// activationPending returns true when the deployment of the stack is deferred to a future time.
+// The caller must hold the manager lock.
+func (manager *StackManager) activationPending(stack *edgeStack) bool {
+	return !stack.ActivateAt.IsZero() && manager.now().Before(stack.ActivateAt)
+}
+
+// deferDeploy moves the stack to StatusScheduled when its activation time is not reached yet,
+// its images are already pulled and its files staged.
+func (manager *StackManager) deferDeploy(stack *edgeStack) bool {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	if stack.Action == actionDelete || !manager.activationPending(stack) {
+		return false
+	}
+
+	log.Info().
+		Int("stack_identifier", stack.ID).
+		Int("stack_version", stack.Version).
+		Time("activate_at", stack.ActivateAt).
+		Msg("stack staged, deployment scheduled")
+
+	manager.setStatus(stack, StatusScheduled)
+
+	return true
+}
+
+// nextActivatedStack moves the first scheduled stack whose activation time is reached back to StatusPending.
+// The caller must hold the manager lock.
+func (manager *StackManager) nextActivatedStack() *edgeStack {
+	for _, stack := range manager.sortedStacks() {
+		if stack.Status == StatusScheduled && !manager.activationPending(stack) {
+			log.Debug().Int("stack_identifier", stack.ID).Msg("activating scheduled stack")
+
+			manager.setStatus(stack, StatusPending)
+
+			return stack
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package stack
+
+import (
+	"testing"
+	"time"
+
+	"github.com/portainer/agent/clock"
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/portainer/api/edge"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestActivationTime(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	require.NoError(t, err)
+
+	at, err := activationTime(nil, paris)
+	require.NoError(t, err)
+	assert.True(t, at.IsZero())
+
+	at, err = activationTime(&client.StackActivation{Time: "2024-06-01T02:00:00Z"}, paris)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 6, 1, 2, 0, 0, 0, time.UTC), at.UTC())
+
+	at, err = activationTime(&client.StackActivation{Time: "2024-06-01T02:00:00", Local: true}, paris)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), at.UTC())
+
+	_, err = activationTime(&client.StackActivation{Time: "tomorrow"}, paris)
+	assert.Error(t, err)
+}
+
+func TestStackManager_deferDeploy(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Unix(1000, 0))
+
+	stack := &edgeStack{
+		StackPayload: edge.StackPayload{ID: 1},
+		Status:       StatusDeploying,
+		Action:       actionDeploy,
+		ActivateAt:   time.Unix(1000, 0).Add(time.Hour),
+	}
+
+	manager := &StackManager{
+		clock:  fakeClock,
+		stacks: map[edgeStackID]*edgeStack{1: stack},
+	}
+
+	assert.True(t, manager.deferDeploy(stack))
+	assert.Equal(t, StatusScheduled, stack.Status)
+	assert.Nil(t, manager.nextActivatedStack())
+
+	fakeClock.Advance(time.Hour)
+
+	assert.Equal(t, stack, manager.nextActivatedStack())
+	assert.Equal(t, StatusPending, stack.Status)
+	assert.False(t, manager.deferDeploy(stack))
+}
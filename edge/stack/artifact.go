@@ -0,0 +1,34 @@
+package stack
+
+import (
+	"context"
+
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/agent/edge/oci"
+	"github.com/portainer/portainer/api/filesystem"
+
+	"github.com/rs/zerolog/log"
+)
+
+// pullStackArtifact pulls and verifies the OCI artifact holding the stack files, using the
+// stack credentials of the artifact registry
+func (manager *StackManager) pullStackArtifact(ctx context.Context, stack *edgeStack, artifact *client.StackArtifact) ([]filesystem.DirEntry, error) {
+	registry, err := oci.RegistryDomain(artifact.Reference)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debug().
+		Int("stack_identifier", stack.ID).
+		Str("reference", artifact.Reference).
+		Msg("pulling the stack OCI artifact")
+
+	return manager.artifactClient.Pull(ctx, oci.PullOptions{
+		Reference:   artifact.Reference,
+		Digest:      artifact.Digest,
+		PublicKey:   artifact.PublicKey,
+		Signature:   artifact.Signature,
+		PlainHTTP:   artifact.PlainHTTP,
+		Credentials: findRegistryCredentials(stack.RegistryCredentials, registry),
+	})
+}
@@ -0,0 +1,91 @@
+package stack
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/portainer/agent/edge/client"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ReleaseStackBarrier releases the barrier a version of a stack waits at, the stack is deployed at releaseAt
+func (manager *StackManager) ReleaseStackBarrier(stackID, version int, releaseAt time.Time) error {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	stack, ok := manager.stacks[edgeStackID(stackID)]
+	if !ok || stack.Version != version {
+		return fmt.Errorf("version %d of stack %d not found", version, stackID)
+	}
+
+	manager.releaseBarrier(stack, releaseAt)
+
+	return nil
+}
+
+// releaseBarriers releases the barriers of the stacks released by the server.
+// The caller must hold the manager lock.
+func (manager *StackManager) releaseBarriers(pollResponseStacks map[int]client.StackStatus) {
+	for stackID, status := range pollResponseStacks {
+		if status.BarrierReleaseAt == 0 {
+			continue
+		}
+
+		if stack, ok := manager.stacks[edgeStackID(stackID)]; ok && stack.Version == status.Version {
+			manager.releaseBarrier(stack, time.Unix(status.BarrierReleaseAt, 0))
+		}
+	}
+}
+
+// releaseBarrier lets the stack be deployed at releaseAt, a staged stack is processed again right away.
+// The caller must hold the manager lock.
+func (manager *StackManager) releaseBarrier(stack *edgeStack, releaseAt time.Time) {
+	if stack.Barrier == "" || stack.BarrierReleased {
+		return
+	}
+
+	log.Info().
+		Int("stack_identifier", stack.ID).
+		Str("barrier", stack.Barrier).
+		Time("release_at", releaseAt).
+		Msg("stack barrier released")
+
+	stack.BarrierReleased = true
+	if releaseAt.After(stack.ActivateAt) {
+		stack.ActivateAt = releaseAt
+	}
+
+	if stack.Status == StatusStaged {
+		manager.setStatus(stack, StatusPending)
+	}
+}
+
+// waitAtBarrier moves the stack to StatusStaged and reports it to the server when it waits for its
+// barrier to be released, its images are already pulled and its files staged.
+func (manager *StackManager) waitAtBarrier(stack *edgeStack) bool {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	if stack.Action == actionDelete || stack.Barrier == "" || stack.BarrierReleased {
+		return false
+	}
+
+	log.Info().
+		Int("stack_identifier", stack.ID).
+		Int("stack_version", stack.Version).
+		Str("barrier", stack.Barrier).
+		Msg("stack staged, waiting for the barrier to be released")
+
+	manager.setStatus(stack, StatusStaged)
+
+	if err := manager.portainerClient.SetEdgeStackStaged(stack.ID, client.StackStaged{
+		Barrier: stack.Barrier,
+		Version: stack.Version,
+		Time:    manager.now().Unix(),
+	}); err != nil {
+		log.Error().Err(err).Int("stack_identifier", stack.ID).Msg("unable to report the staged Edge stack")
+	}
+
+	return true
+}
@@ -0,0 +1,72 @@
+package stack
+
+import (
+	"testing"
+	"time"
+
+	"github.com/portainer/agent/clock"
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/agent/internals/mocks"
+	"github.com/portainer/portainer/api/edge"
+	"github.com/stretchr/testify/assert"
+	gomock "go.uber.org/mock/gomock"
+)
+
+func TestStackManager_barrier(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClient := mocks.NewMockPortainerClient(ctrl)
+	fakeClock := clock.NewFakeClock(time.Unix(1000, 0))
+
+	stack := &edgeStack{
+		StackPayload: edge.StackPayload{ID: 1, Version: 2},
+		Status:       StatusDeploying,
+		Action:       actionUpdate,
+		Barrier:      "rollout-42",
+	}
+
+	manager := &StackManager{
+		portainerClient: mockClient,
+		clock:           fakeClock,
+		stacks:          map[edgeStackID]*edgeStack{1: stack},
+	}
+
+	mockClient.EXPECT().SetEdgeStackStaged(1, client.StackStaged{Barrier: "rollout-42", Version: 2, Time: 1000})
+
+	assert.True(t, manager.waitAtBarrier(stack))
+	assert.Equal(t, StatusStaged, stack.Status)
+
+	// Releases of other versions are ignored
+	manager.releaseBarriers(map[int]client.StackStatus{1: {ID: 1, Version: 1, BarrierReleaseAt: 1060}})
+	assert.Equal(t, StatusStaged, stack.Status)
+
+	manager.releaseBarriers(map[int]client.StackStatus{1: {ID: 1, Version: 2, BarrierReleaseAt: 1060}})
+	assert.Equal(t, StatusPending, stack.Status)
+	assert.Equal(t, time.Unix(1060, 0), stack.ActivateAt)
+
+	// The released stack is deployed at the release time
+	manager.setStatus(stack, StatusDeploying)
+	assert.False(t, manager.waitAtBarrier(stack))
+	assert.True(t, manager.deferDeploy(stack))
+
+	fakeClock.Advance(time.Minute)
+	assert.Equal(t, stack, manager.nextActivatedStack())
+}
+
+func TestStackManager_ReleaseStackBarrier(t *testing.T) {
+	manager := &StackManager{
+		clock: clock.NewFakeClock(time.Unix(1000, 0)),
+		stacks: map[edgeStackID]*edgeStack{
+			1: {StackPayload: edge.StackPayload{ID: 1, Version: 2}, Status: StatusPending, Barrier: "rollout-42"},
+		},
+	}
+
+	assert.Error(t, manager.ReleaseStackBarrier(1, 1, time.Unix(1000, 0)))
+	assert.Error(t, manager.ReleaseStackBarrier(2, 2, time.Unix(1000, 0)))
+
+	// A stack released before being staged is not staged
+	assert.NoError(t, manager.ReleaseStackBarrier(1, 2, time.Unix(900, 0)))
+	assert.True(t, manager.stacks[1].BarrierReleased)
+	assert.Equal(t, StatusPending, manager.stacks[1].Status)
+}
@@ -0,0 +1,468 @@
+package stack
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/portainer/agent/edge/client"
+	portainer "github.com/portainer/portainer/api"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultBatchTimeout is how long the stacks of a batch can be retried before the batch is rolled back
+const DefaultBatchTimeout = 10 * time.Minute
+
+// batchState is the composite state of a batch of stacks deployed as a single unit
+type batchState int
+
+const (
+	_ batchState = iota
+	batchDeploying
+	batchCompleted
+	batchRollingBack
+	batchRolledBack
+	batchFailed
+)
+
+func (s batchState) String() string {
+	switch s {
+	case batchDeploying:
+		return "Deploying"
+	case batchCompleted:
+		return "Completed"
+	case batchRollingBack:
+		return "RollingBack"
+	case batchRolledBack:
+		return "RolledBack"
+	case batchFailed:
+		return "Failed"
+	}
+
+	return fmt.Sprintf("Unknown(%d)", int(s))
+}
+
+type batchMember struct {
+	// Changed is false when the stack was already at the requested version when the batch started
+	Changed bool
+	// Existed is false when the stack was deployed for the first time by the batch
+	Existed bool
+	// PreviousVersion is the retained version the stack is rolled back to, 0 if none
+	PreviousVersion int
+	Version         int
+	Status          edgeStackStatus
+	Removed         bool
+	// Unrecoverable is true when the stack had no retained version to be rolled back to
+	Unrecoverable bool
+	// RolledBack is true once the rollback of the stack is requested or nothing had to be rolled back
+	RolledBack bool
+}
+
+type stackBatch struct {
+	ID        int
+	State     batchState
+	Members   map[int]*batchMember
+	StartedAt time.Time
+	Error     string
+
+	lastReport string
+}
+
+// BeginStackBatch starts deploying the given stacks as a single unit: either all of them end up
+// running their new version or the ones that changed are rolled back to their previous version.
+// It must be called before the stacks are deployed.
+func (manager *StackManager) BeginStackBatch(batchID int, stacks []client.StackPayload) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	versions := make(map[int]int, len(stacks))
+	for _, stack := range stacks {
+		versions[stack.ID] = stack.Version
+	}
+
+	manager.beginBatch(batchID, versions)
+}
+
+// AbortStackBatch rolls back a batch of stacks being deployed
+func (manager *StackManager) AbortStackBatch(batchID int, reason string) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	batch, ok := manager.batches[batchID]
+	if !ok || batch.State != batchDeploying {
+		return
+	}
+
+	manager.startBatchRollback(batch, reason)
+}
+
+// batchVersions groups the requested versions of the stacks by batch
+func batchVersions(pollResponseStacks map[int]client.StackStatus) map[int]map[int]int {
+	batches := make(map[int]map[int]int)
+
+	for stackID, status := range pollResponseStacks {
+		if status.BatchID == 0 {
+			continue
+		}
+
+		if batches[status.BatchID] == nil {
+			batches[status.BatchID] = make(map[int]int)
+		}
+
+		batches[status.BatchID][stackID] = status.Version
+	}
+
+	return batches
+}
+
+// beginBatch starts tracking a batch when at least one of its stacks is requested at a new version.
+// It must be called before the stacks are processed, so that their previous versions are known.
+// The caller must hold the manager lock.
+func (manager *StackManager) beginBatch(batchID int, versions map[int]int) {
+	if batch, ok := manager.batches[batchID]; ok && (batch.State == batchDeploying || batch.State == batchRollingBack) {
+		return
+	}
+
+	batch := &stackBatch{
+		ID:        batchID,
+		State:     batchDeploying,
+		Members:   make(map[int]*batchMember, len(versions)),
+		StartedAt: manager.now(),
+	}
+
+	var changed bool
+
+	for stackID, version := range versions {
+		member := &batchMember{Version: version}
+
+		stack, ok := manager.stacks[edgeStackID(stackID)]
+		if ok {
+			member.Existed = true
+			member.Status = stack.Status
+		}
+
+		if rolledBackVersion, ok := manager.rolledBackBatchStacks[stackID]; ok && rolledBackVersion == version {
+			// The version was already rolled back with a previous batch
+			continue
+		}
+
+		if !ok || stack.Version != version {
+			member.Changed = true
+			changed = true
+
+			if ok {
+				member.PreviousVersion = manager.latestRetainedVersion(stack)
+			}
+		}
+
+		batch.Members[stackID] = member
+	}
+
+	if !changed {
+		return
+	}
+
+	log.Info().Int("batch_identifier", batchID).Int("stacks", len(batch.Members)).Msg("deploying Edge stack batch")
+
+	if manager.batches == nil {
+		manager.batches = make(map[int]*stackBatch)
+		manager.stackBatches = make(map[int]int)
+		manager.rolledBackBatchStacks = make(map[int]int)
+	}
+
+	manager.batches[batchID] = batch
+	for stackID := range batch.Members {
+		manager.stackBatches[stackID] = batchID
+	}
+
+	manager.reportBatch(batch)
+}
+
+// isRolledBackBatchStack returns true when the version of the stack was rolled back with its batch,
+// the tombstone is cleared once a different version is requested
+func (manager *StackManager) isRolledBackBatchStack(stackID, version int) bool {
+	rolledBackVersion, ok := manager.rolledBackBatchStacks[stackID]
+	if !ok {
+		return false
+	}
+
+	if rolledBackVersion == version {
+		return true
+	}
+
+	delete(manager.rolledBackBatchStacks, stackID)
+
+	return false
+}
+
+// latestRetainedVersion returns the most recently deployed retained version of the stack, 0 if none
+func (manager *StackManager) latestRetainedVersion(stack *edgeStack) int {
+	history, err := listStackHistory(HistoryStackFileFolder(stack.FileFolder))
+	if err != nil || len(history) == 0 {
+		return 0
+	}
+
+	return history[0].Version
+}
+
+// updateBatchMember records the new status of a stack and updates its batch.
+// The caller must hold the manager lock.
+func (manager *StackManager) updateBatchMember(stack *edgeStack, removed bool) {
+	batchID, ok := manager.stackBatches[stack.ID]
+	if !ok {
+		return
+	}
+
+	batch := manager.batches[batchID]
+
+	member, ok := batch.Members[stack.ID]
+	if !ok {
+		return
+	}
+
+	member.Status = stack.Status
+	member.Removed = member.Removed || removed
+
+	manager.evaluateBatch(batch)
+}
+
+func (manager *StackManager) evaluateBatch(batch *stackBatch) {
+	switch batch.State {
+	case batchDeploying:
+		if stackID, failed := manager.failedBatchMember(batch); failed {
+			manager.startBatchRollback(batch, fmt.Sprintf("stack %d failed to deploy", stackID))
+
+			return
+		}
+
+		if manager.readyBatchMembers(batch) == len(batch.Members) {
+			manager.finishBatch(batch, batchCompleted)
+
+			return
+		}
+	case batchRollingBack:
+		var rolledBack int
+
+		for stackID, member := range batch.Members {
+			switch {
+			case !member.Changed || member.Removed || member.Unrecoverable:
+				rolledBack++
+			case !member.RolledBack:
+			case member.Status == StatusError || member.Status == StatusIntegrityError:
+				if member.Existed && member.PreviousVersion > 0 {
+					batch.Error = fmt.Sprintf("%s, stack %d failed to roll back", batch.Error, stackID)
+					manager.finishBatch(batch, batchFailed)
+
+					return
+				}
+
+				// Stacks never deployed before the batch are left in error
+				rolledBack++
+			case member.Status == StatusDeployed || member.Status == StatusCompleted:
+				rolledBack++
+			}
+		}
+
+		if rolledBack == len(batch.Members) {
+			state := batchRolledBack
+			for _, member := range batch.Members {
+				if member.Unrecoverable {
+					state = batchFailed
+				}
+			}
+
+			manager.finishBatch(batch, state)
+
+			return
+		}
+	default:
+		return
+	}
+
+	manager.reportBatch(batch)
+}
+
+func (manager *StackManager) startBatchRollback(batch *stackBatch, reason string) {
+	log.Error().Int("batch_identifier", batch.ID).Str("reason", reason).Msg("Edge stack batch failed, rolling back")
+
+	batch.Error = reason
+	batch.State = batchRollingBack
+
+	manager.reportBatch(batch)
+	manager.rollbackBatch(batch)
+
+	// The rollback of the stacks can be complete already
+	manager.evaluateBatch(batch)
+}
+
+func (manager *StackManager) failedBatchMember(batch *stackBatch) (int, bool) {
+	timedOut := manager.batchTimeout > 0 && manager.now().Sub(batch.StartedAt) > manager.batchTimeout
+
+	for stackID, member := range batch.Members {
+		if !member.Changed {
+			continue
+		}
+
+		if member.Status == StatusError || member.Status == StatusIntegrityError || (member.Status == StatusRetry && timedOut) {
+			return stackID, true
+		}
+	}
+
+	return 0, false
+}
+
+func (manager *StackManager) readyBatchMembers(batch *stackBatch) int {
+	var ready int
+
+	for _, member := range batch.Members {
+		if !member.Changed || member.Status == StatusDeployed || member.Status == StatusCompleted {
+			ready++
+		}
+	}
+
+	return ready
+}
+
+// rollbackBatch rolls back the changed stacks of the batch to their previous versions,
+// the stacks deployed for the first time by the batch are removed
+func (manager *StackManager) rollbackBatch(batch *stackBatch) {
+	stackIDs := make([]int, 0, len(batch.Members))
+	for stackID := range batch.Members {
+		stackIDs = append(stackIDs, stackID)
+	}
+
+	sort.Ints(stackIDs)
+
+	for _, stackID := range stackIDs {
+		member := batch.Members[stackID]
+		if !member.Changed {
+			continue
+		}
+
+		member.RolledBack = true
+
+		// The stacks not processed yet must not be deployed at the version being rolled back
+		manager.rolledBackBatchStacks[stackID] = member.Version
+
+		stack, ok := manager.stacks[edgeStackID(stackID)]
+		if !ok {
+			// The stack was never received
+			member.Removed = true
+
+			continue
+		}
+
+		if stack.Version != member.Version {
+			// The stack is still running its previous version
+			member.Status = stack.Status
+
+			continue
+		}
+
+		if member.Existed {
+			if member.PreviousVersion == 0 {
+				log.Warn().Int("batch_identifier", batch.ID).Int("stack_identifier", stackID).Msg("no retained version to roll back the stack to")
+
+				member.Unrecoverable = true
+
+				continue
+			}
+
+			if err := manager.rollbackStack(stackID, member.PreviousVersion); err != nil {
+				log.Error().Err(err).Int("batch_identifier", batch.ID).Int("stack_identifier", stackID).Msg("unable to roll back the stack")
+
+				batch.Error = fmt.Sprintf("%s, stack %d failed to roll back", batch.Error, stackID)
+			}
+
+			continue
+		}
+
+		manager.removeBatchStack(batch, stack)
+	}
+}
+
+// removeBatchStack removes a stack deployed for the first time by a batch being rolled back.
+// Stacks that never reached a deployed status have nothing to remove and are moved to StatusError.
+func (manager *StackManager) removeBatchStack(batch *stackBatch, originalStack *edgeStack) {
+	clonedStack := *originalStack
+	stack := &clonedStack
+
+	manager.putStack(stack)
+
+	if _, err := os.Stat(SuccessStackFileFolder(stack.FileFolder)); err == nil {
+		stack.Action = actionDelete
+		manager.setStatus(stack, StatusPending)
+
+		return
+	}
+
+	stack.Action = actionIdle
+
+	// The stacks waiting to be deployed, e.g. staged or retrying, are moved to StatusError through StatusPending
+	if stack.Status != StatusError && !isStatusTransitionAllowed(stack.Status, StatusError) {
+		manager.setStatus(stack, StatusPending)
+	}
+
+	if !manager.setStatus(stack, StatusError) {
+		log.Error().Int("batch_identifier", batch.ID).Int("stack_identifier", stack.ID).Stringer("status", stack.Status).Msg("unable to move the rolled back stack to the error status")
+
+		return
+	}
+
+	errMsg := fmt.Sprintf("rolled back with the batch %d", batch.ID)
+	if err := manager.portainerClient.SetEdgeStackStatus(stack.ID, portainer.EdgeStackStatusError, stack.RollbackTo, errMsg); err != nil {
+		log.Error().Err(err).Int("stack_identifier", stack.ID).Msg("unable to update Edge stack status")
+	}
+}
+
+func (manager *StackManager) finishBatch(batch *stackBatch, state batchState) {
+	batch.State = state
+
+	log.Info().Int("batch_identifier", batch.ID).Stringer("state", state).Msg("Edge stack batch finished")
+
+	manager.reportBatch(batch)
+
+	for stackID := range batch.Members {
+		if manager.stackBatches[stackID] == batch.ID {
+			delete(manager.stackBatches, stackID)
+		}
+	}
+
+	delete(manager.batches, batch.ID)
+}
+
+// reportBatch sends the composite status of the batch to the server when it changed
+func (manager *StackManager) reportBatch(batch *stackBatch) {
+	status := client.StackBatchStatus{
+		Status: batch.State.String(),
+		Stacks: make(map[int]string, len(batch.Members)),
+		Ready:  manager.readyBatchMembers(batch),
+		Total:  len(batch.Members),
+		Error:  batch.Error,
+	}
+
+	for stackID, member := range batch.Members {
+		status.Stacks[stackID] = member.Status.String()
+		if member.Removed {
+			status.Stacks[stackID] = "Removed"
+		}
+
+		if member.Unrecoverable {
+			status.Stacks[stackID] = "Unrecoverable"
+		}
+	}
+
+	report := fmt.Sprintf("%v", status)
+	if report == batch.lastReport {
+		return
+	}
+
+	batch.lastReport = report
+	status.Time = manager.now().Unix()
+
+	if err := manager.portainerClient.SetEdgeStackBatchStatus(batch.ID, status); err != nil {
+		log.Error().Err(err).Int("batch_identifier", batch.ID).Msg("unable to update Edge stack batch status")
+	}
+}
@@ -0,0 +1,223 @@
+package stack
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/portainer/agent/clock"
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/agent/internals/mocks"
+	portainer "github.com/portainer/portainer/api"
+	"github.com/portainer/portainer/api/edge"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gomock "go.uber.org/mock/gomock"
+)
+
+func newBatchTestManager(t *testing.T) (*StackManager, *[]client.StackBatchStatus, *mocks.MockPortainerClient) {
+	ctrl := gomock.NewController(t)
+
+	mockPortainerClient := mocks.NewMockPortainerClient(ctrl)
+
+	var reports []client.StackBatchStatus
+	mockPortainerClient.EXPECT().
+		SetEdgeStackBatchStatus(7, gomock.Any()).
+		DoAndReturn(func(_ int, status client.StackBatchStatus) error {
+			reports = append(reports, status)
+			return nil
+		}).
+		AnyTimes()
+
+	manager := &StackManager{
+		clock:           clock.NewFakeClock(time.Unix(1000, 0)),
+		historyCount:    DefaultHistoryRetention,
+		portainerClient: mockPortainerClient,
+		stacks:          map[edgeStackID]*edgeStack{},
+	}
+
+	return manager, &reports, mockPortainerClient
+}
+
+// deployedBatchStack returns a stack deployed at the given version with the version retained in its history
+func deployedBatchStack(t *testing.T, manager *StackManager, id, version int) *edgeStack {
+	fileFolder := filepath.Join(t.TempDir(), "stack")
+	require.NoError(t, os.MkdirAll(fileFolder, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(fileFolder, "docker-compose.yml"), []byte{byte(version)}, 0644))
+
+	stack := &edgeStack{
+		StackPayload: edge.StackPayload{ID: id, Version: version},
+		FileFolder:   fileFolder,
+		FileName:     "docker-compose.yml",
+		Status:       StatusDeployed,
+	}
+	require.NoError(t, manager.saveStackHistory(stack))
+
+	manager.stacks[edgeStackID(id)] = stack
+
+	return stack
+}
+
+// updateBatchStack marks the stack for the given version the way processStack does
+func updateBatchStack(manager *StackManager, id, version int) *edgeStack {
+	stack, ok := manager.stacks[edgeStackID(id)]
+	if !ok {
+		stack = &edgeStack{
+			StackPayload: edge.StackPayload{ID: id},
+			FileFolder:   filepath.Join(os.TempDir(), "portainer-agent-batch-test-missing"),
+			Action:       actionDeploy,
+		}
+	}
+
+	clonedStack := *stack
+	stack = &clonedStack
+	stack.Version = version
+	manager.setStatus(stack, StatusPending)
+	manager.stacks[edgeStackID(id)] = stack
+
+	return stack
+}
+
+func deployBatchStack(manager *StackManager, stack *edgeStack) {
+	manager.setStatus(stack, StatusDeploying)
+	manager.setStatus(stack, StatusAwaitingDeployedStatus)
+	manager.setStatus(stack, StatusDeployed)
+}
+
+func TestStackManager_batchCompleted(t *testing.T) {
+	manager, reports, _ := newBatchTestManager(t)
+
+	deployedBatchStack(t, manager, 1, 1)
+	deployedBatchStack(t, manager, 2, 1)
+
+	manager.beginBatch(7, map[int]int{1: 2, 2: 1})
+	require.Len(t, manager.batches[7].Members, 2)
+	assert.False(t, manager.batches[7].Members[2].Changed)
+	assert.Equal(t, 1, manager.batches[7].Members[1].PreviousVersion)
+
+	stack := updateBatchStack(manager, 1, 2)
+	deployBatchStack(manager, stack)
+
+	require.NotEmpty(t, *reports)
+	last := (*reports)[len(*reports)-1]
+	assert.Equal(t, "Completed", last.Status)
+	assert.Equal(t, 2, last.Ready)
+	assert.Equal(t, 2, last.Total)
+	assert.Empty(t, manager.batches)
+	assert.Empty(t, manager.stackBatches)
+}
+
+func TestStackManager_batchRolledBack(t *testing.T) {
+	manager, reports, mockPortainerClient := newBatchTestManager(t)
+
+	mockPortainerClient.EXPECT().
+		SetEdgeStackStatus(3, portainer.EdgeStackStatusError, gomock.Any(), gomock.Any()).
+		Return(nil)
+
+	deployedBatchStack(t, manager, 1, 1)
+
+	manager.beginBatch(7, map[int]int{1: 2, 3: 1})
+
+	updated := updateBatchStack(manager, 1, 2)
+	deployBatchStack(manager, updated)
+
+	added := updateBatchStack(manager, 3, 1)
+	manager.setStatus(added, StatusError)
+
+	assert.Equal(t, "RollingBack", (*reports)[len(*reports)-1].Status)
+
+	// The updated stack is redeployed at its previous version, the new one is left in error
+	rolledBack := manager.stacks[1]
+	assert.Equal(t, StatusPending, rolledBack.Status)
+	assert.Equal(t, 1, rolledBack.RolledBackVersion)
+	assert.Equal(t, StatusError, manager.stacks[3].Status)
+
+	content, err := os.ReadFile(filepath.Join(rolledBack.FileFolder, rolledBack.FileName))
+	require.NoError(t, err)
+	assert.Equal(t, []byte{1}, content)
+
+	deployBatchStack(manager, rolledBack)
+
+	last := (*reports)[len(*reports)-1]
+	assert.Equal(t, "RolledBack", last.Status)
+	assert.Equal(t, "stack 3 failed to deploy", last.Error)
+	assert.Empty(t, manager.batches)
+
+	// The rolled back versions are not deployed again
+	assert.True(t, manager.isRolledBackBatchStack(1, 2))
+	assert.True(t, manager.isRolledBackBatchStack(3, 1))
+	assert.False(t, manager.isRolledBackBatchStack(1, 3))
+	assert.NotContains(t, manager.rolledBackBatchStacks, 1)
+}
+
+func TestStackManager_batchRolledBack_waitingStacks(t *testing.T) {
+	manager, reports, mockPortainerClient := newBatchTestManager(t)
+
+	for _, stackID := range []int{3, 4, 5} {
+		mockPortainerClient.EXPECT().
+			SetEdgeStackStatus(stackID, portainer.EdgeStackStatusError, gomock.Any(), gomock.Any()).
+			Return(nil)
+	}
+
+	manager.beginBatch(7, map[int]int{3: 1, 4: 1, 5: 1})
+
+	staged := updateBatchStack(manager, 4, 1)
+	manager.setStatus(staged, StatusStaged)
+
+	retrying := updateBatchStack(manager, 5, 1)
+	manager.setStatus(retrying, StatusDeploying)
+	manager.setStatus(retrying, StatusRetry)
+
+	failed := updateBatchStack(manager, 3, 1)
+	manager.setStatus(failed, StatusError)
+
+	// The stacks waiting to be deployed are moved to the error status along with the failed one
+	for _, stackID := range []edgeStackID{3, 4, 5} {
+		assert.Equal(t, StatusError, manager.stacks[stackID].Status, "stack %d", stackID)
+		assert.Equal(t, actionIdle, manager.stacks[stackID].Action, "stack %d", stackID)
+	}
+
+	last := (*reports)[len(*reports)-1]
+	assert.Equal(t, "RolledBack", last.Status)
+	assert.Equal(t, "Error", last.Stacks[4])
+	assert.Equal(t, "Error", last.Stacks[5])
+}
+
+func TestStackManager_batchFailedWithoutHistory(t *testing.T) {
+	manager, reports, _ := newBatchTestManager(t)
+
+	manager.historyCount = 0
+	stack := deployedBatchStack(t, manager, 1, 1)
+	require.NoError(t, os.RemoveAll(HistoryStackFileFolder(stack.FileFolder)))
+
+	manager.beginBatch(7, map[int]int{1: 2})
+
+	updated := updateBatchStack(manager, 1, 2)
+	manager.setStatus(updated, StatusDeploying)
+	manager.setStatus(updated, StatusError)
+
+	last := (*reports)[len(*reports)-1]
+	assert.Equal(t, "Failed", last.Status)
+	assert.Equal(t, "Unrecoverable", last.Stacks[1])
+}
+
+func TestStackManager_AbortStackBatch(t *testing.T) {
+	manager, reports, _ := newBatchTestManager(t)
+
+	deployedBatchStack(t, manager, 1, 1)
+
+	manager.BeginStackBatch(7, []client.StackPayload{
+		{StackPayload: edge.StackPayload{ID: 1, Version: 2}},
+		{StackPayload: edge.StackPayload{ID: 2, Version: 1}},
+	})
+
+	manager.AbortStackBatch(7, "failed to deploy stack 1")
+
+	// Neither stack was updated yet, there is nothing to roll back
+	last := (*reports)[len(*reports)-1]
+	assert.Equal(t, "RolledBack", last.Status)
+	assert.Equal(t, "Removed", last.Stacks[2])
+	assert.Equal(t, 1, manager.stacks[1].Version)
+	assert.True(t, manager.isRolledBackBatchStack(2, 1))
+}
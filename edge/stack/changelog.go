@@ -0,0 +1,303 @@
+package stack
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/portainer/agent/edge/client"
+	agentfs "github.com/portainer/agent/filesystem"
+
+	"github.com/rs/zerolog/log"
+)
+
+// changelogFileName is the file of the data folder the changelogs of the stacks are kept in
+const changelogFileName = "stack_changelog.json"
+
+// maxChangelogVersions is the number of versions kept in the changelog of each stack
+const maxChangelogVersions = 20
+
+// SetChangelog keeps the changelogs of the stacks in the data folder so that they survive the restarts of the agent,
+// they are only kept in memory when dataPath is empty. The changelog of a stack is reported to the server each time
+// one of its versions gets a result when report is set.
+func (manager *StackManager) SetChangelog(dataPath string, report bool) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	manager.changelogDataPath = dataPath
+	manager.changelogReport = report
+
+	if dataPath == "" {
+		return
+	}
+
+	changelogs, err := readChangelogs(dataPath)
+	if err != nil {
+		log.Warn().Err(err).Msg("unable to read the changelogs of the Edge stacks")
+
+		return
+	}
+
+	manager.changelogs = changelogs
+}
+
+func readChangelogs(dataPath string) (map[int][]client.StackVersionRecord, error) {
+	data, err := os.ReadFile(filepath.Join(dataPath, changelogFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var changelogs map[int][]client.StackVersionRecord
+	if err := json.Unmarshal(data, &changelogs); err != nil {
+		return nil, err
+	}
+
+	return changelogs, nil
+}
+
+// StackChangelog returns the changelog of the stack, false when no version of the stack was received
+func (manager *StackManager) StackChangelog(stackID int) (client.StackChangelog, bool) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	if len(manager.changelogs[stackID]) == 0 {
+		return client.StackChangelog{}, false
+	}
+
+	return manager.stackChangelog(stackID), true
+}
+
+// StackChangelogs returns the changelogs of the stacks, ordered by stack
+func (manager *StackManager) StackChangelogs() []client.StackChangelog {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	changelogs := make([]client.StackChangelog, 0, len(manager.changelogs))
+	for stackID := range manager.changelogs {
+		changelogs = append(changelogs, manager.stackChangelog(stackID))
+	}
+
+	sort.Slice(changelogs, func(i, j int) bool { return changelogs[i].StackID < changelogs[j].StackID })
+
+	return changelogs
+}
+
+// stackChangelog returns the changelog of the stack.
+// The caller must hold the manager lock.
+func (manager *StackManager) stackChangelog(stackID int) client.StackChangelog {
+	changelog := buildChangelog(stackID, manager.changelogs[stackID])
+
+	if stack, ok := manager.stacks[edgeStackID(stackID)]; ok {
+		changelog.Name = stack.Name
+	}
+
+	return changelog
+}
+
+// ReadChangelogs returns the changelogs of the stacks kept in the data folder, ordered by stack. It does not need the
+// stack manager, so that the changelogs can be read by another process.
+func ReadChangelogs(dataPath string) ([]client.StackChangelog, error) {
+	records, err := readChangelogs(dataPath)
+	if err != nil {
+		return nil, err
+	}
+
+	changelogs := make([]client.StackChangelog, 0, len(records))
+	for stackID := range records {
+		changelogs = append(changelogs, buildChangelog(stackID, records[stackID]))
+	}
+
+	sort.Slice(changelogs, func(i, j int) bool { return changelogs[i].StackID < changelogs[j].StackID })
+
+	return changelogs, nil
+}
+
+// buildChangelog returns the changelog of the versions recorded for the stack, the most recent version first
+func buildChangelog(stackID int, records []client.StackVersionRecord) client.StackChangelog {
+	changelog := client.StackChangelog{
+		StackID:  stackID,
+		Versions: make([]client.StackVersionRecord, 0, len(records)),
+	}
+
+	behind := make(map[int]struct{})
+
+	for i := len(records) - 1; i >= 0; i-- {
+		record := records[i]
+		changelog.Versions = append(changelog.Versions, record)
+
+		if changelog.RunningVersion != 0 {
+			continue
+		}
+
+		if record.Result == client.StackVersionDeployed || record.Result == client.StackVersionCompleted {
+			changelog.RunningVersion = record.Version
+		} else {
+			behind[record.Version] = struct{}{}
+		}
+	}
+
+	if len(records) > 0 {
+		changelog.Version = records[len(records)-1].Version
+	}
+
+	delete(behind, changelog.RunningVersion)
+	changelog.Behind = len(behind)
+
+	return changelog
+}
+
+// recordVersion updates the changelog of the stack moved to the status: a version queued for deployment is recorded,
+// and gets its result once the stack is deployed or failed. The removals are not recorded.
+// The caller must hold the manager lock.
+func (manager *StackManager) recordVersion(stack *edgeStack, status edgeStackStatus, message string) {
+	if stack.Action == actionDelete {
+		return
+	}
+
+	records := manager.changelogs[stack.ID]
+
+	var last *client.StackVersionRecord
+	if len(records) > 0 {
+		last = &records[len(records)-1]
+	}
+
+	now := manager.now()
+
+	switch status {
+	case StatusPending:
+		if last != nil && last.Version == stack.Version && last.RolledBackTo == stack.RolledBackVersion && last.Hash == stack.Hash {
+			if last.Result == "" {
+				return
+			}
+
+			// The version is deployed again, e.g. once its deployment failed
+			last.Result = ""
+			last.Error = ""
+			last.FinishedAt = 0
+			last.Duration = 0
+			last.Attempts++
+
+			manager.setChangelog(stack.ID, records)
+
+			return
+		}
+
+		if last != nil && last.Result == "" {
+			last.Result = client.StackVersionSuperseded
+			last.FinishedAt = now.Unix()
+			last.Duration = now.Sub(time.Unix(last.ReceivedAt, 0))
+		}
+
+		records = append(records, client.StackVersionRecord{
+			Version:      stack.Version,
+			Hash:         stack.Hash,
+			RolledBackTo: stack.RolledBackVersion,
+			Attempts:     1,
+			ReceivedAt:   now.Unix(),
+		})
+
+		if len(records) > maxChangelogVersions {
+			records = records[len(records)-maxChangelogVersions:]
+		}
+
+		manager.setChangelog(stack.ID, records)
+
+		return
+	case StatusDeployed:
+		manager.finishVersion(stack, last, client.StackVersionDeployed, "")
+	case StatusCompleted:
+		manager.finishVersion(stack, last, client.StackVersionCompleted, "")
+	case StatusError, StatusIntegrityError:
+		manager.finishVersion(stack, last, client.StackVersionFailed, message)
+	}
+}
+
+// recordVersionError records the error the version of the stack failed with before it was queued for deployment,
+// e.g. when its payload could not be retrieved. The caller must hold the manager lock.
+func (manager *StackManager) recordVersionError(stackID, version int, err error) {
+	records := manager.changelogs[stackID]
+	if len(records) == 0 {
+		return
+	}
+
+	last := &records[len(records)-1]
+	if last.Version != version || last.Result != "" {
+		return
+	}
+
+	last.Error = err.Error()
+
+	manager.setChangelog(stackID, records)
+}
+
+// finishVersion sets the result of the version being deployed.
+// The caller must hold the manager lock.
+func (manager *StackManager) finishVersion(stack *edgeStack, last *client.StackVersionRecord, result, message string) {
+	if last == nil || last.Version != stack.Version || last.Result != "" {
+		return
+	}
+
+	now := manager.now()
+
+	last.Result = result
+	last.Error = message
+	last.Digests = stack.FileChecksums
+	last.FinishedAt = now.Unix()
+	last.Duration = now.Sub(time.Unix(last.ReceivedAt, 0))
+
+	manager.setChangelog(stack.ID, manager.changelogs[stack.ID])
+
+	if !manager.changelogReport {
+		return
+	}
+
+	if err := manager.portainerClient.SetEdgeStackChangelog(stack.ID, manager.stackChangelog(stack.ID)); err != nil {
+		log.Warn().Err(err).Int("stack_identifier", stack.ID).Msg("unable to report the changelog of the stack")
+	}
+}
+
+// forgetChangelog removes the changelog of a removed stack.
+// The caller must hold the manager lock.
+func (manager *StackManager) forgetChangelog(stackID int) {
+	if _, ok := manager.changelogs[stackID]; !ok {
+		return
+	}
+
+	delete(manager.changelogs, stackID)
+
+	manager.saveChangelogs()
+}
+
+// setChangelog replaces the changelog of the stack and saves the changelogs.
+// The caller must hold the manager lock.
+func (manager *StackManager) setChangelog(stackID int, records []client.StackVersionRecord) {
+	if manager.changelogs == nil {
+		manager.changelogs = make(map[int][]client.StackVersionRecord)
+	}
+
+	manager.changelogs[stackID] = records
+
+	manager.saveChangelogs()
+}
+
+// saveChangelogs writes the changelogs to the data folder, the failures are logged as the changelogs are informative.
+// The caller must hold the manager lock.
+func (manager *StackManager) saveChangelogs() {
+	if manager.changelogDataPath == "" {
+		return
+	}
+
+	data, err := json.Marshal(manager.changelogs)
+	if err == nil {
+		err = agentfs.WriteFile(manager.changelogDataPath, changelogFileName, data, 0600)
+	}
+
+	if err != nil {
+		log.Warn().Err(err).Msg("unable to save the changelogs of the Edge stacks")
+	}
+}
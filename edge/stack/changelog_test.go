@@ -0,0 +1,118 @@
+package stack
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/portainer/agent/clock"
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/agent/internals/mocks"
+	"github.com/portainer/portainer/api/edge"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestStackManager_recordVersion(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Unix(1000, 0))
+	manager := &StackManager{clock: fakeClock, stacks: map[edgeStackID]*edgeStack{}}
+
+	stack := &edgeStack{StackPayload: edge.StackPayload{ID: 1, Name: "web", Version: 1}, Hash: "a"}
+	manager.stacks[1] = stack
+
+	manager.recordVersion(stack, StatusPending, "")
+	fakeClock.Advance(time.Minute)
+	stack.FileChecksums = map[string]string{"docker-compose.yml": "1"}
+	manager.recordVersion(stack, StatusDeployed, "")
+
+	// The version 2 is superseded by the version 3 before it is deployed
+	stack.Version, stack.Hash = 2, "b"
+	manager.recordVersion(stack, StatusPending, "")
+	stack.Version, stack.Hash = 3, "c"
+	manager.recordVersion(stack, StatusPending, "")
+	manager.recordVersionError(1, 3, errors.New("pull failed"))
+	manager.recordVersion(stack, StatusError, "unable to deploy")
+
+	// The version 3 is deployed again and fails again
+	manager.recordVersion(stack, StatusPending, "")
+	manager.recordVersion(stack, StatusError, "unable to deploy")
+
+	changelog, ok := manager.StackChangelog(1)
+	require.True(t, ok)
+	assert.Equal(t, "web", changelog.Name)
+	assert.Equal(t, 3, changelog.Version)
+	assert.Equal(t, 1, changelog.RunningVersion)
+	assert.Equal(t, 2, changelog.Behind)
+
+	require.Len(t, changelog.Versions, 3)
+	assert.Equal(t, 3, changelog.Versions[0].Version)
+	assert.Equal(t, client.StackVersionFailed, changelog.Versions[0].Result)
+	assert.Equal(t, "unable to deploy", changelog.Versions[0].Error)
+	assert.Equal(t, 2, changelog.Versions[0].Attempts)
+	assert.Equal(t, client.StackVersionSuperseded, changelog.Versions[1].Result)
+	assert.Equal(t, client.StackVersionDeployed, changelog.Versions[2].Result)
+	assert.Equal(t, time.Minute, changelog.Versions[2].Duration)
+	assert.Equal(t, map[string]string{"docker-compose.yml": "1"}, changelog.Versions[2].Digests)
+
+	_, ok = manager.StackChangelog(2)
+	assert.False(t, ok)
+}
+
+func TestStackManager_recordVersion_removal(t *testing.T) {
+	manager := &StackManager{clock: clock.NewFakeClock(time.Unix(1000, 0))}
+
+	stack := &edgeStack{StackPayload: edge.StackPayload{ID: 1, Version: 1}, Action: actionDelete}
+	manager.recordVersion(stack, StatusPending, "")
+
+	assert.Empty(t, manager.StackChangelogs())
+}
+
+func TestStackManager_SetChangelog(t *testing.T) {
+	dataPath := t.TempDir()
+
+	manager := &StackManager{clock: clock.NewFakeClock(time.Unix(1000, 0))}
+	manager.SetChangelog(dataPath, false)
+
+	for id := 1; id <= 2; id++ {
+		stack := &edgeStack{StackPayload: edge.StackPayload{ID: id, Version: 1}}
+		manager.recordVersion(stack, StatusPending, "")
+		manager.recordVersion(stack, StatusDeployed, "")
+	}
+
+	manager.forgetChangelog(1)
+
+	changelogs, err := ReadChangelogs(dataPath)
+	require.NoError(t, err)
+	require.Len(t, changelogs, 1)
+	assert.Equal(t, 2, changelogs[0].StackID)
+	assert.Equal(t, 1, changelogs[0].RunningVersion)
+
+	reloaded := &StackManager{}
+	reloaded.SetChangelog(dataPath, false)
+	assert.Equal(t, changelogs, reloaded.StackChangelogs())
+}
+
+func TestStackManager_recordVersion_report(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	portainerClient := mocks.NewMockPortainerClient(ctrl)
+
+	manager := &StackManager{
+		clock:           clock.NewFakeClock(time.Unix(1000, 0)),
+		portainerClient: portainerClient,
+		changelogReport: true,
+	}
+
+	portainerClient.EXPECT().
+		SetEdgeStackChangelog(1, gomock.Any()).
+		DoAndReturn(func(_ int, changelog client.StackChangelog) error {
+			assert.Equal(t, 1, changelog.RunningVersion)
+			assert.Len(t, changelog.Versions, 1)
+
+			return nil
+		})
+
+	stack := &edgeStack{StackPayload: edge.StackPayload{ID: 1, Version: 1}}
+	manager.recordVersion(stack, StatusPending, "")
+	manager.recordVersion(stack, StatusDeployed, "")
+}
@@ -0,0 +1,135 @@
+package stack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/portainer/agent"
+	"github.com/portainer/agent/edge/client"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultCommandTimeout is how long a one-off command of a stack can run when the server does not set it
+const DefaultCommandTimeout = 10 * time.Minute
+
+// maxCommandOutput bounds the output of a one-off command reported to the server, its end is kept
+const maxCommandOutput = 64 * 1024
+
+// ErrCommandNotSupported is returned when the deployer of the stack cannot run one-off commands
+var ErrCommandNotSupported = errors.New("running commands is not supported by the engine")
+
+// stackCommandRunner is implemented by the deployers able to run a one-off command with the image, the environment
+// and the volumes of a service of a deployed stack, the same way as the docker compose run --rm command
+type stackCommandRunner interface {
+	Run(ctx context.Context, name string, filePaths []string, service string, command []string, options agent.DeployOptions) ([]byte, int, error)
+}
+
+// RunStackCommand runs a one-off command in the context of a service of a deployed stack, e.g. a migration or a
+// maintenance task defined by the stack, and reports its output and its exit code to the server. The command exiting
+// with a non-zero code is not an error, only the commands that could not run are.
+func (manager *StackManager) RunStackCommand(ctx context.Context, command client.StackRunCommandData) error {
+	result := client.StackCommandResult{
+		StackID: command.StackID,
+		Service: command.Service,
+		Started: manager.now().Unix(),
+	}
+
+	output, exitCode, err := manager.runStackCommand(ctx, command)
+
+	result.ExitCode = exitCode
+	result.Output, result.Truncated = commandOutput(output)
+	result.Finished = manager.now().Unix()
+
+	if err != nil {
+		result.Error = err.Error()
+
+		log.Error().Err(err).Int("stack_identifier", command.StackID).Str("service", command.Service).Msg("unable to run the stack command")
+	} else {
+		log.Info().
+			Int("stack_identifier", command.StackID).
+			Str("service", command.Service).
+			Int("exit_code", exitCode).
+			Msg("stack command finished")
+	}
+
+	if reportErr := manager.portainerClient.SetStackCommandResult(command.ID, result); reportErr != nil {
+		log.Error().Err(reportErr).Int("command_id", command.ID).Msg("unable to report the result of the stack command")
+	}
+
+	return err
+}
+
+// runStackCommand runs the command with the deployer of the stack, the manager lock is not held while it runs
+func (manager *StackManager) runStackCommand(ctx context.Context, command client.StackRunCommandData) ([]byte, int, error) {
+	if command.Service == "" {
+		return nil, -1, errors.New("missing service")
+	}
+
+	manager.mu.Lock()
+
+	stack, ok := manager.stacks[edgeStackID(command.StackID)]
+	if !ok {
+		manager.mu.Unlock()
+
+		return nil, -1, fmt.Errorf("stack %d not found", command.StackID)
+	}
+
+	runner, ok := manager.deployerFor(stack).(stackCommandRunner)
+	if !ok {
+		manager.mu.Unlock()
+
+		return nil, -1, ErrCommandNotSupported
+	}
+
+	if !deployedIdle(stack) {
+		manager.mu.Unlock()
+
+		return nil, -1, fmt.Errorf("stack %d is not deployed, its commands cannot run", command.StackID)
+	}
+
+	stackName := stack.DeployedProject
+	filePaths := []string{filepath.Join(stack.FileFolder, stack.FileName)}
+	options := agent.DeployOptions{
+		DeployerBaseOptions: agent.DeployerBaseOptions{
+			Namespace:  stack.Namespace,
+			WorkingDir: stack.FileFolder,
+			Env:        manager.deployerEnv(stack),
+			Proxy:      manager.deployProxy,
+		},
+		HostBasePath: hostBasePath(stack),
+	}
+
+	manager.mu.Unlock()
+
+	timeout := DefaultCommandTimeout
+	if command.Timeout > 0 {
+		timeout = time.Duration(command.Timeout) * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	log.Info().
+		Int("stack_identifier", command.StackID).
+		Str("service", command.Service).
+		Strs("command", command.Command).
+		Msg("running stack command")
+
+	return runner.Run(ctx, stackName, filePaths, command.Service, command.Command, options)
+}
+
+// commandOutput returns the end of the output reported to the server, and whether it was truncated. The invalid
+// UTF-8 sequences, such as the character cut at the start of a truncated output, are replaced.
+func commandOutput(output []byte) (string, bool) {
+	truncated := len(output) > maxCommandOutput
+	if truncated {
+		output = output[len(output)-maxCommandOutput:]
+	}
+
+	return strings.ToValidUTF8(string(output), "\uFFFD"), truncated
+}
@@ -0,0 +1,89 @@
+package stack
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/portainer/agent"
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/agent/internals/mocks"
+	"github.com/portainer/portainer/api/edge"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+type commandDeployer struct {
+	*mocks.MockDeployer
+
+	project string
+	service string
+	command []string
+}
+
+func (d *commandDeployer) Run(ctx context.Context, name string, filePaths []string, service string, command []string, options agent.DeployOptions) ([]byte, int, error) {
+	d.project, d.service, d.command = name, service, command
+
+	return []byte("applying migrations\nfailed\n"), 3, nil
+}
+
+func TestStackManager_RunStackCommand(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockPortainerClient := mocks.NewMockPortainerClient(ctrl)
+	deployer := &commandDeployer{MockDeployer: mocks.NewMockDeployer(ctrl)}
+
+	manager := NewStackManager(mockPortainerClient, "", nil, "")
+	manager.deployer = deployer
+	manager.stacks[1] = &edgeStack{StackPayload: edge.StackPayload{ID: 1, Version: 2}, Status: StatusDeployed, Action: actionIdle, DeployedProject: "edge_web"}
+	manager.stacks[2] = &edgeStack{StackPayload: edge.StackPayload{ID: 2, Version: 1}, Status: StatusPending, Action: actionDeploy}
+
+	var result client.StackCommandResult
+	mockPortainerClient.EXPECT().SetStackCommandResult(7, gomock.Any()).DoAndReturn(func(_ int, r client.StackCommandResult) error {
+		result = r
+
+		return nil
+	})
+
+	err := manager.RunStackCommand(context.Background(), client.StackRunCommandData{ID: 7, StackID: 1, Service: "web", Command: []string{"./manage", "migrate"}})
+	require.NoError(t, err)
+
+	assert.Equal(t, "edge_web", deployer.project)
+	assert.Equal(t, "web", deployer.service)
+	assert.Equal(t, []string{"./manage", "migrate"}, deployer.command)
+
+	assert.Equal(t, 1, result.StackID)
+	assert.Equal(t, "web", result.Service)
+	assert.Equal(t, 3, result.ExitCode)
+	assert.Equal(t, "applying migrations\nfailed\n", result.Output)
+	assert.Empty(t, result.Error)
+
+	// A stack being deployed does not run commands, the failure is reported
+	mockPortainerClient.EXPECT().SetStackCommandResult(8, gomock.Any()).DoAndReturn(func(_ int, r client.StackCommandResult) error {
+		result = r
+
+		return nil
+	})
+
+	err = manager.RunStackCommand(context.Background(), client.StackRunCommandData{ID: 8, StackID: 2, Service: "web"})
+	require.Error(t, err)
+	assert.Equal(t, -1, result.ExitCode)
+	assert.Equal(t, err.Error(), result.Error)
+
+	manager.deployer = mocks.NewMockDeployer(ctrl)
+	mockPortainerClient.EXPECT().SetStackCommandResult(9, gomock.Any()).Return(nil)
+
+	err = manager.RunStackCommand(context.Background(), client.StackRunCommandData{ID: 9, StackID: 1, Service: "web"})
+	assert.ErrorIs(t, err, ErrCommandNotSupported)
+}
+
+func TestCommandOutput(t *testing.T) {
+	output, truncated := commandOutput([]byte("done\n"))
+	assert.Equal(t, "done\n", output)
+	assert.False(t, truncated)
+
+	// The multi-byte character cut at the start of the kept output is replaced
+	output, truncated = commandOutput([]byte("é" + strings.Repeat("a", maxCommandOutput-1)))
+	assert.True(t, truncated)
+	assert.Equal(t, "\uFFFD"+strings.Repeat("a", maxCommandOutput-1), output)
+}
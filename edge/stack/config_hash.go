@@ -0,0 +1,110 @@
+package stack
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/agent/edge/oci"
+	"github.com/portainer/agent/edge/yaml"
+
+	"github.com/rs/zerolog/log"
+)
+
+// configHash returns the hash of the effective configuration of the stack: its files once the variables of its entry
+// file are interpolated with its environment, and the registries and users of its credentials. The entry file is
+// normalized, and the labels identifying the device are left out so that the hash does not depend on it.
+// The caller must hold the manager lock.
+func (manager *StackManager) configHash(stack *edgeStack) (string, error) {
+	env := make(map[string]string, len(stack.EnvVars))
+	for _, pair := range stack.EnvVars {
+		env[pair.Name] = pair.Value
+	}
+
+	folder := resolveStackFileFolder(stack.FileFolder)
+
+	var files []string
+	err := filepath.WalkDir(folder, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.Type().IsRegular() {
+			files = append(files, path)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sort.Strings(files)
+
+	hash := sha256.New()
+
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+
+		name, err := filepath.Rel(folder, file)
+		if err != nil {
+			return "", err
+		}
+
+		name = filepath.ToSlash(name)
+
+		if name == stack.FileName {
+			content = []byte(interpolate(string(content), env))
+
+			// The entry files that are not YAML, e.g. the systemd units, are hashed as is
+			if normalized, err := yaml.Normalize(string(content), AgentIDLabel); err == nil {
+				content = []byte(normalized)
+			}
+		}
+
+		fmt.Fprintf(hash, "file %q %d\n", name, len(content))
+		hash.Write(content)
+	}
+
+	credentials := manager.stackRegistryCredentials(stack.ID)
+
+	registries := make([]string, 0, len(credentials))
+	for _, credential := range credentials {
+		registries = append(registries, fmt.Sprintf("%s %s", oci.NormalizeRegistry(credential.ServerURL), credential.Username))
+	}
+
+	sort.Strings(registries)
+
+	for _, registry := range registries {
+		fmt.Fprintf(hash, "registry %s\n", registry)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// reportConfigHash reports the hash of the effective configuration of the deployed stack.
+// The caller must hold the manager lock.
+func (manager *StackManager) reportConfigHash(stack *edgeStack) {
+	hash, err := manager.configHash(stack)
+	if err != nil {
+		log.Warn().Err(err).Int("stack_identifier", stack.ID).Msg("unable to compute the configuration hash of the stack")
+
+		return
+	}
+
+	if err := manager.portainerClient.SetEdgeStackConfigHash(stack.ID, client.StackConfigHash{
+		Version: stack.Version,
+		Hash:    hash,
+		Time:    manager.now().Unix(),
+	}); err != nil {
+		log.Warn().Err(err).Int("stack_identifier", stack.ID).Msg("unable to report the configuration hash of the stack")
+	}
+}
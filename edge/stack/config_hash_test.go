@@ -0,0 +1,42 @@
+package stack
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	portainer "github.com/portainer/portainer/api"
+	"github.com/portainer/portainer/api/edge"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStackManager_configHash(t *testing.T) {
+	newStack := func(edgeID, tag, content string) *edgeStack {
+		folder := filepath.Join(t.TempDir(), "1")
+		require.NoError(t, os.MkdirAll(folder, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(folder, "docker-compose.yml"), []byte(content), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(folder, "nginx.conf"), []byte("worker_processes 1;\n"), 0644))
+
+		return &edgeStack{
+			StackPayload: edge.StackPayload{ID: 1, EnvVars: []portainer.Pair{{Name: "TAG", Value: tag}, {Name: "PORTAINER_EDGE_ID", Value: edgeID}}},
+			FileFolder:   folder,
+			FileName:     "docker-compose.yml",
+		}
+	}
+
+	manager := NewStackManager(nil, "", nil, "")
+
+	a, err := manager.configHash(newStack("device-a", "1.25", "services:\n  web:\n    image: nginx:${TAG}\n    labels:\n      io.portainer.edge.id: device-a\n"))
+	require.NoError(t, err)
+
+	// The formatting and the identifier of the device do not change the hash
+	b, err := manager.configHash(newStack("device-b", "1.25", "services:\n  web:\n    labels: {io.portainer.edge.id: device-b}\n    image: \"nginx:1.25\"\n"))
+	require.NoError(t, err)
+	assert.Equal(t, a, b)
+
+	// The interpolated values do
+	c, err := manager.configHash(newStack("device-a", "1.26", "services:\n  web:\n    image: nginx:${TAG}\n    labels:\n      io.portainer.edge.id: device-a\n"))
+	require.NoError(t, err)
+	assert.NotEqual(t, a, c)
+}
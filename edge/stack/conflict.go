@@ -0,0 +1,114 @@
+package stack
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	portainer "github.com/portainer/portainer/api"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ErrStackNameConflict is returned when the project name of a stack is already used by another stack
+var ErrStackNameConflict = errors.New("stack name conflict")
+
+// normalizeProjectName returns the project name the way compose normalizes it,
+// different stack names can end up being deployed as the same project
+func normalizeProjectName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+
+		return -1
+	}, name)
+}
+
+// checkStackNameConflict returns an ErrStackNameConflict error when the project of the stack is already
+// used by another stack managed by the agent, or by a compose project of the host not deployed by the agent
+// unless the server set the project of the stack to adopt it.
+// The caller must hold the manager lock.
+func (manager *StackManager) checkStackNameConflict(stack *edgeStack) error {
+	project := normalizeProjectName(manager.stackProjectName(stack))
+
+	var conflicts []int
+
+	for _, other := range manager.stacks {
+		if other.ID == stack.ID || other.Name == "" || other.NameConflict {
+			continue
+		}
+
+		if normalizeProjectName(manager.stackProjectName(other)) == project {
+			conflicts = append(conflicts, other.ID)
+		}
+	}
+
+	if len(conflicts) > 0 {
+		sort.Ints(conflicts)
+
+		return fmt.Errorf("%w: project %s is already used by the stack %d", ErrStackNameConflict, project, conflicts[0])
+	}
+
+	if manager.hostProjects == nil {
+		return nil
+	}
+
+	projects, err := manager.hostProjects()
+	if err != nil {
+		// The host projects are only checked on a best effort basis
+		log.Warn().Err(err).Msg("unable to list the compose projects of the host")
+
+		return nil
+	}
+
+	// The project set by the server for the stack is adopted, even when it was not deployed by the agent
+	if workingDir, ok := projects[project]; ok && stack.ProjectName == "" && !isStackWorkingDir(stack, workingDir) {
+		return fmt.Errorf("%w: project %s is already deployed on the host and is not managed by the agent", ErrStackNameConflict, project)
+	}
+
+	return nil
+}
+
+// isStackWorkingDir returns true when the working directory of a compose project is one of the stack folders,
+// which means the project was deployed by the agent before it was restarted
+func isStackWorkingDir(stack *edgeStack, workingDir string) bool {
+	workingDir = filepath.Clean(workingDir)
+
+	for _, folder := range []string{stack.FileFolder, resolveStackFileFolder(stack.FileFolder), SuccessStackFileFolder(stack.FileFolder)} {
+		if workingDir == filepath.Clean(folder) {
+			return true
+		}
+	}
+
+	return strings.HasPrefix(workingDir, VersionsStackFileFolder(stack.FileFolder)+string(filepath.Separator))
+}
+
+// markNameConflict moves the stack to StatusError, the stack is never deployed nor removed
+// so that the stack owning the project is left untouched.
+// The caller must hold the manager lock.
+func (manager *StackManager) markNameConflict(stack *edgeStack, err error) {
+	log.Error().Err(err).Int("stack_identifier", stack.ID).Str("stack_name", stack.Name).Msg("unable to deploy the stack")
+
+	stack.NameConflict = true
+	stack.Action = actionIdle
+
+	manager.putStack(stack)
+	manager.setStatusMessage(stack, StatusError, err.Error())
+	runHooks(hookError, stack, err.Error())
+}
+
+// failNameConflict marks the name conflict and reports it to the server.
+// The caller must hold the manager lock.
+func (manager *StackManager) failNameConflict(stack *edgeStack, err error) {
+	manager.markNameConflict(stack, err)
+
+	if err := manager.portainerClient.SetEdgeStackStatus(stack.ID, portainer.EdgeStackStatusError, stack.RollbackTo, err.Error()); err != nil {
+		log.Error().Err(err).Int("stack_identifier", stack.ID).Msg("unable to update Edge stack status")
+	}
+}
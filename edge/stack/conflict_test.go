@@ -0,0 +1,66 @@
+package stack
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/portainer/portainer/api/edge"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeProjectName(t *testing.T) {
+	manager := &StackManager{}
+
+	assert.Equal(t, "edge_my-stack", normalizeProjectName(manager.stackProjectName(&edgeStack{StackPayload: edge.StackPayload{Name: "My-Stack"}})))
+	assert.Equal(t, "edge_mystack", normalizeProjectName(manager.stackProjectName(&edgeStack{StackPayload: edge.StackPayload{Name: "my.stack"}})))
+}
+
+func TestStackManager_checkStackNameConflict(t *testing.T) {
+	folder := t.TempDir()
+
+	manager := &StackManager{
+		stacks: map[edgeStackID]*edgeStack{
+			1: {StackPayload: edge.StackPayload{ID: 1, Name: "web"}},
+			2: {StackPayload: edge.StackPayload{ID: 2, Name: "db"}, NameConflict: true},
+		},
+	}
+
+	stack := &edgeStack{StackPayload: edge.StackPayload{ID: 3, Name: "Web"}, FileFolder: filepath.Join(folder, "3")}
+	err := manager.checkStackNameConflict(stack)
+	require.ErrorIs(t, err, ErrStackNameConflict)
+	assert.Contains(t, err.Error(), "stack 1")
+
+	// The stack itself and the stacks already in conflict are ignored
+	assert.NoError(t, manager.checkStackNameConflict(manager.stacks[1]))
+	assert.NoError(t, manager.checkStackNameConflict(&edgeStack{StackPayload: edge.StackPayload{ID: 3, Name: "db"}}))
+
+	manager.hostProjects = func() (map[string]string, error) {
+		return map[string]string{
+			"edge_cache": "/opt/cache",
+			"edge_queue": filepath.Join(VersionsStackFileFolder(stack.FileFolder), "2-1000"),
+		}, nil
+	}
+
+	stack.Name = "cache"
+	assert.ErrorIs(t, manager.checkStackNameConflict(stack), ErrStackNameConflict)
+
+	// Projects deployed by the agent for the same stack are not conflicts
+	stack.Name = "queue"
+	assert.NoError(t, manager.checkStackNameConflict(stack))
+
+	// The project set by the server is adopted
+	stack.ProjectName = "edge_cache"
+	assert.NoError(t, manager.checkStackNameConflict(stack))
+}
+
+func TestStackManager_markNameConflict(t *testing.T) {
+	manager := &StackManager{stacks: map[edgeStackID]*edgeStack{}}
+
+	stack := &edgeStack{StackPayload: edge.StackPayload{ID: 1, Name: "web"}, Status: StatusPending, Action: actionDeploy}
+	manager.markNameConflict(stack, ErrStackNameConflict)
+
+	assert.True(t, manager.stacks[1].NameConflict)
+	assert.Equal(t, StatusError, manager.stacks[1].Status)
+	assert.Equal(t, actionIdle, manager.stacks[1].Action)
+}
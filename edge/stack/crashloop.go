@@ -0,0 +1,108 @@
+package stack
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/portainer/agent/edge/client"
+	portainer "github.com/portainer/portainer/api"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// crashLoopRestarts is the number of restarts of a service within crashLoopWindow that marks the stack as degraded
+	crashLoopRestarts = 3
+	crashLoopWindow   = 10 * time.Minute
+	// crashLoopReportInterval is the minimum interval between two reports of a degraded stack
+	crashLoopReportInterval = 5 * time.Minute
+)
+
+// restartSample holds the restart count of each service of a stack at a given time
+type restartSample struct {
+	Time   time.Time
+	Counts map[string]int
+}
+
+// crashLoopReason records the restart counts of the services and returns why the stack is crash looping,
+// an empty string when it is not. The caller must hold the manager lock.
+func (manager *StackManager) crashLoopReason(stack *edgeStack, services map[string]client.StackServiceStatus) string {
+	now := manager.now()
+
+	counts := make(map[string]int, len(services))
+	for name, service := range services {
+		counts[name] = service.RestartCount
+	}
+
+	samples := stack.RestartSamples[:0]
+	for _, sample := range stack.RestartSamples {
+		if now.Sub(sample.Time) <= crashLoopWindow {
+			samples = append(samples, sample)
+		}
+	}
+
+	stack.RestartSamples = append(samples, restartSample{Time: now, Counts: counts})
+	oldest := stack.RestartSamples[0]
+
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		restarts := counts[name] - oldest.Counts[name]
+		if restarts < crashLoopRestarts {
+			continue
+		}
+
+		reason := fmt.Sprintf("service %s is %s, restarted %d times in %s", name, services[name].Status, restarts, now.Sub(oldest.Time).Round(time.Second))
+		if services[name].Error != "" {
+			reason += ": " + services[name].Error
+		}
+
+		return reason
+	}
+
+	return ""
+}
+
+// checkCrashLoop moves a deployed stack to StatusDegraded when its containers are crash looping and back
+// to StatusDeployed once they are stable. The reports of a degraded stack are throttled.
+// The caller must hold the manager lock.
+func (manager *StackManager) checkCrashLoop(stack *edgeStack, services map[string]client.StackServiceStatus) error {
+	if services == nil {
+		return nil
+	}
+
+	reason := manager.crashLoopReason(stack, services)
+
+	switch {
+	case reason != "" && stack.Status == StatusDeployed:
+		log.Warn().Int("stack_identifier", stack.ID).Str("reason", reason).Msg("Edge stack is crash looping")
+
+		manager.setStatusMessage(stack, StatusDegraded, reason)
+		runHooks(hookError, stack, reason)
+	case reason != "" && stack.Status == StatusDegraded:
+		if reason == stack.DegradedReason || manager.now().Before(stack.DegradedReportedAt.Add(crashLoopReportInterval)) {
+			return nil
+		}
+	case reason == "" && stack.Status == StatusDegraded:
+		log.Info().Int("stack_identifier", stack.ID).Msg("Edge stack recovered from crash looping")
+
+		manager.setStatus(stack, StatusDeployed)
+		runHooks(hookDeployed, stack, "")
+		stack.DegradedReason = ""
+
+		return manager.portainerClient.SetEdgeStackStatus(stack.ID, portainer.EdgeStackStatusRunning, stack.RollbackTo, "")
+	default:
+		return nil
+	}
+
+	stack.DegradedReason = reason
+	stack.DegradedReportedAt = manager.now()
+
+	return manager.portainerClient.SetEdgeStackStatus(stack.ID, portainer.EdgeStackStatusError, stack.RollbackTo, reason)
+}
@@ -0,0 +1,63 @@
+package stack
+
+import (
+	"testing"
+	"time"
+
+	"github.com/portainer/agent/clock"
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/agent/internals/mocks"
+	portainer "github.com/portainer/portainer/api"
+	"github.com/stretchr/testify/assert"
+	gomock "go.uber.org/mock/gomock"
+)
+
+func TestStackManager_checkCrashLoop(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClient := mocks.NewMockPortainerClient(ctrl)
+	fakeClock := clock.NewFakeClock(time.Unix(1000, 0))
+
+	manager := &StackManager{
+		portainerClient: mockClient,
+		clock:           fakeClock,
+		stacks:          map[edgeStackID]*edgeStack{},
+	}
+
+	stack := &edgeStack{Status: StatusDeployed}
+	stack.ID = 1
+
+	services := func(restarts int) map[string]client.StackServiceStatus {
+		return map[string]client.StackServiceStatus{
+			"db":  {Status: "running", Containers: 1},
+			"web": {Status: "restarting", Containers: 1, RestartCount: restarts, Error: "exited with code 1"},
+		}
+	}
+
+	// A stable stack stays deployed
+	assert.NoError(t, manager.checkCrashLoop(stack, services(0)))
+	assert.Equal(t, StatusDeployed, stack.Status)
+
+	fakeClock.Advance(time.Minute)
+
+	mockClient.EXPECT().SetEdgeStackStatus(1, portainer.EdgeStackStatusError, nil, "service web is restarting, restarted 3 times in 1m0s: exited with code 1")
+	assert.NoError(t, manager.checkCrashLoop(stack, services(3)))
+	assert.Equal(t, StatusDegraded, stack.Status)
+
+	// Further restarts are not reported before the report interval elapsed
+	fakeClock.Advance(time.Minute)
+	assert.NoError(t, manager.checkCrashLoop(stack, services(5)))
+
+	fakeClock.Advance(crashLoopReportInterval)
+
+	mockClient.EXPECT().SetEdgeStackStatus(1, portainer.EdgeStackStatusError, nil, "service web is restarting, restarted 8 times in 7m0s: exited with code 1")
+	assert.NoError(t, manager.checkCrashLoop(stack, services(8)))
+
+	// The stack recovers once its services stop restarting within the window
+	fakeClock.Advance(crashLoopWindow + time.Minute)
+
+	mockClient.EXPECT().SetEdgeStackStatus(1, portainer.EdgeStackStatusRunning, nil, "")
+	assert.NoError(t, manager.checkCrashLoop(stack, services(8)))
+	assert.Equal(t, StatusDeployed, stack.Status)
+}
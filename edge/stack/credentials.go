@@ -0,0 +1,178 @@
+package stack
+
+import (
+	"strings"
+
+	"github.com/portainer/agent/edge/credstore"
+	"github.com/portainer/agent/edge/oci"
+	"github.com/portainer/agent/edge/yaml"
+	"github.com/portainer/portainer/api/edge"
+
+	"github.com/rs/zerolog/log"
+)
+
+// SetCredentialStore sets the store where the registry credentials of the stacks are kept
+func (manager *StackManager) SetCredentialStore(store credstore.Store) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	manager.credentialStore = store
+}
+
+// saveRegistryCredentials keeps the registry credentials of the stack in the credential store
+func (manager *StackManager) saveRegistryCredentials(stack *edgeStack) {
+	if err := manager.credentialStore.Save(stack.ID, stack.RegistryCredentials); err != nil {
+		log.Error().Err(err).Int("stack_identifier", stack.ID).Msg("unable to save the registry credentials of the stack")
+	}
+}
+
+// deleteRegistryCredentials removes the registry credentials of the stack from the credential store,
+// along with its docker client configuration
+func (manager *StackManager) deleteRegistryCredentials(stack *edgeStack) {
+	removeRegistryConfig(stack)
+
+	if err := manager.credentialStore.Delete(stack.ID); err != nil {
+		log.Error().Err(err).Int("stack_identifier", stack.ID).Msg("unable to delete the registry credentials of the stack")
+	}
+}
+
+// imageRegistries returns the registries of the images used by the stack entry file
+func imageRegistries(stack *edgeStack, stackPayload *edge.StackPayload) []string {
+	fileContent := entryFileContent(stackPayload)
+	if fileContent == nil {
+		return nil
+	}
+
+	registries, err := yaml.ImageRegistries(*fileContent)
+	if err != nil {
+		log.Debug().Err(err).Int("stack_identifier", stack.ID).Msg("unable to find the registries used by the stack")
+
+		return nil
+	}
+
+	return registries
+}
+
+// stackRegistryCredentials returns the credentials of a stack, from the credential store when available.
+// The caller must hold the manager lock.
+func (manager *StackManager) stackRegistryCredentials(stackID int) []edge.RegistryCredentials {
+	if credentials := manager.storedRegistryCredentials(stackID); len(credentials) > 0 {
+		return credentials
+	}
+
+	if stack, ok := manager.stacks[edgeStackID(stackID)]; ok {
+		return stack.RegistryCredentials
+	}
+
+	return nil
+}
+
+// viewRegistryCredentials returns the credentials of a stack like stackRegistryCredentials, without the manager lock
+func (manager *StackManager) viewRegistryCredentials(stackID int) []edge.RegistryCredentials {
+	if credentials := manager.storedRegistryCredentials(stackID); len(credentials) > 0 {
+		return credentials
+	}
+
+	manager.view.mu.RLock()
+	defer manager.view.mu.RUnlock()
+
+	return manager.view.credentials[stackID]
+}
+
+func (manager *StackManager) storedRegistryCredentials(stackID int) []edge.RegistryCredentials {
+	credentials, err := manager.credentialStore.Get(stackID)
+	if err != nil {
+		log.Error().Err(err).Int("stack_identifier", stackID).Msg("unable to retrieve the stored registry credentials of the stack")
+	}
+
+	return credentials
+}
+
+// GetEdgeRegistryCredentials returns the credentials of the registry for the stack pulling an image from it.
+// The stack is identified by stackID when the pull reports it, otherwise the registry is matched against
+// the images of the stacks, those being deployed first. Credentials are only returned when the matching
+// stacks agree on them, so that the credentials of a stack are never used to pull the images of another one.
+//
+// The manager lock is held while the images are pulled, so the stacks are read from the status view.
+func (manager *StackManager) GetEdgeRegistryCredentials(stackID int, registry string) *edge.RegistryCredentials {
+	if stackID > 0 {
+		return findRegistryCredentials(manager.viewRegistryCredentials(stackID), registry)
+	}
+
+	var deploying, others []int
+
+	for _, stack := range manager.ListStacks() {
+		if !usesRegistry(stack.Registries, registry) {
+			continue
+		}
+
+		if stack.Status == StatusDeploying {
+			deploying = append(deploying, stack.ID)
+		} else {
+			others = append(others, stack.ID)
+		}
+	}
+
+	candidates := deploying
+	if len(candidates) == 0 {
+		candidates = others
+	}
+
+	var found *edge.RegistryCredentials
+
+	for _, id := range candidates {
+		credentials := findRegistryCredentials(manager.viewRegistryCredentials(id), registry)
+		if credentials == nil || (found != nil && *found != *credentials) {
+			log.Warn().
+				Str("registry", registry).
+				Ints("stack_identifiers", candidates).
+				Msg("several stacks pull from the registry with different credentials, unable to select them")
+
+			return nil
+		}
+
+		found = credentials
+	}
+
+	return found
+}
+
+// findRegistryCredentials returns the credentials of the registry. The server URLs are compared as given first,
+// then without their scheme and path, the credential helper being called with the registry as the docker client
+// knows it, e.g. https://index.docker.io/v1/ for Docker Hub.
+func findRegistryCredentials(credentials []edge.RegistryCredentials, registry string) *edge.RegistryCredentials {
+	for i := range credentials {
+		if credentials[i].ServerURL == registry {
+			return &credentials[i]
+		}
+	}
+
+	for i := range credentials {
+		if oci.NormalizeRegistry(credentials[i].ServerURL) == oci.NormalizeRegistry(registry) {
+			return &credentials[i]
+		}
+	}
+
+	return nil
+}
+
+// usesRegistry returns true when the registry is in the list, the port is ignored as
+// the registry host is not always reported with it
+func usesRegistry(registries []string, registry string) bool {
+	for _, r := range registries {
+		if r == registry || registryHostname(r) == registryHostname(registry) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func registryHostname(registry string) string {
+	host, _, found := strings.Cut(registry, ":")
+	if !found {
+		return registry
+	}
+
+	return host
+}
@@ -0,0 +1,51 @@
+package stack
+
+import (
+	"testing"
+
+	"github.com/portainer/portainer/api/edge"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetEdgeRegistryCredentials(t *testing.T) {
+	manager := NewStackManager(nil, "", nil, "")
+
+	first := edge.RegistryCredentials{ServerURL: "registry.example.com", Username: "first"}
+	second := edge.RegistryCredentials{ServerURL: "registry.example.com", Username: "second"}
+	other := edge.RegistryCredentials{ServerURL: "other.example.com", Username: "other"}
+
+	stack1 := &edgeStack{
+		StackPayload: edge.StackPayload{ID: 1, RegistryCredentials: []edge.RegistryCredentials{first}},
+		Status:       StatusDeployed,
+		Registries:   []string{"registry.example.com:5000"},
+	}
+	stack2 := &edgeStack{
+		StackPayload: edge.StackPayload{ID: 2, RegistryCredentials: []edge.RegistryCredentials{second, other}},
+		Status:       StatusDeployed,
+		Registries:   []string{"registry.example.com", "other.example.com"},
+	}
+	manager.putStack(stack1)
+	manager.putStack(stack2)
+	manager.saveRegistryCredentials(stack1)
+	manager.saveRegistryCredentials(stack2)
+
+	// The stack reported by the pull only gets its own credentials
+	assert.Equal(t, &first, manager.GetEdgeRegistryCredentials(1, "registry.example.com"))
+	assert.Equal(t, &second, manager.GetEdgeRegistryCredentials(2, "registry.example.com"))
+	assert.Nil(t, manager.GetEdgeRegistryCredentials(1, "other.example.com"))
+
+	// Only stack2 uses other.example.com
+	assert.Equal(t, &other, manager.GetEdgeRegistryCredentials(0, "other.example.com"))
+
+	// Both stacks use registry.example.com with different credentials
+	assert.Nil(t, manager.GetEdgeRegistryCredentials(0, "registry.example.com"))
+
+	// The stack being deployed takes precedence
+	stack1.Status = StatusDeploying
+	manager.putStack(stack1)
+	assert.Equal(t, &first, manager.GetEdgeRegistryCredentials(0, "registry.example.com"))
+
+	// The stored credentials are used once the stack is no longer in memory, e.g. after a restart
+	manager.removeStack(stack2)
+	assert.Equal(t, &second, manager.GetEdgeRegistryCredentials(2, "registry.example.com"))
+}
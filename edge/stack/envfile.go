@@ -0,0 +1,148 @@
+package stack
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	portainer "github.com/portainer/portainer/api"
+)
+
+// ErrEnvFileNotAllowed is returned when an env file of a stack is not under the env file paths of the agent
+var ErrEnvFileNotAllowed = errors.New("the env file is not allowed")
+
+// SetEnvFilePaths sets the host paths the env files of the stacks can be read from, the host being mounted at hostRoot.
+// The env files of the stacks are refused when there are none.
+func (manager *StackManager) SetEnvFilePaths(hostRoot string, paths []string) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	manager.envFileRoot = hostRoot
+	manager.envFilePaths = nil
+
+	for _, p := range paths {
+		manager.envFilePaths = append(manager.envFilePaths, path.Clean(p))
+	}
+}
+
+// mergeEnvFiles returns the variables of the stack along with the ones of its env files read from the host. The
+// variables of the stack take precedence over the ones of the files, and the later files over the earlier ones.
+// The caller must hold the manager lock.
+func (manager *StackManager) mergeEnvFiles(envVars []portainer.Pair, envFiles []string) ([]portainer.Pair, error) {
+	if len(envFiles) == 0 {
+		return envVars, nil
+	}
+
+	fileVars := make(map[string]string)
+	var names []string
+
+	for _, envFile := range envFiles {
+		vars, err := manager.readEnvFile(envFile)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, pair := range vars {
+			if _, ok := fileVars[pair.Name]; !ok {
+				names = append(names, pair.Name)
+			}
+
+			fileVars[pair.Name] = pair.Value
+		}
+	}
+
+	for _, pair := range envVars {
+		delete(fileVars, pair.Name)
+	}
+
+	merged := make([]portainer.Pair, 0, len(envVars)+len(fileVars))
+	for _, name := range names {
+		if value, ok := fileVars[name]; ok {
+			merged = append(merged, portainer.Pair{Name: name, Value: value})
+		}
+	}
+
+	return append(merged, envVars...), nil
+}
+
+// readEnvFile reads the env file at the host path, once checked against the env file paths
+func (manager *StackManager) readEnvFile(hostPath string) ([]portainer.Pair, error) {
+	if !path.IsAbs(hostPath) || path.Clean(hostPath) != hostPath {
+		return nil, fmt.Errorf("%w: %s is not a clean absolute path", ErrEnvFileNotAllowed, hostPath)
+	}
+
+	if !manager.envFileAllowed(hostPath) {
+		return nil, fmt.Errorf("%w: %s", ErrEnvFileNotAllowed, hostPath)
+	}
+
+	location := filepath.Join(manager.envFileRoot, hostPath)
+
+	// The symbolic links are resolved against the root of the agent instead of the one of the host, they could
+	// point outside of the env file paths
+	resolved, err := filepath.EvalSymlinks(location)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the env file %s: %w", hostPath, err)
+	}
+
+	if resolved != filepath.Clean(location) {
+		return nil, fmt.Errorf("%w: %s goes through a symbolic link", ErrEnvFileNotAllowed, hostPath)
+	}
+
+	content, err := os.ReadFile(location)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the env file %s: %w", hostPath, err)
+	}
+
+	vars, err := parseEnvFile(content)
+	if err != nil {
+		return nil, fmt.Errorf("invalid env file %s: %w", hostPath, err)
+	}
+
+	return vars, nil
+}
+
+func (manager *StackManager) envFileAllowed(hostPath string) bool {
+	for _, allowed := range manager.envFilePaths {
+		if allowed == "/" || hostPath == allowed || strings.HasPrefix(hostPath, allowed+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseEnvFile parses the KEY=value lines of an env file the way compose does for the common cases: the blank lines
+// and the comments are skipped, an optional export keyword is ignored and the quotes around the values are removed
+func parseEnvFile(content []byte) ([]portainer.Pair, error) {
+	var vars []portainer.Pair
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line = strings.TrimPrefix(line, "export ")
+
+		name, value, ok := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("line %d is not a KEY=value variable", n)
+		}
+
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+
+		vars = append(vars, portainer.Pair{Name: name, Value: value})
+	}
+
+	return vars, scanner.Err()
+}
@@ -0,0 +1,57 @@
+package stack
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	portainer "github.com/portainer/portainer/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEnvFile(t *testing.T) {
+	vars, err := parseEnvFile([]byte("# site values\nAPI_KEY=secret\n\nexport LAT = \"48.85\"\nLABEL='a b'\nEMPTY=\n"))
+	require.NoError(t, err)
+
+	assert.Equal(t, []portainer.Pair{
+		{Name: "API_KEY", Value: "secret"},
+		{Name: "LAT", Value: "48.85"},
+		{Name: "LABEL", Value: "a b"},
+		{Name: "EMPTY", Value: ""},
+	}, vars)
+
+	_, err = parseEnvFile([]byte("API_KEY secret\n"))
+	assert.ErrorContains(t, err, "line 1")
+}
+
+func TestStackManager_mergeEnvFiles(t *testing.T) {
+	hostRoot := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(hostRoot, "etc", "portainer"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(hostRoot, "etc", "portainer", "stack.env"), []byte("API_KEY=secret\nREGION=eu\n"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(hostRoot, "etc", "portainer", "site.env"), []byte("REGION=fr\n"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(hostRoot, "etc", "shadow"), []byte("root=x\n"), 0600))
+	require.NoError(t, os.Symlink(filepath.Join(hostRoot, "etc", "shadow"), filepath.Join(hostRoot, "etc", "portainer", "link.env")))
+
+	manager := &StackManager{}
+	manager.SetEnvFilePaths(hostRoot, []string{"/etc/portainer/"})
+
+	merged, err := manager.mergeEnvFiles([]portainer.Pair{{Name: "API_KEY", Value: "payload"}}, []string{"/etc/portainer/stack.env", "/etc/portainer/site.env"})
+	require.NoError(t, err)
+
+	// The variables of the payload take precedence, the later files over the earlier ones
+	assert.Equal(t, []portainer.Pair{{Name: "REGION", Value: "fr"}, {Name: "API_KEY", Value: "payload"}}, merged)
+
+	for _, envFile := range []string{"/etc/shadow", "/etc/portainer/../shadow", "etc/portainer/stack.env", "/etc/portainer/link.env"} {
+		_, err := manager.mergeEnvFiles(nil, []string{envFile})
+		assert.ErrorIs(t, err, ErrEnvFileNotAllowed, envFile)
+	}
+
+	_, err = manager.mergeEnvFiles(nil, []string{"/etc/portainer/missing.env"})
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// The env files are refused when no path is allowed
+	manager.SetEnvFilePaths(hostRoot, nil)
+	_, err = manager.mergeEnvFiles(nil, []string{"/etc/portainer/stack.env"})
+	assert.ErrorIs(t, err, ErrEnvFileNotAllowed)
+}
@@ -0,0 +1,44 @@
+package stack
+
+import (
+	"regexp"
+
+	"github.com/portainer/agent/edge/client"
+)
+
+// terminalErrorPatterns match the output of the engines and of the registries for the errors retrying does not fix.
+// The errors they do not match are considered transient, as they were before the errors were classified.
+var terminalErrorPatterns = []*regexp.Regexp{
+	// Images
+	regexp.MustCompile(`(?i)manifest unknown`),
+	regexp.MustCompile(`(?i)manifest for \S+ not found`),
+	regexp.MustCompile(`(?i)no matching manifest for`),
+	regexp.MustCompile(`(?i)invalid reference format`),
+	regexp.MustCompile(`(?i)repository does not exist`),
+	// Registry credentials
+	regexp.MustCompile(`(?i)pull access denied`),
+	regexp.MustCompile(`(?i)unauthorized`),
+	regexp.MustCompile(`(?i)authentication required`),
+	regexp.MustCompile(`(?i)requested access to the resource is denied`),
+	regexp.MustCompile(`(?i)\b40[13] (unauthorized|forbidden)\b`),
+	// Stack files
+	regexp.MustCompile(`(?i)\byaml: `),
+	regexp.MustCompile(`(?i)additional propert(y|ies) .* not allowed`),
+	regexp.MustCompile(`(?i)error validating data`),
+	regexp.MustCompile(`(?i) is invalid: `),
+	regexp.MustCompile(`(?i) is forbidden: `),
+}
+
+// errorClass returns the class of the error the pull or the deployment of a stack failed with, one of
+// client.StackErrorTransient or client.StackErrorTerminal
+func errorClass(err error) string {
+	message := err.Error()
+
+	for _, pattern := range terminalErrorPatterns {
+		if pattern.MatchString(message) {
+			return client.StackErrorTerminal
+		}
+	}
+
+	return client.StackErrorTransient
+}
@@ -0,0 +1,30 @@
+package stack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/portainer/agent/edge/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorClass(t *testing.T) {
+	for _, tc := range []struct {
+		err   error
+		class string
+	}{
+		{fmt.Errorf("chaos: image pull: %w", context.DeadlineExceeded), client.StackErrorTransient},
+		{errors.New("exit status 1: Get \"https://registry-1.docker.io/v2/\": dial tcp: lookup registry-1.docker.io: no such host"), client.StackErrorTransient},
+		{errors.New("exit status 1: toomanyrequests: You have reached your pull rate limit"), client.StackErrorTransient},
+		{errors.New("exit status 1: Error response from daemon: manifest for nginx:9.9 not found: manifest unknown"), client.StackErrorTerminal},
+		{errors.New("exit status 1: pull access denied for private/app, repository does not exist or may require 'docker login'"), client.StackErrorTerminal},
+		{errors.New("exit status 1: Head \"https://registry.example.com/v2/app/manifests/1.0\": unauthorized: authentication required"), client.StackErrorTerminal},
+		{errors.New("exit status 1: yaml: line 3: mapping values are not allowed in this context"), client.StackErrorTerminal},
+		{errors.New("exit status 1: The Deployment \"web\" is invalid: spec.template.spec.containers[0].image: Required value"), client.StackErrorTerminal},
+		{fmt.Errorf("%w (failed images: nginx:9.9: manifest unknown)", context.DeadlineExceeded), client.StackErrorTerminal},
+	} {
+		assert.Equal(t, tc.class, errorClass(tc.err), tc.err.Error())
+	}
+}
@@ -0,0 +1,69 @@
+package stack
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+
+	"github.com/portainer/agent/edge/client"
+	agentfs "github.com/portainer/agent/filesystem"
+	portainer "github.com/portainer/portainer/api"
+)
+
+// envPlaceholder matches the ${VAR}, ${VAR:-default} and ${VAR-default} placeholders. The $VAR form is not
+// expanded, it is used by the configuration files themselves, e.g. the variables of nginx.
+var envPlaceholder = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(?:(:?-)([^}]*))?\}`)
+
+// expandEnvFiles replaces the placeholders of the files flagged by the server with the variables of the stack. The
+// entry file is left to the deployer, which interpolates it with the same variables.
+func expandEnvFiles(stackPayload *client.StackPayload, envVars []portainer.Pair) error {
+	if len(stackPayload.ExpandEnvFiles) == 0 {
+		return nil
+	}
+
+	vars := make(map[string]string, len(envVars))
+	for _, pair := range envVars {
+		vars[pair.Name] = pair.Value
+	}
+
+	for _, path := range stackPayload.ExpandEnvFiles {
+		if filepath.Clean(path) == filepath.Clean(stackPayload.EntryFileName) {
+			continue
+		}
+
+		entry := findDirEntry(stackPayload.DirEntries, path)
+		if entry == nil {
+			return fmt.Errorf("the file %s to expand is not found in the stack payload", path)
+		}
+
+		if agentfs.IsBinary(entry.Content) {
+			return fmt.Errorf("the file %s to expand is a binary file", path)
+		}
+
+		entry.Content = expandEnv(entry.Content, vars)
+	}
+
+	return nil
+}
+
+// expandEnv replaces the placeholders of the defined variables, or of the ones with a default value, the others are
+// kept as they are
+func expandEnv(content string, vars map[string]string) string {
+	return envPlaceholder.ReplaceAllStringFunc(content, func(placeholder string) string {
+		match := envPlaceholder.FindStringSubmatch(placeholder)
+		name, operator, defaultValue := match[1], match[2], match[3]
+
+		value, ok := vars[name]
+
+		switch {
+		case operator == ":-" && value == "":
+			return defaultValue
+		case operator == "-" && !ok:
+			return defaultValue
+		case !ok:
+			return placeholder
+		}
+
+		return value
+	})
+}
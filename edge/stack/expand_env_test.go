@@ -0,0 +1,47 @@
+package stack
+
+import (
+	"testing"
+
+	"github.com/portainer/agent/edge/client"
+	portainer "github.com/portainer/portainer/api"
+	"github.com/portainer/portainer/api/edge"
+	"github.com/portainer/portainer/api/filesystem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandEnv(t *testing.T) {
+	vars := map[string]string{"UPSTREAM": "app:8080", "EMPTY": ""}
+
+	assert.Equal(t,
+		"proxy_pass http://app:8080; set $host_name $host; ${UNDEFINED} 80 default ",
+		expandEnv("proxy_pass http://${UPSTREAM}; set $host_name $host; ${UNDEFINED} ${PORT:-80} ${MISSING-default} ${EMPTY-unused}", vars),
+	)
+
+	assert.Equal(t, "fallback", expandEnv("${EMPTY:-fallback}", vars))
+}
+
+func TestExpandEnvFiles(t *testing.T) {
+	stackPayload := &client.StackPayload{
+		StackPayload: edge.StackPayload{
+			EntryFileName: "docker-compose.yml",
+			DirEntries: []filesystem.DirEntry{
+				{Name: "docker-compose.yml", Content: "image: ${IMAGE}", IsFile: true},
+				{Name: "conf/nginx.conf", Content: "server_name ${DOMAIN};", IsFile: true},
+			},
+		},
+		ExpandEnvFiles: []string{"docker-compose.yml", "conf/nginx.conf"},
+	}
+
+	envVars := []portainer.Pair{{Name: "IMAGE", Value: "nginx"}, {Name: "DOMAIN", Value: "example.com"}}
+
+	require.NoError(t, expandEnvFiles(stackPayload, envVars))
+
+	// The entry file is interpolated by the deployer
+	assert.Equal(t, "image: ${IMAGE}", stackPayload.DirEntries[0].Content)
+	assert.Equal(t, "server_name example.com;", stackPayload.DirEntries[1].Content)
+
+	stackPayload.ExpandEnvFiles = []string{"missing.conf"}
+	assert.Error(t, expandEnvFiles(stackPayload, envVars))
+}
@@ -0,0 +1,245 @@
+package stack
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/portainer/agent/docker"
+	"github.com/portainer/agent/exec"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrUnsupportedFeature is returned when a stack uses a compose feature the engine or the compose plugin is too old for
+var ErrUnsupportedFeature = errors.New("unsupported compose feature")
+
+// composeFeature is a feature of the compose specification only supported from a version of the Docker engine
+// or of the compose plugin
+type composeFeature struct {
+	Name string
+	// MinEngine and MinCompose are the first versions supporting the feature, any version when empty
+	MinEngine  string
+	MinCompose string
+	used       func(project map[string]any) bool
+}
+
+// composeFeatures is the capability matrix the stacks are checked against before being deployed
+var composeFeatures = []composeFeature{
+	{Name: "service gpus", MinEngine: "19.03", MinCompose: "2.30.0", used: anyService(hasKey("gpus"))},
+	{Name: "GPU device reservations", MinEngine: "19.03", MinCompose: "1.28.0", used: anyService(reservesGPU)},
+	{Name: "configs", MinEngine: "17.06", MinCompose: "2.0.0", used: hasKey("configs")},
+	{Name: "inline config content", MinCompose: "2.23.1", used: anyConfig(hasKey("content"))},
+	{Name: "depends_on conditions", MinCompose: "1.27.0", used: anyDependency(hasKey("condition"))},
+	{Name: "depends_on restart", MinCompose: "2.17.0", used: anyDependency(hasKey("restart"))},
+	{Name: "depends_on required", MinCompose: "2.20.0", used: anyDependency(hasKey("required"))},
+	{Name: "profiles", MinCompose: "1.28.0", used: anyService(hasKey("profiles"))},
+	{Name: "include", MinCompose: "2.20.0", used: hasKey("include")},
+}
+
+func hasKey(key string) func(map[string]any) bool {
+	return func(m map[string]any) bool {
+		_, ok := m[key]
+
+		return ok
+	}
+}
+
+func mapEntries(m map[string]any, key string) []map[string]any {
+	entries, _ := m[key].(map[string]any)
+
+	var values []map[string]any
+	for _, entry := range entries {
+		if value, ok := entry.(map[string]any); ok {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
+
+func anyService(fn func(service map[string]any) bool) func(map[string]any) bool {
+	return func(project map[string]any) bool {
+		for _, service := range mapEntries(project, "services") {
+			if fn(service) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+func anyConfig(fn func(config map[string]any) bool) func(map[string]any) bool {
+	return func(project map[string]any) bool {
+		for _, config := range mapEntries(project, "configs") {
+			if fn(config) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// anyDependency only looks at the long syntax of depends_on, the short syntax is a list of service names
+func anyDependency(fn func(dependency map[string]any) bool) func(map[string]any) bool {
+	return anyService(func(service map[string]any) bool {
+		for _, dependency := range mapEntries(service, "depends_on") {
+			if fn(dependency) {
+				return true
+			}
+		}
+
+		return false
+	})
+}
+
+func reservesGPU(service map[string]any) bool {
+	deploy, _ := service["deploy"].(map[string]any)
+	resources, _ := deploy["resources"].(map[string]any)
+	reservations, _ := resources["reservations"].(map[string]any)
+	devices, _ := reservations["devices"].([]any)
+
+	for _, device := range devices {
+		device, _ := device.(map[string]any)
+		capabilities, _ := device["capabilities"].([]any)
+
+		for _, capability := range capabilities {
+			if capability == "gpu" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// compareVersions compares two dotted versions such as 24.0.7 or v2.24.6-desktop.1, the suffixes are ignored
+func compareVersions(a, b string) int {
+	pa, pb := versionParts(a), versionParts(b)
+
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+
+		if i < len(pb) {
+			y = pb[i]
+		}
+
+		if x != y {
+			if x < y {
+				return -1
+			}
+
+			return 1
+		}
+	}
+
+	return 0
+}
+
+func versionParts(version string) []int {
+	version = strings.TrimPrefix(version, "v")
+	if i := strings.IndexFunc(version, func(r rune) bool { return r != '.' && (r < '0' || r > '9') }); i >= 0 {
+		version = version[:i]
+	}
+
+	var parts []int
+	for _, part := range strings.Split(version, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			break
+		}
+
+		parts = append(parts, n)
+	}
+
+	return parts
+}
+
+// unsupportedComposeFeatures returns why the versions do not support the features used by the project, an empty
+// string when they do. The versions that are unknown are not checked.
+func unsupportedComposeFeatures(project map[string]any, engineVersion, composeVersion string) string {
+	var reasons []string
+
+	for _, feature := range composeFeatures {
+		if !feature.used(project) {
+			continue
+		}
+
+		if feature.MinEngine != "" && engineVersion != "" && compareVersions(engineVersion, feature.MinEngine) < 0 {
+			reasons = append(reasons, fmt.Sprintf("%s requires engine >= %s, found %s", feature.Name, feature.MinEngine, engineVersion))
+		}
+
+		if feature.MinCompose != "" && composeVersion != "" && compareVersions(composeVersion, feature.MinCompose) < 0 {
+			reasons = append(reasons, fmt.Sprintf("%s requires compose >= %s, found %s", feature.Name, feature.MinCompose, composeVersion))
+		}
+	}
+
+	return strings.Join(reasons, "; ")
+}
+
+// checkComposeFeatures returns an ErrUnsupportedFeature error when the entry file of the stack uses features the
+// Docker engine or the compose plugin does not support. Files that cannot be parsed are left to the validation
+// of the deployer. The caller must hold the manager lock.
+func (manager *StackManager) checkComposeFeatures(stackFileLocation string) error {
+	if manager.engineVersions == nil {
+		return nil
+	}
+
+	content, err := os.ReadFile(stackFileLocation)
+	if err != nil {
+		return nil
+	}
+
+	var project map[string]any
+	if err := yaml.Unmarshal(content, &project); err != nil {
+		return nil
+	}
+
+	engineVersion, composeVersion := manager.engineVersions()
+
+	if reason := unsupportedComposeFeatures(project, engineVersion, composeVersion); reason != "" {
+		return fmt.Errorf("%w: %s", ErrUnsupportedFeature, reason)
+	}
+
+	return nil
+}
+
+// dockerEngineVersions returns the versions of the Docker engine and, for the standalone engine, of the compose
+// plugin of the assets path. The compose plugin version is only looked up once.
+func dockerEngineVersions(assetsPath string, compose bool) func() (string, string) {
+	var once sync.Once
+	var composeVersion string
+
+	return func() (string, string) {
+		var engineVersion string
+
+		info, err := docker.GetInfo()
+		if err != nil {
+			log.Warn().Err(err).Msg("unable to retrieve the version of the Docker engine")
+		} else {
+			engineVersion = info.ServerVersion
+		}
+
+		if !compose {
+			return engineVersion, ""
+		}
+
+		once.Do(func() {
+			composeVersion, err = exec.ComposePluginVersion(assetsPath)
+			if err != nil {
+				log.Warn().Err(err).Msg("unable to retrieve the version of the compose plugin")
+			}
+		})
+
+		return engineVersion, composeVersion
+	}
+}
@@ -0,0 +1,63 @@
+package stack
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareVersions(t *testing.T) {
+	assert.Equal(t, 0, compareVersions("2.20.0", "v2.20"))
+	assert.Equal(t, -1, compareVersions("2.17.3", "2.20.0"))
+	assert.Equal(t, 1, compareVersions("24.0.7", "19.03"))
+	assert.Equal(t, 1, compareVersions("v2.24.6-desktop.1", "2.23.1"))
+	assert.Equal(t, -1, compareVersions("20.10.17+dfsg1", "23.0"))
+}
+
+func TestStackManager_checkComposeFeatures(t *testing.T) {
+	stackFile := filepath.Join(t.TempDir(), "docker-compose.yml")
+	require.NoError(t, os.WriteFile(stackFile, []byte(`
+services:
+  web:
+    image: nginx
+    profiles: [frontend]
+    depends_on:
+      db:
+        condition: service_healthy
+        required: false
+  db:
+    image: postgres
+    deploy:
+      resources:
+        reservations:
+          devices:
+            - capabilities: [gpu]
+`), 0644))
+
+	versions := func(engine, compose string) func() (string, string) {
+		return func() (string, string) { return engine, compose }
+	}
+
+	manager := &StackManager{engineVersions: versions("24.0.7", "2.18.1")}
+	err := manager.checkComposeFeatures(stackFile)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrUnsupportedFeature))
+	assert.Contains(t, err.Error(), "depends_on required requires compose >= 2.20.0, found 2.18.1")
+	assert.NotContains(t, err.Error(), "profiles")
+
+	manager.engineVersions = versions("18.09.1", "")
+	err = manager.checkComposeFeatures(stackFile)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "GPU device reservations requires engine >= 19.03, found 18.09.1")
+
+	// The unknown versions are not checked
+	manager.engineVersions = versions("", "")
+	assert.NoError(t, manager.checkComposeFeatures(stackFile))
+
+	manager.engineVersions = versions("26.1.0", "2.29.1")
+	assert.NoError(t, manager.checkComposeFeatures(stackFile))
+}
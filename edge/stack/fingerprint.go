@@ -0,0 +1,107 @@
+package stack
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/distribution/reference"
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/agent/edge/yaml"
+
+	"github.com/rs/zerolog/log"
+)
+
+// upToDateMessage is reported with the DeploymentReceived status of a version identical to the deployed one
+const upToDateMessage = "up-to-date"
+
+// SetSkipUnchanged skips the deployment of the versions of a stack whose files, credentials and images are identical
+// to the ones deployed, so that the metadata changes made by the server do not restart the stack
+func (manager *StackManager) SetSkipUnchanged(skip bool) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	manager.skipUnchanged = skip
+}
+
+// deployFingerprint returns the fingerprint of what a deployment of the stack runs: the hash of its effective
+// configuration and the images its entry file references. The images are identified by their local ID when the
+// engine can resolve it, otherwise only the images pinned by digest are known to be unchanged. It returns false when
+// the fingerprint cannot be computed, the stack is then always deployed. The caller must hold the manager lock.
+func (manager *StackManager) deployFingerprint(ctx context.Context, stack *edgeStack) (string, bool) {
+	if stack.Format == client.StackFormatSystemd {
+		return "", false
+	}
+
+	configHash, err := manager.configHash(stack)
+	if err != nil {
+		log.Debug().Err(err).Int("stack_identifier", stack.ID).Msg("unable to compute the configuration hash of the stack")
+
+		return "", false
+	}
+
+	content, err := os.ReadFile(filepath.Join(resolveStackFileFolder(stack.FileFolder), stack.FileName))
+	if err != nil {
+		return "", false
+	}
+
+	env := make(map[string]string, len(stack.EnvVars))
+	for _, pair := range stack.EnvVars {
+		env[pair.Name] = pair.Value
+	}
+
+	images, err := yaml.Images(interpolate(string(content), env))
+	if err != nil {
+		return "", false
+	}
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "config %s\n", configHash)
+
+	for _, image := range images {
+		id, ok := manager.imageFingerprint(ctx, image)
+		if !ok {
+			return "", false
+		}
+
+		fmt.Fprintf(hash, "image %s %s\n", image, id)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), true
+}
+
+// imageFingerprint returns the local ID of the image, its reference when it is pinned by digest and the ID cannot
+// be resolved. The caller must hold the manager lock.
+func (manager *StackManager) imageFingerprint(ctx context.Context, image string) (string, bool) {
+	if manager.imageID != nil {
+		if id, err := manager.imageID(ctx, image); err == nil && id != "" {
+			return id, true
+		}
+	}
+
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", false
+	}
+
+	if digested, ok := named.(reference.Digested); ok {
+		return digested.Digest().String(), true
+	}
+
+	return "", false
+}
+
+// unchangedDeployment returns whether the stack is deployed as stackName with the same fingerprint, so that its
+// deployment can be skipped. The caller must hold the manager lock.
+func (manager *StackManager) unchangedDeployment(ctx context.Context, stack *edgeStack, stackName string) bool {
+	if !manager.skipUnchanged || stack.DeployedFingerprint == "" || stack.DeployedProject != stackName {
+		return false
+	}
+
+	fingerprint, ok := manager.deployFingerprint(ctx, stack)
+
+	return ok && fingerprint == stack.DeployedFingerprint
+}
@@ -0,0 +1,94 @@
+package stack
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/portainer/agent/internals/mocks"
+	portainer "github.com/portainer/portainer/api"
+	"github.com/portainer/portainer/api/edge"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestStackManager_deployStack_skipUnchanged(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockDeployer := mocks.NewMockDeployer(ctrl)
+	mockPortainerClient := mocks.NewMockPortainerClient(ctrl)
+
+	imageIDs := map[string]string{"nginx:1.27": "sha256:a"}
+
+	manager := NewStackManager(mockPortainerClient, "", nil, "")
+	manager.deployer = mockDeployer
+	manager.imageID = func(_ context.Context, image string) (string, error) {
+		if id, ok := imageIDs[image]; ok {
+			return id, nil
+		}
+
+		return "", errors.New("no such image")
+	}
+	manager.SetSkipUnchanged(true)
+
+	folder := filepath.Join(t.TempDir(), "1")
+	require.NoError(t, os.MkdirAll(folder, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(folder, "docker-compose.yml"), []byte("services:\n  web:\n    image: nginx:${TAG}\n"), 0644))
+
+	stack := &edgeStack{
+		StackPayload: edge.StackPayload{ID: 1, Version: 1, EnvVars: []portainer.Pair{{Name: "TAG", Value: "1.27"}}},
+		FileFolder:   folder,
+		FileName:     "docker-compose.yml",
+		Status:       StatusPending,
+	}
+
+	deploy := func(version int) {
+		stack.Version = version
+		stack.Status = StatusPending
+
+		manager.deployStack(context.Background(), stack, "edge_web", filepath.Join(folder, "docker-compose.yml"))
+	}
+
+	mockPortainerClient.EXPECT().SetEdgeStackStatus(1, portainer.EdgeStackStatusDeploying, nil, "").Return(nil).Times(3)
+	mockPortainerClient.EXPECT().SetEdgeStackUsage(1, gomock.Any()).Return(nil).AnyTimes()
+
+	// The first version is deployed
+	mockDeployer.EXPECT().Deploy(gomock.Any(), "edge_web", gomock.Any(), gomock.Any()).Return(nil)
+	mockPortainerClient.EXPECT().SetEdgeStackStatus(1, portainer.EdgeStackStatusDeploymentReceived, nil, "").Return(nil)
+
+	deploy(1)
+	assert.NotEmpty(t, stack.DeployedFingerprint)
+	assert.Equal(t, StatusAwaitingDeployedStatus, stack.Status)
+
+	// The second version only changes the metadata of the stack, it is not deployed
+	mockPortainerClient.EXPECT().SetEdgeStackStatus(1, portainer.EdgeStackStatusDeploymentReceived, nil, upToDateMessage).Return(nil)
+
+	deploy(2)
+	assert.Equal(t, StatusAwaitingDeployedStatus, stack.Status)
+
+	// The image of the third version was pulled again and changed, it is deployed
+	imageIDs["nginx:1.27"] = "sha256:b"
+
+	mockDeployer.EXPECT().Deploy(gomock.Any(), "edge_web", gomock.Any(), gomock.Any()).Return(nil)
+	mockPortainerClient.EXPECT().SetEdgeStackStatus(1, portainer.EdgeStackStatusDeploymentReceived, nil, "").Return(nil)
+
+	deploy(3)
+}
+
+func TestStackManager_deployFingerprint_pinnedImages(t *testing.T) {
+	manager := NewStackManager(nil, "", nil, "")
+
+	folder := t.TempDir()
+	stack := &edgeStack{StackPayload: edge.StackPayload{ID: 1}, FileFolder: folder, FileName: "docker-compose.yml"}
+
+	// The images are only known to be unchanged when they are pinned by digest on the engines that cannot resolve them
+	require.NoError(t, os.WriteFile(filepath.Join(folder, "docker-compose.yml"), []byte("services:\n  web:\n    image: nginx:1.27\n"), 0644))
+	_, ok := manager.deployFingerprint(context.Background(), stack)
+	assert.False(t, ok)
+
+	require.NoError(t, os.WriteFile(filepath.Join(folder, "docker-compose.yml"), []byte("services:\n  web:\n    image: nginx@sha256:0000000000000000000000000000000000000000000000000000000000000000\n"), 0644))
+	_, ok = manager.deployFingerprint(context.Background(), stack)
+	assert.True(t, ok)
+}
@@ -0,0 +1,97 @@
+package stack
+
+import (
+	"strconv"
+
+	"github.com/portainer/agent/docker"
+	"github.com/portainer/agent/edge/firewall"
+
+	"github.com/rs/zerolog/log"
+)
+
+// stackFirewall opens the ports published by the stacks in the host firewall
+type stackFirewall interface {
+	Open(owner string, ports []firewall.Port) ([]firewall.Port, error)
+	Close(owner string) error
+}
+
+// SetFirewall opens the ports published by the stacks in the host firewall once they are deployed, and closes them
+// once they are removed
+func (manager *StackManager) SetFirewall(hostFirewall stackFirewall) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	manager.firewall = hostFirewall
+}
+
+// openFirewall replaces the openings of the stack with the ports its containers publish. A failure is logged, the
+// stack stays deployed. The caller must hold the manager lock.
+func (manager *StackManager) openFirewall(stack *edgeStack) {
+	if manager.firewall == nil || manager.publishedPorts == nil {
+		return
+	}
+
+	ports, err := manager.publishedPorts(stack.ID)
+	if err != nil {
+		log.Error().Err(err).Int("stack_identifier", stack.ID).Msg("unable to retrieve the published ports of the stack")
+
+		return
+	}
+
+	denied, err := manager.firewall.Open(firewallOwner(stack), ports)
+	if err != nil {
+		log.Error().Err(err).Int("stack_identifier", stack.ID).Msg("unable to open the published ports of the stack in the firewall")
+	}
+
+	for _, port := range denied {
+		log.Warn().Int("stack_identifier", stack.ID).Stringer("port", port).Msg("the port is not allowed by the firewall policy, it is not opened")
+	}
+}
+
+// closeFirewall removes the openings of the stack. The caller must hold the manager lock.
+func (manager *StackManager) closeFirewall(stack *edgeStack) {
+	if manager.firewall == nil {
+		return
+	}
+
+	if err := manager.firewall.Close(firewallOwner(stack)); err != nil {
+		log.Error().Err(err).Int("stack_identifier", stack.ID).Msg("unable to close the published ports of the stack in the firewall")
+	}
+}
+
+func firewallOwner(stack *edgeStack) string {
+	return "stack-" + strconv.Itoa(stack.ID)
+}
+
+// dockerPublishedPorts returns the host ports published by the containers of the stack, bound to IPv4 or IPv6
+func dockerPublishedPorts(stackID int) ([]firewall.Port, error) {
+	containers, err := docker.GetContainersWithLabel(StackIDLabel + "=" + strconv.Itoa(stackID))
+	if err != nil {
+		return nil, err
+	}
+
+	var ports []firewall.Port
+	seen := make(map[firewall.Port]struct{})
+
+	for _, container := range containers {
+		for _, containerPort := range container.Ports {
+			if containerPort.PublicPort == 0 {
+				continue
+			}
+
+			port := firewall.Port{Number: containerPort.PublicPort, Protocol: containerPort.Type}
+			if port.Protocol != firewall.ProtocolTCP && port.Protocol != firewall.ProtocolUDP {
+				continue
+			}
+
+			if _, ok := seen[port]; ok {
+				continue
+			}
+
+			seen[port] = struct{}{}
+			ports = append(ports, port)
+		}
+	}
+
+	return ports, nil
+}
@@ -0,0 +1,44 @@
+package stack
+
+import (
+	"testing"
+
+	"github.com/portainer/agent/edge/firewall"
+	"github.com/portainer/portainer/api/edge"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeFirewall struct {
+	opened map[string][]firewall.Port
+}
+
+func (f *fakeFirewall) Open(owner string, ports []firewall.Port) ([]firewall.Port, error) {
+	f.opened[owner] = ports
+
+	return nil, nil
+}
+
+func (f *fakeFirewall) Close(owner string) error {
+	delete(f.opened, owner)
+
+	return nil
+}
+
+func TestStackManager_firewall(t *testing.T) {
+	manager := NewStackManager(nil, "", nil, "")
+
+	hostFirewall := &fakeFirewall{opened: make(map[string][]firewall.Port)}
+	manager.SetFirewall(hostFirewall)
+
+	manager.publishedPorts = func(stackID int) ([]firewall.Port, error) {
+		return []firewall.Port{{Number: 1883, Protocol: firewall.ProtocolTCP}}, nil
+	}
+
+	stack := &edgeStack{StackPayload: edge.StackPayload{ID: 4}}
+
+	manager.openFirewall(stack)
+	assert.Equal(t, map[string][]firewall.Port{"stack-4": {{Number: 1883, Protocol: firewall.ProtocolTCP}}}, hostFirewall.opened)
+
+	manager.closeFirewall(stack)
+	assert.Empty(t, hostFirewall.opened)
+}
@@ -0,0 +1,89 @@
+package stack
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/portainer/agent/edge/freeze"
+	portainer "github.com/portainer/portainer/api"
+
+	"github.com/rs/zerolog/log"
+)
+
+// SetFreezeDataPath enables the freeze windows kept in the data folder, the stacks frozen by
+// an active window keep running their current version
+func (manager *StackManager) SetFreezeDataPath(dataPath string) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	manager.freezeDataPath = dataPath
+}
+
+// freezeWindow returns the window the stack is frozen by, nil when it can be deployed.
+// The caller must hold the manager lock.
+func (manager *StackManager) freezeWindow(stackID int) *freeze.Window {
+	if manager.freezeDataPath == "" {
+		return nil
+	}
+
+	window, err := freeze.Active(manager.freezeDataPath, stackID, manager.now())
+	if err != nil {
+		log.Error().Err(err).Msg("unable to read the freeze windows, ignoring them")
+
+		return nil
+	}
+
+	return window
+}
+
+// deferFrozen moves the stack to StatusFrozen and reports it to the server when the device or the stack
+// is frozen, its images are already pulled and its files staged. The removals are not frozen.
+func (manager *StackManager) deferFrozen(stack *edgeStack) bool {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	if stack.Action == actionDelete {
+		return false
+	}
+
+	window := manager.freezeWindow(stack.ID)
+	if window == nil {
+		return false
+	}
+
+	log.Info().
+		Int("stack_identifier", stack.ID).
+		Int("stack_version", stack.Version).
+		Time("until", window.Until).
+		Str("reason", window.Reason).
+		Msg("stack staged, update deferred by freeze window")
+
+	manager.setStatus(stack, StatusFrozen)
+
+	message := fmt.Sprintf("update deferred (frozen) until %s", window.Until.UTC().Format(time.RFC3339))
+	if window.Reason != "" {
+		message += ": " + window.Reason
+	}
+
+	if err := manager.portainerClient.SetEdgeStackStatus(stack.ID, portainer.EdgeStackStatusAcknowledged, nil, message); err != nil {
+		log.Error().Err(err).Int("stack_identifier", stack.ID).Msg("unable to update Edge stack status")
+	}
+
+	return true
+}
+
+// nextThawedStack moves the first frozen stack whose freeze window ended back to StatusPending.
+// The caller must hold the manager lock.
+func (manager *StackManager) nextThawedStack() *edgeStack {
+	for _, stack := range manager.sortedStacks() {
+		if stack.Status == StatusFrozen && manager.freezeWindow(stack.ID) == nil {
+			log.Debug().Int("stack_identifier", stack.ID).Msg("freeze window ended, resuming stack update")
+
+			manager.setStatus(stack, StatusPending)
+
+			return stack
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package stack
+
+import (
+	"testing"
+	"time"
+
+	"github.com/portainer/agent/clock"
+	"github.com/portainer/agent/edge/freeze"
+	"github.com/portainer/agent/internals/mocks"
+	portainer "github.com/portainer/portainer/api"
+	"github.com/portainer/portainer/api/edge"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gomock "go.uber.org/mock/gomock"
+)
+
+func TestStackManager_deferFrozen(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClient := mocks.NewMockPortainerClient(ctrl)
+	fakeClock := clock.NewFakeClock(time.Now())
+	dataPath := t.TempDir()
+
+	stack := &edgeStack{
+		StackPayload: edge.StackPayload{ID: 1, Version: 2},
+		Status:       StatusDeploying,
+		Action:       actionUpdate,
+	}
+
+	manager := &StackManager{
+		portainerClient: mockClient,
+		clock:           fakeClock,
+		stacks:          map[edgeStackID]*edgeStack{1: stack},
+		freezeDataPath:  dataPath,
+	}
+
+	assert.False(t, manager.deferFrozen(stack))
+
+	// Freezing another stack does not defer the update
+	require.NoError(t, freeze.Add(dataPath, freeze.Window{StackID: 2, Until: fakeClock.Now().Add(time.Hour)}))
+	assert.False(t, manager.deferFrozen(stack))
+
+	require.NoError(t, freeze.Add(dataPath, freeze.Window{Until: fakeClock.Now().Add(time.Hour), Reason: "blackout"}))
+
+	mockClient.EXPECT().SetEdgeStackStatus(1, portainer.EdgeStackStatusAcknowledged, nil, gomock.Any()).
+		DoAndReturn(func(_ int, _ portainer.EdgeStackStatusType, _ *int, message string) error {
+			assert.Contains(t, message, "update deferred (frozen)")
+			assert.Contains(t, message, "blackout")
+
+			return nil
+		})
+
+	assert.True(t, manager.deferFrozen(stack))
+	assert.Equal(t, StatusFrozen, stack.Status)
+	assert.Nil(t, manager.nextThawedStack())
+
+	fakeClock.Advance(time.Hour)
+
+	assert.Equal(t, stack, manager.nextThawedStack())
+	assert.Equal(t, StatusPending, stack.Status)
+
+	// The removals are not frozen
+	require.NoError(t, freeze.Add(dataPath, freeze.Window{StackID: 1, Until: fakeClock.Now().Add(time.Hour)}))
+	stack.Action = actionDelete
+	assert.False(t, manager.deferFrozen(stack))
+}
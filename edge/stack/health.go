@@ -0,0 +1,26 @@
+package stack
+
+import (
+	"context"
+	"errors"
+
+	"github.com/portainer/agent/docker"
+)
+
+// CheckDeployer returns an error when the stacks cannot be deployed, i.e. the engine was not detected yet or the
+// Docker engine is not reachable
+func (manager *StackManager) CheckDeployer(ctx context.Context) error {
+	manager.mu.Lock()
+	deployer, engine := manager.deployer, manager.engineType
+	manager.mu.Unlock()
+
+	if deployer == nil {
+		return errors.New("the deployer of the engine is not initialized")
+	}
+
+	if engine == EngineTypeDockerStandalone || engine == EngineTypeDockerSwarm {
+		return docker.Ping(ctx)
+	}
+
+	return nil
+}
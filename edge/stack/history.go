@@ -0,0 +1,329 @@
+package stack
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	agentfs "github.com/portainer/agent/filesystem"
+	portainer "github.com/portainer/portainer/api"
+	"github.com/portainer/portainer/api/filesystem"
+	"github.com/rs/zerolog/log"
+)
+
+// historyFolderSuffix is the suffix for the path where the successfully deployed versions of an edge stack are kept
+const historyFolderSuffix = ".history"
+
+const historyMetadataFileName = "metadata.json"
+
+// DefaultHistoryRetention is the default number of successfully deployed versions kept for each stack
+const DefaultHistoryRetention = 3
+
+// StackVersionMetadata describes a successfully deployed version of an Edge stack
+type StackVersionMetadata struct {
+	Version    int               `json:"version"`
+	DeployedAt int64             `json:"deployedAt"`
+	Size       int64             `json:"size"`
+	Digests    map[string]string `json:"digests"`
+	Name       string            `json:"name,omitempty"`
+	// Env is the environment of the stack, the values of the variables that look like secrets are redacted
+	Env map[string]string `json:"env,omitempty"`
+}
+
+// redactedEnvValue replaces the values of the variables that look like secrets in the retained environments
+const redactedEnvValue = "<redacted>"
+
+// secretEnvNames are the parts of the names of the variables whose values are redacted
+var secretEnvNames = []string{"KEY", "SECRET", "PASSWORD", "PASSWD", "TOKEN", "CREDENTIAL", "AUTH", "PRIVATE"}
+
+// HistoryStackFileFolder returns the folder where the successfully deployed versions of an Edge stack are kept
+func HistoryStackFileFolder(fileFolder string) string {
+	return fmt.Sprintf("%s%s", fileFolder, historyFolderSuffix)
+}
+
+// SetHistoryRetention sets how many successfully deployed versions are kept for each stack,
+// and optionally the maximum size in bytes they can use. A maxSize of 0 disables the size limit.
+func (manager *StackManager) SetHistoryRetention(count int, maxSize int64) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	manager.historyCount = count
+	manager.historyMaxSize = maxSize
+}
+
+// saveStackHistory keeps a copy of the deployed stack files along with their metadata then prunes the history
+func (manager *StackManager) saveStackHistory(stack *edgeStack) error {
+	if manager.historyCount <= 0 {
+		return nil
+	}
+
+	historyFolder := HistoryStackFileFolder(stack.FileFolder)
+	versionFolder := filepath.Join(historyFolder, strconv.Itoa(stack.Version))
+
+	if err := os.RemoveAll(versionFolder); err != nil {
+		return err
+	}
+
+	if err := filesystem.CopyDir(resolveStackFileFolder(stack.FileFolder), versionFolder, false); err != nil {
+		return err
+	}
+
+	metadata := StackVersionMetadata{
+		Version:    stack.Version,
+		DeployedAt: manager.now().Unix(),
+		Digests:    make(map[string]string),
+		Name:       stack.Name,
+		Env:        redactEnv(stack.EnvVars),
+	}
+
+	err := filepath.WalkDir(versionFolder, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		digest, size, err := fileDigest(path)
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(versionFolder, path)
+		if err != nil {
+			return err
+		}
+
+		metadata.Digests[filepath.ToSlash(rel)] = digest
+		metadata.Size += size
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(filepath.Join(versionFolder, historyMetadataFileName), data, 0600); err != nil {
+		return err
+	}
+
+	if err := agentfs.SyncTree(versionFolder); err != nil {
+		return err
+	}
+
+	return pruneStackHistory(historyFolder, manager.historyCount, manager.historyMaxSize)
+}
+
+// redactEnv returns the environment of the stack with the values of the variables that look like secrets redacted
+func redactEnv(envVars []portainer.Pair) map[string]string {
+	env := make(map[string]string, len(envVars))
+
+	for _, pair := range envVars {
+		env[pair.Name] = pair.Value
+
+		name := strings.ToUpper(pair.Name)
+		for _, secret := range secretEnvNames {
+			if strings.Contains(name, secret) {
+				env[pair.Name] = redactedEnvValue
+
+				break
+			}
+		}
+	}
+
+	return env
+}
+
+func fileDigest(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+// ListStackHistory returns the retained versions of the stack whose files are written to the folder, the most recently
+// deployed first. It does not need the stack manager, so that the history can be read by another process.
+func ListStackHistory(fileFolder string) ([]StackVersionMetadata, error) {
+	return listStackHistory(HistoryStackFileFolder(fileFolder))
+}
+
+// listStackHistory returns the metadata of the retained versions, the most recently deployed first
+func listStackHistory(historyFolder string) ([]StackVersionMetadata, error) {
+	entries, err := os.ReadDir(historyFolder)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var history []StackVersionMetadata
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(historyFolder, entry.Name(), historyMetadataFileName))
+		if err != nil {
+			log.Warn().Err(err).Str("folder", entry.Name()).Msg("ignoring Edge stack history entry without metadata")
+
+			continue
+		}
+
+		var metadata StackVersionMetadata
+		if err := json.Unmarshal(data, &metadata); err != nil {
+			log.Warn().Err(err).Str("folder", entry.Name()).Msg("ignoring Edge stack history entry with invalid metadata")
+
+			continue
+		}
+
+		history = append(history, metadata)
+	}
+
+	sort.Slice(history, func(i, j int) bool {
+		return history[i].DeployedAt > history[j].DeployedAt
+	})
+
+	return history, nil
+}
+
+// pruneStackHistory removes the oldest versions beyond maxCount, then the oldest versions
+// until the history fits in maxSize. The most recent version is always kept.
+func pruneStackHistory(historyFolder string, maxCount int, maxSize int64) error {
+	history, err := listStackHistory(historyFolder)
+	if err != nil {
+		return err
+	}
+
+	var totalSize int64
+	for i, metadata := range history {
+		totalSize += metadata.Size
+
+		if i == 0 || (i < maxCount && (maxSize <= 0 || totalSize <= maxSize)) {
+			continue
+		}
+
+		if err := os.RemoveAll(filepath.Join(historyFolder, strconv.Itoa(metadata.Version))); err != nil {
+			return err
+		}
+
+		totalSize -= metadata.Size
+	}
+
+	return nil
+}
+
+// GetStackHistory returns the retained versions of a stack, the most recently deployed first
+func (manager *StackManager) GetStackHistory(stackID int) ([]StackVersionMetadata, error) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	stack, ok := manager.stacks[edgeStackID(stackID)]
+	if !ok {
+		return nil, fmt.Errorf("stack %d not found", stackID)
+	}
+
+	return listStackHistory(HistoryStackFileFolder(stack.FileFolder))
+}
+
+// RollbackStack redeploys a retained version of a stack. The stack keeps its current version
+// so that it is only replaced once a new version is received from the server.
+func (manager *StackManager) RollbackStack(stackID, version int) error {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	return manager.rollbackStack(stackID, version)
+}
+
+// rollbackStack redeploys a retained version of a stack.
+// The caller must hold the manager lock.
+func (manager *StackManager) rollbackStack(stackID, version int) error {
+	originalStack, ok := manager.stacks[edgeStackID(stackID)]
+	if !ok {
+		return fmt.Errorf("stack %d not found", stackID)
+	}
+
+	versionFolder := filepath.Join(HistoryStackFileFolder(originalStack.FileFolder), strconv.Itoa(version))
+	data, err := os.ReadFile(filepath.Join(versionFolder, historyMetadataFileName))
+	if err != nil {
+		return fmt.Errorf("version %d of stack %d is not retained: %w", version, stackID, err)
+	}
+
+	var metadata StackVersionMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return err
+	}
+
+	log.Info().Int("stack_identifier", stackID).Int("version", version).Msg("rolling back stack")
+
+	if err := manager.restoreStackHistory(originalStack, versionFolder); err != nil {
+		return err
+	}
+
+	clonedStack := *originalStack
+	stack := &clonedStack
+
+	stack.Action = actionUpdate
+	stack.RolledBackVersion = version
+	stack.FileChecksums = metadata.Digests
+	// The retained version holds its large files
+	stack.LargeFiles = nil
+	stack.PullFinished = false
+	stack.PullCount = 0
+	stack.PulledImages = nil
+	stack.DeployCount = 0
+	manager.setStatus(stack, StatusPending)
+
+	manager.putStack(stack)
+
+	return nil
+}
+
+// restoreStackHistory replaces the stack files by the ones retained in the history folder
+func (manager *StackManager) restoreStackHistory(stack *edgeStack, historyVersionFolder string) error {
+	dst := stack.FileFolder
+	if !IsRelativePathStack(stack) {
+		dst = manager.newStackVersionFolder(stack)
+	} else if err := os.RemoveAll(dst); err != nil {
+		return err
+	}
+
+	if err := filesystem.CopyDir(historyVersionFolder, dst, false); err != nil {
+		return err
+	}
+
+	if err := os.Remove(filepath.Join(dst, historyMetadataFileName)); err != nil {
+		return err
+	}
+
+	if err := agentfs.SyncTree(dst); err != nil {
+		return err
+	}
+
+	if IsRelativePathStack(stack) {
+		return nil
+	}
+
+	return switchStackFileFolder(stack.FileFolder, dst)
+}
@@ -0,0 +1,85 @@
+package stack
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/portainer/agent/clock"
+	"github.com/portainer/portainer/api/edge"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStackManager_saveStackHistory(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Unix(1000, 0))
+	manager := &StackManager{
+		clock:        fakeClock,
+		historyCount: 2,
+		stacks:       map[edgeStackID]*edgeStack{},
+	}
+
+	fileFolder := filepath.Join(t.TempDir(), "1")
+	stack := &edgeStack{
+		StackPayload: edge.StackPayload{ID: 1},
+		FileFolder:   fileFolder,
+		FileName:     "docker-compose.yml",
+		Status:       StatusDeployed,
+	}
+	manager.stacks[1] = stack
+
+	for version := 1; version <= 3; version++ {
+		require.NoError(t, os.MkdirAll(fileFolder, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(fileFolder, stack.FileName), []byte{byte(version)}, 0644))
+
+		stack.Version = version
+		require.NoError(t, manager.saveStackHistory(stack))
+
+		fakeClock.Advance(time.Minute)
+	}
+
+	history, err := manager.GetStackHistory(1)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, 3, history[0].Version)
+	assert.Equal(t, 2, history[1].Version)
+	assert.Contains(t, history[0].Digests, "docker-compose.yml")
+
+	err = manager.RollbackStack(1, 1)
+	assert.Error(t, err)
+
+	require.NoError(t, manager.RollbackStack(1, 2))
+
+	content, err := os.ReadFile(filepath.Join(fileFolder, stack.FileName))
+	require.NoError(t, err)
+	assert.Equal(t, []byte{2}, content)
+
+	assert.NoFileExists(t, filepath.Join(fileFolder, historyMetadataFileName))
+	assert.Equal(t, StatusPending, manager.stacks[1].Status)
+	assert.Equal(t, actionUpdate, manager.stacks[1].Action)
+	assert.Equal(t, 3, manager.stacks[1].Version)
+	assert.Equal(t, 2, manager.stacks[1].RolledBackVersion)
+}
+
+func TestPruneStackHistory_size(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Unix(1000, 0))
+	manager := &StackManager{clock: fakeClock, historyCount: 10, historyMaxSize: 15}
+
+	fileFolder := filepath.Join(t.TempDir(), "1")
+	require.NoError(t, os.MkdirAll(fileFolder, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(fileFolder, "docker-compose.yml"), make([]byte, 10), 0644))
+
+	stack := &edgeStack{FileFolder: fileFolder}
+	for version := 1; version <= 3; version++ {
+		stack.Version = version
+		require.NoError(t, manager.saveStackHistory(stack))
+
+		fakeClock.Advance(time.Minute)
+	}
+
+	history, err := listStackHistory(HistoryStackFileFolder(fileFolder))
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Equal(t, 3, history[0].Version)
+}
@@ -0,0 +1,122 @@
+package stack
+
+import (
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// StackEvent describes an Edge stack at the time a lifecycle hook is called
+type StackEvent struct {
+	StackID   int
+	Name      string
+	Version   int
+	Namespace string
+	// Status is the status of the stack when the hook is called, e.g. "Deployed" or "Completed" for OnDeployed
+	Status string
+	// Error is only set for the OnError hook
+	Error string
+}
+
+// Hooks is a set of callbacks called during the lifecycle of Edge stacks.
+// Any of the callbacks can be left nil. Callbacks are called synchronously
+// by the stack manager and must return quickly, long running work should be
+// done in a separate goroutine.
+type Hooks struct {
+	// OnAcknowledged is called when a new version of a stack is received from the server
+	OnAcknowledged func(event StackEvent)
+	// OnBeforeDeploy is called right before the stack is deployed
+	OnBeforeDeploy func(event StackEvent)
+	// OnDeployed is called once the stack is confirmed to be running or completed
+	OnDeployed func(event StackEvent)
+	// OnError is called when the stack fails to be validated, pulled, deployed or started
+	OnError func(event StackEvent)
+	// OnRemoved is called once the stack is confirmed to be removed
+	OnRemoved func(event StackEvent)
+}
+
+type hookKind int
+
+const (
+	_ hookKind = iota
+	hookAcknowledged
+	hookBeforeDeploy
+	hookDeployed
+	hookError
+	hookRemoved
+)
+
+var (
+	hooksMu         sync.RWMutex
+	registeredHooks []Hooks
+)
+
+// RegisterHooks subscribes the given hooks to the lifecycle events of all Edge stacks.
+// It is meant to be called by modules compiled into the agent, usually from an init function.
+func RegisterHooks(hooks Hooks) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+
+	registeredHooks = append(registeredHooks, hooks)
+}
+
+func (h Hooks) get(kind hookKind) func(event StackEvent) {
+	switch kind {
+	case hookAcknowledged:
+		return h.OnAcknowledged
+	case hookBeforeDeploy:
+		return h.OnBeforeDeploy
+	case hookDeployed:
+		return h.OnDeployed
+	case hookError:
+		return h.OnError
+	case hookRemoved:
+		return h.OnRemoved
+	}
+
+	return nil
+}
+
+func newStackEvent(stack *edgeStack, errMsg string) StackEvent {
+	return StackEvent{
+		StackID:   stack.ID,
+		Name:      stack.Name,
+		Version:   stack.Version,
+		Namespace: stack.Namespace,
+		Status:    stack.Status.String(),
+		Error:     errMsg,
+	}
+}
+
+// runHooks calls the registered hooks of the given kind, a panicking hook is
+// logged and does not prevent the other hooks from being called
+func runHooks(kind hookKind, stack *edgeStack, errMsg string) {
+	hooksMu.RLock()
+	defer hooksMu.RUnlock()
+
+	if len(registeredHooks) == 0 {
+		return
+	}
+
+	event := newStackEvent(stack, errMsg)
+
+	for _, hooks := range registeredHooks {
+		fn := hooks.get(kind)
+		if fn == nil {
+			continue
+		}
+
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Error().
+						Int("stack_identifier", stack.ID).
+						Interface("panic", r).
+						Msg("Edge stack lifecycle hook panicked")
+				}
+			}()
+
+			fn(event)
+		}()
+	}
+}
@@ -0,0 +1,33 @@
+package stack
+
+import (
+	"testing"
+
+	"github.com/portainer/portainer/api/edge"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunHooks(t *testing.T) {
+	defer func() { registeredHooks = nil }()
+
+	var events []StackEvent
+
+	RegisterHooks(Hooks{
+		OnError: func(event StackEvent) {
+			panic("faulty hook")
+		},
+	})
+	RegisterHooks(Hooks{
+		OnError: func(event StackEvent) {
+			events = append(events, event)
+		},
+	})
+
+	stack := &edgeStack{StackPayload: edge.StackPayload{ID: 1, Name: "my-stack", Version: 2}, Status: StatusError}
+
+	runHooks(hookDeployed, stack, "")
+	assert.Empty(t, events)
+
+	runHooks(hookError, stack, "deploy failed")
+	assert.Equal(t, []StackEvent{{StackID: 1, Name: "my-stack", Version: 2, Status: "Error", Error: "deploy failed"}}, events)
+}
@@ -0,0 +1,89 @@
+package stack
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/portainer/agent/edge/imagepolicy"
+	"github.com/portainer/agent/edge/yaml"
+)
+
+// SetImagePolicy enforces the image policy of the device, the stacks using images it does not allow are neither
+// pulled nor deployed
+func (manager *StackManager) SetImagePolicy(policy *imagepolicy.Policy) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	manager.imagePolicy = policy
+}
+
+// checkImagePolicy returns an imagepolicy.ErrImageDenied error when an image of the entry file of the stack is not
+// allowed by the image policy. The variables of the images are interpolated with the environment of the stack, the
+// images that cannot be evaluated are denied. The caller must hold the manager lock.
+func (manager *StackManager) checkImagePolicy(ctx context.Context, stack *edgeStack, stackFileLocation string) error {
+	if manager.imagePolicy == nil {
+		return nil
+	}
+
+	content, err := os.ReadFile(stackFileLocation)
+	if err != nil {
+		return err
+	}
+
+	images, err := yaml.Images(string(content))
+	if err != nil {
+		return fmt.Errorf("%w: unable to find the images of the stack: %w", imagepolicy.ErrImageDenied, err)
+	}
+
+	env := make(map[string]string, len(stack.EnvVars))
+	for _, pair := range stack.EnvVars {
+		env[pair.Name] = pair.Value
+	}
+
+	credentials := manager.stackRegistryCredentials(stack.ID)
+
+	for _, image := range images {
+		image = interpolate(image, env)
+
+		registry, _ := yaml.ImageRegistry(image)
+
+		if err := manager.imagePolicy.Evaluate(ctx, image, findRegistryCredentials(credentials, registry)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// interpolate replaces the variables of the value as compose does, ${VAR}, $VAR, ${VAR:-default} and ${VAR-default}.
+// The unset variables are replaced by an empty string.
+func interpolate(value string, env map[string]string) string {
+	return os.Expand(value, func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+
+		if key, fallback, ok := strings.Cut(name, ":-"); ok {
+			if env[key] == "" {
+				return fallback
+			}
+
+			return env[key]
+		}
+
+		if key, fallback, ok := strings.Cut(name, "-"); ok {
+			if value, set := env[key]; set {
+				return value
+			}
+
+			return fallback
+		}
+
+		key, _, _ := strings.Cut(name, ":?")
+		key, _, _ = strings.Cut(key, "?")
+
+		return env[key]
+	})
+}
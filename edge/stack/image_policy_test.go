@@ -0,0 +1,60 @@
+package stack
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/portainer/agent/edge/credstore"
+	"github.com/portainer/agent/edge/imagepolicy"
+	portainer "github.com/portainer/portainer/api"
+	"github.com/portainer/portainer/api/edge"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterpolate(t *testing.T) {
+	env := map[string]string{"REGISTRY": "registry.example.com", "EMPTY": ""}
+
+	assert.Equal(t, "registry.example.com/web:1.0", interpolate("${REGISTRY}/web:1.0", env))
+	assert.Equal(t, "registry.example.com/web:1.0", interpolate("$REGISTRY/web:${TAG:-1.0}", env))
+	assert.Equal(t, "web:", interpolate("web:${EMPTY-1.0}", env))
+	assert.Equal(t, "web:1.0", interpolate("web:${EMPTY:-1.0}", env))
+	assert.Equal(t, "web:", interpolate("web:${TAG:?the tag is required}", env))
+}
+
+func TestStackManager_checkImagePolicy(t *testing.T) {
+	policyFile := filepath.Join(t.TempDir(), "policy.json")
+	require.NoError(t, os.WriteFile(policyFile, []byte(`{"allowedRegistries": ["registry.example.com"]}`), 0600))
+
+	policy, err := imagepolicy.Load(policyFile)
+	require.NoError(t, err)
+
+	stackFile := filepath.Join(t.TempDir(), "docker-compose.yml")
+	require.NoError(t, os.WriteFile(stackFile, []byte(`
+services:
+  web:
+    image: ${REGISTRY}/team/web:${TAG:-1.0}
+`), 0600))
+
+	manager := &StackManager{credentialStore: credstore.NewMemoryStore(), stacks: map[edgeStackID]*edgeStack{}}
+
+	stack := &edgeStack{StackPayload: edge.StackPayload{
+		ID:      1,
+		EnvVars: []portainer.Pair{{Name: "REGISTRY", Value: "registry.example.com"}},
+	}}
+
+	// No policy
+	assert.NoError(t, manager.checkImagePolicy(context.Background(), &edgeStack{}, stackFile))
+
+	manager.SetImagePolicy(policy)
+	assert.NoError(t, manager.checkImagePolicy(context.Background(), stack, stackFile))
+
+	stack.EnvVars = []portainer.Pair{{Name: "REGISTRY", Value: "ghcr.io"}}
+	assert.ErrorIs(t, manager.checkImagePolicy(context.Background(), stack, stackFile), imagepolicy.ErrImageDenied)
+
+	// The images using unknown variables cannot be evaluated
+	stack.EnvVars = nil
+	assert.ErrorIs(t, manager.checkImagePolicy(context.Background(), stack, stackFile), imagepolicy.ErrImageDenied)
+}
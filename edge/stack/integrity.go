@@ -0,0 +1,138 @@
+package stack
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	portainer "github.com/portainer/portainer/api"
+	"github.com/portainer/portainer/api/filesystem"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ErrIntegrity is returned when the persisted files of a stack do not match the checksums sent by the server
+var ErrIntegrity = errors.New("stack files integrity check failed")
+
+// verifyStackFiles checks the files persisted in the folder against the given checksums.
+// Stacks without checksums are not verified.
+func verifyStackFiles(folder string, checksums map[string]string) error {
+	paths := make([]string, 0, len(checksums))
+	for path := range checksums {
+		paths = append(paths, path)
+	}
+
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		if !filepath.IsLocal(path) {
+			return fmt.Errorf("%w: invalid path %q", ErrIntegrity, path)
+		}
+
+		expected := normalizeChecksum(checksums[path])
+
+		actual, err := sha256File(filepath.Join(folder, path))
+		if err != nil {
+			return fmt.Errorf("%w: %s: %w", ErrIntegrity, path, err)
+		}
+
+		if actual != expected {
+			return fmt.Errorf("%w: %s: checksum mismatch", ErrIntegrity, path)
+		}
+	}
+
+	return nil
+}
+
+// verifyDirEntries checks the decoded content received from the server against the given checksums
+func verifyDirEntries(dirEntries []filesystem.DirEntry, checksums map[string]string) error {
+	for path, checksum := range checksums {
+		entry := findDirEntry(dirEntries, path)
+		if entry == nil {
+			return fmt.Errorf("%w: %s: file not found in the stack payload", ErrIntegrity, path)
+		}
+
+		if sha256String(entry.Content) != normalizeChecksum(checksum) {
+			return fmt.Errorf("%w: %s: checksum mismatch in the stack payload", ErrIntegrity, path)
+		}
+	}
+
+	return nil
+}
+
+// persistedChecksums returns the checksums of the files as they are persisted on disk,
+// which differ from the ones sent by the server when the agent modifies the entry file
+func persistedChecksums(dirEntries []filesystem.DirEntry, checksums map[string]string) map[string]string {
+	if len(checksums) == 0 {
+		return nil
+	}
+
+	persisted := make(map[string]string, len(checksums))
+	for path := range checksums {
+		if entry := findDirEntry(dirEntries, path); entry != nil {
+			persisted[path] = sha256String(entry.Content)
+		}
+	}
+
+	return persisted
+}
+
+func findDirEntry(dirEntries []filesystem.DirEntry, path string) *filesystem.DirEntry {
+	for i := range dirEntries {
+		if dirEntries[i].IsFile && filepath.Clean(dirEntries[i].Name) == filepath.Clean(path) {
+			return &dirEntries[i]
+		}
+	}
+
+	return nil
+}
+
+func normalizeChecksum(checksum string) string {
+	return strings.ToLower(strings.TrimPrefix(checksum, "sha256:"))
+}
+
+func sha256String(content string) string {
+	h := sha256.Sum256([]byte(content))
+
+	return hex.EncodeToString(h[:])
+}
+
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// markIntegrityError moves the stack to StatusIntegrityError.
+// The caller must hold the manager lock.
+func (manager *StackManager) markIntegrityError(stack *edgeStack, err error) {
+	log.Error().Err(err).Int("stack_identifier", stack.ID).Msg("stack files integrity check failed")
+
+	manager.setStatusMessage(stack, StatusIntegrityError, err.Error())
+	runHooks(hookError, stack, err.Error())
+}
+
+// failIntegrityCheck moves the stack to StatusIntegrityError and reports the failure to the server.
+// The caller must hold the manager lock.
+func (manager *StackManager) failIntegrityCheck(stack *edgeStack, err error) {
+	manager.markIntegrityError(stack, err)
+
+	if err := manager.portainerClient.SetEdgeStackStatus(stack.ID, portainer.EdgeStackStatusError, stack.RollbackTo, err.Error()); err != nil {
+		log.Error().Err(err).Int("stack_identifier", stack.ID).Msg("unable to update Edge stack status")
+	}
+}
@@ -0,0 +1,45 @@
+package stack
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/portainer/portainer/api/filesystem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyStackFiles(t *testing.T) {
+	folder := t.TempDir()
+	content := "services: {}"
+
+	require.NoError(t, os.WriteFile(filepath.Join(folder, "docker-compose.yml"), []byte(content), 0644))
+
+	checksums := map[string]string{"docker-compose.yml": "sha256:" + sha256String(content)}
+	assert.NoError(t, verifyStackFiles(folder, checksums))
+	assert.NoError(t, verifyStackFiles(folder, nil))
+
+	require.NoError(t, os.WriteFile(filepath.Join(folder, "docker-compose.yml"), []byte("tampered"), 0644))
+	err := verifyStackFiles(folder, checksums)
+	assert.True(t, errors.Is(err, ErrIntegrity))
+
+	err = verifyStackFiles(folder, map[string]string{"../outside.yml": sha256String(content)})
+	assert.True(t, errors.Is(err, ErrIntegrity))
+}
+
+func TestVerifyDirEntries(t *testing.T) {
+	dirEntries := []filesystem.DirEntry{
+		{Name: "stack", IsFile: false},
+		{Name: "stack/docker-compose.yml", Content: "services: {}", IsFile: true},
+	}
+
+	assert.NoError(t, verifyDirEntries(dirEntries, map[string]string{"stack/docker-compose.yml": sha256String("services: {}")}))
+
+	err := verifyDirEntries(dirEntries, map[string]string{"stack/docker-compose.yml": sha256String("other")})
+	assert.True(t, errors.Is(err, ErrIntegrity))
+
+	err = verifyDirEntries(dirEntries, map[string]string{"missing.yml": sha256String("")})
+	assert.True(t, errors.Is(err, ErrIntegrity))
+}
@@ -0,0 +1,170 @@
+package stack
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/portainer/agent"
+	"github.com/portainer/agent/docker"
+	"github.com/portainer/agent/edge/client"
+
+	"github.com/rs/zerolog/log"
+)
+
+// SetRecovery is called with the recovery flag of each desired state received from the server. While the server
+// recovers its stacks, the stacks it does not send are neither removed nor swept as orphans, and the stacks deployed
+// on the device are reported once so that the server can adopt them again.
+func (manager *StackManager) SetRecovery(recovering bool) {
+	manager.mu.Lock()
+
+	if recovering != manager.recovering {
+		log.Info().Bool("recovering", recovering).Msg("the server changed the recovery of its Edge stacks")
+	}
+
+	manager.recovering = recovering
+	if !recovering {
+		manager.inventoryReported = false
+	}
+
+	report := recovering && !manager.inventoryReported
+
+	manager.mu.Unlock()
+
+	if !report {
+		return
+	}
+
+	// The inventory is reported again with the next desired state when it cannot be reported
+	if err := manager.ReportInventory(); err != nil {
+		log.Error().Err(err).Msg("unable to report the Edge stacks deployed on the device")
+
+		return
+	}
+
+	manager.mu.Lock()
+	manager.inventoryReported = manager.recovering
+	manager.mu.Unlock()
+}
+
+// ReportInventory reports the stacks deployed on the device to the server: the stacks managed by the agent, the
+// stacks whose last successfully deployed files are on disk and the stacks whose resources carry the labels of
+// the agent
+func (manager *StackManager) ReportInventory() error {
+	manager.mu.Lock()
+
+	known := make(map[int]client.DeployedStack, len(manager.stacks))
+	for stackID, stack := range manager.stacks {
+		if stack.Action == actionDelete {
+			continue
+		}
+
+		known[int(stackID)] = client.DeployedStack{
+			ID:          stack.ID,
+			Name:        stack.Name,
+			Version:     stack.Version,
+			ProjectName: manager.stackProjectName(stack),
+			Managed:     true,
+		}
+	}
+
+	resources, edgeID := manager.resources, manager.edgeID
+
+	manager.mu.Unlock()
+
+	var labeled []docker.LabeledResource
+	if resources != nil {
+		var err error
+		if labeled, err = resources.List(); err != nil {
+			return err
+		}
+	}
+
+	inventory, err := stackInventory(agent.EdgeStackFilesPath, known, labeled, edgeID)
+	if err != nil {
+		return err
+	}
+
+	log.Info().Int("stacks", len(inventory)).Msg("reporting the Edge stacks deployed on the device")
+
+	return manager.portainerClient.SetEdgeStackInventory(inventory)
+}
+
+// stackInventory merges the stacks known by the agent with the success folders of the stacks folder and the
+// resources labeled by the agent, sorted by identifier. The name and the version of the stacks only found on disk
+// are read from their retained versions.
+func stackInventory(stacksPath string, known map[int]client.DeployedStack, resources []docker.LabeledResource, edgeID string) ([]client.DeployedStack, error) {
+	stacks := make(map[int]client.DeployedStack, len(known))
+	for stackID, stack := range known {
+		stacks[stackID] = stack
+	}
+
+	entries, err := os.ReadDir(stacksPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		folder, ok := strings.CutSuffix(entry.Name(), successFolderSuffix)
+		if !ok {
+			continue
+		}
+
+		stackID, err := strconv.Atoi(folder)
+		if err != nil {
+			continue
+		}
+
+		if _, ok := stacks[stackID]; ok {
+			continue
+		}
+
+		stack := client.DeployedStack{ID: stackID}
+
+		history, err := ListStackHistory(filepath.Join(stacksPath, folder))
+		if err != nil {
+			log.Warn().Err(err).Int("stack_identifier", stackID).Msg("unable to read the retained versions of the stack")
+		} else if len(history) > 0 {
+			stack.Name, stack.Version = history[0].Name, history[0].Version
+		}
+
+		stacks[stackID] = stack
+	}
+
+	for _, resource := range resources {
+		// The resources of the other agents sharing the engine are not reported
+		if resource.Labels[AgentIDLabel] != edgeID {
+			continue
+		}
+
+		stackID, err := strconv.Atoi(resource.Labels[StackIDLabel])
+		if err != nil {
+			continue
+		}
+
+		stack := stacks[stackID]
+		stack.ID = stackID
+
+		if stack.ProjectName == "" {
+			stack.ProjectName = resourceProject(resource)
+		}
+
+		if resource.Kind == docker.ResourceContainer {
+			stack.Containers++
+		}
+
+		stacks[stackID] = stack
+	}
+
+	inventory := make([]client.DeployedStack, 0, len(stacks))
+	for _, stack := range stacks {
+		inventory = append(inventory, stack)
+	}
+
+	sort.Slice(inventory, func(i, j int) bool { return inventory[i].ID < inventory[j].ID })
+
+	return inventory, nil
+}
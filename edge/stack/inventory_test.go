@@ -0,0 +1,84 @@
+package stack
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/portainer/agent/docker"
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/portainer/api/edge"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStackInventory(t *testing.T) {
+	stacksPath := t.TempDir()
+
+	// Deployed before the agent restarted, with its retained versions
+	require.NoError(t, os.MkdirAll(SuccessStackFileFolder(filepath.Join(stacksPath, "2")), 0755))
+
+	versionFolder := filepath.Join(HistoryStackFileFolder(filepath.Join(stacksPath, "2")), "4")
+	require.NoError(t, os.MkdirAll(versionFolder, 0755))
+
+	metadata, err := json.Marshal(StackVersionMetadata{Version: 4, Name: "db"})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(versionFolder, historyMetadataFileName), metadata, 0644))
+
+	// Deployed without retained versions
+	require.NoError(t, os.MkdirAll(SuccessStackFileFolder(filepath.Join(stacksPath, "3")), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(stacksPath, "5"), 0755))
+
+	known := map[int]client.DeployedStack{
+		1: {ID: 1, Name: "web", Version: 2, ProjectName: "edge_web", Managed: true},
+	}
+
+	resources := []docker.LabeledResource{
+		labeledResource(docker.ResourceContainer, "web", "1", "edge_web", "edge-id"),
+		labeledResource(docker.ResourceContainer, "db-1", "2", "edge_db", "edge-id"),
+		labeledResource(docker.ResourceContainer, "db-2", "2", "edge_db", "edge-id"),
+		labeledResource(docker.ResourceNetwork, "db_default", "2", "edge_db", "edge-id"),
+		// Only found through its labels, e.g. a relative path stack
+		labeledResource(docker.ResourceContainer, "cache", "4", "edge_cache", "edge-id"),
+		// Deployed by another agent
+		labeledResource(docker.ResourceContainer, "other", "6", "edge_other", "other-id"),
+	}
+
+	inventory, err := stackInventory(stacksPath, known, resources, "edge-id")
+	require.NoError(t, err)
+
+	assert.Equal(t, []client.DeployedStack{
+		{ID: 1, Name: "web", Version: 2, ProjectName: "edge_web", Containers: 1, Managed: true},
+		{ID: 2, Name: "db", Version: 4, ProjectName: "edge_db", Containers: 2},
+		{ID: 3},
+		{ID: 4, ProjectName: "edge_cache", Containers: 1},
+	}, inventory)
+}
+
+func TestStackManager_recovery(t *testing.T) {
+	resources := &fakeResources{
+		resources: []docker.LabeledResource{
+			labeledResource(docker.ResourceContainer, "web", "1", "edge_web", "edge-id"),
+		},
+	}
+
+	manager := &StackManager{
+		edgeID:       "edge-id",
+		orphanPolicy: OrphanPolicyRemove,
+		resources:    resources,
+		reconciled:   true,
+		recovering:   true,
+		stacks: map[edgeStackID]*edgeStack{
+			1: {StackPayload: edge.StackPayload{ID: 1, Name: "web"}, Status: StatusDeployed, Action: actionIdle},
+		},
+	}
+
+	// The stacks not sent by the server are kept, and their resources are not swept
+	assert.NoError(t, manager.applyStackOperations(reconcileStacks(map[int]client.StackStatus{}, manager.stacks)))
+	assert.Equal(t, actionIdle, manager.stacks[1].Action)
+
+	delete(manager.stacks, 1)
+	manager.sweepOrphans()
+	assert.Empty(t, resources.removed)
+}
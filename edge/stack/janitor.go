@@ -0,0 +1,170 @@
+package stack
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/portainer/agent/docker"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// OrphanPolicyNone disables the detection of orphaned resources
+	OrphanPolicyNone = "none"
+	// OrphanPolicyReport logs the orphaned resources
+	OrphanPolicyReport = "report"
+	// OrphanPolicyAdopt logs the orphaned resources and adopts the resources created by a previous
+	// agent for a stack managed by the agent, instead of ignoring them
+	OrphanPolicyAdopt = "adopt"
+	// OrphanPolicyRemove removes the orphaned containers and networks. Volumes are never removed
+	// because they hold data, the same way they are kept when a stack is removed without a retention policy.
+	OrphanPolicyRemove = "remove"
+)
+
+// DefaultJanitorInterval is the interval between two lookups for orphaned resources
+const DefaultJanitorInterval = 10 * time.Minute
+
+// resourceRuntime lists and removes the resources labeled by the agent
+type resourceRuntime interface {
+	List() ([]docker.LabeledResource, error)
+	Remove(resource docker.LabeledResource) error
+}
+
+type dockerResources struct{}
+
+func (dockerResources) List() ([]docker.LabeledResource, error) {
+	return docker.GetLabeledResources(StackIDLabel)
+}
+
+func (dockerResources) Remove(resource docker.LabeledResource) error {
+	return docker.RemoveResource(resource)
+}
+
+// SetOrphanPolicy sets what is done with the resources left behind by deleted or crashed stacks
+func (manager *StackManager) SetOrphanPolicy(policy string) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	manager.orphanPolicy = policy
+}
+
+func (manager *StackManager) startJanitor(stopSignal chan struct{}) {
+	if manager.orphanPolicy == "" || manager.orphanPolicy == OrphanPolicyNone {
+		return
+	}
+
+	go func() {
+		for {
+			select {
+			case <-stopSignal:
+				return
+			case <-manager.clock.After(DefaultJanitorInterval):
+				manager.sweepOrphans()
+			}
+		}
+	}()
+}
+
+// resourceProject returns the compose or swarm stack project a resource belongs to
+func resourceProject(resource docker.LabeledResource) string {
+	if project, ok := resource.Labels["com.docker.compose.project"]; ok {
+		return project
+	}
+
+	return resource.Labels["com.docker.stack.namespace"]
+}
+
+// sweepOrphans looks for the resources whose stack is no longer managed by the agent, or that belong
+// to a project the stack is no longer deployed as, and applies the orphan policy to them. Nothing is
+// done until the full desired set of stacks was received, so that the resources of the stacks not
+// received yet after a restart are not mistaken for orphans, nor while the server recovers its stacks.
+func (manager *StackManager) sweepOrphans() {
+	manager.mu.Lock()
+
+	if !manager.reconciled || manager.recovering || manager.resources == nil {
+		manager.mu.Unlock()
+
+		return
+	}
+
+	projects := make(map[int]string, len(manager.stacks))
+	for stackID, stack := range manager.stacks {
+		if !stack.NameConflict {
+			projects[int(stackID)] = manager.stackProjectName(stack)
+		}
+	}
+
+	policy, resources, edgeID := manager.orphanPolicy, manager.resources, manager.edgeID
+
+	if manager.reportedOrphans == nil {
+		manager.reportedOrphans = make(map[string]struct{})
+	}
+
+	manager.mu.Unlock()
+
+	labeled, err := resources.List()
+	if err != nil {
+		log.Error().Err(err).Msg("unable to list the resources of the Edge stacks")
+
+		return
+	}
+
+	for _, resource := range labeled {
+		stackID, err := strconv.Atoi(resource.Labels[StackIDLabel])
+		if err != nil {
+			continue
+		}
+
+		project, managed := projects[stackID]
+		owned := managed && normalizeProjectName(project) == normalizeProjectName(resourceProject(resource))
+
+		if resource.Labels[AgentIDLabel] != edgeID {
+			// Resources created by other agents are left untouched, unless they can be adopted
+			if owned && policy == OrphanPolicyAdopt {
+				manager.reportOrphan(resource, stackID, "adopting the resource created by a previous agent")
+			}
+
+			continue
+		}
+
+		if owned {
+			continue
+		}
+
+		if policy != OrphanPolicyRemove || resource.Kind == docker.ResourceVolume {
+			manager.reportOrphan(resource, stackID, "orphaned Edge stack resource found")
+
+			continue
+		}
+
+		log.Info().
+			Str("kind", string(resource.Kind)).
+			Str("name", resource.Name).
+			Int("stack_identifier", stackID).
+			Msg("removing orphaned Edge stack resource")
+
+		if err := resources.Remove(resource); err != nil {
+			log.Error().Err(err).Str("kind", string(resource.Kind)).Str("name", resource.Name).Msg("unable to remove orphaned resource")
+		}
+	}
+}
+
+// reportOrphan logs a resource once
+func (manager *StackManager) reportOrphan(resource docker.LabeledResource, stackID int, msg string) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	key := string(resource.Kind) + "/" + resource.ID
+	if _, ok := manager.reportedOrphans[key]; ok {
+		return
+	}
+
+	manager.reportedOrphans[key] = struct{}{}
+
+	log.Warn().
+		Str("kind", string(resource.Kind)).
+		Str("name", resource.Name).
+		Int("stack_identifier", stackID).
+		Msg(msg)
+}
@@ -0,0 +1,103 @@
+package stack
+
+import (
+	"testing"
+
+	"github.com/portainer/agent/docker"
+	"github.com/portainer/portainer/api/edge"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeResources struct {
+	resources []docker.LabeledResource
+	removed   []string
+}
+
+func (f *fakeResources) List() ([]docker.LabeledResource, error) {
+	return f.resources, nil
+}
+
+func (f *fakeResources) Remove(resource docker.LabeledResource) error {
+	f.removed = append(f.removed, resource.Name)
+
+	return nil
+}
+
+func labeledResource(kind docker.ResourceKind, name, stackID, project, edgeID string) docker.LabeledResource {
+	return docker.LabeledResource{
+		Kind: kind,
+		ID:   name,
+		Name: name,
+		Labels: map[string]string{
+			StackIDLabel:                 stackID,
+			AgentIDLabel:                 edgeID,
+			"com.docker.compose.project": project,
+		},
+	}
+}
+
+func TestStackManager_sweepOrphans(t *testing.T) {
+	resources := &fakeResources{
+		resources: []docker.LabeledResource{
+			// Owned by a managed stack
+			labeledResource(docker.ResourceContainer, "web", "1", "edge_web", "edge-id"),
+			// Left behind by a renamed stack
+			labeledResource(docker.ResourceContainer, "old-web", "1", "edge_old", "edge-id"),
+			// Left behind by a deleted stack
+			labeledResource(docker.ResourceContainer, "deleted", "2", "edge_deleted", "edge-id"),
+			labeledResource(docker.ResourceNetwork, "deleted_default", "2", "edge_deleted", "edge-id"),
+			labeledResource(docker.ResourceVolume, "deleted_data", "2", "edge_deleted", "edge-id"),
+			// Created by another agent
+			labeledResource(docker.ResourceContainer, "other", "3", "edge_other", "other-id"),
+		},
+	}
+
+	manager := &StackManager{
+		edgeID:       "edge-id",
+		orphanPolicy: OrphanPolicyRemove,
+		resources:    resources,
+		stacks: map[edgeStackID]*edgeStack{
+			1: {StackPayload: edge.StackPayload{ID: 1, Name: "web"}},
+		},
+	}
+
+	// Nothing is removed until the desired stacks are known
+	manager.sweepOrphans()
+	assert.Empty(t, resources.removed)
+
+	manager.reconciled = true
+	manager.sweepOrphans()
+	assert.Equal(t, []string{"old-web", "deleted", "deleted_default"}, resources.removed)
+	assert.Contains(t, manager.reportedOrphans, "volume/deleted_data")
+
+	resources.removed = nil
+	manager.orphanPolicy = OrphanPolicyReport
+	manager.sweepOrphans()
+	assert.Empty(t, resources.removed)
+	assert.Contains(t, manager.reportedOrphans, "container/deleted")
+}
+
+func TestStackManager_sweepOrphans_adopt(t *testing.T) {
+	resources := &fakeResources{
+		resources: []docker.LabeledResource{
+			labeledResource(docker.ResourceContainer, "web", "1", "edge_web", "previous-id"),
+			labeledResource(docker.ResourceContainer, "other", "2", "edge_other", "other-id"),
+		},
+	}
+
+	manager := &StackManager{
+		edgeID:       "edge-id",
+		orphanPolicy: OrphanPolicyAdopt,
+		resources:    resources,
+		reconciled:   true,
+		stacks: map[edgeStackID]*edgeStack{
+			1: {StackPayload: edge.StackPayload{ID: 1, Name: "web"}},
+		},
+	}
+
+	manager.sweepOrphans()
+
+	assert.Empty(t, resources.removed)
+	assert.Contains(t, manager.reportedOrphans, "container/web")
+	assert.NotContains(t, manager.reportedOrphans, "container/other")
+}
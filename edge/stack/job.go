@@ -0,0 +1,107 @@
+package stack
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/portainer/agent"
+	"github.com/portainer/agent/docker"
+	"github.com/portainer/agent/edge/client"
+
+	"github.com/rs/zerolog/log"
+)
+
+func composeExitCodes(project string) (map[string]int, error) {
+	return docker.GetContainerExitCodes("com.docker.compose.project=" + project)
+}
+
+func swarmExitCodes(project string) (map[string]int, error) {
+	return docker.GetContainerExitCodes("com.docker.stack.namespace=" + project)
+}
+
+// runsUntilCompleted returns true for the job stacks without a status timeout,
+// they are waited for as long as their services keep running
+func runsUntilCompleted(stack *edgeStack) bool {
+	return stack.Job != nil && stack.StatusTimeout <= 0
+}
+
+// reportJobResult reports the exit codes of the services of a job stack once it completed or failed.
+// The caller must hold the manager lock.
+func (manager *StackManager) reportJobResult(stack *edgeStack, stackName string) {
+	if stack.Job == nil {
+		return
+	}
+
+	result := client.StackJobResult{
+		Succeeded: stack.Status == StatusCompleted,
+		Time:      manager.now().Unix(),
+	}
+
+	if manager.exitCodes != nil {
+		exitCodes, err := manager.exitCodes(stackName)
+		if err != nil {
+			log.Warn().Err(err).Int("stack_identifier", stack.ID).Msg("unable to retrieve the exit codes of the job stack")
+		}
+
+		result.ExitCodes = exitCodes
+	}
+
+	for _, exitCode := range result.ExitCodes {
+		if exitCode != 0 {
+			result.Succeeded = false
+		}
+	}
+
+	if err := manager.portainerClient.SetEdgeStackJobResult(stack.ID, result); err != nil {
+		log.Error().Err(err).Int("stack_identifier", stack.ID).Msg("unable to report the Edge stack job result")
+	}
+}
+
+// isExpiredJob returns true when a completed job stack was kept on the host for longer than its TTL.
+// The caller must hold the manager lock.
+func (manager *StackManager) isExpiredJob(stack *edgeStack) bool {
+	if stack.Status != StatusCompleted || stack.Job == nil || stack.Job.TTL <= 0 || stack.JobRemoved {
+		return false
+	}
+
+	n := len(stack.StatusTransitions)
+	if n == 0 || stack.StatusTransitions[n-1].To != StatusCompleted {
+		return false
+	}
+
+	return !manager.now().Before(stack.StatusTransitions[n-1].Time.Add(stack.Job.TTL))
+}
+
+// removeExpiredJob removes the services of a completed job stack once its TTL elapsed.
+// The stack itself is kept so that it is not deployed again until a new version is received.
+func (manager *StackManager) removeExpiredJob(ctx context.Context, stack *edgeStack, stackName string) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	if !manager.isExpiredJob(stack) {
+		return
+	}
+
+	successFileFolder := SuccessStackFileFolder(stack.FileFolder)
+
+	if err := manager.deployerFor(stack).Remove(
+		ctx,
+		stackName,
+		[]string{filepath.Join(successFileFolder, stack.FileName)},
+		agent.RemoveOptions{
+			DeployerBaseOptions: agent.DeployerBaseOptions{
+				Namespace:  stack.Namespace,
+				WorkingDir: successFileFolder,
+				Env:        buildEnvVarsForDeployer(stack.EnvVars),
+			},
+		},
+	); err != nil {
+		log.Error().Err(err).Int("stack_identifier", stack.ID).Msg("unable to remove the completed job stack")
+
+		return
+	}
+
+	stack.JobRemoved = true
+
+	log.Info().Int("stack_identifier", stack.ID).Dur("ttl", stack.Job.TTL).Msg("removed the completed job stack after its TTL")
+}
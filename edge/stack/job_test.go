@@ -0,0 +1,148 @@
+package stack
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/portainer/agent/clock"
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/agent/internals/mocks"
+	portainer "github.com/portainer/portainer/api"
+	"github.com/portainer/portainer/pkg/libstack"
+	"github.com/stretchr/testify/assert"
+	gomock "go.uber.org/mock/gomock"
+)
+
+func TestStackManager_checkStackStatus_job(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDeployer := mocks.NewMockDeployer(ctrl)
+	mockClient := mocks.NewMockPortainerClient(ctrl)
+
+	manager := &StackManager{
+		deployer:        mockDeployer,
+		portainerClient: mockClient,
+		clock:           clock.NewFakeClock(time.Unix(1000, 0)),
+		stacks:          map[edgeStackID]*edgeStack{},
+		exitCodes: func(project string) (map[string]int, error) {
+			assert.Equal(t, "edge_backup", project)
+
+			return map[string]int{"dump": 0, "upload": 0}, nil
+		},
+	}
+
+	stack := &edgeStack{Status: StatusPending, Job: &client.StackJob{}}
+	stack.ID = 1
+	manager.setStatus(stack, StatusDeploying)
+	manager.setStatus(stack, StatusAwaitingDeployedStatus)
+
+	mockDeployer.EXPECT().WaitForStatus(gomock.Any(), "edge_backup", libstack.StatusCompleted).DoAndReturn(
+		func(ctx context.Context, name string, status libstack.Status) <-chan libstack.WaitResult {
+			ch := make(chan libstack.WaitResult, 1)
+			ch <- libstack.WaitResult{Status: libstack.StatusCompleted}
+
+			return ch
+		})
+
+	mockClient.EXPECT().SetEdgeStackJobResult(1, client.StackJobResult{
+		ExitCodes: map[string]int{"dump": 0, "upload": 0},
+		Succeeded: true,
+		Time:      1000,
+	})
+	mockClient.EXPECT().SetEdgeStackStatus(1, portainer.EdgeStackStatusCompleted, nil, "")
+
+	assert.NoError(t, manager.checkStackStatus(context.Background(), "edge_backup", stack))
+	assert.Equal(t, StatusCompleted, stack.Status)
+}
+
+func TestStackManager_checkStackStatus_failedJob(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDeployer := mocks.NewMockDeployer(ctrl)
+	mockClient := mocks.NewMockPortainerClient(ctrl)
+
+	manager := &StackManager{
+		deployer:        mockDeployer,
+		portainerClient: mockClient,
+		clock:           clock.NewFakeClock(time.Unix(1000, 0)),
+		stacks:          map[edgeStackID]*edgeStack{},
+		exitCodes: func(project string) (map[string]int, error) {
+			return map[string]int{"dump": 0, "upload": 2}, nil
+		},
+	}
+
+	stack := &edgeStack{Status: StatusPending, Job: &client.StackJob{}}
+	stack.ID = 1
+	manager.setStatus(stack, StatusDeploying)
+	manager.setStatus(stack, StatusAwaitingDeployedStatus)
+
+	mockDeployer.EXPECT().WaitForStatus(gomock.Any(), "edge_backup", libstack.StatusCompleted).DoAndReturn(
+		func(ctx context.Context, name string, status libstack.Status) <-chan libstack.WaitResult {
+			ch := make(chan libstack.WaitResult, 1)
+			ch <- libstack.WaitResult{Status: status, ErrorMsg: "service upload exited with code 2"}
+
+			return ch
+		})
+
+	mockClient.EXPECT().SetEdgeStackJobResult(1, client.StackJobResult{
+		ExitCodes: map[string]int{"dump": 0, "upload": 2},
+		Time:      1000,
+	})
+	mockClient.EXPECT().SetEdgeStackStatus(1, portainer.EdgeStackStatusError, nil, "service upload exited with code 2")
+
+	assert.NoError(t, manager.checkStackStatus(context.Background(), "edge_backup", stack))
+	assert.Equal(t, StatusError, stack.Status)
+}
+
+func TestStackManager_isExpiredJob(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Unix(1000, 0))
+	manager := &StackManager{clock: fakeClock}
+
+	stack := &edgeStack{Status: StatusAwaitingDeployedStatus, Job: &client.StackJob{TTL: time.Hour}}
+	manager.setStatus(stack, StatusCompleted)
+	assert.False(t, manager.isExpiredJob(stack))
+
+	fakeClock.Advance(time.Hour)
+	assert.True(t, manager.isExpiredJob(stack))
+
+	stack.JobRemoved = true
+	assert.False(t, manager.isExpiredJob(stack))
+
+	// Completed jobs without a TTL are kept forever
+	stack = &edgeStack{Status: StatusAwaitingDeployedStatus, Job: &client.StackJob{}}
+	manager.setStatus(stack, StatusCompleted)
+	fakeClock.Advance(24 * time.Hour)
+	assert.False(t, manager.isExpiredJob(stack))
+}
+
+func TestStackManager_removeExpiredJob(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	fakeClock := clock.NewFakeClock(time.Unix(1000, 0))
+	mockDeployer := mocks.NewMockDeployer(ctrl)
+
+	manager := &StackManager{
+		deployer: mockDeployer,
+		clock:    fakeClock,
+		stacks:   map[edgeStackID]*edgeStack{},
+	}
+
+	stack := &edgeStack{Status: StatusAwaitingDeployedStatus, Job: &client.StackJob{TTL: time.Minute}, FileFolder: "/stacks/1", FileName: "docker-compose.yml"}
+	stack.ID = 1
+	manager.setStatus(stack, StatusCompleted)
+	manager.stacks[1] = stack
+
+	fakeClock.Advance(time.Minute)
+	assert.Equal(t, stack, manager.nextPendingStack())
+
+	mockDeployer.EXPECT().Remove(gomock.Any(), "edge_backup", []string{SuccessStackFileFolder("/stacks/1") + "/docker-compose.yml"}, gomock.Any())
+
+	manager.removeExpiredJob(context.Background(), stack, "edge_backup")
+	assert.True(t, stack.JobRemoved)
+	assert.Equal(t, StatusCompleted, stack.Status)
+	assert.Nil(t, manager.nextPendingStack())
+}
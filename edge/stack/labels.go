@@ -0,0 +1,57 @@
+package stack
+
+import (
+	"strconv"
+
+	"github.com/portainer/agent/edge/yaml"
+	"github.com/portainer/portainer/api/edge"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// StackIDLabel is the label holding the identifier of the Edge stack owning a resource
+	StackIDLabel = "io.portainer.edge.stack.id"
+	// StackVersionLabel is the label holding the version of the Edge stack that created a resource
+	StackVersionLabel = "io.portainer.edge.stack.version"
+	// AgentIDLabel is the label holding the Edge identifier of the agent that created a resource
+	AgentIDLabel = "io.portainer.edge.id"
+)
+
+func (manager *StackManager) ownershipLabels(stack *edgeStack) map[string]string {
+	return map[string]string{
+		StackIDLabel:      strconv.Itoa(stack.ID),
+		StackVersionLabel: strconv.Itoa(stack.Version),
+		AgentIDLabel:      manager.edgeID,
+	}
+}
+
+// addOwnershipLabels labels the resources created by the entry file of the stack, so that the
+// resources left behind by deleted or crashed stacks can be found. Entry files that cannot be
+// parsed are deployed as is, their validation reports the actual error.
+func (manager *StackManager) addOwnershipLabels(stack *edgeStack, stackPayload *edge.StackPayload) {
+	fileContent := entryFileContent(stackPayload)
+	if fileContent == nil {
+		return
+	}
+
+	var labeled string
+	var err error
+
+	switch manager.engineType {
+	case EngineTypeDockerStandalone, EngineTypeDockerSwarm, EngineTypeContainerd:
+		labeled, err = yaml.AddComposeLabels(*fileContent, manager.ownershipLabels(stack))
+	case EngineTypeKubernetes:
+		labeled, err = yaml.AddKubernetesLabels(*fileContent, manager.ownershipLabels(stack))
+	default:
+		return
+	}
+
+	if err != nil {
+		log.Warn().Err(err).Int("stack_identifier", stack.ID).Msg("unable to add the ownership labels to the stack")
+
+		return
+	}
+
+	*fileContent = labeled
+}
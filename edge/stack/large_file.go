@@ -0,0 +1,339 @@
+package stack
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/portainer/agent/crypto"
+	"github.com/portainer/agent/edge/client"
+	agentfs "github.com/portainer/agent/filesystem"
+
+	"github.com/rs/zerolog/log"
+)
+
+// largeFileHeaderTimeout bounds the wait for the server to start sending a large file
+const largeFileHeaderTimeout = time.Minute
+
+// largeFileMinRate is the slowest download rate in bytes per second allowed by the timeout of the large files
+const largeFileMinRate = 64 << 10
+
+func newLargeFileClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = crypto.CreateTLSConfiguration()
+	transport.ResponseHeaderTimeout = largeFileHeaderTimeout
+
+	return &http.Client{Transport: transport}
+}
+
+// SetLargeFileMaxSize sets the maximum size in bytes of each large file of the stacks. A maxSize of 0 disables the
+// size limit, the files are still limited to the size announced by the server.
+func (manager *StackManager) SetLargeFileMaxSize(maxSize int64) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	manager.largeFileMaxSize = maxSize
+}
+
+// largeFileDownload holds the large files of a stack version and where they are written, it is used without the
+// manager lock
+type largeFileDownload struct {
+	stackID  int
+	priority string
+	// folder is the folder of the version, previousFolders the folders of the other persisted versions
+	folder          string
+	previousFolders []string
+	files           []client.StackLargeFile
+	maxSize         int64
+}
+
+// timeout returns the time the download of the files is allowed to take
+func (download largeFileDownload) timeout() time.Duration {
+	var size int64
+	for _, file := range download.files {
+		size += file.Size
+	}
+
+	return largeFileHeaderTimeout*time.Duration(len(download.files)) + time.Duration(size/largeFileMinRate)*time.Second
+}
+
+// downloadLargeFiles writes the large files of the stack into its folder before the stack is deployed. The files are
+// downloaded without the manager lock, the download is cancelled when the manager stops or when it exceeds its
+// timeout. A failed download is retried unless the files are invalid.
+func (manager *StackManager) downloadLargeFiles(stack *edgeStack) error {
+	manager.mu.Lock()
+
+	if stack.LargeFilesWritten || len(stack.LargeFiles) == 0 {
+		manager.mu.Unlock()
+
+		return nil
+	}
+
+	download := largeFileDownload{
+		stackID:  stack.ID,
+		priority: stack.Priority,
+		folder:   resolveStackFileFolder(stack.FileFolder),
+		files:    stack.LargeFiles,
+		maxSize:  manager.largeFileMaxSize,
+	}
+
+	if !IsRelativePathStack(stack) {
+		download.previousFolders = previousVersionFolders(stack.FileFolder, download.folder)
+	}
+
+	stack.DownloadCount++
+	manager.setStatus(stack, StatusDeploying)
+
+	stopSignal := manager.stopSignal
+
+	manager.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), download.timeout())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-stopSignal:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	err := manager.writeLargeFiles(ctx, download)
+	if err == nil {
+		err = agentfs.SyncTree(download.folder)
+	}
+
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	if err == nil {
+		stack.LargeFilesWritten = true
+
+		return nil
+	}
+
+	// The download is resumed once the manager starts again
+	if errors.Is(err, context.Canceled) {
+		manager.setStatus(stack, StatusPending)
+
+		return err
+	}
+
+	if errors.Is(err, ErrIntegrity) {
+		manager.failIntegrityCheck(stack, err)
+
+		return err
+	}
+
+	class := errorClass(err)
+
+	log.Error().Err(err).
+		Int("stack_identifier", stack.ID).
+		Int("DownloadCount", stack.DownloadCount).
+		Str("error_class", class).
+		Msg("large files download failed")
+
+	if class == client.StackErrorTransient && stack.DownloadCount < maxRetries {
+		manager.setStatus(stack, StatusRetry)
+
+		return err
+	}
+
+	manager.setStatusMessage(stack, StatusError, err.Error())
+	runHooks(hookError, stack, err.Error())
+
+	if err := manager.portainerClient.SetEdgeStackError(stack.ID, stack.RollbackTo, fmt.Errorf("failed to download the large files: %w", err).Error(), class); err != nil {
+		log.Error().Err(err).Int("stack_identifier", stack.ID).Msg("unable to update Edge stack status")
+	}
+
+	return err
+}
+
+// previousVersionFolders returns the folders of the persisted versions of the stack other than the current one
+func previousVersionFolders(fileFolder, current string) []string {
+	versionsFolder := VersionsStackFileFolder(fileFolder)
+
+	entries, err := os.ReadDir(versionsFolder)
+	if err != nil {
+		return nil
+	}
+
+	var folders []string
+	for _, entry := range entries {
+		folder := filepath.Join(versionsFolder, entry.Name())
+		if entry.IsDir() && filepath.Clean(folder) != filepath.Clean(current) {
+			folders = append(folders, folder)
+		}
+	}
+
+	return folders
+}
+
+// writeLargeFiles downloads the large files into the folder of the version, streaming them to the disk. A file
+// already written or held by a previous version with the same checksum is linked instead of downloaded again, a file
+// held by the payload cache is copied from it.
+func (manager *StackManager) writeLargeFiles(ctx context.Context, download largeFileDownload) error {
+	for _, file := range download.files {
+		if !filepath.IsLocal(file.Path) {
+			return fmt.Errorf("invalid path %q of the large file", file.Path)
+		}
+
+		if file.Checksum == "" {
+			return fmt.Errorf("the large file %s has no checksum", file.Path)
+		}
+
+		if download.maxSize > 0 && file.Size > download.maxSize {
+			return fmt.Errorf("the large file %s of %d bytes exceeds the maximum size of %d bytes", file.Path, file.Size, download.maxSize)
+		}
+
+		dst := filepath.Join(download.folder, file.Path)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+
+		if reuseLargeFiles(append([]string{download.folder}, download.previousFolders...), file, dst) {
+			continue
+		}
+
+		if manager.restoreCachedLargeFile(ctx, file, dst) {
+			continue
+		}
+
+		if err := manager.downloadLargeFile(ctx, download, file, dst); err != nil {
+			return fmt.Errorf("unable to download the large file %s: %w", file.Path, err)
+		}
+
+		manager.cacheLargeFile(ctx, file, dst)
+	}
+
+	return nil
+}
+
+// reuseLargeFiles links the file of the first folder holding it with the expected checksum to dst
+func reuseLargeFiles(folders []string, file client.StackLargeFile, dst string) bool {
+	for _, folder := range folders {
+		if reuseLargeFile(filepath.Join(folder, file.Path), dst, file.Checksum) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reuseLargeFile links the file of the current version to dst when it has the expected checksum
+func reuseLargeFile(current, dst, checksum string) bool {
+	sum, err := sha256File(current)
+	if err != nil || sum != normalizeChecksum(checksum) {
+		return false
+	}
+
+	// Relative path stacks are written in place
+	if filepath.Clean(current) == filepath.Clean(dst) {
+		return true
+	}
+
+	return os.Link(current, dst) == nil
+}
+
+// downloadLargeFile streams the file to a temporary file next to dst, checking its size and checksum as it is
+// received, then renames it to dst. The download progress is reported along with the pull progress of the stack.
+func (manager *StackManager) downloadLargeFile(ctx context.Context, download largeFileDownload, file client.StackLargeFile, dst string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, file.URL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := manager.largeFileClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	if resp.ContentLength > file.Size {
+		return fmt.Errorf("the file of %d bytes is larger than the announced %d bytes", resp.ContentLength, file.Size)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	progress := &largeFileProgress{manager: manager, download: download, file: file}
+	hash := sha256.New()
+
+	written, err := io.Copy(io.MultiWriter(tmp, hash, progress), io.LimitReader(resp.Body, file.Size+1))
+	if err != nil {
+		return err
+	}
+
+	progress.report()
+
+	if written != file.Size {
+		return fmt.Errorf("received %d bytes instead of the announced %d bytes", written, file.Size)
+	}
+
+	if hex.EncodeToString(hash.Sum(nil)) != normalizeChecksum(file.Checksum) {
+		return fmt.Errorf("%w: %s: checksum mismatch", ErrIntegrity, file.Path)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), dst)
+}
+
+// largeFileProgress reports the download progress of a large file at most every pullProgressInterval
+type largeFileProgress struct {
+	manager    *StackManager
+	download   largeFileDownload
+	file       client.StackLargeFile
+	written    int64
+	lastReport time.Time
+}
+
+func (progress *largeFileProgress) Write(p []byte) (int, error) {
+	progress.written += int64(len(p))
+
+	if progress.manager.now().Sub(progress.lastReport) >= pullProgressInterval {
+		progress.report()
+	}
+
+	return len(p), nil
+}
+
+func (progress *largeFileProgress) report() {
+	progress.lastReport = progress.manager.now()
+
+	percent := 100
+	if progress.file.Size > 0 {
+		percent = int(progress.written * 100 / progress.file.Size)
+	}
+
+	err := progress.manager.portainerClient.SetEdgeStackPullProgress(progress.download.stackID, client.StackPullProgress{
+		File:         progress.file.Path,
+		Percent:      percent,
+		CurrentBytes: progress.written,
+		TotalBytes:   progress.file.Size,
+		Detail:       fmt.Sprintf("downloading %s %d%%", progress.file.Path, percent),
+		Priority:     progress.download.priority,
+		Time:         progress.lastReport.Unix(),
+	})
+	if err != nil {
+		log.Warn().Err(err).Int("stack_identifier", progress.download.stackID).Msg("unable to report the download progress of the stack")
+	}
+}
@@ -0,0 +1,128 @@
+package stack
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/agent/internals/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gomock "go.uber.org/mock/gomock"
+)
+
+func TestStackManager_writeLargeFiles(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	content := "model weights"
+	requests := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		_, _ = w.Write([]byte(content))
+	}))
+	defer server.Close()
+
+	mockClient := mocks.NewMockPortainerClient(ctrl)
+	mockClient.EXPECT().SetEdgeStackPullProgress(1, gomock.Any()).Return(nil).AnyTimes()
+
+	manager := &StackManager{portainerClient: mockClient, largeFileClient: server.Client()}
+
+	stack := &edgeStack{FileFolder: filepath.Join(t.TempDir(), "1"), Status: StatusPending}
+	stack.ID = 1
+
+	file := client.StackLargeFile{
+		Path:     "models/model.bin",
+		URL:      server.URL,
+		Size:     int64(len(content)),
+		Checksum: "sha256:" + sha256String(content),
+	}
+
+	stack.LargeFiles = []client.StackLargeFile{file}
+
+	// The files are persisted without the large files, they are downloaded before the deployment
+	require.NoError(t, manager.persistStackFiles(stack, nil))
+	assert.NoFileExists(t, filepath.Join(stack.FileFolder, file.Path))
+
+	require.NoError(t, manager.downloadLargeFiles(stack))
+	assert.True(t, stack.LargeFilesWritten)
+
+	written, err := os.ReadFile(filepath.Join(stack.FileFolder, file.Path))
+	require.NoError(t, err)
+	assert.Equal(t, content, string(written))
+
+	// The file of the previous version is reused
+	stack.Version = 2
+	stack.LargeFilesWritten = false
+	require.NoError(t, manager.persistStackFiles(stack, nil))
+	require.NoError(t, manager.downloadLargeFiles(stack))
+	assert.FileExists(t, filepath.Join(stack.FileFolder, file.Path))
+	assert.Equal(t, 1, requests)
+
+	download := largeFileDownload{stackID: 1, folder: t.TempDir()}
+
+	mismatch := file
+	mismatch.Path = "other.bin"
+	mismatch.Checksum = sha256String("other")
+	download.files = []client.StackLargeFile{mismatch}
+	assert.ErrorIs(t, manager.writeLargeFiles(context.Background(), download), ErrIntegrity)
+	assert.NoFileExists(t, filepath.Join(download.folder, mismatch.Path))
+
+	truncated := file
+	truncated.Path = "truncated.bin"
+	truncated.Size = 5
+	download.files = []client.StackLargeFile{truncated}
+	assert.ErrorContains(t, manager.writeLargeFiles(context.Background(), download), "larger than the announced")
+
+	download.files = []client.StackLargeFile{file}
+	download.maxSize = 4
+	assert.ErrorContains(t, manager.writeLargeFiles(context.Background(), download), "exceeds the maximum size")
+
+	escaping := file
+	escaping.Path = "../escape.bin"
+	download.files = []client.StackLargeFile{escaping}
+	assert.Error(t, manager.writeLargeFiles(context.Background(), download))
+}
+
+func TestStackManager_downloadLargeFiles_unlocked(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClient := mocks.NewMockPortainerClient(ctrl)
+	mockClient.EXPECT().SetEdgeStackPullProgress(1, gomock.Any()).Return(nil).AnyTimes()
+
+	manager := &StackManager{portainerClient: mockClient, stopSignal: make(chan struct{})}
+
+	// The manager is usable during the download, which is cancelled once the manager stops
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		manager.mu.Lock()
+		close(manager.stopSignal)
+		manager.stopSignal = nil
+		manager.mu.Unlock()
+
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	manager.largeFileClient = server.Client()
+
+	stack := &edgeStack{FileFolder: filepath.Join(t.TempDir(), "1"), Status: StatusPending}
+	stack.ID = 1
+	stack.LargeFiles = []client.StackLargeFile{{
+		Path:     "model.bin",
+		URL:      server.URL,
+		Size:     4,
+		Checksum: sha256String("data"),
+	}}
+
+	require.NoError(t, manager.persistStackFiles(stack, nil))
+
+	assert.ErrorIs(t, manager.downloadLargeFiles(stack), context.Canceled)
+	assert.False(t, stack.LargeFilesWritten)
+	assert.Equal(t, StatusPending, stack.Status)
+}
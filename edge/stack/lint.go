@@ -0,0 +1,103 @@
+package stack
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/agent/edge/yaml"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// LintModeOff disables the linter of the stack files
+	LintModeOff = "off"
+	// LintModeWarn reports the warnings of the linter with the Acknowledged status, the stacks are deployed anyway
+	LintModeWarn = "warn"
+	// LintModeEnforce reports the warnings of the linter with the Acknowledged status and fails the validation of the
+	// stacks flagged by the linter
+	LintModeEnforce = "enforce"
+)
+
+// ErrLintViolation is returned when the linter is enforced and flags the file of a stack
+var ErrLintViolation = errors.New("stack file lint violation")
+
+// SetLintMode sets whether the files of the stacks are linted and whether the warnings fail their deployment
+func (manager *StackManager) SetLintMode(mode string) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	manager.lintMode = mode
+}
+
+// lintStack returns the warnings of the linter for the entry file of the stack, none when the linter is off. The
+// native workloads and the Nomad jobs are not linted, and the files that cannot be parsed are left to the validation.
+// The caller must hold the manager lock.
+func (manager *StackManager) lintStack(stack *edgeStack, stackFileLocation string) []yaml.LintWarning {
+	if manager.lintMode == "" || manager.lintMode == LintModeOff || stack.Format == client.StackFormatSystemd || manager.engineType == EngineTypeNomad {
+		return nil
+	}
+
+	content, err := os.ReadFile(stackFileLocation)
+	if err != nil {
+		log.Debug().Err(err).Int("stack_identifier", stack.ID).Msg("unable to read the stack file to lint")
+
+		return nil
+	}
+
+	lint := yaml.LintCompose
+	if manager.engineType == EngineTypeKubernetes {
+		lint = yaml.LintKubernetes
+	}
+
+	warnings, err := lint(string(content))
+	if err != nil {
+		log.Debug().Err(err).Int("stack_identifier", stack.ID).Msg("unable to lint the stack file")
+
+		return nil
+	}
+
+	return warnings
+}
+
+// lintAnnotation returns the warnings of the linter reported with the Acknowledged status, empty when there are none.
+// The caller must hold the manager lock.
+func (manager *StackManager) lintAnnotation(stack *edgeStack, stackFileLocation string) string {
+	warnings := manager.lintStack(stack, stackFileLocation)
+	if len(warnings) == 0 {
+		return ""
+	}
+
+	log.Warn().
+		Int("stack_identifier", stack.ID).
+		Int("warnings", len(warnings)).
+		Msg("the stack file has lint warnings")
+
+	return "lint warnings: " + joinWarnings(warnings)
+}
+
+// checkLint returns an ErrLintViolation error listing the warnings of the linter when it is enforced.
+// The caller must hold the manager lock.
+func (manager *StackManager) checkLint(stack *edgeStack, stackFileLocation string) error {
+	if manager.lintMode != LintModeEnforce {
+		return nil
+	}
+
+	if warnings := manager.lintStack(stack, stackFileLocation); len(warnings) > 0 {
+		return fmt.Errorf("%w: %s", ErrLintViolation, joinWarnings(warnings))
+	}
+
+	return nil
+}
+
+func joinWarnings(warnings []yaml.LintWarning) string {
+	messages := make([]string, 0, len(warnings))
+	for _, warning := range warnings {
+		messages = append(messages, warning.String())
+	}
+
+	return strings.Join(messages, "; ")
+}
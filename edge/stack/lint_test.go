@@ -0,0 +1,45 @@
+package stack
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/portainer/api/edge"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStackManager_lint(t *testing.T) {
+	stackFileLocation := filepath.Join(t.TempDir(), "docker-compose.yml")
+	require.NoError(t, os.WriteFile(stackFileLocation, []byte(`
+services:
+  web:
+    image: nginx:1.27
+    restart: always
+    mem_limit: 128m
+  cache:
+    image: redis
+    restart: always
+    mem_limit: 128m
+`), 0644))
+
+	stack := &edgeStack{StackPayload: edge.StackPayload{ID: 1}}
+	manager := &StackManager{engineType: EngineTypeDockerStandalone}
+
+	// The linter is off by default
+	assert.Empty(t, manager.lintAnnotation(stack, stackFileLocation))
+	assert.NoError(t, manager.checkLint(stack, stackFileLocation))
+
+	manager.SetLintMode(LintModeWarn)
+	assert.Equal(t, "lint warnings: cache: the image redis is not pinned to a tag or a digest (unpinnedImage)", manager.lintAnnotation(stack, stackFileLocation))
+	assert.NoError(t, manager.checkLint(stack, stackFileLocation))
+
+	manager.SetLintMode(LintModeEnforce)
+	assert.ErrorIs(t, manager.checkLint(stack, stackFileLocation), ErrLintViolation)
+
+	// The native workloads are not linted
+	stack.Format = client.StackFormatSystemd
+	assert.NoError(t, manager.checkLint(stack, stackFileLocation))
+}
@@ -0,0 +1,16 @@
+package stack
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// The invalid status transitions fail the tests, they are only logged in production
+	invalidStatusTransition = func(stack *edgeStack, from, to edgeStackStatus) {
+		panic(fmt.Sprintf("invalid status transition of the stack %d from %s to %s", stack.ID, from, to))
+	}
+
+	os.Exit(m.Run())
+}
@@ -0,0 +1,137 @@
+package stack
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/portainer/agent"
+	"github.com/portainer/agent/edge/client"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// NamingPrefix names the project of a stack after the stack, prefixed with the project prefix
+	NamingPrefix = "prefix"
+	// NamingName names the project of a stack after the stack, without any prefix
+	NamingName = "name"
+)
+
+// DefaultProjectPrefix is the prefix of the projects of the stacks when none is set
+const DefaultProjectPrefix = "edge_"
+
+// SetProjectNaming sets how the projects of the stacks are named, the prefix is only used by NamingPrefix.
+// The stacks already deployed as another project are moved to their new project the next time they are deployed.
+func (manager *StackManager) SetProjectNaming(naming, prefix string) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	manager.projectNaming = naming
+	manager.projectPrefix = prefix
+}
+
+// stackProjectName returns the name of the project a stack is deployed as, the project set by the server for the
+// stack or the project named after the stack otherwise. The caller must hold the manager lock.
+func (manager *StackManager) stackProjectName(stack *edgeStack) string {
+	if stack.ProjectName != "" {
+		return stack.ProjectName
+	}
+
+	if manager.projectNaming == NamingName {
+		return stack.Name
+	}
+
+	prefix := manager.projectPrefix
+	if prefix == "" {
+		prefix = DefaultProjectPrefix
+	}
+
+	return fmt.Sprintf("%s%s", prefix, stack.Name)
+}
+
+// isProjectScoped returns true when the resources of the stack are owned by its project, so that they can be
+// removed by the project name alone. The Kubernetes manifests and the native workloads are not.
+func (manager *StackManager) isProjectScoped(stack *edgeStack) bool {
+	if stack.Format == client.StackFormatSystemd {
+		return false
+	}
+
+	switch manager.engineType {
+	case EngineTypeDockerStandalone, EngineTypeDockerSwarm, EngineTypeContainerd:
+		return true
+	}
+
+	return false
+}
+
+// previousProject returns the project the stack is still deployed as when its project name changed, empty when
+// there is none. The stacks deployed before the agent restarted are found by the working directory of their project.
+// The caller must hold the manager lock.
+func (manager *StackManager) previousProject(stack *edgeStack, project string) string {
+	if !manager.isProjectScoped(stack) {
+		return ""
+	}
+
+	project = normalizeProjectName(project)
+
+	if stack.DeployedProject != "" {
+		if normalizeProjectName(stack.DeployedProject) == project {
+			return ""
+		}
+
+		return stack.DeployedProject
+	}
+
+	if manager.hostProjects == nil {
+		return ""
+	}
+
+	projects, err := manager.hostProjects()
+	if err != nil {
+		log.Warn().Err(err).Msg("unable to list the compose projects of the host")
+
+		return ""
+	}
+
+	names := make([]string, 0, len(projects))
+	for name := range projects {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if name != project && isStackWorkingDir(stack, projects[name]) {
+			return name
+		}
+	}
+
+	return ""
+}
+
+// migrateProject removes the project the stack was previously deployed as, before it is deployed as its new project.
+// The stack is deployed anyway when the previous project cannot be removed, it is removed again on the next
+// deployment. The caller must hold the manager lock.
+func (manager *StackManager) migrateProject(ctx context.Context, stack *edgeStack, project, stackFileLocation string, envVars []string) {
+	previous := manager.previousProject(stack, project)
+	if previous == "" {
+		return
+	}
+
+	log.Info().
+		Int("stack_identifier", stack.ID).
+		Str("previous_project", previous).
+		Str("project", project).
+		Msg("moving the stack to its new project")
+
+	if err := manager.deployerFor(stack).Remove(ctx, previous, []string{stackFileLocation}, agent.RemoveOptions{
+		DeployerBaseOptions: agent.DeployerBaseOptions{
+			Namespace:  stack.Namespace,
+			WorkingDir: stack.FileFolder,
+			Env:        envVars,
+		},
+	}); err != nil {
+		log.Warn().Err(err).Int("stack_identifier", stack.ID).Str("previous_project", previous).Msg("unable to remove the previous project of the stack")
+	}
+}
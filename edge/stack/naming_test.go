@@ -0,0 +1,81 @@
+package stack
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/portainer/agent"
+	"github.com/portainer/agent/internals/mocks"
+	"github.com/portainer/portainer/api/edge"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestStackManager_stackProjectName(t *testing.T) {
+	stack := &edgeStack{StackPayload: edge.StackPayload{Name: "web"}}
+
+	manager := &StackManager{}
+	assert.Equal(t, "edge_web", manager.stackProjectName(stack))
+
+	manager.SetProjectNaming(NamingPrefix, "acme-")
+	assert.Equal(t, "acme-web", manager.stackProjectName(stack))
+
+	manager.SetProjectNaming(NamingName, "acme-")
+	assert.Equal(t, "web", manager.stackProjectName(stack))
+
+	// The project set by the server overrides the naming strategy
+	stack.ProjectName = "legacy"
+	assert.Equal(t, "legacy", manager.stackProjectName(stack))
+}
+
+func TestStackManager_previousProject(t *testing.T) {
+	folder := t.TempDir()
+
+	manager := &StackManager{engineType: EngineTypeDockerStandalone}
+
+	stack := &edgeStack{StackPayload: edge.StackPayload{ID: 1, Name: "web"}, FileFolder: filepath.Join(folder, "1")}
+	assert.Empty(t, manager.previousProject(stack, "web"))
+
+	// The projects deployed before the agent restarted are found by their working directory
+	manager.hostProjects = func() (map[string]string, error) {
+		return map[string]string{
+			"edge_web": SuccessStackFileFolder(stack.FileFolder),
+			"edge_db":  filepath.Join(folder, "2"),
+		}, nil
+	}
+
+	assert.Equal(t, "edge_web", manager.previousProject(stack, "web"))
+	assert.Empty(t, manager.previousProject(stack, "edge_web"))
+
+	stack.DeployedProject = "Web"
+	assert.Empty(t, manager.previousProject(stack, "web"))
+
+	stack.DeployedProject = "edge_web"
+	assert.Equal(t, "edge_web", manager.previousProject(stack, "web"))
+
+	// The Kubernetes manifests are not removed by their project name
+	manager.engineType = EngineTypeKubernetes
+	assert.Empty(t, manager.previousProject(stack, "web"))
+}
+
+func TestStackManager_migrateProject(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	deployer := mocks.NewMockDeployer(ctrl)
+
+	manager := &StackManager{engineType: EngineTypeDockerStandalone, deployer: deployer}
+	stack := &edgeStack{StackPayload: edge.StackPayload{ID: 1, Name: "web"}, FileFolder: "/data/1", DeployedProject: "edge_web"}
+
+	deployer.EXPECT().Remove(gomock.Any(), "edge_web", []string{"/data/1/docker-compose.yml"}, gomock.Any()).
+		DoAndReturn(func(_ context.Context, _ string, _ []string, options agent.RemoveOptions) error {
+			assert.Equal(t, "/data/1", options.WorkingDir)
+
+			return nil
+		})
+
+	manager.migrateProject(context.Background(), stack, "web", "/data/1/docker-compose.yml", nil)
+
+	// Nothing is removed once the stack is deployed as its new project
+	stack.DeployedProject = "web"
+	manager.migrateProject(context.Background(), stack, "web", "/data/1/docker-compose.yml", nil)
+}
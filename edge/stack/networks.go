@@ -0,0 +1,235 @@
+package stack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
+
+	"github.com/portainer/agent/docker"
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/agent/edge/yaml"
+
+	"github.com/rs/zerolog/log"
+)
+
+// maxInterfaceName is the maximum length of the name of a Linux network interface, IFNAMSIZ without the terminating
+// null byte, the VLAN sub-interface created by the engine must fit in it
+const maxInterfaceName = 15
+
+// networkModes are the modes supported by each driver of the networks of the host interfaces
+var networkModes = map[string][]string{
+	client.StackNetworkMacvlan: {"bridge", "private", "vepa", "passthru"},
+	client.StackNetworkIpvlan:  {"l2", "l3", "l3s"},
+}
+
+// checkStackNetworks returns an ErrStackUnschedulable error when the networks of the host interfaces of the stack
+// are invalid or not supported by the engine of the device
+func (manager *StackManager) checkStackNetworks(networks []client.StackNetwork, format string) error {
+	if len(networks) == 0 {
+		return nil
+	}
+
+	if manager.hostNetworks == nil || format != "" {
+		return fmt.Errorf("%w: the networks of the host interfaces are only supported by the compose stacks of the Docker standalone engine", ErrStackUnschedulable)
+	}
+
+	names := make(map[string]struct{}, len(networks))
+	for _, network := range networks {
+		if _, ok := names[network.Name]; ok {
+			return fmt.Errorf("%w: duplicate network %q", ErrStackUnschedulable, network.Name)
+		}
+
+		names[network.Name] = struct{}{}
+
+		if err := validateStackNetwork(network); err != nil {
+			return fmt.Errorf("%w: invalid network %q: %w", ErrStackUnschedulable, network.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func validateStackNetwork(network client.StackNetwork) error {
+	if network.Name == "" {
+		return errors.New("missing name")
+	}
+
+	modes, ok := networkModes[networkDriver(network)]
+	if !ok {
+		return fmt.Errorf("unsupported driver %q", network.Driver)
+	}
+
+	if network.Mode != "" && !slices.Contains(modes, network.Mode) {
+		return fmt.Errorf("unsupported %s mode %q", networkDriver(network), network.Mode)
+	}
+
+	if network.Parent == "" {
+		return errors.New("missing parent interface")
+	}
+
+	if network.VLAN < 0 || network.VLAN > 4094 {
+		return fmt.Errorf("invalid VLAN %d", network.VLAN)
+	}
+
+	if parent := networkParent(network); len(parent) > maxInterfaceName {
+		return fmt.Errorf("the name of the interface %s is longer than %d characters", parent, maxInterfaceName)
+	}
+
+	var subnet netip.Prefix
+	if network.Subnet != "" {
+		var err error
+		if subnet, err = netip.ParsePrefix(network.Subnet); err != nil {
+			return fmt.Errorf("invalid subnet: %w", err)
+		}
+	} else if network.Gateway != "" || network.IPRange != "" || len(network.AuxAddresses) > 0 || len(network.IPv4Addresses) > 0 {
+		return errors.New("the subnet is required to set the gateway, the IP range or the addresses of the network")
+	}
+
+	if network.IPRange != "" {
+		ipRange, err := netip.ParsePrefix(network.IPRange)
+		if err != nil || !subnet.Contains(ipRange.Addr()) || ipRange.Bits() < subnet.Bits() {
+			return fmt.Errorf("the IP range %s is not within the subnet %s", network.IPRange, network.Subnet)
+		}
+	}
+
+	addresses := map[string]string{"gateway": network.Gateway}
+	for name, address := range network.AuxAddresses {
+		addresses[name] = address
+	}
+
+	for service, address := range network.IPv4Addresses {
+		addresses["service "+service] = address
+	}
+
+	for name, address := range addresses {
+		if address == "" {
+			continue
+		}
+
+		addr, err := netip.ParseAddr(address)
+		if err != nil || !subnet.Contains(addr) {
+			return fmt.Errorf("the address %s of the %s is not within the subnet %s", address, name, network.Subnet)
+		}
+	}
+
+	for service := range network.IPv4Addresses {
+		if len(network.Services) > 0 && !slices.Contains(network.Services, service) {
+			return fmt.Errorf("the service %s with an address is not attached to the network", service)
+		}
+	}
+
+	return nil
+}
+
+// attachStackNetworks attaches the services of the entry file of the stack to the networks of the host interfaces
+func attachStackNetworks(stackPayload *client.StackPayload) error {
+	if len(stackPayload.Networks) == 0 {
+		return nil
+	}
+
+	fileContent := entryFileContent(&stackPayload.StackPayload)
+	if fileContent == nil {
+		return errors.New("the entry file of the stack is missing, its services cannot be attached to the networks of the host interfaces")
+	}
+
+	attachments := make([]yaml.ComposeNetworkAttachment, 0, len(stackPayload.Networks))
+	for _, network := range stackPayload.Networks {
+		attachments = append(attachments, yaml.ComposeNetworkAttachment{
+			Network:       network.Name,
+			Services:      network.Services,
+			IPv4Addresses: network.IPv4Addresses,
+		})
+	}
+
+	attached, err := yaml.AttachComposeNetworks(*fileContent, attachments)
+	if err != nil {
+		return fmt.Errorf("unable to attach the services to the networks of the host interfaces: %w", err)
+	}
+
+	*fileContent = attached
+
+	return nil
+}
+
+// createStackNetworks creates the networks of the host interfaces of the stack before it is deployed, the networks
+// already created for another stack on the same interfaces are used as they are
+func (manager *StackManager) createStackNetworks(ctx context.Context, stack *edgeStack) error {
+	if len(stack.HostNetworks) == 0 || manager.hostNetworks == nil {
+		return nil
+	}
+
+	for _, network := range stack.HostNetworks {
+		err := manager.hostNetworks(ctx, docker.HostNetwork{
+			Name:         network.Name,
+			Driver:       networkDriver(network),
+			Parent:       networkParent(network),
+			Mode:         network.Mode,
+			Subnet:       network.Subnet,
+			Gateway:      network.Gateway,
+			IPRange:      network.IPRange,
+			AuxAddresses: network.AuxAddresses,
+			Labels:       manager.ownershipLabels(stack),
+		})
+		if err != nil {
+			return fmt.Errorf("unable to create the network %s: %w", network.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// removeStackNetworks removes the networks of the host interfaces of a removed stack that no other stack uses. A
+// failure is logged, e.g. when containers deployed outside of the stacks are still attached to the network.
+func (manager *StackManager) removeStackNetworks(ctx context.Context, stack *edgeStack) {
+	if manager.removeHostNetwork == nil {
+		return
+	}
+
+	for _, network := range stack.HostNetworks {
+		if manager.sharedStackNetwork(stack, network.Name) {
+			continue
+		}
+
+		if err := manager.removeHostNetwork(ctx, network.Name); err != nil {
+			log.Warn().Err(err).Int("stack_identifier", stack.ID).Str("network", network.Name).Msg("unable to remove the network of the stack")
+		}
+	}
+}
+
+// sharedStackNetwork returns true when another stack uses the network of the host interfaces
+func (manager *StackManager) sharedStackNetwork(stack *edgeStack, name string) bool {
+	for _, other := range manager.stacks {
+		if other.ID == stack.ID {
+			continue
+		}
+
+		for _, network := range other.HostNetworks {
+			if network.Name == name {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func networkDriver(network client.StackNetwork) string {
+	if network.Driver == "" {
+		return client.StackNetworkMacvlan
+	}
+
+	return network.Driver
+}
+
+// networkParent returns the parent interface of the network, the VLAN sub-interface created by the engine when the
+// VLAN is set
+func networkParent(network client.StackNetwork) string {
+	if network.VLAN == 0 {
+		return network.Parent
+	}
+
+	return network.Parent + "." + strconv.Itoa(network.VLAN)
+}
@@ -0,0 +1,185 @@
+package stack
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/portainer/agent"
+	"github.com/portainer/agent/docker"
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/portainer/api/edge"
+	"github.com/portainer/portainer/api/filesystem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStackManager_checkStackNetworks(t *testing.T) {
+	manager := NewStackManager(nil, "", nil, "")
+
+	network := client.StackNetwork{
+		Name:          "ot_vlan100",
+		Parent:        "eth1",
+		VLAN:          100,
+		Subnet:        "192.168.100.0/24",
+		Gateway:       "192.168.100.1",
+		IPRange:       "192.168.100.128/25",
+		AuxAddresses:  map[string]string{"plc": "192.168.100.2"},
+		IPv4Addresses: map[string]string{"collector": "192.168.100.10"},
+	}
+
+	// The engine does not support the networks of the host interfaces
+	assert.ErrorIs(t, manager.checkStackNetworks([]client.StackNetwork{network}, ""), ErrStackUnschedulable)
+
+	manager.hostNetworks = func(ctx context.Context, network docker.HostNetwork) error { return nil }
+	require.NoError(t, manager.checkStackNetworks([]client.StackNetwork{network}, ""))
+
+	assert.ErrorIs(t, manager.checkStackNetworks([]client.StackNetwork{network}, client.StackFormatSystemd), ErrStackUnschedulable)
+	assert.ErrorIs(t, manager.checkStackNetworks([]client.StackNetwork{network, network}, ""), ErrStackUnschedulable)
+
+	for _, invalid := range []func(n *client.StackNetwork){
+		func(n *client.StackNetwork) { n.Driver = "bridge" },
+		func(n *client.StackNetwork) { n.Mode = "l3" },
+		func(n *client.StackNetwork) { n.Parent = "" },
+		func(n *client.StackNetwork) { n.VLAN = 4095 },
+		func(n *client.StackNetwork) { n.Parent = "enx00e04c680001" },
+		func(n *client.StackNetwork) { n.Subnet = "" },
+		func(n *client.StackNetwork) { n.IPRange = "10.0.0.0/25" },
+		func(n *client.StackNetwork) { n.IPv4Addresses = map[string]string{"collector": "10.0.0.1"} },
+		func(n *client.StackNetwork) { n.Services = []string{"dashboard"} },
+	} {
+		invalidNetwork := network
+		invalid(&invalidNetwork)
+
+		assert.ErrorIs(t, manager.checkStackNetworks([]client.StackNetwork{invalidNetwork}, ""), ErrStackUnschedulable)
+	}
+}
+
+func TestStackManager_createStackNetworks(t *testing.T) {
+	manager := NewStackManager(nil, "", nil, "device-1")
+
+	var created []docker.HostNetwork
+	manager.hostNetworks = func(ctx context.Context, network docker.HostNetwork) error {
+		created = append(created, network)
+
+		return nil
+	}
+
+	stack := &edgeStack{
+		StackPayload: edge.StackPayload{ID: 1, Version: 2},
+		HostNetworks: []client.StackNetwork{
+			{Name: "ot_vlan100", Parent: "eth1", VLAN: 100, Mode: "bridge", Subnet: "192.168.100.0/24"},
+			{Name: "it", Driver: client.StackNetworkIpvlan, Parent: "eth0"},
+		},
+	}
+
+	require.NoError(t, manager.createStackNetworks(context.Background(), stack))
+	require.Len(t, created, 2)
+
+	assert.Equal(t, "macvlan", created[0].Driver)
+	assert.Equal(t, "eth1.100", created[0].Parent)
+	assert.Equal(t, "bridge", created[0].Mode)
+	assert.Equal(t, "192.168.100.0/24", created[0].Subnet)
+	assert.Equal(t, "1", created[0].Labels[StackIDLabel])
+
+	assert.Equal(t, "ipvlan", created[1].Driver)
+	assert.Equal(t, "eth0", created[1].Parent)
+}
+
+func TestAttachStackNetworks(t *testing.T) {
+	stackPayload := &client.StackPayload{
+		StackPayload: edge.StackPayload{
+			EntryFileName: "docker-compose.yml",
+			DirEntries: []filesystem.DirEntry{
+				{Name: "docker-compose.yml", IsFile: true, Content: "services:\n  collector:\n    image: collector\n"},
+			},
+		},
+		Networks: []client.StackNetwork{{Name: "ot_vlan100", Parent: "eth1", IPv4Addresses: map[string]string{"collector": "192.168.100.10"}}},
+	}
+
+	require.NoError(t, attachStackNetworks(stackPayload))
+
+	content := stackPayload.DirEntries[0].Content
+	assert.Contains(t, content, "ipv4_address: 192.168.100.10")
+	assert.Contains(t, content, "external: true")
+}
+
+func TestStackManager_DeployStack_networks(t *testing.T) {
+	filesPath := agent.EdgeStackFilesPath
+	agent.EdgeStackFilesPath = t.TempDir()
+	defer func() { agent.EdgeStackFilesPath = filesPath }()
+
+	manager := NewStackManager(nil, "", nil, "device-1")
+	manager.engineType = EngineTypeDockerStandalone
+	manager.hostNetworks = func(ctx context.Context, network docker.HostNetwork) error { return nil }
+
+	compose := "services:\n  collector:\n    image: collector\n"
+	network := client.StackNetwork{Name: "ot_vlan100", Parent: "eth1", VLAN: 100, Subnet: "192.168.100.0/24", IPv4Addresses: map[string]string{"collector": "192.168.100.10"}}
+
+	stackPayload := client.StackPayload{
+		StackPayload: edge.StackPayload{
+			ID:            5,
+			Name:          "collector",
+			Version:       1,
+			EntryFileName: "docker-compose.yml",
+			DirEntries:    []filesystem.DirEntry{{Name: "docker-compose.yml", Content: base64.StdEncoding.EncodeToString([]byte(compose)), IsFile: true}},
+		},
+		Networks: []client.StackNetwork{network},
+	}
+
+	require.NoError(t, manager.DeployStack(context.Background(), stackPayload))
+
+	stack := manager.stacks[5]
+	require.NotNil(t, stack)
+	assert.Equal(t, []client.StackNetwork{network}, stack.HostNetworks)
+
+	content, err := os.ReadFile(filepath.Join(stack.FileFolder, "docker-compose.yml"))
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "ipv4_address: 192.168.100.10")
+	assert.Contains(t, string(content), "external: true")
+
+	// The removal keeps the networks of the deployed version so that they are removed along with the stack
+	require.NoError(t, manager.DeleteStack(context.Background(), client.StackPayload{
+		StackPayload: edge.StackPayload{
+			ID:            5,
+			Name:          "collector",
+			Version:       1,
+			EntryFileName: "docker-compose.yml",
+			DirEntries:    []filesystem.DirEntry{{Name: "docker-compose.yml", Content: base64.StdEncoding.EncodeToString([]byte(compose)), IsFile: true}},
+		},
+	}))
+	assert.Equal(t, []client.StackNetwork{network}, manager.stacks[5].HostNetworks)
+
+	// The invalid networks make the stack unschedulable
+	stackPayload.ID = 6
+	stackPayload.Name = "invalid"
+	stackPayload.DirEntries = []filesystem.DirEntry{{Name: "docker-compose.yml", Content: base64.StdEncoding.EncodeToString([]byte(compose)), IsFile: true}}
+	stackPayload.Networks = []client.StackNetwork{{Name: "ot_vlan100", Parent: "eth1", VLAN: 4095}}
+
+	assert.ErrorIs(t, manager.DeployStack(context.Background(), stackPayload), ErrStackUnschedulable)
+	assert.Equal(t, StatusError, manager.stacks[6].Status)
+}
+
+func TestStackManager_removeStackNetworks(t *testing.T) {
+	manager := NewStackManager(nil, "", nil, "device-1")
+
+	var removed []string
+	manager.removeHostNetwork = func(ctx context.Context, name string) error {
+		removed = append(removed, name)
+
+		return nil
+	}
+
+	stack := &edgeStack{
+		StackPayload: edge.StackPayload{ID: 1},
+		HostNetworks: []client.StackNetwork{{Name: "ot_vlan100"}, {Name: "it"}},
+	}
+	manager.stacks[1] = stack
+	manager.stacks[2] = &edgeStack{StackPayload: edge.StackPayload{ID: 2}, HostNetworks: []client.StackNetwork{{Name: "it"}}}
+
+	// The network shared with another stack is kept
+	manager.removeStackNetworks(context.Background(), stack)
+	assert.Equal(t, []string{"ot_vlan100"}, removed)
+}
@@ -0,0 +1,220 @@
+package stack
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/portainer/agent/edge/blobstore"
+	"github.com/portainer/agent/edge/client"
+
+	"github.com/rs/zerolog/log"
+)
+
+// sha256Hex matches the normalized SHA-256 checksums the large files are cached by
+var sha256Hex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// cachedPayload is a stack payload kept in the payload cache. The registry credentials are not cached, they are
+// restored from the credential store.
+type cachedPayload struct {
+	Payload *client.StackPayload
+	// RegistryCredentials is true when the payload came with registry credentials
+	RegistryCredentials bool
+}
+
+// SetPayloadCache sets the store keeping the payloads and the large files of the stack versions downloaded, so that
+// a version deployed again, after an error or a rollback, is not downloaded again. A nil store disables the cache.
+func (manager *StackManager) SetPayloadCache(store blobstore.Store) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	manager.payloadCache = store
+}
+
+// payloadCacheKey returns the key of the payload of a stack version, the hash tells the payloads of a version
+// recreated by the server apart
+func payloadCacheKey(stackID, version int, hash string) string {
+	return fmt.Sprintf("payloads/%d/%d-%s.json.gz", stackID, version, sha256String(hash)[:16])
+}
+
+func largeFileCacheKey(checksum string) string {
+	return "files/sha256/" + normalizeChecksum(checksum)
+}
+
+// getStackPayload returns the payload of the stack version, from the payload cache when it holds it, otherwise from
+// the server, caching it. The versions without a hash are not cached.
+// The caller must hold the manager lock.
+func (manager *StackManager) getStackPayload(stackID int, stackStatus client.StackStatus) (*client.StackPayload, error) {
+	if manager.payloadCache == nil || stackStatus.Hash == "" {
+		return manager.portainerClient.GetEdgeStackConfig(stackID, &stackStatus.Version)
+	}
+
+	key := payloadCacheKey(stackID, stackStatus.Version, stackStatus.Hash)
+
+	stackPayload, err := manager.readCachedPayload(stackID, key)
+	if err == nil {
+		log.Debug().Int("stack_identifier", stackID).Int("version", stackStatus.Version).Msg("stack payload read from the cache")
+
+		return stackPayload, nil
+	} else if !errors.Is(err, blobstore.ErrNotFound) {
+		log.Warn().Err(err).Int("stack_identifier", stackID).Msg("unable to read the stack payload from the cache")
+	}
+
+	stackPayload, err = manager.portainerClient.GetEdgeStackConfig(stackID, &stackStatus.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	// The payload is cached before it is processed, the processing alters it
+	if err := manager.writeCachedPayload(key, stackPayload); err != nil {
+		log.Warn().Err(err).Int("stack_identifier", stackID).Msg("unable to cache the stack payload")
+	}
+
+	return stackPayload, nil
+}
+
+func (manager *StackManager) readCachedPayload(stackID int, key string) (*client.StackPayload, error) {
+	content, err := manager.payloadCache.Get(context.TODO(), key)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	gz, err := gzip.NewReader(content)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	var cached cachedPayload
+	if err := json.NewDecoder(gz).Decode(&cached); err != nil {
+		return nil, err
+	}
+
+	if cached.Payload == nil {
+		return nil, errors.New("empty cached stack payload")
+	}
+
+	if cached.RegistryCredentials {
+		credentials, err := manager.credentialStore.Get(stackID)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(credentials) == 0 {
+			// The credentials were lost with a restart of the agent, the payload is downloaded again
+			return nil, fmt.Errorf("%w: the registry credentials of the stack are not in the credential store", blobstore.ErrNotFound)
+		}
+
+		cached.Payload.RegistryCredentials = credentials
+	}
+
+	return cached.Payload, nil
+}
+
+func (manager *StackManager) writeCachedPayload(key string, stackPayload *client.StackPayload) error {
+	stripped := *stackPayload
+	stripped.RegistryCredentials = nil
+
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+
+	err := json.NewEncoder(gz).Encode(cachedPayload{
+		Payload:             &stripped,
+		RegistryCredentials: len(stackPayload.RegistryCredentials) > 0,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	return manager.payloadCache.Put(context.TODO(), key, buf, int64(buf.Len()))
+}
+
+// restoreCachedLargeFile writes the large file to dst from the payload cache, it returns false when the cache does not
+// hold a file with the expected checksum
+func (manager *StackManager) restoreCachedLargeFile(ctx context.Context, file client.StackLargeFile, dst string) bool {
+	checksum := normalizeChecksum(file.Checksum)
+	if manager.payloadCache == nil || !sha256Hex.MatchString(checksum) {
+		return false
+	}
+
+	content, err := manager.payloadCache.Get(ctx, largeFileCacheKey(checksum))
+	if err != nil {
+		if !errors.Is(err, blobstore.ErrNotFound) {
+			log.Warn().Err(err).Str("file", file.Path).Msg("unable to read the large file from the cache")
+		}
+
+		return false
+	}
+	defer content.Close()
+
+	if err := writeVerifiedFile(content, dst, file.Size, checksum); err != nil {
+		log.Warn().Err(err).Str("file", file.Path).Msg("unable to restore the large file from the cache")
+
+		return false
+	}
+
+	log.Debug().Str("file", file.Path).Msg("large file restored from the cache")
+
+	return true
+}
+
+// cacheLargeFile keeps the large file downloaded to path in the payload cache
+func (manager *StackManager) cacheLargeFile(ctx context.Context, file client.StackLargeFile, path string) {
+	checksum := normalizeChecksum(file.Checksum)
+	if manager.payloadCache == nil || !sha256Hex.MatchString(checksum) {
+		return
+	}
+
+	content, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer content.Close()
+
+	err = manager.payloadCache.Put(ctx, largeFileCacheKey(checksum), content, file.Size)
+	if err != nil && !errors.Is(err, blobstore.ErrTooLarge) {
+		log.Warn().Err(err).Str("file", file.Path).Msg("unable to cache the large file")
+	}
+}
+
+// writeVerifiedFile writes the content to dst through a temporary file, failing when it does not have the expected
+// size and checksum
+func writeVerifiedFile(content io.Reader, dst string, size int64, checksum string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+
+	written, err := io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(content, size+1))
+	if err != nil {
+		return err
+	}
+
+	if written != size || hex.EncodeToString(hash.Sum(nil)) != checksum {
+		return fmt.Errorf("%w: the cached file does not match its checksum", ErrIntegrity)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), dst)
+}
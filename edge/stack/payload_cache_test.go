@@ -0,0 +1,114 @@
+package stack
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/portainer/agent/edge/blobstore"
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/agent/edge/credstore"
+	"github.com/portainer/agent/internals/mocks"
+	"github.com/portainer/portainer/api/edge"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gomock "go.uber.org/mock/gomock"
+)
+
+func TestStackManager_getStackPayload(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cache, err := blobstore.NewFileStore(t.TempDir(), 0)
+	require.NoError(t, err)
+
+	credentials := []edge.RegistryCredentials{{ServerURL: "registry.example.com", Username: "user", Secret: "s3cret"}}
+
+	payload := &client.StackPayload{StackPayload: edge.StackPayload{
+		ID:                  1,
+		Name:                "stack",
+		Version:             2,
+		EntryFileName:       "docker-compose.yml",
+		RegistryCredentials: credentials,
+	}}
+
+	mockClient := mocks.NewMockPortainerClient(ctrl)
+	mockClient.EXPECT().GetEdgeStackConfig(1, gomock.Any()).DoAndReturn(func(int, *int) (*client.StackPayload, error) {
+		downloaded := *payload
+
+		return &downloaded, nil
+	}).Times(2)
+
+	manager := &StackManager{portainerClient: mockClient, credentialStore: credstore.NewMemoryStore()}
+	manager.SetPayloadCache(cache)
+
+	status := client.StackStatus{ID: 1, Version: 2, Hash: "hash"}
+
+	got, err := manager.getStackPayload(1, status)
+	require.NoError(t, err)
+	assert.Equal(t, payload, got)
+
+	// The registry credentials are not cached
+	content, err := cache.Get(context.Background(), payloadCacheKey(1, 2, "hash"))
+	require.NoError(t, err)
+	defer content.Close()
+
+	gz, err := gzip.NewReader(content)
+	require.NoError(t, err)
+
+	cached, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.NotContains(t, string(cached), "s3cret")
+
+	// The version is downloaded again when its registry credentials are lost
+	_, err = manager.getStackPayload(1, status)
+	require.NoError(t, err)
+
+	require.NoError(t, manager.credentialStore.Save(1, credentials))
+
+	got, err = manager.getStackPayload(1, status)
+	require.NoError(t, err)
+	assert.Equal(t, payload, got)
+
+	// The versions without hash are not cached
+	manager.payloadCache = nil
+	mockClient.EXPECT().GetEdgeStackConfig(1, gomock.Any()).Return(payload, nil)
+
+	_, err = manager.getStackPayload(1, client.StackStatus{ID: 1, Version: 2})
+	require.NoError(t, err)
+}
+
+func TestStackManager_cachedLargeFile(t *testing.T) {
+	cache, err := blobstore.NewFileStore(t.TempDir(), 0)
+	require.NoError(t, err)
+
+	manager := &StackManager{payloadCache: cache}
+
+	content := "model weights"
+	file := client.StackLargeFile{Path: "model.bin", Size: int64(len(content)), Checksum: "sha256:" + sha256String(content)}
+
+	folder := t.TempDir()
+	downloaded := filepath.Join(folder, "downloaded.bin")
+	require.NoError(t, os.WriteFile(downloaded, []byte(content), 0644))
+
+	manager.cacheLargeFile(context.Background(), file, downloaded)
+
+	restored := filepath.Join(folder, file.Path)
+	require.True(t, manager.restoreCachedLargeFile(context.Background(), file, restored))
+
+	written, err := os.ReadFile(restored)
+	require.NoError(t, err)
+	assert.Equal(t, content, string(written))
+
+	// A cached file not matching its checksum is not restored
+	other := file
+	other.Checksum = sha256String("other")
+	require.NoError(t, os.WriteFile(downloaded, []byte("corrupted"), 0644))
+	manager.cacheLargeFile(context.Background(), other, downloaded)
+
+	assert.False(t, manager.restoreCachedLargeFile(context.Background(), other, filepath.Join(folder, "other.bin")))
+	assert.NoFileExists(t, filepath.Join(folder, "other.bin"))
+}
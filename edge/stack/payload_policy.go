@@ -0,0 +1,72 @@
+package stack
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/portainer/agent/edge/opa"
+	"github.com/portainer/agent/edge/yaml"
+)
+
+// SetPayloadPolicy evaluates the stacks against the OPA policies of the device, the stacks they deny are not deployed
+func (manager *StackManager) SetPayloadPolicy(evaluator *opa.Evaluator) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	manager.payloadPolicy = evaluator
+}
+
+// stackPolicyInput is the payload of the input documents of the stacks, their registry credentials are left out
+type stackPolicyInput struct {
+	ID            int               `json:"id"`
+	Name          string            `json:"name"`
+	Version       int               `json:"version"`
+	Namespace     string            `json:"namespace"`
+	Format        string            `json:"format"`
+	EntryFileName string            `json:"entryFileName"`
+	EnvVars       map[string]string `json:"envVars"`
+	Registries    []string          `json:"registries"`
+	// Documents are the documents of the entry file, e.g. the compose project or the Kubernetes resources
+	Documents []any `json:"documents"`
+}
+
+// checkPayloadPolicy returns an opa.ErrDenied error holding the deny reasons of the OPA policies for the stack.
+// The caller must hold the manager lock.
+func (manager *StackManager) checkPayloadPolicy(ctx context.Context, stack *edgeStack, stackFileLocation string) error {
+	if manager.payloadPolicy == nil {
+		return nil
+	}
+
+	content, err := os.ReadFile(stackFileLocation)
+	if err != nil {
+		return err
+	}
+
+	documents, err := yaml.Documents(string(content))
+	if err != nil {
+		return fmt.Errorf("%w: unable to parse the stack file: %w", opa.ErrDenied, err)
+	}
+
+	input := stackPolicyInput{
+		ID:            stack.ID,
+		Name:          stack.Name,
+		Version:       stack.Version,
+		Namespace:     stack.Namespace,
+		Format:        stack.Format,
+		EntryFileName: stack.EntryFileName,
+		EnvVars:       make(map[string]string, len(stack.EnvVars)),
+		Registries:    []string{},
+		Documents:     documents,
+	}
+
+	for _, pair := range stack.EnvVars {
+		input.EnvVars[pair.Name] = pair.Value
+	}
+
+	for _, credentials := range manager.stackRegistryCredentials(stack.ID) {
+		input.Registries = append(input.Registries, credentials.ServerURL)
+	}
+
+	return manager.payloadPolicy.Evaluate(ctx, opa.KindStack, input)
+}
@@ -0,0 +1,77 @@
+package stack
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/portainer/agent/edge/credstore"
+	"github.com/portainer/agent/edge/opa"
+	portainer "github.com/portainer/portainer/api"
+	"github.com/portainer/portainer/api/edge"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStackManager_checkPayloadPolicy(t *testing.T) {
+	dir := t.TempDir()
+
+	// The fake opa binary keeps the input document and denies the stacks using host networking
+	binary := filepath.Join(dir, "opa")
+	require.NoError(t, os.WriteFile(binary, []byte(`#!/bin/sh
+tee "$(dirname "$0")/input.json" | grep -q '"network_mode":"host"' &&
+  echo '{"result": [{"expressions": [{"value": ["host networking is forbidden"]}]}]}' ||
+  echo '{}'
+`), 0755))
+
+	stackFile := filepath.Join(dir, "docker-compose.yml")
+	require.NoError(t, os.WriteFile(stackFile, []byte("services:\n  web:\n    image: nginx\n"), 0600))
+
+	credentialStore := credstore.NewMemoryStore()
+	require.NoError(t, credentialStore.Save(1, []edge.RegistryCredentials{{ServerURL: "registry.example.com", Username: "deploy", Secret: "s3cret"}}))
+
+	manager := &StackManager{credentialStore: credentialStore, stacks: map[edgeStackID]*edgeStack{}}
+
+	stack := &edgeStack{StackPayload: edge.StackPayload{
+		ID:            1,
+		Name:          "web",
+		Version:       3,
+		EntryFileName: "docker-compose.yml",
+		EnvVars:       []portainer.Pair{{Name: "TAG", Value: "1.25"}},
+	}}
+
+	// No policy
+	assert.NoError(t, manager.checkPayloadPolicy(context.Background(), stack, stackFile))
+
+	manager.SetPayloadPolicy(opa.NewEvaluator(binary, filepath.Join(dir, "policies")))
+	require.NoError(t, manager.checkPayloadPolicy(context.Background(), stack, stackFile))
+
+	data, err := os.ReadFile(filepath.Join(dir, "input.json"))
+	require.NoError(t, err)
+
+	var input map[string]any
+	require.NoError(t, json.Unmarshal(data, &input))
+	assert.Equal(t, map[string]any{
+		"kind": "stack",
+		"payload": map[string]any{
+			"id":            float64(1),
+			"name":          "web",
+			"version":       float64(3),
+			"namespace":     "",
+			"format":        "",
+			"entryFileName": "docker-compose.yml",
+			"envVars":       map[string]any{"TAG": "1.25"},
+			"registries":    []any{"registry.example.com"},
+			"documents":     []any{map[string]any{"services": map[string]any{"web": map[string]any{"image": "nginx"}}}},
+		},
+	}, input)
+	assert.NotContains(t, string(data), "s3cret")
+
+	require.NoError(t, os.WriteFile(stackFile, []byte("services:\n  web:\n    image: nginx\n    network_mode: host\n"), 0600))
+
+	err = manager.checkPayloadPolicy(context.Background(), stack, stackFile)
+	assert.ErrorIs(t, err, opa.ErrDenied)
+	assert.Equal(t, "denied by the OPA policy: host networking is forbidden", err.Error())
+}
@@ -0,0 +1,161 @@
+package stack
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/portainer/agent/chaos"
+	agentfs "github.com/portainer/agent/filesystem"
+	"github.com/portainer/portainer/api/filesystem"
+)
+
+// versionsFolderSuffix is the suffix for the path where each persisted version of an edge stack is written
+const versionsFolderSuffix = ".versions"
+
+// keptStackVersions is the number of persisted versions kept, the current one and the previous one
+const keptStackVersions = 2
+
+// VersionsStackFileFolder returns the folder where the persisted versions of an Edge stack are written
+func VersionsStackFileFolder(fileFolder string) string {
+	return fmt.Sprintf("%s%s", fileFolder, versionsFolderSuffix)
+}
+
+// persistStackFiles writes the files of the stack into a new versioned folder then atomically
+// points the stack folder to it, so that a crash mid-write never leaves a partially written
+// stack folder behind. Relative path stacks are copied as is to the host and are still
+// written in place. The large files are downloaded later on, see downloadLargeFiles.
+func (manager *StackManager) persistStackFiles(stack *edgeStack, dirEntries []filesystem.DirEntry) error {
+	if err := chaos.WriteError(stack.FileFolder); err != nil {
+		return err
+	}
+
+	if IsRelativePathStack(stack) {
+		if err := filesystem.PersistDir(stack.FileFolder, dirEntries); err != nil {
+			return err
+		}
+
+		chaos.TruncateFile(stack.FileFolder)
+
+		return agentfs.SyncTree(stack.FileFolder)
+	}
+
+	versionFolder := manager.newStackVersionFolder(stack)
+
+	// The folder is created even without entries, the large files are written into it
+	if err := os.MkdirAll(versionFolder, 0755); err != nil {
+		return err
+	}
+
+	if err := filesystem.PersistDir(versionFolder, dirEntries); err != nil {
+		_ = os.RemoveAll(versionFolder)
+
+		return err
+	}
+
+	chaos.TruncateFile(versionFolder)
+
+	// The version folder must be durable before the stack folder points to it
+	if err := agentfs.SyncTree(versionFolder); err != nil {
+		return err
+	}
+
+	return switchStackFileFolder(stack.FileFolder, versionFolder)
+}
+
+func (manager *StackManager) newStackVersionFolder(stack *edgeStack) string {
+	name := fmt.Sprintf("%d-%d", stack.Version, manager.now().UnixNano())
+
+	return filepath.Join(VersionsStackFileFolder(stack.FileFolder), name)
+}
+
+// switchStackFileFolder atomically replaces the stack folder by a symlink to the version folder
+// and prunes the older versions
+func switchStackFileFolder(fileFolder, versionFolder string) error {
+	target, err := filepath.Rel(filepath.Dir(fileFolder), versionFolder)
+	if err != nil {
+		return err
+	}
+
+	tmpLink := fileFolder + ".tmp"
+	if err := os.Remove(tmpLink); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if err := os.Symlink(target, tmpLink); err != nil {
+		return err
+	}
+
+	// Stacks persisted by previous versions of the agent are plain folders that
+	// cannot be atomically replaced by a symlink
+	if info, err := os.Lstat(fileFolder); err == nil && info.Mode()&os.ModeSymlink == 0 {
+		if err := os.RemoveAll(fileFolder); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(tmpLink, fileFolder); err != nil {
+		return err
+	}
+
+	if err := agentfs.SyncDir(filepath.Dir(fileFolder)); err != nil {
+		return err
+	}
+
+	return pruneStackVersions(filepath.Dir(versionFolder), filepath.Base(versionFolder))
+}
+
+// pruneStackVersions removes the oldest versions, always keeping the current one
+func pruneStackVersions(versionsFolder, current string) error {
+	entries, err := os.ReadDir(versionsFolder)
+	if err != nil {
+		return err
+	}
+
+	var versions []string
+
+	for _, entry := range entries {
+		if entry.Name() != current {
+			versions = append(versions, entry.Name())
+		}
+	}
+
+	// Folders are named <version>-<unix nano timestamp>, sort them from the most recent
+	sort.Slice(versions, func(i, j int) bool {
+		return versionFolderTimestamp(versions[i]) > versionFolderTimestamp(versions[j])
+	})
+
+	for i, name := range versions {
+		if i < keptStackVersions-1 {
+			continue
+		}
+
+		if err := os.RemoveAll(filepath.Join(versionsFolder, name)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func versionFolderTimestamp(name string) int64 {
+	_, timestamp, _ := strings.Cut(name, "-")
+
+	ts, _ := strconv.ParseInt(timestamp, 10, 64)
+
+	return ts
+}
+
+// resolveStackFileFolder returns the folder the stack files are actually written to
+func resolveStackFileFolder(fileFolder string) string {
+	resolved, err := filepath.EvalSymlinks(fileFolder)
+	if err != nil {
+		return fileFolder
+	}
+
+	return resolved
+}
@@ -0,0 +1,50 @@
+package stack
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/portainer/agent/clock"
+	"github.com/portainer/portainer/api/filesystem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStackManager_persistStackFiles(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Unix(1000, 0))
+	manager := &StackManager{clock: fakeClock}
+
+	fileFolder := filepath.Join(t.TempDir(), "1")
+
+	// Stack folder persisted by a previous version of the agent
+	require.NoError(t, os.MkdirAll(fileFolder, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(fileFolder, "legacy.yml"), nil, 0644))
+
+	stack := &edgeStack{FileFolder: fileFolder}
+
+	for version := 1; version <= 3; version++ {
+		stack.Version = version
+
+		err := manager.persistStackFiles(stack, []filesystem.DirEntry{
+			{Name: "docker-compose.yml", Content: string(rune('0' + version)), IsFile: true},
+		})
+		require.NoError(t, err)
+
+		fakeClock.Advance(time.Second)
+	}
+
+	info, err := os.Lstat(fileFolder)
+	require.NoError(t, err)
+	assert.NotZero(t, info.Mode()&os.ModeSymlink)
+
+	content, err := os.ReadFile(filepath.Join(fileFolder, "docker-compose.yml"))
+	require.NoError(t, err)
+	assert.Equal(t, "3", string(content))
+	assert.NoFileExists(t, filepath.Join(fileFolder, "legacy.yml"))
+
+	entries, err := os.ReadDir(VersionsStackFileFolder(fileFolder))
+	require.NoError(t, err)
+	assert.Len(t, entries, keptStackVersions)
+}
@@ -0,0 +1,56 @@
+package stack
+
+import (
+	"context"
+
+	"github.com/portainer/agent/docker"
+
+	"github.com/rs/zerolog/log"
+)
+
+// StopStacks stops processing the stacks, then stops the containers of the stacks so that they are stopped cleanly
+// before the device reboots or shuts down. Only the containers of the stacks deployed on a standalone Docker engine
+// are stopped, the other engines stop their workloads on their own.
+func (manager *StackManager) StopStacks(ctx context.Context) error {
+	if err := manager.Stop(); err != nil {
+		return err
+	}
+
+	manager.mu.Lock()
+
+	if manager.engineType != EngineTypeDockerStandalone {
+		manager.mu.Unlock()
+
+		return nil
+	}
+
+	projects := make([]string, 0, len(manager.stacks))
+	for _, stack := range manager.stacks {
+		projects = append(projects, manager.stackProjectName(stack))
+	}
+
+	manager.mu.Unlock()
+
+	for _, project := range projects {
+		containers, err := docker.GetContainersWithLabel("com.docker.compose.project=" + project)
+		if err != nil {
+			return err
+		}
+
+		for _, container := range containers {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			if container.State != "running" {
+				continue
+			}
+
+			if err := docker.ContainerStop(container.ID); err != nil {
+				log.Error().Str("project", project).Str("container_id", container.ID).Err(err).Msg("unable to stop the container of the stack")
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,195 @@
+package stack
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/portainer/portainer/api/edge"
+
+	"github.com/rs/zerolog/log"
+)
+
+// subscriberBufferSize is the number of events buffered for each subscriber, the events are dropped for the
+// subscribers not reading them
+const subscriberBufferSize = 64
+
+// StackInfo is a read-only view of an Edge stack
+type StackInfo struct {
+	ID      int
+	Name    string
+	Version int
+	Status  edgeStackStatus
+	// RolledBackVersion is the retained version currently deployed instead of Version, 0 if none
+	RolledBackVersion int
+	// Registries are the registries of the images used by the stack
+	Registries []string
+	// Priority is the priority of the stack in the queue, empty for the normal priority
+	Priority string
+	// ProjectName is the project the stack is deployed as
+	ProjectName string
+	// UpdatedAt is the time of the last status transition of the stack
+	UpdatedAt time.Time
+}
+
+// StackChange is a change of an Edge stack sent to the subscribers, Removed is true once the stack is removed
+type StackChange struct {
+	// Stack is the stack once changed, Stack.Status is its new status
+	Stack StackInfo
+	// From is the status of the stack before the change, 0 for a new stack
+	From    edgeStackStatus
+	Time    time.Time
+	Message string
+	Removed bool
+}
+
+// StatusProvider is the read-only view of the Edge stacks for the other subsystems of the agent. Its methods do not
+// take the lock of the stack manager, they can be called while a stack is deployed.
+type StatusProvider interface {
+	ListStacks() []StackInfo
+	GetStack(stackID int) (StackInfo, bool)
+	// Subscribe returns the changes of the stacks until the context is done
+	Subscribe(ctx context.Context) <-chan StackChange
+}
+
+var _ StatusProvider = &StackManager{}
+
+// statusView holds the copies of the stacks read by the status consumers, updated along with the stacks of the manager
+type statusView struct {
+	stacks map[int]StackInfo
+	// credentials are the registry credentials of the stacks, only read by the registry credential lookup
+	credentials map[int][]edge.RegistryCredentials
+	subscribers map[chan StackChange]struct{}
+	mu          sync.RWMutex
+}
+
+// ListStacks returns the stacks sorted by identifier
+func (manager *StackManager) ListStacks() []StackInfo {
+	manager.view.mu.RLock()
+	defer manager.view.mu.RUnlock()
+
+	stacks := make([]StackInfo, 0, len(manager.view.stacks))
+	for _, stack := range manager.view.stacks {
+		stack.Registries = slices.Clone(stack.Registries)
+		stacks = append(stacks, stack)
+	}
+
+	sort.Slice(stacks, func(i, j int) bool { return stacks[i].ID < stacks[j].ID })
+
+	return stacks
+}
+
+// GetStack returns the stack, false when the manager does not know it
+func (manager *StackManager) GetStack(stackID int) (StackInfo, bool) {
+	manager.view.mu.RLock()
+	defer manager.view.mu.RUnlock()
+
+	stack, ok := manager.view.stacks[stackID]
+	stack.Registries = slices.Clone(stack.Registries)
+
+	return stack, ok
+}
+
+// Subscribe returns the changes of the stacks until the context is done, the channel is then closed. The events are
+// dropped when the channel is full, the subscribers falling behind can read the stacks again with ListStacks.
+func (manager *StackManager) Subscribe(ctx context.Context) <-chan StackChange {
+	events := make(chan StackChange, subscriberBufferSize)
+
+	manager.view.mu.Lock()
+	if manager.view.subscribers == nil {
+		manager.view.subscribers = make(map[chan StackChange]struct{})
+	}
+	manager.view.subscribers[events] = struct{}{}
+	manager.view.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		manager.view.mu.Lock()
+		defer manager.view.mu.Unlock()
+
+		delete(manager.view.subscribers, events)
+		close(events)
+	}()
+
+	return events
+}
+
+// putStack stores the stack in the manager and publishes its changes. The caller must hold the manager lock.
+func (manager *StackManager) putStack(stack *edgeStack) {
+	manager.stacks[edgeStackID(stack.ID)] = stack
+	manager.publishStack(stack, "")
+}
+
+// removeStack removes the stack from the manager and notifies its removal. The caller must hold the manager lock.
+func (manager *StackManager) removeStack(stack *edgeStack) {
+	delete(manager.stacks, edgeStackID(stack.ID))
+	delete(manager.queueServedAt, stack.ID)
+	manager.forgetChangelog(stack.ID)
+	delete(manager.remediations, stack.ID)
+
+	manager.view.mu.Lock()
+	defer manager.view.mu.Unlock()
+
+	info, ok := manager.view.stacks[stack.ID]
+	if !ok {
+		return
+	}
+
+	delete(manager.view.stacks, stack.ID)
+	delete(manager.view.credentials, stack.ID)
+
+	manager.notify(StackChange{Stack: info, From: info.Status, Time: manager.now(), Removed: true})
+}
+
+// publishStack updates the copy of the stack read by the status consumers, they are notified when it changed.
+// The caller must hold the manager lock.
+func (manager *StackManager) publishStack(stack *edgeStack, message string) {
+	info := StackInfo{
+		ID:                stack.ID,
+		Name:              stack.Name,
+		Version:           stack.Version,
+		Status:            stack.Status,
+		RolledBackVersion: stack.RolledBackVersion,
+		Registries:        slices.Clone(stack.Registries),
+		Priority:          stack.Priority,
+		ProjectName:       manager.stackProjectName(stack),
+	}
+
+	if n := len(stack.StatusTransitions); n > 0 {
+		info.UpdatedAt = stack.StatusTransitions[n-1].Time
+	}
+
+	manager.view.mu.Lock()
+	defer manager.view.mu.Unlock()
+
+	if manager.view.stacks == nil {
+		manager.view.stacks = make(map[int]StackInfo)
+		manager.view.credentials = make(map[int][]edge.RegistryCredentials)
+	}
+
+	manager.view.credentials[stack.ID] = slices.Clone(stack.RegistryCredentials)
+
+	previous, ok := manager.view.stacks[stack.ID]
+	if ok && reflect.DeepEqual(previous, info) {
+		return
+	}
+
+	manager.view.stacks[stack.ID] = info
+
+	manager.notify(StackChange{Stack: info, From: previous.Status, Time: manager.now(), Message: message})
+}
+
+// notify sends the event to the subscribers. The caller must hold the lock of the view.
+func (manager *StackManager) notify(event StackChange) {
+	for events := range manager.view.subscribers {
+		select {
+		case events <- event:
+		default:
+			log.Debug().Int("stack_identifier", event.Stack.ID).Msg("stack event dropped, the subscriber is not reading the events")
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package stack
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/portainer/agent/clock"
+	"github.com/portainer/portainer/api/edge"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStackManager_StatusProvider(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	manager := NewStackManager(nil, "", nil, "")
+	manager.clock = clock.NewFakeClock(now)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := manager.Subscribe(ctx)
+
+	stack := &edgeStack{StackPayload: edge.StackPayload{ID: 2, Name: "web", Version: 1}, Registries: []string{"registry.example.com"}}
+	manager.putStack(stack)
+	manager.setStatus(stack, StatusPending)
+	manager.putStack(&edgeStack{StackPayload: edge.StackPayload{ID: 1, Name: "db", Version: 3}})
+
+	// Storing an unchanged stack is not notified
+	manager.putStack(stack)
+
+	web := StackInfo{ID: 2, Name: "web", ProjectName: "edge_web", Version: 1, Status: StatusPending, Registries: []string{"registry.example.com"}, UpdatedAt: now}
+
+	assert.Equal(t, StackChange{Stack: StackInfo{ID: 2, Name: "web", ProjectName: "edge_web", Version: 1, Registries: []string{"registry.example.com"}}, Time: now}, <-events)
+	assert.Equal(t, StackChange{Stack: web, Time: now}, <-events)
+	assert.Equal(t, StackChange{Stack: StackInfo{ID: 1, Name: "db", ProjectName: "edge_db", Version: 3}, Time: now}, <-events)
+
+	got, ok := manager.GetStack(2)
+	require.True(t, ok)
+	assert.Equal(t, web, got)
+
+	stacks := manager.ListStacks()
+	require.Len(t, stacks, 2)
+	assert.Equal(t, 1, stacks[0].ID)
+
+	// The stacks returned are copies
+	got.Registries[0] = "other.example.com"
+	got, _ = manager.GetStack(2)
+	assert.Equal(t, []string{"registry.example.com"}, got.Registries)
+
+	// The transitions carry the previous status and their message
+	manager.setStatus(stack, StatusDeploying)
+	manager.setStatusMessage(stack, StatusError, "failed to pull image")
+
+	deploying := <-events
+	assert.Equal(t, StatusPending, deploying.From)
+	assert.Equal(t, StatusDeploying, deploying.Stack.Status)
+
+	assert.Equal(t, StackChange{Stack: StackInfo{ID: 2, Name: "web", ProjectName: "edge_web", Version: 1, Status: StatusError, Registries: []string{"registry.example.com"}, UpdatedAt: now}, From: StatusDeploying, Time: now, Message: "failed to pull image"}, <-events)
+
+	manager.removeStack(stack)
+
+	removed := <-events
+	assert.True(t, removed.Removed)
+	assert.Equal(t, StatusError, removed.From)
+
+	_, ok = manager.GetStack(2)
+	assert.False(t, ok)
+
+	cancel()
+
+	for range events {
+	}
+}
@@ -0,0 +1,12 @@
+package stack
+
+import "github.com/portainer/agent"
+
+// SetDeployProxy sets the proxy the deployers pull the images and fetch the files of the stacks through, along with
+// the registries and hosts they reach without it. The deployers inherit the environment of the agent when it is nil.
+func (manager *StackManager) SetDeployProxy(proxy *agent.ProxyOptions) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	manager.deployProxy = proxy
+}
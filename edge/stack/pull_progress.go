@@ -0,0 +1,200 @@
+package stack
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/portainer/agent/docker"
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/agent/edge/yaml"
+
+	"github.com/docker/docker/api/types/registry"
+	"github.com/rs/zerolog/log"
+)
+
+// pullProgressInterval is the minimum time between two reports of the pull progress of a stack
+const pullProgressInterval = 5 * time.Second
+
+// imagePuller pulls an image through the engine API, calling progress as its layers are downloaded
+type imagePuller func(ctx context.Context, image, registryAuth string, progress func(docker.LayerProgress)) error
+
+// pullTracker aggregates the download progress of the layers of the images of a stack
+type pullTracker struct {
+	layers map[string]docker.LayerProgress
+	image  string
+}
+
+func newPullTracker() *pullTracker {
+	return &pullTracker{layers: make(map[string]docker.LayerProgress)}
+}
+
+func (tracker *pullTracker) update(layer docker.LayerProgress) {
+	// The size of the layers already present is not known, they are left out
+	if layer.Done {
+		known, ok := tracker.layers[layer.Layer]
+		if !ok {
+			return
+		}
+
+		layer.Current, layer.Total = known.Total, known.Total
+	}
+
+	tracker.layers[layer.Layer] = layer
+	tracker.image = layer.Image
+}
+
+// progress returns the progress over the layers whose size is known so far, the percentage can
+// decrease as the downloads of new layers start
+func (tracker *pullTracker) progress(now time.Time) client.StackPullProgress {
+	var current, total int64
+	for _, layer := range tracker.layers {
+		current += layer.Current
+		total += layer.Total
+	}
+
+	percent := 0
+	if total > 0 {
+		percent = int(current * 100 / total)
+	}
+
+	return client.StackPullProgress{
+		Image:        tracker.image,
+		Percent:      percent,
+		CurrentBytes: current,
+		TotalBytes:   total,
+		Detail:       fmt.Sprintf("pulling %d%%", percent),
+		Time:         now.Unix(),
+	}
+}
+
+// pullResult is the outcome of the pull of the images of a stack through the engine API
+type pullResult struct {
+	// failed maps the images the engine failed to pull to their error
+	failed map[string]error
+	// complete is true when every image of the stack file was pulled through the engine API
+	complete bool
+}
+
+// failures returns the error of each failed image, in a stable order
+func (result pullResult) failures() string {
+	images := make([]string, 0, len(result.failed))
+	for image := range result.failed {
+		images = append(images, image)
+	}
+
+	sort.Strings(images)
+
+	details := make([]string, 0, len(images))
+	for _, image := range images {
+		details = append(details, fmt.Sprintf("%s: %s", image, result.failed[image]))
+	}
+
+	return strings.Join(details, "; ")
+}
+
+// SetPullProgress sets whether the images of the stacks are pulled through the engine API to report their download
+// progress. The images are otherwise pulled by the deployer, which saves the memory of the progress tracking.
+func (manager *StackManager) SetPullProgress(enabled bool) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	manager.pullProgressDisabled = !enabled
+}
+
+// pullWithProgress pulls the images of the stack file through the engine API before the deployer pulls them,
+// reporting the download progress to the server. The images pulled by the previous attempts are skipped, so that
+// only the failed ones are pulled again. The deployer pulls the images when they are not all pulled this way,
+// e.g. because they use variables or their credentials are not known to the agent.
+// The caller must hold the manager lock.
+func (manager *StackManager) pullWithProgress(ctx context.Context, stack *edgeStack, stackFileLocation string) pullResult {
+	result := pullResult{failed: make(map[string]error)}
+
+	if manager.imagePuller == nil || manager.pullProgressDisabled {
+		return result
+	}
+
+	content, err := os.ReadFile(stackFileLocation)
+	if err != nil {
+		return result
+	}
+
+	images, err := yaml.Images(string(content))
+	if err != nil {
+		return result
+	}
+
+	credentials := manager.stackRegistryCredentials(stack.ID)
+	tracker := newPullTracker()
+
+	var lastReport time.Time
+	report := func() {
+		lastReport = manager.now()
+
+		progress := tracker.progress(lastReport)
+		if stack.Priority != "" {
+			progress.Priority = stack.Priority
+			progress.Detail = fmt.Sprintf("%s (%s priority)", progress.Detail, stack.Priority)
+		}
+
+		if err := manager.portainerClient.SetEdgeStackPullProgress(stack.ID, progress); err != nil {
+			log.Warn().Err(err).Int("stack_identifier", stack.ID).Msg("unable to report the pull progress of the stack")
+		}
+	}
+
+	result.complete = true
+
+	for _, image := range images {
+		if slices.Contains(stack.PulledImages, image) {
+			continue
+		}
+
+		imageRegistry, err := yaml.ImageRegistry(image)
+		if err != nil {
+			result.complete = false
+
+			continue
+		}
+
+		var registryAuth string
+		if found := findRegistryCredentials(credentials, imageRegistry); found != nil {
+			registryAuth, err = registry.EncodeAuthConfig(registry.AuthConfig{
+				Username:      found.Username,
+				Password:      found.Secret,
+				ServerAddress: found.ServerURL,
+			})
+			if err != nil {
+				result.complete = false
+
+				continue
+			}
+		}
+
+		err = manager.imagePuller(ctx, image, registryAuth, func(layer docker.LayerProgress) {
+			tracker.update(layer)
+
+			if manager.now().Sub(lastReport) >= pullProgressInterval {
+				report()
+			}
+		})
+		if err != nil {
+			log.Debug().Err(err).Int("stack_identifier", stack.ID).Str("image", image).Msg("unable to pull the image through the engine")
+
+			result.failed[image] = err
+
+			continue
+		}
+
+		stack.PulledImages = append(stack.PulledImages, image)
+	}
+
+	if len(tracker.layers) > 0 {
+		report()
+	}
+
+	return result
+}
@@ -0,0 +1,187 @@
+package stack
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/portainer/agent/clock"
+	"github.com/portainer/agent/docker"
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/agent/edge/credstore"
+	"github.com/portainer/agent/internals/mocks"
+	"github.com/portainer/portainer/api/edge"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gomock "go.uber.org/mock/gomock"
+)
+
+func TestPullTracker(t *testing.T) {
+	tracker := newPullTracker()
+
+	tracker.update(docker.LayerProgress{Image: "nginx", Layer: "a", Current: 25, Total: 100})
+	tracker.update(docker.LayerProgress{Image: "nginx", Layer: "b", Done: true})
+	assert.Equal(t, 25, tracker.progress(time.Unix(0, 0)).Percent)
+
+	tracker.update(docker.LayerProgress{Image: "nginx", Layer: "c", Current: 0, Total: 100})
+	tracker.update(docker.LayerProgress{Image: "nginx", Layer: "a", Done: true})
+
+	progress := tracker.progress(time.Unix(1000, 0))
+	assert.Equal(t, client.StackPullProgress{
+		Image:        "nginx",
+		Percent:      50,
+		CurrentBytes: 100,
+		TotalBytes:   200,
+		Detail:       "pulling 50%",
+		Time:         1000,
+	}, progress)
+}
+
+func TestStackManager_pullWithProgress(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	stackFile := filepath.Join(t.TempDir(), "docker-compose.yml")
+	require.NoError(t, os.WriteFile(stackFile, []byte(`
+services:
+  web:
+    image: registry.example.com/team/web:1.0
+  worker:
+    image: ${REGISTRY}/worker
+  db:
+    image: postgres:16
+`), 0600))
+
+	mockClient := mocks.NewMockPortainerClient(ctrl)
+	fakeClock := clock.NewFakeClock(time.Unix(1000, 0))
+
+	stack := &edgeStack{
+		StackPayload: edge.StackPayload{
+			ID: 1,
+			RegistryCredentials: []edge.RegistryCredentials{
+				{ServerURL: "registry.example.com", Username: "deploy", Secret: "s3cret"},
+			},
+		},
+	}
+
+	auths := make(map[string]string)
+
+	manager := &StackManager{
+		portainerClient: mockClient,
+		clock:           fakeClock,
+		credentialStore: credstore.NewMemoryStore(),
+		stacks:          map[edgeStackID]*edgeStack{1: stack},
+		imagePuller: func(ctx context.Context, image, registryAuth string, progress func(docker.LayerProgress)) error {
+			auths[image] = registryAuth
+
+			if image == "postgres:16" {
+				return errors.New("toomanyrequests")
+			}
+
+			progress(docker.LayerProgress{Image: image, Layer: "a", Current: 10, Total: 100})
+			fakeClock.Advance(time.Second)
+			progress(docker.LayerProgress{Image: image, Layer: "a", Current: 50, Total: 100})
+			fakeClock.Advance(5 * time.Second)
+			progress(docker.LayerProgress{Image: image, Layer: "a", Done: true})
+
+			return nil
+		},
+	}
+
+	gomock.InOrder(
+		mockClient.EXPECT().SetEdgeStackPullProgress(1, client.StackPullProgress{
+			Image: "registry.example.com/team/web:1.0", Percent: 10, CurrentBytes: 10, TotalBytes: 100, Detail: "pulling 10%", Time: 1000,
+		}),
+		mockClient.EXPECT().SetEdgeStackPullProgress(1, client.StackPullProgress{
+			Image: "registry.example.com/team/web:1.0", Percent: 100, CurrentBytes: 100, TotalBytes: 100, Detail: "pulling 100%", Time: 1006,
+		}).Times(2),
+	)
+
+	result := manager.pullWithProgress(context.Background(), stack, stackFile)
+
+	// The image using a variable is left to the deployer
+	assert.False(t, result.complete)
+	assert.Equal(t, "postgres:16: toomanyrequests", result.failures())
+	assert.Equal(t, []string{"registry.example.com/team/web:1.0"}, stack.PulledImages)
+
+	assert.Len(t, auths, 2)
+	assert.Empty(t, auths["postgres:16"])
+
+	data, err := base64.URLEncoding.DecodeString(auths["registry.example.com/team/web:1.0"])
+	require.NoError(t, err)
+
+	var auth map[string]string
+	require.NoError(t, json.Unmarshal(data, &auth))
+	assert.Equal(t, "deploy", auth["username"])
+	assert.Equal(t, "s3cret", auth["password"])
+}
+
+func TestStackManager_pullWithProgress_retry(t *testing.T) {
+	stackFile := filepath.Join(t.TempDir(), "docker-compose.yml")
+	require.NoError(t, os.WriteFile(stackFile, []byte(`
+services:
+  web:
+    image: nginx:1.25
+  db:
+    image: postgres:16
+`), 0600))
+
+	var pulled []string
+	failing := map[string]bool{"postgres:16": true}
+
+	manager := &StackManager{
+		credentialStore: credstore.NewMemoryStore(),
+		stacks:          map[edgeStackID]*edgeStack{},
+		imagePuller: func(ctx context.Context, image, registryAuth string, progress func(docker.LayerProgress)) error {
+			pulled = append(pulled, image)
+
+			if failing[image] {
+				return errors.New("toomanyrequests")
+			}
+
+			return nil
+		},
+	}
+
+	stack := &edgeStack{StackPayload: edge.StackPayload{ID: 1}}
+
+	result := manager.pullWithProgress(context.Background(), stack, stackFile)
+	assert.True(t, result.complete)
+	assert.Equal(t, "postgres:16: toomanyrequests", result.failures())
+
+	// Only the failed image is pulled again
+	pulled = nil
+	failing = map[string]bool{}
+
+	result = manager.pullWithProgress(context.Background(), stack, stackFile)
+	assert.True(t, result.complete)
+	assert.Empty(t, result.failed)
+	assert.Equal(t, []string{"postgres:16"}, pulled)
+	assert.Equal(t, []string{"nginx:1.25", "postgres:16"}, stack.PulledImages)
+}
+
+func TestStackManager_pullWithProgress_disabled(t *testing.T) {
+	stackFile := filepath.Join(t.TempDir(), "docker-compose.yml")
+	require.NoError(t, os.WriteFile(stackFile, []byte("services:\n  web:\n    image: nginx:1.25\n"), 0600))
+
+	stack := &edgeStack{StackPayload: edge.StackPayload{ID: 1}}
+
+	manager := &StackManager{
+		stacks: map[edgeStackID]*edgeStack{1: stack},
+		imagePuller: func(ctx context.Context, image, registryAuth string, progress func(docker.LayerProgress)) error {
+			t.Fatal("the image must be pulled by the deployer")
+
+			return nil
+		},
+	}
+	manager.SetPullProgress(false)
+
+	result := manager.pullWithProgress(context.Background(), stack, stackFile)
+	assert.False(t, result.complete)
+	assert.Empty(t, stack.PulledImages)
+}
@@ -0,0 +1,163 @@
+package stack
+
+import (
+	"sort"
+	"time"
+
+	"github.com/portainer/agent/edge/client"
+)
+
+// starvationThreshold is the time a stack waits in the queue before it is served ahead of the higher priority tiers
+const starvationThreshold = 5 * time.Minute
+
+// queueTier ranks the stacks waiting in the queue, the lower tiers are served first
+type queueTier int
+
+const (
+	tierDelete queueTier = iota
+	tierUpdate
+	tierDeploy
+	tierStatusCheck
+)
+
+// queuePriority ranks the pending stacks by the priority sent by the server, the lower ones are served first
+type queuePriority int
+
+const (
+	priorityCritical queuePriority = iota
+	priorityHigh
+	priorityNormal
+)
+
+type queueEntry struct {
+	stack    *edgeStack
+	priority queuePriority
+	tier     queueTier
+	since    time.Time
+}
+
+// nextQueuedStack returns the pending stack or the stack awaiting its status to serve next, nil when there is none.
+// The stacks waiting for longer than the starvation threshold are served first, then the pending stacks by priority,
+// then the stacks of the lowest tier, each tier being served in the order the stacks entered it.
+// The caller must hold the manager lock.
+func (manager *StackManager) nextQueuedStack() *edgeStack {
+	var entries []queueEntry
+
+	for _, stack := range manager.stacks {
+		switch stack.Status {
+		case StatusPending:
+			entries = append(entries, queueEntry{stack: stack, priority: stackPriority(stack), tier: actionTier(stack.Action), since: manager.queuedSince(stack)})
+		case StatusAwaitingDeployedStatus, StatusAwaitingRemovedStatus:
+			entries = append(entries, queueEntry{stack: stack, priority: priorityNormal, tier: tierStatusCheck, since: manager.queuedSince(stack)})
+		}
+	}
+
+	if len(entries) == 0 {
+		return nil
+	}
+
+	starvedBefore := manager.now().Add(-starvationThreshold)
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+
+		starvedA, starvedB := a.since.Before(starvedBefore), b.since.Before(starvedBefore)
+		if starvedA != starvedB {
+			return starvedA
+		}
+
+		if !starvedA && a.priority != b.priority {
+			return a.priority < b.priority
+		}
+
+		if !starvedA && a.tier != b.tier {
+			return a.tier < b.tier
+		}
+
+		if !a.since.Equal(b.since) {
+			return a.since.Before(b.since)
+		}
+
+		return a.stack.ID < b.stack.ID
+	})
+
+	return entries[0].stack
+}
+
+// nextCheckedStack returns the deployed stack whose status was checked the least recently, nil when there is none.
+// The caller must hold the manager lock.
+func (manager *StackManager) nextCheckedStack() *edgeStack {
+	var next *edgeStack
+	var nextSince time.Time
+
+	for _, stack := range manager.sortedStacks() {
+		if stack.Status != StatusDeployed && stack.Status != StatusDegraded {
+			continue
+		}
+
+		if since := manager.queuedSince(stack); next == nil || since.Before(nextSince) {
+			next, nextSince = stack, since
+		}
+	}
+
+	return next
+}
+
+// queuedSince returns the time the stack waits in the queue since, the last time it was served or changed status
+func (manager *StackManager) queuedSince(stack *edgeStack) time.Time {
+	var since time.Time
+	if n := len(stack.StatusTransitions); n > 0 {
+		since = stack.StatusTransitions[n-1].Time
+	}
+
+	if served, ok := manager.queueServedAt[stack.ID]; ok && served.After(since) {
+		since = served
+	}
+
+	return since
+}
+
+// markServed moves the stack to the end of its tier. The caller must hold the manager lock.
+func (manager *StackManager) markServed(stack *edgeStack) {
+	if manager.queueServedAt == nil {
+		manager.queueServedAt = make(map[int]time.Time)
+	}
+
+	manager.queueServedAt[stack.ID] = manager.now()
+}
+
+// sortedStacks returns the stacks sorted by identifier, so that they are always visited in the same order.
+// The caller must hold the manager lock.
+func (manager *StackManager) sortedStacks() []*edgeStack {
+	stacks := make([]*edgeStack, 0, len(manager.stacks))
+	for _, stack := range manager.stacks {
+		stacks = append(stacks, stack)
+	}
+
+	sort.Slice(stacks, func(i, j int) bool { return stacks[i].ID < stacks[j].ID })
+
+	return stacks
+}
+
+func actionTier(action edgeStackAction) queueTier {
+	switch action {
+	case actionDelete:
+		return tierDelete
+	case actionUpdate, actionRestart:
+		return tierUpdate
+	}
+
+	return tierDeploy
+}
+
+// stackPriority returns the rank of the priority of the stack, the unknown priorities are normal
+func stackPriority(stack *edgeStack) queuePriority {
+	switch stack.Priority {
+	case client.StackPriorityCritical:
+		return priorityCritical
+	case client.StackPriorityHigh:
+		return priorityHigh
+	}
+
+	return priorityNormal
+}
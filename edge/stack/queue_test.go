@@ -0,0 +1,120 @@
+package stack
+
+import (
+	"testing"
+	"time"
+
+	"github.com/portainer/agent/clock"
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/portainer/api/edge"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func queuedStack(id int, status edgeStackStatus, action edgeStackAction, since time.Time) *edgeStack {
+	return &edgeStack{
+		StackPayload:      edge.StackPayload{ID: id},
+		Status:            status,
+		Action:            action,
+		StatusTransitions: []statusTransition{{To: status, Time: since}},
+	}
+}
+
+func TestStackManager_nextQueuedStack(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	manager := &StackManager{
+		clock: clock.NewFakeClock(start),
+		stacks: map[edgeStackID]*edgeStack{
+			1: queuedStack(1, StatusAwaitingDeployedStatus, actionDeploy, start.Add(-time.Minute)),
+			2: queuedStack(2, StatusPending, actionDeploy, start.Add(-2*time.Second)),
+			3: queuedStack(3, StatusPending, actionDeploy, start.Add(-3*time.Second)),
+			4: queuedStack(4, StatusPending, actionUpdate, start.Add(-time.Second)),
+			5: queuedStack(5, StatusPending, actionDelete, start),
+		},
+	}
+
+	var order []int
+	for len(manager.stacks) > 0 {
+		stack := manager.nextQueuedStack()
+		require.NotNil(t, stack)
+
+		order = append(order, stack.ID)
+		delete(manager.stacks, edgeStackID(stack.ID))
+	}
+
+	// delete > update > deploy > status checks, in the order the stacks entered their tier
+	assert.Equal(t, []int{5, 4, 3, 2, 1}, order)
+}
+
+func TestStackManager_nextQueuedStack_starvation(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	manager := &StackManager{
+		clock: clock.NewFakeClock(start),
+		stacks: map[edgeStackID]*edgeStack{
+			1: queuedStack(1, StatusAwaitingDeployedStatus, actionDeploy, start.Add(-starvationThreshold-time.Second)),
+			2: queuedStack(2, StatusPending, actionDelete, start),
+		},
+	}
+
+	// The status check waited for too long, it is served ahead of the removal
+	assert.Equal(t, 1, manager.nextQueuedStack().ID)
+
+	manager.markServed(manager.stacks[1])
+	assert.Equal(t, 2, manager.nextQueuedStack().ID)
+}
+
+func TestStackManager_nextQueuedStack_priority(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	critical := queuedStack(3, StatusPending, actionUpdate, start)
+	critical.Priority = client.StackPriorityCritical
+
+	high := queuedStack(4, StatusPending, actionDeploy, start.Add(-time.Second))
+	high.Priority = client.StackPriorityHigh
+
+	manager := &StackManager{
+		clock: clock.NewFakeClock(start),
+		stacks: map[edgeStackID]*edgeStack{
+			1: queuedStack(1, StatusPending, actionDelete, start.Add(-time.Minute)),
+			2: queuedStack(2, StatusAwaitingDeployedStatus, actionDeploy, start.Add(-starvationThreshold-time.Second)),
+			3: critical,
+			4: high,
+		},
+	}
+
+	var order []int
+	for len(manager.stacks) > 0 {
+		stack := manager.nextQueuedStack()
+		require.NotNil(t, stack)
+
+		order = append(order, stack.ID)
+		delete(manager.stacks, edgeStackID(stack.ID))
+	}
+
+	// The starved stack is still served first, then the urgent stacks ahead of the routine removal
+	assert.Equal(t, []int{2, 3, 4, 1}, order)
+}
+
+func TestStackManager_nextPendingStack_fairness(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	fakeClock := clock.NewFakeClock(start)
+
+	manager := &StackManager{
+		clock: fakeClock,
+		stacks: map[edgeStackID]*edgeStack{
+			1: queuedStack(1, StatusDeployed, actionDeploy, start),
+			2: queuedStack(2, StatusDeployed, actionDeploy, start),
+			3: queuedStack(3, StatusDegraded, actionDeploy, start),
+		},
+	}
+
+	// The deployed stacks are checked in turn
+	var order []int
+	for i := 0; i < 6; i++ {
+		order = append(order, manager.nextPendingStack().ID)
+	}
+
+	assert.Equal(t, []int{1, 2, 3, 1, 2, 3}, order)
+}
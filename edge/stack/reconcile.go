@@ -0,0 +1,160 @@
+package stack
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/portainer/agent/edge/client"
+
+	"github.com/rs/zerolog/log"
+)
+
+type stackOperationType int
+
+const (
+	_ stackOperationType = iota
+	operationCreate
+	operationUpdate
+	operationDelete
+	operationRestart
+)
+
+func (o stackOperationType) String() string {
+	switch o {
+	case operationCreate:
+		return "Create"
+	case operationUpdate:
+		return "Update"
+	case operationDelete:
+		return "Delete"
+	case operationRestart:
+		return "Restart"
+	}
+
+	return "Unknown"
+}
+
+// stackOperation is an operation required to move a stack to its desired state
+type stackOperation struct {
+	StackID int
+	Type    stackOperationType
+	// Desired is the desired state of the stack, unset for operationDelete
+	Desired client.StackStatus
+}
+
+// desiredStackOperation returns the operation required to move the stack to the desired state,
+// false when the stack is already in the desired state. A nil stack is not known by the agent.
+func desiredStackOperation(stack *edgeStack, desired client.StackStatus) (stackOperationType, bool) {
+	if stack == nil {
+		return operationCreate, true
+	}
+
+	// A stack marked for removal is desired again, it is redeployed instead of being removed
+	if stack.Action == actionDelete {
+		return operationUpdate, true
+	}
+
+	if stack.Version != desired.Version || desired.ReadyRePullImage {
+		return operationUpdate, true
+	}
+
+	// Hashes are only compared when both are known, older servers do not send them
+	if desired.Hash != "" && stack.Hash != "" && desired.Hash != stack.Hash {
+		return operationUpdate, true
+	}
+
+	if desired.RestartRequestedAt > stack.RestartedAt {
+		return operationRestart, true
+	}
+
+	return 0, false
+}
+
+// reconcileStacks compares the full desired set of stacks with the stacks known by the agent and
+// returns the operations required to converge, ordered by stack identifier. Stacks already being
+// removed are not removed again.
+func reconcileStacks(desired map[int]client.StackStatus, current map[edgeStackID]*edgeStack) []stackOperation {
+	var operations []stackOperation
+
+	for stackID, status := range desired {
+		if op, ok := desiredStackOperation(current[edgeStackID(stackID)], status); ok {
+			operations = append(operations, stackOperation{StackID: stackID, Type: op, Desired: status})
+		}
+	}
+
+	for stackID, stack := range current {
+		if _, ok := desired[int(stackID)]; ok || stack.Action == actionDelete {
+			continue
+		}
+
+		operations = append(operations, stackOperation{StackID: int(stackID), Type: operationDelete})
+	}
+
+	sort.Slice(operations, func(i, j int) bool {
+		return operations[i].StackID < operations[j].StackID
+	})
+
+	return operations
+}
+
+// applyStackOperations applies every operation, a failing operation does not prevent the
+// other stacks from converging. It will be retried with the next desired state.
+// The caller must hold the manager lock.
+func (manager *StackManager) applyStackOperations(operations []stackOperation) error {
+	var errs []error
+
+	for _, op := range operations {
+		log.Debug().
+			Int("stack_identifier", op.StackID).
+			Stringer("operation", op.Type).
+			Msg("reconciling stack")
+
+		switch op.Type {
+		case operationCreate, operationUpdate:
+			if manager.rolloutDeferred(op.Desired) {
+				continue
+			}
+
+			if err := manager.processStack(op.StackID, op.Desired); err != nil {
+				log.Error().Err(err).Int("stack_identifier", op.StackID).Msg("unable to reconcile stack")
+
+				manager.recordVersionError(op.StackID, op.Desired.Version, err)
+				manager.reportRemediation(op.StackID, err)
+
+				errs = append(errs, err)
+			}
+		case operationRestart:
+			if err := manager.requestRestart(op.StackID, op.Desired.RestartRequestedAt); err != nil {
+				log.Error().Err(err).Int("stack_identifier", op.StackID).Msg("unable to restart stack")
+
+				errs = append(errs, err)
+			}
+		case operationDelete:
+			if manager.recovering {
+				log.Debug().Int("stack_identifier", op.StackID).Msg("keeping the stack while the server recovers its stacks")
+
+				continue
+			}
+
+			manager.markStackForRemoval(op.StackID)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// markStackForRemoval marks a stack no longer desired for removal.
+// The caller must hold the manager lock.
+func (manager *StackManager) markStackForRemoval(stackID int) {
+	stack, ok := manager.stacks[edgeStackID(stackID)]
+	if !ok {
+		return
+	}
+
+	log.Debug().Int("stack_identifier", stackID).Msg("marking stack for deletion")
+
+	stack.Action = actionDelete
+	if stack.Status != StatusAwaitingRemovedStatus {
+		manager.setStatus(stack, StatusPending)
+	}
+}
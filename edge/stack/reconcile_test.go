@@ -0,0 +1,77 @@
+package stack
+
+import (
+	"testing"
+
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/portainer/api/edge"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconcileStacks(t *testing.T) {
+	current := map[edgeStackID]*edgeStack{
+		1: {StackPayload: edge.StackPayload{ID: 1, Version: 1}, Hash: "a", Status: StatusDeployed},
+		2: {StackPayload: edge.StackPayload{ID: 2, Version: 1}, Hash: "a", Status: StatusDeployed},
+		3: {StackPayload: edge.StackPayload{ID: 3, Version: 1}, Hash: "a", Status: StatusDeployed},
+		4: {StackPayload: edge.StackPayload{ID: 4, Version: 1}, Status: StatusDeployed},
+		5: {StackPayload: edge.StackPayload{ID: 5, Version: 1}, Status: StatusDeployed},
+		6: {StackPayload: edge.StackPayload{ID: 6, Version: 1}, Status: StatusRemoving, Action: actionDelete},
+		7: {StackPayload: edge.StackPayload{ID: 7, Version: 1}, Status: StatusRemoving, Action: actionDelete},
+	}
+
+	desired := map[int]client.StackStatus{
+		// Unchanged
+		1: {ID: 1, Version: 1, Hash: "a"},
+		// New version
+		2: {ID: 2, Version: 2, Hash: "b"},
+		// Same version with a different content
+		3: {ID: 3, Version: 1, Hash: "b"},
+		// Unknown hash
+		4: {ID: 4, Version: 1, Hash: "b"},
+		// Desired again while being removed
+		7: {ID: 7, Version: 1},
+		// New stack
+		8: {ID: 8, Version: 1},
+	}
+
+	operations := reconcileStacks(desired, current)
+
+	assert.Equal(t, []stackOperation{
+		{StackID: 2, Type: operationUpdate, Desired: desired[2]},
+		{StackID: 3, Type: operationUpdate, Desired: desired[3]},
+		{StackID: 5, Type: operationDelete},
+		{StackID: 7, Type: operationUpdate, Desired: desired[7]},
+		{StackID: 8, Type: operationCreate, Desired: desired[8]},
+	}, operations)
+}
+
+func TestDesiredStackOperation_rePullImage(t *testing.T) {
+	stack := &edgeStack{StackPayload: edge.StackPayload{ID: 1, Version: 1}}
+
+	_, changed := desiredStackOperation(stack, client.StackStatus{ID: 1, Version: 1})
+	assert.False(t, changed)
+
+	op, changed := desiredStackOperation(stack, client.StackStatus{ID: 1, Version: 1, ReadyRePullImage: true})
+	assert.True(t, changed)
+	assert.Equal(t, operationUpdate, op)
+}
+
+func TestStackManager_markStackForRemoval(t *testing.T) {
+	manager := &StackManager{
+		stacks: map[edgeStackID]*edgeStack{
+			1: {StackPayload: edge.StackPayload{ID: 1}, Status: StatusDeployed, Action: actionIdle},
+			2: {StackPayload: edge.StackPayload{ID: 2}, Status: StatusAwaitingRemovedStatus, Action: actionIdle},
+		},
+	}
+
+	assert.NoError(t, manager.applyStackOperations([]stackOperation{
+		{StackID: 1, Type: operationDelete},
+		{StackID: 2, Type: operationDelete},
+		{StackID: 3, Type: operationDelete},
+	}))
+
+	assert.Equal(t, actionDelete, manager.stacks[1].Action)
+	assert.Equal(t, StatusPending, manager.stacks[1].Status)
+	assert.Equal(t, actionDelete, manager.stacks[2].Action)
+	assert.Equal(t, StatusAwaitingRemovedStatus, manager.stacks[2].Status)
+}
@@ -0,0 +1,114 @@
+package stack
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"github.com/portainer/agent"
+	"github.com/portainer/agent/edge/oci"
+	agentfs "github.com/portainer/agent/filesystem"
+
+	"github.com/rs/zerolog/log"
+)
+
+// credentialHelperName is the name of the docker credential helper looking up the registry credentials
+// of the stacks from the agent, i.e. the docker-credential-portainer binary
+const credentialHelperName = "portainer"
+
+// dockerHubServerURL is the key the docker client looks up the credentials of Docker Hub with
+const dockerHubServerURL = "https://index.docker.io/v1/"
+
+// registryConfigPath is where the docker client configuration of each stack is written, the docker-config folder of
+// the stack files path when empty
+var registryConfigPath string
+
+// lookupCredentialHelper returns the path of the agent credential helper
+var lookupCredentialHelper = func() (string, error) {
+	return exec.LookPath("docker-credential-" + credentialHelperName)
+}
+
+type dockerClientConfig struct {
+	CredHelpers map[string]string `json:"credHelpers"`
+}
+
+// registryConfigEnv writes a docker client configuration for the stack routing each registry it pulls from,
+// or has credentials for, to the agent credential helper. The credentials of every registry of a stack mixing
+// several private registries are then looked up whatever the credentials store configured on the device.
+// nerdctl reads the same configuration on containerd engines.
+// It returns the environment pointing the deployer to the configuration, nothing when it is not needed.
+func (manager *StackManager) registryConfigEnv(stack *edgeStack) []string {
+	switch manager.engineType {
+	case EngineTypeDockerStandalone, EngineTypeDockerSwarm, EngineTypeContainerd:
+	default:
+		return nil
+	}
+
+	if len(stack.RegistryCredentials) == 0 && manager.awsConfig == nil {
+		return nil
+	}
+
+	if _, err := lookupCredentialHelper(); err != nil {
+		log.Debug().Err(err).Int("stack_identifier", stack.ID).Msg("the credential helper is not available, using the docker client configuration of the device")
+
+		return nil
+	}
+
+	registries := make(map[string]string)
+	for _, registry := range stack.Registries {
+		registries[credentialHelperKey(registry)] = credentialHelperName
+	}
+
+	for _, credentials := range stack.RegistryCredentials {
+		registries[credentialHelperKey(credentials.ServerURL)] = credentialHelperName
+	}
+
+	data, err := json.Marshal(dockerClientConfig{CredHelpers: registries})
+	if err != nil {
+		log.Error().Err(err).Int("stack_identifier", stack.ID).Msg("unable to encode the docker client configuration of the stack")
+
+		return nil
+	}
+
+	folder := stackRegistryConfigFolder(stack.ID)
+	if err := agentfs.WriteFile(folder, "config.json", data, 0600); err != nil {
+		log.Error().Err(err).Int("stack_identifier", stack.ID).Msg("unable to write the docker client configuration of the stack")
+
+		return nil
+	}
+
+	return []string{"DOCKER_CONFIG=" + folder}
+}
+
+// deployerEnv returns the environment of the deployer commands pulling the images of the stack
+func (manager *StackManager) deployerEnv(stack *edgeStack) []string {
+	return append(buildEnvVarsForDeployer(stack.EnvVars), manager.registryConfigEnv(stack)...)
+}
+
+// removeRegistryConfig removes the docker client configuration of the stack
+func removeRegistryConfig(stack *edgeStack) {
+	if err := os.RemoveAll(stackRegistryConfigFolder(stack.ID)); err != nil {
+		log.Error().Err(err).Int("stack_identifier", stack.ID).Msg("unable to remove the docker client configuration of the stack")
+	}
+}
+
+func stackRegistryConfigFolder(stackID int) string {
+	folder := registryConfigPath
+	if folder == "" {
+		folder = filepath.Join(agent.EdgeStackFilesPath, "docker-config")
+	}
+
+	return filepath.Join(folder, strconv.Itoa(stackID))
+}
+
+// credentialHelperKey returns the registry as the docker client looks up its credentials helper
+func credentialHelperKey(registry string) string {
+	registry = oci.NormalizeRegistry(registry)
+	if registry == "docker.io" {
+		return dockerHubServerURL
+	}
+
+	return registry
+}
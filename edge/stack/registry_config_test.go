@@ -0,0 +1,68 @@
+package stack
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/portainer/portainer/api/edge"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryConfigEnv(t *testing.T) {
+	originalPath, originalLookup := registryConfigPath, lookupCredentialHelper
+	t.Cleanup(func() {
+		registryConfigPath, lookupCredentialHelper = originalPath, originalLookup
+	})
+
+	registryConfigPath = t.TempDir()
+	lookupCredentialHelper = func() (string, error) { return "/app/docker-credential-portainer", nil }
+
+	manager := NewStackManager(nil, "", nil, "")
+	manager.engineType = EngineTypeDockerStandalone
+
+	stack := &edgeStack{
+		StackPayload: edge.StackPayload{ID: 3, RegistryCredentials: []edge.RegistryCredentials{
+			{ServerURL: "registry.example.com:5000", Username: "first"},
+			{ServerURL: "https://ghcr.io/acme", Username: "second"},
+		}},
+		Registries: []string{"docker.io", "registry.example.com:5000", "ghcr.io"},
+	}
+
+	env := manager.registryConfigEnv(stack)
+	folder := filepath.Join(registryConfigPath, "3")
+	require.Equal(t, []string{"DOCKER_CONFIG=" + folder}, env)
+
+	data, err := os.ReadFile(filepath.Join(folder, "config.json"))
+	require.NoError(t, err)
+
+	var config dockerClientConfig
+	require.NoError(t, json.Unmarshal(data, &config))
+	assert.Equal(t, map[string]string{
+		"https://index.docker.io/v1/": "portainer",
+		"registry.example.com:5000":   "portainer",
+		"ghcr.io":                     "portainer",
+	}, config.CredHelpers)
+
+	manager.deleteRegistryCredentials(stack)
+	assert.NoDirExists(t, folder)
+
+	// Nothing is written for the stacks without credentials and for Kubernetes
+	assert.Nil(t, manager.registryConfigEnv(&edgeStack{StackPayload: edge.StackPayload{ID: 4}}))
+
+	manager.engineType = EngineTypeKubernetes
+	assert.Nil(t, manager.registryConfigEnv(stack))
+}
+
+func TestFindRegistryCredentials_normalized(t *testing.T) {
+	hub := edge.RegistryCredentials{ServerURL: "docker.io", Username: "hub"}
+	private := edge.RegistryCredentials{ServerURL: "https://registry.example.com:5000/v2/", Username: "private"}
+	credentials := []edge.RegistryCredentials{hub, private}
+
+	assert.Equal(t, &hub, findRegistryCredentials(credentials, "docker.io"))
+	assert.Equal(t, &hub, findRegistryCredentials(credentials, "https://index.docker.io/v1/"))
+	assert.Equal(t, &private, findRegistryCredentials(credentials, "registry.example.com:5000"))
+	assert.Nil(t, findRegistryCredentials(credentials, "registry.example.com"))
+}
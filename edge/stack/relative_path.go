@@ -0,0 +1,95 @@
+package stack
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/portainer/agent"
+	"github.com/portainer/agent/docker"
+	"github.com/portainer/agent/edge/yaml"
+	agentfs "github.com/portainer/agent/filesystem"
+	"github.com/portainer/portainer/api/edge"
+	"github.com/portainer/portainer/api/filesystem"
+)
+
+// copyRelativePathStackToHost copies the stack files to the same path on the host.
+// Docker engines use the unpacker container, the other engines rely on the host
+// filesystem being mounted inside the agent container.
+func (manager *StackManager) copyRelativePathStackToHost(stack *edgeStack, stackName string) error {
+	switch manager.engineType {
+	case EngineTypeDockerStandalone, EngineTypeDockerSwarm:
+		dst := filepath.Join(stack.FilesystemPath, agent.ComposePathPrefix)
+
+		return docker.CopyGitStackToHost(stack.FileFolder, dst, stack.ID, stackName, manager.assetsPath)
+	}
+
+	dst := mountedHostPath(stack.FileFolder)
+	if err := os.RemoveAll(dst); err != nil {
+		return err
+	}
+
+	if err := filesystem.CopyDir(stack.FileFolder, dst, false); err != nil {
+		return err
+	}
+
+	return agentfs.SyncTree(dst)
+}
+
+func (manager *StackManager) removeRelativePathStackFromHost(stack *edgeStack, stackName string) error {
+	switch manager.engineType {
+	case EngineTypeDockerStandalone, EngineTypeDockerSwarm:
+		dst := filepath.Join(stack.FilesystemPath, agent.ComposePathPrefix)
+
+		return docker.RemoveGitStackFromHost(stack.FileFolder, dst, stack.ID, stackName)
+	}
+
+	return os.RemoveAll(mountedHostPath(stack.FileFolder))
+}
+
+// mountedHostPath returns where the host path is mounted inside the agent container. The volume name of the Windows paths,
+// e.g. C:, is dropped as the host filesystem is mounted as a single folder.
+func mountedHostPath(path string) string {
+	return filepath.Join(agent.HostRoot, strings.TrimPrefix(path, filepath.VolumeName(path)))
+}
+
+// rewriteRelativePaths makes the relative paths of Kubernetes manifests point to the
+// stack folder on the host. Nomad jobs are rewritten by the deployer once parsed.
+func (manager *StackManager) rewriteRelativePaths(stack *edgeStack, stackPayload *edge.StackPayload) error {
+	if !IsRelativePathStack(stack) || manager.engineType != EngineTypeKubernetes {
+		return nil
+	}
+
+	fileContent := entryFileContent(stackPayload)
+	if fileContent == nil {
+		return nil
+	}
+
+	content, err := yaml.NewKubernetesYAML(*fileContent, nil).RewriteRelativeHostPaths(stack.FileFolder)
+	if err != nil {
+		return err
+	}
+
+	*fileContent = content
+
+	return nil
+}
+
+// hostBasePath returns the host folder relative paths are resolved against, empty for regular stacks
+func hostBasePath(stack *edgeStack) string {
+	if !IsRelativePathStack(stack) {
+		return ""
+	}
+
+	return stack.FileFolder
+}
+
+func entryFileContent(stackPayload *edge.StackPayload) *string {
+	for index, dirEntry := range stackPayload.DirEntries {
+		if dirEntry.IsFile && dirEntry.Name == stackPayload.EntryFileName {
+			return &stackPayload.DirEntries[index].Content
+		}
+	}
+
+	return nil
+}
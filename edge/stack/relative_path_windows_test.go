@@ -0,0 +1,28 @@
+//go:build windows
+// +build windows
+
+package stack
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMountedHostPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{`C:\ProgramData\portainer\stacks\1`, `\host\ProgramData\portainer\stacks\1`},
+		{`D:\stacks\1\`, `\host\stacks\1`},
+		{"C:/stacks/1", `\host\stacks\1`},
+		{`\stacks\1`, `\host\stacks\1`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			assert.Equal(t, tt.expected, mountedHostPath(tt.path))
+		})
+	}
+}
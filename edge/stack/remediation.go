@@ -0,0 +1,231 @@
+package stack
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/agent/edge/yaml"
+
+	"github.com/rs/zerolog/log"
+)
+
+// remediationRule attaches a remediation to the errors matching one of its patterns
+type remediationRule struct {
+	code     string
+	patterns []*regexp.Regexp
+	// target returns what the remediation applies to, found in the error or among the registries of the stack
+	target func(message string, registries []string) string
+	hint   func(target string) string
+}
+
+var (
+	urlHostPattern     = regexp.MustCompile(`https?://([^/"\s]+)`)
+	deniedImagePattern = regexp.MustCompile(`(?i)(?:pull access denied for|manifest for|no matching manifest for) ([^\s,]+)`)
+	fullPathPattern    = regexp.MustCompile(`(?i)(?:open|write|mkdir|create|rename|copy) ([^\s:]+): no space left on device`)
+)
+
+// remediationRules are evaluated in order, the first rule matching the error applies
+var remediationRules = []remediationRule{
+	{
+		code: client.StackRemediationImageNotFound,
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)manifest unknown`),
+			regexp.MustCompile(`(?i)manifest for \S+ not found`),
+			regexp.MustCompile(`(?i)no matching manifest for`),
+			regexp.MustCompile(`(?i)invalid reference format`),
+		},
+		target: imageTarget,
+		hint: func(target string) string {
+			return "check the name and the tag of " + subject("the image", target) + ", and that it is published for the platform of the device"
+		},
+	},
+	{
+		code: client.StackRemediationRegistryAuth,
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)pull access denied`),
+			regexp.MustCompile(`(?i)unauthorized`),
+			regexp.MustCompile(`(?i)authentication required`),
+			regexp.MustCompile(`(?i)requested access to the resource is denied`),
+		},
+		target: registryTarget,
+		hint: func(target string) string {
+			return "rotate the credentials of " + subject("the registry", target) + " in Portainer and check that they can pull the images of the stack"
+		},
+	},
+	{
+		code: client.StackRemediationRateLimited,
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)toomanyrequests`),
+			regexp.MustCompile(`(?i)429 too many requests`),
+		},
+		target: registryTarget,
+		hint: func(target string) string {
+			return "authenticate the pulls from " + subject("the registry", target) + " with an account of the registry, or pull the images through a mirror"
+		},
+	},
+	{
+		code: client.StackRemediationRegistryUnreachable,
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)no such host`),
+			regexp.MustCompile(`(?i)connection refused`),
+			regexp.MustCompile(`(?i)network is unreachable`),
+			regexp.MustCompile(`(?i)tls handshake timeout`),
+			regexp.MustCompile(`(?i)dial tcp .*i/o timeout`),
+		},
+		target: registryTarget,
+		hint: func(target string) string {
+			return "check that the device can reach " + subject("the registry", target) + " through its DNS, its firewall and its proxy"
+		},
+	},
+	{
+		code:     client.StackRemediationDiskFull,
+		patterns: []*regexp.Regexp{regexp.MustCompile(`(?i)no space left on device`)},
+		target: func(message string, _ []string) string {
+			if match := fullPathPattern.FindStringSubmatch(message); match != nil {
+				return match[1]
+			}
+
+			return ""
+		},
+		hint: func(target string) string {
+			return "free the disk of " + subject("the device", target) + ": prune the unused images and volumes, or bound the files of the agent with EDGE_STORAGE_QUOTA"
+		},
+	},
+	{
+		code: client.StackRemediationInvalidStackFile,
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)\byaml: `),
+			regexp.MustCompile(`(?i)additional propert(y|ies) .* not allowed`),
+			regexp.MustCompile(`(?i)error validating data`),
+			regexp.MustCompile(`(?i) is invalid: `),
+		},
+		target: func(string, []string) string { return "" },
+		hint: func(string) string {
+			return "fix the stack file rejected by the engine and deploy a new version of the stack"
+		},
+	},
+	{
+		code:     client.StackRemediationForbidden,
+		patterns: []*regexp.Regexp{regexp.MustCompile(`(?i) is forbidden: `)},
+		target:   func(string, []string) string { return "" },
+		hint: func(string) string {
+			return "grant the service account of the agent the permissions the resources of the stack require"
+		},
+	},
+}
+
+// remediationFor returns the remediation of the error the stack failed with, nil when no rule matches it
+func remediationFor(err error, registries []string) *client.StackRemediation {
+	message := err.Error()
+
+	for _, rule := range remediationRules {
+		for _, pattern := range rule.patterns {
+			if !pattern.MatchString(message) {
+				continue
+			}
+
+			target := rule.target(message, registries)
+
+			return &client.StackRemediation{
+				Code:   rule.code,
+				Class:  errorClass(err),
+				Target: target,
+				Hint:   rule.hint(target),
+				Error:  message,
+			}
+		}
+	}
+
+	return nil
+}
+
+// registryTarget returns the registry of the URL or of the image in the error, the registry of the stack when it
+// uses a single one
+func registryTarget(message string, registries []string) string {
+	if match := urlHostPattern.FindStringSubmatch(message); match != nil {
+		return match[1]
+	}
+
+	if image := imageTarget(message, nil); image != "" {
+		if registry, err := yaml.ImageRegistry(image); err == nil {
+			return registry
+		}
+	}
+
+	if len(registries) == 1 {
+		return registries[0]
+	}
+
+	return ""
+}
+
+func imageTarget(message string, _ []string) string {
+	if match := deniedImagePattern.FindStringSubmatch(message); match != nil {
+		return strings.TrimSuffix(match[1], ":")
+	}
+
+	return ""
+}
+
+func subject(noun, target string) string {
+	if target == "" {
+		return noun
+	}
+
+	return noun + " " + target
+}
+
+// reportRemediation reports the remediation of the error the stack failed with, when it differs from the one
+// reported last. The caller must hold the manager lock.
+func (manager *StackManager) reportRemediation(stackID int, err error) {
+	var registries []string
+	if stack, ok := manager.stacks[edgeStackID(stackID)]; ok {
+		registries = stack.Registries
+	}
+
+	remediation := remediationFor(err, registries)
+	if remediation == nil {
+		return
+	}
+
+	if last, ok := manager.remediations[stackID]; ok && last.Code == remediation.Code && last.Target == remediation.Target {
+		return
+	}
+
+	remediation.Time = manager.now().Unix()
+
+	if manager.remediations == nil {
+		manager.remediations = make(map[int]client.StackRemediation)
+	}
+
+	manager.remediations[stackID] = *remediation
+
+	log.Info().
+		Int("stack_identifier", stackID).
+		Str("remediation", remediation.Code).
+		Str("target", remediation.Target).
+		Msg(remediation.Hint)
+
+	if err := manager.portainerClient.SetEdgeStackRemediation(stackID, *remediation); err != nil {
+		log.Warn().Err(err).Int("stack_identifier", stackID).Msg("unable to report the remediation of the stack")
+	}
+}
+
+// resolveRemediation clears the remediation reported for the stack once it is deployed.
+// The caller must hold the manager lock.
+func (manager *StackManager) resolveRemediation(stack *edgeStack, status edgeStackStatus) {
+	if status != StatusDeployed && status != StatusCompleted {
+		return
+	}
+
+	if _, ok := manager.remediations[stack.ID]; !ok {
+		return
+	}
+
+	delete(manager.remediations, stack.ID)
+
+	if err := manager.portainerClient.SetEdgeStackRemediation(stack.ID, client.StackRemediation{Time: manager.now().Unix()}); err != nil {
+		log.Warn().Err(err).Int("stack_identifier", stack.ID).Msg("unable to clear the remediation of the stack")
+	}
+}
@@ -0,0 +1,116 @@
+package stack
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/portainer/agent/clock"
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/agent/internals/mocks"
+	"github.com/portainer/portainer/api/edge"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestRemediationFor(t *testing.T) {
+	for _, tc := range []struct {
+		err        error
+		registries []string
+		code       string
+		target     string
+	}{
+		{
+			err:    errors.New("exit status 1: Head \"https://registry.example.com/v2/app/manifests/1.0\": unauthorized: authentication required"),
+			code:   client.StackRemediationRegistryAuth,
+			target: "registry.example.com",
+		},
+		{
+			err:    errors.New("exit status 1: pull access denied for private/app, repository does not exist or may require 'docker login'"),
+			code:   client.StackRemediationRegistryAuth,
+			target: "docker.io",
+		},
+		{
+			err:        errors.New("exit status 1: unauthorized: incorrect username or password"),
+			registries: []string{"registry.example.com"},
+			code:       client.StackRemediationRegistryAuth,
+			target:     "registry.example.com",
+		},
+		{
+			err:    errors.New("exit status 1: Error response from daemon: manifest for nginx:9.9 not found: manifest unknown"),
+			code:   client.StackRemediationImageNotFound,
+			target: "nginx:9.9",
+		},
+		{
+			err:  errors.New("exit status 1: toomanyrequests: You have reached your pull rate limit"),
+			code: client.StackRemediationRateLimited,
+		},
+		{
+			err:    errors.New("exit status 1: Get \"https://registry.example.com/v2/\": dial tcp: lookup registry.example.com: no such host"),
+			code:   client.StackRemediationRegistryUnreachable,
+			target: "registry.example.com",
+		},
+		{
+			err:    fmt.Errorf("unable to persist the stack files: %w", &fs.PathError{Op: "write", Path: "/var/lib/portainer/1", Err: syscall.ENOSPC}),
+			code:   client.StackRemediationDiskFull,
+			target: "/var/lib/portainer/1",
+		},
+		{
+			err:  errors.New("exit status 1: yaml: line 3: mapping values are not allowed in this context"),
+			code: client.StackRemediationInvalidStackFile,
+		},
+		{
+			err:  errors.New("exit status 1: deployments.apps \"web\" is forbidden: User \"system:serviceaccount:portainer:agent\" cannot patch resource"),
+			code: client.StackRemediationForbidden,
+		},
+	} {
+		remediation := remediationFor(tc.err, tc.registries)
+		require.NotNil(t, remediation, tc.err.Error())
+		assert.Equal(t, tc.code, remediation.Code, tc.err.Error())
+		assert.Equal(t, tc.target, remediation.Target, tc.err.Error())
+		assert.NotEmpty(t, remediation.Hint)
+	}
+
+	assert.Nil(t, remediationFor(errors.New("deploy failed"), nil))
+}
+
+func TestStackManager_reportRemediation(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	portainerClient := mocks.NewMockPortainerClient(ctrl)
+
+	manager := &StackManager{
+		clock:           clock.NewFakeClock(time.Unix(1000, 0)),
+		portainerClient: portainerClient,
+		stacks:          map[edgeStackID]*edgeStack{},
+	}
+
+	stack := &edgeStack{StackPayload: edge.StackPayload{ID: 1}, Status: StatusDeploying, Registries: []string{"registry.example.com"}}
+	manager.stacks[1] = stack
+
+	portainerClient.EXPECT().
+		SetEdgeStackRemediation(1, gomock.Any()).
+		DoAndReturn(func(_ int, remediation client.StackRemediation) error {
+			assert.Equal(t, client.StackRemediationRegistryAuth, remediation.Code)
+			assert.Equal(t, client.StackErrorTerminal, remediation.Class)
+			assert.Equal(t, "registry.example.com", remediation.Target)
+			assert.Equal(t, int64(1000), remediation.Time)
+
+			return nil
+		})
+
+	// The same failure is reported once
+	manager.reportRemediation(1, errors.New("unauthorized: authentication required"))
+	manager.reportRemediation(1, errors.New("unauthorized: authentication required"))
+
+	// The remediation is cleared once the stack is deployed
+	portainerClient.EXPECT().SetEdgeStackRemediation(1, client.StackRemediation{Time: 1000}).Return(nil)
+
+	manager.resolveRemediation(stack, StatusDeployed)
+	manager.resolveRemediation(stack, StatusDeployed)
+
+	assert.Empty(t, manager.remediations)
+}
@@ -0,0 +1,110 @@
+package stack
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/portainer/agent/clock"
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/agent/edge/session"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultReplayActionsPerPoll is how many times the queue of the stacks is served after each replayed poll
+const DefaultReplayActionsPerPoll = 20
+
+// replayEngines are the engines a session can be replayed for, indexed by name
+var replayEngines = map[string]engineType{
+	"docker":     EngineTypeDockerStandalone,
+	"swarm":      EngineTypeDockerSwarm,
+	"kubernetes": EngineTypeKubernetes,
+	"nomad":      EngineTypeNomad,
+	"containerd": EngineTypeContainerd,
+}
+
+var errReplayOffline = errors.New("the large files are not downloaded while a session is replayed")
+
+// SetSessionRecorder records the calls to the deployer in the session, the deployer of the engine set afterwards is
+// recorded. A nil recorder stops the recording.
+func (manager *StackManager) SetSessionRecorder(recorder *session.Recorder) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	manager.sessionRecorder = recorder
+}
+
+// Replay feeds the polls of a recorded session to a new stack manager for the engine, one of docker, swarm,
+// kubernetes, nomad or containerd, offline: the payloads and the
+// outcomes of the deployer are the recorded ones and the clock is set to the time of each poll. The queue of the
+// stacks is served actionsPerPoll times after each poll. It returns the calls issued by the stack manager, to be
+// compared with the ones recorded, see session.Diverge.
+// The stack files are persisted in agent.EdgeStackFilesPath.
+func Replay(entries []session.Entry, engine string, actionsPerPoll int) ([]session.Entry, error) {
+	engineType, ok := replayEngines[engine]
+	if !ok {
+		return nil, fmt.Errorf("unsupported engine: %s", engine)
+	}
+
+	polls, err := session.Polls(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(polls) == 0 {
+		return nil, errors.New("the session does not hold any poll of the server")
+	}
+
+	fakeClock := clock.NewFakeClock(polls[0].Time)
+
+	replayer, err := session.NewReplayer(entries, fakeClock)
+	if err != nil {
+		return nil, err
+	}
+
+	manager := NewStackManager(replayer.Client(), "", nil, "replay")
+	manager.clock = fakeClock
+	manager.engineType = engineType
+	manager.deployer = replayer.Deployer()
+	manager.nativeDeployer = manager.deployer
+	manager.largeFileClient = &http.Client{Transport: offlineTransport{}}
+	manager.isEnabled = true
+
+	for _, poll := range polls {
+		if elapsed := poll.Time.Sub(fakeClock.Now()); elapsed > 0 {
+			fakeClock.Advance(elapsed)
+		}
+
+		if len(poll.Response.AsyncCommands) > 0 {
+			log.Warn().Time("poll_time", poll.Time).Msg("the async commands of the poll are not replayed")
+		}
+
+		manager.SetStatusWaitDefaults(poll.Response.StackStatusTimeout, poll.Response.StackStatusCheckInterval)
+		manager.SetRecovery(poll.Response.StackRecovery)
+
+		if poll.Response.Stacks != nil {
+			stacks := map[int]client.StackStatus{}
+			for _, s := range poll.Response.Stacks {
+				stacks[s.ID] = s
+			}
+
+			if err := manager.UpdateStacksStatus(stacks); err != nil {
+				log.Error().Err(err).Time("poll_time", poll.Time).Msg("an error occurred during stack management")
+			}
+		}
+
+		for i := 0; i < actionsPerPoll; i++ {
+			manager.performActionOnStack()
+		}
+	}
+
+	return replayer.Calls(), nil
+}
+
+// offlineTransport fails the downloads of a replayed session
+type offlineTransport struct{}
+
+func (offlineTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errReplayOffline
+}
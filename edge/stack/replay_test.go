@@ -0,0 +1,55 @@
+package stack
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/portainer/agent"
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/agent/edge/session"
+	"github.com/portainer/agent/edge/stack/stacktest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplay(t *testing.T) {
+	filesPath := agent.EdgeStackFilesPath
+	agent.EdgeStackFilesPath = t.TempDir()
+	defer func() { agent.EdgeStackFilesPath = filesPath }()
+
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+
+	recorder, err := session.NewRecorder(path, 0)
+	require.NoError(t, err)
+
+	fixture := stacktest.Get(t, "compose")
+	version := fixture.Payload.Version
+
+	recorder.Record(session.KindClient, session.MethodGetEnvironmentStatus, nil, &client.PollStatusResponse{
+		Stacks: []client.StackStatus{{ID: fixture.Payload.ID, Version: version}},
+	}, nil)
+	recorder.Record(session.KindClient, session.MethodGetEdgeStackConfig, session.StackArgs{EdgeStackID: fixture.Payload.ID, Version: &version}, fixture.EncodedPayload(), nil)
+	recorder.Record(session.KindDeployer, session.MethodDeploy, session.DeployerArgs{Name: "edge_web"}, nil, errors.New("context deadline exceeded"))
+	require.NoError(t, recorder.Close())
+
+	entries, err := session.Read(path)
+	require.NoError(t, err)
+
+	calls, err := Replay(entries, "docker", 5)
+	require.NoError(t, err)
+	require.NotEmpty(t, calls)
+
+	last := calls[len(calls)-1]
+	assert.Equal(t, session.MethodSetEdgeStackStatus, last.Method)
+	assert.JSONEq(t, `{"edgeStackID":1,"status":2,"error":"failed to redeploy stack: context deadline exceeded","errorClass":"transient"}`, string(last.Args))
+
+	// The replay is deterministic
+	again, err := Replay(entries, "docker", 5)
+	require.NoError(t, err)
+	assert.Equal(t, -1, session.Diverge(calls, again))
+
+	_, err = Replay(entries, "podman", 5)
+	assert.Error(t, err)
+}
@@ -0,0 +1,122 @@
+package stack
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/portainer/agent/docker"
+	"github.com/portainer/agent/edge/client"
+	portainer "github.com/portainer/portainer/api"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ErrStackUnschedulable is returned when the device does not meet the requirements of a stack
+var ErrStackUnschedulable = errors.New("unschedulable")
+
+// DeviceCapabilities describes the device the stacks are deployed on
+type DeviceCapabilities struct {
+	Arch string
+	// Memory is the total memory of the device in bytes, 0 when unknown
+	Memory int64
+	// GPU and Privileged are nil when unknown
+	GPU        *bool
+	Privileged *bool
+}
+
+// runtimeCapabilities returns the capabilities known without querying the container engine
+func runtimeCapabilities() (DeviceCapabilities, error) {
+	return DeviceCapabilities{Arch: runtime.GOARCH}, nil
+}
+
+// dockerCapabilities returns the capabilities of the device reported by the Docker engine
+func dockerCapabilities() (DeviceCapabilities, error) {
+	info, err := docker.GetInfo()
+	if err != nil {
+		return DeviceCapabilities{}, err
+	}
+
+	_, gpu := info.Runtimes["nvidia"]
+
+	privileged := true
+	for _, option := range info.SecurityOptions {
+		if strings.Contains(option, "name=rootless") {
+			privileged = false
+		}
+	}
+
+	return DeviceCapabilities{
+		Arch:       runtime.GOARCH,
+		Memory:     info.MemTotal,
+		GPU:        &gpu,
+		Privileged: &privileged,
+	}, nil
+}
+
+// unmetRequirement returns why the device does not meet the requirements, an empty string when it does.
+// The requirements that cannot be evaluated because the capability is unknown are ignored.
+func unmetRequirement(requirements client.StackRequirements, capabilities DeviceCapabilities) string {
+	if len(requirements.Arch) > 0 && !slices.Contains(requirements.Arch, capabilities.Arch) {
+		return fmt.Sprintf("architecture %s is not one of %s", capabilities.Arch, strings.Join(requirements.Arch, ", "))
+	}
+
+	if requirements.MinMemory > 0 && capabilities.Memory > 0 && capabilities.Memory < requirements.MinMemory {
+		return fmt.Sprintf("%d bytes of memory available, %d required", capabilities.Memory, requirements.MinMemory)
+	}
+
+	if requirements.GPU && capabilities.GPU != nil && !*capabilities.GPU {
+		return "no GPU available"
+	}
+
+	if requirements.Privileged && capabilities.Privileged != nil && !*capabilities.Privileged {
+		return "privileged containers are not allowed"
+	}
+
+	return ""
+}
+
+// checkStackRequirements returns an ErrStackUnschedulable error when the device does not meet the requirements.
+// The capabilities are checked on a best effort basis, the stack is deployed when they cannot be retrieved.
+func (manager *StackManager) checkStackRequirements(requirements *client.StackRequirements) error {
+	if requirements == nil || manager.capabilities == nil {
+		return nil
+	}
+
+	capabilities, err := manager.capabilities()
+	if err != nil {
+		log.Warn().Err(err).Msg("unable to retrieve the capabilities of the device")
+
+		return nil
+	}
+
+	if reason := unmetRequirement(*requirements, capabilities); reason != "" {
+		return fmt.Errorf("%w: %s", ErrStackUnschedulable, reason)
+	}
+
+	return nil
+}
+
+// markUnschedulable moves the stack to StatusError, the stack is not deployed until a new version is received.
+// The caller must hold the manager lock.
+func (manager *StackManager) markUnschedulable(stack *edgeStack, err error) {
+	log.Error().Err(err).Int("stack_identifier", stack.ID).Str("stack_name", stack.Name).Msg("unable to deploy the stack")
+
+	stack.Action = actionIdle
+
+	manager.putStack(stack)
+	manager.setStatusMessage(stack, StatusError, err.Error())
+	runHooks(hookError, stack, err.Error())
+}
+
+// failUnschedulable marks the stack as unschedulable and reports it to the server.
+// The caller must hold the manager lock.
+func (manager *StackManager) failUnschedulable(stack *edgeStack, err error) {
+	manager.markUnschedulable(stack, err)
+
+	if err := manager.portainerClient.SetEdgeStackStatus(stack.ID, portainer.EdgeStackStatusError, stack.RollbackTo, err.Error()); err != nil {
+		log.Error().Err(err).Int("stack_identifier", stack.ID).Msg("unable to update Edge stack status")
+	}
+}
@@ -0,0 +1,58 @@
+package stack
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/portainer/api/edge"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnmetRequirement(t *testing.T) {
+	yes, no := true, false
+
+	capabilities := DeviceCapabilities{Arch: "arm64", Memory: 1 << 30, GPU: &no, Privileged: &yes}
+
+	assert.Empty(t, unmetRequirement(client.StackRequirements{Arch: []string{"amd64", "arm64"}, MinMemory: 512 << 20, Privileged: true}, capabilities))
+	assert.Equal(t, "architecture arm64 is not one of amd64", unmetRequirement(client.StackRequirements{Arch: []string{"amd64"}}, capabilities))
+	assert.Equal(t, "1073741824 bytes of memory available, 2147483648 required", unmetRequirement(client.StackRequirements{MinMemory: 2 << 30}, capabilities))
+	assert.Equal(t, "no GPU available", unmetRequirement(client.StackRequirements{GPU: true}, capabilities))
+
+	// Unknown capabilities are not checked
+	assert.Empty(t, unmetRequirement(client.StackRequirements{MinMemory: 2 << 30, GPU: true, Privileged: true}, DeviceCapabilities{Arch: "arm64"}))
+}
+
+func TestStackManager_checkStackRequirements(t *testing.T) {
+	rootless := false
+
+	manager := &StackManager{
+		capabilities: func() (DeviceCapabilities, error) {
+			return DeviceCapabilities{Arch: "amd64", Privileged: &rootless}, nil
+		},
+	}
+
+	assert.NoError(t, manager.checkStackRequirements(nil))
+
+	err := manager.checkStackRequirements(&client.StackRequirements{Privileged: true})
+	require.ErrorIs(t, err, ErrStackUnschedulable)
+	assert.Equal(t, "unschedulable: privileged containers are not allowed", err.Error())
+
+	// The stack is deployed when the capabilities cannot be retrieved
+	manager.capabilities = func() (DeviceCapabilities, error) {
+		return DeviceCapabilities{}, errors.New("engine unavailable")
+	}
+
+	assert.NoError(t, manager.checkStackRequirements(&client.StackRequirements{Privileged: true}))
+}
+
+func TestStackManager_markUnschedulable(t *testing.T) {
+	manager := &StackManager{stacks: map[edgeStackID]*edgeStack{}}
+
+	stack := &edgeStack{StackPayload: edge.StackPayload{ID: 1, Name: "web"}, Status: StatusPending, Action: actionDeploy}
+	manager.markUnschedulable(stack, ErrStackUnschedulable)
+
+	assert.Equal(t, StatusError, manager.stacks[1].Status)
+	assert.Equal(t, actionIdle, manager.stacks[1].Action)
+}
@@ -0,0 +1,131 @@
+package stack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/portainer/agent"
+	portainer "github.com/portainer/portainer/api"
+
+	"github.com/rs/zerolog/log"
+)
+
+// restartedMessage is reported with the DeploymentReceived status of a stack restarted without a new version
+const restartedMessage = "restarted"
+
+// ErrRestartNotSupported is returned when the deployer of the stack cannot restart its workloads
+var ErrRestartNotSupported = errors.New("restarting the stack is not supported by the engine")
+
+// stackRestarter is implemented by the deployers able to restart the workloads of a deployed stack in place, the same
+// way as the docker compose restart or the kubectl rollout restart commands
+type stackRestarter interface {
+	Restart(ctx context.Context, name string, filePaths []string, options agent.DeployOptions) error
+}
+
+// RestartStack restarts the workloads of a deployed stack without changing its version, e.g. to bounce an
+// application after a change of the host
+func (manager *StackManager) RestartStack(stackID int) error {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	return manager.requestRestart(stackID, manager.now().Unix())
+}
+
+// requestRestart queues the restart of the stack requested at requestedAt. Only the deployed stacks are restarted,
+// the restarts requested while a version is being deployed are dropped. The caller must hold the manager lock.
+func (manager *StackManager) requestRestart(stackID int, requestedAt int64) error {
+	originalStack, ok := manager.stacks[edgeStackID(stackID)]
+	if !ok {
+		return fmt.Errorf("stack %d not found", stackID)
+	}
+
+	originalStack.RestartedAt = max(originalStack.RestartedAt, requestedAt)
+
+	if _, ok := manager.deployerFor(originalStack).(stackRestarter); !ok {
+		return ErrRestartNotSupported
+	}
+
+	if !deployedIdle(originalStack) {
+		return fmt.Errorf("stack %d is not deployed, it cannot be restarted", stackID)
+	}
+
+	log.Info().Int("stack_identifier", stackID).Int("stack_version", originalStack.Version).Msg("restarting stack")
+
+	clonedStack := *originalStack
+	stack := &clonedStack
+
+	stack.Action = actionRestart
+	manager.setStatus(stack, StatusPending)
+
+	manager.putStack(stack)
+
+	return nil
+}
+
+// deployedIdle returns whether the stack is deployed and not being changed
+func deployedIdle(stack *edgeStack) bool {
+	if stack.Action != actionIdle || stack.DeployedProject == "" {
+		return false
+	}
+
+	switch stack.Status {
+	case StatusDeployed, StatusDegraded, StatusAwaitingDeployedStatus:
+		return true
+	}
+
+	return false
+}
+
+// restartStack restarts the workloads of the stack deployed as stackName, its status is then checked the same way as
+// after a deployment. The caller must not hold the manager lock.
+func (manager *StackManager) restartStack(ctx context.Context, stack *edgeStack, stackName, stackFileLocation string) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	if err := manager.portainerClient.SetEdgeStackStatus(stack.ID, portainer.EdgeStackStatusDeploying, stack.RollbackTo, ""); err != nil {
+		log.Error().Err(err).Msg("unable to update Edge stack status")
+	}
+
+	manager.setStatus(stack, StatusDeploying)
+
+	stack.Action = actionIdle
+	stack.RestartSamples = nil
+
+	err := ErrRestartNotSupported
+	if restarter, ok := manager.deployerFor(stack).(stackRestarter); ok {
+		err = restarter.Restart(ctx, stackName, []string{stackFileLocation}, agent.DeployOptions{
+			DeployerBaseOptions: agent.DeployerBaseOptions{
+				Namespace:  stack.Namespace,
+				WorkingDir: stack.FileFolder,
+				Env:        manager.deployerEnv(stack),
+				Proxy:      manager.deployProxy,
+			},
+			HostBasePath: hostBasePath(stack),
+		})
+	}
+
+	if err != nil {
+		class := errorClass(err)
+
+		log.Error().Err(err).Int("stack_identifier", stack.ID).Str("error_class", class).Msg("stack restart failed")
+
+		manager.reportRemediation(stack.ID, err)
+		manager.setStatusMessage(stack, StatusError, err.Error())
+		runHooks(hookError, stack, err.Error())
+
+		if err := manager.portainerClient.SetEdgeStackError(stack.ID, stack.RollbackTo, fmt.Errorf("failed to restart stack: %w", err).Error(), class); err != nil {
+			log.Error().Err(err).Msg("unable to update Edge stack status")
+		}
+
+		return
+	}
+
+	log.Debug().Int("stack_identifier", stack.ID).Int("stack_version", stack.Version).Msg("stack restarted")
+
+	if err := manager.portainerClient.SetEdgeStackStatus(stack.ID, portainer.EdgeStackStatusDeploymentReceived, stack.RollbackTo, restartedMessage); err != nil {
+		log.Error().Err(err).Msg("unable to update Edge stack status")
+	}
+
+	manager.setStatus(stack, StatusAwaitingDeployedStatus)
+}
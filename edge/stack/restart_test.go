@@ -0,0 +1,101 @@
+package stack
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/portainer/agent"
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/agent/internals/mocks"
+	portainer "github.com/portainer/portainer/api"
+	"github.com/portainer/portainer/api/edge"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+type restartingDeployer struct {
+	*mocks.MockDeployer
+
+	restarted []string
+	err       error
+}
+
+func (d *restartingDeployer) Restart(ctx context.Context, name string, filePaths []string, options agent.DeployOptions) error {
+	d.restarted = append(d.restarted, name)
+
+	return d.err
+}
+
+func TestStackManager_RestartStack(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	manager := NewStackManager(mocks.NewMockPortainerClient(ctrl), "", nil, "")
+	manager.deployer = &restartingDeployer{MockDeployer: mocks.NewMockDeployer(ctrl)}
+	manager.stacks[1] = &edgeStack{StackPayload: edge.StackPayload{ID: 1, Version: 2}, Status: StatusDeployed, Action: actionIdle, DeployedProject: "edge_web"}
+	manager.stacks[2] = &edgeStack{StackPayload: edge.StackPayload{ID: 2, Version: 1}, Status: StatusPending, Action: actionDeploy}
+
+	require.NoError(t, manager.RestartStack(1))
+	assert.Equal(t, actionRestart, manager.stacks[1].Action)
+	assert.Equal(t, StatusPending, manager.stacks[1].Status)
+	assert.Equal(t, 2, manager.stacks[1].Version)
+	assert.NotZero(t, manager.stacks[1].RestartedAt)
+
+	// A stack being deployed is not restarted
+	assert.Error(t, manager.RestartStack(2))
+	assert.Equal(t, actionDeploy, manager.stacks[2].Action)
+
+	assert.Error(t, manager.RestartStack(3))
+
+	manager.deployer = mocks.NewMockDeployer(ctrl)
+	manager.stacks[1].Action = actionIdle
+	manager.stacks[1].Status = StatusDeployed
+
+	assert.ErrorIs(t, manager.RestartStack(1), ErrRestartNotSupported)
+}
+
+func TestStackManager_restartStack(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockPortainerClient := mocks.NewMockPortainerClient(ctrl)
+	deployer := &restartingDeployer{MockDeployer: mocks.NewMockDeployer(ctrl)}
+
+	manager := NewStackManager(mockPortainerClient, "", nil, "")
+	manager.deployer = deployer
+
+	stack := &edgeStack{StackPayload: edge.StackPayload{ID: 1, Version: 2}, Status: StatusPending, Action: actionRestart, DeployedProject: "edge_web"}
+
+	mockPortainerClient.EXPECT().SetEdgeStackStatus(1, portainer.EdgeStackStatusDeploying, nil, "").Return(nil).Times(2)
+	mockPortainerClient.EXPECT().SetEdgeStackStatus(1, portainer.EdgeStackStatusDeploymentReceived, nil, restartedMessage).Return(nil)
+
+	manager.restartStack(context.Background(), stack, "edge_web", "docker-compose.yml")
+	assert.Equal(t, []string{"edge_web"}, deployer.restarted)
+	assert.Equal(t, actionIdle, stack.Action)
+	assert.Equal(t, StatusAwaitingDeployedStatus, stack.Status)
+
+	deployer.err = errors.New("boom")
+	stack.Action = actionRestart
+	stack.Status = StatusPending
+
+	mockPortainerClient.EXPECT().SetEdgeStackError(1, nil, "failed to restart stack: boom", client.StackErrorTransient).Return(nil)
+
+	manager.restartStack(context.Background(), stack, "edge_web", "docker-compose.yml")
+	assert.Equal(t, actionIdle, stack.Action)
+	assert.Equal(t, StatusError, stack.Status)
+}
+
+func TestDesiredStackOperation_restart(t *testing.T) {
+	stack := &edgeStack{StackPayload: edge.StackPayload{ID: 1, Version: 2}, RestartedAt: 100}
+
+	op, ok := desiredStackOperation(stack, client.StackStatus{ID: 1, Version: 2, RestartRequestedAt: 200})
+	assert.True(t, ok)
+	assert.Equal(t, operationRestart, op)
+
+	_, ok = desiredStackOperation(stack, client.StackStatus{ID: 1, Version: 2, RestartRequestedAt: 100})
+	assert.False(t, ok)
+
+	// A new version is deployed instead of restarting the stack
+	op, ok = desiredStackOperation(stack, client.StackStatus{ID: 1, Version: 3, RestartRequestedAt: 200})
+	assert.True(t, ok)
+	assert.Equal(t, operationUpdate, op)
+}
@@ -0,0 +1,288 @@
+package stack
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/portainer/agent/docker"
+	"github.com/portainer/agent/edge/client"
+	agentfs "github.com/portainer/agent/filesystem"
+	portainer "github.com/portainer/portainer/api"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// RetentionKeep keeps the resources of a removed stack, it is the default
+	RetentionKeep = "keep"
+	// RetentionRemove removes the resources of a removed stack
+	RetentionRemove = "remove"
+)
+
+// DefaultCleanupHookTimeout is how long the cleanup hook of a removed stack can run when the stack does not set it
+const DefaultCleanupHookTimeout = 5 * time.Minute
+
+// cleanupHookVolumesFolder is where the named volumes of the stack are mounted in the cleanup hook container
+const cleanupHookVolumesFolder = "/volumes"
+
+// retentionRuntime lists, archives, cleans up and removes the resources left behind by the removed stacks
+type retentionRuntime interface {
+	// StackResources returns the named volumes and the networks created by the stack
+	StackResources(stackID int) ([]docker.LabeledResource, error)
+	// AnonymousVolumes returns the anonymous volumes mounted by the containers of the stack
+	AnonymousVolumes(stackID int) ([]string, error)
+	Archive(volume string, w io.Writer) error
+	RunHook(hook client.StackCleanupHook, env, binds []string, timeout time.Duration) error
+	Remove(resource docker.LabeledResource) error
+}
+
+type dockerRetention struct{}
+
+func (dockerRetention) StackResources(stackID int) ([]docker.LabeledResource, error) {
+	resources, err := docker.GetLabeledResources(StackIDLabel + "=" + strconv.Itoa(stackID))
+	if err != nil {
+		return nil, err
+	}
+
+	var left []docker.LabeledResource
+	for _, resource := range resources {
+		if resource.Kind == docker.ResourceVolume || resource.Kind == docker.ResourceNetwork {
+			left = append(left, resource)
+		}
+	}
+
+	return left, nil
+}
+
+func (dockerRetention) AnonymousVolumes(stackID int) ([]string, error) {
+	return docker.GetAnonymousVolumes(StackIDLabel + "=" + strconv.Itoa(stackID))
+}
+
+func (dockerRetention) Archive(volume string, w io.Writer) error {
+	return docker.ArchiveVolume(volume, w)
+}
+
+func (dockerRetention) RunHook(hook client.StackCleanupHook, env, binds []string, timeout time.Duration) error {
+	return docker.RunContainer(hook.Image, hook.Command, env, binds, timeout)
+}
+
+func (dockerRetention) Remove(resource docker.LabeledResource) error {
+	return docker.RemoveResource(resource)
+}
+
+// SetArchivePath sets the folder the volumes of the removed stacks are archived to
+func (manager *StackManager) SetArchivePath(path string) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	manager.archivePath = path
+}
+
+// retentionEnabled returns true when the retention policy does more than keeping the resources of the stack
+func retentionEnabled(retention *client.StackRetention) bool {
+	return retention != nil && (retention.Volumes == RetentionRemove ||
+		retention.AnonymousVolumes == RetentionRemove ||
+		retention.Networks == RetentionRemove ||
+		retention.Archive ||
+		retention.CleanupHook != nil)
+}
+
+// collectAnonymousVolumes records the anonymous volumes of the stack before it is removed, they can
+// no longer be told apart from the other unused volumes once its containers are gone.
+// The caller must hold the manager lock.
+func (manager *StackManager) collectAnonymousVolumes(stack *edgeStack) {
+	stack.AnonymousVolumes = nil
+
+	if manager.retention == nil || stack.Retention == nil || stack.Retention.AnonymousVolumes != RetentionRemove {
+		return
+	}
+
+	volumes, err := manager.retention.AnonymousVolumes(stack.ID)
+	if err != nil {
+		log.Error().Err(err).Int("stack_identifier", stack.ID).Msg("unable to list the anonymous volumes of the stack")
+
+		return
+	}
+
+	stack.AnonymousVolumes = volumes
+}
+
+// applyRetention runs the cleanup hook of a removed stack, archives its named volumes, then removes the resources
+// it left behind according to its retention policy. The resources about to be removed are reported to the server
+// first, a dry run stops there. The volumes are kept when the hook or an archive fails. It returns the message
+// reported to the server along with the removal, empty when the policy was applied.
+// The caller must hold the manager lock.
+func (manager *StackManager) applyRetention(stack *edgeStack) string {
+	retention := stack.Retention
+	if !retentionEnabled(retention) {
+		return ""
+	}
+
+	if manager.retention == nil {
+		log.Warn().Int("stack_identifier", stack.ID).Msg("the data retention policy is not supported by the engine, the resources are kept")
+
+		return "data retention policy not supported by the engine, resources kept"
+	}
+
+	resources, err := manager.retention.StackResources(stack.ID)
+	if err != nil {
+		log.Error().Err(err).Int("stack_identifier", stack.ID).Msg("unable to list the resources of the removed stack")
+
+		return fmt.Sprintf("unable to list the resources, resources kept: %s", err)
+	}
+
+	var volumes []string
+	var networks []docker.LabeledResource
+	for _, resource := range resources {
+		if resource.Kind == docker.ResourceVolume {
+			volumes = append(volumes, resource.Name)
+		} else {
+			networks = append(networks, resource)
+		}
+	}
+
+	if retention.CleanupHook != nil {
+		if err := manager.runCleanupHook(stack, *retention.CleanupHook, volumes); err != nil {
+			log.Error().Err(err).Int("stack_identifier", stack.ID).Msg("cleanup hook of the removed stack failed")
+
+			return fmt.Sprintf("cleanup hook failed, resources kept: %s", err)
+		}
+	}
+
+	if retention.Archive {
+		for _, volume := range volumes {
+			if err := manager.archiveVolume(stack, volume); err != nil {
+				log.Error().Err(err).Int("stack_identifier", stack.ID).Str("volume", volume).Msg("unable to archive the volume")
+
+				return fmt.Sprintf("unable to archive the volume %s, resources kept: %s", volume, err)
+			}
+		}
+	}
+
+	var removals []docker.LabeledResource
+	if retention.Volumes == RetentionRemove {
+		for _, volume := range volumes {
+			removals = append(removals, docker.LabeledResource{Kind: docker.ResourceVolume, ID: volume, Name: volume})
+		}
+	}
+
+	if retention.AnonymousVolumes == RetentionRemove {
+		for _, volume := range stack.AnonymousVolumes {
+			removals = append(removals, docker.LabeledResource{Kind: docker.ResourceVolume, ID: volume, Name: volume})
+		}
+	}
+
+	if retention.Networks == RetentionRemove {
+		removals = append(removals, networks...)
+	}
+
+	if len(removals) == 0 {
+		return ""
+	}
+
+	listing := describeResources(removals)
+
+	if retention.DryRun {
+		log.Info().Int("stack_identifier", stack.ID).Str("resources", listing).Msg("dry run of the retention policy of the removed stack")
+
+		return "dry run, the retention policy would remove " + listing
+	}
+
+	if err := manager.portainerClient.SetEdgeStackStatus(stack.ID, portainer.EdgeStackStatusRemoving, stack.RollbackTo, "removing "+listing); err != nil {
+		log.Error().Err(err).Int("stack_identifier", stack.ID).Msg("unable to update Edge stack status")
+	}
+
+	var failed []docker.LabeledResource
+	for _, resource := range removals {
+		if err := manager.retention.Remove(resource); err != nil {
+			log.Error().Err(err).Int("stack_identifier", stack.ID).Str("resource", resource.Name).Msg("unable to remove the resource")
+
+			failed = append(failed, resource)
+
+			continue
+		}
+
+		log.Info().
+			Int("stack_identifier", stack.ID).
+			Str("kind", string(resource.Kind)).
+			Str("resource", resource.Name).
+			Msg("resource of the removed stack removed")
+	}
+
+	if len(failed) > 0 {
+		return "unable to remove " + describeResources(failed)
+	}
+
+	return ""
+}
+
+// describeResources lists the resources for the messages reported to the server, e.g. "volume web_data, network web_default"
+func describeResources(resources []docker.LabeledResource) string {
+	parts := make([]string, 0, len(resources))
+	for _, resource := range resources {
+		parts = append(parts, string(resource.Kind)+" "+resource.Name)
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+func (manager *StackManager) runCleanupHook(stack *edgeStack, hook client.StackCleanupHook, volumes []string) error {
+	timeout := hook.Timeout
+	if timeout <= 0 {
+		timeout = DefaultCleanupHookTimeout
+	}
+
+	binds := make([]string, 0, len(volumes))
+	for _, volume := range volumes {
+		binds = append(binds, volume+":"+cleanupHookVolumesFolder+"/"+volume)
+	}
+
+	log.Info().Int("stack_identifier", stack.ID).Str("image", hook.Image).Msg("running the cleanup hook of the removed stack")
+
+	return manager.retention.RunHook(hook, buildEnvVarsForDeployer(stack.EnvVars), binds, timeout)
+}
+
+// archiveVolume writes the volume to a tarball of the archive folder, the tarball is only
+// moved to its final name once complete
+func (manager *StackManager) archiveVolume(stack *edgeStack, volume string) error {
+	if manager.archivePath == "" {
+		return errors.New("no archive folder configured")
+	}
+
+	if err := os.MkdirAll(manager.archivePath, 0700); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d-%s-%s.tar.gz", stack.ID, volume, manager.now().UTC().Format("20060102T150405Z"))
+	tmpPath := filepath.Join(manager.archivePath, name+".tmp")
+
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	err = manager.retention.Archive(volume, file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(tmpPath)
+
+		return err
+	}
+
+	if err := agentfs.RenameFile(tmpPath, filepath.Join(manager.archivePath, name)); err != nil {
+		return err
+	}
+
+	log.Info().Int("stack_identifier", stack.ID).Str("volume", volume).Str("archive", name).Msg("volume of the removed stack archived")
+
+	return nil
+}
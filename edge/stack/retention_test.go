@@ -0,0 +1,158 @@
+package stack
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/portainer/agent/clock"
+	"github.com/portainer/agent/docker"
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/agent/internals/mocks"
+	portainer "github.com/portainer/portainer/api"
+	"github.com/portainer/portainer/api/edge"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gomock "go.uber.org/mock/gomock"
+)
+
+type fakeRetention struct {
+	volumes   []string
+	networks  []string
+	anonymous []string
+	hookErr   error
+	hookRuns  int
+	binds     []string
+	removed   []string
+}
+
+func (f *fakeRetention) StackResources(stackID int) ([]docker.LabeledResource, error) {
+	var resources []docker.LabeledResource
+	for _, volume := range f.volumes {
+		resources = append(resources, docker.LabeledResource{Kind: docker.ResourceVolume, ID: volume, Name: volume})
+	}
+
+	for _, network := range f.networks {
+		resources = append(resources, docker.LabeledResource{Kind: docker.ResourceNetwork, ID: "id-" + network, Name: network})
+	}
+
+	return resources, nil
+}
+
+func (f *fakeRetention) AnonymousVolumes(stackID int) ([]string, error) {
+	return f.anonymous, nil
+}
+
+func (f *fakeRetention) Archive(volume string, w io.Writer) error {
+	_, err := w.Write([]byte(volume))
+
+	return err
+}
+
+func (f *fakeRetention) RunHook(hook client.StackCleanupHook, env, binds []string, timeout time.Duration) error {
+	f.hookRuns++
+	f.binds = binds
+
+	return f.hookErr
+}
+
+func (f *fakeRetention) Remove(resource docker.LabeledResource) error {
+	f.removed = append(f.removed, resource.Name)
+
+	return nil
+}
+
+func TestStackManager_applyRetention(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClient := mocks.NewMockPortainerClient(ctrl)
+
+	stack := &edgeStack{StackPayload: edge.StackPayload{ID: 1}}
+
+	t.Run("Volumes are kept without a policy", func(t *testing.T) {
+		volumes := &fakeRetention{volumes: []string{"web_data"}}
+		manager := &StackManager{retention: volumes}
+
+		assert.Empty(t, manager.applyRetention(stack))
+		assert.Empty(t, volumes.removed)
+	})
+
+	t.Run("Volumes are archived and removed after the cleanup hook", func(t *testing.T) {
+		archivePath := t.TempDir()
+		volumes := &fakeRetention{volumes: []string{"web_data", "web_cache"}}
+		manager := &StackManager{
+			portainerClient: mockClient,
+			retention:       volumes,
+			archivePath:     archivePath,
+			clock:           clock.NewFakeClock(time.Unix(0, 0)),
+		}
+
+		mockClient.EXPECT().SetEdgeStackStatus(1, portainer.EdgeStackStatusRemoving, nil, "removing volume web_data, volume web_cache").Times(1)
+
+		stack.Retention = &client.StackRetention{
+			Volumes:     RetentionRemove,
+			Archive:     true,
+			CleanupHook: &client.StackCleanupHook{Image: "alpine"},
+		}
+
+		assert.Empty(t, manager.applyRetention(stack))
+		assert.Equal(t, 1, volumes.hookRuns)
+		assert.Equal(t, []string{"web_data:/volumes/web_data", "web_cache:/volumes/web_cache"}, volumes.binds)
+		assert.Equal(t, []string{"web_data", "web_cache"}, volumes.removed)
+
+		entries, err := os.ReadDir(archivePath)
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+		assert.Equal(t, "1-web_cache-19700101T000000Z.tar.gz", entries[0].Name())
+	})
+
+	t.Run("Volumes are kept when the cleanup hook fails", func(t *testing.T) {
+		volumes := &fakeRetention{volumes: []string{"web_data"}, hookErr: errors.New("exit code 1")}
+		manager := &StackManager{retention: volumes}
+
+		stack.Retention = &client.StackRetention{
+			Volumes:     RetentionRemove,
+			CleanupHook: &client.StackCleanupHook{Image: "alpine"},
+		}
+
+		assert.Contains(t, manager.applyRetention(stack), "resources kept")
+		assert.Empty(t, volumes.removed)
+	})
+
+	t.Run("Volumes are kept when they cannot be archived", func(t *testing.T) {
+		volumes := &fakeRetention{volumes: []string{"web_data"}}
+		manager := &StackManager{retention: volumes}
+
+		stack.Retention = &client.StackRetention{Volumes: RetentionRemove, Archive: true}
+
+		assert.Contains(t, manager.applyRetention(stack), "resources kept")
+		assert.Empty(t, volumes.removed)
+	})
+
+	t.Run("Dangling networks and anonymous volumes are only listed by a dry run", func(t *testing.T) {
+		volumes := &fakeRetention{volumes: []string{"web_data"}, networks: []string{"web_default"}, anonymous: []string{"3f2a"}}
+		manager := &StackManager{retention: volumes}
+
+		stack.Retention = &client.StackRetention{AnonymousVolumes: RetentionRemove, Networks: RetentionRemove, DryRun: true}
+		manager.collectAnonymousVolumes(stack)
+
+		assert.Equal(t, "dry run, the retention policy would remove volume 3f2a, network web_default", manager.applyRetention(stack))
+		assert.Empty(t, volumes.removed)
+	})
+
+	t.Run("Dangling networks and anonymous volumes are removed", func(t *testing.T) {
+		volumes := &fakeRetention{volumes: []string{"web_data"}, networks: []string{"web_default"}, anonymous: []string{"3f2a"}}
+		manager := &StackManager{portainerClient: mockClient, retention: volumes}
+
+		stack.Retention = &client.StackRetention{AnonymousVolumes: RetentionRemove, Networks: RetentionRemove}
+		manager.collectAnonymousVolumes(stack)
+
+		mockClient.EXPECT().SetEdgeStackStatus(1, portainer.EdgeStackStatusRemoving, nil, gomock.Any()).Times(1)
+
+		assert.Empty(t, manager.applyRetention(stack))
+		assert.Equal(t, []string{"3f2a", "web_default"}, volumes.removed)
+	})
+}
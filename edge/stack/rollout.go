@@ -0,0 +1,92 @@
+package stack
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/portainer/agent/edge/client"
+	portainer "github.com/portainer/portainer/api"
+
+	"github.com/rs/zerolog/log"
+)
+
+// rolloutRecheckInterval is how long a deferred stack version is not fetched again
+// to check whether the rollout reached the device
+const rolloutRecheckInterval = 5 * time.Minute
+
+// deferredRollout is a version of a stack the device is not part of the rollout of
+type deferredRollout struct {
+	Version    int
+	Hash       string
+	Percentage int
+	CheckedAt  time.Time
+}
+
+// rolloutBucket returns the bucket of the device for the rollout salt, between 0 and 99
+func rolloutBucket(edgeID, salt string) int {
+	sum := sha256.Sum256([]byte(salt + ":" + edgeID))
+
+	return int(binary.BigEndian.Uint64(sum[:8]) % 100)
+}
+
+// deferRollout returns true when the device is not part of the rollout of the stack version,
+// which is reported once per version and percentage. The caller must hold the manager lock.
+func (manager *StackManager) deferRollout(stackID, version int, hash string, rollout *client.StackRollout) bool {
+	if rollout == nil || rollout.Percentage >= 100 {
+		delete(manager.deferredRollouts, stackID)
+
+		return false
+	}
+
+	bucket := rolloutBucket(manager.edgeID, rollout.Salt)
+	if bucket < rollout.Percentage {
+		delete(manager.deferredRollouts, stackID)
+
+		return false
+	}
+
+	if manager.deferredRollouts == nil {
+		manager.deferredRollouts = make(map[int]deferredRollout)
+	}
+
+	previous, reported := manager.deferredRollouts[stackID]
+	reported = reported && previous.Version == version && previous.Percentage == rollout.Percentage
+
+	manager.deferredRollouts[stackID] = deferredRollout{
+		Version:    version,
+		Hash:       hash,
+		Percentage: rollout.Percentage,
+		CheckedAt:  manager.now(),
+	}
+
+	if reported {
+		return true
+	}
+
+	log.Info().
+		Int("stack_identifier", stackID).
+		Int("stack_version", version).
+		Int("bucket", bucket).
+		Int("percentage", rollout.Percentage).
+		Msg("stack version deferred by rollout policy")
+
+	message := fmt.Sprintf("deferred by rollout policy: bucket %d is not within %d%%", bucket, rollout.Percentage)
+	if err := manager.portainerClient.SetEdgeStackStatus(stackID, portainer.EdgeStackStatusAcknowledged, nil, message); err != nil {
+		log.Error().Err(err).Int("stack_identifier", stackID).Msg("unable to update Edge stack status")
+	}
+
+	return true
+}
+
+// rolloutDeferred returns true when the desired stack version was recently deferred by its rollout policy,
+// so that its configuration is not fetched at every poll. The caller must hold the manager lock.
+func (manager *StackManager) rolloutDeferred(desired client.StackStatus) bool {
+	deferred, ok := manager.deferredRollouts[desired.ID]
+	if !ok || deferred.Version != desired.Version || deferred.Hash != desired.Hash {
+		return false
+	}
+
+	return manager.now().Before(deferred.CheckedAt.Add(rolloutRecheckInterval))
+}
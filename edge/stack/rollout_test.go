@@ -0,0 +1,108 @@
+package stack
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/portainer/agent/clock"
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/agent/internals/mocks"
+	portainer "github.com/portainer/portainer/api"
+	"github.com/stretchr/testify/assert"
+	gomock "go.uber.org/mock/gomock"
+)
+
+func TestRolloutBucket(t *testing.T) {
+	bucket := rolloutBucket("edge-1", "salt")
+	assert.GreaterOrEqual(t, bucket, 0)
+	assert.Less(t, bucket, 100)
+	assert.Equal(t, bucket, rolloutBucket("edge-1", "salt"))
+
+	// The buckets are spread across the devices
+	counts := make(map[int]int)
+	for i := 0; i < 10000; i++ {
+		counts[rolloutBucket(strconv.Itoa(i), "salt")/10]++
+	}
+
+	for decile := 0; decile < 10; decile++ {
+		assert.InDelta(t, 1000, counts[decile], 150)
+	}
+}
+
+func TestStackManager_deferRollout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClient := mocks.NewMockPortainerClient(ctrl)
+	fakeClock := clock.NewFakeClock(time.Unix(1000, 0))
+
+	manager := &StackManager{
+		portainerClient: mockClient,
+		clock:           fakeClock,
+		edgeID:          "edge-1",
+	}
+
+	bucket := rolloutBucket("edge-1", "salt")
+
+	assert.False(t, manager.deferRollout(1, 2, "h2", nil))
+	assert.False(t, manager.deferRollout(1, 2, "h2", &client.StackRollout{Percentage: bucket + 1, Salt: "salt"}))
+
+	rollout := &client.StackRollout{Percentage: bucket, Salt: "salt"}
+
+	mockClient.EXPECT().SetEdgeStackStatus(1, portainer.EdgeStackStatusAcknowledged, nil, gomock.Any()).Times(1)
+	assert.True(t, manager.deferRollout(1, 2, "h2", rollout))
+	assert.True(t, manager.deferRollout(1, 2, "h2", rollout))
+
+	// The deferred version is not fetched again before the recheck interval
+	desired := client.StackStatus{ID: 1, Version: 2, Hash: "h2"}
+	assert.True(t, manager.rolloutDeferred(desired))
+	assert.False(t, manager.rolloutDeferred(client.StackStatus{ID: 1, Version: 3, Hash: "h3"}))
+
+	fakeClock.Advance(rolloutRecheckInterval)
+	assert.False(t, manager.rolloutDeferred(desired))
+
+	// The device is part of the rollout once the percentage grows
+	assert.False(t, manager.deferRollout(1, 2, "h2", &client.StackRollout{Percentage: 100, Salt: "salt"}))
+	assert.False(t, manager.rolloutDeferred(desired))
+}
+
+func TestStackManager_processStack_abandonedVersion(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClient := mocks.NewMockPortainerClient(ctrl)
+
+	deployed := &edgeStack{Status: StatusDeployed}
+	deployed.ID = 1
+	deployed.Version = 1
+
+	manager := &StackManager{
+		portainerClient: mockClient,
+		clock:           clock.NewFakeClock(time.Unix(1000, 0)),
+		edgeID:          "edge-1",
+		stacks:          map[edgeStackID]*edgeStack{1: deployed},
+	}
+
+	transitions := 0
+	manager.OnStatusExit(StatusDeployed, func(stackID int, from, to edgeStackStatus) { transitions++ })
+	manager.OnStatusEnter(StatusPending, func(stackID int, from, to edgeStackStatus) { transitions++ })
+
+	desired := client.StackStatus{ID: 1, Version: 2, Hash: "h2"}
+
+	// The payload of the version cannot be fetched
+	mockClient.EXPECT().GetEdgeStackConfig(1, gomock.Any()).Return(nil, errors.New("server unreachable"))
+	assert.Error(t, manager.processStack(1, desired))
+
+	// The version is deferred by its rollout
+	rollout := &client.StackRollout{Percentage: rolloutBucket("edge-1", "salt"), Salt: "salt"}
+	mockClient.EXPECT().GetEdgeStackConfig(1, gomock.Any()).Return(&client.StackPayload{Rollout: rollout}, nil)
+	mockClient.EXPECT().SetEdgeStackStatus(1, portainer.EdgeStackStatusAcknowledged, nil, gomock.Any())
+	assert.NoError(t, manager.processStack(1, desired))
+
+	// The deployed version is left untouched, without running the hooks of the transitions
+	assert.Zero(t, transitions)
+	assert.Same(t, deployed, manager.stacks[1])
+	assert.Equal(t, StatusDeployed, deployed.Status)
+}
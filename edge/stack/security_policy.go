@@ -0,0 +1,61 @@
+package stack
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/portainer/agent/edge/securitypolicy"
+	"github.com/portainer/agent/edge/yaml"
+)
+
+// SetSecurityPolicy enforces the security policy of the device, the stacks it denies are not deployed
+func (manager *StackManager) SetSecurityPolicy(policy *securitypolicy.Policy) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	manager.securityPolicy = policy
+}
+
+// checkSecurityPolicy returns a securitypolicy.ErrPolicyViolation error when the workloads of the entry file of the
+// stack do not comply with the security policy. The bind mounts are interpolated with the environment of the stack
+// and the relative ones are resolved against the folder of the file, the files that cannot be parsed are denied.
+// The caller must hold the manager lock.
+func (manager *StackManager) checkSecurityPolicy(stack *edgeStack, stackFileLocation string) error {
+	if manager.securityPolicy == nil {
+		return nil
+	}
+
+	content, err := os.ReadFile(stackFileLocation)
+	if err != nil {
+		return err
+	}
+
+	workloads, err := yaml.ComposeWorkloadSecurity(string(content))
+	if manager.engineType == EngineTypeKubernetes {
+		workloads, err = yaml.KubernetesWorkloadSecurity(string(content))
+	}
+
+	if err != nil {
+		return fmt.Errorf("%w: unable to parse the stack file: %w", securitypolicy.ErrPolicyViolation, err)
+	}
+
+	env := make(map[string]string, len(stack.EnvVars))
+	for _, pair := range stack.EnvVars {
+		env[pair.Name] = pair.Value
+	}
+
+	for i := range workloads {
+		for j, mount := range workloads[i].BindMounts {
+			mount = interpolate(mount, env)
+			if strings.HasPrefix(mount, ".") {
+				mount = filepath.Join(filepath.Dir(stackFileLocation), mount)
+			}
+
+			workloads[i].BindMounts[j] = mount
+		}
+	}
+
+	return manager.securityPolicy.Evaluate(workloads)
+}
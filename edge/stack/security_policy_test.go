@@ -0,0 +1,63 @@
+package stack
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/portainer/agent/edge/securitypolicy"
+	portainer "github.com/portainer/portainer/api"
+	"github.com/portainer/portainer/api/edge"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStackManager_checkSecurityPolicy(t *testing.T) {
+	policyFile := filepath.Join(t.TempDir(), "policy.json")
+	require.NoError(t, os.WriteFile(policyFile, []byte(`{"denyPrivileged": true, "deniedBindMounts": ["/var/run"]}`), 0600))
+
+	policy, err := securitypolicy.Load(policyFile)
+	require.NoError(t, err)
+
+	stackFile := filepath.Join(t.TempDir(), "docker-compose.yml")
+	require.NoError(t, os.WriteFile(stackFile, []byte(`
+services:
+  web:
+    image: nginx
+    volumes:
+      - ${SOCKET_DIR:-/srv}:/run
+      - ./html:/usr/share/nginx/html
+`), 0600))
+
+	manager := &StackManager{engineType: EngineTypeDockerStandalone}
+	stack := &edgeStack{StackPayload: edge.StackPayload{ID: 1}}
+
+	// No policy
+	assert.NoError(t, manager.checkSecurityPolicy(stack, stackFile))
+
+	manager.SetSecurityPolicy(policy)
+	assert.NoError(t, manager.checkSecurityPolicy(stack, stackFile))
+
+	stack.EnvVars = []portainer.Pair{{Name: "SOCKET_DIR", Value: "/var/run"}}
+	err = manager.checkSecurityPolicy(stack, stackFile)
+	assert.ErrorIs(t, err, securitypolicy.ErrPolicyViolation)
+	assert.Equal(t, "security policy violation: web mounts /var/run", err.Error())
+
+	// The Kubernetes manifests are evaluated on the Kubernetes engines
+	manager.engineType = EngineTypeKubernetes
+	require.NoError(t, os.WriteFile(stackFile, []byte(`
+apiVersion: v1
+kind: Pod
+metadata:
+  name: debug
+spec:
+  containers:
+    - name: debug
+      image: busybox
+      securityContext:
+        privileged: true
+`), 0600))
+	err = manager.checkSecurityPolicy(stack, stackFile)
+	assert.ErrorIs(t, err, securitypolicy.ErrPolicyViolation)
+	assert.Equal(t, "security policy violation: Pod/debug is privileged", err.Error())
+}
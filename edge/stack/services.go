@@ -0,0 +1,99 @@
+package stack
+
+import (
+	"fmt"
+	"maps"
+
+	"github.com/portainer/agent/docker"
+	"github.com/portainer/agent/edge/client"
+
+	"github.com/rs/zerolog/log"
+)
+
+// serviceStatusSeverity ranks the container states, the most severe one is reported for a service
+var serviceStatusSeverity = map[string]int{
+	"running":    0,
+	"created":    1,
+	"paused":     2,
+	"removing":   3,
+	"exited":     4,
+	"dead":       5,
+	"restarting": 6,
+}
+
+func composeServiceStatuses(project string) (map[string]client.StackServiceStatus, error) {
+	states, err := docker.GetContainerStates("com.docker.compose.project=" + project)
+	if err != nil {
+		return nil, err
+	}
+
+	return aggregateServiceStatuses(states), nil
+}
+
+func swarmServiceStatuses(project string) (map[string]client.StackServiceStatus, error) {
+	states, err := docker.GetContainerStates("com.docker.stack.namespace=" + project)
+	if err != nil {
+		return nil, err
+	}
+
+	return aggregateServiceStatuses(states), nil
+}
+
+// aggregateServiceStatuses merges the states of the containers running each service
+func aggregateServiceStatuses(states []docker.ContainerState) map[string]client.StackServiceStatus {
+	services := make(map[string]client.StackServiceStatus)
+
+	for _, state := range states {
+		service, ok := services[state.Service]
+		if !ok || serviceStatusSeverity[state.Status] > serviceStatusSeverity[service.Status] {
+			service.Status = state.Status
+		}
+
+		service.Containers++
+		service.RestartCount += state.RestartCount
+
+		if state.Status == "exited" && state.ExitCode > service.ExitCode {
+			service.ExitCode = state.ExitCode
+		}
+
+		switch {
+		case state.Error != "":
+			service.Error = state.Error
+		case state.Status == "exited" && state.ExitCode != 0 && service.Error == "":
+			service.Error = fmt.Sprintf("exited with code %d", state.ExitCode)
+		}
+
+		services[state.Service] = service
+	}
+
+	return services
+}
+
+// reportServices reports the status of each service of the stack when it changed since the last report.
+// It returns the status of the services, nil when it is unavailable. The caller must hold the manager lock.
+func (manager *StackManager) reportServices(stack *edgeStack, stackName string) map[string]client.StackServiceStatus {
+	if manager.serviceStatuses == nil {
+		return nil
+	}
+
+	services, err := manager.serviceStatuses(stackName)
+	if err != nil {
+		log.Warn().Err(err).Int("stack_identifier", stack.ID).Msg("unable to retrieve the status of the services of the stack")
+
+		return nil
+	}
+
+	if stack.Services != nil && maps.Equal(services, stack.Services) {
+		return services
+	}
+
+	if err := manager.portainerClient.SetEdgeStackServicesStatus(stack.ID, services); err != nil {
+		log.Error().Err(err).Int("stack_identifier", stack.ID).Msg("unable to report the status of the Edge stack services")
+
+		return services
+	}
+
+	stack.Services = services
+
+	return services
+}
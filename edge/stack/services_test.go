@@ -0,0 +1,65 @@
+package stack
+
+import (
+	"testing"
+
+	"github.com/portainer/agent/docker"
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/agent/internals/mocks"
+	"github.com/stretchr/testify/assert"
+	gomock "go.uber.org/mock/gomock"
+)
+
+func TestAggregateServiceStatuses(t *testing.T) {
+	services := aggregateServiceStatuses([]docker.ContainerState{
+		{Service: "web", Status: "running"},
+		{Service: "web", Status: "restarting", RestartCount: 3, Error: "OCI runtime create failed"},
+		{Service: "db", Status: "running", RestartCount: 1},
+		{Service: "migrate", Status: "exited", ExitCode: 1},
+	})
+
+	assert.Equal(t, map[string]client.StackServiceStatus{
+		"web":     {Status: "restarting", Containers: 2, RestartCount: 3, Error: "OCI runtime create failed"},
+		"db":      {Status: "running", Containers: 1, RestartCount: 1},
+		"migrate": {Status: "exited", Containers: 1, ExitCode: 1, Error: "exited with code 1"},
+	}, services)
+}
+
+func TestStackManager_reportServices(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClient := mocks.NewMockPortainerClient(ctrl)
+
+	services := map[string]client.StackServiceStatus{
+		"web": {Status: "running", Containers: 1},
+	}
+
+	manager := &StackManager{
+		portainerClient: mockClient,
+		stacks:          map[edgeStackID]*edgeStack{},
+		serviceStatuses: func(project string) (map[string]client.StackServiceStatus, error) {
+			assert.Equal(t, "edge_web", project)
+
+			return services, nil
+		},
+	}
+
+	stack := &edgeStack{}
+	stack.ID = 1
+
+	mockClient.EXPECT().SetEdgeStackServicesStatus(1, services).Times(1)
+
+	manager.reportServices(stack, "edge_web")
+	// Unchanged services are not reported again
+	manager.reportServices(stack, "edge_web")
+
+	services = map[string]client.StackServiceStatus{
+		"web": {Status: "restarting", Containers: 1, RestartCount: 1},
+	}
+
+	mockClient.EXPECT().SetEdgeStackServicesStatus(1, services).Times(1)
+
+	manager.reportServices(stack, "edge_web")
+	assert.Equal(t, services, stack.Services)
+}
@@ -2,7 +2,9 @@ package stack
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -10,14 +12,25 @@ import (
 	"time"
 
 	"github.com/portainer/agent"
+	"github.com/portainer/agent/chaos"
+	"github.com/portainer/agent/clock"
 	"github.com/portainer/agent/docker"
+	"github.com/portainer/agent/edge/blobstore"
 	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/agent/edge/credstore"
+	"github.com/portainer/agent/edge/firewall"
+	"github.com/portainer/agent/edge/imagepolicy"
+	"github.com/portainer/agent/edge/oci"
+	"github.com/portainer/agent/edge/opa"
+	"github.com/portainer/agent/edge/securitypolicy"
+	"github.com/portainer/agent/edge/session"
 	"github.com/portainer/agent/edge/yaml"
 	"github.com/portainer/agent/exec"
+	agentfs "github.com/portainer/agent/filesystem"
 	"github.com/portainer/agent/nomad"
+	"github.com/portainer/agent/systemd"
 	portainer "github.com/portainer/portainer/api"
 	"github.com/portainer/portainer/api/edge"
-	"github.com/portainer/portainer/api/filesystem"
 	"github.com/portainer/portainer/pkg/libstack"
 
 	"github.com/rs/zerolog/log"
@@ -33,9 +46,89 @@ type edgeStack struct {
 	Status     edgeStackStatus
 	Action     edgeStackAction
 
+	StatusTransitions []statusTransition
+
+	// FileChecksums holds the checksums of the persisted stack files, verified before each deployment
+	FileChecksums map[string]string
+
+	// LargeFiles are the large files of the version, downloaded into the stack folder before its deployment.
+	// LargeFilesWritten is set once they are written, DownloadCount counts the download attempts.
+	LargeFiles        []client.StackLargeFile
+	LargeFilesWritten bool
+	DownloadCount     int
+
 	PullCount    int
 	PullFinished bool
+	// PulledImages holds the images of the stack file pulled by the previous attempts, only the others are pulled again
+	PulledImages []string
 	DeployCount  int
+
+	// RolledBackVersion is the retained version currently deployed instead of Version, 0 if none
+	RolledBackVersion int
+
+	// Registries holds the registries of the images used by the stack, used to scope its registry credentials
+	Registries []string
+
+	// Hash is the hash of the stack content sent by the server along with its desired version
+	Hash string
+
+	// NameConflict is true when the project of the stack is already used by another stack
+	NameConflict bool
+
+	// StatusTimeout and StatusCheckInterval override the status wait defaults when they are set
+	StatusTimeout       time.Duration
+	StatusCheckInterval time.Duration
+
+	// Job is set for the stacks running to completion, JobRemoved once the completed stack was removed after its TTL
+	Job        *client.StackJob
+	JobRemoved bool
+
+	// Services holds the status of each service of the stack as last reported to the server
+	Services map[string]client.StackServiceStatus
+
+	// RestartSamples holds the recent restart counts of the services, used to detect crash loops.
+	// DegradedReason and DegradedReportedAt are the last crash loop reported to the server.
+	RestartSamples     []restartSample
+	DegradedReason     string
+	DegradedReportedAt time.Time
+
+	// Usage is the cumulative data and time spent on the stack, ReportedUsage the last one reported to the server
+	Usage           client.StackUsage
+	ReportedUsage   client.StackUsage
+	UsageReportedAt time.Time
+
+	// ActivateAt is the time the stack is deployed at, its images are pulled and its files staged beforehand
+	ActivateAt time.Time
+
+	// Barrier is the fleet-wide barrier the stack waits at once staged, BarrierReleased once the server released it
+	Barrier         string
+	BarrierReleased bool
+
+	// Format is the format of the stack files, see client.StackPayload
+	Format string
+
+	// Priority is the priority of the stack in the queue, see client.StackPayload
+	Priority string
+
+	// ProjectName is the project set by the server for the stack, DeployedProject the project it was last deployed as
+	ProjectName     string
+	DeployedProject string
+
+	// DeployedFingerprint identifies the files, credentials and images of the last successful deployment, empty when
+	// it is not known or a deployment failed since, see deployFingerprint
+	DeployedFingerprint string
+
+	// RestartedAt is the last restart requested by the server the agent handled, see client.StackStatus
+	RestartedAt int64
+
+	// Retention is the data retention policy applied once the stack is removed, AnonymousVolumes
+	// the anonymous volumes of its containers recorded before they were removed
+	Retention        *client.StackRetention
+	AnonymousVolumes []string
+
+	// HostNetworks are the networks of the host interfaces created before the stack is deployed, see
+	// client.StackPayload
+	HostNetworks []client.StackNetwork
 }
 
 type edgeStackStatus int
@@ -51,6 +144,11 @@ const (
 	StatusAwaitingDeployedStatus
 	StatusAwaitingRemovedStatus
 	StatusCompleted
+	StatusIntegrityError
+	StatusDegraded
+	StatusScheduled
+	StatusStaged
+	StatusFrozen
 )
 
 type edgeStackAction int
@@ -61,6 +159,7 @@ const (
 	actionUpdate
 	actionDelete
 	actionIdle
+	actionRestart
 )
 
 const queueSleepInterval = agent.EdgeStackQueueSleepIntervalSeconds * time.Second
@@ -77,6 +176,7 @@ const (
 	EngineTypeDockerSwarm
 	EngineTypeKubernetes
 	EngineTypeNomad
+	EngineTypeContainerd
 )
 
 // StackManager represents a service for managing Edge stacks
@@ -86,11 +186,123 @@ type StackManager struct {
 	stacks          map[edgeStackID]*edgeStack
 	stopSignal      chan struct{}
 	deployer        agent.Deployer
+	nativeDeployer  agent.Deployer
 	isEnabled       bool
 	portainerClient client.PortainerClient
 	assetsPath      string
 	awsConfig       *agent.AWSConfig
-	mu              sync.Mutex
+	clock           agent.Clock
+	historyCount    int
+	historyMaxSize  int64
+	credentialStore credstore.Store
+	artifactClient  *oci.Client
+	batchTimeout    time.Duration
+
+	statusTimeout       time.Duration
+	statusCheckInterval time.Duration
+
+	orphanPolicy string
+	// lintMode is one of LintModeOff, LintModeWarn or LintModeEnforce
+	lintMode string
+	// resources lists and removes the resources labeled by the agent, nil when they are not supported
+	resources resourceRuntime
+	// retention applies the retention policy of the removed stacks, nil when it is not supported
+	retention retentionRuntime
+	// hostNetworks creates the networks of the host interfaces of the stacks, nil when they are not supported
+	hostNetworks func(ctx context.Context, network docker.HostNetwork) error
+	// removeHostNetwork removes a network of the host interfaces once no stack uses it
+	removeHostNetwork func(ctx context.Context, name string) error
+	// firewall opens the published ports of the stacks in the host firewall, nil when it is not managed.
+	// publishedPorts returns the ports published by a stack, nil when they are not known.
+	firewall       stackFirewall
+	publishedPorts func(stackID int) ([]firewall.Port, error)
+	// archivePath is the folder the volumes of the removed stacks are archived to
+	archivePath string
+	// reconciled is true once the full desired set of stacks was received from the server
+	reconciled bool
+	// recovering is true while the server recovers the stacks it lost the records of, see SetRecovery.
+	// inventoryReported is true once the stacks deployed on the device were reported during the recovery.
+	recovering        bool
+	inventoryReported bool
+	// reportedOrphans holds the orphaned resources already reported
+	reportedOrphans map[string]struct{}
+	// exitCodes returns the exit codes of the services of a project, nil when they are not reported
+	exitCodes func(project string) (map[string]int, error)
+	// serviceStatuses returns the status of each service of a project, nil when they are not reported
+	serviceStatuses func(project string) (map[string]client.StackServiceStatus, error)
+	// envFileRoot is where the host is mounted, envFilePaths the host paths the env files of the stacks are read from
+	envFileRoot  string
+	envFilePaths []string
+	// deployProxy is the proxy passed to the deployers, nil when they inherit the environment of the agent
+	deployProxy *agent.ProxyOptions
+	// projectNaming and projectPrefix name the projects of the stacks, see SetProjectNaming
+	projectNaming string
+	projectPrefix string
+	// hostProjects lists the compose projects of the host, nil when they are not checked for name conflicts
+	hostProjects func() (map[string]string, error)
+	// capabilities returns the capabilities of the device the stack requirements are checked against
+	capabilities func() (DeviceCapabilities, error)
+	// engineVersions returns the versions of the Docker engine and of the compose plugin the compose features
+	// of the stacks are checked against, nil when they are not checked
+	engineVersions func() (string, string)
+	// wasmRuntime returns why the WebAssembly runtime of a workload is not available, an empty string when it is,
+	// nil when the WebAssembly workloads are not checked
+	wasmRuntime func(runtime string) (string, error)
+	// imagePolicy restricts the images the stacks can run, nil when any image is allowed
+	imagePolicy *imagepolicy.Policy
+	// securityPolicy restricts the security settings of the workloads of the stacks, nil when any setting is allowed
+	securityPolicy *securitypolicy.Policy
+	// payloadPolicy evaluates the stacks against the OPA policies of the device, nil when there are none
+	payloadPolicy *opa.Evaluator
+	// imagePuller pulls the images of the stacks reporting their download progress before the deployer pulls them,
+	// nil when the pull progress is not reported
+	imagePuller imagePuller
+	// pullProgressDisabled lets the deployer pull the images, e.g. on the devices running with the minimal profile
+	pullProgressDisabled bool
+	// imageID returns the ID of a local image, nil when the engine cannot resolve it
+	imageID func(ctx context.Context, image string) (string, error)
+	// skipUnchanged skips the deployment of the versions identical to the deployed one
+	skipUnchanged bool
+	// imageLayersSize returns the total size of the image layers of the host, nil when the image usage is not accounted
+	imageLayersSize func() (int64, error)
+	// deferredRollouts holds the stack versions deferred by their rollout policy, indexed by stack
+	deferredRollouts map[int]deferredRollout
+	// freezeDataPath is the data folder the freeze windows are read from, empty when they are not enforced
+	freezeDataPath string
+	// largeFileClient downloads the large files of the stacks, largeFileMaxSize bounds their size when not zero
+	largeFileClient  *http.Client
+	largeFileMaxSize int64
+	// payloadCache keeps the payloads and the large files of the stack versions downloaded, nil when disabled
+	payloadCache blobstore.Store
+	// changelogs are the versions received for each stack, kept in changelogDataPath when it is set and reported to
+	// the server when changelogReport is set
+	changelogs        map[int][]client.StackVersionRecord
+	changelogDataPath string
+	changelogReport   bool
+	// remediations are the remediations reported for the stacks that failed and are not deployed since
+	remediations map[int]client.StackRemediation
+	// sessionRecorder records the calls to the deployer, nil when the session is not recorded
+	sessionRecorder *session.Recorder
+
+	// batches are the batches of stacks being deployed as a single unit, stackBatches maps
+	// their stacks to them and rolledBackBatchStacks the versions they were rolled back from
+	batches               map[int]*stackBatch
+	stackBatches          map[int]int
+	rolledBackBatchStacks map[int]int
+
+	statusEnterHooks map[edgeStackStatus][]StatusHookFunc
+	statusExitHooks  map[edgeStackStatus][]StatusHookFunc
+
+	// queueServedAt holds the last time each stack was served by the queue
+	queueServedAt map[int]time.Time
+
+	// view is read by the status consumers without the manager lock
+	view statusView
+
+	// storage bounds the disk used by the files of the stacks and the job logs
+	storage storageQuota
+
+	mu sync.Mutex
 }
 
 // NewStackManager returns a pointer to a new instance of StackManager
@@ -102,9 +314,25 @@ func NewStackManager(cli client.PortainerClient, assetsPath string, config *agen
 		assetsPath:      assetsPath,
 		awsConfig:       config,
 		edgeID:          edgeID,
+		clock:           clock.NewSystemClock(),
+		historyCount:    DefaultHistoryRetention,
+		credentialStore: credstore.NewMemoryStore(),
+		artifactClient:  oci.NewClient(),
+		largeFileClient: newLargeFileClient(),
+		batchTimeout:    DefaultBatchTimeout,
+		nativeDeployer:  systemd.NewDeployer(),
 	}
 }
 
+// deployerFor returns the deployer of the stack, the deployer of the engine for the container stacks
+func (manager *StackManager) deployerFor(stack *edgeStack) agent.Deployer {
+	if stack.Format == client.StackFormatSystemd {
+		return manager.nativeDeployer
+	}
+
+	return manager.deployer
+}
+
 func (manager *StackManager) UpdateStacksStatus(pollResponseStacks map[int]client.StackStatus) error {
 	if !manager.isEnabled {
 		return nil
@@ -113,28 +341,21 @@ func (manager *StackManager) UpdateStacksStatus(pollResponseStacks map[int]clien
 	manager.mu.Lock()
 	defer manager.mu.Unlock()
 
-	for stackID, status := range pollResponseStacks {
-		err := manager.processStack(stackID, status)
-		if err != nil {
-			return err
-		}
+	for batchID, versions := range batchVersions(pollResponseStacks) {
+		manager.beginBatch(batchID, versions)
 	}
 
-	manager.processRemovedStacks(pollResponseStacks)
+	err := manager.applyStackOperations(reconcileStacks(pollResponseStacks, manager.stacks))
 
-	return nil
-}
+	manager.releaseBarriers(pollResponseStacks)
 
-func (manager *StackManager) addRegistryToEntryFile(stackPayload *edge.StackPayload) error {
-	var fileContent *string
+	manager.reconciled = true
 
-	for index, dirEntry := range stackPayload.DirEntries {
-		if dirEntry.IsFile && dirEntry.Name == stackPayload.EntryFileName {
-			fileContent = &stackPayload.DirEntries[index].Content
-			break
-		}
-	}
+	return err
+}
 
+func (manager *StackManager) addRegistryToEntryFile(stackPayload *client.StackPayload) error {
+	fileContent := entryFileContent(&stackPayload.StackPayload)
 	if fileContent == nil {
 		return fmt.Errorf("EntryFileName not found in DirEntries")
 	}
@@ -153,6 +374,7 @@ func (manager *StackManager) addRegistryToEntryFile(stackPayload *edge.StackPayl
 	case EngineTypeKubernetes:
 		if len(stackPayload.RegistryCredentials) > 0 {
 			yml := yaml.NewKubernetesYAML(*fileContent, stackPayload.RegistryCredentials)
+			yml.PatchServiceAccounts = stackPayload.PatchServiceAccounts
 			*fileContent, _ = yml.AddImagePullSecrets()
 		}
 	}
@@ -174,26 +396,37 @@ func getStackFileFolder(stack *edgeStack) string {
 func (manager *StackManager) processStack(stackID int, stackStatus client.StackStatus) error {
 	var stack *edgeStack
 
+	if manager.isRolledBackBatchStack(stackID, stackStatus.Version) {
+		return nil
+	}
+
 	originalStack, processedStack := manager.stacks[edgeStackID(stackID)]
 	if processedStack {
 		// update the cloned stack to keep data consistency
 		clonedStack := *originalStack
 		stack = &clonedStack
 
-		if stack.Version == stackStatus.Version && !stackStatus.ReadyRePullImage {
+		op, changed := desiredStackOperation(stack, stackStatus)
+		if !changed {
 			return nil // stack is unchanged
 		}
 
+		if op == operationRestart {
+			return manager.requestRestart(stackID, stackStatus.RestartRequestedAt)
+		}
+
 		log.Debug().Int("stack_identifier", stackID).Msg("marking stack for update")
 
 		stack.Action = actionUpdate
 		stack.Version = stackStatus.Version
-		stack.Status = StatusPending
 
 		stack.PullFinished = false
 		stack.PullCount = 0
+		stack.PulledImages = nil
 		stack.DeployCount = 0
+		stack.RolledBackVersion = 0
 		stack.ReadyRePullImage = stackStatus.ReadyRePullImage
+		stack.Hash = stackStatus.Hash
 	} else {
 		log.Debug().Int("stack_identifier", stackID).Msg("marking stack for deployment")
 
@@ -203,46 +436,163 @@ func (manager *StackManager) processStack(stackID int, stackStatus client.StackS
 				ID:      stackID,
 			},
 			Action: actionDeploy,
-			Status: StatusPending,
+			Hash:   stackStatus.Hash,
 		}
 	}
 
-	stackPayload, err := manager.portainerClient.GetEdgeStackConfig(stackID, &stackStatus.Version)
+	// The new version is deployed from scratch, the restarts requested until now are handled
+	stack.RestartedAt = max(stack.RestartedAt, stackStatus.RestartRequestedAt)
+
+	syncStart := manager.now()
+
+	stackPayload, err := manager.getStackPayload(stackID, stackStatus)
 	if err != nil {
 		return err
 	}
 
+	if manager.deferRollout(stackID, stackStatus.Version, stackStatus.Hash, stackPayload.Rollout) {
+		// The current version of the stack, if any, is left untouched
+		return nil
+	}
+
+	// The transition hooks only run for the versions going on, not for the ones abandoned above
+	manager.setStatus(stack, StatusPending)
+
 	edgeIdPair := portainer.Pair{Name: agent.EdgeIdEnvVarName, Value: manager.edgeID}
+	stackIdPair := portainer.Pair{Name: agent.EdgeStackIdEnvVarName, Value: strconv.Itoa(stackID)}
 
 	stack.Name = stackPayload.Name
 	stack.RegistryCredentials = stackPayload.RegistryCredentials
+	manager.saveRegistryCredentials(stack)
 	stack.Namespace = stackPayload.Namespace
 	stack.PrePullImage = stackPayload.PrePullImage
 	stack.RePullImage = stackPayload.RePullImage
 	stack.RetryDeploy = stackPayload.RetryDeploy
-	stack.EnvVars = append(stackPayload.EnvVars, edgeIdPair)
+	stack.EnvVars = append(stackPayload.EnvVars, edgeIdPair, stackIdPair)
 	stack.SupportRelativePath = stackPayload.SupportRelativePath
 	stack.FilesystemPath = stackPayload.FilesystemPath
 	stack.FileName = stackPayload.EntryFileName
 	stack.FileFolder = getStackFileFolder(stack)
 	stack.RollbackTo = stackPayload.RollbackTo
+	stack.StatusTimeout = stackPayload.StatusTimeout
+	stack.StatusCheckInterval = stackPayload.StatusCheckInterval
+	stack.Job = stackPayload.Job
+	stack.JobRemoved = false
+	stack.Format = stackPayload.Format
+	stack.Priority = stackPayload.Priority
+	stack.ProjectName = stackPayload.ProjectName
+	stack.RestartSamples = nil
+	stack.LargeFiles = stackPayload.LargeFiles
+	stack.LargeFilesWritten = false
+	stack.DownloadCount = 0
+
+	stack.NameConflict = false
+	if err := manager.checkStackNameConflict(stack); err != nil {
+		manager.failNameConflict(stack, err)
+
+		return nil
+	}
+
+	if err := manager.checkStackRequirements(stackPayload.Requirements); err != nil {
+		manager.failUnschedulable(stack, err)
 
-	err = filesystem.DecodeDirEntries(stackPayload.DirEntries)
+		return nil
+	}
+
+	if err := manager.checkStackNetworks(stackPayload.Networks, stackPayload.Format); err != nil {
+		manager.failUnschedulable(stack, err)
+
+		return nil
+	}
+
+	envVars, err := manager.mergeEnvFiles(stack.EnvVars, stackPayload.EnvFiles)
+	if err != nil {
+		manager.failUnschedulable(stack, err)
+
+		return nil
+	}
+
+	stack.EnvVars = envVars
+
+	stack.ActivateAt, err = activationTime(stackPayload.Activation, time.Local)
 	if err != nil {
 		return err
 	}
 
-	err = manager.addRegistryToEntryFile(stackPayload)
+	stack.Barrier = stackPayload.Barrier
+	stack.BarrierReleased = false
+	stack.Retention = stackPayload.Retention
+	stack.HostNetworks = stackPayload.Networks
+
+	err = agentfs.DecodeDirEntries(stackPayload.DirEntries, stackPayload.FileEncodings)
 	if err != nil {
 		return err
 	}
 
-	err = filesystem.PersistDir(stack.FileFolder, stackPayload.DirEntries)
+	if stackPayload.Artifact != nil {
+		dirEntries, err := manager.pullStackArtifact(context.TODO(), stack, stackPayload.Artifact)
+		if errors.Is(err, oci.ErrVerification) {
+			manager.putStack(stack)
+			manager.failIntegrityCheck(stack, err)
+
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		stackPayload.DirEntries = dirEntries
+	}
+
+	if err := verifyDirEntries(stackPayload.DirEntries, stackPayload.FileChecksums); err != nil {
+		manager.putStack(stack)
+		manager.failIntegrityCheck(stack, err)
+
+		return nil
+	}
+
+	// The units of the native stacks are deployed as declared
+	if stack.Format != client.StackFormatSystemd {
+		err = manager.addRegistryToEntryFile(stackPayload)
+		if err != nil {
+			return err
+		}
+
+		manager.addOwnershipLabels(stack, &stackPayload.StackPayload)
+
+		err = manager.rewriteRelativePaths(stack, &stackPayload.StackPayload)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := attachStackNetworks(stackPayload); err != nil {
+		manager.failUnschedulable(stack, err)
+
+		return nil
+	}
+
+	if err := expandEnvFiles(stackPayload, stack.EnvVars); err != nil {
+		return err
+	}
+
+	stack.Registries = imageRegistries(stack, &stackPayload.StackPayload)
+
+	stack.FileChecksums = persistedChecksums(stackPayload.DirEntries, stackPayload.FileChecksums)
+
+	err = manager.persistStackFiles(stack, stackPayload.DirEntries)
 	if err != nil {
 		return err
 	}
 
-	manager.stacks[edgeStackID(stackID)] = stack
+	manager.accountSync(stack, stackPayload.DirEntries, syncStart)
+
+	manager.putStack(stack)
+
+	if err := verifyStackFiles(stack.FileFolder, stack.FileChecksums); err != nil {
+		manager.failIntegrityCheck(stack, err)
+
+		return nil
+	}
 
 	log.Debug().
 		Int("stack_identifier", int(stack.ID)).
@@ -250,21 +600,11 @@ func (manager *StackManager) processStack(stackID int, stackStatus client.StackS
 		Str("namespace", stack.Namespace).
 		Msg("stack acknowledged")
 
-	return manager.portainerClient.SetEdgeStackStatus(int(stack.ID), portainer.EdgeStackStatusAcknowledged, stack.RollbackTo, "")
-}
+	runHooks(hookAcknowledged, stack, "")
 
-func (manager *StackManager) processRemovedStacks(pollResponseStacks map[int]client.StackStatus) {
-	for stackID, stack := range manager.stacks {
-		if _, ok := pollResponseStacks[int(stackID)]; !ok {
-			log.Debug().Int("stack_identifier", int(stackID)).Msg("marking stack for deletion")
+	annotation := manager.lintAnnotation(stack, filepath.Join(stack.FileFolder, stack.FileName))
 
-			stack.Action = actionDelete
-			if stack.Status != StatusAwaitingRemovedStatus {
-				stack.Status = StatusPending
-			}
-			manager.stacks[stackID] = stack
-		}
-	}
+	return manager.portainerClient.SetEdgeStackStatus(int(stack.ID), portainer.EdgeStackStatusAcknowledged, stack.RollbackTo, annotation)
 }
 
 func (manager *StackManager) Stop() error {
@@ -309,34 +649,45 @@ func (manager *StackManager) Start() error {
 		}
 	}()
 
+	manager.startJanitor(manager.stopSignal)
+	manager.startStorageQuota(manager.stopSignal)
+
 	return nil
 }
 
 func (manager *StackManager) performActionOnStack() {
 	stack := manager.nextPendingStack()
 	if stack == nil {
-		time.Sleep(queueSleepInterval)
+		manager.clock.Sleep(queueSleepInterval)
 
 		return
 	}
 
 	ctx := context.TODO()
 	manager.mu.Lock()
-	stackName := fmt.Sprintf("edge_%s", stack.Name)
-	stackFileLocation := fmt.Sprintf("%s/%s", stack.FileFolder, stack.FileName)
+	stackName := manager.stackProjectName(stack)
+	stackFileLocation := filepath.Join(stack.FileFolder, stack.FileName)
 	manager.mu.Unlock()
 
 	switch stack.Status {
-	case StatusAwaitingDeployedStatus, StatusAwaitingRemovedStatus, StatusDeployed:
+	case StatusAwaitingDeployedStatus, StatusAwaitingRemovedStatus, StatusDeployed, StatusDegraded:
 		if err := manager.checkStackStatus(ctx, stackName, stack); err != nil {
 			log.Error().Err(err).Msg("unable to check Edge stack status")
 		}
 
+		return
+	case StatusCompleted:
+		manager.removeExpiredJob(ctx, stack, stackName)
+
 		return
 	}
 
 	switch stack.Action {
 	case actionDeploy, actionUpdate:
+		if err := manager.downloadLargeFiles(stack); err != nil {
+			return
+		}
+
 		// validate the stack file and fail-fast if the stack format is invalid
 		// each deployer has its own Validate function
 		err := manager.validateStackFile(ctx, stack, stackName, stackFileLocation)
@@ -349,12 +700,18 @@ func (manager *StackManager) performActionOnStack() {
 			return
 		}
 
+		if manager.waitAtBarrier(stack) || manager.deferFrozen(stack) || manager.deferDeploy(stack) {
+			return
+		}
+
 		if IsRelativePathStack(stack) {
-			dst := filepath.Join(stack.FilesystemPath, agent.ComposePathPrefix)
-			if err := docker.CopyGitStackToHost(stack.FileFolder, dst, stack.ID, stackName, manager.assetsPath); err != nil {
+			if err := manager.copyRelativePathStackToHost(stack, stackName); err != nil {
 				log.Error().Err(err).Str("context", "DeployRelativePathEdgeStack").Msg("unable to copy the stack to host")
 
-				stack.Status = StatusError
+				manager.mu.Lock()
+				manager.setStatusMessage(stack, StatusError, err.Error())
+				runHooks(hookError, stack, err.Error())
+				manager.mu.Unlock()
 
 				if err := manager.portainerClient.SetEdgeStackStatus(stack.ID, portainer.EdgeStackStatusError, stack.RollbackTo, fmt.Errorf("failed to copy git stack to host: %w", err).Error()); err != nil {
 					log.Error().Err(err).Str("context", "DeployRelativePathEdgeStack").Msg("unable to update Edge stack status")
@@ -364,12 +721,17 @@ func (manager *StackManager) performActionOnStack() {
 		}
 
 		manager.deployStack(ctx, stack, stackName, stackFileLocation)
+	case actionRestart:
+		if manager.deferFrozen(stack) {
+			return
+		}
+
+		manager.restartStack(ctx, stack, stackName, stackFileLocation)
 	case actionDelete:
-		stackFileLocation = fmt.Sprintf("%s/%s", SuccessStackFileFolder(stack.FileFolder), stack.FileName)
+		stackFileLocation = filepath.Join(SuccessStackFileFolder(stack.FileFolder), stack.FileName)
 		manager.deleteStack(ctx, stack, stackName, stackFileLocation)
 		if IsRelativePathStack(stack) {
-			dst := filepath.Join(stack.FilesystemPath, agent.ComposePathPrefix)
-			_ = docker.RemoveGitStackFromHost(stack.FileFolder, dst, stack.ID, stackName)
+			_ = manager.removeRelativePathStackFromHost(stack, stackName)
 		}
 	}
 }
@@ -378,43 +740,51 @@ func (manager *StackManager) nextPendingStack() *edgeStack {
 	manager.mu.Lock()
 	defer manager.mu.Unlock()
 
-	// find the first pending stack,
-	// if not found look for a stack waiting for status check
-	// if not found, look for the first retry stack and set it to pending
+	// serve the pending stacks and the stacks waiting for status check by priority tier,
+	// if not found, set the retry stacks back to pending
 
-	for _, stack := range manager.stacks {
-		if stack.Status == StatusPending {
-			return stack
+	if stack := manager.nextQueuedStack(); stack != nil {
+		if stack.Status != StatusPending {
+			manager.clock.Sleep(queueSleepInterval)
 		}
-	}
 
-	for _, stack := range manager.stacks {
-		if stack.Status == StatusAwaitingDeployedStatus || stack.Status == StatusAwaitingRemovedStatus {
-			time.Sleep(queueSleepInterval)
+		manager.markServed(stack)
 
-			return stack
-		}
+		return stack
 	}
 
-	for _, stack := range manager.stacks {
+	for _, stack := range manager.sortedStacks() {
 		if stack.Status == StatusRetry {
 			log.Debug().
 				Int("stack_identifier", int(stack.ID)).
 				Msg("retrying stack")
 
-			stack.Status = StatusPending
+			manager.setStatus(stack, StatusPending)
 		}
 	}
 
-	// Pick the first one randomly
-	for _, stack := range manager.stacks {
-		if stack.Status == StatusDeployed {
-			time.Sleep(queueSleepInterval)
+	if stack := manager.nextActivatedStack(); stack != nil {
+		return stack
+	}
+
+	if stack := manager.nextThawedStack(); stack != nil {
+		return stack
+	}
 
+	for _, stack := range manager.sortedStacks() {
+		if manager.isExpiredJob(stack) {
 			return stack
 		}
 	}
 
+	// Check the deployed stacks in turn
+	if stack := manager.nextCheckedStack(); stack != nil {
+		manager.clock.Sleep(queueSleepInterval)
+		manager.markServed(stack)
+
+		return stack
+	}
+
 	return nil
 }
 
@@ -427,33 +797,56 @@ func (manager *StackManager) checkStackStatus(ctx context.Context, stackName str
 	manager.mu.Lock()
 	defer manager.mu.Unlock()
 
+	manager.reportUsage(stack)
+
 	requiredStatus := libstack.StatusRemoved
+	timeout, interval := manager.statusWait(stack)
+	deadline := manager.statusDeadline(stack, timeout)
+	window := manager.statusCheckWindow(deadline, interval)
 
 	switch stack.Status {
 	case StatusAwaitingDeployedStatus:
 		requiredStatus = libstack.StatusRunning
 
-		if stack.EdgeUpdateID != 0 {
+		if stack.EdgeUpdateID != 0 || stack.Job != nil {
 			requiredStatus = libstack.StatusCompleted
 		}
 
-	case StatusDeployed:
+	case StatusDeployed, StatusDegraded:
 		// There is no need to wait for a change of state, just observe if it
 		// has happened already, the new timeout is just enough to get past the
 		// ctx.Done() check and run once.
 		var cancelFn func()
-		ctx, cancelFn = context.WithTimeout(ctx, 1*time.Second)
+		ctx, cancelFn = clock.WithTimeout(ctx, manager.clock, 1*time.Second)
 		defer cancelFn()
 
 		requiredStatus = libstack.StatusCompleted
 	}
 
-	status, statusMessage, err := manager.waitForStatus(ctx, stackName, requiredStatus)
-	if err != nil && stack.Status != StatusDeployed {
+	windowCtx, cancel := clock.WithTimeout(ctx, manager.clock, window)
+	defer cancel()
+
+	status, statusMessage, err := manager.observeStatus(windowCtx, manager.deployerFor(stack), stackName, requiredStatus)
+	deployed := stack.Status == StatusDeployed || stack.Status == StatusDegraded
+
+	if err != nil && !deployed {
 		return err
 	}
 
-	if stack.Status != StatusDeployed {
+	if status == libstack.StatusError && !deployed && windowCtx.Err() != nil && ctx.Err() == nil &&
+		(manager.now().Before(deadline) || runsUntilCompleted(stack)) {
+		// The check window elapsed before the timeout, the stack is checked again later
+		log.Debug().
+			Int("stack_identifier", int(stack.ID)).
+			Str("stack_name", stackName).
+			Str("status_message", statusMessage).
+			Time("deadline", deadline).
+			Msg("stack status not reached yet")
+
+		return nil
+	}
+
+	if !deployed {
 		log.Debug().
 			Int("stack_identifier", int(stack.ID)).
 			Str("stack_name", stackName).
@@ -465,48 +858,80 @@ func (manager *StackManager) checkStackStatus(ctx context.Context, stackName str
 	}
 
 	// Only report back the Completed status for already deployed stacks
-	if stack.Status == StatusDeployed {
+	if deployed {
+		services := manager.reportServices(stack, stackName)
+
 		if status == libstack.StatusCompleted {
-			stack.Status = StatusCompleted
+			manager.setStatus(stack, StatusCompleted)
 			return manager.portainerClient.SetEdgeStackStatus(stack.ID, portainer.EdgeStackStatusCompleted, stack.RollbackTo, "")
 		}
 
-		return nil
+		return manager.checkCrashLoop(stack, services)
 	}
 
 	if status == libstack.StatusError {
-		stack.Status = StatusError
+		manager.setStatusMessage(stack, StatusError, statusMessage)
+		runHooks(hookError, stack, statusMessage)
+
+		if requiredStatus == libstack.StatusCompleted {
+			manager.reportJobResult(stack, stackName)
+		}
+
+		if requiredStatus != libstack.StatusRemoved {
+			manager.reportServices(stack, stackName)
+		}
+
 		return manager.portainerClient.SetEdgeStackStatus(int(stack.ID), portainer.EdgeStackStatusError, stack.RollbackTo, statusMessage)
 	}
 
 	if status == libstack.StatusRunning {
-		stack.Status = StatusDeployed
+		manager.setStatus(stack, StatusDeployed)
+		manager.openFirewall(stack)
+		runHooks(hookDeployed, stack, "")
+		manager.reportServices(stack, stackName)
+		manager.reportConfigHash(stack)
 		return manager.portainerClient.SetEdgeStackStatus(int(stack.ID), portainer.EdgeStackStatusRunning, stack.RollbackTo, "")
 	}
 
 	if status == libstack.StatusCompleted {
-		stack.Status = StatusCompleted
+		manager.setStatus(stack, StatusCompleted)
+		runHooks(hookDeployed, stack, "")
+		manager.reportJobResult(stack, stackName)
+		manager.reportServices(stack, stackName)
 		return manager.portainerClient.SetEdgeStackStatus(int(stack.ID), portainer.EdgeStackStatusCompleted, stack.RollbackTo, "")
 	}
 
 	if status == libstack.StatusRemoved {
-		delete(manager.stacks, edgeStackID(stack.ID))
-		return manager.portainerClient.SetEdgeStackStatus(int(stack.ID), portainer.EdgeStackStatusRemoved, stack.RollbackTo, "")
+		retentionMessage := manager.applyRetention(stack)
+		manager.closeFirewall(stack)
+		manager.removeStackNetworks(ctx, stack)
+
+		manager.removeStack(stack)
+		manager.deleteRegistryCredentials(stack)
+		manager.updateBatchMember(stack, true)
+		runHooks(hookRemoved, stack, "")
+		return manager.portainerClient.SetEdgeStackStatus(int(stack.ID), portainer.EdgeStackStatusRemoved, stack.RollbackTo, retentionMessage)
 	}
 
 	return nil
 }
 
 func (manager *StackManager) waitForStatus(ctx context.Context, stackName string, requiredStatus libstack.Status) (libstack.Status, string, error) {
-	ctx, cancel := context.WithTimeout(ctx, 1*time.Minute)
+	ctx, cancel := clock.WithTimeout(ctx, manager.clock, DefaultStatusTimeout)
 	defer cancel()
 
-	statusCh := manager.deployer.WaitForStatus(ctx, stackName, requiredStatus)
+	return manager.observeStatus(ctx, manager.deployer, stackName, requiredStatus)
+}
+
+// observeStatus waits until the stack reaches the required status or the context is done
+func (manager *StackManager) observeStatus(ctx context.Context, deployer agent.Deployer, stackName string, requiredStatus libstack.Status) (libstack.Status, string, error) {
+
+	statusCh := deployer.WaitForStatus(ctx, stackName, requiredStatus)
 	result := <-statusCh
 
 	if result.ErrorMsg == "" {
 		// TODO: remove this once a proper implementation of WaitForStatus() is in place
-		if manager.engineType == EngineTypeKubernetes {
+		if manager.engineType == EngineTypeKubernetes && deployer == manager.deployer {
 			if requiredStatus == libstack.StatusCompleted {
 				requiredStatus = libstack.StatusRunning
 			}
@@ -531,18 +956,46 @@ func (manager *StackManager) validateStackFile(ctx context.Context, stack *edgeS
 
 	envVars := buildEnvVarsForDeployer(stack.EnvVars)
 
-	err := manager.deployer.Validate(ctx, stackName, []string{stackFileLocation},
-		agent.ValidateOptions{
-			DeployerBaseOptions: agent.DeployerBaseOptions{
-				Namespace:  stack.Namespace,
-				WorkingDir: stack.FileFolder,
-				Env:        envVars,
+	// The features and the WebAssembly runtimes are checked first, the engines reject them with less actionable errors.
+	// The image and the security policies are enforced before the images are pulled, the OPA policies apply
+	// to the stacks of any format.
+	err := manager.checkPayloadPolicy(ctx, stack, stackFileLocation)
+	if err == nil && stack.Format != client.StackFormatSystemd {
+		err = manager.checkImagePolicy(ctx, stack, stackFileLocation)
+		if err == nil {
+			err = manager.checkSecurityPolicy(stack, stackFileLocation)
+		}
+
+		if err == nil {
+			err = manager.checkComposeFeatures(stackFileLocation)
+		}
+
+		if err == nil {
+			err = manager.checkWasmRuntimes(stackFileLocation)
+		}
+	}
+
+	if err == nil {
+		err = manager.checkLint(stack, stackFileLocation)
+	}
+
+	if err == nil {
+		err = manager.deployerFor(stack).Validate(ctx, stackName, []string{stackFileLocation},
+			agent.ValidateOptions{
+				DeployerBaseOptions: agent.DeployerBaseOptions{
+					Namespace:  stack.Namespace,
+					WorkingDir: stack.FileFolder,
+					Env:        envVars,
+					Proxy:      manager.deployProxy,
+				},
 			},
-		},
-	)
+		)
+	}
+
 	if err != nil {
 		log.Error().Int("stack_identifier", int(stack.ID)).Err(err).Msg("stack validation failed")
-		stack.Status = StatusError
+		manager.setStatusMessage(stack, StatusError, err.Error())
+		runHooks(hookError, stack, err.Error())
 
 		statusUpdateErr := manager.portainerClient.SetEdgeStackStatus(stack.ID, portainer.EdgeStackStatusError, stack.RollbackTo, fmt.Errorf("failed to validate stack: %w", err).Error())
 		if statusUpdateErr != nil {
@@ -559,7 +1012,7 @@ func (manager *StackManager) pullImages(ctx context.Context, stack *edgeStack, s
 	manager.mu.Lock()
 	defer manager.mu.Unlock()
 
-	if stack.PullFinished || (!stack.PrePullImage && !stack.RePullImage && !stack.ReadyRePullImage) {
+	if stack.PullFinished || (!stack.PrePullImage && !stack.RePullImage && !stack.ReadyRePullImage && !manager.activationPending(stack) && stack.Barrier == "") {
 		return nil
 	}
 
@@ -570,31 +1023,64 @@ func (manager *StackManager) pullImages(ctx context.Context, stack *edgeStack, s
 		return fmt.Errorf("skip pulling")
 	}
 
-	stack.Status = StatusDeploying
+	manager.setStatus(stack, StatusDeploying)
 
-	envVars := buildEnvVarsForDeployer(stack.EnvVars)
+	envVars := manager.deployerEnv(stack)
 
-	err := manager.deployer.Pull(ctx, stackName, []string{stackFileLocation}, agent.PullOptions{
-		DeployerBaseOptions: agent.DeployerBaseOptions{
-			WorkingDir: stack.FileFolder,
-			Env:        envVars,
-		},
+	elapsed, imageBytes, err := manager.measure(func() error {
+		if err := chaos.PullError(); err != nil {
+			return err
+		}
+
+		var result pullResult
+		if stack.Format != client.StackFormatSystemd {
+			result = manager.pullWithProgress(ctx, stack, stackFileLocation)
+		}
+
+		if result.complete && len(result.failed) == 0 {
+			return nil
+		}
+
+		err := manager.deployerFor(stack).Pull(ctx, stackName, []string{stackFileLocation}, agent.PullOptions{
+			DeployerBaseOptions: agent.DeployerBaseOptions{
+				WorkingDir: stack.FileFolder,
+				Env:        envVars,
+				Proxy:      manager.deployProxy,
+			},
+		})
+		if err != nil && len(result.failed) > 0 {
+			return fmt.Errorf("%w (failed images: %s)", err, result.failures())
+		}
+
+		return err
 	})
+
+	stack.Usage.Pulls++
+	stack.Usage.PullTime += elapsed
+	stack.Usage.ImageBytes += imageBytes
+	defer manager.reportUsage(stack)
+
 	if err != nil {
+		class := errorClass(err)
+
 		log.Error().Err(err).
 			Int("stack_identifier", int(stack.ID)).
 			Int("PullCount", stack.PullCount).
+			Str("error_class", class).
 			Msg("images pull failed")
 
-		if stack.PullCount < maxRetries {
-			stack.Status = StatusRetry
+		manager.reportRemediation(stack.ID, err)
+
+		if class == client.StackErrorTransient && stack.PullCount < maxRetries {
+			manager.setStatus(stack, StatusRetry)
 
 			return err
 		}
 
-		stack.Status = StatusError
+		manager.setStatusMessage(stack, StatusError, err.Error())
+		runHooks(hookError, stack, err.Error())
 
-		statusUpdateErr := manager.portainerClient.SetEdgeStackStatus(stack.ID, portainer.EdgeStackStatusError, stack.RollbackTo, fmt.Errorf("failed to pull image: %w", err).Error())
+		statusUpdateErr := manager.portainerClient.SetEdgeStackError(stack.ID, stack.RollbackTo, fmt.Errorf("failed to pull image: %w", err).Error(), class)
 		if statusUpdateErr != nil {
 			log.Error().
 				Err(statusUpdateErr).
@@ -634,7 +1120,7 @@ func (manager *StackManager) deployStack(ctx context.Context, stack *edgeStack,
 		log.Error().Err(err).Msg("unable to update Edge stack status")
 	}
 
-	stack.Status = StatusDeploying
+	manager.setStatus(stack, StatusDeploying)
 
 	log.Debug().
 		Int("stack_identifier", int(stack.ID)).
@@ -645,47 +1131,100 @@ func (manager *StackManager) deployStack(ctx context.Context, stack *edgeStack,
 		Msg("stack deployment")
 
 	if stack.DeployCount > perHourRetries && stack.DeployCount%perHourRetries != 0 {
-		stack.Status = StatusRetry
+		manager.setStatus(stack, StatusRetry)
 
 		return
 	}
 
-	envVars := buildEnvVarsForDeployer(stack.EnvVars)
+	if err := verifyStackFiles(stack.FileFolder, stack.FileChecksums); err != nil {
+		manager.failIntegrityCheck(stack, err)
 
-	err = manager.deployer.Deploy(ctx, stackName, []string{stackFileLocation},
-		agent.DeployOptions{
-			DeployerBaseOptions: agent.DeployerBaseOptions{
-				Namespace:  stack.Namespace,
-				WorkingDir: stack.FileFolder,
-				Env:        envVars,
+		return
+	}
+
+	if manager.unchangedDeployment(ctx, stack, stackName) {
+		log.Info().
+			Int("stack_identifier", stack.ID).
+			Int("stack_version", stack.Version).
+			Msg("the stack is unchanged, skipping its deployment")
+
+		manager.finishDeploy(stack, stackName, upToDateMessage)
+
+		return
+	}
+
+	// A failed deployment may leave the stack partially updated
+	stack.DeployedFingerprint = ""
+
+	runHooks(hookBeforeDeploy, stack, "")
+
+	envVars := manager.deployerEnv(stack)
+
+	manager.migrateProject(ctx, stack, stackName, stackFileLocation, envVars)
+
+	elapsed, imageBytes, err := manager.measure(func() error {
+		if err := manager.createStackNetworks(ctx, stack); err != nil {
+			return err
+		}
+
+		return manager.deployerFor(stack).Deploy(ctx, stackName, []string{stackFileLocation},
+			agent.DeployOptions{
+				DeployerBaseOptions: agent.DeployerBaseOptions{
+					Namespace:  stack.Namespace,
+					WorkingDir: stack.FileFolder,
+					Env:        envVars,
+					Proxy:      manager.deployProxy,
+				},
+				HostBasePath: hostBasePath(stack),
 			},
-		},
-	)
+		)
+	})
+
+	stack.Usage.Deploys++
+	stack.Usage.DeployTime += elapsed
+	stack.Usage.ImageBytes += imageBytes
+	defer manager.reportUsage(stack)
 
 	if err != nil {
-		log.Error().Err(err).Int("DeployCount", stack.DeployCount).Msg("stack deployment failed")
+		class := errorClass(err)
+
+		log.Error().Err(err).Int("DeployCount", stack.DeployCount).Str("error_class", class).Msg("stack deployment failed")
+
+		manager.reportRemediation(stack.ID, err)
 
-		if stack.RetryDeploy && stack.DeployCount < maxRetries {
-			stack.Status = StatusRetry
+		if stack.RetryDeploy && class == client.StackErrorTransient && stack.DeployCount < maxRetries {
+			manager.setStatus(stack, StatusRetry)
 			return
 		}
 
-		stack.Status = StatusError
+		manager.setStatusMessage(stack, StatusError, err.Error())
+		runHooks(hookError, stack, err.Error())
 
-		if err := manager.portainerClient.SetEdgeStackStatus(stack.ID, portainer.EdgeStackStatusError, stack.RollbackTo, fmt.Errorf("failed to redeploy stack: %w", err).Error()); err != nil {
+		if err := manager.portainerClient.SetEdgeStackError(stack.ID, stack.RollbackTo, fmt.Errorf("failed to redeploy stack: %w", err).Error(), class); err != nil {
 			log.Error().Err(err).Msg("unable to update Edge stack status")
 		}
 
 		return
 	}
 
-	stack.Action = actionIdle
+	if manager.skipUnchanged {
+		stack.DeployedFingerprint, _ = manager.deployFingerprint(ctx, stack)
+	}
 
 	log.Debug().
 		Int("stack_identifier", int(stack.ID)).
 		Int("stack_version", stack.Version).Msg("stack deployed")
 
-	err = manager.portainerClient.SetEdgeStackStatus(int(stack.ID), portainer.EdgeStackStatusDeploymentReceived, stack.RollbackTo, "")
+	manager.finishDeploy(stack, stackName, "")
+}
+
+// finishDeploy reports the stack deployed as stackName, message is sent along with the DeploymentReceived status.
+// The caller must hold the manager lock.
+func (manager *StackManager) finishDeploy(stack *edgeStack, stackName, message string) {
+	stack.Action = actionIdle
+	stack.DeployedProject = stackName
+
+	err := manager.portainerClient.SetEdgeStackStatus(int(stack.ID), portainer.EdgeStackStatusDeploymentReceived, stack.RollbackTo, message)
 	if err != nil {
 		log.Error().Err(err).Msg("unable to update Edge stack status")
 	}
@@ -695,8 +1234,14 @@ func (manager *StackManager) deployStack(ctx context.Context, stack *edgeStack,
 		log.Error().Err(err).Msg("unable to backup successful Edge stack")
 	}
 
-	stack.Status = StatusAwaitingDeployedStatus
+	// A rolled back deployment is already part of the history
+	if stack.RolledBackVersion == 0 {
+		if err := manager.saveStackHistory(stack); err != nil {
+			log.Error().Err(err).Msg("unable to save Edge stack history")
+		}
+	}
 
+	manager.setStatus(stack, StatusAwaitingDeployedStatus)
 }
 
 func buildEnvVarsForDeployer(envVars []portainer.Pair) []string {
@@ -711,12 +1256,30 @@ func (manager *StackManager) deleteStack(ctx context.Context, stack *edgeStack,
 	manager.mu.Lock()
 	defer manager.mu.Unlock()
 
-	stack.Status = StatusRemoving
+	manager.setStatus(stack, StatusRemoving)
 	log.Debug().Int("stack_identifier", int(stack.ID)).Msg("removing stack")
 
+	if stack.NameConflict {
+		// The project belongs to another stack, the stack was never deployed
+		manager.removeStackFileFolders(stack)
+
+		manager.removeStack(stack)
+		manager.deleteRegistryCredentials(stack)
+		manager.updateBatchMember(stack, true)
+		runHooks(hookRemoved, stack, "")
+
+		if err := manager.portainerClient.SetEdgeStackStatus(stack.ID, portainer.EdgeStackStatusRemoved, stack.RollbackTo, ""); err != nil {
+			log.Error().Err(err).Msg("unable to update Edge stack status")
+		}
+
+		return
+	}
+
 	successFileFolder := SuccessStackFileFolder(stack.FileFolder)
 
-	if err := manager.deployer.Remove(
+	manager.collectAnonymousVolumes(stack)
+
+	if err := manager.deployerFor(stack).Remove(
 		ctx,
 		stackName,
 		[]string{stackFileLocation},
@@ -738,7 +1301,14 @@ func (manager *StackManager) deleteStack(ctx context.Context, stack *edgeStack,
 		return
 	}
 
-	stack.Status = StatusAwaitingRemovedStatus
+	manager.setStatus(stack, StatusAwaitingRemovedStatus)
+
+	manager.removeStackFileFolders(stack)
+}
+
+// removeStackFileFolders removes the folders of a stack being removed
+func (manager *StackManager) removeStackFileFolders(stack *edgeStack) {
+	successFileFolder := SuccessStackFileFolder(stack.FileFolder)
 
 	// Remove stack file folder
 	if err := os.RemoveAll(stack.FileFolder); err != nil {
@@ -753,6 +1323,22 @@ func (manager *StackManager) deleteStack(ctx context.Context, stack *edgeStack,
 			Str("stack_success_file_folder", successFileFolder).
 			Msg("Unable to delete Edge stack success folder")
 	}
+
+	// Remove stack versions folder
+	versionsFileFolder := VersionsStackFileFolder(stack.FileFolder)
+	if err := os.RemoveAll(versionsFileFolder); err != nil {
+		log.Error().Err(err).
+			Str("stack_versions_file_folder", versionsFileFolder).
+			Msg("Unable to delete Edge stack versions folder")
+	}
+
+	// Remove stack history folder
+	historyFileFolder := HistoryStackFileFolder(stack.FileFolder)
+	if err := os.RemoveAll(historyFileFolder); err != nil {
+		log.Error().Err(err).
+			Str("stack_history_file_folder", historyFileFolder).
+			Msg("Unable to delete Edge stack history folder")
+	}
 }
 
 func (manager *StackManager) SetEngineStatus(engineStatus engineType) error {
@@ -771,8 +1357,73 @@ func (manager *StackManager) SetEngineStatus(engineStatus engineType) error {
 	if err != nil {
 		return err
 	}
+	if manager.sessionRecorder != nil {
+		deployer = session.NewRecordingDeployer(deployer, manager.sessionRecorder)
+	}
 	manager.deployer = deployer
 
+	manager.hostProjects = nil
+	if engineStatus == EngineTypeDockerStandalone {
+		manager.hostProjects = docker.GetComposeProjects
+	}
+
+	manager.imagePuller = nil
+	manager.imageID = nil
+	if engineStatus == EngineTypeDockerStandalone {
+		manager.imagePuller = docker.PullImage
+		manager.imageID = docker.ImageID
+	}
+
+	manager.capabilities = runtimeCapabilities
+	manager.imageLayersSize = nil
+	if engineStatus == EngineTypeDockerStandalone || engineStatus == EngineTypeDockerSwarm {
+		manager.capabilities = dockerCapabilities
+		manager.imageLayersSize = docker.GetImageLayersSize
+	}
+
+	manager.engineVersions = nil
+	if engineStatus == EngineTypeDockerStandalone || engineStatus == EngineTypeDockerSwarm {
+		manager.engineVersions = dockerEngineVersions(manager.assetsPath, engineStatus == EngineTypeDockerStandalone)
+	}
+
+	manager.wasmRuntime = nil
+	switch engineStatus {
+	case EngineTypeDockerStandalone:
+		manager.wasmRuntime = dockerWasmRuntime
+	case EngineTypeContainerd:
+		manager.wasmRuntime = containerdWasmRuntime
+	case EngineTypeKubernetes:
+		manager.wasmRuntime = kubernetesWasmRuntime
+	}
+
+	manager.exitCodes = nil
+	manager.serviceStatuses = nil
+	switch engineStatus {
+	case EngineTypeDockerStandalone:
+		manager.exitCodes = composeExitCodes
+		manager.serviceStatuses = composeServiceStatuses
+	case EngineTypeDockerSwarm:
+		manager.exitCodes = swarmExitCodes
+		manager.serviceStatuses = swarmServiceStatuses
+	}
+
+	manager.resources = nil
+	manager.retention = nil
+	if engineStatus == EngineTypeDockerStandalone || engineStatus == EngineTypeDockerSwarm {
+		manager.resources = dockerResources{}
+		manager.retention = dockerRetention{}
+	}
+
+	// The macvlan networks of a swarm are created on each node, they are not supported
+	manager.hostNetworks = nil
+	manager.removeHostNetwork = nil
+	manager.publishedPorts = nil
+	if engineStatus == EngineTypeDockerStandalone {
+		manager.hostNetworks = docker.EnsureHostNetwork
+		manager.removeHostNetwork = docker.RemoveHostNetwork
+		manager.publishedPorts = dockerPublishedPorts
+	}
+
 	return nil
 }
 
@@ -786,27 +1437,39 @@ func buildDeployerService(assetsPath string, engineStatus engineType) (agent.Dep
 		return exec.NewKubernetesDeployer(assetsPath), nil
 	case EngineTypeNomad:
 		return nomad.NewDeployer()
+	case EngineTypeContainerd:
+		return exec.NewNerdctlComposeStackService(assetsPath)
 	}
 
 	return nil, fmt.Errorf("engine status %d not supported", engineStatus)
 }
 
-func (manager *StackManager) DeployStack(ctx context.Context, stackData edge.StackPayload) error {
+func (manager *StackManager) DeployStack(ctx context.Context, stackData client.StackPayload) error {
 	return manager.buildDeployerParams(stackData, false)
 }
 
-func (manager *StackManager) DeleteStack(ctx context.Context, stackData edge.StackPayload) error {
+func (manager *StackManager) DeleteStack(ctx context.Context, stackData client.StackPayload) error {
 	return manager.buildDeployerParams(stackData, true)
 }
 
-func (manager *StackManager) buildDeployerParams(stackPayload edge.StackPayload, deleteStack bool) error {
+func (manager *StackManager) buildDeployerParams(stackPayload client.StackPayload, deleteStack bool) error {
 	var err error
 	var stack *edgeStack
 
+	syncStart := manager.now()
+
 	// The stack information will be shared with edge agent registry server (request by docker credential helper)
 	manager.mu.Lock()
 	defer manager.mu.Unlock()
 
+	if !deleteStack && manager.isRolledBackBatchStack(stackPayload.ID, stackPayload.Version) {
+		return nil
+	}
+
+	if !deleteStack && manager.deferRollout(stackPayload.ID, stackPayload.Version, "", stackPayload.Rollout) {
+		return nil
+	}
+
 	originalStack, processedStack := manager.stacks[edgeStackID(stackPayload.ID)]
 	if processedStack {
 		// update the cloned stack to keep data consistency
@@ -818,7 +1481,8 @@ func (manager *StackManager) buildDeployerParams(stackPayload edge.StackPayload,
 
 			stack.Action = actionDelete
 		} else {
-			if stack.Version == stackPayload.Version && !stackPayload.ReadyRePullImage {
+			desired := client.StackStatus{ID: stackPayload.ID, Version: stackPayload.Version, ReadyRePullImage: stackPayload.ReadyRePullImage}
+			if _, changed := desiredStackOperation(stack, desired); !changed {
 				return nil
 			}
 
@@ -851,8 +1515,14 @@ func (manager *StackManager) buildDeployerParams(stackPayload edge.StackPayload,
 
 	stack.Name = stackPayload.Name
 	stack.RegistryCredentials = stackPayload.RegistryCredentials
+	manager.saveRegistryCredentials(stack)
+	stack.Priority = stackPayload.Priority
+	if !deleteStack {
+		stack.Format = stackPayload.Format
+		stack.ProjectName = stackPayload.ProjectName
+	}
 
-	stack.Status = StatusPending
+	manager.setStatus(stack, StatusPending)
 	stack.Version = stackPayload.Version
 
 	stack.PrePullImage = stackPayload.PrePullImage
@@ -860,41 +1530,147 @@ func (manager *StackManager) buildDeployerParams(stackPayload edge.StackPayload,
 	stack.RetryDeploy = stackPayload.RetryDeploy
 	stack.PullCount = 0
 	stack.PullFinished = false
+	stack.PulledImages = nil
 	stack.DeployCount = 0
+	stack.RolledBackVersion = 0
 
 	stack.SupportRelativePath = stackPayload.SupportRelativePath
 	stack.FilesystemPath = stackPayload.FilesystemPath
 	stack.FileName = stackPayload.EntryFileName
 	stack.FileFolder = getStackFileFolder(stack)
-	stack.EnvVars = stackPayload.EnvVars
+	stack.EnvVars = append(stackPayload.EnvVars, portainer.Pair{Name: agent.EdgeStackIdEnvVarName, Value: strconv.Itoa(stack.ID)})
 	stack.Namespace = stackPayload.Namespace
+	stack.StatusTimeout = stackPayload.StatusTimeout
+	stack.StatusCheckInterval = stackPayload.StatusCheckInterval
+	stack.Job = stackPayload.Job
+	stack.JobRemoved = false
+	stack.RestartSamples = nil
 
-	err = filesystem.DecodeDirEntries(stackPayload.DirEntries)
-	if err != nil {
-		return err
+	if !deleteStack {
+		stack.NameConflict = false
+		if err := manager.checkStackNameConflict(stack); err != nil {
+			manager.markNameConflict(stack, err)
+
+			return err
+		}
+
+		if err := manager.checkStackRequirements(stackPayload.Requirements); err != nil {
+			manager.markUnschedulable(stack, err)
+
+			return err
+		}
+
+		if err := manager.checkStackNetworks(stackPayload.Networks, stackPayload.Format); err != nil {
+			manager.markUnschedulable(stack, err)
+
+			return err
+		}
+
+		envVars, err := manager.mergeEnvFiles(stack.EnvVars, stackPayload.EnvFiles)
+		if err != nil {
+			manager.markUnschedulable(stack, err)
+
+			return err
+		}
+
+		stack.EnvVars = envVars
+
+		stack.ActivateAt, err = activationTime(stackPayload.Activation, time.Local)
+		if err != nil {
+			return err
+		}
+
+		stack.Barrier = stackPayload.Barrier
+		stack.BarrierReleased = false
+		stack.HostNetworks = stackPayload.Networks
+	}
+
+	// The retention policy sent along with the removal overrides the one of the deployed version
+	if !deleteStack || stackPayload.Retention != nil {
+		stack.Retention = stackPayload.Retention
 	}
 
-	err = manager.addRegistryToEntryFile(&stackPayload)
+	err = agentfs.DecodeDirEntries(stackPayload.DirEntries, stackPayload.FileEncodings)
 	if err != nil {
 		return err
 	}
 
+	if !deleteStack && stackPayload.Artifact != nil {
+		dirEntries, err := manager.pullStackArtifact(context.TODO(), stack, stackPayload.Artifact)
+		if err != nil {
+			if errors.Is(err, oci.ErrVerification) {
+				manager.putStack(stack)
+				manager.markIntegrityError(stack, err)
+			}
+
+			return err
+		}
+
+		stackPayload.DirEntries = dirEntries
+	}
+
 	if !deleteStack {
-		err = filesystem.PersistDir(stack.FileFolder, stackPayload.DirEntries)
+		if err := verifyDirEntries(stackPayload.DirEntries, stackPayload.FileChecksums); err != nil {
+			manager.putStack(stack)
+			manager.markIntegrityError(stack, err)
+
+			return err
+		}
+	}
+
+	// The units of the native stacks are deployed as declared
+	if stack.Format != client.StackFormatSystemd {
+		err = manager.addRegistryToEntryFile(&stackPayload)
+		if err != nil {
+			return err
+		}
+
+		if !deleteStack {
+			manager.addOwnershipLabels(stack, &stackPayload.StackPayload)
+		}
+
+		err = manager.rewriteRelativePaths(stack, &stackPayload.StackPayload)
 		if err != nil {
 			return err
 		}
 	}
 
-	manager.stacks[edgeStackID(stack.ID)] = stack
+	if !deleteStack {
+		if err := attachStackNetworks(&stackPayload); err != nil {
+			manager.markUnschedulable(stack, err)
 
-	return nil
-}
+			return err
+		}
+
+		if err := expandEnvFiles(&stackPayload, stack.EnvVars); err != nil {
+			return err
+		}
+	}
+
+	stack.Registries = imageRegistries(stack, &stackPayload.StackPayload)
+
+	stack.FileChecksums = persistedChecksums(stackPayload.DirEntries, stackPayload.FileChecksums)
+
+	if !deleteStack {
+		stack.LargeFiles = stackPayload.LargeFiles
+		stack.LargeFilesWritten = false
+		stack.DownloadCount = 0
 
-func (manager *StackManager) GetEdgeRegistryCredentials() []edge.RegistryCredentials {
-	for _, stack := range manager.stacks {
-		if stack.Status == StatusDeploying {
-			return stack.RegistryCredentials
+		err = manager.persistStackFiles(stack, stackPayload.DirEntries)
+		if err != nil {
+			return err
+		}
+
+		manager.accountSync(stack, stackPayload.DirEntries, syncStart)
+	}
+
+	manager.putStack(stack)
+
+	if !deleteStack {
+		if err := verifyStackFiles(stack.FileFolder, stack.FileChecksums); err != nil {
+			manager.markIntegrityError(stack, err)
+
+			return err
 		}
 	}
 
@@ -2,14 +2,24 @@ package stack
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/portainer/agent"
+	"github.com/portainer/agent/clock"
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/agent/edge/stack/stacktest"
 	"github.com/portainer/agent/internals/mocks"
 	portainer "github.com/portainer/portainer/api"
 	"github.com/portainer/portainer/api/edge"
+	"github.com/portainer/portainer/api/filesystem"
+	"github.com/portainer/portainer/pkg/libstack"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	gomock "go.uber.org/mock/gomock"
 )
 
@@ -48,6 +58,7 @@ func TestStackManager_pullImages(t *testing.T) {
 		}).Return(nil)
 
 		mockPortainerClient.EXPECT().SetEdgeStackStatus(int(stack.ID), portainer.EdgeStackStatusImagesPulled, stack.RollbackTo, "").Return(nil)
+		mockPortainerClient.EXPECT().SetEdgeStackUsage(stack.ID, gomock.Any()).Return(nil)
 
 		err := manager.pullImages(ctx, stack, stackName, stackFileLocation)
 		assert.NoError(t, err)
@@ -76,6 +87,7 @@ func TestStackManager_pullImages(t *testing.T) {
 				Env:        buildEnvVarsForDeployer(stack.EnvVars),
 			},
 		}).Return(errors.New("pull failed"))
+		mockPortainerClient.EXPECT().SetEdgeStackUsage(stack.ID, gomock.Any()).Return(nil)
 
 		err := manager.pullImages(ctx, stack, stackName, stackFileLocation)
 		assert.Error(t, err)
@@ -83,6 +95,38 @@ func TestStackManager_pullImages(t *testing.T) {
 		assert.Equal(t, StatusRetry, stack.Status)
 	})
 
+	t.Run("Pull images failed with a terminal error", func(t *testing.T) {
+		stack := &edgeStack{
+			PullCount:    0,
+			Status:       StatusPending,
+			PullFinished: false,
+			FileFolder:   "/path/to/stack",
+			StackPayload: edge.StackPayload{
+				ID:           1,
+				PrePullImage: true,
+			},
+		}
+
+		ctx := context.Background()
+		stackName := "my-stack"
+		stackFileLocation := "/path/to/stack/stack.yml"
+
+		mockDeployer.EXPECT().Pull(ctx, stackName, []string{stackFileLocation}, agent.PullOptions{
+			DeployerBaseOptions: agent.DeployerBaseOptions{
+				WorkingDir: stack.FileFolder,
+				Env:        buildEnvVarsForDeployer(stack.EnvVars),
+			},
+		}).Return(errors.New("exit status 1: Error response from daemon: manifest for nginx:9.9 not found: manifest unknown"))
+		mockPortainerClient.EXPECT().SetEdgeStackUsage(stack.ID, gomock.Any()).Return(nil)
+		mockPortainerClient.EXPECT().SetEdgeStackRemediation(stack.ID, gomock.Any()).Return(nil)
+		mockPortainerClient.EXPECT().SetEdgeStackError(stack.ID, stack.RollbackTo, "failed to pull image: exit status 1: Error response from daemon: manifest for nginx:9.9 not found: manifest unknown", client.StackErrorTerminal).Return(nil)
+
+		err := manager.pullImages(ctx, stack, stackName, stackFileLocation)
+		assert.Error(t, err)
+		assert.False(t, stack.PullFinished)
+		assert.Equal(t, StatusError, stack.Status)
+	})
+
 	t.Run("Skip pulling images", func(t *testing.T) {
 		stack := &edgeStack{
 			PullCount:    0,
@@ -146,6 +190,7 @@ func TestStackManager_deployStack(t *testing.T) {
 			},
 		}).Return(nil)
 		mockPortainerClient.EXPECT().SetEdgeStackStatus(int(stack.ID), portainer.EdgeStackStatusDeploymentReceived, stack.RollbackTo, "").Return(nil)
+		mockPortainerClient.EXPECT().SetEdgeStackUsage(stack.ID, gomock.Any()).Return(nil)
 
 		manager.deployStack(ctx, stack, stackName, stackFileLocation)
 
@@ -181,6 +226,7 @@ func TestStackManager_deployStack(t *testing.T) {
 				Env:        buildEnvVarsForDeployer(stack.EnvVars),
 			},
 		}).Return(errors.New("deploy failed"))
+		mockPortainerClient.EXPECT().SetEdgeStackUsage(stack.ID, gomock.Any()).Return(nil)
 
 		manager.deployStack(ctx, stack, stackName, stackFileLocation)
 
@@ -216,11 +262,231 @@ func TestStackManager_deployStack(t *testing.T) {
 				Env:        buildEnvVarsForDeployer(stack.EnvVars),
 			},
 		}).Return(errors.New("deploy failed"))
-		mockPortainerClient.EXPECT().SetEdgeStackStatus(stack.ID, portainer.EdgeStackStatusError, stack.RollbackTo, "failed to redeploy stack: deploy failed").Return(nil)
+		mockPortainerClient.EXPECT().SetEdgeStackUsage(stack.ID, gomock.Any()).Return(nil)
+		mockPortainerClient.EXPECT().SetEdgeStackError(stack.ID, stack.RollbackTo, "failed to redeploy stack: deploy failed", client.StackErrorTransient).Return(nil)
 
 		manager.deployStack(ctx, stack, stackName, stackFileLocation)
 
 		assert.Equal(t, StatusError, stack.Status)
 		assert.Equal(t, actionIdle, stack.Action)
 	})
+
+	t.Run("Deploy stack failed with a terminal error", func(t *testing.T) {
+		ctx := context.Background()
+		stack := &edgeStack{
+			DeployCount: 0,
+			Status:      StatusPending,
+			FileFolder:  "/path/to/stack",
+			Action:      actionIdle,
+
+			StackPayload: edge.StackPayload{
+				ID:          1,
+				RetryDeploy: true,
+				Namespace:   "default",
+				Version:     1,
+			},
+		}
+
+		stackName := "my-stack"
+		stackFileLocation := "/path/to/stack/stack.yml"
+
+		mockPortainerClient.EXPECT().SetEdgeStackStatus(int(stack.ID), portainer.EdgeStackStatusDeploying, stack.RollbackTo, "").Return(nil)
+		mockDeployer.EXPECT().Deploy(ctx, stackName, []string{stackFileLocation}, gomock.Any()).
+			Return(errors.New("exit status 1: pull access denied for private/app, repository does not exist or may require 'docker login'"))
+		mockPortainerClient.EXPECT().SetEdgeStackUsage(stack.ID, gomock.Any()).Return(nil)
+		mockPortainerClient.EXPECT().SetEdgeStackRemediation(stack.ID, gomock.Any()).Return(nil)
+		mockPortainerClient.EXPECT().SetEdgeStackError(stack.ID, stack.RollbackTo, gomock.Any(), client.StackErrorTerminal).Return(nil)
+
+		manager.deployStack(ctx, stack, stackName, stackFileLocation)
+
+		assert.Equal(t, StatusError, stack.Status)
+	})
+}
+
+func TestStackManager_nextPendingStack(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("Pending stack is returned without waiting", func(t *testing.T) {
+		fakeClock := clock.NewFakeClock(start)
+		manager := &StackManager{
+			clock: fakeClock,
+			stacks: map[edgeStackID]*edgeStack{
+				1: {StackPayload: edge.StackPayload{ID: 1}, Status: StatusPending},
+			},
+		}
+
+		stack := manager.nextPendingStack()
+		assert.NotNil(t, stack)
+		assert.Equal(t, 1, stack.ID)
+		assert.Equal(t, start, fakeClock.Now())
+	})
+
+	t.Run("Awaiting stack is returned after the queue interval", func(t *testing.T) {
+		fakeClock := clock.NewFakeClock(start)
+		manager := &StackManager{
+			clock: fakeClock,
+			stacks: map[edgeStackID]*edgeStack{
+				1: {StackPayload: edge.StackPayload{ID: 1}, Status: StatusAwaitingDeployedStatus},
+			},
+		}
+
+		stack := manager.nextPendingStack()
+		assert.NotNil(t, stack)
+		assert.Equal(t, start.Add(queueSleepInterval), fakeClock.Now())
+	})
+
+	t.Run("Retry stack is set back to pending", func(t *testing.T) {
+		fakeClock := clock.NewFakeClock(start)
+		manager := &StackManager{
+			clock: fakeClock,
+			stacks: map[edgeStackID]*edgeStack{
+				1: {StackPayload: edge.StackPayload{ID: 1}, Status: StatusRetry},
+			},
+		}
+
+		stack := manager.nextPendingStack()
+		assert.Nil(t, stack)
+		assert.Equal(t, StatusPending, manager.stacks[1].Status)
+
+		stack = manager.nextPendingStack()
+		assert.NotNil(t, stack)
+	})
+}
+
+func TestStackManager_waitForStatus_timeout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	fakeClock := clock.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	mockDeployer := mocks.NewMockDeployer(ctrl)
+
+	manager := &StackManager{
+		deployer: mockDeployer,
+		clock:    fakeClock,
+	}
+
+	mockDeployer.EXPECT().WaitForStatus(gomock.Any(), "my-stack", libstack.StatusRunning).DoAndReturn(
+		func(ctx context.Context, name string, status libstack.Status) <-chan libstack.WaitResult {
+			ch := make(chan libstack.WaitResult, 1)
+
+			go func() {
+				<-ctx.Done()
+				ch <- libstack.WaitResult{Status: libstack.StatusError, ErrorMsg: "timeout"}
+			}()
+
+			return ch
+		})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		status, msg, err := manager.waitForStatus(context.Background(), "my-stack", libstack.StatusRunning)
+		assert.NoError(t, err)
+		assert.Equal(t, libstack.StatusError, status)
+		assert.Equal(t, "timeout", msg)
+	}()
+
+	// keep advancing the fake time until the timeout waiter has been registered and fired
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		fakeClock.Advance(time.Minute)
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestGetStackFileFolder(t *testing.T) {
+	for _, fixture := range stacktest.Fixtures() {
+		t.Run(fixture.Name, func(t *testing.T) {
+			stack := &edgeStack{StackPayload: fixture.Payload.StackPayload}
+
+			expected := fixture.Folder
+			if !fixture.Payload.SupportRelativePath {
+				expected = filepath.Join(agent.EdgeStackFilesPath, fixture.Folder)
+			}
+
+			assert.Equal(t, filepath.FromSlash(expected), getStackFileFolder(stack))
+		})
+	}
+}
+
+var fixtureEngines = map[string]engineType{
+	stacktest.EngineDocker:     EngineTypeDockerStandalone,
+	stacktest.EngineKubernetes: EngineTypeKubernetes,
+	stacktest.EngineNomad:      EngineTypeNomad,
+}
+
+func TestStackManager_addRegistryToEntryFile(t *testing.T) {
+	for _, fixture := range stacktest.Fixtures() {
+		t.Run(fixture.Name, func(t *testing.T) {
+			manager := &StackManager{engineType: fixtureEngines[fixture.Engine]}
+
+			require.NoError(t, manager.addRegistryToEntryFile(fixture.Payload))
+			assert.Equal(t, fixture.EntryFile(), *entryFileContent(&fixture.Payload.StackPayload))
+		})
+	}
+
+	// The credentials of the updater are only added on the Docker engines
+	fixture := stacktest.Get(t, "edge-update")
+	manager := &StackManager{engineType: EngineTypeNomad}
+
+	require.NoError(t, manager.addRegistryToEntryFile(fixture.Payload))
+	assert.NotContains(t, *entryFileContent(&fixture.Payload.StackPayload), "REGISTRY_PASSWORD")
+
+	fixture.Payload.EntryFileName = "missing.yml"
+	assert.Error(t, manager.addRegistryToEntryFile(fixture.Payload))
+}
+
+func TestStackManager_persistStackFiles_layouts(t *testing.T) {
+	for _, fixture := range stacktest.Fixtures() {
+		t.Run(fixture.Name, func(t *testing.T) {
+			manager := &StackManager{engineType: fixtureEngines[fixture.Engine], clock: clock.NewSystemClock()}
+
+			require.NoError(t, manager.addRegistryToEntryFile(fixture.Payload))
+
+			stack := &edgeStack{StackPayload: fixture.Payload.StackPayload, FileFolder: filepath.Join(t.TempDir(), "stack")}
+			require.NoError(t, manager.persistStackFiles(stack, fixture.Payload.DirEntries))
+
+			stacktest.AssertLayout(t, resolveStackFileFolder(stack.FileFolder), fixture)
+		})
+	}
+}
+
+func TestStackManager_DeployStack_systemd(t *testing.T) {
+	filesPath := agent.EdgeStackFilesPath
+	agent.EdgeStackFilesPath = t.TempDir()
+	defer func() { agent.EdgeStackFilesPath = filesPath }()
+
+	unit := "[Unit]\nDescription=Sensor collector\n\n[Service]\nExecStart=/opt/sensor/collector\n"
+
+	manager := NewStackManager(nil, "", nil, "device-1")
+	manager.engineType = EngineTypeDockerStandalone
+
+	stackPayload := client.StackPayload{
+		StackPayload: edge.StackPayload{
+			ID:            3,
+			Name:          "sensor",
+			Version:       1,
+			EntryFileName: "sensor.service",
+			DirEntries:    []filesystem.DirEntry{{Name: "sensor.service", Content: base64.StdEncoding.EncodeToString([]byte(unit)), IsFile: true}},
+		},
+		Format: client.StackFormatSystemd,
+	}
+
+	require.NoError(t, manager.DeployStack(context.Background(), stackPayload))
+
+	stack := manager.stacks[3]
+	require.NotNil(t, stack)
+	assert.Equal(t, client.StackFormatSystemd, stack.Format)
+	assert.Equal(t, manager.nativeDeployer, manager.deployerFor(stack))
+
+	// The unit is persisted as declared, without the rewrites of the compose files
+	content, err := os.ReadFile(filepath.Join(stack.FileFolder, "sensor.service"))
+	require.NoError(t, err)
+	assert.Equal(t, unit, string(content))
 }
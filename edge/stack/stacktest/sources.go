@@ -0,0 +1,96 @@
+package stacktest
+
+// The stack files sent by the server, the golden folder holds them once persisted
+
+const composeFile = `services:
+  web:
+    image: nginx:1.25
+    ports:
+      - "${NGINX_PORT}:80"
+    volumes:
+      - ./config/nginx.conf:/etc/nginx/nginx.conf:ro
+    restart: unless-stopped
+`
+
+const nginxConf = `events {}
+
+http {
+  server {
+    listen 80;
+    location / {
+      return 200 'ok';
+    }
+  }
+}
+`
+
+const kubernetesManifest = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  replicas: 1
+  selector:
+    matchLabels:
+      app: web
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      containers:
+        - name: web
+          image: nginx:1.25
+`
+
+const nomadJob = `job "web" {
+  datacenters = ["dc1"]
+
+  group "web" {
+    task "nginx" {
+      driver = "docker"
+
+      config {
+        image = "nginx:1.25"
+      }
+    }
+  }
+}
+`
+
+const relativePathComposeFile = `services:
+  collector:
+    image: registry.example.com/iot/collector:2.1
+    volumes:
+      - ./data:/data
+`
+
+const privateKubernetesManifest = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: private
+spec:
+  selector:
+    matchLabels:
+      app: private
+  template:
+    metadata:
+      labels:
+        app: private
+    spec:
+      containers:
+        - name: private
+          image: registry.example.com/team/private:1.0
+`
+
+const updaterComposeFile = `version: "3"
+services:
+  updater:
+    image: registry.example.com/portainer/portainer-updater:latest
+    labels:
+      - io.portainer.hideStack=true
+      - io.portainer.updater=true
+    command: ["portainer", "--image", "registry.example.com/portainer/portainer-ee:2.19", "--env-type", "standalone"]
+    volumes:
+      - /var/run/docker.sock:/var/run/docker.sock
+`
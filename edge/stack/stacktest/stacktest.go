@@ -0,0 +1,232 @@
+// Package stacktest provides realistic Edge stack payloads along with the golden layouts of the files the agent
+// persists for them, so that the changes to the stack pipeline can be regression tested.
+package stacktest
+
+import (
+	"embed"
+	"encoding/base64"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/portainer/api/edge"
+	"github.com/portainer/portainer/api/filesystem"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Engines the fixtures target
+const (
+	EngineDocker     = "docker"
+	EngineKubernetes = "kubernetes"
+	EngineNomad      = "nomad"
+)
+
+//go:embed all:golden
+var golden embed.FS
+
+// Fixture is a stack payload as sent by the Portainer server
+type Fixture struct {
+	Name   string
+	Engine string
+	// Payload is a new payload at each call of Fixtures, the pipeline alters the payloads it processes
+	Payload *client.StackPayload
+	// Folder is the folder the files of the stack are persisted in, relative to the folder of the Edge stack
+	// files unless the stack supports relative paths
+	Folder string
+}
+
+// Layout returns the golden layout of the fixture, the content of the files persisted by the agent indexed by
+// their slash-separated path. The entry file includes the registry credentials added for the engine.
+func (fixture Fixture) Layout() map[string]string {
+	layout := make(map[string]string)
+
+	root := path.Join("golden", fixture.Name)
+
+	err := fs.WalkDir(golden, root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		content, err := golden.ReadFile(name)
+		if err != nil {
+			return err
+		}
+
+		relative := name[len(root)+1:]
+		layout[relative] = string(content)
+
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return layout
+}
+
+// EntryFile returns the golden content of the entry file of the fixture
+func (fixture Fixture) EntryFile() string {
+	return fixture.Layout()[fixture.Payload.EntryFileName]
+}
+
+// EncodedPayload returns a copy of the payload with the content of its files base64 encoded, as sent by the server
+func (fixture Fixture) EncodedPayload() *client.StackPayload {
+	encoded := *fixture.Payload
+	encoded.DirEntries = make([]filesystem.DirEntry, len(fixture.Payload.DirEntries))
+
+	for i, entry := range fixture.Payload.DirEntries {
+		if entry.IsFile {
+			entry.Content = base64.StdEncoding.EncodeToString([]byte(entry.Content))
+		}
+
+		encoded.DirEntries[i] = entry
+	}
+
+	return &encoded
+}
+
+// Fixtures returns the fixtures, with new payloads
+func Fixtures() []Fixture {
+	return []Fixture{
+		{
+			Name:   "compose",
+			Engine: EngineDocker,
+			Payload: payload(1, "web", "docker-compose.yml",
+				file("docker-compose.yml", composeFile),
+				file(".env", "NGINX_PORT=8080\n"),
+				dir("config"),
+				file("config/nginx.conf", nginxConf),
+			),
+			Folder: "1",
+		},
+		{
+			Name:    "kubernetes",
+			Engine:  EngineKubernetes,
+			Payload: withNamespace(payload(2, "web", "manifest.yml", file("manifest.yml", kubernetesManifest)), "edge"),
+			Folder:  "2",
+		},
+		{
+			Name:    "nomad",
+			Engine:  EngineNomad,
+			Payload: payload(3, "web", "web.hcl", file("web.hcl", nomadJob)),
+			Folder:  "3",
+		},
+		{
+			Name:   "relative-path",
+			Engine: EngineDocker,
+			Payload: withRelativePath(payload(4, "sensors", "docker-compose.yml",
+				file("docker-compose.yml", relativePathComposeFile),
+				dir("data"),
+				file("data/sensors.json", "{\"interval\": 10}\n"),
+			), "/opt/edge"),
+			Folder: "/opt/edge/portainer-compose-unpacker/4",
+		},
+		{
+			Name:    "registry-credentials",
+			Engine:  EngineKubernetes,
+			Payload: withCredentials(payload(5, "private", "manifest.yml", file("manifest.yml", privateKubernetesManifest))),
+			Folder:  "5",
+		},
+		{
+			Name:    "edge-update",
+			Engine:  EngineDocker,
+			Payload: withEdgeUpdate(withCredentials(payload(6, "portainer-updater", "docker-compose.yml", file("docker-compose.yml", updaterComposeFile))), 3),
+			Folder:  "6",
+		},
+	}
+}
+
+// Get returns the fixture named name, it fails the test when there is none
+func Get(t testing.TB, name string) Fixture {
+	t.Helper()
+
+	for _, fixture := range Fixtures() {
+		if fixture.Name == name {
+			return fixture
+		}
+	}
+
+	require.FailNow(t, "unknown stack fixture", name)
+
+	return Fixture{}
+}
+
+// AssertLayout asserts that the files of folder match the golden layout of the fixture
+func AssertLayout(t testing.TB, folder string, fixture Fixture) {
+	t.Helper()
+
+	got := make(map[string]string)
+
+	err := filepath.WalkDir(folder, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		content, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+
+		relative, err := filepath.Rel(folder, name)
+		if err != nil {
+			return err
+		}
+
+		got[filepath.ToSlash(relative)] = string(content)
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, fixture.Layout(), got)
+}
+
+func payload(id int, name, entryFile string, dirEntries ...filesystem.DirEntry) *client.StackPayload {
+	return &client.StackPayload{StackPayload: edge.StackPayload{
+		ID:            id,
+		Name:          name,
+		Version:       1,
+		EntryFileName: entryFile,
+		DirEntries:    dirEntries,
+	}}
+}
+
+func file(name, content string) filesystem.DirEntry {
+	return filesystem.DirEntry{Name: name, Content: content, IsFile: true, Permissions: 0644}
+}
+
+func dir(name string) filesystem.DirEntry {
+	return filesystem.DirEntry{Name: name, Permissions: 0755}
+}
+
+func withNamespace(stackPayload *client.StackPayload, namespace string) *client.StackPayload {
+	stackPayload.Namespace = namespace
+
+	return stackPayload
+}
+
+func withRelativePath(stackPayload *client.StackPayload, filesystemPath string) *client.StackPayload {
+	stackPayload.SupportRelativePath = true
+	stackPayload.FilesystemPath = filesystemPath
+
+	return stackPayload
+}
+
+func withCredentials(stackPayload *client.StackPayload) *client.StackPayload {
+	stackPayload.RegistryCredentials = []edge.RegistryCredentials{
+		{ServerURL: "registry.example.com", Username: "edge", Secret: "s3cret"},
+	}
+
+	return stackPayload
+}
+
+func withEdgeUpdate(stackPayload *client.StackPayload, edgeUpdateID int) *client.StackPayload {
+	stackPayload.EdgeUpdateID = edgeUpdateID
+
+	return stackPayload
+}
@@ -0,0 +1,216 @@
+package stack
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// maxStatusTransitions is the number of transitions kept in the log of each stack
+const maxStatusTransitions = 20
+
+// statusTransition represents a change of status of an Edge stack
+type statusTransition struct {
+	From edgeStackStatus
+	To   edgeStackStatus
+	Time time.Time
+}
+
+// StatusHookFunc is called when a stack enters or exits a status.
+// Hooks are called while the stack manager lock is held and must not call back into the manager.
+type StatusHookFunc func(stackID int, from, to edgeStackStatus)
+
+// allowedStatusTransitions lists, for each status, the statuses a stack can move to.
+// Any status can move back to StatusPending because a new version or a removal
+// can be requested by the server at any time.
+var allowedStatusTransitions = map[edgeStackStatus][]edgeStackStatus{
+	0:                            {StatusPending},
+	StatusPending:                {StatusDeploying, StatusError, StatusRemoving, StatusIntegrityError, StatusScheduled, StatusStaged, StatusFrozen},
+	StatusDeploying:              {StatusPending, StatusRetry, StatusError, StatusAwaitingDeployedStatus, StatusIntegrityError, StatusScheduled, StatusStaged, StatusFrozen},
+	StatusRetry:                  {StatusPending},
+	StatusAwaitingDeployedStatus: {StatusPending, StatusDeployed, StatusCompleted, StatusError},
+	StatusDeployed:               {StatusPending, StatusCompleted, StatusDegraded},
+	StatusDegraded:               {StatusPending, StatusCompleted, StatusDeployed},
+	StatusScheduled:              {StatusPending},
+	StatusStaged:                 {StatusPending},
+	StatusFrozen:                 {StatusPending},
+	StatusCompleted:              {StatusPending},
+	StatusError:                  {StatusPending},
+	StatusRemoving:               {StatusPending, StatusAwaitingRemovedStatus},
+	StatusAwaitingRemovedStatus:  {StatusPending, StatusError},
+	StatusIntegrityError:         {StatusPending},
+}
+
+// invalidStatusTransition is called with each rejected transition. A rejected transition is a bug of the state
+// machine, the tests of the package replace it to fail on it rather than only logging it.
+var invalidStatusTransition = func(stack *edgeStack, from, to edgeStackStatus) {}
+
+func (s edgeStackStatus) String() string {
+	switch s {
+	case 0:
+		return "None"
+	case StatusPending:
+		return "Pending"
+	case StatusDeployed:
+		return "Deployed"
+	case StatusError:
+		return "Error"
+	case StatusDeploying:
+		return "Deploying"
+	case StatusRetry:
+		return "Retry"
+	case StatusRemoving:
+		return "Removing"
+	case StatusAwaitingDeployedStatus:
+		return "AwaitingDeployedStatus"
+	case StatusAwaitingRemovedStatus:
+		return "AwaitingRemovedStatus"
+	case StatusCompleted:
+		return "Completed"
+	case StatusIntegrityError:
+		return "IntegrityError"
+	case StatusDegraded:
+		return "Degraded"
+	case StatusScheduled:
+		return "Scheduled"
+	case StatusStaged:
+		return "Staged"
+	case StatusFrozen:
+		return "Frozen"
+	}
+
+	return fmt.Sprintf("Unknown(%d)", int(s))
+}
+
+func isStatusTransitionAllowed(from, to edgeStackStatus) bool {
+	for _, s := range allowedStatusTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// OnStatusEnter registers a hook called every time a stack enters the given status
+func (manager *StackManager) OnStatusEnter(status edgeStackStatus, fn StatusHookFunc) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	if manager.statusEnterHooks == nil {
+		manager.statusEnterHooks = make(map[edgeStackStatus][]StatusHookFunc)
+	}
+
+	manager.statusEnterHooks[status] = append(manager.statusEnterHooks[status], fn)
+}
+
+// OnStatusExit registers a hook called every time a stack exits the given status
+func (manager *StackManager) OnStatusExit(status edgeStackStatus, fn StatusHookFunc) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	if manager.statusExitHooks == nil {
+		manager.statusExitHooks = make(map[edgeStackStatus][]StatusHookFunc)
+	}
+
+	manager.statusExitHooks[status] = append(manager.statusExitHooks[status], fn)
+}
+
+// setStatus moves the stack to the given status. Invalid transitions are rejected
+// and logged, leaving the stack in its current status. Setting the current status is a no-op.
+// The caller must hold the manager lock.
+func (manager *StackManager) setStatus(stack *edgeStack, status edgeStackStatus) bool {
+	return manager.setStatusMessage(stack, status, "")
+}
+
+// setStatusMessage moves the stack to the given status like setStatus, the message explaining the transition is sent
+// to the subscribers. The caller must hold the manager lock.
+func (manager *StackManager) setStatusMessage(stack *edgeStack, status edgeStackStatus, message string) bool {
+	from := stack.Status
+	if from == status {
+		return true
+	}
+
+	if !isStatusTransitionAllowed(from, status) {
+		log.Error().
+			Int("stack_identifier", stack.ID).
+			Stringer("from", from).
+			Stringer("to", status).
+			Msg("invalid stack status transition")
+
+		invalidStatusTransition(stack, from, status)
+
+		return false
+	}
+
+	log.Debug().
+		Int("stack_identifier", stack.ID).
+		Stringer("from", from).
+		Stringer("to", status).
+		Msg("stack status transition")
+
+	for _, fn := range manager.statusExitHooks[from] {
+		fn(stack.ID, from, status)
+	}
+
+	stack.Status = status
+	stack.StatusTransitions = append(stack.StatusTransitions, statusTransition{
+		From: from,
+		To:   status,
+		Time: manager.now(),
+	})
+
+	if len(stack.StatusTransitions) > maxStatusTransitions {
+		stack.StatusTransitions = stack.StatusTransitions[len(stack.StatusTransitions)-maxStatusTransitions:]
+	}
+
+	for _, fn := range manager.statusEnterHooks[status] {
+		fn(stack.ID, from, status)
+	}
+
+	manager.recordVersion(stack, status, message)
+	manager.resolveRemediation(stack, status)
+
+	manager.updateBatchMember(stack, false)
+
+	// The cloned stacks are published once they replace the stored ones
+	if manager.stacks[edgeStackID(stack.ID)] == stack {
+		manager.publishStack(stack, message)
+	}
+
+	return true
+}
+
+func (manager *StackManager) now() time.Time {
+	if manager.clock == nil {
+		return time.Now()
+	}
+
+	return manager.clock.Now()
+}
+
+// StatusTransitionGraph returns the stack status lifecycle in the Graphviz DOT format
+func StatusTransitionGraph() string {
+	var froms []edgeStackStatus
+	for from := range allowedStatusTransitions {
+		froms = append(froms, from)
+	}
+
+	sort.Slice(froms, func(i, j int) bool { return froms[i] < froms[j] })
+
+	var sb strings.Builder
+	sb.WriteString("digraph edge_stack_status {\n")
+
+	for _, from := range froms {
+		for _, to := range allowedStatusTransitions[from] {
+			fmt.Fprintf(&sb, "\t%q -> %q;\n", from.String(), to.String())
+		}
+	}
+
+	sb.WriteString("}\n")
+
+	return sb.String()
+}
@@ -0,0 +1,132 @@
+package stack
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/portainer/agent/clock"
+	"github.com/portainer/portainer/api/edge"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStackManager_setStatus(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("Valid transition is applied and logged", func(t *testing.T) {
+		manager := &StackManager{clock: clock.NewFakeClock(now)}
+		stack := &edgeStack{StackPayload: edge.StackPayload{ID: 1}, Status: StatusPending}
+
+		assert.True(t, manager.setStatus(stack, StatusDeploying))
+		assert.Equal(t, StatusDeploying, stack.Status)
+		assert.Equal(t, []statusTransition{{From: StatusPending, To: StatusDeploying, Time: now}}, stack.StatusTransitions)
+	})
+
+	t.Run("Invalid transition is rejected", func(t *testing.T) {
+		var rejected []edgeStackStatus
+
+		strict := invalidStatusTransition
+		invalidStatusTransition = func(stack *edgeStack, from, to edgeStackStatus) { rejected = append(rejected, from, to) }
+		defer func() { invalidStatusTransition = strict }()
+
+		manager := &StackManager{clock: clock.NewFakeClock(now)}
+		stack := &edgeStack{StackPayload: edge.StackPayload{ID: 1}, Status: StatusRetry}
+
+		assert.False(t, manager.setStatus(stack, StatusDeployed))
+		assert.Equal(t, StatusRetry, stack.Status)
+		assert.Empty(t, stack.StatusTransitions)
+		assert.Equal(t, []edgeStackStatus{StatusRetry, StatusDeployed}, rejected)
+	})
+
+	t.Run("Entry and exit hooks are called", func(t *testing.T) {
+		manager := &StackManager{clock: clock.NewFakeClock(now)}
+		stack := &edgeStack{StackPayload: edge.StackPayload{ID: 1}, Status: StatusDeploying}
+
+		var calls []string
+		manager.OnStatusExit(StatusDeploying, func(stackID int, from, to edgeStackStatus) {
+			calls = append(calls, "exit "+from.String())
+		})
+		manager.OnStatusEnter(StatusError, func(stackID int, from, to edgeStackStatus) {
+			calls = append(calls, "enter "+to.String())
+		})
+
+		manager.setStatus(stack, StatusError)
+		assert.Equal(t, []string{"exit Deploying", "enter Error"}, calls)
+	})
+}
+
+func TestStatusTransitionGraph(t *testing.T) {
+	graph := StatusTransitionGraph()
+
+	assert.Contains(t, graph, `"Pending" -> "Deploying";`)
+	assert.Contains(t, graph, `"Retry" -> "Pending";`)
+}
+
+// TestStackManager_setStatus_callSites checks the transition of each call of setStatus from every status the stack
+// can be in at that point
+func TestStackManager_setStatus_callSites(t *testing.T) {
+	var anyStatus []edgeStackStatus
+	for from := range allowedStatusTransitions {
+		if from != 0 {
+			anyStatus = append(anyStatus, from)
+		}
+	}
+
+	waiting := []edgeStackStatus{StatusPending, StatusDeploying}
+
+	callSites := []struct {
+		site  string
+		froms []edgeStackStatus
+		to    edgeStackStatus
+	}{
+		{"processStack new stack", []edgeStackStatus{0}, StatusPending},
+		{"processStack new version", anyStatus, StatusPending},
+		{"buildDeployerParams", append([]edgeStackStatus{0}, anyStatus...), StatusPending},
+		{"markStackForRemoval", anyStatus, StatusPending},
+		{"rollbackStack", anyStatus, StatusPending},
+		{"removeBatchStack removal", anyStatus, StatusPending},
+		{"removeBatchStack never deployed", []edgeStackStatus{StatusPending, StatusDeploying, StatusAwaitingDeployedStatus, StatusAwaitingRemovedStatus, StatusError}, StatusError},
+		{"requestRestart", []edgeStackStatus{StatusDeployed, StatusDegraded, StatusAwaitingDeployedStatus}, StatusPending},
+		{"nextPendingStack retry", []edgeStackStatus{StatusRetry}, StatusPending},
+		{"nextActivatedStack", []edgeStackStatus{StatusScheduled}, StatusPending},
+		{"releaseBarrier", []edgeStackStatus{StatusStaged}, StatusPending},
+		{"nextThawedStack", []edgeStackStatus{StatusFrozen}, StatusPending},
+		{"markNameConflict", []edgeStackStatus{StatusPending}, StatusError},
+		{"markUnschedulable", []edgeStackStatus{StatusPending}, StatusError},
+		{"markIntegrityError", waiting, StatusIntegrityError},
+		{"validateStackFile", []edgeStackStatus{StatusPending}, StatusError},
+		{"pullImages", []edgeStackStatus{StatusPending}, StatusDeploying},
+		{"pullImages retry", []edgeStackStatus{StatusDeploying}, StatusRetry},
+		{"pullImages error", []edgeStackStatus{StatusDeploying}, StatusError},
+		{"waitAtBarrier", waiting, StatusStaged},
+		{"deferFrozen", waiting, StatusFrozen},
+		{"deferDeploy", waiting, StatusScheduled},
+		{"copyRelativePathStackToHost error", waiting, StatusError},
+		{"deployStack", waiting, StatusDeploying},
+		{"deployStack retry", []edgeStackStatus{StatusDeploying}, StatusRetry},
+		{"deployStack error", []edgeStackStatus{StatusDeploying}, StatusError},
+		{"finishDeploy", []edgeStackStatus{StatusDeploying}, StatusAwaitingDeployedStatus},
+		{"restartStack", []edgeStackStatus{StatusPending}, StatusDeploying},
+		{"restartStack error", []edgeStackStatus{StatusDeploying}, StatusError},
+		{"restartStack restarted", []edgeStackStatus{StatusDeploying}, StatusAwaitingDeployedStatus},
+		{"deleteStack", []edgeStackStatus{StatusPending}, StatusRemoving},
+		{"deleteStack removed", []edgeStackStatus{StatusRemoving}, StatusAwaitingRemovedStatus},
+		{"checkStackStatus error", []edgeStackStatus{StatusAwaitingDeployedStatus, StatusAwaitingRemovedStatus}, StatusError},
+		{"checkStackStatus running", []edgeStackStatus{StatusAwaitingDeployedStatus}, StatusDeployed},
+		{"checkStackStatus completed", []edgeStackStatus{StatusAwaitingDeployedStatus, StatusDeployed, StatusDegraded}, StatusCompleted},
+		{"checkCrashLoop degraded", []edgeStackStatus{StatusDeployed}, StatusDegraded},
+		{"checkCrashLoop recovered", []edgeStackStatus{StatusDegraded}, StatusDeployed},
+	}
+
+	for _, callSite := range callSites {
+		for _, from := range callSite.froms {
+			t.Run(fmt.Sprintf("%s from %s", callSite.site, from), func(t *testing.T) {
+				manager := &StackManager{clock: clock.NewFakeClock(time.Unix(1000, 0))}
+				stack := &edgeStack{StackPayload: edge.StackPayload{ID: 1}, Status: from}
+
+				assert.True(t, manager.setStatus(stack, callSite.to))
+				assert.Equal(t, callSite.to, stack.Status)
+			})
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package stack
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/portainer/agent"
+	"github.com/portainer/agent/edge/client"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultStorageCheckInterval is the interval between two checks of the disk used by the stacks and the job logs
+const DefaultStorageCheckInterval = 5 * time.Minute
+
+// storageQuota bounds the disk used by the files of the stacks and the job logs, it is read by the status consumers
+// without the manager lock
+type storageQuota struct {
+	// stacksPath is the folder of the files of the stacks, logsPath the folder of the job logs
+	stacksPath string
+	logsPath   string
+	maxSize    int64
+	maxLogSize int64
+	usage      client.StorageUsage
+	mu         sync.Mutex
+}
+
+// historyCandidate is a retained version that can be evicted
+type historyCandidate struct {
+	stackID    int
+	folder     string
+	deployedAt int64
+}
+
+// SetStorageQuota bounds the disk used by the files of the stacks, their last successfully deployed files, their
+// retained versions and the job logs of the logs folder. The retained versions are evicted the least recently
+// deployed first when the quota is exceeded, the job logs bigger than maxLogSize are truncated to their end.
+// A maxSize of 0 disables the quota, a maxLogSize of 0 leaves the job logs untouched.
+func (manager *StackManager) SetStorageQuota(maxSize int64, logsPath string, maxLogSize int64) {
+	manager.storage.mu.Lock()
+	defer manager.storage.mu.Unlock()
+
+	manager.storage.stacksPath = agent.EdgeStackFilesPath
+	manager.storage.logsPath = logsPath
+	manager.storage.maxSize = maxSize
+	manager.storage.maxLogSize = maxLogSize
+	manager.storage.usage.Quota = maxSize
+}
+
+// StorageUsage returns the disk usage measured by the last check of the storage quota, nil when the storage is not
+// bounded. It does not take the lock of the stack manager.
+func (manager *StackManager) StorageUsage() *client.StorageUsage {
+	manager.storage.mu.Lock()
+	defer manager.storage.mu.Unlock()
+
+	if manager.storage.maxSize <= 0 && manager.storage.maxLogSize <= 0 {
+		return nil
+	}
+
+	usage := manager.storage.usage
+
+	return &usage
+}
+
+func (manager *StackManager) startStorageQuota(stopSignal chan struct{}) {
+	if manager.StorageUsage() == nil {
+		return
+	}
+
+	go func() {
+		for {
+			manager.enforceStorageQuota()
+
+			select {
+			case <-stopSignal:
+				return
+			case <-manager.clock.After(DefaultStorageCheckInterval):
+			}
+		}
+	}()
+}
+
+// enforceStorageQuota truncates the oversized job logs, then evicts the retained versions until the files fit in the
+// quota. The most recent version of each stack and the version a stack is rolled back to are never evicted, neither
+// are the files of the stacks nor their last successfully deployed files.
+func (manager *StackManager) enforceStorageQuota() {
+	// The retained versions are written and read under the manager lock
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	manager.storage.mu.Lock()
+	defer manager.storage.mu.Unlock()
+
+	quota := &manager.storage
+	usage := &quota.usage
+
+	logs, err := measureLogs(quota.logsPath)
+	if err != nil {
+		log.Warn().Err(err).Msg("unable to measure the job logs")
+	}
+
+	usage.Logs = 0
+	for path, size := range logs {
+		if quota.maxLogSize > 0 && size > quota.maxLogSize {
+			if err := truncateLog(path, quota.maxLogSize); err != nil {
+				log.Warn().Err(err).Str("path", path).Msg("unable to truncate the job log")
+			} else {
+				log.Info().Str("path", path).Int64("size", size).Msg("job log truncated")
+
+				usage.TruncatedLogs++
+				usage.EvictedBytes += size - quota.maxLogSize
+				size = quota.maxLogSize
+			}
+		}
+
+		usage.Logs += size
+	}
+
+	if err := manager.measureStacks(usage); err != nil {
+		log.Warn().Err(err).Msg("unable to measure the files of the Edge stacks")
+	}
+
+	usage.CheckedAt = manager.now().Unix()
+
+	if quota.maxSize <= 0 || usage.Stacks+usage.Success+usage.History+usage.Logs <= quota.maxSize {
+		return
+	}
+
+	for _, candidate := range manager.historyCandidates() {
+		size, err := folderSize(candidate.folder)
+		if err != nil {
+			continue
+		}
+
+		if err := os.RemoveAll(candidate.folder); err != nil {
+			log.Warn().Err(err).Str("folder", candidate.folder).Msg("unable to evict the retained version")
+
+			continue
+		}
+
+		log.Info().
+			Int("stack_identifier", candidate.stackID).
+			Str("version", filepath.Base(candidate.folder)).
+			Int64("size", size).
+			Msg("retained version evicted to fit in the storage quota")
+
+		usage.History -= size
+		usage.EvictedVersions++
+		usage.EvictedBytes += size
+
+		if usage.Stacks+usage.Success+usage.History+usage.Logs <= quota.maxSize {
+			return
+		}
+	}
+
+	log.Warn().
+		Int64("quota", quota.maxSize).
+		Int64("size", usage.Stacks+usage.Success+usage.History+usage.Logs).
+		Msg("the files of the Edge stacks and the job logs exceed the storage quota, nothing else can be evicted")
+}
+
+// measureStacks measures the files of the stacks, their last successfully deployed files and their retained versions.
+// The caller must hold the manager lock.
+func (manager *StackManager) measureStacks(usage *client.StorageUsage) error {
+	usage.Stacks, usage.Success, usage.History = 0, 0, 0
+
+	entries, err := os.ReadDir(manager.storage.stacksPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		size, err := folderSize(filepath.Join(manager.storage.stacksPath, entry.Name()))
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case strings.HasSuffix(entry.Name(), successFolderSuffix):
+			usage.Success += size
+		case strings.HasSuffix(entry.Name(), historyFolderSuffix):
+			usage.History += size
+		default:
+			usage.Stacks += size
+		}
+	}
+
+	return nil
+}
+
+// historyCandidates returns the retained versions that can be evicted, the least recently deployed first.
+// The caller must hold the manager lock.
+func (manager *StackManager) historyCandidates() []historyCandidate {
+	rolledBack := make(map[int]int)
+	for stackID, stack := range manager.stacks {
+		rolledBack[int(stackID)] = stack.RolledBackVersion
+	}
+
+	entries, err := os.ReadDir(manager.storage.stacksPath)
+	if err != nil {
+		return nil
+	}
+
+	var candidates []historyCandidate
+
+	for _, entry := range entries {
+		folder, ok := strings.CutSuffix(entry.Name(), historyFolderSuffix)
+		if !ok {
+			continue
+		}
+
+		stackID, err := strconv.Atoi(folder)
+		if err != nil {
+			continue
+		}
+
+		historyFolder := filepath.Join(manager.storage.stacksPath, entry.Name())
+
+		history, err := listStackHistory(historyFolder)
+		if err != nil {
+			continue
+		}
+
+		for i, metadata := range history {
+			if i == 0 || metadata.Version == rolledBack[stackID] {
+				continue
+			}
+
+			candidates = append(candidates, historyCandidate{
+				stackID:    stackID,
+				folder:     filepath.Join(historyFolder, strconv.Itoa(metadata.Version)),
+				deployedAt: metadata.DeployedAt,
+			})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].deployedAt < candidates[j].deployedAt })
+
+	return candidates
+}
+
+// measureLogs returns the size of each job log of the folder
+func measureLogs(logsPath string) (map[string]int64, error) {
+	if logsPath == "" {
+		return nil, nil
+	}
+
+	entries, err := os.ReadDir(logsPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	logs := make(map[string]int64)
+
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !strings.HasPrefix(entry.Name(), "schedule_") || filepath.Ext(entry.Name()) != ".log" {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		logs[filepath.Join(logsPath, entry.Name())] = info.Size()
+	}
+
+	return logs, nil
+}
+
+// truncateLog keeps the last maxSize bytes of the log
+func truncateLog(path string, maxSize int64) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(-maxSize, io.SeekEnd); err != nil {
+		return err
+	}
+
+	end, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.WriteAt(end, 0); err != nil {
+		return err
+	}
+
+	return f.Truncate(int64(len(end)))
+}
+
+// folderSize returns the size of the regular files of the folder, the symlinks are not followed
+func folderSize(folder string) (int64, error) {
+	var size int64
+
+	err := filepath.WalkDir(folder, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		size += info.Size()
+
+		return nil
+	})
+
+	return size, err
+}
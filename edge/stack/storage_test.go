@@ -0,0 +1,90 @@
+package stack
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/portainer/agent/clock"
+	"github.com/portainer/portainer/api/edge"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeRetainedVersion(t *testing.T, stacksPath string, stackID, version int, deployedAt int64, size int) {
+	t.Helper()
+
+	versionFolder := filepath.Join(HistoryStackFileFolder(filepath.Join(stacksPath, strconv.Itoa(stackID))), strconv.Itoa(version))
+	require.NoError(t, os.MkdirAll(versionFolder, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(versionFolder, "docker-compose.yml"), make([]byte, size), 0644))
+
+	metadata, err := json.Marshal(StackVersionMetadata{Version: version, DeployedAt: deployedAt})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(versionFolder, historyMetadataFileName), metadata, 0644))
+}
+
+func TestStackManager_enforceStorageQuota(t *testing.T) {
+	stacksPath, logsPath := t.TempDir(), t.TempDir()
+
+	require.NoError(t, os.MkdirAll(SuccessStackFileFolder(filepath.Join(stacksPath, "1")), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(SuccessStackFileFolder(filepath.Join(stacksPath, "1")), "docker-compose.yml"), make([]byte, 1000), 0644))
+
+	// Stack 1 is rolled back to its version 1, which is kept
+	writeRetainedVersion(t, stacksPath, 1, 1, 10, 1000)
+	writeRetainedVersion(t, stacksPath, 1, 2, 30, 1000)
+	writeRetainedVersion(t, stacksPath, 1, 3, 50, 1000)
+	writeRetainedVersion(t, stacksPath, 2, 1, 20, 1000)
+	writeRetainedVersion(t, stacksPath, 2, 2, 40, 1000)
+
+	require.NoError(t, os.WriteFile(filepath.Join(logsPath, "schedule_1.log"), []byte(strings.Repeat("a", 900)+strings.Repeat("b", 100)), 0644))
+
+	manager := &StackManager{
+		clock: clock.NewFakeClock(time.Unix(100, 0)),
+		stacks: map[edgeStackID]*edgeStack{
+			1: {StackPayload: edge.StackPayload{ID: 1, Version: 3}, RolledBackVersion: 1},
+		},
+	}
+
+	manager.SetStorageQuota(5000, logsPath, 100)
+	manager.storage.stacksPath = stacksPath
+
+	manager.enforceStorageQuota()
+
+	// The oversized log keeps its end
+	content, err := os.ReadFile(filepath.Join(logsPath, "schedule_1.log"))
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("b", 100), string(content))
+
+	// The least recently deployed version of stack 2 then version 2 of stack 1 are evicted, the most recent versions
+	// and the rolled back version are kept
+	history, err := ListStackHistory(filepath.Join(stacksPath, "2"))
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Equal(t, 2, history[0].Version)
+
+	history, err = ListStackHistory(filepath.Join(stacksPath, "1"))
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, 3, history[0].Version)
+	assert.Equal(t, 1, history[1].Version)
+
+	usage := manager.StorageUsage()
+	require.NotNil(t, usage)
+	assert.Equal(t, 2, usage.EvictedVersions)
+	assert.Equal(t, 1, usage.TruncatedLogs)
+	assert.Equal(t, int64(100), usage.Logs)
+	assert.Equal(t, int64(1000), usage.Success)
+	assert.Equal(t, int64(5000), usage.Quota)
+	assert.LessOrEqual(t, usage.Stacks+usage.Success+usage.History+usage.Logs, usage.Quota)
+	assert.Equal(t, int64(100), usage.CheckedAt)
+}
+
+func TestStackManager_StorageUsage_disabled(t *testing.T) {
+	manager := &StackManager{}
+
+	assert.Nil(t, manager.StorageUsage())
+}
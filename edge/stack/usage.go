@@ -0,0 +1,77 @@
+package stack
+
+import (
+	"time"
+
+	"github.com/portainer/portainer/api/filesystem"
+
+	"github.com/rs/zerolog/log"
+)
+
+// usageReportInterval is the minimum interval between two usage reports of a stack being retried
+const usageReportInterval = time.Minute
+
+// measure runs fn and returns the time it took and the size of the image layers it added to the host,
+// 0 when the layers size cannot be retrieved
+func (manager *StackManager) measure(fn func() error) (time.Duration, int64, error) {
+	before, known := manager.layersSize()
+	start := manager.now()
+
+	err := fn()
+
+	elapsed := manager.now().Sub(start)
+
+	after, _ := manager.layersSize()
+	if !known || after < before {
+		return elapsed, 0, err
+	}
+
+	return elapsed, after - before, err
+}
+
+func (manager *StackManager) layersSize() (int64, bool) {
+	if manager.imageLayersSize == nil {
+		return 0, false
+	}
+
+	size, err := manager.imageLayersSize()
+	if err != nil {
+		log.Debug().Err(err).Msg("unable to retrieve the size of the image layers")
+
+		return 0, false
+	}
+
+	return size, true
+}
+
+// accountSync adds the stack files received since start to the usage of the stack
+func (manager *StackManager) accountSync(stack *edgeStack, dirEntries []filesystem.DirEntry, start time.Time) {
+	for _, entry := range dirEntries {
+		if entry.IsFile {
+			stack.Usage.FileBytes += int64(len(entry.Content))
+		}
+	}
+
+	stack.Usage.SyncTime += manager.now().Sub(start)
+}
+
+// reportUsage reports the usage of the stack when it changed since the last report. The reports of
+// the stacks being retried are throttled. The caller must hold the manager lock.
+func (manager *StackManager) reportUsage(stack *edgeStack) {
+	if stack.Usage == stack.ReportedUsage {
+		return
+	}
+
+	if stack.Status == StatusRetry && manager.now().Before(stack.UsageReportedAt.Add(usageReportInterval)) {
+		return
+	}
+
+	if err := manager.portainerClient.SetEdgeStackUsage(stack.ID, stack.Usage); err != nil {
+		log.Error().Err(err).Int("stack_identifier", stack.ID).Msg("unable to report the Edge stack usage")
+
+		return
+	}
+
+	stack.ReportedUsage = stack.Usage
+	stack.UsageReportedAt = manager.now()
+}
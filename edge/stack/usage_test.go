@@ -0,0 +1,91 @@
+package stack
+
+import (
+	"testing"
+	"time"
+
+	"github.com/portainer/agent/clock"
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/agent/internals/mocks"
+	"github.com/portainer/portainer/api/filesystem"
+	"github.com/stretchr/testify/assert"
+	gomock "go.uber.org/mock/gomock"
+)
+
+func TestStackManager_measure(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Unix(1000, 0))
+	layers := int64(1000)
+
+	manager := &StackManager{
+		clock: fakeClock,
+		imageLayersSize: func() (int64, error) {
+			return layers, nil
+		},
+	}
+
+	elapsed, imageBytes, err := manager.measure(func() error {
+		fakeClock.Advance(3 * time.Second)
+		layers += 250
+
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3*time.Second, elapsed)
+	assert.Equal(t, int64(250), imageBytes)
+
+	// Layers removed meanwhile are not counted
+	_, imageBytes, _ = manager.measure(func() error {
+		layers -= 500
+
+		return nil
+	})
+
+	assert.Zero(t, imageBytes)
+}
+
+func TestStackManager_accountSync(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Unix(1000, 0))
+	manager := &StackManager{clock: fakeClock}
+
+	stack := &edgeStack{}
+	start := manager.now()
+	fakeClock.Advance(2 * time.Second)
+
+	manager.accountSync(stack, []filesystem.DirEntry{
+		{Name: "config", IsFile: false},
+		{Name: "docker-compose.yml", Content: "services: {}", IsFile: true},
+		{Name: "config/app.env", Content: "A=1", IsFile: true},
+	}, start)
+
+	assert.Equal(t, client.StackUsage{FileBytes: 15, SyncTime: 2 * time.Second}, stack.Usage)
+}
+
+func TestStackManager_reportUsage(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClient := mocks.NewMockPortainerClient(ctrl)
+	fakeClock := clock.NewFakeClock(time.Unix(1000, 0))
+
+	manager := &StackManager{portainerClient: mockClient, clock: fakeClock}
+
+	stack := &edgeStack{Status: StatusRetry}
+	stack.ID = 1
+
+	// Unchanged usage is not reported
+	manager.reportUsage(stack)
+
+	stack.Usage.Pulls = 1
+	mockClient.EXPECT().SetEdgeStackUsage(1, client.StackUsage{Pulls: 1})
+	manager.reportUsage(stack)
+
+	// The retries are reported at most once per interval
+	stack.Usage.Pulls = 2
+	manager.reportUsage(stack)
+
+	fakeClock.Advance(usageReportInterval)
+	mockClient.EXPECT().SetEdgeStackUsage(1, client.StackUsage{Pulls: 2})
+	manager.reportUsage(stack)
+	assert.Equal(t, stack.Usage, stack.ReportedUsage)
+}
@@ -3,6 +3,7 @@ package stack
 import (
 	"fmt"
 
+	agentfs "github.com/portainer/agent/filesystem"
 	"github.com/portainer/portainer/api/filesystem"
 )
 
@@ -19,7 +20,11 @@ func SuccessStackFileFolder(fileFolder string) string {
 }
 
 func backupSuccessStack(stack *edgeStack) error {
-	src := stack.FileFolder
-	dst := SuccessStackFileFolder(src)
-	return filesystem.CopyDir(src, dst, false)
+	src := resolveStackFileFolder(stack.FileFolder)
+	dst := SuccessStackFileFolder(stack.FileFolder)
+	if err := filesystem.CopyDir(src, dst, false); err != nil {
+		return err
+	}
+
+	return agentfs.SyncTree(dst)
 }
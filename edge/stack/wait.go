@@ -0,0 +1,63 @@
+package stack
+
+import (
+	"time"
+)
+
+// DefaultStatusTimeout is how long a stack can take to reach its deployed or removed status
+// when neither the stack nor the server define it
+const DefaultStatusTimeout = 1 * time.Minute
+
+// minStatusCheckWindow is the shortest status check, long enough for the deployers to observe the stack once
+const minStatusCheckWindow = 5 * time.Second
+
+// SetStatusWaitDefaults sets the status timeout and check interval of the stacks that do not define them.
+// A zero value keeps the current default.
+func (manager *StackManager) SetStatusWaitDefaults(timeout, interval time.Duration) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	if timeout > 0 {
+		manager.statusTimeout = timeout
+	}
+
+	if interval > 0 {
+		manager.statusCheckInterval = interval
+	}
+}
+
+// statusWait returns how long the stack can take to reach its status and how long each status check lasts.
+// By default the stack is checked once for the whole timeout.
+func (manager *StackManager) statusWait(stack *edgeStack) (time.Duration, time.Duration) {
+	timeout := firstPositiveDuration(stack.StatusTimeout, manager.statusTimeout, DefaultStatusTimeout)
+	interval := firstPositiveDuration(stack.StatusCheckInterval, manager.statusCheckInterval, timeout)
+
+	return timeout, min(interval, timeout)
+}
+
+// statusDeadline returns when the stack must have reached its status, counted from when it started waiting for it
+func (manager *StackManager) statusDeadline(stack *edgeStack, timeout time.Duration) time.Time {
+	if n := len(stack.StatusTransitions); n > 0 && stack.StatusTransitions[n-1].To == stack.Status {
+		return stack.StatusTransitions[n-1].Time.Add(timeout)
+	}
+
+	return manager.now().Add(timeout)
+}
+
+// statusCheckWindow returns how long the next status check lasts, it never goes past the deadline
+// unless it is already too close to observe the stack
+func (manager *StackManager) statusCheckWindow(deadline time.Time, interval time.Duration) time.Duration {
+	window := min(interval, deadline.Sub(manager.now()))
+
+	return max(window, min(interval, minStatusCheckWindow))
+}
+
+func firstPositiveDuration(durations ...time.Duration) time.Duration {
+	for _, d := range durations {
+		if d > 0 {
+			return d
+		}
+	}
+
+	return 0
+}
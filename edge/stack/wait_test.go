@@ -0,0 +1,107 @@
+package stack
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/portainer/agent/clock"
+	"github.com/portainer/agent/internals/mocks"
+	"github.com/portainer/portainer/pkg/libstack"
+	"github.com/stretchr/testify/assert"
+	gomock "go.uber.org/mock/gomock"
+)
+
+func TestStackManager_statusWait(t *testing.T) {
+	manager := &StackManager{}
+
+	timeout, interval := manager.statusWait(&edgeStack{})
+	assert.Equal(t, DefaultStatusTimeout, timeout)
+	assert.Equal(t, DefaultStatusTimeout, interval)
+
+	manager.SetStatusWaitDefaults(10*time.Minute, 0)
+	timeout, interval = manager.statusWait(&edgeStack{})
+	assert.Equal(t, 10*time.Minute, timeout)
+	assert.Equal(t, 10*time.Minute, interval)
+
+	manager.SetStatusWaitDefaults(0, 30*time.Second)
+	timeout, interval = manager.statusWait(&edgeStack{})
+	assert.Equal(t, 10*time.Minute, timeout)
+	assert.Equal(t, 30*time.Second, interval)
+
+	// The stack settings take precedence, the interval never exceeds the timeout
+	timeout, interval = manager.statusWait(&edgeStack{StatusTimeout: 20 * time.Second})
+	assert.Equal(t, 20*time.Second, timeout)
+	assert.Equal(t, 20*time.Second, interval)
+}
+
+func TestStackManager_statusCheckWindow(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Unix(1000, 0))
+	manager := &StackManager{clock: fakeClock}
+
+	stack := &edgeStack{Status: StatusPending}
+	manager.setStatus(stack, StatusDeploying)
+	manager.setStatus(stack, StatusAwaitingDeployedStatus)
+
+	deadline := manager.statusDeadline(stack, 2*time.Minute)
+	assert.Equal(t, time.Unix(1120, 0), deadline)
+
+	fakeClock.Advance(90 * time.Second)
+	assert.Equal(t, 30*time.Second, manager.statusCheckWindow(deadline, time.Minute))
+	assert.Equal(t, 10*time.Second, manager.statusCheckWindow(deadline, 10*time.Second))
+
+	// A last check is done once the deadline is reached
+	fakeClock.Advance(time.Minute)
+	assert.Equal(t, minStatusCheckWindow, manager.statusCheckWindow(deadline, time.Minute))
+}
+
+func TestStackManager_checkStackStatus_window(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	fakeClock := clock.NewFakeClock(time.Unix(1000, 0))
+	mockDeployer := mocks.NewMockDeployer(ctrl)
+
+	manager := &StackManager{
+		deployer: mockDeployer,
+		clock:    fakeClock,
+		stacks:   map[edgeStackID]*edgeStack{},
+	}
+
+	stack := &edgeStack{Status: StatusPending, StatusTimeout: time.Hour, StatusCheckInterval: time.Minute}
+	manager.setStatus(stack, StatusDeploying)
+	manager.setStatus(stack, StatusAwaitingDeployedStatus)
+
+	mockDeployer.EXPECT().WaitForStatus(gomock.Any(), "edge_web", libstack.StatusRunning).DoAndReturn(
+		func(ctx context.Context, name string, status libstack.Status) <-chan libstack.WaitResult {
+			ch := make(chan libstack.WaitResult, 1)
+
+			go func() {
+				<-ctx.Done()
+				ch <- libstack.WaitResult{Status: status, ErrorMsg: "failed to wait for status"}
+			}()
+
+			return ch
+		})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		assert.NoError(t, manager.checkStackStatus(context.Background(), "edge_web", stack))
+	}()
+
+	for {
+		select {
+		case <-done:
+			// The timeout is not reached, the stack is checked again later
+			assert.Equal(t, StatusAwaitingDeployedStatus, stack.Status)
+
+			return
+		default:
+		}
+
+		fakeClock.Advance(time.Second)
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,157 @@
+package stack
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/portainer/agent"
+	"github.com/portainer/agent/docker"
+	"github.com/portainer/agent/edge/yaml"
+	"github.com/portainer/agent/kubernetes"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ErrMissingWasmRuntime is returned when a stack runs WebAssembly workloads with a runtime the device does not provide
+var ErrMissingWasmRuntime = errors.New("missing WebAssembly runtime")
+
+// shimSearchPaths are the folders the containerd shims are looked up in, on the host mounted in the agent container
+// when there is one. The shims installed by KWasm and bundled with k3s are included.
+var shimSearchPaths = []string{
+	"/usr/local/bin",
+	"/usr/bin",
+	"/bin",
+	"/opt/kwasm/bin",
+	"/var/lib/rancher/k3s/data/current/bin",
+}
+
+// shimBinary returns the binary of the containerd shim of a runtime, e.g. containerd-shim-spin-v2 for
+// io.containerd.spin.v2, an empty string when the runtime is not a containerd shim
+func shimBinary(runtime string) string {
+	parts := strings.Split(runtime, ".")
+	if len(parts) != 4 || parts[0] != "io" || parts[1] != "containerd" {
+		return ""
+	}
+
+	return "containerd-shim-" + parts[2] + "-" + parts[3]
+}
+
+// shimInstalled returns whether the binary of a containerd shim is installed
+func shimInstalled(binary string) bool {
+	for _, dir := range shimSearchPaths {
+		for _, path := range []string{filepath.Join(agent.HostRoot, dir, binary), filepath.Join(dir, binary)} {
+			if info, err := os.Stat(path); err == nil && !info.IsDir() {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// missingShim returns why the containerd shim of a runtime is not installed, an empty string when it is
+func missingShim(runtime string) string {
+	binary := shimBinary(runtime)
+	if binary == "" {
+		return fmt.Sprintf("%s is not a containerd shim", runtime)
+	}
+
+	if !shimInstalled(binary) {
+		return fmt.Sprintf("the %s shim is not installed", binary)
+	}
+
+	return ""
+}
+
+// dockerWasmRuntime checks the runtimes registered in the Docker engine, then the containerd shims the engine
+// can use directly, e.g. --runtime io.containerd.spin.v2
+func dockerWasmRuntime(runtime string) (string, error) {
+	if shimBinary(runtime) != "" {
+		return missingShim(runtime), nil
+	}
+
+	info, err := docker.GetInfo()
+	if err != nil {
+		return "", err
+	}
+
+	if _, ok := info.Runtimes[runtime]; !ok {
+		return fmt.Sprintf("the %s runtime is not registered in the Docker engine", runtime), nil
+	}
+
+	return "", nil
+}
+
+// containerdWasmRuntime checks the containerd shims, nerdctl only runs them by their full name
+func containerdWasmRuntime(runtime string) (string, error) {
+	return missingShim(runtime), nil
+}
+
+// kubernetesWasmRuntime checks the RuntimeClasses of the cluster, which map the runtime class names to the shims
+// configured in containerd on the nodes
+func kubernetesWasmRuntime(runtimeClass string) (string, error) {
+	runtimeClasses, err := kubernetes.RuntimeClasses()
+	if err != nil {
+		return "", err
+	}
+
+	if !slices.Contains(runtimeClasses, runtimeClass) {
+		return fmt.Sprintf("the %s RuntimeClass does not exist", runtimeClass), nil
+	}
+
+	return "", nil
+}
+
+// checkWasmRuntimes returns an ErrMissingWasmRuntime error when the entry file of the stack runs WebAssembly workloads
+// with runtimes the device does not provide. Files that cannot be parsed are left to the validation of the deployer,
+// the runtimes are deployed when they cannot be checked. The caller must hold the manager lock.
+func (manager *StackManager) checkWasmRuntimes(stackFileLocation string) error {
+	if manager.wasmRuntime == nil {
+		return nil
+	}
+
+	content, err := os.ReadFile(stackFileLocation)
+	if err != nil {
+		return nil
+	}
+
+	workloads, err := yaml.ComposeWasmWorkloads(string(content))
+	if manager.engineType == EngineTypeKubernetes {
+		workloads, err = yaml.KubernetesWasmWorkloads(string(content))
+	}
+
+	if err != nil {
+		return nil
+	}
+
+	var reasons []string
+
+	for _, workload := range workloads {
+		if workload.Runtime == "" {
+			reasons = append(reasons, fmt.Sprintf("the service %s declares a wasi platform without a runtime", workload.Name))
+
+			continue
+		}
+
+		reason, err := manager.wasmRuntime(workload.Runtime)
+		if err != nil {
+			log.Warn().Err(err).Str("runtime", workload.Runtime).Msg("unable to check the WebAssembly runtime")
+
+			continue
+		}
+
+		if reason != "" {
+			reasons = append(reasons, fmt.Sprintf("%s: %s", workload.Name, reason))
+		}
+	}
+
+	if len(reasons) > 0 {
+		return fmt.Errorf("%w: %s", ErrMissingWasmRuntime, strings.Join(reasons, "; "))
+	}
+
+	return nil
+}
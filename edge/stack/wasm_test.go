@@ -0,0 +1,84 @@
+package stack
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShimBinary(t *testing.T) {
+	assert.Equal(t, "containerd-shim-spin-v2", shimBinary("io.containerd.spin.v2"))
+	assert.Equal(t, "containerd-shim-wasmedge-v1", shimBinary("io.containerd.wasmedge.v1"))
+	assert.Empty(t, shimBinary("wasmtime-spin-v2"))
+	assert.Empty(t, shimBinary("io.containerd.spin"))
+}
+
+func TestMissingShim(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "containerd-shim-spin-v2"), nil, 0755))
+
+	defaultPaths := shimSearchPaths
+	shimSearchPaths = []string{dir}
+	t.Cleanup(func() { shimSearchPaths = defaultPaths })
+
+	assert.Empty(t, missingShim("io.containerd.spin.v2"))
+	assert.Equal(t, "the containerd-shim-wasmedge-v1 shim is not installed", missingShim("io.containerd.wasmedge.v1"))
+	assert.Equal(t, "crun-wasm is not a containerd shim", missingShim("crun-wasm"))
+}
+
+func TestStackManager_checkWasmRuntimes(t *testing.T) {
+	stackFile := filepath.Join(t.TempDir(), "docker-compose.yml")
+	require.NoError(t, os.WriteFile(stackFile, []byte(`
+services:
+  api:
+    image: ghcr.io/org/api:1.0
+    runtime: io.containerd.spin.v2
+    platform: wasi/wasm
+  edge:
+    image: ghcr.io/org/edge:1.0
+    platform: wasi/wasm
+  db:
+    image: postgres:16
+`), 0600))
+
+	var checked []string
+	installed := map[string]bool{"io.containerd.spin.v2": true}
+
+	manager := &StackManager{
+		engineType: EngineTypeDockerStandalone,
+		wasmRuntime: func(runtime string) (string, error) {
+			checked = append(checked, runtime)
+			if !installed[runtime] {
+				return "not installed", nil
+			}
+
+			return "", nil
+		},
+	}
+
+	err := manager.checkWasmRuntimes(stackFile)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrMissingWasmRuntime))
+	assert.Equal(t, "missing WebAssembly runtime: the service edge declares a wasi platform without a runtime", err.Error())
+	assert.Equal(t, []string{"io.containerd.spin.v2"}, checked)
+
+	installed = map[string]bool{}
+	err = manager.checkWasmRuntimes(stackFile)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "api: not installed")
+
+	// The runtimes are not checked on the engines without a WebAssembly runtime check
+	manager.wasmRuntime = nil
+	assert.NoError(t, manager.checkWasmRuntimes(stackFile))
+
+	// A runtime that cannot be checked does not prevent the deployment
+	manager.wasmRuntime = func(runtime string) (string, error) {
+		return "", errors.New("engine unreachable")
+	}
+	require.NoError(t, os.WriteFile(stackFile, []byte("services:\n  api:\n    image: ghcr.io/org/api:1.0\n    runtime: io.containerd.spin.v2\n"), 0600))
+	assert.NoError(t, manager.checkWasmRuntimes(stackFile))
+}
@@ -0,0 +1,52 @@
+package edge
+
+import (
+	"github.com/portainer/agent/edge/standby"
+
+	"github.com/rs/zerolog/log"
+)
+
+// startStandbyElection runs the election of the active agent of a hot standby pair, only the active
+// agent polls Portainer, manages the Edge stacks and opens the tunnel. The election runs once for the
+// restarts of the manager, the lease is released once the shutdown context of the manager is done.
+func (manager *Manager) startStandbyElection() {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	if manager.lease != nil {
+		return
+	}
+
+	manager.lease = standby.NewLease(manager.agentOptions.DataPath, manager.agentOptions.EdgeStandbyLease, manager.closeTunnel)
+	manager.leaseDone = make(chan struct{})
+
+	go func() {
+		defer close(manager.leaseDone)
+
+		manager.lease.Run(manager.shutdownCtx)
+	}()
+}
+
+// Wait waits for the lease of the active agent to be released once the shutdown context of the manager is done
+func (manager *Manager) Wait() {
+	manager.mu.Lock()
+	leaseDone := manager.leaseDone
+	manager.mu.Unlock()
+
+	if leaseDone != nil {
+		<-leaseDone
+	}
+}
+
+// isActive returns true when the agent is not part of a hot standby pair or is its active agent
+func (manager *Manager) isActive() bool {
+	return manager.lease == nil || manager.lease.Held()
+}
+
+// closeTunnel closes the tunnel of an agent switching to standby, the active agent opens its own. It is called from
+// the lease goroutine while the poll loops keep running, the poll service synchronizes the tunnel operations.
+func (manager *Manager) closeTunnel() {
+	if manager.pollService.closeTunnel() {
+		log.Info().Msg("tunnel shut down after switching to standby")
+	}
+}
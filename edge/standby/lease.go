@@ -0,0 +1,196 @@
+package standby
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/portainer/agent"
+	"github.com/portainer/agent/clock"
+	agentfs "github.com/portainer/agent/filesystem"
+
+	"github.com/rs/zerolog/log"
+)
+
+// leaseFileName is the file of the shared data folder the lease of the active agent is kept in
+const leaseFileName = "active_agent.lease"
+
+// leaseRecord is the content of the lease file
+type leaseRecord struct {
+	Holder    string
+	RenewedAt time.Time
+}
+
+// Lease elects the active agent of a hot standby pair sharing the same data folder. The active agent renews
+// the lease, the standby agent takes it over when it is not renewed for longer than the TTL.
+type Lease struct {
+	path   string
+	holder string
+	ttl    time.Duration
+	clock  agent.Clock
+	held   bool
+	// claimed is set when the lease was written by the agent but not yet confirmed
+	claimed bool
+	onLost  func()
+	mu      sync.Mutex
+}
+
+// NewLease returns a pointer to a new instance of Lease. onLost is called when the lease is taken over
+// by the other agent while it was held, without the lock of the lease so that it can check whether the lease is held.
+func NewLease(dataPath string, ttl time.Duration, onLost func()) *Lease {
+	return &Lease{
+		path:   filepath.Join(dataPath, leaseFileName),
+		holder: holderID(),
+		ttl:    ttl,
+		clock:  clock.NewSystemClock(),
+		onLost: onLost,
+	}
+}
+
+func holderID() string {
+	hostname, _ := os.Hostname()
+
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+
+	return fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), hex.EncodeToString(suffix))
+}
+
+// Held returns true when the agent is the active agent
+func (lease *Lease) Held() bool {
+	lease.mu.Lock()
+	defer lease.mu.Unlock()
+
+	return lease.held
+}
+
+// Run acquires and renews the lease until the context is done, then releases it
+func (lease *Lease) Run(ctx context.Context) {
+	lease.renew()
+
+	for {
+		select {
+		case <-ctx.Done():
+			lease.release()
+
+			return
+		case <-lease.clock.After(lease.ttl / 3):
+			lease.renew()
+		}
+	}
+}
+
+// renew refreshes the lease and calls onLost once the lease was lost
+func (lease *Lease) renew() {
+	if lease.refresh() && lease.onLost != nil {
+		lease.onLost()
+	}
+}
+
+// refresh renews the lease when it is held, or acquires it when it expired. It returns true when the lease
+// was held and is taken over by the other agent.
+func (lease *Lease) refresh() bool {
+	lease.mu.Lock()
+	defer lease.mu.Unlock()
+
+	record, err := lease.read()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Error().Err(err).Msg("unable to read the active agent lease")
+
+		return false
+	}
+
+	now := lease.clock.Now()
+
+	free := record == nil || record.Holder == lease.holder || now.Sub(record.RenewedAt) > lease.ttl
+	if !free {
+		lease.claimed = false
+
+		if lease.held {
+			log.Warn().Str("holder", record.Holder).Msg("the active agent lease was taken over, switching to standby")
+
+			lease.held = false
+
+			return true
+		}
+
+		return false
+	}
+
+	// Both agents can claim an expired lease at the same time, the claim is only confirmed
+	// at the next refresh when the lease was not overwritten by the other agent
+	confirmed := lease.held || (lease.claimed && record != nil && record.Holder == lease.holder)
+
+	if err := lease.write(leaseRecord{Holder: lease.holder, RenewedAt: now}); err != nil {
+		log.Error().Err(err).Msg("unable to renew the active agent lease")
+
+		return false
+	}
+
+	if !confirmed {
+		lease.claimed = true
+
+		return false
+	}
+
+	if !lease.held {
+		log.Info().Str("holder", lease.holder).Msg("active agent lease acquired, taking over the Edge duties")
+	}
+
+	lease.held = true
+	lease.claimed = false
+
+	return false
+}
+
+func (lease *Lease) release() {
+	lease.mu.Lock()
+	defer lease.mu.Unlock()
+
+	if !lease.held {
+		return
+	}
+
+	lease.held = false
+
+	// Backdating the lease lets the standby agent take over at its next refresh
+	if err := lease.write(leaseRecord{Holder: lease.holder}); err != nil {
+		log.Error().Err(err).Msg("unable to release the active agent lease")
+	}
+}
+
+func (lease *Lease) read() (*leaseRecord, error) {
+	data, err := os.ReadFile(lease.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var record leaseRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+
+	return &record, nil
+}
+
+// write replaces the lease file atomically so that the other agent never reads a partial record
+func (lease *Lease) write(record leaseRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	tmpName := filepath.Base(lease.path) + "." + lease.holder + ".tmp"
+	if err := agentfs.WriteFile(filepath.Dir(lease.path), tmpName, data, 0600); err != nil {
+		return err
+	}
+
+	return agentfs.RenameFile(filepath.Join(filepath.Dir(lease.path), tmpName), lease.path)
+}
@@ -0,0 +1,86 @@
+package standby
+
+import (
+	"testing"
+	"time"
+
+	"github.com/portainer/agent/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestLease(dataPath string, fakeClock *clock.FakeClock, onLost func()) *Lease {
+	lease := NewLease(dataPath, 15*time.Second, onLost)
+	lease.clock = fakeClock
+
+	return lease
+}
+
+func TestLease_takeover(t *testing.T) {
+	dataPath := t.TempDir()
+	fakeClock := clock.NewFakeClock(time.Unix(1000, 0))
+
+	lost := false
+	active := newTestLease(dataPath, fakeClock, nil)
+	// The lease is no longer held once it is lost
+	active.onLost = func() { lost = !active.Held() }
+	passive := newTestLease(dataPath, fakeClock, nil)
+
+	// The claim is confirmed at the next refresh
+	active.refresh()
+	assert.False(t, active.Held())
+	active.refresh()
+	assert.True(t, active.Held())
+
+	// The standby agent does not take over a renewed lease
+	fakeClock.Advance(5 * time.Second)
+	passive.refresh()
+	active.refresh()
+	passive.refresh()
+	assert.False(t, passive.Held())
+	assert.True(t, active.Held())
+
+	// The standby agent takes over once the lease expired
+	fakeClock.Advance(20 * time.Second)
+	passive.refresh()
+	passive.refresh()
+	assert.True(t, passive.Held())
+
+	active.renew()
+	assert.False(t, active.Held())
+	assert.True(t, lost)
+}
+
+func TestLease_concurrentClaims(t *testing.T) {
+	dataPath := t.TempDir()
+	fakeClock := clock.NewFakeClock(time.Unix(1000, 0))
+
+	first := newTestLease(dataPath, fakeClock, nil)
+	second := newTestLease(dataPath, fakeClock, nil)
+
+	// Both agents see the lease expired, the last claim wins
+	first.refresh()
+	assert.NoError(t, second.write(leaseRecord{Holder: second.holder, RenewedAt: fakeClock.Now()}))
+	second.claimed = true
+
+	first.refresh()
+	second.refresh()
+	assert.False(t, first.Held())
+	assert.True(t, second.Held())
+}
+
+func TestLease_release(t *testing.T) {
+	dataPath := t.TempDir()
+	fakeClock := clock.NewFakeClock(time.Unix(1000, 0))
+
+	active := newTestLease(dataPath, fakeClock, nil)
+	passive := newTestLease(dataPath, fakeClock, nil)
+
+	active.refresh()
+	active.refresh()
+	active.release()
+
+	// The standby agent takes over a released lease without waiting for the TTL
+	passive.refresh()
+	passive.refresh()
+	assert.True(t, passive.Held())
+}
@@ -0,0 +1,147 @@
+package edge
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/portainer/agent"
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/agent/edge/standby"
+	"github.com/portainer/portainer/pkg/libcrypto"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTunnelClient is a ReverseTunnelClient without synchronization of its own, the race detector reports the
+// unsynchronized uses of the tunnel
+type fakeTunnelClient struct {
+	open   bool
+	closed int
+}
+
+func (c *fakeTunnelClient) CreateTunnel(config agent.TunnelConfig) error {
+	c.open = true
+
+	return nil
+}
+
+func (c *fakeTunnelClient) CloseTunnel() error {
+	c.open = false
+	c.closed++
+
+	return nil
+}
+
+func (c *fakeTunnelClient) IsTunnelOpen() bool {
+	return c.open
+}
+
+// TestManager_closeTunnel_leaseLost loses the lease while the tunnel is polled, run it with -race
+func TestManager_closeTunnel_leaseLost(t *testing.T) {
+	dataPath := t.TempDir()
+
+	credentials, err := libcrypto.Encrypt([]byte("user:password"), []byte("edge-id"))
+	require.NoError(t, err)
+
+	tunnelClient := &fakeTunnelClient{}
+	activity := make(chan struct{})
+
+	manager := &Manager{}
+	manager.pollService = &PollService{edgeID: "edge-id", tunnelClient: tunnelClient, updateLastActivitySignal: activity, edgeManager: manager}
+	manager.lease = standby.NewLease(dataPath, 150*time.Millisecond, manager.closeTunnel)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	leaseDone := make(chan struct{})
+	go func() {
+		manager.lease.Run(ctx)
+		close(leaseDone)
+	}()
+
+	// The activity monitoring loop
+	go func() {
+		for range activity {
+		}
+	}()
+
+	require.Eventually(t, manager.lease.Held, 5*time.Second, time.Millisecond)
+
+	status := client.PollStatusResponse{
+		Status:      agent.TunnelStatusRequired,
+		Credentials: base64.RawStdEncoding.EncodeToString(credentials),
+		Port:        8000,
+	}
+
+	stopPolling := make(chan struct{})
+	pollDone := make(chan struct{})
+
+	go func() {
+		defer close(pollDone)
+
+		for {
+			select {
+			case <-stopPolling:
+				return
+			default:
+				assert.NoError(t, manager.pollService.manageUpdateTunnel(status))
+			}
+		}
+	}()
+
+	// The other agent of the pair takes the lease over
+	record, err := json.Marshal(struct {
+		Holder    string
+		RenewedAt time.Time
+	}{Holder: "standby-agent", RenewedAt: time.Now()})
+	require.NoError(t, err)
+
+	leasePath := filepath.Join(dataPath, "active_agent.lease")
+	require.NoError(t, os.WriteFile(leasePath+".tmp", record, 0600))
+	require.NoError(t, os.Rename(leasePath+".tmp", leasePath))
+
+	require.Eventually(t, func() bool { return !manager.lease.Held() }, 5*time.Second, time.Millisecond)
+
+	close(stopPolling)
+	<-pollDone
+
+	cancel()
+	<-leaseDone
+
+	assert.Positive(t, tunnelClient.closed)
+
+	// A poll in flight once the lease is lost does not open the tunnel again
+	assert.NoError(t, manager.pollService.manageUpdateTunnel(status))
+	assert.False(t, tunnelClient.open)
+
+	close(activity)
+}
+
+func TestManager_Wait_releasesLease(t *testing.T) {
+	dataPath := t.TempDir()
+
+	ctx, shutdown := context.WithCancel(context.Background())
+
+	manager := NewManager(&ManagerParameters{
+		Context: ctx,
+		Options: &agent.Options{DataPath: dataPath, EdgeStandbyLease: 150 * time.Millisecond},
+	})
+
+	manager.startStandbyElection()
+	require.Eventually(t, manager.lease.Held, 5*time.Second, time.Millisecond)
+
+	// The election runs once for the restarts of the manager
+	lease := manager.lease
+	manager.startStandbyElection()
+	assert.Same(t, lease, manager.lease)
+
+	shutdown()
+	manager.Wait()
+
+	assert.False(t, manager.lease.Held())
+}
@@ -0,0 +1,60 @@
+package edge
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/portainer/agent/internals/updates"
+)
+
+// tunnelDialTimeout bounds the connection to the tunnel server checked after an update
+const tunnelDialTimeout = 10 * time.Second
+
+// UpgradeChecks returns the health checks of an updated agent: it polled Portainer, its deployer is available and it
+// reaches the tunnel server
+func (manager *Manager) UpgradeChecks() []updates.Check {
+	return []updates.Check{
+		{Name: "poll", Run: manager.checkPoll},
+		{Name: "deployer", Run: manager.stackManager.CheckDeployer},
+		{Name: "tunnel", Run: manager.checkTunnel},
+	}
+}
+
+func (manager *Manager) checkPoll(ctx context.Context) error {
+	if manager.pollService == nil || manager.pollService.lastPoll.Load() == 0 {
+		return errors.New("the agent did not poll Portainer successfully")
+	}
+
+	return nil
+}
+
+// checkTunnel dials the tunnel server, or its proxy, unless the tunnel is already open. The agents without tunnel
+// capability pass the check.
+func (manager *Manager) checkTunnel(ctx context.Context) error {
+	service := manager.pollService
+	if service == nil || service.tunnelClient == nil || service.tunnelClient.IsTunnelOpen() {
+		return nil
+	}
+
+	addr := service.tunnelServerAddr
+	if service.tunnelProxy != "" {
+		proxy, err := url.Parse(service.tunnelProxy)
+		if err != nil {
+			return err
+		}
+
+		addr = proxy.Host
+	}
+
+	dialer := net.Dialer{Timeout: tunnelDialTimeout}
+
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
@@ -18,19 +18,6 @@ type DockerComposeYaml struct {
 	awsConfig           *agent.AWSConfig
 }
 
-type Compose struct {
-	Version  string             `yaml:"version"`
-	Services map[string]Service `yaml:"services"`
-}
-
-type Service struct {
-	Image       string   `yaml:"image"`
-	Labels      []string `yaml:"labels,omitempty"`
-	Command     []string `yaml:"command,omitempty"`
-	Environment []string `yaml:"environment,omitempty"`
-	Volumes     []string `yaml:"volumes,omitempty"`
-}
-
 func NewDockerComposeYAML(fileContent string, credentials []edge.RegistryCredentials, config *agent.AWSConfig) *DockerComposeYaml {
 	return &DockerComposeYaml{
 		FileContent:         fileContent,
@@ -39,26 +26,28 @@ func NewDockerComposeYAML(fileContent string, credentials []edge.RegistryCredent
 	}
 }
 
+// AddCredentialsAsEnvForSpecificService adds the credentials of the registry of the image of the service to its
+// environment. The rest of the file, including its comments, ordering, anchors and merge keys, is kept as is.
 func (y *DockerComposeYaml) AddCredentialsAsEnvForSpecificService(serviceName string) (string, error) {
-	var compose Compose
-
-	// Parse file content to the object with yaml struct
-	err := yaml.Unmarshal([]byte(y.FileContent), &compose)
+	documents, err := decodeDocuments(y.FileContent)
 	if err != nil {
 		return "", errors.Wrap(err, "Error while unmarshalling the docker compose file content")
 	}
 
-	if !validateComposeFile(&compose, serviceName) {
+	if len(documents) != 1 || !validateComposeFile(documentRoot(documents[0]), serviceName) {
 		return "", errors.New("Failed to validate the compose file content")
 	}
 
 	// Extract registry server url from compose object
-	service, ok := compose.Services[serviceName]
-	if !ok {
-		return "", fmt.Errorf("failed to find the service: %s", serviceName)
+	services, _ := lookupValue(documentRoot(documents[0]), "services")
+	service, _ := lookupValue(services, serviceName)
+
+	image, _ := lookupValue(service, "image")
+	if image == nil || image.Kind != yaml.ScalarNode {
+		return "", fmt.Errorf("failed to find the image of the service: %s", serviceName)
 	}
 
-	serverUrl, err := extractRegistryServerUrl(service.Image)
+	serverUrl, err := extractRegistryServerUrl(image.Value)
 	if err != nil {
 		return "", err
 	}
@@ -100,43 +89,64 @@ func (y *DockerComposeYaml) AddCredentialsAsEnvForSpecificService(serviceName st
 		}
 	}
 
-	return updateServiceWithEnv(compose, serviceName, envs)
+	return updateServiceWithEnv(documents[0], serviceName, envs)
 }
 
-func updateServiceWithEnv(compose Compose, serviceName string, envs map[string]string) (string, error) {
+// updateServiceWithEnv sets the environment variables of the service, written either as a mapping or as a list
+// of KEY=value. An environment shared through an anchor or inherited through a merge key is copied into the
+// service first, so that the other services using it are left untouched.
+func updateServiceWithEnv(document *yaml.Node, serviceName string, envs map[string]string) (string, error) {
 	log.Info().Int("number", len(envs)).Msg("environment variable")
-	service, ok := compose.Services[serviceName]
-	if !ok {
+
+	services, _ := lookupValue(documentRoot(document), "services")
+
+	service, inherited := lookupValue(services, serviceName)
+	if service == nil || service.Kind != yaml.MappingNode || inherited {
 		return "", fmt.Errorf("failed to find the service: %s", serviceName)
 	}
 
-	if service.Environment == nil {
-		service.Environment = make([]string, 0)
+	environment := mappingValue(service, "environment")
+	if environment == nil {
+		if merged, _ := lookupValue(service, "environment"); merged != nil {
+			environment = setMappingValue(service, "environment", copyNode(merged))
+		}
+	} else if environment.Kind == yaml.AliasNode {
+		environment = setMappingValue(service, "environment", copyNode(environment))
+	}
+
+	if environment == nil || (environment.Kind == yaml.ScalarNode && environment.Tag == "!!null") {
+		environment = setMappingValue(service, "environment", &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"})
 	}
 
-	for k, v := range envs {
-		service.Environment = append(service.Environment, fmt.Sprintf("%s=%s", k, v))
+	if environment.Kind != yaml.MappingNode && environment.Kind != yaml.SequenceNode {
+		return "", fmt.Errorf("invalid environment of the service: %s", serviceName)
 	}
 
-	compose.Services[serviceName] = service
+	setEntries(environment, envs)
 
-	// Marshal the Compose object into a byte slice.
-	yamlBytes, err := yaml.Marshal(compose)
+	content, err := encodeDocuments([]*yaml.Node{document})
 	if err != nil {
 		log.Error().Msg("failed to encode compose to yaml file")
 		return "", errors.Wrap(err, "failed to encode compose to yaml file")
 	}
-	return string(yamlBytes), nil
+
+	return content, nil
 }
 
-func validateComposeFile(compose *Compose, serviceName string) bool {
-	if compose == nil || compose.Version == "" || len(compose.Services) == 0 {
+func validateComposeFile(root *yaml.Node, serviceName string) bool {
+	version, _ := lookupValue(root, "version")
+	if version == nil || version.Value == "" {
+		return false
+	}
+
+	services, _ := lookupValue(root, "services")
+	if services == nil || services.Kind != yaml.MappingNode || len(services.Content) == 0 {
 		return false
 	}
 
-	_, ok := compose.Services[serviceName]
+	service, _ := lookupValue(services, serviceName)
 
-	return ok
+	return service != nil
 }
 
 func extractRegistryServerUrl(imageName string) (string, error) {
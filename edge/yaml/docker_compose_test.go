@@ -5,34 +5,35 @@ import (
 	"testing"
 
 	"github.com/pkg/errors"
+	"github.com/portainer/portainer/api/edge"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestUpdateServiceWithEnv(t *testing.T) {
-	compose := Compose{
-		Version: "3",
-		Services: map[string]Service{
-			"updater": {
-				Image: "portainer/portainer-updater:latest",
-				Labels: []string{
-					"io.portainer.hideStack=true",
-					"io.portainer.updater=true",
-				},
-				Command: []string{
-					"portainer", "--image", "portainerci/portainer:2.18", "--env-type", "standalone",
-				},
-				Volumes: []string{
-					"/var/run/docker.sock:/var/run/docker.sock",
-				},
-			},
-		},
+	content := `version: "3"
+services:
+  updater:
+    image: portainer/portainer-updater:latest
+    labels:
+      - io.portainer.hideStack=true
+      - io.portainer.updater=true
+    command: ["portainer", "--image", "portainerci/portainer:2.18", "--env-type", "standalone"]
+    volumes:
+      - /var/run/docker.sock:/var/run/docker.sock
+`
+	documents, err := decodeDocuments(content)
+	if err != nil {
+		t.Fatalf("error while decoding the compose file: %s", err)
 	}
+
 	serviceName := "updater"
 	envs := map[string]string{
 		"ENV_VAR_1": "value1",
 		"ENV_VAR_2": "value2",
 	}
 
-	updatedYAML, err := updateServiceWithEnv(compose, serviceName, envs)
+	updatedYAML, err := updateServiceWithEnv(documents[0], serviceName, envs)
 	if err != nil {
 		t.Errorf("error while updating service with environment variables: %s", err)
 	}
@@ -43,6 +44,53 @@ func TestUpdateServiceWithEnv(t *testing.T) {
 	}
 }
 
+func TestAddCredentialsAsEnvForSpecificService_anchors(t *testing.T) {
+	content := `version: "3.8"
+x-defaults: &defaults
+  restart: always
+  environment: &env
+    TZ: UTC # timezone of the containers
+services:
+  updater:
+    <<: *defaults
+    image: registry.example.com/portainer/updater:latest
+  worker:
+    <<: *defaults
+    image: nginx
+    x-custom:
+      field: kept
+`
+	y := NewDockerComposeYAML(content, []edge.RegistryCredentials{
+		{ServerURL: "registry.example.com", Username: "user", Secret: "secret"},
+	}, nil)
+
+	result, err := y.AddCredentialsAsEnvForSpecificService("updater")
+	require.NoError(t, err)
+
+	documents, err := decodeDocuments(result)
+	require.NoError(t, err)
+
+	services, _ := lookupValue(documentRoot(documents[0]), "services")
+
+	updater, _ := lookupValue(services, "updater")
+	environment, inherited := lookupValue(updater, "environment")
+	assert.False(t, inherited)
+	assert.Equal(t, "UTC", mappingValue(environment, "TZ").Value)
+	assert.Equal(t, "user", mappingValue(environment, "REGISTRY_USERNAME").Value)
+	assert.Equal(t, "secret", mappingValue(environment, "REGISTRY_PASSWORD").Value)
+
+	// The other services sharing the anchor are left untouched
+	worker, _ := lookupValue(services, "worker")
+	environment, inherited = lookupValue(worker, "environment")
+	assert.True(t, inherited)
+	assert.Nil(t, mappingValue(environment, "REGISTRY_USERNAME"))
+
+	assert.Contains(t, result, "x-defaults: &defaults")
+	assert.Contains(t, result, "<<: *defaults")
+	assert.Contains(t, result, "# timezone of the containers")
+	assert.Contains(t, result, "field: kept")
+}
+
 func TestExtractRegistryServerUrl(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -0,0 +1,101 @@
+package yaml
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+// ImageRegistries returns the registries of the images referenced by the given manifest, which can be
+// a compose file, one or several Kubernetes documents or a JSON job. Images without a registry default
+// to docker.io, images that cannot be parsed, e.g. because they use variables, are ignored.
+func ImageRegistries(fileContent string) ([]string, error) {
+	images, err := Images(fileContent)
+	if err != nil {
+		return nil, err
+	}
+
+	registries := make(map[string]struct{})
+
+	for _, image := range images {
+		registry, err := ImageRegistry(image)
+		if err != nil {
+			log.Debug().Err(err).Str("image", image).Msg("ignoring image without a parsable reference")
+
+			continue
+		}
+
+		registries[registry] = struct{}{}
+	}
+
+	result := make([]string, 0, len(registries))
+	for registry := range registries {
+		result = append(result, registry)
+	}
+
+	sort.Strings(result)
+
+	return result, nil
+}
+
+// Images returns the images referenced by the given manifest, see ImageRegistries, in the order they are referenced
+func Images(fileContent string) ([]string, error) {
+	var images []string
+	found := make(map[string]bool)
+
+	decoder := yaml.NewDecoder(strings.NewReader(fileContent))
+
+	for {
+		var document yaml.Node
+
+		err := decoder.Decode(&document)
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		for _, image := range findImages(&document) {
+			if !found[image] {
+				found[image] = true
+				images = append(images, image)
+			}
+		}
+	}
+
+	return images, nil
+}
+
+// ImageRegistry returns the registry of an image, docker.io when the image does not reference one
+func ImageRegistry(image string) (string, error) {
+	return getRegistryDomain(image)
+}
+
+func findImages(node *yaml.Node) []string {
+	var images []string
+
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range node.Content {
+			images = append(images, findImages(child)...)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+
+			if key.Value == "image" && value.Kind == yaml.ScalarNode && value.Value != "" {
+				images = append(images, value.Value)
+
+				continue
+			}
+
+			images = append(images, findImages(value)...)
+		}
+	}
+
+	return images
+}
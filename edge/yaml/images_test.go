@@ -0,0 +1,46 @@
+package yaml
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImageRegistries(t *testing.T) {
+	compose := `
+services:
+  web:
+    image: registry.example.com:5000/team/web:1.0
+  db:
+    image: postgres:16
+  worker:
+    image: ${REGISTRY}/worker
+`
+
+	registries, err := ImageRegistries(compose)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"docker.io", "registry.example.com:5000"}, registries)
+
+	manifest := `
+apiVersion: apps/v1
+kind: Deployment
+spec:
+  template:
+    spec:
+      containers:
+        - name: app
+          image: ghcr.io/org/app:latest
+---
+apiVersion: v1
+kind: Pod
+spec:
+  initContainers:
+    - name: init
+      image: quay.io/org/init
+`
+
+	registries, err = ImageRegistries(manifest)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ghcr.io", "quay.io"}, registries)
+}
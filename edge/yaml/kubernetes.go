@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/base64"
 	"fmt"
+	"path"
 	"regexp"
 	"strings"
 
@@ -11,17 +12,19 @@ import (
 	"github.com/pkg/errors"
 	"github.com/portainer/portainer/api/edge"
 	"github.com/rs/zerolog/log"
-	v1 "k8s.io/api/apps/v1"
+	"gopkg.in/yaml.v3"
 	v1Types "k8s.io/api/core/v1"
 	v1AMacTypes "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer/json"
-	"k8s.io/client-go/kubernetes/scheme"
 )
 
 type KubernetesYaml struct {
 	FileContent         string
 	RegistryCredentials []edge.RegistryCredentials
+	// PatchServiceAccounts references the pull secrets of all the registries in the service accounts of the
+	// manifest, for the pods created by controllers whose pod templates are not known
+	PatchServiceAccounts bool
 }
 
 func NewKubernetesYAML(fileContent string, credentials []edge.RegistryCredentials) *KubernetesYaml {
@@ -83,62 +86,67 @@ func getRegistryDomain(image string) (string, error) {
 	return reference.Domain(ref), nil
 }
 
+// AddImagePullSecrets references the pull secrets of the registries of the images in the pod specs of the
+// workloads, and in the service accounts when PatchServiceAccounts is set, and appends the secrets to the
+// manifest. The other documents, such as unknown custom resources, and the comments, ordering and anchors
+// of the manifest are kept as is.
 func (y *KubernetesYaml) AddImagePullSecrets() (string, error) {
-	ymlFiles := strings.Split(y.FileContent, "---\n")
-	log.Info().Int("length", len(ymlFiles)).Msg("yaml")
+	documents, err := decodeDocuments(y.FileContent)
+	if err != nil {
+		return "", errors.Wrap(err, "Error while decoding original YAML")
+	}
+
+	log.Info().Int("length", len(documents)).Msg("yaml")
 
 	pullSecrets := make([]v1Types.Secret, 0)
-	for i, f := range ymlFiles {
-		decode := scheme.Codecs.UniversalDeserializer().Decode
+	seen := make(map[string]bool)
 
-		obj, _, err := decode([]byte(f), nil, nil) // TODO: validate second param
-		if err != nil {
-			return "", errors.Wrap(err, "Error while decoding original YAML")
-		}
+	addSecret := func(node *yaml.Node, namespace string, cred edge.RegistryCredentials) {
+		imagePullSecretName := slug(cred.ServerURL + cred.Username)
+		addImagePullSecret(node, imagePullSecretName)
 
-		switch o := obj.(type) {
-		case *v1.Deployment:
-			yml := obj.(*v1.Deployment)
-			spec := yml.Spec.Template.Spec
-			namespace := yml.GetNamespace()
+		if seen[namespace+"/"+imagePullSecretName] {
+			return
+		}
 
-			for _, c := range spec.Containers {
-				creds := y.getRegistryCredentialsByImageURL(c.Image)
-				if len(creds) == 0 {
-					continue
-				}
-				for _, cred := range creds {
-					imagePullSecretName := slug(cred.ServerURL + cred.Username)
-					sec := v1Types.LocalObjectReference{
-						Name: imagePullSecretName,
-					}
-					spec.ImagePullSecrets = append(spec.ImagePullSecrets, sec)
+		seen[namespace+"/"+imagePullSecretName] = true
+		pullSecrets = append(pullSecrets, y.generateImagePullSecrets(namespace, imagePullSecretName, cred))
+	}
 
-					pullSecret := y.generateImagePullSecrets(namespace, imagePullSecretName, cred)
+	for _, document := range documents {
+		root := documentRoot(document)
+		namespace := documentNamespace(root)
 
-					pullSecrets = append(pullSecrets, pullSecret)
-				}
+		if y.PatchServiceAccounts && isKind(root, "", "ServiceAccount") {
+			for _, cred := range y.RegistryCredentials {
+				addSecret(root, namespace, cred)
 			}
-			yml.Spec.Template.Spec = spec
 
-			ymlStr, err := encodeYAML(yml)
-			if err != nil {
-				log.Error().Msg("error while encoding YAML with imagePullSecrets")
+			continue
+		}
+
+		spec := podSpec(root)
+		if spec == nil {
+			continue
+		}
 
-				continue
+		for _, image := range containerImages(spec) {
+			for _, cred := range y.getRegistryCredentialsByImageURL(image) {
+				addSecret(spec, namespace, cred)
 			}
-			ymlFiles[i] = ymlStr
-		default:
-			log.Info().Str("type", fmt.Sprintf("%T", obj)).Msg("default case")
-			_ = o
 		}
 	}
 
+	content, err := encodeDocuments(documents)
+	if err != nil {
+		return "", errors.Wrap(err, "Error while encoding YAML with imagePullSecrets")
+	}
+
+	ymlFiles := []string{content}
+
 	// All pullSecrets to original YAML file
 	for _, yml := range pullSecrets {
-		y := yml.DeepCopyObject()
-
-		ymlStr, err := encodeYAML(y)
+		ymlStr, err := encodeYAML(yml.DeepCopyObject())
 		if err != nil {
 			log.Error().Msg("error while encoding YAML with imagePullSecrets")
 
@@ -151,6 +159,179 @@ func (y *KubernetesYaml) AddImagePullSecrets() (string, error) {
 	return strings.Join(ymlFiles, "---\n"), nil
 }
 
+// RewriteRelativeHostPaths resolves the relative hostPath volumes of the workloads against basePath,
+// so that relative path stacks can reference the files copied to the host
+func (y *KubernetesYaml) RewriteRelativeHostPaths(basePath string) (string, error) {
+	documents, err := decodeDocuments(y.FileContent)
+	if err != nil {
+		return "", errors.Wrap(err, "Error while decoding original YAML")
+	}
+
+	for _, document := range documents {
+		spec := podSpec(documentRoot(document))
+		if spec == nil {
+			continue
+		}
+
+		volumes, _ := lookupValue(spec, "volumes")
+		if volumes == nil || volumes.Kind != yaml.SequenceNode {
+			continue
+		}
+
+		for _, volume := range volumes.Content {
+			hostPath, _ := lookupValue(volume, "hostPath")
+			if hostPath == nil || hostPath.Kind != yaml.MappingNode {
+				continue
+			}
+
+			value := mappingValue(hostPath, "path")
+			if value == nil || value.Kind != yaml.ScalarNode || path.IsAbs(value.Value) {
+				continue
+			}
+
+			value.Value = path.Join(basePath, value.Value)
+		}
+	}
+
+	content, err := encodeDocuments(documents)
+	if err != nil {
+		return "", errors.Wrap(err, "Error while encoding YAML with rewritten hostPath volumes")
+	}
+
+	return content, nil
+}
+
+// podSpecPaths are the paths of the pod specs of the workload kinds, keyed by the API group and the kind.
+// The custom resources of the common operators embedding a pod template are included.
+var podSpecPaths = map[string][]string{
+	"/Pod":                   {"spec"},
+	"/PodTemplate":           {"template", "spec"},
+	"/ReplicationController": {"spec", "template", "spec"},
+	"apps/Deployment":        {"spec", "template", "spec"},
+	"apps/StatefulSet":       {"spec", "template", "spec"},
+	"apps/DaemonSet":         {"spec", "template", "spec"},
+	"apps/ReplicaSet":        {"spec", "template", "spec"},
+	"extensions/Deployment":  {"spec", "template", "spec"},
+	"extensions/DaemonSet":   {"spec", "template", "spec"},
+	"extensions/ReplicaSet":  {"spec", "template", "spec"},
+	"batch/Job":              {"spec", "template", "spec"},
+	"batch/CronJob":          {"spec", "jobTemplate", "spec", "template", "spec"},
+
+	"argoproj.io/Rollout":            {"spec", "template", "spec"},
+	"serving.knative.dev/Service":    {"spec", "template", "spec"},
+	"serving.knative.dev/Revision":   {"spec"},
+	"apps.kruise.io/CloneSet":        {"spec", "template", "spec"},
+	"apps.kruise.io/StatefulSet":     {"spec", "template", "spec"},
+	"apps.kruise.io/DaemonSet":       {"spec", "template", "spec"},
+	"apps.kruise.io/AdvancedCronJob": {"spec", "template", "jobTemplate", "spec", "template", "spec"},
+}
+
+// documentKind returns the API group and the kind of a manifest document, the group of the core kinds is empty
+func documentKind(root *yaml.Node) (string, string) {
+	kind, _ := lookupValue(root, "kind")
+	if kind == nil {
+		return "", ""
+	}
+
+	group := ""
+	if apiVersion, _ := lookupValue(root, "apiVersion"); apiVersion != nil {
+		if g, _, found := strings.Cut(apiVersion.Value, "/"); found {
+			group = g
+		}
+	}
+
+	return group, kind.Value
+}
+
+func isKind(root *yaml.Node, group, kind string) bool {
+	g, k := documentKind(root)
+
+	return g == group && k == kind
+}
+
+func documentNamespace(root *yaml.Node) string {
+	metadata, _ := lookupValue(root, "metadata")
+
+	namespace, _ := lookupValue(metadata, "namespace")
+	if namespace == nil {
+		return ""
+	}
+
+	return namespace.Value
+}
+
+// podSpec returns the pod spec of a workload document, nil for the other kinds
+func podSpec(root *yaml.Node) *yaml.Node {
+	group, kind := documentKind(root)
+
+	keys := podSpecPaths[group+"/"+kind]
+	if len(keys) == 0 {
+		return nil
+	}
+
+	node := root
+	for _, key := range keys {
+		if node, _ = lookupValue(node, key); node == nil {
+			return nil
+		}
+	}
+
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	return node
+}
+
+func containerImages(spec *yaml.Node) []string {
+	var images []string
+
+	for _, key := range []string{"initContainers", "containers"} {
+		containers, _ := lookupValue(spec, key)
+		if containers == nil || containers.Kind != yaml.SequenceNode {
+			continue
+		}
+
+		for _, container := range containers.Content {
+			if image, _ := lookupValue(container, "image"); image != nil && image.Value != "" {
+				images = append(images, image.Value)
+			}
+		}
+	}
+
+	return images
+}
+
+// addImagePullSecret references the secret in the pod spec unless it already is, the secrets shared
+// through an anchor or inherited through a merge key are copied into the pod spec first
+func addImagePullSecret(spec *yaml.Node, name string) {
+	secrets := mappingValue(spec, "imagePullSecrets")
+	if secrets == nil || secrets.Kind == yaml.AliasNode {
+		if shared, _ := lookupValue(spec, "imagePullSecrets"); shared != nil {
+			secrets = setMappingValue(spec, "imagePullSecrets", copyNode(shared))
+		}
+	}
+
+	if secrets == nil || secrets.Kind != yaml.SequenceNode {
+		secrets = setMappingValue(spec, "imagePullSecrets", &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"})
+	}
+
+	for _, secret := range secrets.Content {
+		if value, _ := lookupValue(secret, "name"); value != nil && value.Value == name {
+			return
+		}
+	}
+
+	secrets.Content = append(secrets.Content, &yaml.Node{
+		Kind: yaml.MappingNode,
+		Tag:  "!!map",
+		Content: []*yaml.Node{
+			{Kind: yaml.ScalarNode, Tag: "!!str", Value: "name"},
+			{Kind: yaml.ScalarNode, Tag: "!!str", Value: name},
+		},
+	})
+}
+
 // Utility methods
 var re = regexp.MustCompile("[^a-z0-9]+")
 
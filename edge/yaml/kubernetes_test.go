@@ -0,0 +1,171 @@
+package yaml
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/portainer/portainer/api/edge"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRewriteRelativeHostPaths(t *testing.T) {
+	content := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  selector:
+    matchLabels:
+      app: web
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      containers:
+      - name: web
+        image: nginx
+      volumes:
+      - name: config
+        hostPath:
+          path: ./config
+      - name: logs
+        hostPath:
+          path: /var/log
+`
+
+	result, err := NewKubernetesYAML(content, nil).RewriteRelativeHostPaths("/opt/stacks/portainer-compose-unpacker/1")
+	require.NoError(t, err)
+
+	assert.Contains(t, result, "path: /opt/stacks/portainer-compose-unpacker/1/config")
+	assert.Contains(t, result, "path: /var/log")
+	assert.Contains(t, result, "kind: Deployment")
+}
+
+func TestAddImagePullSecrets(t *testing.T) {
+	content := `# the web application
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: apps
+spec:
+  template:
+    spec:
+      containers:
+      - name: web
+        image: &image registry.example.com/web:1.0
+      - name: sidecar
+        image: *image
+---
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: widget
+spec:
+  template:
+    spec:
+      containers:
+      - image: registry.example.com/widget
+  unknownField: [1, 2]
+`
+
+	y := NewKubernetesYAML(content, []edge.RegistryCredentials{
+		{ServerURL: "registry.example.com", Username: "user", Secret: "secret"},
+	})
+
+	result, err := y.AddImagePullSecrets()
+	require.NoError(t, err)
+
+	documents, err := decodeDocuments(result)
+	require.NoError(t, err)
+	require.Len(t, documents, 3)
+
+	spec := podSpec(documentRoot(documents[0]))
+	require.NotNil(t, spec)
+
+	secrets := mappingValue(spec, "imagePullSecrets")
+	require.NotNil(t, secrets)
+	require.Len(t, secrets.Content, 1)
+	assert.Equal(t, "registry-example-comuser", mappingValue(secrets.Content[0], "name").Value)
+
+	// The custom resources are left untouched
+	assert.Nil(t, podSpec(documentRoot(documents[1])))
+	assert.Equal(t, 1, strings.Count(result, "imagePullSecrets:"))
+	assert.Contains(t, result, "unknownField: [1, 2]")
+
+	assert.Contains(t, result, "# the web application")
+	assert.Contains(t, result, "image: &image registry.example.com/web:1.0")
+	assert.Contains(t, result, "image: *image")
+	assert.Equal(t, "Secret", mappingValue(documentRoot(documents[2]), "kind").Value)
+	assert.Equal(t, "apps", mappingValue(mappingValue(documentRoot(documents[2]), "metadata"), "namespace").Value)
+}
+
+func TestAddImagePullSecrets_workloadKinds(t *testing.T) {
+	content := `apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: backup
+spec:
+  schedule: "0 * * * *"
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+          - image: registry.example.com/backup
+---
+apiVersion: argoproj.io/v1alpha1
+kind: Rollout
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      initContainers:
+      - image: registry.example.com/init
+      containers:
+      - image: nginx
+---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: operator
+  namespace: operators
+`
+
+	y := NewKubernetesYAML(content, []edge.RegistryCredentials{
+		{ServerURL: "registry.example.com", Username: "user", Secret: "secret"},
+	})
+
+	result, err := y.AddImagePullSecrets()
+	require.NoError(t, err)
+
+	documents, err := decodeDocuments(result)
+	require.NoError(t, err)
+	require.Len(t, documents, 4)
+
+	for _, document := range documents[:2] {
+		spec := podSpec(documentRoot(document))
+		require.NotNil(t, spec)
+		assert.NotNil(t, mappingValue(spec, "imagePullSecrets"))
+	}
+
+	assert.Nil(t, mappingValue(documentRoot(documents[2]), "imagePullSecrets"))
+
+	// The service accounts are only patched when requested
+	y.PatchServiceAccounts = true
+
+	result, err = y.AddImagePullSecrets()
+	require.NoError(t, err)
+
+	documents, err = decodeDocuments(result)
+	require.NoError(t, err)
+	require.Len(t, documents, 5)
+
+	secrets := mappingValue(documentRoot(documents[2]), "imagePullSecrets")
+	require.NotNil(t, secrets)
+	assert.Equal(t, "registry-example-comuser", mappingValue(secrets.Content[0], "name").Value)
+	assert.Equal(t, "operators", documentNamespace(documentRoot(documents[4])))
+}
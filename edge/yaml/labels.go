@@ -0,0 +1,185 @@
+package yaml
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// AddComposeLabels adds the given labels to the services, networks and volumes created by a compose file.
+// External networks and volumes are not created by the stack and are left untouched.
+func AddComposeLabels(fileContent string, labels map[string]string) (string, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal([]byte(fileContent), &document); err != nil {
+		return "", err
+	}
+
+	root := documentRoot(&document)
+	if root == nil || root.Kind != yaml.MappingNode {
+		return "", errors.New("the compose file is not a mapping")
+	}
+
+	for _, section := range []string{"services", "networks", "volumes"} {
+		entries := mappingValue(root, section)
+		if entries == nil || entries.Kind != yaml.MappingNode {
+			continue
+		}
+
+		for i := 1; i < len(entries.Content); i += 2 {
+			entry := entries.Content[i]
+
+			if entry.Kind == yaml.ScalarNode && entry.Tag == "!!null" {
+				*entry = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			}
+
+			if entry.Kind != yaml.MappingNode {
+				continue
+			}
+
+			if section != "services" && isExternal(entry) {
+				continue
+			}
+
+			setLabels(entry, labels)
+		}
+	}
+
+	return encodeDocuments([]*yaml.Node{&document})
+}
+
+// AddKubernetesLabels adds the given labels to the metadata of each object of a Kubernetes manifest
+func AddKubernetesLabels(fileContent string, labels map[string]string) (string, error) {
+	var documents []*yaml.Node
+
+	decoder := yaml.NewDecoder(strings.NewReader(fileContent))
+
+	for {
+		var document yaml.Node
+
+		err := decoder.Decode(&document)
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return "", err
+		}
+
+		if root := documentRoot(&document); root != nil && root.Kind == yaml.MappingNode && mappingValue(root, "kind") != nil {
+			metadata := mappingValue(root, "metadata")
+			if metadata == nil {
+				metadata = setMappingValue(root, "metadata", &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"})
+			}
+
+			if metadata.Kind == yaml.MappingNode {
+				setLabels(metadata, labels)
+			}
+		}
+
+		documents = append(documents, &document)
+	}
+
+	return encodeDocuments(documents)
+}
+
+func documentRoot(document *yaml.Node) *yaml.Node {
+	if document.Kind != yaml.DocumentNode || len(document.Content) == 0 {
+		return nil
+	}
+
+	return document.Content[0]
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+
+	return nil
+}
+
+func setMappingValue(node *yaml.Node, key string, value *yaml.Node) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content[i+1] = value
+
+			return value
+		}
+	}
+
+	node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+
+	return value
+}
+
+func isExternal(node *yaml.Node) bool {
+	external := mappingValue(node, "external")
+
+	return external != nil && !(external.Kind == yaml.ScalarNode && external.Value == "false")
+}
+
+// setLabels sets the labels of the node, which can be written either as a mapping or as a list of key=value
+func setLabels(node *yaml.Node, labels map[string]string) {
+	existing := mappingValue(node, "labels")
+	if existing == nil || (existing.Kind == yaml.ScalarNode && existing.Tag == "!!null") {
+		existing = setMappingValue(node, "labels", &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"})
+	}
+
+	setEntries(existing, labels)
+}
+
+// setEntries sets the entries of a mapping or of a list of key=value, replacing the existing ones
+func setEntries(node *yaml.Node, entries map[string]string) {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	switch node.Kind {
+	case yaml.MappingNode:
+		for _, key := range keys {
+			setMappingValue(node, key, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: entries[key]})
+		}
+	case yaml.SequenceNode:
+		for _, key := range keys {
+			item := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key + "=" + entries[key]}
+
+			replaced := false
+			for i, entry := range node.Content {
+				if name, _, _ := strings.Cut(entry.Value, "="); name == key {
+					node.Content[i] = item
+					replaced = true
+				}
+			}
+
+			if !replaced {
+				node.Content = append(node.Content, item)
+			}
+		}
+	}
+}
+
+func encodeDocuments(documents []*yaml.Node) (string, error) {
+	var buf bytes.Buffer
+
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+
+	for _, document := range documents {
+		if err := encoder.Encode(document); err != nil {
+			return "", err
+		}
+	}
+
+	if err := encoder.Close(); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
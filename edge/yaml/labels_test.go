@@ -0,0 +1,89 @@
+package yaml
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestAddComposeLabels(t *testing.T) {
+	content := `services:
+  web:
+    image: nginx
+    labels:
+      - traefik.enable=true
+      - io.portainer.stack=old
+  db:
+    image: postgres
+    labels:
+      owner: team
+networks:
+  front:
+  shared:
+    external: true
+volumes:
+  data: {}
+`
+
+	result, err := AddComposeLabels(content, map[string]string{"io.portainer.stack": "1"})
+	require.NoError(t, err)
+
+	var compose struct {
+		Services map[string]struct {
+			Labels yaml.Node `yaml:"labels"`
+		} `yaml:"services"`
+		Networks map[string]map[string]any `yaml:"networks"`
+		Volumes  map[string]map[string]any `yaml:"volumes"`
+	}
+	require.NoError(t, yaml.Unmarshal([]byte(result), &compose))
+
+	web, db := compose.Services["web"], compose.Services["db"]
+
+	var webLabels []string
+	require.NoError(t, web.Labels.Decode(&webLabels))
+	assert.Equal(t, []string{"traefik.enable=true", "io.portainer.stack=1"}, webLabels)
+
+	var dbLabels map[string]string
+	require.NoError(t, db.Labels.Decode(&dbLabels))
+	assert.Equal(t, map[string]string{"owner": "team", "io.portainer.stack": "1"}, dbLabels)
+
+	assert.Equal(t, map[string]any{"io.portainer.stack": "1"}, compose.Networks["front"]["labels"])
+	assert.NotContains(t, compose.Networks["shared"], "labels")
+	assert.Equal(t, map[string]any{"io.portainer.stack": "1"}, compose.Volumes["data"]["labels"])
+}
+
+func TestAddKubernetesLabels(t *testing.T) {
+	content := `apiVersion: v1
+kind: Namespace
+metadata:
+  name: demo
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  labels:
+    app: web
+`
+
+	result, err := AddKubernetesLabels(content, map[string]string{"io.portainer.stack": "1"})
+	require.NoError(t, err)
+
+	var objects []map[string]any
+	decoder := yaml.NewDecoder(strings.NewReader(result))
+	for {
+		var object map[string]any
+		if decoder.Decode(&object) != nil {
+			break
+		}
+
+		objects = append(objects, object)
+	}
+
+	require.Len(t, objects, 2)
+	assert.Equal(t, map[string]any{"io.portainer.stack": "1"}, objects[0]["metadata"].(map[string]any)["labels"])
+	assert.Equal(t, map[string]any{"app": "web", "io.portainer.stack": "1"}, objects[1]["metadata"].(map[string]any)["labels"])
+}
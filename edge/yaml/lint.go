@@ -0,0 +1,164 @@
+package yaml
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"gopkg.in/yaml.v3"
+)
+
+// The rules of the linter of the stack files
+const (
+	// LintUnpinnedImage flags the images referenced without a tag or with the latest tag, and without a digest
+	LintUnpinnedImage = "unpinnedImage"
+	// LintRestartPolicy flags the compose services without a restart policy, they are not restarted when they exit
+	// or when the device reboots
+	LintRestartPolicy = "restartPolicy"
+	// LintResourceLimits flags the compose services and the Kubernetes containers without a memory or CPU limit
+	LintResourceLimits = "resourceLimits"
+	// LintPrivileged flags the privileged containers
+	LintPrivileged = "privileged"
+)
+
+// LintWarning is a finding of the linter, a setting of a workload that is valid but likely to cause trouble on a
+// fleet of devices
+type LintWarning struct {
+	Rule string
+	// Workload is the service of a compose file, or the kind and the name of a Kubernetes workload followed by the
+	// container, e.g. Deployment/web/nginx
+	Workload string
+	Message  string
+}
+
+func (warning LintWarning) String() string {
+	return fmt.Sprintf("%s: %s (%s)", warning.Workload, warning.Message, warning.Rule)
+}
+
+// LintCompose returns the warnings of the services of a compose file
+func LintCompose(fileContent string) ([]LintWarning, error) {
+	documents, err := decodeDocuments(fileContent)
+	if err != nil {
+		return nil, err
+	}
+
+	var warnings []LintWarning
+
+	for _, document := range documents {
+		services, _ := lookupValue(documentRoot(document), "services")
+		if services == nil || services.Kind != yaml.MappingNode {
+			continue
+		}
+
+		for i := 0; i+1 < len(services.Content); i += 2 {
+			name, service := services.Content[i].Value, services.Content[i+1]
+
+			if image, _ := lookupValue(service, "image"); image != nil && image.Kind == yaml.ScalarNode {
+				if unpinnedImage(image.Value) {
+					warnings = append(warnings, LintWarning{Rule: LintUnpinnedImage, Workload: name, Message: fmt.Sprintf("the image %s is not pinned to a tag or a digest", image.Value)})
+				}
+			}
+
+			deploy, _ := lookupValue(service, "deploy")
+
+			if restart, _ := lookupValue(service, "restart"); (restart == nil || restart.Value == "no") && !hasKey(deploy, "restart_policy") {
+				warnings = append(warnings, LintWarning{Rule: LintRestartPolicy, Workload: name, Message: "the service has no restart policy"})
+			}
+
+			resources, _ := lookupValue(deploy, "resources")
+			if !hasKey(service, "mem_limit") && !hasKey(service, "cpus") && !hasKey(resources, "limits") {
+				warnings = append(warnings, LintWarning{Rule: LintResourceLimits, Workload: name, Message: "the service has no memory or CPU limit"})
+			}
+
+			if isTrue(service, "privileged") {
+				warnings = append(warnings, LintWarning{Rule: LintPrivileged, Workload: name, Message: "the service is privileged"})
+			}
+		}
+	}
+
+	return warnings, nil
+}
+
+// LintKubernetes returns the warnings of the containers of the workloads of a Kubernetes manifest. The restart policy
+// is not checked, the pods are restarted by default.
+func LintKubernetes(fileContent string) ([]LintWarning, error) {
+	documents, err := decodeDocuments(fileContent)
+	if err != nil {
+		return nil, err
+	}
+
+	var warnings []LintWarning
+
+	for _, document := range documents {
+		root := documentRoot(document)
+
+		spec := podSpec(root)
+		if spec == nil {
+			continue
+		}
+
+		_, workload := documentKind(root)
+
+		metadata, _ := lookupValue(root, "metadata")
+		if value, _ := lookupValue(metadata, "name"); value != nil {
+			workload += "/" + value.Value
+		}
+
+		for _, key := range []string{"initContainers", "containers"} {
+			containers, _ := lookupValue(spec, key)
+			if containers == nil || containers.Kind != yaml.SequenceNode {
+				continue
+			}
+
+			for _, container := range containers.Content {
+				name := workload
+				if value, _ := lookupValue(container, "name"); value != nil {
+					name += "/" + value.Value
+				}
+
+				if image, _ := lookupValue(container, "image"); image != nil && unpinnedImage(image.Value) {
+					warnings = append(warnings, LintWarning{Rule: LintUnpinnedImage, Workload: name, Message: fmt.Sprintf("the image %s is not pinned to a tag or a digest", image.Value)})
+				}
+
+				resources, _ := lookupValue(container, "resources")
+				if limits, _ := lookupValue(resources, "limits"); !hasKey(limits, "memory") && !hasKey(limits, "cpu") {
+					warnings = append(warnings, LintWarning{Rule: LintResourceLimits, Workload: name, Message: "the container has no memory or CPU limit"})
+				}
+
+				securityContext, _ := lookupValue(container, "securityContext")
+				if isTrue(securityContext, "privileged") {
+					warnings = append(warnings, LintWarning{Rule: LintPrivileged, Workload: name, Message: "the container is privileged"})
+				}
+			}
+		}
+	}
+
+	return warnings, nil
+}
+
+// unpinnedImage returns whether the image has no tag or the latest tag, and no digest. The images using variables and
+// the invalid references, which the engines reject, are not flagged.
+func unpinnedImage(image string) bool {
+	if image == "" || strings.Contains(image, "$") {
+		return false
+	}
+
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return false
+	}
+
+	if _, ok := named.(reference.Digested); ok {
+		return false
+	}
+
+	tagged, ok := named.(reference.Tagged)
+
+	return !ok || tagged.Tag() == "latest"
+}
+
+func hasKey(node *yaml.Node, key string) bool {
+	value, _ := lookupValue(node, key)
+
+	return value != nil
+}
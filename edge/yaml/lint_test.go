@@ -0,0 +1,88 @@
+package yaml
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLintCompose(t *testing.T) {
+	compose := `
+x-defaults: &defaults
+  restart: unless-stopped
+  mem_limit: 256m
+services:
+  web:
+    <<: *defaults
+    image: nginx:1.27
+  cache:
+    image: redis
+    privileged: true
+  worker:
+    image: registry.example.com/worker:latest
+    deploy:
+      restart_policy:
+        condition: on-failure
+      resources:
+        limits:
+          cpus: "0.5"
+  pinned:
+    image: alpine@sha256:0000000000000000000000000000000000000000000000000000000000000000
+    restart: "no"
+    cpus: 1
+  templated:
+    image: app:${TAG}
+    restart: always
+    mem_limit: 64m
+`
+
+	warnings, err := LintCompose(compose)
+	require.NoError(t, err)
+	assert.Equal(t, []LintWarning{
+		{Rule: LintUnpinnedImage, Workload: "cache", Message: "the image redis is not pinned to a tag or a digest"},
+		{Rule: LintRestartPolicy, Workload: "cache", Message: "the service has no restart policy"},
+		{Rule: LintResourceLimits, Workload: "cache", Message: "the service has no memory or CPU limit"},
+		{Rule: LintPrivileged, Workload: "cache", Message: "the service is privileged"},
+		{Rule: LintUnpinnedImage, Workload: "worker", Message: "the image registry.example.com/worker:latest is not pinned to a tag or a digest"},
+		{Rule: LintRestartPolicy, Workload: "pinned", Message: "the service has no restart policy"},
+	}, warnings)
+
+	assert.Equal(t, "cache: the service is privileged (privileged)", warnings[3].String())
+}
+
+func TestLintKubernetes(t *testing.T) {
+	manifest := `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      initContainers:
+        - name: init
+          image: busybox:1.36
+          resources:
+            limits:
+              memory: 32Mi
+      containers:
+        - name: nginx
+          image: nginx
+          securityContext:
+            privileged: true
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+`
+
+	warnings, err := LintKubernetes(manifest)
+	require.NoError(t, err)
+	assert.Equal(t, []LintWarning{
+		{Rule: LintUnpinnedImage, Workload: "Deployment/web/nginx", Message: "the image nginx is not pinned to a tag or a digest"},
+		{Rule: LintResourceLimits, Workload: "Deployment/web/nginx", Message: "the container has no memory or CPU limit"},
+		{Rule: LintPrivileged, Workload: "Deployment/web/nginx", Message: "the container is privileged"},
+	}, warnings)
+}
@@ -0,0 +1,116 @@
+package yaml
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ComposeNetworkAttachment attaches services of a compose file to a network created outside of the stack
+type ComposeNetworkAttachment struct {
+	Network string
+	// Services are the services attached to the network, all of them when empty
+	Services []string
+	// IPv4Addresses are the static addresses of the services on the network, indexed by service name
+	IPv4Addresses map[string]string
+}
+
+// AttachComposeNetworks declares the networks as external networks of the compose file and attaches the services to
+// them. The services keep the networks they are attached to, the default network of the project for the services
+// that do not set any. The services using a network_mode cannot be attached to a network, it is an error when they
+// are listed in the attachment and they are skipped otherwise.
+func AttachComposeNetworks(fileContent string, attachments []ComposeNetworkAttachment) (string, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal([]byte(fileContent), &document); err != nil {
+		return "", err
+	}
+
+	root := documentRoot(&document)
+	if root == nil || root.Kind != yaml.MappingNode {
+		return "", errors.New("the compose file is not a mapping")
+	}
+
+	services := mappingValue(root, "services")
+	if services == nil || services.Kind != yaml.MappingNode {
+		return "", errors.New("the compose file has no services")
+	}
+
+	networks := mappingValue(root, "networks")
+	if networks == nil || networks.Kind != yaml.MappingNode {
+		networks = setMappingValue(root, "networks", &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"})
+	}
+
+	for _, attachment := range attachments {
+		setMappingValue(networks, attachment.Network, &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Content: []*yaml.Node{
+			{Kind: yaml.ScalarNode, Tag: "!!str", Value: "external"},
+			{Kind: yaml.ScalarNode, Tag: "!!bool", Value: "true"},
+		}})
+
+		for _, name := range attachment.Services {
+			if mappingValue(services, name) == nil {
+				return "", fmt.Errorf("service %s of the network %s not found", name, attachment.Network)
+			}
+		}
+
+		for i := 0; i+1 < len(services.Content); i += 2 {
+			name, service := services.Content[i].Value, services.Content[i+1]
+
+			listed := slices.Contains(attachment.Services, name)
+			if len(attachment.Services) > 0 && !listed {
+				continue
+			}
+
+			if service.Kind == yaml.ScalarNode && service.Tag == "!!null" {
+				*service = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			}
+
+			if service.Kind != yaml.MappingNode {
+				continue
+			}
+
+			if mappingValue(service, "network_mode") != nil {
+				if listed {
+					return "", fmt.Errorf("service %s sets a network_mode, it cannot be attached to the network %s", name, attachment.Network)
+				}
+
+				continue
+			}
+
+			attachService(service, attachment.Network, attachment.IPv4Addresses[name])
+		}
+	}
+
+	return encodeDocuments([]*yaml.Node{&document})
+}
+
+// attachService adds the network to the networks of the service, written as a mapping so that its address can be set
+func attachService(service *yaml.Node, network, address string) {
+	serviceNetworks := mappingValue(service, "networks")
+
+	switch {
+	case serviceNetworks == nil || (serviceNetworks.Kind == yaml.ScalarNode && serviceNetworks.Tag == "!!null"):
+		serviceNetworks = setMappingValue(service, "networks", &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"})
+
+		setMappingValue(serviceNetworks, "default", &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null"})
+	case serviceNetworks.Kind == yaml.SequenceNode:
+		mapping := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for _, item := range serviceNetworks.Content {
+			setMappingValue(mapping, item.Value, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null"})
+		}
+
+		serviceNetworks = setMappingValue(service, "networks", mapping)
+	case serviceNetworks.Kind != yaml.MappingNode:
+		return
+	}
+
+	entry := mappingValue(serviceNetworks, network)
+	if entry == nil || entry.Kind != yaml.MappingNode {
+		entry = setMappingValue(serviceNetworks, network, &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"})
+	}
+
+	if address != "" {
+		setMappingValue(entry, "ipv4_address", &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: address})
+	}
+}
@@ -0,0 +1,71 @@
+package yaml
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestAttachComposeNetworks(t *testing.T) {
+	content := `services:
+  collector:
+    image: collector
+  dashboard:
+    image: grafana
+    networks:
+      - front
+  exporter:
+    image: exporter
+    network_mode: host
+networks:
+  front: {}
+`
+
+	result, err := AttachComposeNetworks(content, []ComposeNetworkAttachment{{
+		Network:       "ot_vlan100",
+		IPv4Addresses: map[string]string{"collector": "192.168.100.10"},
+	}})
+	require.NoError(t, err)
+
+	var compose struct {
+		Services map[string]struct {
+			Networks    map[string]map[string]string `yaml:"networks"`
+			NetworkMode string                       `yaml:"network_mode"`
+		} `yaml:"services"`
+		Networks map[string]map[string]any `yaml:"networks"`
+	}
+	require.NoError(t, yaml.Unmarshal([]byte(result), &compose))
+
+	assert.Equal(t, map[string]any{"external": true}, compose.Networks["ot_vlan100"])
+	assert.Contains(t, compose.Networks, "front")
+
+	// The services keep their networks, the default network for those that do not set any
+	assert.Equal(t, map[string]map[string]string{"default": nil, "ot_vlan100": {"ipv4_address": "192.168.100.10"}}, compose.Services["collector"].Networks)
+	assert.Equal(t, map[string]map[string]string{"front": nil, "ot_vlan100": {}}, compose.Services["dashboard"].Networks)
+
+	// The services using a network_mode are skipped
+	assert.Empty(t, compose.Services["exporter"].Networks)
+	assert.Equal(t, "host", compose.Services["exporter"].NetworkMode)
+}
+
+func TestAttachComposeNetworks_services(t *testing.T) {
+	content := `services:
+  collector:
+    image: collector
+  exporter:
+    image: exporter
+    network_mode: host
+`
+
+	result, err := AttachComposeNetworks(content, []ComposeNetworkAttachment{{Network: "ot", Services: []string{"collector"}}})
+	require.NoError(t, err)
+	assert.Contains(t, result, "ot: {}")
+
+	_, err = AttachComposeNetworks(content, []ComposeNetworkAttachment{{Network: "ot", Services: []string{"exporter"}}})
+	assert.Error(t, err)
+
+	_, err = AttachComposeNetworks(content, []ComposeNetworkAttachment{{Network: "ot", Services: []string{"unknown"}}})
+	assert.Error(t, err)
+}
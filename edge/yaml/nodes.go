@@ -0,0 +1,109 @@
+package yaml
+
+import (
+	"errors"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// decodeDocuments decodes every document of the content into nodes, which keep the comments,
+// the ordering and the anchors of the content when encoded back
+func decodeDocuments(content string) ([]*yaml.Node, error) {
+	var documents []*yaml.Node
+
+	decoder := yaml.NewDecoder(strings.NewReader(content))
+
+	for {
+		var document yaml.Node
+
+		err := decoder.Decode(&document)
+		if errors.Is(err, io.EOF) {
+			return documents, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		documents = append(documents, &document)
+	}
+}
+
+// resolveAlias returns the node an alias points to, the node itself otherwise
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node != nil && node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+
+	return node
+}
+
+// lookupValue returns the value of the key of a mapping, including the keys inherited through merge keys.
+// The keys of the mapping take precedence over the merged ones, inherited is true for the merged ones.
+func lookupValue(node *yaml.Node, key string) (value *yaml.Node, inherited bool) {
+	node = resolveAlias(node)
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil, false
+	}
+
+	if value := mappingValue(node, key); value != nil {
+		return resolveAlias(value), false
+	}
+
+	merge := resolveAlias(mappingValue(node, "<<"))
+	if merge == nil {
+		return nil, false
+	}
+
+	sources := []*yaml.Node{merge}
+	if merge.Kind == yaml.SequenceNode {
+		sources = merge.Content
+	}
+
+	for _, source := range sources {
+		if value, _ := lookupValue(source, key); value != nil {
+			return value, true
+		}
+	}
+
+	return nil, false
+}
+
+// copyNode returns a deep copy of the node without its anchors and aliases, so that it can be
+// modified without changing the other nodes sharing it
+func copyNode(node *yaml.Node) *yaml.Node {
+	node = resolveAlias(node)
+
+	copied := *node
+	copied.Anchor = ""
+	copied.Content = make([]*yaml.Node, 0, len(node.Content))
+
+	for _, child := range node.Content {
+		copied.Content = append(copied.Content, copyNode(child))
+	}
+
+	return &copied
+}
+
+// Documents returns the documents of the content decoded as generic values, the mappings whose keys are all
+// strings are decoded as map[string]any so that the documents can be encoded to JSON
+func Documents(content string) ([]any, error) {
+	var documents []any
+
+	decoder := yaml.NewDecoder(strings.NewReader(content))
+
+	for {
+		var document any
+
+		err := decoder.Decode(&document)
+		if errors.Is(err, io.EOF) {
+			return documents, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		if document != nil {
+			documents = append(documents, document)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package yaml
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Normalize returns the documents of the manifest in a canonical form, with their keys sorted and without comments
+// nor formatting, so that two manifests describing the same configuration are normalized the same way. The labels
+// whose key is listed in ignoredLabels are removed, from the label maps as well as from the label lists.
+func Normalize(fileContent string, ignoredLabels ...string) (string, error) {
+	var buf bytes.Buffer
+
+	decoder := yaml.NewDecoder(strings.NewReader(fileContent))
+	encoder := yaml.NewEncoder(&buf)
+
+	for {
+		var document any
+
+		err := decoder.Decode(&document)
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return "", err
+		}
+
+		if err := encoder.Encode(removeLabels(document, ignoredLabels)); err != nil {
+			return "", err
+		}
+	}
+
+	if err := encoder.Close(); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+func removeLabels(value any, ignoredLabels []string) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, child := range v {
+			if key == "labels" {
+				v[key] = filterLabels(child, ignoredLabels)
+
+				continue
+			}
+
+			v[key] = removeLabels(child, ignoredLabels)
+		}
+	case []any:
+		for i, child := range v {
+			v[i] = removeLabels(child, ignoredLabels)
+		}
+	}
+
+	return value
+}
+
+func filterLabels(labels any, ignoredLabels []string) any {
+	switch v := labels.(type) {
+	case map[string]any:
+		for _, label := range ignoredLabels {
+			delete(v, label)
+		}
+	case []any:
+		filtered := v[:0]
+
+		for _, entry := range v {
+			label, _ := entry.(string)
+			key, _, _ := strings.Cut(label, "=")
+
+			if !slices.Contains(ignoredLabels, key) {
+				filtered = append(filtered, entry)
+			}
+		}
+
+		return filtered
+	}
+
+	return labels
+}
@@ -0,0 +1,40 @@
+package yaml
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalize(t *testing.T) {
+	a, err := Normalize(`
+services:
+  web:
+    # the web server
+    image: nginx:1.25
+    labels:
+      io.portainer.edge.id: device-a
+      app: web
+  db:
+    image: postgres:16
+    labels:
+      - io.portainer.edge.id=device-a
+      - tier=data
+`, "io.portainer.edge.id")
+	require.NoError(t, err)
+
+	b, err := Normalize(`
+services:
+  db: {image: "postgres:16", labels: ["io.portainer.edge.id=device-b", "tier=data"]}
+  web: {labels: {app: web, io.portainer.edge.id: device-b}, image: "nginx:1.25"}
+`, "io.portainer.edge.id")
+	require.NoError(t, err)
+
+	assert.Equal(t, a, b)
+	assert.NotContains(t, a, "device-a")
+
+	c, err := Normalize("services:\n  web:\n    image: nginx:1.26\n", "io.portainer.edge.id")
+	require.NoError(t, err)
+	assert.NotEqual(t, a, c)
+}
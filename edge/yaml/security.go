@@ -0,0 +1,226 @@
+package yaml
+
+import (
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// WorkloadSecurity holds the security sensitive settings of a workload of a stack
+type WorkloadSecurity struct {
+	// Name is the service of a compose file, or the kind and the name of a Kubernetes workload, e.g. Deployment/web
+	Name string
+	// Privileged is true when a container of the workload is privileged
+	Privileged  bool
+	HostNetwork bool
+	HostPID     bool
+	// UnconfinedSeccomp is true when a container of the workload runs without a seccomp profile
+	UnconfinedSeccomp bool
+	// Capabilities are the capabilities added to the containers, without the CAP_ prefix, e.g. NET_ADMIN
+	Capabilities []string
+	// BindMounts are the paths of the host mounted in the containers, as written in the file
+	BindMounts []string
+}
+
+// ComposeWorkloadSecurity returns the security sensitive settings of the services of a compose file
+func ComposeWorkloadSecurity(fileContent string) ([]WorkloadSecurity, error) {
+	documents, err := decodeDocuments(fileContent)
+	if err != nil {
+		return nil, err
+	}
+
+	var workloads []WorkloadSecurity
+
+	for _, document := range documents {
+		services, _ := lookupValue(documentRoot(document), "services")
+		if services == nil || services.Kind != yaml.MappingNode {
+			continue
+		}
+
+		for i := 0; i+1 < len(services.Content); i += 2 {
+			service := services.Content[i+1]
+
+			workload := WorkloadSecurity{
+				Name:         services.Content[i].Value,
+				Privileged:   isTrue(service, "privileged"),
+				HostNetwork:  hasValue(service, "network_mode", "host"),
+				HostPID:      hasValue(service, "pid", "host"),
+				Capabilities: capabilities(sequenceValues(service, "cap_add")),
+			}
+
+			for _, option := range sequenceValues(service, "security_opt") {
+				if option == "seccomp:unconfined" || option == "seccomp=unconfined" {
+					workload.UnconfinedSeccomp = true
+				}
+			}
+
+			workload.BindMounts = composeBindMounts(service)
+
+			workloads = append(workloads, workload)
+		}
+	}
+
+	return workloads, nil
+}
+
+// composeBindMounts returns the sources of the bind mounts of a service, in the short syntax the sources that are
+// not paths are named volumes
+func composeBindMounts(service *yaml.Node) []string {
+	volumes, _ := lookupValue(service, "volumes")
+	if volumes == nil || volumes.Kind != yaml.SequenceNode {
+		return nil
+	}
+
+	var mounts []string
+
+	for _, volume := range volumes.Content {
+		volume = resolveAlias(volume)
+
+		switch volume.Kind {
+		case yaml.ScalarNode:
+			source, found := volumeSource(volume.Value)
+			if found && (strings.HasPrefix(source, "/") || strings.HasPrefix(source, ".") || strings.HasPrefix(source, "~") || strings.HasPrefix(source, "$")) {
+				mounts = append(mounts, source)
+			}
+		case yaml.MappingNode:
+			if hasValue(volume, "type", "bind") {
+				if source, _ := lookupValue(volume, "source"); source != nil && source.Value != "" {
+					mounts = append(mounts, source.Value)
+				}
+			}
+		}
+	}
+
+	return mounts
+}
+
+// volumeSource returns the source of a volume in the short syntax, the part before the first colon that is not
+// within a variable, e.g. ${DATA:-/srv}:/data
+func volumeSource(volume string) (string, bool) {
+	depth := 0
+
+	for i, r := range volume {
+		switch {
+		case r == '{' && i > 0 && volume[i-1] == '$':
+			depth++
+		case r == '}' && depth > 0:
+			depth--
+		case r == ':' && depth == 0:
+			return volume[:i], true
+		}
+	}
+
+	return volume, false
+}
+
+// KubernetesWorkloadSecurity returns the security sensitive settings of the workloads of a Kubernetes manifest
+func KubernetesWorkloadSecurity(fileContent string) ([]WorkloadSecurity, error) {
+	documents, err := decodeDocuments(fileContent)
+	if err != nil {
+		return nil, err
+	}
+
+	var workloads []WorkloadSecurity
+
+	for _, document := range documents {
+		root := documentRoot(document)
+
+		spec := podSpec(root)
+		if spec == nil {
+			continue
+		}
+
+		_, kind := documentKind(root)
+
+		workload := WorkloadSecurity{
+			Name:              kind,
+			HostNetwork:       isTrue(spec, "hostNetwork"),
+			HostPID:           isTrue(spec, "hostPID"),
+			UnconfinedSeccomp: unconfinedSeccomp(spec),
+		}
+
+		metadata, _ := lookupValue(root, "metadata")
+		if value, _ := lookupValue(metadata, "name"); value != nil {
+			workload.Name += "/" + value.Value
+		}
+
+		for _, key := range []string{"initContainers", "containers", "ephemeralContainers"} {
+			containers, _ := lookupValue(spec, key)
+			if containers == nil || containers.Kind != yaml.SequenceNode {
+				continue
+			}
+
+			for _, container := range containers.Content {
+				securityContext, _ := lookupValue(container, "securityContext")
+				if securityContext == nil {
+					continue
+				}
+
+				workload.Privileged = workload.Privileged || isTrue(securityContext, "privileged")
+				workload.UnconfinedSeccomp = workload.UnconfinedSeccomp || unconfinedSeccomp(container)
+
+				added, _ := lookupValue(securityContext, "capabilities")
+				workload.Capabilities = append(workload.Capabilities, capabilities(sequenceValues(added, "add"))...)
+			}
+		}
+
+		if volumes, _ := lookupValue(spec, "volumes"); volumes != nil && volumes.Kind == yaml.SequenceNode {
+			for _, volume := range volumes.Content {
+				hostPath, _ := lookupValue(volume, "hostPath")
+				if path, _ := lookupValue(hostPath, "path"); path != nil && path.Value != "" {
+					workload.BindMounts = append(workload.BindMounts, path.Value)
+				}
+			}
+		}
+
+		workloads = append(workloads, workload)
+	}
+
+	return workloads, nil
+}
+
+// unconfinedSeccomp returns whether the security context of a pod spec or of a container disables seccomp
+func unconfinedSeccomp(node *yaml.Node) bool {
+	securityContext, _ := lookupValue(node, "securityContext")
+	profile, _ := lookupValue(securityContext, "seccompProfile")
+
+	return hasValue(profile, "type", "Unconfined")
+}
+
+func isTrue(node *yaml.Node, key string) bool {
+	value, _ := lookupValue(node, key)
+
+	return value != nil && value.Kind == yaml.ScalarNode && strings.EqualFold(value.Value, "true")
+}
+
+func hasValue(node *yaml.Node, key, expected string) bool {
+	value, _ := lookupValue(node, key)
+
+	return value != nil && value.Kind == yaml.ScalarNode && value.Value == expected
+}
+
+func sequenceValues(node *yaml.Node, key string) []string {
+	sequence, _ := lookupValue(node, key)
+	if sequence == nil || sequence.Kind != yaml.SequenceNode {
+		return nil
+	}
+
+	var values []string
+	for _, item := range sequence.Content {
+		if item = resolveAlias(item); item.Kind == yaml.ScalarNode {
+			values = append(values, item.Value)
+		}
+	}
+
+	return values
+}
+
+// capabilities normalizes the names of capabilities, e.g. cap_net_admin is returned as NET_ADMIN
+func capabilities(names []string) []string {
+	var normalized []string
+	for _, name := range names {
+		normalized = append(normalized, strings.TrimPrefix(strings.ToUpper(name), "CAP_"))
+	}
+
+	return normalized
+}
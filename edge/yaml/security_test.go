@@ -0,0 +1,111 @@
+package yaml
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComposeWorkloadSecurity(t *testing.T) {
+	compose := `
+x-host: &host
+  network_mode: host
+  pid: host
+services:
+  monitor:
+    <<: *host
+    image: prom/node-exporter
+    privileged: true
+    cap_add: [cap_sys_admin, NET_ADMIN]
+    security_opt:
+      - seccomp:unconfined
+    volumes:
+      - /:/host:ro
+      - data:/data
+      - ./config:/etc/config
+      - ${LOGS:-/var/log}:/logs
+      - type: bind
+        source: /var/run/docker.sock
+        target: /var/run/docker.sock
+      - type: volume
+        source: cache
+        target: /cache
+  web:
+    image: nginx
+`
+
+	workloads, err := ComposeWorkloadSecurity(compose)
+	require.NoError(t, err)
+	assert.Equal(t, []WorkloadSecurity{
+		{
+			Name:              "monitor",
+			Privileged:        true,
+			HostNetwork:       true,
+			HostPID:           true,
+			UnconfinedSeccomp: true,
+			Capabilities:      []string{"SYS_ADMIN", "NET_ADMIN"},
+			BindMounts:        []string{"/", "./config", "${LOGS:-/var/log}", "/var/run/docker.sock"},
+		},
+		{Name: "web"},
+	}, workloads)
+}
+
+func TestKubernetesWorkloadSecurity(t *testing.T) {
+	manifest := `
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: agent
+spec:
+  template:
+    spec:
+      hostNetwork: true
+      hostPID: true
+      containers:
+        - name: agent
+          image: portainer/agent
+          securityContext:
+            privileged: true
+            capabilities:
+              add: ["SYS_PTRACE"]
+            seccompProfile:
+              type: Unconfined
+      volumes:
+        - name: root
+          hostPath:
+            path: /
+        - name: config
+          configMap:
+            name: agent
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: agent
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: web
+spec:
+  containers:
+    - name: web
+      image: nginx
+`
+
+	workloads, err := KubernetesWorkloadSecurity(manifest)
+	require.NoError(t, err)
+	assert.Equal(t, []WorkloadSecurity{
+		{
+			Name:              "DaemonSet/agent",
+			Privileged:        true,
+			HostNetwork:       true,
+			HostPID:           true,
+			UnconfinedSeccomp: true,
+			Capabilities:      []string{"SYS_PTRACE"},
+			BindMounts:        []string{"/"},
+		},
+		{Name: "Pod/web"},
+	}, workloads)
+}
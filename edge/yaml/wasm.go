@@ -0,0 +1,132 @@
+package yaml
+
+import (
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// WasmWorkload is a workload of a stack run by a WebAssembly runtime, such as the runwasi or spin containerd shims
+type WasmWorkload struct {
+	// Name is the service of a compose file, or the kind and the name of a Kubernetes workload, e.g. Deployment/web
+	Name string
+	// Runtime is the runtime of a compose service, e.g. io.containerd.spin.v2, or the runtime class of a Kubernetes
+	// workload. It is empty for the compose services declaring a wasi platform without a runtime.
+	Runtime string
+}
+
+// wasmRuntimeTokens are the parts of the names of the WebAssembly runtimes, of the shims, e.g. io.containerd.wasmedge.v1,
+// as well as of the Docker runtimes and of the runtime classes commonly registered for them, e.g. wasmtime-spin-v2
+var wasmRuntimeTokens = []string{"wasm", "wasi", "wasmedge", "wasmtime", "wasmer", "spin", "slight", "wws", "lunatic"}
+
+// IsWasmRuntime returns whether a runtime or a runtime class name refers to a WebAssembly runtime
+func IsWasmRuntime(name string) bool {
+	tokens := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	for _, token := range tokens {
+		for _, wasmToken := range wasmRuntimeTokens {
+			if token == wasmToken {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// ComposeWasmWorkloads returns the services of a compose file run by a WebAssembly runtime, the ones whose runtime
+// refers to one or whose platform is wasi, e.g. wasi/wasm
+func ComposeWasmWorkloads(fileContent string) ([]WasmWorkload, error) {
+	documents, err := decodeDocuments(fileContent)
+	if err != nil {
+		return nil, err
+	}
+
+	var workloads []WasmWorkload
+
+	for _, document := range documents {
+		services, _ := lookupValue(documentRoot(document), "services")
+		if services == nil || services.Kind != yaml.MappingNode {
+			continue
+		}
+
+		for i := 0; i+1 < len(services.Content); i += 2 {
+			name, service := services.Content[i].Value, services.Content[i+1]
+
+			var runtime, platform string
+			if value, _ := lookupValue(service, "runtime"); value != nil {
+				runtime = value.Value
+			}
+
+			if value, _ := lookupValue(service, "platform"); value != nil {
+				platform = value.Value
+			}
+
+			switch {
+			case IsWasmRuntime(runtime):
+				workloads = append(workloads, WasmWorkload{Name: name, Runtime: runtime})
+			case runtime == "" && strings.HasPrefix(strings.ToLower(platform), "wasi/"):
+				workloads = append(workloads, WasmWorkload{Name: name})
+			}
+		}
+	}
+
+	return workloads, nil
+}
+
+// KubernetesWasmWorkloads returns the workloads of a Kubernetes manifest whose runtime class refers to a WebAssembly runtime.
+// The workloads using a RuntimeClass declared by the manifest itself are left out.
+func KubernetesWasmWorkloads(fileContent string) ([]WasmWorkload, error) {
+	documents, err := decodeDocuments(fileContent)
+	if err != nil {
+		return nil, err
+	}
+
+	var workloads []WasmWorkload
+	declared := make(map[string]bool)
+
+	for _, document := range documents {
+		root := documentRoot(document)
+
+		if isKind(root, "node.k8s.io", "RuntimeClass") {
+			metadata, _ := lookupValue(root, "metadata")
+			if name, _ := lookupValue(metadata, "name"); name != nil {
+				declared[name.Value] = true
+			}
+
+			continue
+		}
+
+		spec := podSpec(root)
+		if spec == nil {
+			continue
+		}
+
+		runtimeClass, _ := lookupValue(spec, "runtimeClassName")
+		if runtimeClass == nil || !IsWasmRuntime(runtimeClass.Value) {
+			continue
+		}
+
+		_, kind := documentKind(root)
+
+		name := kind
+		metadata, _ := lookupValue(root, "metadata")
+		if value, _ := lookupValue(metadata, "name"); value != nil {
+			name += "/" + value.Value
+		}
+
+		workloads = append(workloads, WasmWorkload{Name: name, Runtime: runtimeClass.Value})
+	}
+
+	var undeclared []WasmWorkload
+	for _, workload := range workloads {
+		if !declared[workload.Runtime] {
+			undeclared = append(undeclared, workload)
+		}
+	}
+
+	return undeclared, nil
+}
@@ -0,0 +1,91 @@
+package yaml
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsWasmRuntime(t *testing.T) {
+	for _, name := range []string{"io.containerd.spin.v2", "io.containerd.wasmedge.v1", "wasmtime-spin-v2", "crun-wasm", "WasmEdge"} {
+		assert.True(t, IsWasmRuntime(name), name)
+	}
+
+	for _, name := range []string{"", "runc", "nvidia", "io.containerd.runc.v2", "spinnaker", "kata"} {
+		assert.False(t, IsWasmRuntime(name), name)
+	}
+}
+
+func TestComposeWasmWorkloads(t *testing.T) {
+	compose := `
+x-wasm: &wasm
+  runtime: io.containerd.spin.v2
+  platform: wasi/wasm
+services:
+  api:
+    <<: *wasm
+    image: ghcr.io/org/api:1.0
+  edge:
+    image: ghcr.io/org/edge:1.0
+    platform: wasi/wasm32
+  db:
+    image: postgres:16
+    runtime: runc
+`
+
+	workloads, err := ComposeWasmWorkloads(compose)
+	require.NoError(t, err)
+	assert.Equal(t, []WasmWorkload{
+		{Name: "api", Runtime: "io.containerd.spin.v2"},
+		{Name: "edge"},
+	}, workloads)
+}
+
+func TestKubernetesWasmWorkloads(t *testing.T) {
+	manifest := `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+spec:
+  template:
+    spec:
+      runtimeClassName: wasmtime-spin-v2
+      containers:
+        - name: api
+          image: ghcr.io/org/api:1.0
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+spec:
+  template:
+    spec:
+      runtimeClassName: kata
+      containers:
+        - name: migrate
+          image: ghcr.io/org/migrate:1.0
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: probe
+spec:
+  runtimeClassName: wasmedge
+  containers:
+    - name: probe
+      image: ghcr.io/org/probe:1.0
+---
+apiVersion: node.k8s.io/v1
+kind: RuntimeClass
+metadata:
+  name: wasmedge
+handler: wasmedge
+`
+
+	workloads, err := KubernetesWasmWorkloads(manifest)
+	require.NoError(t, err)
+	assert.Equal(t, []WasmWorkload{{Name: "Deployment/api", Runtime: "wasmtime-spin-v2"}}, workloads)
+}
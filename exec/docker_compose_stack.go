@@ -2,8 +2,14 @@ package exec
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"path"
+	"runtime"
+	"strings"
 
 	"github.com/portainer/agent"
+	"github.com/portainer/agent/docker"
 	libstack "github.com/portainer/portainer/pkg/libstack"
 	"github.com/portainer/portainer/pkg/libstack/compose"
 )
@@ -11,6 +17,8 @@ import (
 // DockerComposeStackService represents a service for managing stacks by using the Docker binary.
 type DockerComposeStackService struct {
 	deployer libstack.Deployer
+	// command is the docker-compose plugin, run directly for the commands the deployer does not support
+	command string
 }
 
 // NewDockerComposeStackService initializes a new DockerStackService service.
@@ -23,18 +31,29 @@ func NewDockerComposeStackService(binaryPath string) (*DockerComposeStackService
 
 	service := &DockerComposeStackService{
 		deployer: deployer,
+		command:  composePluginCommand(binaryPath),
 	}
 
 	return service, nil
 }
 
+// ComposePluginVersion returns the version of the docker-compose plugin found in the binary path, e.g. 2.24.6
+func ComposePluginVersion(binaryPath string) (string, error) {
+	output, err := runCommandAndCaptureStdErr(composePluginCommand(binaryPath), []string{"version", "--short"}, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
 // Deploy executes the docker stack deploy command.
 func (service *DockerComposeStackService) Deploy(ctx context.Context, name string, filePaths []string, options agent.DeployOptions) error {
 	return service.deployer.Deploy(ctx, filePaths, libstack.DeployOptions{
 		Options: libstack.Options{
 			ProjectName: name,
 			WorkingDir:  options.WorkingDir,
-			Env:         options.Env,
+			Env:         deployerEnv(options.DeployerBaseOptions),
 		},
 	})
 }
@@ -44,7 +63,7 @@ func (service *DockerComposeStackService) Pull(ctx context.Context, name string,
 	return service.deployer.Pull(ctx, filePaths, libstack.Options{
 		ProjectName: name,
 		WorkingDir:  options.WorkingDir,
-		Env:         options.Env,
+		Env:         deployerEnv(options.DeployerBaseOptions),
 	})
 }
 
@@ -52,7 +71,47 @@ func (service *DockerComposeStackService) Pull(ctx context.Context, name string,
 func (service *DockerComposeStackService) Remove(ctx context.Context, name string, filePaths []string, options agent.RemoveOptions) error {
 	return service.deployer.Remove(ctx, name, filePaths, libstack.Options{
 		ProjectName: name,
-		Env:         options.Env,
+		Env:         deployerEnv(options.DeployerBaseOptions),
+	})
+}
+
+// Restart restarts the containers of the project in place, the same way as the docker compose restart command
+func (service *DockerComposeStackService) Restart(ctx context.Context, name string, filePaths []string, options agent.DeployOptions) error {
+	containers, err := docker.GetContainersWithLabel("com.docker.compose.project=" + name)
+	if err != nil {
+		return err
+	}
+
+	if len(containers) == 0 {
+		return fmt.Errorf("no container found for the project %s", name)
+	}
+
+	for _, container := range containers {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := docker.ContainerRestart(container.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Run executes the docker compose run --rm command, the command runs in a new container of the service and its
+// combined outputs and its exit code are returned
+func (service *DockerComposeStackService) Run(ctx context.Context, name string, filePaths []string, serviceName string, command []string, options agent.DeployOptions) ([]byte, int, error) {
+	if len(filePaths) == 0 {
+		return nil, -1, errors.New("missing file paths")
+	}
+
+	// The plugin is run directly, without the compose subcommand of the docker CLI
+	args := append(composeArgs(name, filePaths)[1:], "run", "--rm", "-T", serviceName)
+
+	return runCommandWithOutput(ctx, service.command, append(args, command...), &cmdOpts{
+		WorkingDir: workingDir(options.WorkingDir, filePaths),
+		Env:        deployerEnv(options.DeployerBaseOptions),
 	})
 }
 
@@ -60,10 +119,20 @@ func (service *DockerComposeStackService) Remove(ctx context.Context, name strin
 func (service *DockerComposeStackService) Validate(ctx context.Context, name string, filePaths []string, options agent.ValidateOptions) error {
 	return service.deployer.Validate(ctx, filePaths, libstack.Options{
 		WorkingDir: options.WorkingDir,
-		Env:        options.Env,
+		Env:        deployerEnv(options.DeployerBaseOptions),
 	})
 }
 
+// composePluginCommand returns the path of the docker-compose plugin found in the binary path
+func composePluginCommand(binaryPath string) string {
+	// Assume Linux as a default
+	if runtime.GOOS == "windows" {
+		return path.Join(binaryPath, "docker-compose.exe")
+	}
+
+	return path.Join(binaryPath, "docker-compose")
+}
+
 func (service *DockerComposeStackService) WaitForStatus(ctx context.Context, name string, status libstack.Status) <-chan libstack.WaitResult {
 	return service.deployer.WaitForStatus(ctx, name, status)
 }
@@ -3,10 +3,12 @@ package exec
 import (
 	"context"
 	"errors"
+	"fmt"
 	"path"
 	"runtime"
 
 	"github.com/portainer/agent"
+	"github.com/portainer/agent/docker"
 	libstack "github.com/portainer/portainer/pkg/libstack"
 	"github.com/portainer/portainer/pkg/libstack/compose"
 )
@@ -65,11 +67,36 @@ func (service *DockerSwarmStackService) Deploy(ctx context.Context, name string,
 	}
 	_, err := runCommandAndCaptureStdErr(service.command, args, &cmdOpts{
 		WorkingDir: stackFolder,
-		Env:        options.Env,
+		Env:        deployerEnv(options.DeployerBaseOptions),
 	})
 	return err
 }
 
+// Restart redeploys the tasks of the services of the stack without changing their specification, the same way as
+// the docker service update --force command
+func (service *DockerSwarmStackService) Restart(ctx context.Context, name string, filePaths []string, options agent.DeployOptions) error {
+	services, err := docker.GetServicesWithLabel("com.docker.stack.namespace=" + name)
+	if err != nil {
+		return err
+	}
+
+	if len(services) == 0 {
+		return fmt.Errorf("no service found for the stack %s", name)
+	}
+
+	for _, s := range services {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := docker.ServiceForceUpdate(s.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // Pull is a dummy method for Swarm
 func (service *DockerSwarmStackService) Pull(ctx context.Context, name string, filePaths []string, options agent.PullOptions) error {
 	return nil
@@ -79,7 +106,7 @@ func (service *DockerSwarmStackService) Pull(ctx context.Context, name string, f
 func (service *DockerSwarmStackService) Validate(ctx context.Context, name string, filePaths []string, options agent.ValidateOptions) error {
 	return service.composeDeployer.Validate(ctx, filePaths, libstack.Options{
 		WorkingDir: options.WorkingDir,
-		Env:        options.Env,
+		Env:        deployerEnv(options.DeployerBaseOptions),
 	})
 }
 
@@ -88,7 +115,7 @@ func (service *DockerSwarmStackService) Remove(ctx context.Context, name string,
 	args := []string{"stack", "rm", name}
 
 	_, err := runCommandAndCaptureStdErr(service.command, args, &cmdOpts{
-		Env: options.Env,
+		Env: deployerEnv(options.DeployerBaseOptions),
 	})
 	return err
 }
@@ -3,12 +3,15 @@ package exec
 import (
 	"context"
 	"fmt"
+	"net/url"
 	"os"
 	"path"
 	"runtime"
+	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/portainer/agent"
+	"github.com/portainer/agent/kubernetes"
 )
 
 // KubernetesDeployer represents a service to deploy resources inside a Kubernetes environment.
@@ -47,10 +50,26 @@ func (deployer *KubernetesDeployer) Deploy(ctx context.Context, name string, fil
 
 	args = append(args, "apply", "-f", stackFilePath)
 
-	_, err = runCommandAndCaptureStdErr(deployer.command, args, nil)
+	// Only the proxy is passed to kubectl, the API server is reached without it
+	var opts *cmdOpts
+	if options.Proxy != nil {
+		opts = &cmdOpts{Env: deployerEnv(agent.DeployerBaseOptions{Proxy: options.Proxy}, apiServerHost())}
+	}
+
+	_, err = runCommandAndCaptureStdErr(deployer.command, args, opts)
 	return err
 }
 
+// apiServerHost returns the host of the Kubernetes API server kubectl talks to
+func apiServerHost() string {
+	u, err := url.Parse(kubernetes.APIServerURL())
+	if err != nil {
+		return ""
+	}
+
+	return u.Hostname()
+}
+
 func (deployer *KubernetesDeployer) Remove(ctx context.Context, name string, filePaths []string, options agent.RemoveOptions) error {
 	if len(filePaths) == 0 {
 		return errors.New("missing file paths")
@@ -71,6 +90,51 @@ func (deployer *KubernetesDeployer) Remove(ctx context.Context, name string, fil
 	return err
 }
 
+// Restart restarts the deployments, the stateful sets and the daemon sets of the manifest with the kubectl rollout
+// restart command, the other resources of the manifest cannot be restarted and are left untouched
+func (deployer *KubernetesDeployer) Restart(ctx context.Context, name string, filePaths []string, options agent.DeployOptions) error {
+	if len(filePaths) == 0 {
+		return errors.New("missing file paths")
+	}
+
+	args, err := buildArgs(&argOptions{
+		Namespace: options.Namespace,
+	})
+	if err != nil {
+		return err
+	}
+
+	output, err := runCommandAndCaptureStdErr(deployer.command, append(args, "get", "-f", filePaths[0], "--output", "name"), nil)
+	if err != nil {
+		return err
+	}
+
+	workloads := restartableWorkloads(string(output))
+	if len(workloads) == 0 {
+		return errors.New("the manifest has no deployment, stateful set or daemon set to restart")
+	}
+
+	_, err = runCommandAndCaptureStdErr(deployer.command, append(append(args, "rollout", "restart"), workloads...), nil)
+
+	return err
+}
+
+// restartableWorkloads returns the resources listed by kubectl get --output name that kubectl rollout restart supports
+func restartableWorkloads(output string) []string {
+	var workloads []string
+
+	for _, line := range strings.Fields(output) {
+		kind, _, _ := strings.Cut(line, "/")
+
+		switch kind {
+		case "deployment.apps", "statefulset.apps", "daemonset.apps":
+			workloads = append(workloads, line)
+		}
+	}
+
+	return workloads
+}
+
 // Pull is a dummy method for Kube
 func (deployer *KubernetesDeployer) Pull(ctx context.Context, name string, filePaths []string, options agent.PullOptions) error {
 	return nil
@@ -128,6 +192,15 @@ func buildArgs(opts *argOptions) ([]string, error) {
 }
 
 func buildTokenArgs(token string) ([]string, error) {
+	// The cluster of the kubeconfig is used by the agents running outside of it
+	if os.Getenv(kubernetes.KubeconfigEnvVarName) != "" {
+		return []string{
+			"--token", token,
+			"--server", kubernetes.APIServerURL(),
+			"--insecure-skip-tls-verify",
+		}, nil
+	}
+
 	host := os.Getenv(agent.KubernetesServiceHost)
 	if host == "" {
 		return nil, fmt.Errorf("%s env var is not defined", agent.KubernetesServiceHost)
@@ -0,0 +1,20 @@
+package exec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRestartableWorkloads(t *testing.T) {
+	output := `deployment.apps/web
+service/web
+configmap/config
+statefulset.apps/db
+daemonset.apps/agent
+job.batch/migrate
+`
+
+	assert.Equal(t, []string{"deployment.apps/web", "statefulset.apps/db", "daemonset.apps/agent"}, restartableWorkloads(output))
+	assert.Empty(t, restartableWorkloads("service/web\n"))
+}
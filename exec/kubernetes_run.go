@@ -0,0 +1,184 @@
+package exec
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/portainer/agent"
+)
+
+// commandJobTTL is how long the jobs running the one-off commands are kept once finished, they are deleted by the
+// agent as soon as their output is read
+const commandJobTTL = 10 * time.Minute
+
+// commandJobPollInterval is the interval between two checks of the job running a one-off command
+const commandJobPollInterval = 2 * time.Second
+
+// Run runs the command in a job created from the pod template of the deployment, the stateful set or the daemon set
+// of the manifest named serviceName, and returns the logs and the exit code of its pod
+func (deployer *KubernetesDeployer) Run(ctx context.Context, name string, filePaths []string, serviceName string, command []string, options agent.DeployOptions) ([]byte, int, error) {
+	if len(filePaths) == 0 {
+		return nil, -1, errors.New("missing file paths")
+	}
+
+	args, err := buildArgs(&argOptions{
+		Namespace: options.Namespace,
+	})
+	if err != nil {
+		return nil, -1, err
+	}
+
+	output, err := runCommandAndCaptureStdErr(deployer.command, append(args, "get", "-f", filePaths[0], "--output", "name"), nil)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	var workload string
+	for _, candidate := range restartableWorkloads(string(output)) {
+		if _, workloadName, _ := strings.Cut(candidate, "/"); workloadName == serviceName {
+			workload = candidate
+		}
+	}
+
+	if workload == "" {
+		return nil, -1, fmt.Errorf("no deployment, stateful set or daemon set named %s in the manifest", serviceName)
+	}
+
+	definition, err := runCommandAndCaptureStdErr(deployer.command, append(args, "get", workload, "--output", "json"), nil)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	jobName := fmt.Sprintf("%.40s-run-%d", serviceName, time.Now().Unix())
+
+	job, namespace, err := commandJob(definition, jobName, command)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	jobArgs, err := buildArgs(&argOptions{Namespace: namespace})
+	if err != nil {
+		return nil, -1, err
+	}
+
+	if _, err := runCommandAndCaptureStdErr(deployer.command, append(jobArgs, "apply", "-f", "-"), &cmdOpts{Input: string(job)}); err != nil {
+		return nil, -1, err
+	}
+
+	defer func() {
+		_, _ = runCommandAndCaptureStdErr(deployer.command, append(jobArgs, "delete", "job", jobName, "--wait=false"), nil)
+	}()
+
+	if err := deployer.waitForJob(ctx, jobArgs, jobName); err != nil {
+		return nil, -1, err
+	}
+
+	logs, err := runCommandAndCaptureStdErr(deployer.command, append(jobArgs, "logs", "job/"+jobName), nil)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	exitCode, err := runCommandAndCaptureStdErr(deployer.command, append(jobArgs, "get", "pods", "--selector", "job-name="+jobName,
+		"--output", "jsonpath={.items[0].status.containerStatuses[0].state.terminated.exitCode}"), nil)
+	if err != nil {
+		return logs, -1, err
+	}
+
+	code, err := strconv.Atoi(strings.TrimSpace(string(exitCode)))
+	if err != nil {
+		return logs, -1, fmt.Errorf("unable to read the exit code of the job %s: %w", jobName, err)
+	}
+
+	return logs, code, nil
+}
+
+// waitForJob waits until the job succeeded or failed
+func (deployer *KubernetesDeployer) waitForJob(ctx context.Context, args []string, jobName string) error {
+	for {
+		output, err := runCommandAndCaptureStdErr(deployer.command, append(args, "get", "job", jobName,
+			"--output", "jsonpath={.status.succeeded},{.status.failed}"), nil)
+		if err != nil {
+			return err
+		}
+
+		if succeeded, failed, _ := strings.Cut(strings.TrimSpace(string(output)), ","); succeeded != "" || failed != "" {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(commandJobPollInterval):
+		}
+	}
+}
+
+// commandJob returns the manifest of a job running the command once with the pod template of the workload, and the
+// namespace of the workload. The command replaces the command of the first container, the other containers such as
+// the sidecars are not run and the job is not retried.
+func commandJob(workload []byte, jobName string, command []string) ([]byte, string, error) {
+	var definition struct {
+		Metadata struct {
+			Namespace string `json:"namespace"`
+		} `json:"metadata"`
+		Spec struct {
+			Template map[string]any `json:"template"`
+		} `json:"spec"`
+	}
+
+	if err := json.Unmarshal(workload, &definition); err != nil {
+		return nil, "", err
+	}
+
+	spec, _ := definition.Spec.Template["spec"].(map[string]any)
+	if spec == nil {
+		return nil, "", errors.New("the workload has no pod template")
+	}
+
+	containers, _ := spec["containers"].([]any)
+	if len(containers) == 0 {
+		return nil, "", errors.New("the pod template of the workload has no container")
+	}
+
+	container, _ := containers[0].(map[string]any)
+	if container == nil {
+		return nil, "", errors.New("the pod template of the workload has an invalid container")
+	}
+
+	if len(command) > 0 {
+		container["command"] = command
+		delete(container, "args")
+	}
+
+	// The probes and the lifecycle hooks of the workload do not apply to a command running to completion
+	for _, key := range []string{"livenessProbe", "readinessProbe", "startupProbe", "lifecycle"} {
+		delete(container, key)
+	}
+
+	spec["restartPolicy"] = "Never"
+	spec["containers"] = []any{container}
+
+	// The labels of the template are not copied, the pod would otherwise be selected by the services of the workload
+	template := map[string]any{"spec": spec}
+
+	job, err := json.Marshal(map[string]any{
+		"apiVersion": "batch/v1",
+		"kind":       "Job",
+		"metadata": map[string]any{
+			"name":      jobName,
+			"namespace": definition.Metadata.Namespace,
+		},
+		"spec": map[string]any{
+			"backoffLimit":            0,
+			"ttlSecondsAfterFinished": int(commandJobTTL.Seconds()),
+			"template":                template,
+		},
+	})
+
+	return job, definition.Metadata.Namespace, err
+}
@@ -0,0 +1,58 @@
+package exec
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandJob(t *testing.T) {
+	workload := `{
+  "kind": "Deployment",
+  "metadata": {"name": "web", "namespace": "shop"},
+  "spec": {
+    "template": {
+      "metadata": {"labels": {"app": "web"}},
+      "spec": {
+        "restartPolicy": "Always",
+        "containers": [
+          {"name": "web", "image": "shop:1.2", "args": ["serve"], "env": [{"name": "DB", "value": "db:5432"}], "livenessProbe": {"httpGet": {"path": "/"}}},
+          {"name": "proxy", "image": "envoy:1.30"}
+        ],
+        "volumes": [{"name": "data", "persistentVolumeClaim": {"claimName": "data"}}]
+      }
+    }
+  }
+}`
+
+	manifest, namespace, err := commandJob([]byte(workload), "web-run-1", []string{"./manage", "migrate"})
+	require.NoError(t, err)
+	assert.Equal(t, "shop", namespace)
+
+	var job map[string]any
+	require.NoError(t, json.Unmarshal(manifest, &job))
+
+	assert.Equal(t, "Job", job["kind"])
+	assert.Equal(t, map[string]any{"name": "web-run-1", "namespace": "shop"}, job["metadata"])
+
+	spec := job["spec"].(map[string]any)
+	assert.EqualValues(t, 0, spec["backoffLimit"])
+
+	template := spec["template"].(map[string]any)
+	assert.NotContains(t, template, "metadata")
+
+	podSpec := template["spec"].(map[string]any)
+	assert.Equal(t, "Never", podSpec["restartPolicy"])
+	assert.Len(t, podSpec["volumes"], 1)
+	assert.Equal(t, []any{map[string]any{
+		"name":    "web",
+		"image":   "shop:1.2",
+		"command": []any{"./manage", "migrate"},
+		"env":     []any{map[string]any{"name": "DB", "value": "db:5432"}},
+	}}, podSpec["containers"])
+
+	_, _, err = commandJob([]byte(`{"spec": {"template": {"spec": {}}}}`), "web-run-1", nil)
+	assert.Error(t, err)
+}
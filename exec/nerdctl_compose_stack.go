@@ -0,0 +1,226 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/portainer/agent"
+	"github.com/portainer/agent/containerd"
+	libstack "github.com/portainer/portainer/pkg/libstack"
+	"github.com/rs/zerolog/log"
+)
+
+// NerdctlComposeStackService represents a service for managing stacks on containerd by using the nerdctl binary.
+// The stacks are deployed in the namespace of the CONTAINERD_NAMESPACE environment variable.
+type NerdctlComposeStackService struct {
+	command string
+}
+
+// NewNerdctlComposeStackService initializes a new NerdctlComposeStackService service.
+func NewNerdctlComposeStackService(binaryPath string) (*NerdctlComposeStackService, error) {
+	command, err := containerd.Command(binaryPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NerdctlComposeStackService{command: command}, nil
+}
+
+// Deploy executes the nerdctl compose up command.
+func (service *NerdctlComposeStackService) Deploy(ctx context.Context, name string, filePaths []string, options agent.DeployOptions) error {
+	if len(filePaths) == 0 {
+		return errors.New("missing file paths")
+	}
+
+	args := append(composeArgs(name, filePaths), "up", "--detach", "--remove-orphans")
+
+	_, err := runCommandAndCaptureStdErr(service.command, args, &cmdOpts{
+		WorkingDir: workingDir(options.WorkingDir, filePaths),
+		Env:        deployerEnv(options.DeployerBaseOptions),
+	})
+
+	return err
+}
+
+// Pull executes the nerdctl compose pull command.
+func (service *NerdctlComposeStackService) Pull(ctx context.Context, name string, filePaths []string, options agent.PullOptions) error {
+	if len(filePaths) == 0 {
+		return errors.New("missing file paths")
+	}
+
+	_, err := runCommandAndCaptureStdErr(service.command, append(composeArgs(name, filePaths), "pull"), &cmdOpts{
+		WorkingDir: workingDir(options.WorkingDir, filePaths),
+		Env:        deployerEnv(options.DeployerBaseOptions),
+	})
+
+	return err
+}
+
+// Remove executes the nerdctl compose down command.
+func (service *NerdctlComposeStackService) Remove(ctx context.Context, name string, filePaths []string, options agent.RemoveOptions) error {
+	args := append(composeArgs(name, filePaths), "down", "--remove-orphans")
+
+	_, err := runCommandAndCaptureStdErr(service.command, args, &cmdOpts{
+		WorkingDir: workingDir("", filePaths),
+		Env:        deployerEnv(options.DeployerBaseOptions),
+	})
+
+	return err
+}
+
+// Restart executes the nerdctl compose restart command.
+func (service *NerdctlComposeStackService) Restart(ctx context.Context, name string, filePaths []string, options agent.DeployOptions) error {
+	if len(filePaths) == 0 {
+		return errors.New("missing file paths")
+	}
+
+	_, err := runCommandAndCaptureStdErr(service.command, append(composeArgs(name, filePaths), "restart"), &cmdOpts{
+		WorkingDir: workingDir(options.WorkingDir, filePaths),
+		Env:        deployerEnv(options.DeployerBaseOptions),
+	})
+
+	return err
+}
+
+// Run executes the nerdctl compose run --rm command, the command runs in a new container of the service and its
+// combined outputs and its exit code are returned
+func (service *NerdctlComposeStackService) Run(ctx context.Context, name string, filePaths []string, serviceName string, command []string, options agent.DeployOptions) ([]byte, int, error) {
+	if len(filePaths) == 0 {
+		return nil, -1, errors.New("missing file paths")
+	}
+
+	args := append(composeArgs(name, filePaths), "run", "--rm", serviceName)
+
+	return runCommandWithOutput(ctx, service.command, append(args, command...), &cmdOpts{
+		WorkingDir: workingDir(options.WorkingDir, filePaths),
+		Env:        deployerEnv(options.DeployerBaseOptions),
+	})
+}
+
+// Validate executes the nerdctl compose config command to validate the file format.
+func (service *NerdctlComposeStackService) Validate(ctx context.Context, name string, filePaths []string, options agent.ValidateOptions) error {
+	if len(filePaths) == 0 {
+		return errors.New("missing file paths")
+	}
+
+	_, err := runCommandAndCaptureStdErr(service.command, append(composeArgs(name, filePaths), "config", "--quiet"), &cmdOpts{
+		WorkingDir: workingDir(options.WorkingDir, filePaths),
+		Env:        deployerEnv(options.DeployerBaseOptions),
+	})
+
+	return err
+}
+
+// WaitForStatus waits until the containers of the project reach the status, they are found by their compose project label
+func (service *NerdctlComposeStackService) WaitForStatus(ctx context.Context, name string, status libstack.Status) <-chan libstack.WaitResult {
+	waitResultCh := make(chan libstack.WaitResult)
+	waitResult := libstack.WaitResult{
+		Status: status,
+	}
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				waitResult.ErrorMsg = fmt.Sprintf("failed to wait for status: %s", ctx.Err().Error())
+				waitResultCh <- waitResult
+
+				return
+			default:
+			}
+
+			time.Sleep(1 * time.Second)
+
+			containers, err := containerd.ListContainers("", "com.docker.compose.project="+name)
+			if err != nil {
+				log.Warn().
+					Str("project_name", name).
+					Err(err).
+					Msg("failed to list containerd containers")
+
+				continue
+			}
+
+			aggregateStatus, errorMessage := aggregateContainerStatus(containers)
+			if aggregateStatus == status {
+				waitResultCh <- waitResult
+
+				return
+			}
+
+			if status == libstack.StatusRunning && aggregateStatus == libstack.StatusCompleted {
+				waitResult.Status = libstack.StatusCompleted
+				waitResultCh <- waitResult
+
+				return
+			}
+
+			if errorMessage != "" {
+				waitResult.ErrorMsg = errorMessage
+				waitResultCh <- waitResult
+
+				return
+			}
+
+			log.Debug().
+				Str("project_name", name).
+				Str("status", string(aggregateStatus)).
+				Msg("waiting for status")
+		}
+	}()
+
+	return waitResultCh
+}
+
+// aggregateContainerStatus returns the status of a project from the states of its containers
+func aggregateContainerStatus(containers []containerd.Container) (libstack.Status, string) {
+	if len(containers) == 0 {
+		return libstack.StatusRemoved, ""
+	}
+
+	running, completed := 0, 0
+
+	for _, container := range containers {
+		switch container.State() {
+		case "running":
+			running++
+		case "exited":
+			if code := container.ExitCode(); code != 0 {
+				return libstack.StatusError, fmt.Sprintf("container %s exited with code %d", container.Names, code)
+			}
+
+			completed++
+		case "created", "restarting":
+			return libstack.StatusStarting, ""
+		}
+	}
+
+	switch {
+	case running+completed < len(containers):
+		return libstack.StatusUnknown, ""
+	case running == 0:
+		return libstack.StatusCompleted, ""
+	}
+
+	return libstack.StatusRunning, ""
+}
+
+func composeArgs(name string, filePaths []string) []string {
+	args := []string{"compose", "--project-name", name}
+	for _, filePath := range filePaths {
+		args = append(args, "--file", filePath)
+	}
+
+	return args
+}
+
+func workingDir(dir string, filePaths []string) string {
+	if dir != "" || len(filePaths) == 0 {
+		return dir
+	}
+
+	return path.Dir(filePaths[0])
+}
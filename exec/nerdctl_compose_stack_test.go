@@ -0,0 +1,39 @@
+package exec
+
+import (
+	"testing"
+
+	"github.com/portainer/agent/containerd"
+	libstack "github.com/portainer/portainer/pkg/libstack"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAggregateContainerStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		statuses   []string
+		status     libstack.Status
+		hasMessage bool
+	}{
+		{"no containers", nil, libstack.StatusRemoved, false},
+		{"running", []string{"Up", "Up 2 minutes"}, libstack.StatusRunning, false},
+		{"one-off job done", []string{"Up", "Exited (0) 1 minute ago"}, libstack.StatusRunning, false},
+		{"completed", []string{"Exited (0) 1 minute ago"}, libstack.StatusCompleted, false},
+		{"starting", []string{"Up", "Created"}, libstack.StatusStarting, false},
+		{"failed", []string{"Up", "Exited (1) 3 seconds ago"}, libstack.StatusError, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var containers []containerd.Container
+			for _, status := range tt.statuses {
+				containers = append(containers, containerd.Container{Names: "web", Status: status})
+			}
+
+			status, message := aggregateContainerStatus(containers)
+
+			assert.Equal(t, tt.status, status)
+			assert.Equal(t, tt.hasMessage, message != "")
+		})
+	}
+}
@@ -2,10 +2,14 @@ package exec
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"strings"
+
+	"github.com/portainer/agent"
 )
 
 type cmdOpts struct {
@@ -19,21 +23,7 @@ func runCommandAndCaptureStdErr(command string, args []string, opts *cmdOpts) ([
 	cmd := exec.Command(command, args...)
 	cmd.Stderr = &stderr
 
-	if opts != nil {
-		if opts.Input != "" {
-			cmd.Stdin = strings.NewReader(opts.Input)
-		}
-
-		if opts.WorkingDir != "" {
-			cmd.Dir = opts.WorkingDir
-		}
-
-		if opts.Env != nil {
-			cmd.Env = os.Environ()
-			cmd.Env = append(cmd.Env, opts.Env...)
-		}
-
-	}
+	applyCmdOpts(cmd, opts)
 
 	output, err := cmd.Output()
 
@@ -43,3 +33,82 @@ func runCommandAndCaptureStdErr(command string, args []string, opts *cmdOpts) ([
 
 	return output, nil
 }
+
+// runCommandWithOutput runs the command until it exits or the context is done, and returns its combined standard and
+// error outputs and its exit code. The error is only set when the command could not run to its end.
+func runCommandWithOutput(ctx context.Context, command string, args []string, opts *cmdOpts) ([]byte, int, error) {
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	applyCmdOpts(cmd, opts)
+
+	err := cmd.Run()
+	if err := ctx.Err(); err != nil {
+		return output.Bytes(), -1, err
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return output.Bytes(), exitErr.ExitCode(), nil
+	} else if err != nil {
+		return output.Bytes(), -1, err
+	}
+
+	return output.Bytes(), 0, nil
+}
+
+func applyCmdOpts(cmd *exec.Cmd, opts *cmdOpts) {
+	if opts == nil {
+		return
+	}
+
+	if opts.Input != "" {
+		cmd.Stdin = strings.NewReader(opts.Input)
+	}
+
+	if opts.WorkingDir != "" {
+		cmd.Dir = opts.WorkingDir
+	}
+
+	if opts.Env != nil {
+		cmd.Env = os.Environ()
+		cmd.Env = append(cmd.Env, opts.Env...)
+	}
+}
+
+// deployerEnv returns the environment of the deployer processes, the variables of the stack followed by the ones of
+// the proxy in both cases as the tools read either of them. The local hosts, e.g. the registry credential server, and
+// the noProxy hosts are always reached without the proxy.
+func deployerEnv(options agent.DeployerBaseOptions, noProxy ...string) []string {
+	proxy := options.Proxy
+	if proxy == nil {
+		return options.Env
+	}
+
+	hosts := []string{"localhost", "127.0.0.1"}
+	for _, list := range [][]string{proxy.NoProxy, noProxy} {
+		for _, host := range list {
+			if host != "" {
+				hosts = append(hosts, host)
+			}
+		}
+	}
+
+	env := append([]string{}, options.Env...)
+
+	for _, variable := range []struct{ name, value string }{
+		{"HTTP_PROXY", proxy.HTTPProxy},
+		{"HTTPS_PROXY", proxy.HTTPSProxy},
+		{"NO_PROXY", strings.Join(hosts, ",")},
+	} {
+		if variable.value == "" {
+			continue
+		}
+
+		env = append(env, variable.name+"="+variable.value, strings.ToLower(variable.name)+"="+variable.value)
+	}
+
+	return env
+}
@@ -0,0 +1,27 @@
+package exec
+
+import (
+	"testing"
+
+	"github.com/portainer/agent"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeployerEnv(t *testing.T) {
+	options := agent.DeployerBaseOptions{Env: []string{"TAG=1.0"}}
+
+	assert.Equal(t, []string{"TAG=1.0"}, deployerEnv(options))
+
+	options.Proxy = &agent.ProxyOptions{
+		HTTPSProxy: "http://proxy.corp:3128",
+		NoProxy:    []string{"registry.local:5000"},
+	}
+
+	assert.Equal(t, []string{
+		"TAG=1.0",
+		"HTTPS_PROXY=http://proxy.corp:3128",
+		"https_proxy=http://proxy.corp:3128",
+		"NO_PROXY=localhost,127.0.0.1,registry.local:5000,10.43.0.1",
+		"no_proxy=localhost,127.0.0.1,registry.local:5000,10.43.0.1",
+	}, deployerEnv(options, "10.43.0.1", ""))
+}
@@ -0,0 +1,91 @@
+package filesystem
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/portainer/portainer/api/filesystem"
+)
+
+const (
+	// EncodingBase64 is the default encoding of the content of the files sent by the server
+	EncodingBase64 = "base64"
+	// EncodingGzip is the encoding of the base64 encoded gzip compressed content, used for the large binary files
+	EncodingGzip = "gzip"
+)
+
+// maxDecompressedSize bounds the decompressed content of each file
+const maxDecompressedSize = 1 << 30
+
+// DecodeDirEntries decodes the content of the files according to their encoding, indexed by their path. The content
+// is kept as raw bytes in the strings, so that the binary files are written as they were sent.
+func DecodeDirEntries(dirEntries []filesystem.DirEntry, encodings map[string]string) error {
+	for index, dirEntry := range dirEntries {
+		if !dirEntry.IsFile || dirEntry.Content == "" {
+			continue
+		}
+
+		content, err := decodeContent(dirEntry.Content, fileEncoding(encodings, dirEntry.Name))
+		if err != nil {
+			return fmt.Errorf("unable to decode %s: %w", dirEntry.Name, err)
+		}
+
+		dirEntries[index].Content = content
+	}
+
+	return nil
+}
+
+func fileEncoding(encodings map[string]string, name string) string {
+	for path, encoding := range encodings {
+		if filepath.Clean(path) == filepath.Clean(name) {
+			return encoding
+		}
+	}
+
+	return EncodingBase64
+}
+
+func decodeContent(content, encoding string) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(content)
+	if err != nil {
+		return "", err
+	}
+
+	switch encoding {
+	case "", EncodingBase64:
+		return string(decoded), nil
+	case EncodingGzip:
+		reader, err := gzip.NewReader(bytes.NewReader(decoded))
+		if err != nil {
+			return "", err
+		}
+		defer reader.Close()
+
+		var builder strings.Builder
+
+		n, err := io.Copy(&builder, io.LimitReader(reader, maxDecompressedSize+1))
+		if err != nil {
+			return "", err
+		}
+
+		if n > maxDecompressedSize {
+			return "", fmt.Errorf("the decompressed content exceeds %d bytes", maxDecompressedSize)
+		}
+
+		return builder.String(), nil
+	}
+
+	return "", fmt.Errorf("unsupported encoding %q", encoding)
+}
+
+// IsBinary returns true when the content is not UTF-8 text, such content cannot be rendered or expanded
+func IsBinary(content string) bool {
+	return !utf8.ValidString(content) || strings.ContainsRune(content, 0)
+}
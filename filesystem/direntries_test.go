@@ -0,0 +1,42 @@
+package filesystem
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"testing"
+
+	"github.com/portainer/portainer/api/filesystem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeDirEntries(t *testing.T) {
+	binary := string([]byte{0x53, 0x51, 0x4c, 0x00, 0xff, 0xfe, 0x80})
+
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	_, err := writer.Write([]byte(binary))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	dirEntries := []filesystem.DirEntry{
+		{Name: "docker-compose.yml", Content: base64.StdEncoding.EncodeToString([]byte("services: {}")), IsFile: true},
+		{Name: "seed/app.db", Content: base64.StdEncoding.EncodeToString(compressed.Bytes()), IsFile: true},
+		{Name: "certs/ca.der", Content: base64.StdEncoding.EncodeToString([]byte(binary)), IsFile: true},
+		{Name: "seed", IsFile: false},
+	}
+
+	err = DecodeDirEntries(dirEntries, map[string]string{"./seed/app.db": EncodingGzip, "certs/ca.der": EncodingBase64})
+	require.NoError(t, err)
+
+	assert.Equal(t, "services: {}", dirEntries[0].Content)
+	assert.Equal(t, binary, dirEntries[1].Content)
+	assert.Equal(t, binary, dirEntries[2].Content)
+
+	assert.True(t, IsBinary(binary))
+	assert.False(t, IsBinary(dirEntries[0].Content))
+
+	err = DecodeDirEntries([]filesystem.DirEntry{{Name: "a", Content: "YQ==", IsFile: true}}, map[string]string{"a": "zip"})
+	assert.ErrorContains(t, err, "unsupported encoding")
+}
@@ -0,0 +1,108 @@
+package filesystem
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// durableWrites enables fsync of the files and directories written by the agent.
+// It is meant for edge devices using flash media, where files are frequently lost on power cuts.
+var durableWrites atomic.Bool
+
+// SetDurableWrites enables or disables durable writes
+func SetDurableWrites(enabled bool) {
+	durableWrites.Store(enabled)
+}
+
+// DurableWritesEnabled returns true when durable writes are enabled
+func DurableWritesEnabled() bool {
+	return durableWrites.Load()
+}
+
+// SyncTree flushes to the storage all the files and directories under root, then root's parent
+// so that root itself is durable. Writes are batched by calling it once after a whole
+// folder is written instead of after each file. It is a no-op when durable writes are disabled.
+func SyncTree(root string) error {
+	if !DurableWritesEnabled() {
+		return nil
+	}
+
+	var dirs []string
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			dirs = append(dirs, path)
+
+			return nil
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		return syncPath(path)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Directories are synced once their entries are durable, deepest first
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := syncDirPath(dirs[i]); err != nil {
+			return err
+		}
+	}
+
+	return SyncDir(filepath.Dir(root))
+}
+
+// SyncDir flushes a directory entries to the storage, making the creation,
+// removal or rename of its children durable. It is a no-op when durable writes are disabled.
+func SyncDir(dir string) error {
+	if !DurableWritesEnabled() {
+		return nil
+	}
+
+	return syncDirPath(dir)
+}
+
+func writeFileDurable(filePath string, data []byte, mode os.FileMode) error {
+	f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return syncDirPath(filepath.Dir(filePath))
+}
+
+func syncPath(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return f.Sync()
+}
@@ -0,0 +1,28 @@
+package filesystem
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncTree(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "stack")
+
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "nested"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "nested", "docker-compose.yml"), []byte("services: {}"), 0644))
+
+	SetDurableWrites(true)
+	defer SetDurableWrites(false)
+
+	assert.NoError(t, SyncTree(root))
+
+	require.NoError(t, WriteFile(root, "file", []byte("content"), 0644))
+
+	content, err := os.ReadFile(filepath.Join(root, "file"))
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+}
@@ -0,0 +1,8 @@
+//go:build !windows
+// +build !windows
+
+package filesystem
+
+func syncDirPath(dir string) error {
+	return syncPath(dir)
+}
@@ -0,0 +1,9 @@
+//go:build windows
+// +build windows
+
+package filesystem
+
+// syncDirPath is a no-op as directories cannot be flushed on Windows
+func syncDirPath(dir string) error {
+	return nil
+}
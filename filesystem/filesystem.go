@@ -116,6 +116,10 @@ func WriteFile(folder, filename string, file []byte, mode uint32) error {
 
 	filePath := path.Join(folder, filename)
 
+	if DurableWritesEnabled() {
+		return writeFileDurable(filePath, file, os.FileMode(mode))
+	}
+
 	return os.WriteFile(filePath, file, os.FileMode(mode))
 }
 
@@ -0,0 +1,72 @@
+package agent
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/portainer/agent/serf"
+	httperror "github.com/portainer/portainer/pkg/libhttp/error"
+	"github.com/portainer/portainer/pkg/libhttp/request"
+	"github.com/portainer/portainer/pkg/libhttp/response"
+
+	"github.com/rs/zerolog/log"
+)
+
+type keyRotatePayload struct {
+	// Key is the base64 encoded new encryption key
+	Key string
+	// Overlap is how long the previous key is still accepted, in seconds
+	Overlap int
+}
+
+func (payload *keyRotatePayload) Validate(r *http.Request) error {
+	if payload.Key == "" {
+		return errors.New("invalid key")
+	}
+
+	if payload.Overlap < 0 {
+		return errors.New("invalid overlap")
+	}
+
+	return nil
+}
+
+func (handler *Handler) keyList(w http.ResponseWriter, r *http.Request) *httperror.HandlerError {
+	if handler.clusterService == nil {
+		return httperror.NewError(http.StatusServiceUnavailable, "Agent management is not available when running the agent on a standalone engine", errors.New("Agent management is disabled"))
+	}
+
+	keys, err := handler.clusterService.EncryptionKeys()
+	if err != nil {
+		return httperror.InternalServerError("Unable to list the cluster encryption keys", err)
+	}
+
+	return response.JSON(w, keys)
+}
+
+func (handler *Handler) keyRotate(w http.ResponseWriter, r *http.Request) *httperror.HandlerError {
+	if handler.clusterService == nil {
+		return httperror.NewError(http.StatusServiceUnavailable, "Agent management is not available when running the agent on a standalone engine", errors.New("Agent management is disabled"))
+	}
+
+	var payload keyRotatePayload
+	err := request.DecodeAndValidateJSONPayload(r, &payload)
+	if err != nil {
+		return httperror.BadRequest("Invalid request payload", err)
+	}
+
+	overlap := serf.DefaultKeyRotationOverlap
+	if payload.Overlap > 0 {
+		overlap = time.Duration(payload.Overlap) * time.Second
+	}
+
+	log.Info().Msg("received cluster encryption key rotation request")
+
+	err = handler.clusterService.RotateEncryptionKey(payload.Key, overlap)
+	if err != nil {
+		return httperror.InternalServerError("Unable to rotate the cluster encryption key", err)
+	}
+
+	return response.Empty(w)
+}
@@ -25,6 +25,10 @@ func NewHandler(cs agent.ClusterService, notaryService *security.NotaryService)
 
 	h.Handle("/agents",
 		notaryService.DigitalSignatureVerification(httperror.LoggerHandler(h.agentList))).Methods(http.MethodGet)
+	h.Handle("/agents/keys",
+		notaryService.DigitalSignatureVerification(httperror.LoggerHandler(h.keyList))).Methods(http.MethodGet)
+	h.Handle("/agents/keys/rotate",
+		notaryService.DigitalSignatureVerification(httperror.LoggerHandler(h.keyRotate))).Methods(http.MethodPost)
 
 	return h
 }
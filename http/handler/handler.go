@@ -8,6 +8,8 @@ import (
 
 	"github.com/portainer/agent"
 	"github.com/portainer/agent/edge"
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/agent/edge/jobhistory"
 	"github.com/portainer/agent/exec"
 	httpagenthandler "github.com/portainer/agent/http/handler/agent"
 	"github.com/portainer/agent/http/handler/browse"
@@ -23,6 +25,7 @@ import (
 	"github.com/portainer/agent/http/proxy"
 	"github.com/portainer/agent/http/security"
 	kubecli "github.com/portainer/agent/kubernetes"
+	agentos "github.com/portainer/agent/os"
 )
 
 // Handler is the main handler of the application.
@@ -49,6 +52,7 @@ type Config struct {
 	SystemService        agent.SystemService
 	ClusterService       agent.ClusterService
 	SignatureService     agent.DigitalSignatureService
+	ClusterAuth          *security.ClusterAuth
 	KubeClient           *kubecli.KubeClient
 	KubernetesDeployer   *exec.KubernetesDeployer
 	EdgeManager          *edge.Manager
@@ -63,7 +67,18 @@ var dockerAPIVersionRegexp = regexp.MustCompile(`(/v[0-9]\.[0-9]*)?`)
 // NewHandler returns a pointer to a Handler.
 func NewHandler(config *Config) *Handler {
 	agentProxy := proxy.NewAgentProxy(config.ClusterService, config.RuntimeConfiguration, config.UseTLS)
-	notaryService := security.NewNotaryService(config.SignatureService, true)
+	notaryService := security.NewNotaryService(config.SignatureService, config.ClusterAuth, true)
+
+	var jobHistoryStore *jobhistory.Store
+	var requestStats func() *client.RequestStats
+	var stackChangelogs func() []client.StackChangelog
+	settings := agentos.EffectiveSettings
+	if config.EdgeManager != nil {
+		jobHistoryStore = config.EdgeManager.JobHistory()
+		settings = config.EdgeManager.EffectiveSettings
+		requestStats = config.EdgeManager.RequestStats
+		stackChangelogs = config.EdgeManager.StackChangelogs
+	}
 
 	return &Handler{
 		agentHandler:           httpagenthandler.NewHandler(config.ClusterService, notaryService),
@@ -76,7 +91,7 @@ func NewHandler(config *Config) *Handler {
 		kubernetesProxyHandler: kubernetesproxy.NewHandler(notaryService),
 		nomadProxyHandler:      nomadproxy.NewHandler(notaryService, config.NomadConfig),
 		webSocketHandler:       websocket.NewHandler(config.ClusterService, config.RuntimeConfiguration, notaryService, config.KubeClient),
-		hostHandler:            host.NewHandler(config.SystemService, agentProxy, notaryService),
+		hostHandler:            host.NewHandler(config.SystemService, agentProxy, notaryService, jobHistoryStore, settings, requestStats, stackChangelogs),
 		pingHandler:            ping.NewHandler(),
 		containerPlatform:      config.ContainerPlatform,
 	}
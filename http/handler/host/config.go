@@ -0,0 +1,14 @@
+package host
+
+import (
+	"net/http"
+
+	httperror "github.com/portainer/portainer/pkg/libhttp/error"
+	"github.com/portainer/portainer/pkg/libhttp/response"
+)
+
+// config returns the effective settings of the agent, with their defaults and where their values come from, the
+// secrets being redacted
+func (handler *Handler) config(rw http.ResponseWriter, r *http.Request) *httperror.HandlerError {
+	return response.JSON(rw, handler.settings())
+}
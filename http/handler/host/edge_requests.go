@@ -0,0 +1,24 @@
+package host
+
+import (
+	"errors"
+	"net/http"
+
+	httperror "github.com/portainer/portainer/pkg/libhttp/error"
+	"github.com/portainer/portainer/pkg/libhttp/response"
+)
+
+// edgeRequests returns the metrics of the requests sent to the Portainer server: their retries, their failures and
+// the state of the circuit breaker failing them fast while the server is unreachable
+func (handler *Handler) edgeRequests(rw http.ResponseWriter, r *http.Request) *httperror.HandlerError {
+	if handler.requestStats == nil {
+		return httperror.NotFound("The agent is not in Edge mode", errors.New("the agent is not in Edge mode"))
+	}
+
+	stats := handler.requestStats()
+	if stats == nil {
+		return httperror.NotFound("The Edge agent is not started", errors.New("the Edge agent is not started"))
+	}
+
+	return response.JSON(rw, stats)
+}
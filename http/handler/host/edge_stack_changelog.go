@@ -0,0 +1,40 @@
+package host
+
+import (
+	"errors"
+	"net/http"
+
+	httperror "github.com/portainer/portainer/pkg/libhttp/error"
+	"github.com/portainer/portainer/pkg/libhttp/request"
+	"github.com/portainer/portainer/pkg/libhttp/response"
+)
+
+// edgeStackChangelog returns the history of the versions each Edge stack went through, what became of them and how
+// many versions the device is behind, of the stack given by the stackId query parameter when it is set
+func (handler *Handler) edgeStackChangelog(rw http.ResponseWriter, r *http.Request) *httperror.HandlerError {
+	if handler.stackChangelogs == nil {
+		return httperror.NotFound("The agent is not in Edge mode", errors.New("the agent is not in Edge mode"))
+	}
+
+	stackID, err := request.RetrieveNumericQueryParameter(r, "stackId", true)
+	if err != nil {
+		return httperror.BadRequest("Invalid query parameter: stackId", err)
+	}
+
+	changelogs := handler.stackChangelogs()
+	if changelogs == nil {
+		return httperror.NotFound("The Edge agent is not started", errors.New("the Edge agent is not started"))
+	}
+
+	if stackID == 0 {
+		return response.JSON(rw, changelogs)
+	}
+
+	for _, changelog := range changelogs {
+		if changelog.StackID == stackID {
+			return response.JSON(rw, changelog)
+		}
+	}
+
+	return httperror.NotFound("No version of the stack was received", errors.New("no version of the stack was received"))
+}
@@ -6,26 +6,47 @@ import (
 	"github.com/gorilla/mux"
 
 	"github.com/portainer/agent"
+	"github.com/portainer/agent/edge/client"
+	"github.com/portainer/agent/edge/jobhistory"
 	"github.com/portainer/agent/http/proxy"
 	"github.com/portainer/agent/http/security"
+	agentos "github.com/portainer/agent/os"
 	httperror "github.com/portainer/portainer/pkg/libhttp/error"
 )
 
 // Handler represents an HTTP API Handler for host specific actions
 type Handler struct {
 	*mux.Router
-	systemService agent.SystemService
+	systemService   agent.SystemService
+	jobHistoryStore *jobhistory.Store
+	settings        func() []agentos.Setting
+	requestStats    func() *client.RequestStats
+	stackChangelogs func() []client.StackChangelog
 }
 
-// NewHandler returns a new instance of Handler
-func NewHandler(systemService agent.SystemService, agentProxy *proxy.AgentProxy, notaryService *security.NotaryService) *Handler {
+// NewHandler returns a new instance of Handler, jobHistoryStore is nil when the job history is disabled, settings
+// returns the effective settings of the agent, requestStats the metrics of the requests sent to the Portainer server
+// and stackChangelogs the history of the versions of the Edge stacks, they are nil outside of the Edge mode
+func NewHandler(systemService agent.SystemService, agentProxy *proxy.AgentProxy, notaryService *security.NotaryService, jobHistoryStore *jobhistory.Store, settings func() []agentos.Setting, requestStats func() *client.RequestStats, stackChangelogs func() []client.StackChangelog) *Handler {
 	h := &Handler{
-		Router:        mux.NewRouter(),
-		systemService: systemService,
+		Router:          mux.NewRouter(),
+		systemService:   systemService,
+		jobHistoryStore: jobHistoryStore,
+		settings:        settings,
+		requestStats:    requestStats,
+		stackChangelogs: stackChangelogs,
 	}
 
 	h.Handle("/host/info",
 		agentProxy.Redirect(notaryService.DigitalSignatureVerification(httperror.LoggerHandler(h.hostInfo)))).Methods(http.MethodGet)
+	h.Handle("/host/config",
+		agentProxy.Redirect(notaryService.DigitalSignatureVerification(httperror.LoggerHandler(h.config)))).Methods(http.MethodGet)
+	h.Handle("/host/jobs/history",
+		agentProxy.Redirect(notaryService.DigitalSignatureVerification(httperror.LoggerHandler(h.jobHistory)))).Methods(http.MethodGet)
+	h.Handle("/host/edge/requests",
+		agentProxy.Redirect(notaryService.DigitalSignatureVerification(httperror.LoggerHandler(h.edgeRequests)))).Methods(http.MethodGet)
+	h.Handle("/host/edge/stacks/changelog",
+		agentProxy.Redirect(notaryService.DigitalSignatureVerification(httperror.LoggerHandler(h.edgeStackChangelog)))).Methods(http.MethodGet)
 
 	return h
 }
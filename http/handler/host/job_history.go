@@ -0,0 +1,30 @@
+package host
+
+import (
+	"errors"
+	"net/http"
+
+	httperror "github.com/portainer/portainer/pkg/libhttp/error"
+	"github.com/portainer/portainer/pkg/libhttp/request"
+	"github.com/portainer/portainer/pkg/libhttp/response"
+)
+
+// jobHistory returns the executions of the Edge jobs kept on the device, of the job given by the jobId query
+// parameter when it is set
+func (handler *Handler) jobHistory(rw http.ResponseWriter, r *http.Request) *httperror.HandlerError {
+	if handler.jobHistoryStore == nil {
+		return httperror.NotFound("The job history is disabled", errors.New("the job history is disabled"))
+	}
+
+	jobID, err := request.RetrieveNumericQueryParameter(r, "jobId", true)
+	if err != nil {
+		return httperror.BadRequest("Invalid query parameter: jobId", err)
+	}
+
+	executions, err := handler.jobHistoryStore.List(jobID)
+	if err != nil {
+		return httperror.InternalServerError("Unable to retrieve the job history", err)
+	}
+
+	return response.JSON(rw, executions)
+}
@@ -3,9 +3,16 @@
 package websocket
 
 import (
+	"context"
 	"net"
+
+	"github.com/portainer/agent/docker"
 )
 
 func createDial() (net.Conn, error) {
-	return net.Dial("unix", "/var/run/docker.sock")
+	if docker.IsRemote() {
+		return docker.DialRemote(context.Background())
+	}
+
+	return net.Dial("unix", docker.LocalSocket())
 }
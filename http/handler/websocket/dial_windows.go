@@ -3,11 +3,18 @@
 package websocket
 
 import (
+	"context"
 	"net"
 
+	"github.com/portainer/agent/docker"
+
 	"github.com/Microsoft/go-winio"
 )
 
 func createDial() (net.Conn, error) {
-	return winio.DialPipe("//./pipe/docker_engine", nil)
+	if docker.IsRemote() {
+		return docker.DialRemote(context.Background())
+	}
+
+	return winio.DialPipe(docker.LocalNamedPipe(), nil)
 }
@@ -0,0 +1,20 @@
+package proxy
+
+import (
+	"crypto/tls"
+
+	"github.com/portainer/agent/http/security"
+)
+
+// clusterAuth signs the requests forwarded to the other agents and provides the TLS configuration used to contact them
+var clusterAuth *security.ClusterAuth
+
+// SetClusterAuth sets the authentication of the requests forwarded to the other agents of the cluster,
+// it must be called before the API server is started
+func SetClusterAuth(auth *security.ClusterAuth) {
+	clusterAuth = auth
+}
+
+func agentTLSConfiguration() *tls.Config {
+	return clusterAuth.ClientTLSConfig()
+}
@@ -10,7 +10,6 @@ import (
 	"time"
 
 	"github.com/portainer/agent"
-	"github.com/portainer/agent/crypto"
 
 	"github.com/rs/zerolog/log"
 )
@@ -27,8 +26,7 @@ type ClusterProxy struct {
 // NewClusterProxy returns a pointer to a ClusterProxy.
 // It also sets the default values used in the underlying http.Client.
 func NewClusterProxy(useTLS bool) *ClusterProxy {
-	tlsConfig := crypto.CreateTLSConfiguration()
-	tlsConfig.InsecureSkipVerify = true
+	tlsConfig := agentTLSConfiguration()
 
 	return &ClusterProxy{
 		client: &http.Client{
@@ -183,6 +181,8 @@ func copyRequest(request *http.Request, member *agent.ClusterMember, useTLS bool
 
 	requestCopy.Header = cloneHeader(request.Header)
 	requestCopy.Header.Set(agent.HTTPTargetHeaderName, member.NodeName)
+	clusterAuth.SignHeader(requestCopy.Header, requestCopy.Method, requestCopy.URL.RequestURI(), member.NodeName, body)
+
 	return requestCopy, nil
 }
 
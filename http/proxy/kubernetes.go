@@ -6,12 +6,11 @@ import (
 	"net/url"
 
 	"github.com/portainer/agent/crypto"
+	"github.com/portainer/agent/kubernetes"
 )
 
-const kubernetesAPIURL = "https://kubernetes.default.svc"
-
 func NewKubernetesProxy() http.Handler {
-	remoteURL, _ := url.Parse(kubernetesAPIURL)
+	remoteURL, _ := url.Parse(kubernetes.APIServerURL())
 	proxy := httputil.NewSingleHostReverseProxy(remoteURL)
 
 	tlsConfig := crypto.CreateTLSConfiguration()
@@ -3,23 +3,30 @@
 package proxy
 
 import (
+	"context"
 	"net"
 	"net/http"
 
+	"github.com/portainer/agent/docker"
+
 	"github.com/Microsoft/go-winio"
 )
 
 // NewLocalProxy returns a pointer to a LocalProxy.
 func NewLocalProxy() *LocalProxy {
 	proxy := &LocalProxy{
-		transport: newNamedPipeTransport("//./pipe/docker_engine"),
+		transport: newNamedPipeTransport(docker.LocalNamedPipe()),
 	}
 	return proxy
 }
 
 func newNamedPipeTransport(namedPipePath string) *http.Transport {
 	return &http.Transport{
-		Dial: func(proto, addr string) (conn net.Conn, err error) {
+		DialContext: func(ctx context.Context, proto, addr string) (conn net.Conn, err error) {
+			if docker.IsRemote() {
+				return docker.DialRemote(ctx)
+			}
+
 			return winio.DialPipe(namedPipePath, nil)
 		},
 	}
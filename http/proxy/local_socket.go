@@ -3,21 +3,28 @@
 package proxy
 
 import (
+	"context"
 	"net"
 	"net/http"
+
+	"github.com/portainer/agent/docker"
 )
 
 // NewLocalProxy returns a pointer to a LocalProxy.
 func NewLocalProxy() *LocalProxy {
 	proxy := &LocalProxy{
-		transport: newSocketTransport("/var/run/docker.sock"),
+		transport: newSocketTransport(docker.LocalSocket()),
 	}
 	return proxy
 }
 
 func newSocketTransport(socketPath string) *http.Transport {
 	return &http.Transport{
-		Dial: func(proto, addr string) (conn net.Conn, err error) {
+		DialContext: func(ctx context.Context, proto, addr string) (conn net.Conn, err error) {
+			if docker.IsRemote() {
+				return docker.DialRemote(ctx)
+			}
+
 			return net.Dial("unix", socketPath)
 		},
 	}
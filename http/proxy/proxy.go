@@ -6,10 +6,10 @@ import (
 	"net/url"
 
 	"github.com/portainer/agent"
-	"github.com/portainer/agent/crypto"
 
 	"github.com/gorilla/websocket"
 	"github.com/koding/websocketproxy"
+	"github.com/rs/zerolog/log"
 )
 
 // AgentHTTPRequest redirects a HTTP request to another agent.
@@ -49,13 +49,11 @@ func proxyWebsocketRequest(rw http.ResponseWriter, request *http.Request, target
 		out.Set(agent.HTTPSignatureHeaderName, request.Header.Get(agent.HTTPSignatureHeaderName))
 		out.Set(agent.HTTPPublicKeyHeaderName, request.Header.Get(agent.HTTPPublicKeyHeaderName))
 		out.Set(agent.HTTPTargetHeaderName, targetNode)
+		clusterAuth.SignHeader(out, incoming.Method, incoming.URL.RequestURI(), targetNode, nil)
 	}
 
-	tlsConfig := crypto.CreateTLSConfiguration()
-	tlsConfig.InsecureSkipVerify = true
-
 	proxy.Dialer = &websocket.Dialer{
-		TLSClientConfig: tlsConfig,
+		TLSClientConfig: agentTLSConfiguration(),
 	}
 
 	proxy.ServeHTTP(rw, request)
@@ -76,13 +74,29 @@ func newAgentReverseProxy(target *url.URL, targetNode string) *httputil.ReverseP
 		req.Header.Set(agent.HTTPTargetHeaderName, targetNode)
 	}
 
-	tlsConfig := crypto.CreateTLSConfiguration()
-	tlsConfig.InsecureSkipVerify = true
-
 	return &httputil.ReverseProxy{
 		Director: director,
-		Transport: &http.Transport{
-			TLSClientConfig: tlsConfig,
+		Transport: &signingTransport{
+			RoundTripper: &http.Transport{
+				TLSClientConfig: agentTLSConfiguration(),
+			},
+			targetNode: targetNode,
 		},
 	}
 }
+
+// signingTransport signs the requests forwarded to targetNode, the requests that cannot be signed are not sent
+type signingTransport struct {
+	http.RoundTripper
+	targetNode string
+}
+
+func (transport *signingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := clusterAuth.SignRequest(req, transport.targetNode); err != nil {
+		log.Error().Err(err).Str("target_node", transport.targetNode).Msg("unable to sign the forwarded request")
+
+		return nil, err
+	}
+
+	return transport.RoundTripper.RoundTrip(req)
+}
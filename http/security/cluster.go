@@ -0,0 +1,350 @@
+package security
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/portainer/agent"
+	"github.com/portainer/agent/clock"
+	"github.com/portainer/agent/crypto"
+)
+
+const (
+	// clusterSignatureMaxSkew is how far the timestamp of a signed request can be from the clock of the receiving agent
+	clusterSignatureMaxSkew = 30 * time.Second
+
+	clusterSigningContext = "portainer-agent-cluster-signing"
+)
+
+// ClusterAuthConfig is the configuration of the authentication of the requests forwarded between the agents of a cluster
+type ClusterAuthConfig struct {
+	// Secret is the key material the signing key is derived from, the requests are not signed when empty
+	Secret string
+	// CACertPath, CertPath and KeyPath are the PEM files used for the mutual TLS authentication between the agents,
+	// the agents present the certificate to each other and verify the certificate of their peer against the CA
+	CACertPath string
+	CertPath   string
+	KeyPath    string
+}
+
+// ClusterAuth signs the requests an agent forwards to the other agents of the cluster with a timestamp, a nonce and the
+// digest of their body, and verifies the requests forwarded to it, rejecting the replayed ones
+type ClusterAuth struct {
+	key         []byte
+	certificate *tls.Certificate
+	caPool      *x509.CertPool
+	clock       agent.Clock
+	nonces      map[string]time.Time
+	members     func() []agent.ClusterMember
+	mu          sync.Mutex
+}
+
+// NewClusterAuth returns a pointer to a new instance of ClusterAuth, nil when neither signing nor mutual TLS is configured
+func NewClusterAuth(config ClusterAuthConfig) (*ClusterAuth, error) {
+	auth := &ClusterAuth{
+		clock:  clock.NewSystemClock(),
+		nonces: make(map[string]time.Time),
+	}
+
+	if config.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(config.Secret))
+		mac.Write([]byte(clusterSigningContext))
+		auth.key = mac.Sum(nil)
+	}
+
+	if config.CACertPath != "" || config.CertPath != "" || config.KeyPath != "" {
+		if config.CACertPath == "" || config.CertPath == "" || config.KeyPath == "" {
+			return nil, errors.New("the CA certificate, certificate and key are all required for the mutual TLS authentication between agents")
+		}
+
+		certificate, err := tls.LoadX509KeyPair(config.CertPath, config.KeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load the cluster certificate: %w", err)
+		}
+
+		caCert, err := os.ReadFile(config.CACertPath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the cluster CA certificate: %w", err)
+		}
+
+		auth.caPool = x509.NewCertPool()
+		if !auth.caPool.AppendCertsFromPEM(caCert) {
+			return nil, errors.New("invalid cluster CA certificate")
+		}
+
+		auth.certificate = &certificate
+	}
+
+	if auth.key == nil && auth.certificate == nil {
+		return nil, nil
+	}
+
+	return auth, nil
+}
+
+// MutualTLS returns true when the agents authenticate each other with certificates
+func (auth *ClusterAuth) MutualTLS() bool {
+	return auth != nil && auth.certificate != nil
+}
+
+// ClientTLSConfig returns the TLS configuration used to contact the other agents. With mutual TLS, the agent presents
+// its certificate and verifies the one of its peer against the CA, the agents being contacted by IP address the host
+// name is not verified.
+func (auth *ClusterAuth) ClientTLSConfig() *tls.Config {
+	tlsConfig := crypto.CreateTLSConfiguration()
+	tlsConfig.InsecureSkipVerify = true
+
+	// Without mutual TLS, the agents present self-signed certificates
+	if !auth.MutualTLS() {
+		return tlsConfig
+	}
+
+	tlsConfig.Certificates = []tls.Certificate{*auth.certificate}
+	tlsConfig.VerifyPeerCertificate = auth.verifyPeerCertificate(x509.ExtKeyUsageServerAuth)
+
+	return tlsConfig
+}
+
+// ServerTLSConfig configures the API server to present the cluster certificate and to verify the certificate
+// of the agents forwarding requests to it. Portainer does not present a certificate, so it is only verified when given,
+// the forwarded requests without a verified certificate are rejected by Verify.
+func (auth *ClusterAuth) ServerTLSConfig(tlsConfig *tls.Config) {
+	if !auth.MutualTLS() {
+		return
+	}
+
+	tlsConfig.Certificates = []tls.Certificate{*auth.certificate}
+	tlsConfig.ClientCAs = auth.caPool
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+}
+
+func (auth *ClusterAuth) verifyPeerCertificate(usage x509.ExtKeyUsage) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("the agent did not present a certificate")
+		}
+
+		certs := make([]*x509.Certificate, 0, len(rawCerts))
+		for _, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return err
+			}
+
+			certs = append(certs, cert)
+		}
+
+		intermediates := x509.NewCertPool()
+		for _, cert := range certs[1:] {
+			intermediates.AddCert(cert)
+		}
+
+		_, err := certs[0].Verify(x509.VerifyOptions{
+			Roots:         auth.caPool,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{usage},
+		})
+
+		return err
+	}
+}
+
+// SignHeader signs a request forwarded to targetNode, requestURI is the path and query of the request and body its
+// content
+func (auth *ClusterAuth) SignHeader(header http.Header, method, requestURI, targetNode string, body []byte) {
+	if auth == nil || auth.key == nil {
+		return
+	}
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+
+	timestamp := strconv.FormatInt(auth.clock.Now().Unix(), 10)
+	encodedNonce := hex.EncodeToString(nonce)
+	digest := bodyDigest(body)
+
+	header.Set(agent.HTTPClusterTimestampHeaderName, timestamp)
+	header.Set(agent.HTTPClusterNonceHeaderName, encodedNonce)
+	header.Set(agent.HTTPClusterBodyDigestHeaderName, digest)
+	header.Set(agent.HTTPClusterSignatureHeaderName, auth.signature(method, requestURI, targetNode, timestamp, encodedNonce, digest))
+}
+
+// SignRequest signs a request forwarded to targetNode like SignHeader. The body of the request is read to be signed,
+// it is replaced with a copy.
+func (auth *ClusterAuth) SignRequest(r *http.Request, targetNode string) error {
+	if auth == nil || auth.key == nil {
+		return nil
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		return fmt.Errorf("unable to read the body of the forwarded request: %w", err)
+	}
+
+	auth.SignHeader(r.Header, r.Method, r.URL.RequestURI(), targetNode, body)
+
+	return nil
+}
+
+// SetMembers sets the members of the cluster, the requests sent from their address are verified as forwarded requests
+func (auth *ClusterAuth) SetMembers(members func() []agent.ClusterMember) {
+	auth.members = members
+}
+
+// IsForwarded returns true when the request is forwarded by another agent of the cluster: it carries any of the cluster
+// headers, presents a client certificate or is sent from the address of a cluster member. The forwarded requests
+// stripped of their cluster headers are still verified, and rejected.
+func (auth *ClusterAuth) IsForwarded(r *http.Request) bool {
+	for _, name := range []string{
+		agent.HTTPClusterSignatureHeaderName,
+		agent.HTTPClusterTimestampHeaderName,
+		agent.HTTPClusterNonceHeaderName,
+		agent.HTTPClusterBodyDigestHeaderName,
+	} {
+		if r.Header.Get(name) != "" {
+			return true
+		}
+	}
+
+	// Portainer does not present a client certificate
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return true
+	}
+
+	if auth.members == nil {
+		return false
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	for _, member := range auth.members() {
+		if member.IPAddress == host {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Verify checks the signature, timestamp and nonce of a request forwarded by another agent and,
+// with mutual TLS, that the agent presented a certificate issued by the cluster CA
+func (auth *ClusterAuth) Verify(r *http.Request) error {
+	if auth.MutualTLS() && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+		return errors.New("the forwarding agent did not present a valid certificate")
+	}
+
+	if auth.key == nil {
+		return nil
+	}
+
+	timestamp := r.Header.Get(agent.HTTPClusterTimestampHeaderName)
+	nonce := r.Header.Get(agent.HTTPClusterNonceHeaderName)
+	digest := r.Header.Get(agent.HTTPClusterBodyDigestHeaderName)
+	signature := r.Header.Get(agent.HTTPClusterSignatureHeaderName)
+
+	expected := auth.signature(r.Method, r.RequestURI, r.Header.Get(agent.HTTPTargetHeaderName), timestamp, nonce, digest)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return errors.New("invalid forwarded request signature")
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid forwarded request timestamp")
+	}
+
+	now := auth.clock.Now()
+
+	signedAt := time.Unix(unix, 0)
+	if signedAt.Before(now.Add(-clusterSignatureMaxSkew)) || signedAt.After(now.Add(clusterSignatureMaxSkew)) {
+		return errors.New("the forwarded request signature expired")
+	}
+
+	auth.mu.Lock()
+	defer auth.mu.Unlock()
+
+	// The nonces are kept as long as their timestamp is accepted
+	for seen, expiresAt := range auth.nonces {
+		if now.After(expiresAt) {
+			delete(auth.nonces, seen)
+		}
+	}
+
+	if _, ok := auth.nonces[nonce]; ok {
+		return errors.New("the forwarded request was replayed")
+	}
+
+	auth.nonces[nonce] = signedAt.Add(clusterSignatureMaxSkew)
+
+	return nil
+}
+
+// VerifyBody checks that the body of a request forwarded by another agent matches the digest it was signed with, the
+// signature of the request must be verified first. The body is read, it is replaced with a copy.
+func (auth *ClusterAuth) VerifyBody(r *http.Request) error {
+	if auth.key == nil {
+		return nil
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		return fmt.Errorf("unable to read the body of the forwarded request: %w", err)
+	}
+
+	if !hmac.Equal([]byte(r.Header.Get(agent.HTTPClusterBodyDigestHeaderName)), []byte(bodyDigest(body))) {
+		return errors.New("the body of the forwarded request does not match its signature")
+	}
+
+	return nil
+}
+
+func (auth *ClusterAuth) signature(method, requestURI, targetNode, timestamp, nonce, digest string) string {
+	mac := hmac.New(sha256.New, auth.key)
+	for _, part := range []string{method, requestURI, targetNode, timestamp, nonce, digest} {
+		mac.Write([]byte(part))
+		mac.Write([]byte{'\n'})
+	}
+
+	return base64.RawStdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func bodyDigest(body []byte) string {
+	digest := sha256.Sum256(body)
+
+	return hex.EncodeToString(digest[:])
+}
+
+// readBody reads the body of the request and replaces it with a copy
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+
+	return body, nil
+}
@@ -0,0 +1,171 @@
+package security
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/portainer/agent"
+	"github.com/portainer/agent/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func forwardedRequest(auth *ClusterAuth, method, requestURI, targetNode string) *http.Request {
+	return forwardedRequestBody(auth, method, requestURI, targetNode, "")
+}
+
+func forwardedRequestBody(auth *ClusterAuth, method, requestURI, targetNode, body string) *http.Request {
+	r := httptest.NewRequest(method, requestURI, strings.NewReader(body))
+	r.Header.Set(agent.HTTPTargetHeaderName, targetNode)
+	auth.SignRequest(r, targetNode)
+
+	return r
+}
+
+func TestClusterAuth_Verify(t *testing.T) {
+	auth, err := NewClusterAuth(ClusterAuthConfig{Secret: "secret"})
+	require.NoError(t, err)
+
+	fakeClock := clock.NewFakeClock(time.Unix(1000, 0))
+	auth.clock = fakeClock
+
+	r := forwardedRequest(auth, http.MethodGet, "/containers/json?all=1", "node-2")
+	assert.True(t, auth.IsForwarded(r))
+	assert.NoError(t, auth.Verify(r))
+
+	// Replayed requests are rejected
+	assert.Error(t, auth.Verify(r))
+
+	// Requests signed by another cluster are rejected
+	other, err := NewClusterAuth(ClusterAuthConfig{Secret: "other"})
+	require.NoError(t, err)
+	assert.Error(t, auth.Verify(forwardedRequest(other, http.MethodGet, "/containers/json", "node-2")))
+
+	// Requests redirected to another node or path are rejected
+	r = forwardedRequest(auth, http.MethodGet, "/containers/json", "node-2")
+	r.Header.Set(agent.HTTPTargetHeaderName, "node-3")
+	assert.Error(t, auth.Verify(r))
+
+	r = forwardedRequest(auth, http.MethodPost, "/containers/abc/stop", "node-2")
+	r.RequestURI = "/containers/abc/kill"
+	assert.Error(t, auth.Verify(r))
+
+	// Expired requests are rejected
+	r = forwardedRequest(auth, http.MethodGet, "/info", "node-2")
+	fakeClock.Advance(time.Minute)
+	assert.Error(t, auth.Verify(r))
+}
+
+func TestClusterAuth_VerifyBody(t *testing.T) {
+	auth, err := NewClusterAuth(ClusterAuthConfig{Secret: "secret"})
+	require.NoError(t, err)
+
+	r := forwardedRequestBody(auth, http.MethodPost, "/stacks", "node-2", `{"name":"web"}`)
+	require.NoError(t, auth.Verify(r))
+	require.NoError(t, auth.VerifyBody(r))
+
+	// The body is still readable by the handler
+	body, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Equal(t, `{"name":"web"}`, string(body))
+
+	// Requests whose body was replaced are rejected
+	r = forwardedRequestBody(auth, http.MethodPost, "/stacks", "node-2", `{"name":"web"}`)
+	r.Body = io.NopCloser(strings.NewReader(`{"name":"evil"}`))
+	assert.NoError(t, auth.Verify(r))
+	assert.Error(t, auth.VerifyBody(r))
+
+	// The notary rejects them before they reach the handler
+	handler := NewNotaryService(nil, auth, false).DigitalSignatureVerification(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		t.Error("the tampered request reached the handler")
+	}))
+
+	r = forwardedRequestBody(auth, http.MethodPost, "/stacks", "node-2", `{"name":"web"}`)
+	r.Body = io.NopCloser(strings.NewReader(`{"name":"evil"}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	// Requests whose body and digest were replaced are rejected
+	tampered := forwardedRequestBody(auth, http.MethodPost, "/stacks", "node-2", `{"name":"evil"}`)
+	r = forwardedRequestBody(auth, http.MethodPost, "/stacks", "node-2", `{"name":"web"}`)
+	r.Body = tampered.Body
+	r.Header.Set(agent.HTTPClusterBodyDigestHeaderName, tampered.Header.Get(agent.HTTPClusterBodyDigestHeaderName))
+	assert.Error(t, auth.Verify(r))
+}
+
+func TestNotaryService_forwarded(t *testing.T) {
+	members := func() []agent.ClusterMember {
+		return []agent.ClusterMember{{IPAddress: "10.0.0.2", NodeName: "node-2"}}
+	}
+
+	serve := func(auth *ClusterAuth, r *http.Request) int {
+		handler := NewNotaryService(nil, auth, false).DigitalSignatureVerification(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {}))
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+
+		return rec.Code
+	}
+
+	t.Run("Stripped forwarded request", func(t *testing.T) {
+		auth, err := NewClusterAuth(ClusterAuthConfig{Secret: "secret"})
+		require.NoError(t, err)
+		auth.SetMembers(members)
+
+		r := forwardedRequest(auth, http.MethodPost, "/containers/abc/stop", "node-2")
+		r.RemoteAddr = "10.0.0.2:41000"
+		assert.Equal(t, http.StatusOK, serve(auth, r))
+
+		// The cluster headers of a captured request are removed before it is replayed
+		r = forwardedRequest(auth, http.MethodPost, "/containers/abc/stop", "node-2")
+		r.RemoteAddr = "10.0.0.2:41000"
+		for _, name := range []string{
+			agent.HTTPClusterSignatureHeaderName,
+			agent.HTTPClusterTimestampHeaderName,
+			agent.HTTPClusterNonceHeaderName,
+			agent.HTTPClusterBodyDigestHeaderName,
+		} {
+			r.Header.Del(name)
+		}
+		assert.Equal(t, http.StatusForbidden, serve(auth, r))
+
+		// Portainer is not a member of the cluster
+		r = httptest.NewRequest(http.MethodPost, "/containers/abc/stop", nil)
+		r.RemoteAddr = "10.0.0.9:41000"
+		assert.Equal(t, http.StatusOK, serve(auth, r))
+	})
+
+	t.Run("Mutual TLS only", func(t *testing.T) {
+		auth := &ClusterAuth{certificate: &tls.Certificate{}, caPool: x509.NewCertPool(), clock: clock.NewSystemClock(), nonces: make(map[string]time.Time)}
+		auth.SetMembers(members)
+
+		// The forwarding agents do not sign the requests without a shared secret
+		r := forwardedRequest(auth, http.MethodGet, "/containers/json", "node-2")
+		r.RemoteAddr = "10.0.0.2:41000"
+		assert.Equal(t, http.StatusForbidden, serve(auth, r))
+
+		r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{}}}
+		r.RemoteAddr = "10.0.0.9:41000"
+		assert.Equal(t, http.StatusForbidden, serve(auth, r))
+
+		r.TLS.VerifiedChains = [][]*x509.Certificate{{{}}}
+		assert.Equal(t, http.StatusOK, serve(auth, r))
+	})
+}
+
+func TestNewClusterAuth(t *testing.T) {
+	auth, err := NewClusterAuth(ClusterAuthConfig{})
+	require.NoError(t, err)
+	assert.Nil(t, auth)
+	assert.False(t, auth.MutualTLS())
+
+	_, err = NewClusterAuth(ClusterAuthConfig{CACertPath: "/ca.pem"})
+	assert.Error(t, err)
+}
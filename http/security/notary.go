@@ -10,18 +10,30 @@ import (
 
 type NotaryService struct {
 	signatureService      agent.DigitalSignatureService
+	clusterAuth           *ClusterAuth
 	signatureVerification bool
 }
 
-func NewNotaryService(signatureService agent.DigitalSignatureService, signatureVerification bool) *NotaryService {
+func NewNotaryService(signatureService agent.DigitalSignatureService, clusterAuth *ClusterAuth, signatureVerification bool) *NotaryService {
 	return &NotaryService{
 		signatureVerification: signatureVerification,
 		signatureService:      signatureService,
+		clusterAuth:           clusterAuth,
 	}
 }
 
 func (service *NotaryService) DigitalSignatureVerification(next http.Handler) http.Handler {
 	return httperror.LoggerHandler(func(rw http.ResponseWriter, r *http.Request) *httperror.HandlerError {
+		if service.clusterAuth != nil && service.clusterAuth.IsForwarded(r) {
+			if err := service.clusterAuth.Verify(r); err != nil {
+				return httperror.Forbidden("Invalid forwarded request", err)
+			}
+
+			if err := service.clusterAuth.VerifyBody(r); err != nil {
+				return httperror.Forbidden("Invalid forwarded request", err)
+			}
+		}
+
 		if service.signatureVerification {
 			publicKeyHeaderValue := r.Header.Get(agent.HTTPPublicKeyHeaderName)
 			signatureHeaderValue := r.Header.Get(agent.HTTPSignatureHeaderName)
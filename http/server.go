@@ -11,6 +11,7 @@ import (
 	"github.com/portainer/agent/edge"
 	"github.com/portainer/agent/exec"
 	"github.com/portainer/agent/http/handler"
+	"github.com/portainer/agent/http/security"
 	"github.com/portainer/agent/kubernetes"
 	httpError "github.com/portainer/portainer/pkg/libhttp/error"
 
@@ -24,6 +25,7 @@ type APIServer struct {
 	systemService      agent.SystemService
 	clusterService     agent.ClusterService
 	signatureService   agent.DigitalSignatureService
+	clusterAuth        *security.ClusterAuth
 	edgeManager        *edge.Manager
 	agentTags          *agent.RuntimeConfiguration
 	agentOptions       *agent.Options
@@ -41,6 +43,7 @@ type APIServerConfig struct {
 	SystemService        agent.SystemService
 	ClusterService       agent.ClusterService
 	SignatureService     agent.DigitalSignatureService
+	ClusterAuth          *security.ClusterAuth
 	EdgeManager          *edge.Manager
 	KubeClient           *kubernetes.KubeClient
 	KubernetesDeployer   *exec.KubernetesDeployer
@@ -58,6 +61,7 @@ func NewAPIServer(config *APIServerConfig) *APIServer {
 		systemService:      config.SystemService,
 		clusterService:     config.ClusterService,
 		signatureService:   config.SignatureService,
+		clusterAuth:        config.ClusterAuth,
 		edgeManager:        config.EdgeManager,
 		agentTags:          config.RuntimeConfiguration,
 		agentOptions:       config.AgentOptions,
@@ -74,6 +78,7 @@ func (server *APIServer) Start(edgeMode bool) error {
 		SystemService:        server.systemService,
 		ClusterService:       server.clusterService,
 		SignatureService:     server.signatureService,
+		ClusterAuth:          server.clusterAuth,
 		RuntimeConfiguration: server.agentTags,
 		EdgeManager:          server.edgeManager,
 		KubeClient:           server.kubeClient,
@@ -104,9 +109,15 @@ func (server *APIServer) Start(edgeMode bool) error {
 	}
 
 	httpServer.TLSConfig = crypto.CreateTLSConfiguration()
+	server.clusterAuth.ServerTLSConfig(httpServer.TLSConfig)
 
 	go server.securityShutdown(httpServer)
 
+	// The cluster certificate is presented instead of the generated one
+	if server.clusterAuth.MutualTLS() {
+		return httpServer.ListenAndServeTLS("", "")
+	}
+
 	return httpServer.ListenAndServeTLS(agent.TLSCertPath, agent.TLSKeyPath)
 }
 
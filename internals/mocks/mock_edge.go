@@ -16,7 +16,6 @@ import (
 	agent "github.com/portainer/agent"
 	client "github.com/portainer/agent/edge/client"
 	portainer "github.com/portainer/portainer/api"
-	edge "github.com/portainer/portainer/api/edge"
 	gomock "go.uber.org/mock/gomock"
 )
 
@@ -73,10 +72,10 @@ func (mr *MockPortainerClientMockRecorder) GetEdgeConfig(id any) *gomock.Call {
 }
 
 // GetEdgeStackConfig mocks base method.
-func (m *MockPortainerClient) GetEdgeStackConfig(edgeStackID int, version *int) (*edge.StackPayload, error) {
+func (m *MockPortainerClient) GetEdgeStackConfig(edgeStackID int, version *int) (*client.StackPayload, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "GetEdgeStackConfig", edgeStackID, version)
-	ret0, _ := ret[0].(*edge.StackPayload)
+	ret0, _ := ret[0].(*client.StackPayload)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
@@ -121,6 +120,20 @@ func (mr *MockPortainerClientMockRecorder) GetEnvironmentStatus(flags ...any) *g
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetEnvironmentStatus", reflect.TypeOf((*MockPortainerClient)(nil).GetEnvironmentStatus), flags...)
 }
 
+// SetBackupResult mocks base method.
+func (m *MockPortainerClient) SetBackupResult(backupID int, result client.BackupResult) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetBackupResult", backupID, result)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetBackupResult indicates an expected call of SetBackupResult.
+func (mr *MockPortainerClientMockRecorder) SetBackupResult(backupID, result any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetBackupResult", reflect.TypeOf((*MockPortainerClient)(nil).SetBackupResult), backupID, result)
+}
+
 // SetEdgeConfigState mocks base method.
 func (m *MockPortainerClient) SetEdgeConfigState(id client.EdgeConfigID, state client.EdgeConfigStateType) error {
 	m.ctrl.T.Helper()
@@ -149,6 +162,188 @@ func (mr *MockPortainerClientMockRecorder) SetEdgeJobStatus(edgeJobStatus any) *
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetEdgeJobStatus", reflect.TypeOf((*MockPortainerClient)(nil).SetEdgeJobStatus), edgeJobStatus)
 }
 
+// SetEdgeJobFailure mocks base method.
+func (m *MockPortainerClient) SetEdgeJobFailure(execution agent.EdgeJobExecution) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetEdgeJobFailure", execution)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetEdgeJobFailure indicates an expected call of SetEdgeJobFailure.
+func (mr *MockPortainerClientMockRecorder) SetEdgeJobFailure(execution any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetEdgeJobFailure", reflect.TypeOf((*MockPortainerClient)(nil).SetEdgeJobFailure), execution)
+}
+
+// SetEdgeJobHistory mocks base method.
+func (m *MockPortainerClient) SetEdgeJobHistory(edgeJobID int, executions []agent.EdgeJobExecution) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetEdgeJobHistory", edgeJobID, executions)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetEdgeJobHistory indicates an expected call of SetEdgeJobHistory.
+func (mr *MockPortainerClientMockRecorder) SetEdgeJobHistory(edgeJobID, executions any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetEdgeJobHistory", reflect.TypeOf((*MockPortainerClient)(nil).SetEdgeJobHistory), edgeJobID, executions)
+}
+
+// SetEdgeStackBatchStatus mocks base method.
+func (m *MockPortainerClient) SetEdgeStackBatchStatus(batchID int, status client.StackBatchStatus) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetEdgeStackBatchStatus", batchID, status)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetEdgeStackBatchStatus indicates an expected call of SetEdgeStackBatchStatus.
+func (mr *MockPortainerClientMockRecorder) SetEdgeStackBatchStatus(batchID, status any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetEdgeStackBatchStatus", reflect.TypeOf((*MockPortainerClient)(nil).SetEdgeStackBatchStatus), batchID, status)
+}
+
+// SetEdgeStackJobResult mocks base method.
+func (m *MockPortainerClient) SetEdgeStackJobResult(edgeStackID int, result client.StackJobResult) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetEdgeStackJobResult", edgeStackID, result)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetEdgeStackJobResult indicates an expected call of SetEdgeStackJobResult.
+func (mr *MockPortainerClientMockRecorder) SetEdgeStackJobResult(edgeStackID, result any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetEdgeStackJobResult", reflect.TypeOf((*MockPortainerClient)(nil).SetEdgeStackJobResult), edgeStackID, result)
+}
+
+// SetEdgeStackServicesStatus mocks base method.
+func (m *MockPortainerClient) SetEdgeStackServicesStatus(edgeStackID int, services map[string]client.StackServiceStatus) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetEdgeStackServicesStatus", edgeStackID, services)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetEdgeStackServicesStatus indicates an expected call of SetEdgeStackServicesStatus.
+func (mr *MockPortainerClientMockRecorder) SetEdgeStackServicesStatus(edgeStackID, services any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetEdgeStackServicesStatus", reflect.TypeOf((*MockPortainerClient)(nil).SetEdgeStackServicesStatus), edgeStackID, services)
+}
+
+// SetEdgeStackUsage mocks base method.
+func (m *MockPortainerClient) SetEdgeStackUsage(edgeStackID int, usage client.StackUsage) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetEdgeStackUsage", edgeStackID, usage)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetEdgeStackUsage indicates an expected call of SetEdgeStackUsage.
+func (mr *MockPortainerClientMockRecorder) SetEdgeStackUsage(edgeStackID, usage any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetEdgeStackUsage", reflect.TypeOf((*MockPortainerClient)(nil).SetEdgeStackUsage), edgeStackID, usage)
+}
+
+// SetEdgeStackChangelog mocks base method.
+func (m *MockPortainerClient) SetEdgeStackChangelog(edgeStackID int, changelog client.StackChangelog) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetEdgeStackChangelog", edgeStackID, changelog)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetEdgeStackChangelog indicates an expected call of SetEdgeStackChangelog.
+func (mr *MockPortainerClientMockRecorder) SetEdgeStackChangelog(edgeStackID, changelog any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetEdgeStackChangelog", reflect.TypeOf((*MockPortainerClient)(nil).SetEdgeStackChangelog), edgeStackID, changelog)
+}
+
+// SetEdgeStackRemediation mocks base method.
+func (m *MockPortainerClient) SetEdgeStackRemediation(edgeStackID int, remediation client.StackRemediation) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetEdgeStackRemediation", edgeStackID, remediation)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetEdgeStackRemediation indicates an expected call of SetEdgeStackRemediation.
+func (mr *MockPortainerClientMockRecorder) SetEdgeStackRemediation(edgeStackID, remediation any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetEdgeStackRemediation", reflect.TypeOf((*MockPortainerClient)(nil).SetEdgeStackRemediation), edgeStackID, remediation)
+}
+
+// SetEdgeStackConfigHash mocks base method.
+func (m *MockPortainerClient) SetEdgeStackConfigHash(edgeStackID int, hash client.StackConfigHash) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetEdgeStackConfigHash", edgeStackID, hash)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetEdgeStackConfigHash indicates an expected call of SetEdgeStackConfigHash.
+func (mr *MockPortainerClientMockRecorder) SetEdgeStackConfigHash(edgeStackID, hash any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetEdgeStackConfigHash", reflect.TypeOf((*MockPortainerClient)(nil).SetEdgeStackConfigHash), edgeStackID, hash)
+}
+
+// SetEdgeStackError mocks base method.
+func (m *MockPortainerClient) SetEdgeStackError(edgeStackID int, rollbackTo *int, errMessage, errorClass string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetEdgeStackError", edgeStackID, rollbackTo, errMessage, errorClass)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetEdgeStackError indicates an expected call of SetEdgeStackError.
+func (mr *MockPortainerClientMockRecorder) SetEdgeStackError(edgeStackID, rollbackTo, errMessage, errorClass any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetEdgeStackError", reflect.TypeOf((*MockPortainerClient)(nil).SetEdgeStackError), edgeStackID, rollbackTo, errMessage, errorClass)
+}
+
+// SetEdgeStackInventory mocks base method.
+func (m *MockPortainerClient) SetEdgeStackInventory(stacks []client.DeployedStack) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetEdgeStackInventory", stacks)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetEdgeStackInventory indicates an expected call of SetEdgeStackInventory.
+func (mr *MockPortainerClientMockRecorder) SetEdgeStackInventory(stacks any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetEdgeStackInventory", reflect.TypeOf((*MockPortainerClient)(nil).SetEdgeStackInventory), stacks)
+}
+
+// SetEdgeStackPullProgress mocks base method.
+func (m *MockPortainerClient) SetEdgeStackPullProgress(edgeStackID int, progress client.StackPullProgress) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetEdgeStackPullProgress", edgeStackID, progress)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetEdgeStackPullProgress indicates an expected call of SetEdgeStackPullProgress.
+func (mr *MockPortainerClientMockRecorder) SetEdgeStackPullProgress(edgeStackID, progress any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetEdgeStackPullProgress", reflect.TypeOf((*MockPortainerClient)(nil).SetEdgeStackPullProgress), edgeStackID, progress)
+}
+
+// SetEdgeStackStaged mocks base method.
+func (m *MockPortainerClient) SetEdgeStackStaged(edgeStackID int, staged client.StackStaged) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetEdgeStackStaged", edgeStackID, staged)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetEdgeStackStaged indicates an expected call of SetEdgeStackStaged.
+func (mr *MockPortainerClientMockRecorder) SetEdgeStackStaged(edgeStackID, staged any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetEdgeStackStaged", reflect.TypeOf((*MockPortainerClient)(nil).SetEdgeStackStaged), edgeStackID, staged)
+}
+
 // SetEdgeStackStatus mocks base method.
 func (m *MockPortainerClient) SetEdgeStackStatus(edgeStackID int, edgeStackStatus portainer.EdgeStackStatusType, rollbackTo *int, errMessage string) error {
 	m.ctrl.T.Helper()
@@ -163,6 +358,62 @@ func (mr *MockPortainerClientMockRecorder) SetEdgeStackStatus(edgeStackID, edgeS
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetEdgeStackStatus", reflect.TypeOf((*MockPortainerClient)(nil).SetEdgeStackStatus), edgeStackID, edgeStackStatus, rollbackTo, errMessage)
 }
 
+// SetStackCommandResult mocks base method.
+func (m *MockPortainerClient) SetStackCommandResult(commandID int, result client.StackCommandResult) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetStackCommandResult", commandID, result)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetStackCommandResult indicates an expected call of SetStackCommandResult.
+func (mr *MockPortainerClientMockRecorder) SetStackCommandResult(commandID, result any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetStackCommandResult", reflect.TypeOf((*MockPortainerClient)(nil).SetStackCommandResult), commandID, result)
+}
+
+// SetFileTransferResult mocks base method.
+func (m *MockPortainerClient) SetFileTransferResult(transferID int, result client.FileTransferResult) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetFileTransferResult", transferID, result)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetFileTransferResult indicates an expected call of SetFileTransferResult.
+func (mr *MockPortainerClientMockRecorder) SetFileTransferResult(transferID, result any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetFileTransferResult", reflect.TypeOf((*MockPortainerClient)(nil).SetFileTransferResult), transferID, result)
+}
+
+// SetHostInfo mocks base method.
+func (m *MockPortainerClient) SetHostInfo(info client.HostInfo) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetHostInfo", info)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetHostInfo indicates an expected call of SetHostInfo.
+func (mr *MockPortainerClientMockRecorder) SetHostInfo(info any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetHostInfo", reflect.TypeOf((*MockPortainerClient)(nil).SetHostInfo), info)
+}
+
+// SetLabels mocks base method.
+func (m *MockPortainerClient) SetLabels(labels map[string]string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetLabels", labels)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetLabels indicates an expected call of SetLabels.
+func (mr *MockPortainerClientMockRecorder) SetLabels(labels any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetLabels", reflect.TypeOf((*MockPortainerClient)(nil).SetLabels), labels)
+}
+
 // SetLastCommandTimestamp mocks base method.
 func (m *MockPortainerClient) SetLastCommandTimestamp(timestamp time.Time) {
 	m.ctrl.T.Helper()
@@ -175,6 +426,20 @@ func (mr *MockPortainerClientMockRecorder) SetLastCommandTimestamp(timestamp any
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetLastCommandTimestamp", reflect.TypeOf((*MockPortainerClient)(nil).SetLastCommandTimestamp), timestamp)
 }
 
+// SetPowerStatus mocks base method.
+func (m *MockPortainerClient) SetPowerStatus(status client.PowerStatus) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetPowerStatus", status)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetPowerStatus indicates an expected call of SetPowerStatus.
+func (mr *MockPortainerClientMockRecorder) SetPowerStatus(status any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetPowerStatus", reflect.TypeOf((*MockPortainerClient)(nil).SetPowerStatus), status)
+}
+
 // SetTimeout mocks base method.
 func (m *MockPortainerClient) SetTimeout(t time.Duration) {
 	m.ctrl.T.Helper()
@@ -85,7 +85,25 @@ func (du *DockerUpdaterCleaner) UpdateID() int {
 	return du.updateID
 }
 
-func updateAgentInfoIfNeeds(ctx context.Context, updateID int) error {
+// DockerCanary keeps the previous agent container until the upgraded agent passed its health checks, it is then
+// removed on promotion or started again on rollback
+type DockerCanary struct {
+	*DockerUpdaterCleaner
+}
+
+func NewDockerCanary(updateID int) *DockerCanary {
+	return &DockerCanary{
+		DockerUpdaterCleaner: &DockerUpdaterCleaner{updateID: updateID},
+	}
+}
+
+// Promote removes the previous agent container and gives its name to the upgraded agent container
+func (canary *DockerCanary) Promote(ctx context.Context) error {
+	return updateAgentInfoIfNeeds(ctx, canary.updateID)
+}
+
+// Rollback starts the previous agent container again, then removes the upgraded agent container, which stops this agent
+func (canary *DockerCanary) Rollback(ctx context.Context) error {
 	cli, err := docker.NewClient()
 	if err != nil {
 		return err
@@ -97,22 +115,40 @@ func updateAgentInfoIfNeeds(ctx context.Context, updateID int) error {
 		return fmt.Errorf("unable to list containers. Error: %w", err)
 	}
 
-	var (
-		oldAgentContainer *types.Container
-		newAgentContainer *types.Container
-		oldContainerName  string
-	)
-	for i, container := range containers {
-		if container.Labels != nil && container.Labels["io.portainer.update.scheduleId"] == strconv.Itoa(updateID) {
-			newAgentContainer = containers[i]
-			continue
-		}
+	oldAgentContainer, newAgentContainer, _ := splitAgentContainers(containers, canary.updateID)
+	if oldAgentContainer == nil {
+		return errors.New("the previous agent container was not found, unable to roll back")
+	}
 
-		oldAgentContainer = containers[i]
-		if len(oldAgentContainer.Names) > 0 {
-			oldContainerName = strings.TrimPrefix(oldAgentContainer.Names[0], "/")
-		}
+	if err := cli.ContainerStart(ctx, oldAgentContainer.ID, container.StartOptions{}); err != nil {
+		return fmt.Errorf("unable to start the previous agent container: %w", err)
+	}
+
+	log.Info().
+		Str("old_agent_container_id", oldAgentContainer.ID).
+		Str("context", "RollbackUpdatedAgent").
+		Msg("previous agent container started, removing the updated agent container")
+
+	if newAgentContainer == nil {
+		return errors.New("the updated agent container was not found")
+	}
+
+	return cli.ContainerRemove(ctx, newAgentContainer.ID, container.RemoveOptions{Force: true})
+}
+
+func updateAgentInfoIfNeeds(ctx context.Context, updateID int) error {
+	cli, err := docker.NewClient()
+	if err != nil {
+		return err
 	}
+	defer cli.Close()
+
+	containers, err := getAgentContainerCandicates(ctx, cli)
+	if err != nil {
+		return fmt.Errorf("unable to list containers. Error: %w", err)
+	}
+
+	oldAgentContainer, newAgentContainer, oldContainerName := splitAgentContainers(containers, updateID)
 
 	// Check if the old agent exists
 	if oldAgentContainer != nil {
@@ -167,6 +203,23 @@ func updateAgentInfoIfNeeds(ctx context.Context, updateID int) error {
 	return nil
 }
 
+// splitAgentContainers returns the previous agent container and its name, and the agent container started by the update
+func splitAgentContainers(containers []*types.Container, updateID int) (oldAgentContainer, newAgentContainer *types.Container, oldContainerName string) {
+	for i, container := range containers {
+		if container.Labels != nil && container.Labels["io.portainer.update.scheduleId"] == strconv.Itoa(updateID) {
+			newAgentContainer = containers[i]
+			continue
+		}
+
+		oldAgentContainer = containers[i]
+		if len(oldAgentContainer.Names) > 0 {
+			oldContainerName = strings.TrimPrefix(oldAgentContainer.Names[0], "/")
+		}
+	}
+
+	return oldAgentContainer, newAgentContainer, oldContainerName
+}
+
 func tryRemoveOldContainer(ctx context.Context, dockerCli *client.Client, oldContainerId string) error {
 	log.Debug().
 		Str("containerId", oldContainerId).
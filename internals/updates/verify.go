@@ -0,0 +1,84 @@
+package updates
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// verifyInterval is the delay between two runs of the health checks failing
+const verifyInterval = 10 * time.Second
+
+// Check is a health check of the updated agent
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// Canary is an updated agent running next to the previous one until its health checks passed
+type Canary interface {
+	Promote(ctx context.Context) error
+	Rollback(ctx context.Context) error
+}
+
+// VerifyUpgrade promotes the updated agent once each of the checks passed, and rolls back to the previous agent when
+// some of them did not pass within the window
+func VerifyUpgrade(ctx context.Context, canary Canary, checks []Check, window time.Duration) error {
+	log.Info().Dur("window", window).Int("checks", len(checks)).Msg("verifying the updated agent")
+
+	err := verify(ctx, checks, window, verifyInterval)
+	if err == nil {
+		log.Info().Msg("the updated agent passed its health checks")
+
+		return canary.Promote(ctx)
+	}
+
+	log.Error().Err(err).Msg("the updated agent failed its health checks, rolling back to the previous agent")
+
+	if err := canary.Rollback(ctx); err != nil {
+		log.Error().Err(err).Msg("unable to roll back to the previous agent")
+
+		return err
+	}
+
+	return nil
+}
+
+// verify runs the checks until each of them passed once, or returns the errors of the ones failing at the end of the window
+func verify(ctx context.Context, checks []Check, window, interval time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, window)
+	defer cancel()
+
+	pending := checks
+
+	for {
+		var failing []Check
+		var errs []error
+
+		for _, check := range pending {
+			if err := check.Run(ctx); err != nil {
+				failing = append(failing, check)
+				errs = append(errs, fmt.Errorf("%s: %w", check.Name, err))
+
+				continue
+			}
+
+			log.Debug().Str("check", check.Name).Msg("health check of the updated agent passed")
+		}
+
+		if len(failing) == 0 {
+			return nil
+		}
+
+		pending = failing
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("health checks failed within %s: %w", window, errors.Join(errs...))
+		case <-time.After(interval):
+		}
+	}
+}
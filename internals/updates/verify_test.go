@@ -0,0 +1,74 @@
+package updates
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeCanary struct {
+	promoted   bool
+	rolledBack bool
+}
+
+func (canary *fakeCanary) Promote(ctx context.Context) error {
+	canary.promoted = true
+
+	return nil
+}
+
+func (canary *fakeCanary) Rollback(ctx context.Context) error {
+	canary.rolledBack = true
+
+	return nil
+}
+
+func TestVerify(t *testing.T) {
+	attempts := 0
+	poll := Check{Name: "poll", Run: func(ctx context.Context) error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("no successful poll yet")
+		}
+
+		return nil
+	}}
+
+	runs := 0
+	deployer := Check{Name: "deployer", Run: func(ctx context.Context) error {
+		runs++
+
+		return nil
+	}}
+
+	assert.NoError(t, verify(context.Background(), []Check{poll, deployer}, time.Second, time.Millisecond))
+	assert.Equal(t, 3, attempts)
+	// The checks passing are not run again
+	assert.Equal(t, 1, runs)
+
+	tunnel := Check{Name: "tunnel", Run: func(ctx context.Context) error {
+		return errors.New("connection refused")
+	}}
+
+	err := verify(context.Background(), []Check{deployer, tunnel}, 20*time.Millisecond, time.Millisecond)
+	assert.ErrorContains(t, err, "tunnel: connection refused")
+	assert.NotContains(t, err.Error(), "deployer")
+}
+
+func TestVerifyUpgrade(t *testing.T) {
+	passing := Check{Name: "poll", Run: func(ctx context.Context) error { return nil }}
+	failing := Check{Name: "tunnel", Run: func(ctx context.Context) error { return errors.New("connection refused") }}
+
+	canary := &fakeCanary{}
+	assert.NoError(t, VerifyUpgrade(context.Background(), canary, []Check{passing}, time.Second))
+	assert.True(t, canary.promoted)
+	assert.False(t, canary.rolledBack)
+
+	canary = &fakeCanary{}
+	assert.NoError(t, VerifyUpgrade(context.Background(), canary, []Check{passing, failing}, 10*time.Millisecond))
+	assert.False(t, canary.promoted)
+	assert.True(t, canary.rolledBack)
+}
@@ -7,7 +7,6 @@ import (
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
-	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/remotecommand"
 	utilexec "k8s.io/client-go/util/exec"
 )
@@ -31,7 +30,7 @@ func NewKubeClient() (*KubeClient, error) {
 }
 
 func buildLocalClient() (*kubernetes.Clientset, error) {
-	config, err := rest.InClusterConfig()
+	config, err := restConfig()
 	if err != nil {
 		return nil, err
 	}
@@ -42,9 +41,9 @@ func buildLocalClient() (*kubernetes.Clientset, error) {
 // StartExecProcess will start an exec process inside a container located inside a pod inside a specific namespace
 // using the specified command. The stdin parameter will be bound to the stdin process and the stdout process will write
 // to the stdout parameter.
-// This function only works against a local endpoint using an in-cluster config.
+// This function only works against the cluster managed by the agent, see restConfig.
 func (kcl *KubeClient) StartExecProcess(token, namespace, podName, containerName string, command []string, stdin io.Reader, stdout io.Writer) error {
-	config, err := rest.InClusterConfig()
+	config, err := restConfig()
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,151 @@
+package kubernetes
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// KubeconfigEnvVarName is the environment variable of the kubeconfig file of the cluster managed by an agent
+	// running outside of it, the in-cluster configuration is used when it is not set
+	KubeconfigEnvVarName = "KUBECONFIG"
+
+	inClusterAPIURL = "https://kubernetes.default.svc"
+)
+
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string
+		Cluster struct {
+			Server                   string
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		}
+	}
+	Users []struct {
+		Name string
+		User struct {
+			Token                 string
+			ClientCertificate     string `yaml:"client-certificate"`
+			ClientCertificateData string `yaml:"client-certificate-data"`
+			ClientKey             string `yaml:"client-key"`
+			ClientKeyData         string `yaml:"client-key-data"`
+		}
+	}
+	Contexts []struct {
+		Name    string
+		Context struct {
+			Cluster string
+			User    string
+		}
+	}
+}
+
+// restConfig returns the configuration of the client of the cluster managed by the agent
+func restConfig() (*rest.Config, error) {
+	path := os.Getenv(KubeconfigEnvVarName)
+	if path == "" {
+		return rest.InClusterConfig()
+	}
+
+	return loadKubeconfig(path)
+}
+
+// APIServerURL returns the URL of the API server of the cluster managed by the agent
+func APIServerURL() string {
+	if os.Getenv(KubeconfigEnvVarName) == "" {
+		return inClusterAPIURL
+	}
+
+	config, err := restConfig()
+	if err != nil {
+		return inClusterAPIURL
+	}
+
+	return config.Host
+}
+
+// loadKubeconfig reads the cluster and the credentials of the current context of a kubeconfig file,
+// such as the one written by k3s. Only the server, certificates and token of the context are supported.
+func loadKubeconfig(path string) (*rest.Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file kubeconfig
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid kubeconfig: %w", err)
+	}
+
+	clusterName, userName := "", ""
+	for _, context := range file.Contexts {
+		if context.Name == file.CurrentContext || (file.CurrentContext == "" && len(file.Contexts) == 1) {
+			clusterName, userName = context.Context.Cluster, context.Context.User
+		}
+	}
+
+	if clusterName == "" {
+		return nil, errors.New("invalid kubeconfig: the current context was not found")
+	}
+
+	config := &rest.Config{}
+
+	for _, cluster := range file.Clusters {
+		if cluster.Name != clusterName {
+			continue
+		}
+
+		config.Host = cluster.Cluster.Server
+		config.TLSClientConfig.CAFile = cluster.Cluster.CertificateAuthority
+		config.TLSClientConfig.Insecure = cluster.Cluster.InsecureSkipTLSVerify
+
+		if config.TLSClientConfig.CAData, err = decodeData(cluster.Cluster.CertificateAuthorityData); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Host == "" {
+		return nil, fmt.Errorf("invalid kubeconfig: the cluster %s was not found", clusterName)
+	}
+
+	for _, user := range file.Users {
+		if user.Name != userName {
+			continue
+		}
+
+		config.BearerToken = user.User.Token
+		config.TLSClientConfig.CertFile = user.User.ClientCertificate
+		config.TLSClientConfig.KeyFile = user.User.ClientKey
+
+		if config.TLSClientConfig.CertData, err = decodeData(user.User.ClientCertificateData); err != nil {
+			return nil, err
+		}
+
+		if config.TLSClientConfig.KeyData, err = decodeData(user.User.ClientKeyData); err != nil {
+			return nil, err
+		}
+	}
+
+	return config, nil
+}
+
+func decodeData(data string) ([]byte, error) {
+	if data == "" {
+		return nil, nil
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid kubeconfig: %w", err)
+	}
+
+	return decoded, nil
+}
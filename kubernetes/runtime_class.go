@@ -0,0 +1,27 @@
+package kubernetes
+
+import (
+	"context"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RuntimeClasses returns the names of the RuntimeClasses of the cluster managed by the agent
+func RuntimeClasses() ([]string, error) {
+	cli, err := buildLocalClient()
+	if err != nil {
+		return nil, err
+	}
+
+	runtimeClasses, err := cli.NodeV1().RuntimeClasses().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(runtimeClasses.Items))
+	for _, runtimeClass := range runtimeClasses.Items {
+		names = append(names, runtimeClass.Name)
+	}
+
+	return names, nil
+}
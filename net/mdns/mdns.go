@@ -0,0 +1,291 @@
+package mdns
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// ServiceType is the DNS-SD service type the agent is advertised as
+	ServiceType = "_portainer-agent._tcp"
+
+	domain          = "local."
+	servicesEnum    = "_services._dns-sd._udp." + domain
+	recordTTL       = 120
+	announceCount   = 3
+	announceDelay   = time.Second
+	maxPacketSize   = 9000
+	cacheFlushClass = 1 << 15
+)
+
+var multicastAddr = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// Service describes the agent advertised on the local network
+type Service struct {
+	// Instance is the name of the advertised instance, usually the node name
+	Instance string
+	// Host is the host name the address of the agent is advertised under
+	Host string
+	IP   net.IP
+	Port int
+	// TXT are the key=value pairs attached to the instance
+	TXT []string
+}
+
+// Responder answers the mDNS queries for the agent service on the local network
+// and announces it when started
+type Responder struct {
+	service  Service
+	instance string
+	host     string
+	conn     *net.UDPConn
+	stopOnce sync.Once
+}
+
+// NewResponder returns a pointer to a new instance of Responder
+func NewResponder(service Service) (*Responder, error) {
+	if service.IP.To4() == nil {
+		return nil, errors.New("an IPv4 address is required to advertise the agent")
+	}
+
+	return &Responder{
+		service:  service,
+		instance: dns.Fqdn(sanitizeLabel(service.Instance) + "." + ServiceType + "." + domain),
+		host:     dns.Fqdn(sanitizeLabel(service.Host) + "." + domain),
+	}, nil
+}
+
+// Start joins the mDNS multicast group, announces the service and answers the queries in the background
+func (responder *Responder) Start() error {
+	conn, err := net.ListenMulticastUDP("udp4", nil, multicastAddr)
+	if err != nil {
+		return err
+	}
+
+	responder.conn = conn
+
+	go responder.serve()
+	go responder.announce()
+
+	log.Info().
+		Str("instance", responder.instance).
+		Str("host", responder.host).
+		Int("port", responder.service.Port).
+		Msg("advertising the agent over mDNS")
+
+	return nil
+}
+
+// Stop sends a goodbye for the service and stops answering the queries
+func (responder *Responder) Stop() {
+	responder.stopOnce.Do(func() {
+		if responder.conn == nil {
+			return
+		}
+
+		responder.send(responder.announcement(0), multicastAddr)
+		responder.conn.Close()
+	})
+}
+
+func (responder *Responder) serve() {
+	buf := make([]byte, maxPacketSize)
+
+	for {
+		n, from, err := responder.conn.ReadFromUDP(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Error().Err(err).Msg("unable to read the mDNS query")
+			}
+
+			return
+		}
+
+		var query dns.Msg
+		if err := query.Unpack(buf[:n]); err != nil || query.Response {
+			continue
+		}
+
+		response, unicast := responder.answer(&query)
+		if response == nil {
+			continue
+		}
+
+		// Legacy unicast queries are sent from another port than 5353 and expect a unicast response
+		if unicast || from.Port != multicastAddr.Port {
+			responder.send(response, from)
+
+			continue
+		}
+
+		responder.send(response, multicastAddr)
+	}
+}
+
+func (responder *Responder) announce() {
+	for i := 0; i < announceCount; i++ {
+		if i > 0 {
+			time.Sleep(announceDelay << (i - 1))
+		}
+
+		if !responder.send(responder.announcement(recordTTL), multicastAddr) {
+			return
+		}
+	}
+}
+
+func (responder *Responder) send(msg *dns.Msg, to *net.UDPAddr) bool {
+	data, err := msg.Pack()
+	if err != nil {
+		log.Error().Err(err).Msg("unable to pack the mDNS response")
+
+		return false
+	}
+
+	if _, err := responder.conn.WriteToUDP(data, to); err != nil {
+		if !errors.Is(err, net.ErrClosed) {
+			log.Debug().Err(err).Msg("unable to send the mDNS response")
+		}
+
+		return false
+	}
+
+	return true
+}
+
+// answer returns the response to the query and whether a unicast response was requested,
+// the response is nil when the query is not about the agent service
+func (responder *Responder) answer(query *dns.Msg) (*dns.Msg, bool) {
+	response := new(dns.Msg)
+	response.Response = true
+	response.Authoritative = true
+
+	unicast := false
+
+	for _, question := range query.Question {
+		name := strings.ToLower(question.Name)
+		qtype := question.Qtype
+
+		var answers, extra []dns.RR
+
+		switch {
+		case name == servicesEnum && matchType(qtype, dns.TypePTR):
+			answers = []dns.RR{responder.servicesPTR(recordTTL)}
+		case name == ServiceType+"."+domain && matchType(qtype, dns.TypePTR):
+			answers = []dns.RR{responder.ptr(recordTTL)}
+			extra = []dns.RR{responder.srv(recordTTL), responder.txt(recordTTL), responder.a(recordTTL)}
+		case name == strings.ToLower(responder.instance):
+			if matchType(qtype, dns.TypeSRV) {
+				answers = append(answers, responder.srv(recordTTL))
+				extra = append(extra, responder.a(recordTTL))
+			}
+			if matchType(qtype, dns.TypeTXT) {
+				answers = append(answers, responder.txt(recordTTL))
+			}
+		case name == strings.ToLower(responder.host) && matchType(qtype, dns.TypeA):
+			answers = []dns.RR{responder.a(recordTTL)}
+		}
+
+		if len(answers) == 0 {
+			continue
+		}
+
+		unicast = unicast || question.Qclass&cacheFlushClass != 0
+
+		response.Answer = append(response.Answer, answers...)
+		response.Extra = append(response.Extra, extra...)
+	}
+
+	if len(response.Answer) == 0 {
+		return nil, false
+	}
+
+	// Legacy unicast resolvers expect the identifier and questions of their query
+	response.Id = query.Id
+	response.Question = query.Question
+
+	return response, unicast
+}
+
+// announcement returns the unsolicited response advertising the service, a zero TTL is a goodbye
+func (responder *Responder) announcement(ttl uint32) *dns.Msg {
+	msg := new(dns.Msg)
+	msg.Response = true
+	msg.Authoritative = true
+	msg.Answer = []dns.RR{
+		responder.servicesPTR(ttl),
+		responder.ptr(ttl),
+		responder.srv(ttl),
+		responder.txt(ttl),
+		responder.a(ttl),
+	}
+
+	return msg
+}
+
+func (responder *Responder) servicesPTR(ttl uint32) dns.RR {
+	return &dns.PTR{
+		Hdr: dns.RR_Header{Name: servicesEnum, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: ttl},
+		Ptr: ServiceType + "." + domain,
+	}
+}
+
+func (responder *Responder) ptr(ttl uint32) dns.RR {
+	return &dns.PTR{
+		Hdr: dns.RR_Header{Name: ServiceType + "." + domain, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: ttl},
+		Ptr: responder.instance,
+	}
+}
+
+func (responder *Responder) srv(ttl uint32) dns.RR {
+	return &dns.SRV{
+		Hdr:    dns.RR_Header{Name: responder.instance, Rrtype: dns.TypeSRV, Class: dns.ClassINET | cacheFlushClass, Ttl: ttl},
+		Port:   uint16(responder.service.Port),
+		Target: responder.host,
+	}
+}
+
+func (responder *Responder) txt(ttl uint32) dns.RR {
+	return &dns.TXT{
+		Hdr: dns.RR_Header{Name: responder.instance, Rrtype: dns.TypeTXT, Class: dns.ClassINET | cacheFlushClass, Ttl: ttl},
+		Txt: responder.service.TXT,
+	}
+}
+
+func (responder *Responder) a(ttl uint32) dns.RR {
+	return &dns.A{
+		Hdr: dns.RR_Header{Name: responder.host, Rrtype: dns.TypeA, Class: dns.ClassINET | cacheFlushClass, Ttl: ttl},
+		A:   responder.service.IP.To4(),
+	}
+}
+
+func matchType(qtype, rrtype uint16) bool {
+	return qtype == rrtype || qtype == dns.TypeANY
+}
+
+// sanitizeLabel makes the name usable as a single DNS label
+func sanitizeLabel(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r == '.' || r == ' ' || r == '\\' {
+			return '-'
+		}
+
+		return r
+	}, name)
+
+	if len(name) > 63 {
+		name = name[:63]
+	}
+
+	if name == "" {
+		return "portainer-agent"
+	}
+
+	return name
+}
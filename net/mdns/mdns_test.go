@@ -0,0 +1,63 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestResponder(t *testing.T) *Responder {
+	responder, err := NewResponder(Service{
+		Instance: "node.1",
+		Host:     "node.1",
+		IP:       net.ParseIP("192.168.1.10"),
+		Port:     9001,
+		TXT:      []string{"edge_id=abc"},
+	})
+	require.NoError(t, err)
+
+	return responder
+}
+
+func TestResponder_answer(t *testing.T) {
+	responder := newTestResponder(t)
+
+	query := new(dns.Msg)
+	query.SetQuestion("_portainer-agent._tcp.local.", dns.TypePTR)
+
+	response, unicast := responder.answer(query)
+	require.NotNil(t, response)
+	assert.False(t, unicast)
+	require.Len(t, response.Answer, 1)
+	assert.Equal(t, "node-1._portainer-agent._tcp.local.", response.Answer[0].(*dns.PTR).Ptr)
+	require.Len(t, response.Extra, 3)
+
+	srv := response.Extra[0].(*dns.SRV)
+	assert.Equal(t, uint16(9001), srv.Port)
+	assert.Equal(t, "node-1.local.", srv.Target)
+	assert.Equal(t, []string{"edge_id=abc"}, response.Extra[1].(*dns.TXT).Txt)
+	assert.Equal(t, "192.168.1.10", response.Extra[2].(*dns.A).A.String())
+
+	// Unicast responses are requested with the top bit of the class
+	query.SetQuestion("node-1.local.", dns.TypeA)
+	query.Question[0].Qclass |= cacheFlushClass
+
+	response, unicast = responder.answer(query)
+	require.NotNil(t, response)
+	assert.True(t, unicast)
+	assert.Equal(t, query.Id, response.Id)
+
+	// Other services are ignored
+	query.SetQuestion("_http._tcp.local.", dns.TypePTR)
+
+	response, _ = responder.answer(query)
+	assert.Nil(t, response)
+}
+
+func TestNewResponder_requiresIPv4(t *testing.T) {
+	_, err := NewResponder(Service{Instance: "node", Host: "node", IP: net.ParseIP("::1")})
+	assert.Error(t, err)
+}
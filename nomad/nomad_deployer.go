@@ -52,6 +52,10 @@ func (d *Deployer) Deploy(ctx context.Context, name string, filePaths []string,
 		return errors.Wrap(err, "failed to parse Nomad job file")
 	}
 
+	if options.HostBasePath != "" {
+		rewriteRelativePaths(newJob, options.HostBasePath)
+	}
+
 	// An existing backup file means it is an update action
 	// Need to check if the new coming job file has different region, namespace or id settings
 	// If yes, delete the former job
@@ -0,0 +1,73 @@
+package nomad
+
+import (
+	"path"
+	"strings"
+
+	nomadapi "github.com/hashicorp/nomad/api"
+)
+
+// rewriteRelativePaths resolves the relative artifact sources and bind mounts of the job
+// tasks against basePath, the folder of a relative path stack on the host.
+// Only paths starting with ./ or ../ are rewritten, other relative paths keep being
+// resolved by Nomad against the task directory.
+func rewriteRelativePaths(job *nomadapi.Job, basePath string) {
+	for _, group := range job.TaskGroups {
+		for _, task := range group.Tasks {
+			for _, artifact := range task.Artifacts {
+				if artifact.GetterSource != nil && isRelativePath(*artifact.GetterSource) {
+					source := path.Join(basePath, *artifact.GetterSource)
+					artifact.GetterSource = &source
+				}
+			}
+
+			rewriteDriverVolumes(task.Config, basePath)
+			rewriteDriverMounts(task.Config, basePath)
+		}
+	}
+}
+
+// rewriteDriverVolumes handles the "src:dst[:options]" volumes of the docker and podman drivers
+func rewriteDriverVolumes(config map[string]interface{}, basePath string) {
+	volumes, ok := config["volumes"].([]interface{})
+	if !ok {
+		return
+	}
+
+	for i, v := range volumes {
+		volume, ok := v.(string)
+		if !ok {
+			continue
+		}
+
+		src, rest, found := strings.Cut(volume, ":")
+		if !found || !isRelativePath(src) {
+			continue
+		}
+
+		volumes[i] = path.Join(basePath, src) + ":" + rest
+	}
+}
+
+// rewriteDriverMounts handles the bind mount blocks of the docker driver
+func rewriteDriverMounts(config map[string]interface{}, basePath string) {
+	mounts, ok := config["mount"].([]interface{})
+	if !ok {
+		return
+	}
+
+	for _, m := range mounts {
+		mount, ok := m.(map[string]interface{})
+		if !ok || mount["type"] != "bind" {
+			continue
+		}
+
+		if source, ok := mount["source"].(string); ok && isRelativePath(source) {
+			mount["source"] = path.Join(basePath, source)
+		}
+	}
+}
+
+func isRelativePath(p string) bool {
+	return strings.HasPrefix(p, "./") || strings.HasPrefix(p, "../")
+}
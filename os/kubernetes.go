@@ -7,21 +7,41 @@ import (
 )
 
 const (
+	// PlatformOverride forces the platform managed by the agent, e.g. for the endpoints started by the
+	// multi-endpoint mode: docker, podman, kubernetes, nomad or containerd
+	PlatformOverride      = "AGENT_PLATFORM"
 	PodmanMode            = "PODMAN"
+	ContainerdMode        = "CONTAINERD"
 	KubernetesServiceHost = "KUBERNETES_SERVICE_HOST"
 	KubernetesPodIP       = "KUBERNETES_POD_IP"
 	NomadJobName          = "NOMAD_JOB_NAME"
 )
 
-// DetermineContainerPlatform will check for the existence of the PODMAN_MODE
-// or KUBERNETES_SERVICE_HOST environment variable to determine if
+// DetermineContainerPlatform will check for the AGENT_PLATFORM override, then for the existence of the PODMAN_MODE,
+// CONTAINERD or KUBERNETES_SERVICE_HOST environment variable to determine if
 // the container is running on Podman or inside the Kubernetes platform.
 // Defaults to Docker otherwise.
 func DetermineContainerPlatform() agent.ContainerPlatform {
+	switch os.Getenv(PlatformOverride) {
+	case "docker":
+		return agent.PlatformDocker
+	case "podman":
+		return agent.PlatformPodman
+	case "kubernetes":
+		return agent.PlatformKubernetes
+	case "nomad":
+		return agent.PlatformNomad
+	case "containerd":
+		return agent.PlatformContainerd
+	}
+
 	podmanModeEnvVar := os.Getenv(PodmanMode)
 	if podmanModeEnvVar == "1" {
 		return agent.PlatformPodman
 	}
+	if os.Getenv(ContainerdMode) == "1" {
+		return agent.PlatformContainerd
+	}
 	serviceHostKubernetesEnvVar := os.Getenv(KubernetesServiceHost)
 	if serviceHostKubernetesEnvVar != "" {
 		return agent.PlatformKubernetes
@@ -1,54 +1,140 @@
 package os
 
 import (
+	"os"
 	"strconv"
 	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/portainer/agent"
+	"github.com/portainer/agent/profile"
 
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
 
 const (
-	EnvKeyAgentHost             = "AGENT_HOST"
-	EnvKeyAgentPort             = "AGENT_PORT"
-	EnvKeyClusterAddr           = "AGENT_CLUSTER_ADDR"
-	EnvKeyClusterProbeTimeout   = "AGENT_CLUSTER_PROBE_TIMEOUT"
-	EnvKeyClusterProbeInterval  = "AGENT_CLUSTER_PROBE_INTERVAL"
-	EnvKeyAgentSecret           = "AGENT_SECRET"
-	EnvKeyAgentSecurityShutdown = "AGENT_SECRET_TIMEOUT"
-	EnvKeyAssetsPath            = "ASSETS_PATH"
-	EnvKeyDataPath              = "DATA_PATH"
-	EnvKeyEdge                  = "EDGE"
-	EnvKeyEdgeAsync             = "EDGE_ASYNC"
-	EnvKeyEdgeKey               = "EDGE_KEY"
-	EnvKeyEdgeID                = "EDGE_ID"
-	EnvKeyEdgeServerHost        = "EDGE_SERVER_HOST"
-	EnvKeyEdgeServerPort        = "EDGE_SERVER_PORT"
-	EnvKeyEdgeInactivityTimeout = "EDGE_INACTIVITY_TIMEOUT"
-	EnvKeyEdgeInsecurePoll      = "EDGE_INSECURE_POLL"
-	EnvKeyEdgeTunnel            = "EDGE_TUNNEL"
-	EnvKeyEdgeTunnelHttpProxy   = "HTTP_PROXY"
-	EnvKeyEdgeTunnelHttpsProxy  = "HTTPS_PROXY"
-	EnvKeyHealthCheck           = "HEALTH_CHECK"
-	EnvKeyLogLevel              = "LOG_LEVEL"
-	EnvKeyLogMode               = "LOG_MODE"
-	EnvKeySSLCert               = "MTLS_SSL_CERT"
-	EnvKeySSLKey                = "MTLS_SSL_KEY"
-	EnvKeySSLCACert             = "MTLS_SSL_CA"
-	EnvKeyCertRetryInterval     = "MTLS_CERT_RETRY_INTERVAL"
-	EnvKeyAWSClientCert         = "AWS_CLIENT_CERT"
-	EnvKeyAWSClientKey          = "AWS_CLIENT_KEY"
-	EnvKeyAWSClientBundle       = "AWS_CLIENT_BUNDLE"
-	EnvKeyAWSRoleARN            = "AWS_ROLE_ARN"
-	EnvKeyAWSTrustAnchorARN     = "AWS_TRUST_ANCHOR_ARN"
-	EnvKeyAWSProfileARN         = "AWS_PROFILE_ARN"
-	EnvKeyAWSRegion             = "AWS_REGION"
-	EnvKeyUpdateID              = "UPDATE_ID"
-	EnvKeyEdgeGroups            = "EDGE_GROUPS"
-	EnvKeyEnvironmentGroup      = "PORTAINER_GROUP"
-	EnvKeyTags                  = "PORTAINER_TAGS"
+	EnvKeyAgentHost                 = "AGENT_HOST"
+	EnvKeyAgentPort                 = "AGENT_PORT"
+	EnvKeyClusterAddr               = "AGENT_CLUSTER_ADDR"
+	EnvKeyClusterProbeTimeout       = "AGENT_CLUSTER_PROBE_TIMEOUT"
+	EnvKeyClusterProbeInterval      = "AGENT_CLUSTER_PROBE_INTERVAL"
+	EnvKeyAgentSecret               = "AGENT_SECRET"
+	EnvKeyClusterKey                = "AGENT_CLUSTER_KEY"
+	EnvKeyClusterMTLSCACert         = "AGENT_CLUSTER_MTLS_CA"
+	EnvKeyClusterMTLSCert           = "AGENT_CLUSTER_MTLS_CERT"
+	EnvKeyClusterMTLSKey            = "AGENT_CLUSTER_MTLS_KEY"
+	EnvKeyAgentSecurityShutdown     = "AGENT_SECRET_TIMEOUT"
+	EnvKeyAssetsPath                = "ASSETS_PATH"
+	EnvKeyDataPath                  = "DATA_PATH"
+	EnvKeyEdge                      = "EDGE"
+	EnvKeyEdgeAsync                 = "EDGE_ASYNC"
+	EnvKeyEdgeJSONCodec             = "EDGE_JSON_CODEC"
+	EnvKeyEdgeCompression           = "EDGE_COMPRESSION"
+	EnvKeyEdgeUserAgent             = "EDGE_USER_AGENT"
+	EnvKeyEdgeFleetTag              = "EDGE_FLEET_TAG"
+	EnvKeyEdgeExtraHeaders          = "EDGE_EXTRA_HEADERS"
+	EnvKeyEdgeRequestRetries        = "EDGE_REQUEST_RETRIES"
+	EnvKeyEdgeCircuitCooldown       = "EDGE_CIRCUIT_COOLDOWN"
+	EnvKeyEdgeKey                   = "EDGE_KEY"
+	EnvKeyEdgeID                    = "EDGE_ID"
+	EnvKeyEdgeServerHost            = "EDGE_SERVER_HOST"
+	EnvKeyEdgeServerPort            = "EDGE_SERVER_PORT"
+	EnvKeyEdgeInactivityTimeout     = "EDGE_INACTIVITY_TIMEOUT"
+	EnvKeyEdgeInsecurePoll          = "EDGE_INSECURE_POLL"
+	EnvKeyEdgeTunnel                = "EDGE_TUNNEL"
+	EnvKeyEdgeTunnelHttpProxy       = "HTTP_PROXY"
+	EnvKeyEdgeTunnelHttpsProxy      = "HTTPS_PROXY"
+	EnvKeyEdgeDeployHttpProxy       = "EDGE_DEPLOY_HTTP_PROXY"
+	EnvKeyEdgeDeployHttpsProxy      = "EDGE_DEPLOY_HTTPS_PROXY"
+	EnvKeyEdgeDeployNoProxy         = "EDGE_DEPLOY_NO_PROXY"
+	EnvKeyHealthCheck               = "HEALTH_CHECK"
+	EnvKeyLogLevel                  = "LOG_LEVEL"
+	EnvKeyLogMode                   = "LOG_MODE"
+	EnvKeySSLCert                   = "MTLS_SSL_CERT"
+	EnvKeySSLKey                    = "MTLS_SSL_KEY"
+	EnvKeySSLCACert                 = "MTLS_SSL_CA"
+	EnvKeyCertRetryInterval         = "MTLS_CERT_RETRY_INTERVAL"
+	EnvKeyAWSClientCert             = "AWS_CLIENT_CERT"
+	EnvKeyAWSClientKey              = "AWS_CLIENT_KEY"
+	EnvKeyAWSClientBundle           = "AWS_CLIENT_BUNDLE"
+	EnvKeyAWSRoleARN                = "AWS_ROLE_ARN"
+	EnvKeyAWSTrustAnchorARN         = "AWS_TRUST_ANCHOR_ARN"
+	EnvKeyAWSProfileARN             = "AWS_PROFILE_ARN"
+	EnvKeyAWSRegion                 = "AWS_REGION"
+	EnvKeyUpdateID                  = "UPDATE_ID"
+	EnvKeyEdgeGroups                = "EDGE_GROUPS"
+	EnvKeyEnvironmentGroup          = "PORTAINER_GROUP"
+	EnvKeyTags                      = "PORTAINER_TAGS"
+	EnvKeyEdgeNotifyMQTTAddr        = "EDGE_NOTIFY_MQTT_ADDR"
+	EnvKeyEdgeNotifyMQTTTopic       = "EDGE_NOTIFY_MQTT_TOPIC"
+	EnvKeyEdgeNotifyWebhookURL      = "EDGE_NOTIFY_WEBHOOK_URL"
+	EnvKeyEdgeNotifyExec            = "EDGE_NOTIFY_EXEC"
+	EnvKeyEdgeStatusWebhooks        = "EDGE_STATUS_WEBHOOKS"
+	EnvKeyEdgeStatusWebhookRate     = "EDGE_STATUS_WEBHOOK_RATE_LIMIT"
+	EnvKeyEdgeStackHistoryCount     = "EDGE_STACK_HISTORY_COUNT"
+	EnvKeyEdgeStackHistorySize      = "EDGE_STACK_HISTORY_MAX_SIZE"
+	EnvKeyEdgeStackChangelogReport  = "EDGE_STACK_CHANGELOG_REPORT"
+	EnvKeyEdgeStackLargeFileSize    = "EDGE_STACK_LARGE_FILE_MAX_SIZE"
+	EnvKeyEdgeStackCache            = "EDGE_STACK_CACHE"
+	EnvKeyEdgeStackCacheSize        = "EDGE_STACK_CACHE_SIZE"
+	EnvKeyEdgeStackCacheS3URL       = "EDGE_STACK_CACHE_S3_URL"
+	EnvKeyEdgeStackCacheS3Region    = "EDGE_STACK_CACHE_S3_REGION"
+	EnvKeyEdgeStackCacheS3AccessKey = "EDGE_STACK_CACHE_S3_ACCESS_KEY"
+	EnvKeyEdgeStackCacheS3SecretKey = "EDGE_STACK_CACHE_S3_SECRET_KEY"
+	EnvKeyEdgeSessionRecord         = "EDGE_SESSION_RECORD"
+	EnvKeyEdgeSessionRecordSize     = "EDGE_SESSION_RECORD_SIZE"
+	EnvKeyEdgeJobHistoryCount       = "EDGE_JOB_HISTORY_COUNT"
+	EnvKeyEdgeJobHistoryReport      = "EDGE_JOB_HISTORY_REPORT"
+	EnvKeyEdgeJobUser               = "EDGE_JOB_USER"
+	EnvKeyEdgeJobAllowRoot          = "EDGE_JOB_ALLOW_ROOT"
+	EnvKeyEdgeFileTransferPaths     = "EDGE_FILE_TRANSFER_PATHS"
+	EnvKeyEdgeFileTransferSize      = "EDGE_FILE_TRANSFER_MAX_SIZE"
+	EnvKeyEdgeHostInfoInterval      = "EDGE_HOST_INFO_INTERVAL"
+	EnvKeyEdgeHostUpdatesCheck      = "EDGE_HOST_UPDATES_CHECK"
+	EnvKeyEdgeHostUpdateActions     = "EDGE_HOST_UPDATE_ACTIONS"
+	EnvKeyEdgePowerCriticalHours    = "EDGE_POWER_CRITICAL_HOURS"
+	EnvKeyEdgeUpgradeVerifyWindow   = "EDGE_UPGRADE_VERIFY_WINDOW"
+	EnvKeyDurableWrites             = "DURABLE_WRITES"
+	EnvKeyEdgeProfile               = "EDGE_PROFILE"
+	EnvKeyEdgeSnapshotMode          = "EDGE_SNAPSHOT_MODE"
+	EnvKeyEdgeStackPullProgress     = "EDGE_STACK_PULL_PROGRESS"
+	EnvKeyEdgeCredentialStore       = "EDGE_REGISTRY_CREDENTIAL_STORE"
+	EnvKeyEdgeCredentialHelper      = "EDGE_REGISTRY_CREDENTIAL_HELPER"
+	EnvKeyEdgeStackOrphanPolicy     = "EDGE_STACK_ORPHAN_POLICY"
+	EnvKeyEdgeStackLint             = "EDGE_STACK_LINT"
+	EnvKeyEdgeStackSkipUnchanged    = "EDGE_STACK_SKIP_UNCHANGED"
+	EnvKeyEdgeStackNaming           = "EDGE_STACK_NAMING"
+	EnvKeyEdgeStackPrefix           = "EDGE_STACK_PREFIX"
+	EnvKeyEdgeStackEnvPaths         = "EDGE_STACK_ENV_PATHS"
+	EnvKeyEdgeStorageQuota          = "EDGE_STORAGE_QUOTA"
+	EnvKeyEdgeJobLogMaxSize         = "EDGE_JOB_LOG_MAX_SIZE"
+	EnvKeyEdgeStackFilesPath        = "EDGE_STACK_FILES_PATH"
+	EnvKeyEdgeJobScriptsPath        = "EDGE_JOB_SCRIPTS_PATH"
+	EnvKeyTmpPath                   = "TMP_PATH"
+	EnvKeyFIPSMode                  = "FIPS_MODE"
+	EnvKeyTLSMinVersion             = "TLS_MIN_VERSION"
+	EnvKeyTLSCipherSuites           = "TLS_CIPHER_SUITES"
+	EnvKeyEdgeServerCA              = "EDGE_SERVER_CA"
+	EnvKeyEdgeRegistryCA            = "EDGE_REGISTRY_CA"
+	EnvKeyEdgeServerPins            = "EDGE_SERVER_PINS"
+	EnvKeyEdgeTLSRevocation         = "EDGE_TLS_REVOCATION"
+	EnvKeyEdgeLabelsFile            = "EDGE_LABELS_FILE"
+	EnvKeyEdgeBootstrapFile         = "EDGE_BOOTSTRAP_FILE"
+	EnvKeyEdgeAttestation           = "EDGE_ATTESTATION"
+	EnvKeyEdgeAttestationCert       = "EDGE_ATTESTATION_CERT"
+	EnvKeyEdgeAttestationKey        = "EDGE_ATTESTATION_KEY"
+	EnvKeyEdgeAttestationTPMHandle  = "EDGE_ATTESTATION_TPM_HANDLE"
+	EnvKeyMDNS                      = "MDNS"
+	EnvKeyEdgeStandby               = "EDGE_STANDBY"
+	EnvKeyEdgeStandbyLease          = "EDGE_STANDBY_LEASE"
+	EnvKeyDockerHost                = "DOCKER_HOST"
+	EnvKeyDockerContext             = "DOCKER_CONTEXT"
+	EnvKeyEdgeEndpointsFile         = "EDGE_ENDPOINTS_FILE"
+	EnvKeyEdgeImagePolicyFile       = "EDGE_IMAGE_POLICY_FILE"
+	EnvKeyEdgeSecurityPolicyFile    = "EDGE_SECURITY_POLICY_FILE"
+	EnvKeyEdgeFirewallPolicyFile    = "EDGE_FIREWALL_POLICY_FILE"
+	EnvKeyEdgeOPAPolicy             = "EDGE_OPA_POLICY"
+	EnvKeyEdgeOPABinary             = "EDGE_OPA_BINARY"
 )
 
 type EnvOptionParser struct{}
@@ -65,29 +151,158 @@ var (
 	fClusterAddress        = kingpin.Flag("cluster-addr", EnvKeyClusterAddr+" address (in the IP:PORT format) of an existing agent to join the agent cluster. When deploying the agent as a Docker Swarm service, we can leverage the internal Docker DNS to automatically join existing agents or form a cluster by using tasks.<AGENT_SERVICE_NAME>:<AGENT_PORT> as the address").Envar(EnvKeyClusterAddr).String()
 	fClusterProbeTimeout   = kingpin.Flag("agent-cluster-timeout", EnvKeyClusterProbeTimeout+" timeout interval for receiving agent member probe responses (only change this setting if you know what you're doing)").Envar(EnvKeyClusterProbeTimeout).Default(agent.DefaultClusterProbeTimeout).Duration()
 	fClusterProbeInterval  = kingpin.Flag("agent-cluster-interval", EnvKeyClusterProbeInterval+" interval for repeating failed agent member probe (only change this setting if you know what you're doing)").Envar(EnvKeyClusterProbeInterval).Default(agent.DefaultClusterProbeInterval).Duration()
+	fClusterKey            = kingpin.Flag("agent-cluster-key", EnvKeyClusterKey+" base64 encoded 16, 24 or 32 bytes key used to encrypt the agent cluster gossip. Once rotated, the keys kept in the data folder are used instead").Envar(EnvKeyClusterKey).String()
+	fClusterMTLSCACert     = kingpin.Flag("agent-cluster-mtls-ca", EnvKeyClusterMTLSCACert+" path to the CA certificate the certificates of the agents of the cluster are verified against, enables the mutual TLS authentication between agents").Envar(EnvKeyClusterMTLSCACert).String()
+	fClusterMTLSCert       = kingpin.Flag("agent-cluster-mtls-cert", EnvKeyClusterMTLSCert+" path to the certificate the agent presents to the other agents of the cluster").Envar(EnvKeyClusterMTLSCert).String()
+	fClusterMTLSKey        = kingpin.Flag("agent-cluster-mtls-key", EnvKeyClusterMTLSKey+" path to the key of the certificate the agent presents to the other agents of the cluster").Envar(EnvKeyClusterMTLSKey).String()
 	fDataPath              = kingpin.Flag("data", EnvKeyDataPath+" path to the data folder").Envar(EnvKeyDataPath).Default(agent.DefaultDataPath).String()
+	fTmpPath               = kingpin.Flag("tmp", EnvKeyTmpPath+" path to the folder of the temporary files of the agent and of the processes it runs, e.g. on a writable volume of a device with a read-only root filesystem (default to the temporary folder of the system)").Envar(EnvKeyTmpPath).String()
 	fSharedSecret          = kingpin.Flag("secret", EnvKeyAgentSecret+" shared secret used in the signature verification process").Envar(EnvKeyAgentSecret).String()
 	fLogLevel              = kingpin.Flag("log-level", EnvKeyLogLevel+" defines the log output verbosity (default to INFO)").Envar(EnvKeyLogLevel).Default(agent.DefaultLogLevel).Enum("ERROR", "WARN", "INFO", "DEBUG")
 	fLogMode               = kingpin.Flag("log-mode", EnvKeyLogMode+" defines the logging output mode").Envar(EnvKeyLogMode).Default("PRETTY").Enum("NOCOLOR", "PRETTY", "JSON")
 	fHealthCheck           = kingpin.Flag("health-check", "run the agent in healthcheck mode and exit after running preflight checks").Envar(EnvKeyHealthCheck).Default("false").Bool()
+	fDurableWrites         = kingpin.Flag("durable-writes", EnvKeyDurableWrites+" flush the files written by the agent to the storage. Recommended for devices using SD cards or other flash media that can lose files on power cuts. Disabled by default").Envar(EnvKeyDurableWrites).Bool()
+	fEdgeProfile           = kingpin.Flag("edge-profile", EnvKeyEdgeProfile+" subsystems of the agent: standard, or minimal for the 256-512MB ARMv6/ARMv7 devices, which sends the minimal snapshots, lets the deployer pull the images of the Edge stacks and bounds the heap of the agent (default to the profile of the build)").Envar(EnvKeyEdgeProfile).Default(profile.Default()).Enum(profile.Standard, profile.Minimal)
+	fEdgeSnapshotMode      = kingpin.Flag("edge-snapshot-mode", EnvKeyEdgeSnapshotMode+" content of the snapshots sent in async mode: full, or minimal for the counts and the engine information only (default to the mode of the profile)").Envar(EnvKeyEdgeSnapshotMode).Default(agent.DefaultEdgeSnapshotMode).Enum("auto", "full", "minimal")
+	fEdgeStackPullProgress = kingpin.Flag("edge-stack-pull-progress", EnvKeyEdgeStackPullProgress+" pull the images of the Edge stacks through the engine API to report their download progress: on, or off to let the deployer pull them (default to the mode of the profile)").Envar(EnvKeyEdgeStackPullProgress).Default(agent.DefaultEdgeStackPullProgress).Enum("auto", "on", "off")
+	fFIPSMode              = kingpin.Flag("fips", EnvKeyFIPSMode+" restrict the TLS connections, signatures and encryption of the agent to the FIPS 140 approved algorithms of its validated crypto backend, the agent refuses to start when it was not built with one (GOEXPERIMENT=boringcrypto) or when its self-check fails. Disabled by default").Envar(EnvKeyFIPSMode).Bool()
+	fTLSMinVersion         = kingpin.Flag("tls-min-version", EnvKeyTLSMinVersion+" minimum TLS version of the connections of the agent, to the Portainer server, the registries and the proxied APIs, and of its API (default to 1.2)").Envar(EnvKeyTLSMinVersion).Default(agent.DefaultTLSMinVersion).Enum("1.2", "1.3")
+	fTLSCipherSuites       = kingpin.Flag("tls-cipher-suites", EnvKeyTLSCipherSuites+" comma separated list of the TLS 1.2 cipher suites allowed in the connections of the agent and of its API, e.g. TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384. The insecure cipher suites are refused, the TLS 1.3 cipher suites cannot be configured (default to the recommended cipher suites)").Envar(EnvKeyTLSCipherSuites).String()
+	fMDNS                  = kingpin.Flag("mdns", EnvKeyMDNS+" advertise the agent (name, Edge ID, API port and version) on the local network over mDNS/DNS-SD. Disable this option on security-sensitive sites").Envar(EnvKeyMDNS).Default("true").Bool()
+	fDockerHost            = kingpin.Flag("docker-host", EnvKeyDockerHost+" address of the Docker engine managed by the agent, e.g. tcp://192.168.1.20:2376 or ssh://admin@192.168.1.20, instead of the local socket. The TLS files are read from DOCKER_CERT_PATH").Envar(EnvKeyDockerHost).String()
+	fDockerContext         = kingpin.Flag("docker-context", EnvKeyDockerContext+" name of the docker context of the Docker engine managed by the agent, including its TLS files, instead of the local socket").Envar(EnvKeyDockerContext).String()
 	fUpdateID              = kingpin.Flag("update-id", "the edge update identifier that started this agent").Envar(EnvKeyUpdateID).Int()
 
 	// Edge mode
 	fEdgeMode              = kingpin.Flag("edge", EnvKeyEdge+" enable Edge mode. Disabled by default, set to 1 or true to enable it").Envar(EnvKeyEdge).Bool()
 	fEdgeAsyncMode         = kingpin.Flag("edge-async", EnvKeyEdge+" enable Edge Async mode. Disabled by default, set to 1 or true to enable it").Envar(EnvKeyEdgeAsync).Bool()
+	fEdgeJSONCodec         = kingpin.Flag("edge-json-codec", EnvKeyEdgeJSONCodec+" JSON implementation the payloads exchanged with Portainer are encoded and decoded with, std or jsoniter which lowers the CPU and memory used by the polls of large hosts (default to std)").Envar(EnvKeyEdgeJSONCodec).Default("std").Enum("std", "jsoniter")
+	fEdgeCompression       = kingpin.Flag("edge-compression", EnvKeyEdgeCompression+" gzip compression of the snapshots and status payloads sent to Portainer: auto once the server advertises it accepts gzip, always or off (default to auto). The other encodings such as zstd are not supported").Envar(EnvKeyEdgeCompression).Default("auto").Enum("auto", "always", "off")
+	fEdgeUserAgent         = kingpin.Flag("edge-user-agent", EnvKeyEdgeUserAgent+" User-Agent of the requests sent to Portainer (default to portainer-agent/<version> (<os>; <arch>; <engine>))").Envar(EnvKeyEdgeUserAgent).String()
+	fEdgeFleetTag          = kingpin.Flag("edge-fleet-tag", EnvKeyEdgeFleetTag+" tag of the fleet of the device, sent in the requests to Portainer and in the async polls so that the traffic of the agents can be segmented").Envar(EnvKeyEdgeFleetTag).String()
+	fEdgeExtraHeaders      = kingpin.Flag("edge-extra-headers", EnvKeyEdgeExtraHeaders+" comma-separated list of the headers added to the requests sent to Portainer in the Name: value format, e.g. for the WAF rules in front of the server").Envar(EnvKeyEdgeExtraHeaders).String()
+	fEdgeRequestRetries    = kingpin.Flag("edge-request-retries", EnvKeyEdgeRequestRetries+" maximum number of retries of a request to Portainer failing with a network error or an unavailable server, within a retry budget shared by all the requests (default to 2, 0 to disable)").Envar(EnvKeyEdgeRequestRetries).Default(agent.DefaultEdgeRequestRetries).Int()
+	fEdgeCircuitCooldown   = kingpin.Flag("edge-circuit-cooldown", EnvKeyEdgeCircuitCooldown+" time during which the requests to Portainer fail fast once it is considered unreachable, before a single request probes it again (default to 30s)").Envar(EnvKeyEdgeCircuitCooldown).Default(agent.DefaultEdgeCircuitCooldown).Duration()
 	fEdgeKey               = kingpin.Flag("edge-key", EnvKeyEdgeKey+" specify an Edge key to use at startup").Envar(EnvKeyEdgeKey).String()
 	fEdgeID                = kingpin.Flag("edge-id", EnvKeyEdgeID+" a unique identifier associated to this agent cluster").Envar(EnvKeyEdgeID).String()
 	fEdgeServerAddr        = kingpin.Flag("edge-host", EnvKeyEdgeServerHost+" address on which the Edge UI will be exposed (default to 0.0.0.0)").Envar(EnvKeyEdgeServerHost).Default(agent.DefaultEdgeServerAddr).IP()
 	fEdgeServerPort        = kingpin.Flag("edge-port", EnvKeyEdgeServerPort+" port on which the Edge UI will be exposed (default to 80)").Envar(EnvKeyEdgeServerPort).Default(agent.DefaultEdgeServerPort).Int()
 	fEdgeInactivityTimeout = kingpin.Flag("edge-inactivity", EnvKeyEdgeInactivityTimeout+" timeout used by the agent to close the reverse tunnel after inactivity (default to 5m)").Envar(EnvKeyEdgeInactivityTimeout).Default(agent.DefaultEdgeSleepInterval).String()
 	fEdgeInsecurePoll      = kingpin.Flag("edge-insecurepoll", EnvKeyEdgeInsecurePoll+" enable this option if you need the agent to poll a HTTPS Portainer instance with self-signed certificates. Disabled by default, set to 1 to enable it").Envar(EnvKeyEdgeInsecurePoll).Bool()
+	fEdgeServerCA          = kingpin.Flag("edge-server-ca", EnvKeyEdgeServerCA+" path to the CA bundle the certificate of the Portainer server is verified against, by the poll and the tunnel, instead of the CAs of the system").Envar(EnvKeyEdgeServerCA).String()
+	fEdgeRegistryCA        = kingpin.Flag("edge-registry-ca", EnvKeyEdgeRegistryCA+" path to the CA bundle the certificates of the registries the agent pulls from are verified against, in addition to the CAs of the system").Envar(EnvKeyEdgeRegistryCA).String()
+	fEdgeServerPins        = kingpin.Flag("edge-server-pins", EnvKeyEdgeServerPins+" comma separated list of the public keys the Portainer server must present one of, in the sha256//<base64 SHA-256 of the public key> format, e.g. as computed by curl --pinnedpubkey. Also enforced with EDGE_INSECURE_POLL").Envar(EnvKeyEdgeServerPins).String()
+	fEdgeTLSRevocation     = kingpin.Flag("edge-tls-revocation", EnvKeyEdgeTLSRevocation+" how the revocation of the certificates of the Portainer server is checked through their CRLs and OCSP responders, off, soft to accept the certificates whose revocation status cannot be checked or hard to reject them (default to soft with mTLS, off otherwise)").Envar(EnvKeyEdgeTLSRevocation).Enum("off", "soft", "hard")
 	fEdgeTunnel            = kingpin.Flag("edge-tunnel", EnvKeyEdgeTunnel+" disable this option if you wish to prevent the agent from opening tunnels over websockets").Envar(EnvKeyEdgeTunnel).Default("true").Bool()
 	fEdgeTunnelHttpProxy   = kingpin.Flag("edge-tunnel-http-proxy", EnvKeyEdgeTunnelHttpProxy+" enable this option if you wish to use a proxy to open tunnels over websockets").Envar(EnvKeyEdgeTunnelHttpProxy).String()
 	fEdgeTunnelHttpsProxy  = kingpin.Flag("edge-tunnel-https-proxy", EnvKeyEdgeTunnelHttpsProxy+" enable this option if you wish to use a https proxy to open tunnels over websockets").Envar(EnvKeyEdgeTunnelHttpsProxy).String()
+	fEdgeDeployHttpProxy   = kingpin.Flag("edge-deploy-http-proxy", EnvKeyEdgeDeployHttpProxy+" proxy the compose, nerdctl and kubectl processes deploying the Edge stacks pull the images and fetch the files through over HTTP (default to HTTP_PROXY)").Envar(EnvKeyEdgeDeployHttpProxy).String()
+	fEdgeDeployHttpsProxy  = kingpin.Flag("edge-deploy-https-proxy", EnvKeyEdgeDeployHttpsProxy+" proxy the compose, nerdctl and kubectl processes deploying the Edge stacks pull the images and fetch the files through over HTTPS (default to HTTPS_PROXY)").Envar(EnvKeyEdgeDeployHttpsProxy).String()
+	fEdgeDeployNoProxy     = kingpin.Flag("edge-deploy-no-proxy", EnvKeyEdgeDeployNoProxy+" comma separated list of the registries and hosts the processes deploying the Edge stacks reach without the proxy, e.g. registry.local:5000,.corp.example.com, added to NO_PROXY").Envar(EnvKeyEdgeDeployNoProxy).String()
 	fEdgeGroupsIDs         = kingpin.Flag("edge-groups", EnvKeyEdgeGroups+" a colon-separated list of Edge groups identifiers. Used for AEEC, the created environment will be added to these edge groups").Envar(EnvKeyEdgeGroups).String()
 	fEnvironmentGroupID    = kingpin.Flag("environment-group", EnvKeyEnvironmentGroup+" an Environment group identifier. Used for AEEC, the created environment will be associated to this group").Envar(EnvKeyEnvironmentGroup).Int()
 	fTagsIDs               = kingpin.Flag("tags", EnvKeyTags+" a colon-separated list of tags to associate to the environment. Used for AEEC.").Envar(EnvKeyTags).String()
 
+	// Edge stack local notifications
+	fEdgeNotifyMQTTAddr    = kingpin.Flag("edge-notify-mqtt-addr", EnvKeyEdgeNotifyMQTTAddr+" address (in the HOST:PORT format) of a local MQTT broker Edge stack events are published to").Envar(EnvKeyEdgeNotifyMQTTAddr).String()
+	fEdgeNotifyMQTTTopic   = kingpin.Flag("edge-notify-mqtt-topic", EnvKeyEdgeNotifyMQTTTopic+" MQTT topic Edge stack events are published to").Envar(EnvKeyEdgeNotifyMQTTTopic).Default(agent.DefaultEdgeNotifyMQTTTopic).String()
+	fEdgeNotifyWebhookURL  = kingpin.Flag("edge-notify-webhook-url", EnvKeyEdgeNotifyWebhookURL+" URL of a local webhook Edge stack events are POSTed to").Envar(EnvKeyEdgeNotifyWebhookURL).String()
+	fEdgeNotifyExec        = kingpin.Flag("edge-notify-exec", EnvKeyEdgeNotifyExec+" path to a script executed for each Edge stack event").Envar(EnvKeyEdgeNotifyExec).String()
+	fEdgeStatusWebhooks    = kingpin.Flag("edge-status-webhooks", EnvKeyEdgeStatusWebhooks+" a comma-separated list of webhook URLs notified when an Edge stack fails or completes. Requests are signed with a key derived from the Edge key").Envar(EnvKeyEdgeStatusWebhooks).String()
+	fEdgeStatusWebhookRate = kingpin.Flag("edge-status-webhook-rate-limit", EnvKeyEdgeStatusWebhookRate+" minimum interval between two status webhooks for the same stack and status (default to 5m)").Envar(EnvKeyEdgeStatusWebhookRate).Default(agent.DefaultEdgeStatusWebhookRateLimit).Duration()
+
+	// Edge stack history
+	fEdgeStackHistoryCount     = kingpin.Flag("edge-stack-history-count", EnvKeyEdgeStackHistoryCount+" number of successfully deployed versions kept for each Edge stack, used to roll back (default to 3, 0 to disable)").Envar(EnvKeyEdgeStackHistoryCount).Default(agent.DefaultEdgeStackHistoryCount).Int()
+	fEdgeStackHistorySize      = kingpin.Flag("edge-stack-history-max-size", EnvKeyEdgeStackHistorySize+" maximum size used by the retained versions of each Edge stack, e.g. 10MB (unlimited by default)").Envar(EnvKeyEdgeStackHistorySize).Default("0").Bytes()
+	fEdgeStackChangelogReport  = kingpin.Flag("edge-stack-changelog-report", EnvKeyEdgeStackChangelogReport+" report to the Portainer server the history of the versions each Edge stack went through, each time a version is deployed or fails, so that the devices behind can be told apart. Disabled by default").Envar(EnvKeyEdgeStackChangelogReport).Bool()
+	fEdgeStackChangelog        = kingpin.Flag("stack-changelog", "print the history of the versions of the Edge stacks kept in the data folder as JSON and exit").Bool()
+	fEdgeStackLargeFileSize    = kingpin.Flag("edge-stack-large-file-max-size", EnvKeyEdgeStackLargeFileSize+" maximum size of each large file of the Edge stacks downloaded apart from the stack payload, e.g. 2GB (unlimited by default)").Envar(EnvKeyEdgeStackLargeFileSize).Default("0").Bytes()
+	fEdgeStackCache            = kingpin.Flag("edge-stack-cache", EnvKeyEdgeStackCache+" where the payloads and the large files of the Edge stack versions are cached so that a version deployed again is not downloaded again: off, filesystem in the data folder or s3 for a bucket shared by the devices of a site (default to filesystem)").Envar(EnvKeyEdgeStackCache).Default(agent.DefaultEdgeStackCache).Enum("off", "filesystem", "s3")
+	fEdgeStackCacheSize        = kingpin.Flag("edge-stack-cache-size", EnvKeyEdgeStackCacheSize+" maximum size of the filesystem cache of the Edge stacks, the least recently used entries are evicted beyond it (default to 512MB, 0 for unlimited)").Envar(EnvKeyEdgeStackCacheSize).Default(agent.DefaultEdgeStackCacheSize).Bytes()
+	fEdgeStackCacheS3URL       = kingpin.Flag("edge-stack-cache-s3-url", EnvKeyEdgeStackCacheS3URL+" URL of the bucket of the s3 cache of the Edge stacks in the path style, optionally followed by a prefix, e.g. https://minio.site.local:9000/edge-cache").Envar(EnvKeyEdgeStackCacheS3URL).String()
+	fEdgeStackCacheS3Region    = kingpin.Flag("edge-stack-cache-s3-region", EnvKeyEdgeStackCacheS3Region+" region of the bucket of the s3 cache of the Edge stacks (default to us-east-1)").Envar(EnvKeyEdgeStackCacheS3Region).String()
+	fEdgeStackCacheS3AccessKey = kingpin.Flag("edge-stack-cache-s3-access-key", EnvKeyEdgeStackCacheS3AccessKey+" access key of the bucket of the s3 cache of the Edge stacks, the bucket is accessed anonymously when not set").Envar(EnvKeyEdgeStackCacheS3AccessKey).String()
+	fEdgeStackCacheS3SecretKey = kingpin.Flag("edge-stack-cache-s3-secret-key", EnvKeyEdgeStackCacheS3SecretKey+" secret key of the bucket of the s3 cache of the Edge stacks").Envar(EnvKeyEdgeStackCacheS3SecretKey).String()
+	fEdgeSessionRecord         = kingpin.Flag("edge-session-record", EnvKeyEdgeSessionRecord+" debug mode recording the polls of the server, the stack payloads, the statuses reported and the outcomes of the deployer to this file with their secrets redacted, to be replayed offline with agent-replay").Envar(EnvKeyEdgeSessionRecord).String()
+	fEdgeSessionRecordSize     = kingpin.Flag("edge-session-record-size", EnvKeyEdgeSessionRecordSize+" maximum size of the recorded session, the recording stops beyond it (default to 64MB, 0 for unlimited)").Envar(EnvKeyEdgeSessionRecordSize).Default(agent.DefaultEdgeSessionRecordSize).Bytes()
+
+	// Edge job history
+	fEdgeJobHistoryCount  = kingpin.Flag("edge-job-history-count", EnvKeyEdgeJobHistoryCount+" number of executions kept for each Edge job in the data folder, with their exit code, duration and the end of their output (default to 20, 0 to disable)").Envar(EnvKeyEdgeJobHistoryCount).Default(agent.DefaultEdgeJobHistoryCount).Int()
+	fEdgeJobHistoryReport = kingpin.Flag("edge-job-history-report", EnvKeyEdgeJobHistoryReport+" report the executions of the Edge jobs to Portainer, in the snapshots in async mode").Envar(EnvKeyEdgeJobHistoryReport).Bool()
+	fEdgeJobHistory       = kingpin.Flag("job-history", "print the executions of the Edge jobs kept in the data folder as JSON and exit").Bool()
+	fEdgeJobUser          = kingpin.Flag("edge-job-user", EnvKeyEdgeJobUser+" user the Edge jobs run as on the host when they do not set one (default to nobody)").Envar(EnvKeyEdgeJobUser).Default(agent.DefaultEdgeJobUser).String()
+	fEdgeJobAllowRoot     = kingpin.Flag("edge-job-allow-root", EnvKeyEdgeJobAllowRoot+" allow the Edge jobs to run as root on the host, the jobs running as root are denied otherwise").Envar(EnvKeyEdgeJobAllowRoot).Bool()
+	fEdgeJobHistoryID     = kingpin.Flag("job-history-id", "identifier of the Edge job whose executions are printed by --job-history instead of all the jobs").Int()
+
+	// Edge file transfers
+	fEdgeFileTransferPaths = kingpin.Flag("edge-file-transfer-paths", EnvKeyEdgeFileTransferPaths+" a comma-separated list of the host paths Portainer can push files to and pull files from, e.g. /etc/app,/var/log/app. The transfers are audited in the data folder. Disabled by default").Envar(EnvKeyEdgeFileTransferPaths).String()
+	fEdgeFileTransferSize  = kingpin.Flag("edge-file-transfer-max-size", EnvKeyEdgeFileTransferSize+" maximum size of the files pushed to and pulled from the host (default to 10MB)").Envar(EnvKeyEdgeFileTransferSize).Default(agent.DefaultEdgeFileTransferMaxSize).Bytes()
+
+	// Edge host information
+	fEdgeHostInfoInterval    = kingpin.Flag("edge-host-info-interval", EnvKeyEdgeHostInfoInterval+" interval between two collections of the distribution, the kernel and the container runtime versions of the host reported to Portainer (default to 1h)").Envar(EnvKeyEdgeHostInfoInterval).Default(agent.DefaultEdgeHostInfoInterval).Duration()
+	fEdgeHostUpdatesCheck    = kingpin.Flag("edge-host-updates-check", EnvKeyEdgeHostUpdatesCheck+" check the pending package updates of the host, security updates included, with its package manager at each collection. Disabled by default").Envar(EnvKeyEdgeHostUpdatesCheck).Bool()
+	fEdgeHostUpdateActions   = kingpin.Flag("edge-host-update-actions", EnvKeyEdgeHostUpdateActions+" a comma-separated list of the package update actions Portainer can trigger on the host, among refresh, upgrade and security. Disabled by default").Envar(EnvKeyEdgeHostUpdateActions).String()
+	fEdgePowerCriticalHours  = kingpin.Flag("edge-power-critical-hours", EnvKeyEdgePowerCriticalHours+" a comma-separated list of the daily windows, in the local time of the device, during which Portainer cannot reboot or shut it down, e.g. 08:00-18:00,22:00-02:00").Envar(EnvKeyEdgePowerCriticalHours).String()
+	fEdgeUpgradeVerifyWindow = kingpin.Flag("edge-upgrade-verify-window", EnvKeyEdgeUpgradeVerifyWindow+" window an updated agent must poll Portainer, reach its deployer and its tunnel server within, the previous agent is restored otherwise. Only on Docker standalone, 0 removes the previous agent without verification (default to 5m)").Envar(EnvKeyEdgeUpgradeVerifyWindow).Default(agent.DefaultEdgeUpgradeVerifyWindow).Duration()
+
+	// Edge registry credentials
+	fEdgeCredentialStore  = kingpin.Flag("edge-registry-credential-store", EnvKeyEdgeCredentialStore+" where the registry credentials of the Edge stacks are kept, the file, keyring and helper backends keep them across restarts (default to memory)").Envar(EnvKeyEdgeCredentialStore).Default(agent.DefaultEdgeCredentialStore).Enum("memory", "file", "keyring", "helper")
+	fEdgeCredentialHelper = kingpin.Flag("edge-registry-credential-helper", EnvKeyEdgeCredentialHelper+" path to the docker credential helper binary used by the helper credential store, e.g. /usr/bin/docker-credential-pass").Envar(EnvKeyEdgeCredentialHelper).String()
+
+	// Edge stack orphaned resources
+	fEdgeStackOrphanPolicy  = kingpin.Flag("edge-stack-orphan-policy", EnvKeyEdgeStackOrphanPolicy+" what to do with the Docker resources left behind by deleted or crashed Edge stacks, report them, adopt the ones of a previous agent or remove them. Only supported in standard mode (default to none)").Envar(EnvKeyEdgeStackOrphanPolicy).Default(agent.DefaultEdgeStackOrphanPolicy).Enum("none", "report", "adopt", "remove")
+	fEdgeStackLint          = kingpin.Flag("edge-stack-lint", EnvKeyEdgeStackLint+" lint the files of the Edge stacks for unpinned images, missing restart policies and resource limits and privileged containers. The warnings are reported with the Acknowledged status, or fail the deployment when enforced (default to off)").Envar(EnvKeyEdgeStackLint).Default(agent.DefaultEdgeStackLint).Enum("off", "warn", "enforce")
+	fEdgeStackSkipUnchanged = kingpin.Flag("edge-stack-skip-unchanged", EnvKeyEdgeStackSkipUnchanged+" skip the deployment of the Edge stack versions whose files, registry credentials and images are identical to the deployed ones and report them up-to-date, so that the metadata changes made by the server do not restart the stacks. Disabled by default").Envar(EnvKeyEdgeStackSkipUnchanged).Bool()
+	fEdgeStackNaming        = kingpin.Flag("edge-stack-naming", EnvKeyEdgeStackNaming+" how the projects of the Edge stacks are named, after the stack with the project prefix or after the stack alone. The stacks already deployed are moved to their new project on their next deployment (default to prefix)").Envar(EnvKeyEdgeStackNaming).Default(agent.DefaultEdgeStackNaming).Enum("prefix", "name")
+	fEdgeStackPrefix        = kingpin.Flag("edge-stack-prefix", EnvKeyEdgeStackPrefix+" prefix of the projects of the Edge stacks, used by the prefix naming (default to edge_)").Envar(EnvKeyEdgeStackPrefix).Default(agent.DefaultEdgeStackPrefix).String()
+	fEdgeStackEnvPaths      = kingpin.Flag("edge-stack-env-paths", EnvKeyEdgeStackEnvPaths+" a comma-separated list of the host paths the env files of the Edge stacks can be read from, an empty list refuses the env files (default to /etc/portainer)").Envar(EnvKeyEdgeStackEnvPaths).Default(agent.DefaultEdgeStackEnvPaths).String()
+	fEdgeStackFilesPath     = kingpin.Flag("edge-stack-files-path", EnvKeyEdgeStackFilesPath+" path to the folder the files of the Edge stacks and their retained versions are written to (default to /tmp/edge_stacks)").Envar(EnvKeyEdgeStackFilesPath).Default(agent.DefaultEdgeStackFilesPath).String()
+	fEdgeJobScriptsPath     = kingpin.Flag("edge-job-scripts-path", EnvKeyEdgeJobScriptsPath+" path to the folder of the host the scripts and the logs of the Edge jobs are written to (default to /opt/portainer/scripts)").Envar(EnvKeyEdgeJobScriptsPath).Default(agent.DefaultScheduleScriptDirectory).String()
+	fEdgeStorageQuota       = kingpin.Flag("edge-storage-quota", EnvKeyEdgeStorageQuota+" maximum size used by the files of the Edge stacks, their retained versions and the job logs, e.g. 500MB. The retained versions are evicted the least recently deployed first when it is exceeded (unlimited by default)").Envar(EnvKeyEdgeStorageQuota).Default("0").Bytes()
+	fEdgeJobLogMaxSize      = kingpin.Flag("edge-job-log-max-size", EnvKeyEdgeJobLogMaxSize+" maximum size of the log of each Edge job, the bigger logs are truncated to their end, e.g. 10MB (unlimited by default)").Envar(EnvKeyEdgeJobLogMaxSize).Default("0").Bytes()
+
+	// Edge hot standby
+	fEdgeStandby      = kingpin.Flag("edge-standby", EnvKeyEdgeStandby+" run the agent as part of an active/passive pair sharing the same data folder, only the active agent polls Portainer, manages the Edge stacks and opens the tunnel. Disabled by default").Envar(EnvKeyEdgeStandby).Bool()
+	fEdgeStandbyLease = kingpin.Flag("edge-standby-lease", EnvKeyEdgeStandbyLease+" how long the active agent can miss renewing its lease before the standby agent takes over (default to 15s)").Envar(EnvKeyEdgeStandbyLease).Default(agent.DefaultEdgeStandbyLease).Duration()
+
+	// Edge multi-endpoint mode
+	fEdgeEndpointsFile = kingpin.Flag("edge-endpoints-file", EnvKeyEdgeEndpointsFile+" path to a JSON file declaring additional environments managed by the agent, e.g. a k3s cluster running next to the Docker engine of the device. Each environment is registered as a separate Portainer endpoint with its own Edge key and runs in a child agent process").Envar(EnvKeyEdgeEndpointsFile).String()
+
+	// Edge image policy
+	fEdgeImagePolicyFile    = kingpin.Flag("edge-image-policy-file", EnvKeyEdgeImagePolicyFile+" path to a JSON file declaring the registries and the images the Edge stacks can use, and whether the images must be signed. The policy is enforced by the agent before the images are pulled, whatever the stacks sent by Portainer").Envar(EnvKeyEdgeImagePolicyFile).String()
+	fEdgeSecurityPolicyFile = kingpin.Flag("edge-security-policy-file", EnvKeyEdgeSecurityPolicyFile+" path to a JSON file forbidding privileged containers, the host network and PID namespaces, unconfined seccomp profiles, capabilities or bind mounts in the Edge stacks. The stacks violating the policy are rejected by the agent instead of being deployed").Envar(EnvKeyEdgeSecurityPolicyFile).String()
+	fEdgeFirewallPolicyFile = kingpin.Flag("edge-firewall-policy-file", EnvKeyEdgeFirewallPolicyFile+" path to a JSON file declaring the ports the Edge stacks can open in the nftables or iptables firewall of the host, and the networks they are opened to. The ports published by a stack are opened once it is deployed and closed once it is removed. The firewall is not managed by default").Envar(EnvKeyEdgeFirewallPolicyFile).String()
+	fEdgeOPAPolicy          = kingpin.Flag("edge-opa-policy", EnvKeyEdgeOPAPolicy+" path to a Rego file or to a folder of Rego files evaluated by the agent against the Edge stacks, the Edge jobs and the commands run on the host before processing them. The reasons of the data.portainer.agent.deny rule are reported to Portainer").Envar(EnvKeyEdgeOPAPolicy).String()
+	fEdgeOPABinary          = kingpin.Flag("edge-opa-binary", EnvKeyEdgeOPABinary+" path to the opa binary evaluating the Rego policies, looked up in the PATH by default").Envar(EnvKeyEdgeOPABinary).String()
+
+	// Edge zero-touch enrollment
+	fEdgeBootstrapFile        = kingpin.Flag("edge-bootstrap-file", EnvKeyEdgeBootstrapFile+" path to the JSON provisioning file baked into the device image, holding the Portainer server URL, an enrollment token, the initial labels and the proxy settings. On first boot the agent enrolls itself to obtain its Edge key, then deletes the file or locks it when it cannot be deleted").Envar(EnvKeyEdgeBootstrapFile).String()
+	fEdgeAttestation          = kingpin.Flag("edge-attestation", EnvKeyEdgeAttestation+" how the device proves the identity of its hardware when it enrolls with the provisioning file: off, tpm for the EK certificate of the TPM and a quote of its attestation key, or certificate for the device certificate provisioned at manufacturing, e.g. by a secure element. The server can approve the devices of a known hardware batch without an enrollment token (default to off)").Envar(EnvKeyEdgeAttestation).Default(agent.DefaultEdgeAttestation).Enum("off", "tpm", "certificate")
+	fEdgeAttestationCert      = kingpin.Flag("edge-attestation-cert", EnvKeyEdgeAttestationCert+" path to the PEM certificate chain of the device, used with the certificate attestation").Envar(EnvKeyEdgeAttestationCert).String()
+	fEdgeAttestationKey       = kingpin.Flag("edge-attestation-key", EnvKeyEdgeAttestationKey+" path to the PEM private key of the device certificate, used with the certificate attestation").Envar(EnvKeyEdgeAttestationKey).String()
+	fEdgeAttestationTPMHandle = kingpin.Flag("edge-attestation-tpm-handle", EnvKeyEdgeAttestationTPMHandle+" persistent handle of the attestation key of the TPM, used with the tpm attestation (default to 0x81010002)").Envar(EnvKeyEdgeAttestationTPMHandle).Default(agent.DefaultEdgeAttestationTPMHandle).String()
+
+	// Edge device labels
+	fEdgeLabelsFile = kingpin.Flag("edge-labels-file", EnvKeyEdgeLabelsFile+" path to a file of key=value lines declaring the labels of the device, reported to Portainer along with the labels detected from the DMI asset tags and the cloud-init metadata").Envar(EnvKeyEdgeLabelsFile).String()
+	fEdgeSetLabels  = kingpin.Flag("set-label", "set a label of the device in the key=value format and exit, an empty value removes the label. Can be repeated. Used on a running agent, the labels are kept in the data folder").Strings()
+	fPrintConfig    = kingpin.Flag("print-config", "print the effective settings of the agent in JSON, with their defaults and where their values come from, the secrets being redacted, and exit").Bool()
+	fPreflight      = kingpin.Flag("preflight", "validate the configuration of the agent, print the preflight report in JSON and exit, with a non-zero status when an issue prevents the agent from starting").Bool()
+
+	// Edge pause
+	fEdgePause       = kingpin.Flag("pause", "pause the processing of the instructions sent by Portainer for the given duration and exit, used by the updater and the local operations that must not be interrupted. The pause is kept in the data folder").Duration()
+	fEdgePauseReason = kingpin.Flag("pause-reason", "reason of the pause, shown in the logs of the agent").String()
+	fEdgeResume      = kingpin.Flag("resume", "resume the processing of the instructions sent by Portainer and exit").Bool()
+
+	// Edge freeze windows
+	fEdgeFreezeUntil  = kingpin.Flag("freeze-until", "freeze the stacks of the device at their current version until the given date (2006-01-02) or RFC3339 time and exit, their updates are deferred until then. The freeze windows are kept in the data folder").String()
+	fEdgeFreezeStack  = kingpin.Flag("freeze-stack", "identifier of the stack frozen or unfrozen instead of the whole device").Int()
+	fEdgeFreezeReason = kingpin.Flag("freeze-reason", "reason of the freeze, reported to Portainer along with the deferred updates").String()
+	fEdgeUnfreeze     = kingpin.Flag("unfreeze", "lift the freeze of the device, or of the stack given by --freeze-stack, and exit").Bool()
+
+	// Edge backups
+	fEdgeExportBackup = kingpin.Flag("export-backup", "export the Edge stacks retained on the device with their redacted environment, the labels, the freeze windows and the redacted settings of the agent to the given archive and exit. Used on a running agent, the archive can be imported on a replacement device").String()
+	fEdgeImportBackup = kingpin.Flag("import-backup", "import the archive exported from another device into the data folder and exit, the settings of the exported agent are printed in the env file format").String()
+
 	// mTLS edge agent certs
 	fSSLCert           = kingpin.Flag("mtlscert", "Path to the mTLS certificate used to identify the agent to Portainer").Envar(EnvKeySSLCert).String()
 	fSSLKey            = kingpin.Flag("mtlskey", "Path to the mTLS key used to identify the agent to Portainer").Envar(EnvKeySSLKey).String()
@@ -129,39 +344,138 @@ func (parser *EnvOptionParser) Options() (*agent.Options, error) {
 	}
 
 	return &agent.Options{
-		AssetsPath:            *fAssetsPath,
-		AgentServerAddr:       fAgentServerAddr.String(),
-		AgentServerPort:       strconv.Itoa(*fAgentServerPort),
-		AgentSecurityShutdown: *fAgentSecurityShutdown,
-		ClusterAddress:        *fClusterAddress,
-		ClusterProbeTimeout:   *fClusterProbeTimeout,
-		ClusterProbeInterval:  *fClusterProbeInterval,
-		DataPath:              *fDataPath,
-		EdgeMode:              *fEdgeMode,
-		EdgeAsyncMode:         *fEdgeAsyncMode,
-		EdgeKey:               *fEdgeKey,
-		EdgeID:                *fEdgeID,
-		EdgeUIServerAddr:      fEdgeServerAddr.String(),
-		EdgeUIServerPort:      strconv.Itoa(*fEdgeServerPort),
-		EdgeInactivityTimeout: *fEdgeInactivityTimeout,
-		EdgeInsecurePoll:      *fEdgeInsecurePoll,
-		EdgeTunnel:            *fEdgeTunnel,
-		EdgeTunnelProxy:       httpProxy,
-		HealthCheck:           *fHealthCheck,
-		LogLevel:              *fLogLevel,
-		LogMode:               *fLogMode,
-		SharedSecret:          *fSharedSecret,
-		SSLCert:               *fSSLCert,
-		SSLKey:                *fSSLKey,
-		SSLCACert:             *fSSLCACert,
-		CertRetryInterval:     *fCertRetryInterval,
-		AWSClientCert:         *fAWSClientCert,
-		AWSClientKey:          *fAWSClientKey,
-		AWSClientBundle:       *fAWSClientBundle,
-		AWSRoleARN:            *fAWSRoleARN,
-		AWSTrustAnchorARN:     *fAWSTrustAnchorARN,
-		AWSProfileARN:         *fAWSProfileARN,
-		AWSRegion:             *fAWSRegion,
+		AssetsPath:                *fAssetsPath,
+		AgentServerAddr:           fAgentServerAddr.String(),
+		AgentServerPort:           strconv.Itoa(*fAgentServerPort),
+		AgentSecurityShutdown:     *fAgentSecurityShutdown,
+		ClusterAddress:            *fClusterAddress,
+		ClusterProbeTimeout:       *fClusterProbeTimeout,
+		ClusterProbeInterval:      *fClusterProbeInterval,
+		ClusterKey:                *fClusterKey,
+		ClusterMTLSCACert:         *fClusterMTLSCACert,
+		ClusterMTLSCert:           *fClusterMTLSCert,
+		ClusterMTLSKey:            *fClusterMTLSKey,
+		DataPath:                  *fDataPath,
+		EdgeMode:                  *fEdgeMode,
+		EdgeAsyncMode:             *fEdgeAsyncMode,
+		EdgeJSONCodec:             *fEdgeJSONCodec,
+		EdgeCompression:           *fEdgeCompression,
+		EdgeUserAgent:             *fEdgeUserAgent,
+		EdgeFleetTag:              *fEdgeFleetTag,
+		EdgeExtraHeaders:          parseURLListValue(*fEdgeExtraHeaders),
+		EdgeRequestRetries:        *fEdgeRequestRetries,
+		EdgeCircuitCooldown:       *fEdgeCircuitCooldown,
+		EdgeKey:                   *fEdgeKey,
+		EdgeID:                    *fEdgeID,
+		EdgeUIServerAddr:          fEdgeServerAddr.String(),
+		EdgeUIServerPort:          strconv.Itoa(*fEdgeServerPort),
+		EdgeInactivityTimeout:     *fEdgeInactivityTimeout,
+		EdgeInsecurePoll:          *fEdgeInsecurePoll,
+		EdgeServerCA:              *fEdgeServerCA,
+		EdgeRegistryCA:            *fEdgeRegistryCA,
+		EdgeServerPins:            parseURLListValue(*fEdgeServerPins),
+		EdgeTLSRevocation:         *fEdgeTLSRevocation,
+		EdgeTunnel:                *fEdgeTunnel,
+		EdgeTunnelProxy:           httpProxy,
+		EdgeDeployHTTPProxy:       firstValue(*fEdgeDeployHttpProxy, *fEdgeTunnelHttpProxy, os.Getenv("http_proxy")),
+		EdgeDeployHTTPSProxy:      firstValue(*fEdgeDeployHttpsProxy, *fEdgeTunnelHttpsProxy, os.Getenv("https_proxy")),
+		EdgeDeployNoProxy:         append(parseURLListValue(firstValue(os.Getenv("NO_PROXY"), os.Getenv("no_proxy"))), parseURLListValue(*fEdgeDeployNoProxy)...),
+		HealthCheck:               *fHealthCheck,
+		DurableWrites:             *fDurableWrites,
+		EdgeProfile:               *fEdgeProfile,
+		EdgeSnapshotMode:          profile.Resolve(*fEdgeSnapshotMode, *fEdgeProfile, "full", "minimal"),
+		EdgeStackPullProgress:     profile.Resolve(*fEdgeStackPullProgress, *fEdgeProfile, "on", "off"),
+		MDNS:                      *fMDNS,
+		DockerHost:                *fDockerHost,
+		DockerContext:             *fDockerContext,
+		LogLevel:                  *fLogLevel,
+		LogMode:                   *fLogMode,
+		SharedSecret:              *fSharedSecret,
+		SSLCert:                   *fSSLCert,
+		SSLKey:                    *fSSLKey,
+		SSLCACert:                 *fSSLCACert,
+		CertRetryInterval:         *fCertRetryInterval,
+		AWSClientCert:             *fAWSClientCert,
+		AWSClientKey:              *fAWSClientKey,
+		AWSClientBundle:           *fAWSClientBundle,
+		AWSRoleARN:                *fAWSRoleARN,
+		AWSTrustAnchorARN:         *fAWSTrustAnchorARN,
+		AWSProfileARN:             *fAWSProfileARN,
+		AWSRegion:                 *fAWSRegion,
+		EdgeNotifyMQTTAddr:        *fEdgeNotifyMQTTAddr,
+		EdgeNotifyMQTTTopic:       *fEdgeNotifyMQTTTopic,
+		EdgeNotifyWebhookURL:      *fEdgeNotifyWebhookURL,
+		EdgeNotifyExec:            *fEdgeNotifyExec,
+		EdgeStatusWebhooks:        parseURLListValue(*fEdgeStatusWebhooks),
+		EdgeStatusWebhookRate:     *fEdgeStatusWebhookRate,
+		EdgeStackHistoryCount:     *fEdgeStackHistoryCount,
+		EdgeStackHistorySize:      int64(*fEdgeStackHistorySize),
+		EdgeStackChangelogReport:  *fEdgeStackChangelogReport,
+		EdgeStackChangelog:        *fEdgeStackChangelog,
+		EdgeStackLargeFileSize:    int64(*fEdgeStackLargeFileSize),
+		EdgeStackCache:            *fEdgeStackCache,
+		EdgeStackCacheSize:        int64(*fEdgeStackCacheSize),
+		EdgeStackCacheS3URL:       *fEdgeStackCacheS3URL,
+		EdgeStackCacheS3Region:    *fEdgeStackCacheS3Region,
+		EdgeStackCacheS3AccessKey: *fEdgeStackCacheS3AccessKey,
+		EdgeStackCacheS3SecretKey: *fEdgeStackCacheS3SecretKey,
+		EdgeSessionRecord:         *fEdgeSessionRecord,
+		EdgeSessionRecordSize:     int64(*fEdgeSessionRecordSize),
+		EdgeJobHistoryCount:       *fEdgeJobHistoryCount,
+		EdgeJobHistoryReport:      *fEdgeJobHistoryReport,
+		EdgeJobHistory:            *fEdgeJobHistory,
+		EdgeJobHistoryID:          *fEdgeJobHistoryID,
+		EdgeJobUser:               *fEdgeJobUser,
+		EdgeJobAllowRoot:          *fEdgeJobAllowRoot,
+		EdgeFileTransferPaths:     parseURLListValue(*fEdgeFileTransferPaths),
+		EdgeFileTransferSize:      int64(*fEdgeFileTransferSize),
+		EdgeHostInfoInterval:      *fEdgeHostInfoInterval,
+		EdgeHostUpdatesCheck:      *fEdgeHostUpdatesCheck,
+		EdgeHostUpdateActions:     parseURLListValue(*fEdgeHostUpdateActions),
+		EdgePowerCriticalHours:    *fEdgePowerCriticalHours,
+		EdgeUpgradeVerifyWindow:   *fEdgeUpgradeVerifyWindow,
+		EdgeCredentialStore:       *fEdgeCredentialStore,
+		EdgeCredentialHelper:      *fEdgeCredentialHelper,
+		EdgeStackOrphanPolicy:     *fEdgeStackOrphanPolicy,
+		EdgeStackLint:             *fEdgeStackLint,
+		EdgeStackSkipUnchanged:    *fEdgeStackSkipUnchanged,
+		EdgeStackNaming:           *fEdgeStackNaming,
+		EdgeStackPrefix:           *fEdgeStackPrefix,
+		EdgeStackEnvPaths:         parseURLListValue(*fEdgeStackEnvPaths),
+		EdgeStorageQuota:          int64(*fEdgeStorageQuota),
+		EdgeJobLogMaxSize:         int64(*fEdgeJobLogMaxSize),
+		EdgeStackFilesPath:        *fEdgeStackFilesPath,
+		EdgeJobScriptsPath:        *fEdgeJobScriptsPath,
+		TmpPath:                   *fTmpPath,
+		FIPSMode:                  *fFIPSMode,
+		TLSMinVersion:             *fTLSMinVersion,
+		TLSCipherSuites:           parseURLListValue(*fTLSCipherSuites),
+		EdgeLabelsFile:            *fEdgeLabelsFile,
+		EdgeBootstrapFile:         *fEdgeBootstrapFile,
+		EdgeAttestation:           *fEdgeAttestation,
+		EdgeAttestationCert:       *fEdgeAttestationCert,
+		EdgeAttestationKey:        *fEdgeAttestationKey,
+		EdgeAttestationTPMHandle:  *fEdgeAttestationTPMHandle,
+		EdgeStandby:               *fEdgeStandby,
+		EdgeStandbyLease:          *fEdgeStandbyLease,
+		EdgeEndpointsFile:         *fEdgeEndpointsFile,
+		EdgeImagePolicyFile:       *fEdgeImagePolicyFile,
+		EdgeSecurityPolicyFile:    *fEdgeSecurityPolicyFile,
+		EdgeFirewallPolicyFile:    *fEdgeFirewallPolicyFile,
+		EdgeOPAPolicy:             *fEdgeOPAPolicy,
+		EdgeOPABinary:             *fEdgeOPABinary,
+		EdgeSetLabels:             *fEdgeSetLabels,
+		PrintConfig:               *fPrintConfig,
+		Preflight:                 *fPreflight,
+		EdgePause:                 *fEdgePause,
+		EdgePauseReason:           *fEdgePauseReason,
+		EdgeResume:                *fEdgeResume,
+		EdgeFreezeUntil:           *fEdgeFreezeUntil,
+		EdgeFreezeStack:           *fEdgeFreezeStack,
+		EdgeFreezeReason:          *fEdgeFreezeReason,
+		EdgeUnfreeze:              *fEdgeUnfreeze,
+		EdgeExportBackup:          *fEdgeExportBackup,
+		EdgeImportBackup:          *fEdgeImportBackup,
 		EdgeMetaFields: agent.EdgeMetaFields{
 			EdgeGroupsIDs:      edgeGroupsIDs,
 			EnvironmentGroupID: *fEnvironmentGroupID,
@@ -171,6 +485,55 @@ func (parser *EnvOptionParser) Options() (*agent.Options, error) {
 	}, nil
 }
 
+// redactedSettings are the settings holding secrets, or URLs that can hold credentials, their values are redacted
+// from the exported settings
+var redactedSettings = map[string]bool{
+	EnvKeyAgentSecret:               true,
+	EnvKeyClusterKey:                true,
+	EnvKeyEdgeKey:                   true,
+	EnvKeyEdgeTunnelHttpProxy:       true,
+	EnvKeyEdgeTunnelHttpsProxy:      true,
+	EnvKeyEdgeDeployHttpProxy:       true,
+	EnvKeyEdgeDeployHttpsProxy:      true,
+	EnvKeyEdgeNotifyMQTTAddr:        true,
+	EnvKeyEdgeNotifyWebhookURL:      true,
+	EnvKeyEdgeStatusWebhooks:        true,
+	EnvKeyEdgeExtraHeaders:          true,
+	EnvKeyEdgeStackCacheS3URL:       true,
+	EnvKeyEdgeStackCacheS3SecretKey: true,
+}
+
+// RedactedValue replaces the values of the secrets in the exported settings
+const RedactedValue = "<redacted>"
+
+// Settings returns the settings of the agent set through its flags or their environment variables, indexed by
+// environment variable. The settings left to their default are omitted, the secrets are redacted.
+// The options must be parsed first.
+func Settings() map[string]string {
+	settings := make(map[string]string)
+
+	for _, flag := range kingpin.CommandLine.Model().Flags {
+		if flag.Envar == "" || flag.Value == nil {
+			continue
+		}
+
+		value := flag.Value.String()
+
+		switch value {
+		case strings.Join(flag.Default, urlListSeparator), "", "false", "0", "0s", "[]":
+			continue
+		}
+
+		if redactedSettings[flag.Envar] {
+			value = RedactedValue
+		}
+
+		settings[flag.Envar] = value
+	}
+
+	return settings
+}
+
 const listSeparator = ":"
 
 func parseListValue(flagValue *string) ([]int, error) {
@@ -189,3 +552,27 @@ func parseListValue(flagValue *string) ([]int, error) {
 
 	return arr, nil
 }
+
+const urlListSeparator = ","
+
+func parseURLListValue(flagValue string) []string {
+	var urls []string
+	for _, url := range strings.Split(flagValue, urlListSeparator) {
+		if url = strings.TrimSpace(url); url != "" {
+			urls = append(urls, url)
+		}
+	}
+
+	return urls
+}
+
+// firstValue returns the first non empty value
+func firstValue(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
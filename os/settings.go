@@ -0,0 +1,147 @@
+package os
+
+import (
+	"os"
+	"sort"
+	"strings"
+
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+// Sources of the settings of the agent
+const (
+	// SourceDefault is the source of the settings left to their default
+	SourceDefault = "default"
+	// SourceEnv is the source of the settings set through their environment variable
+	SourceEnv = "env"
+	// SourceFlag is the source of the settings set through their command line flag
+	SourceFlag = "flag"
+	// SourceFile is the source of the settings read from the data folder
+	SourceFile = "file"
+	// SourceServer is the source of the settings sent by the Portainer server
+	SourceServer = "server"
+)
+
+// Setting is a setting of the agent as it was resolved, its secret values are redacted
+type Setting struct {
+	// Name is the environment variable of the setting, or the name of a setting sent by the server
+	Name string `json:"name"`
+	// Flag is the command line flag of the setting, empty for the settings sent by the server
+	Flag      string `json:"flag,omitempty"`
+	Subsystem string `json:"subsystem"`
+	Value     string `json:"value"`
+	Default   string `json:"default,omitempty"`
+	Source    string `json:"source"`
+}
+
+// subsystems maps the prefixes of the settings to the subsystem they configure, the longest prefixes first
+var subsystems = []struct{ prefix, subsystem string }{
+	{"AGENT_CLUSTER", "cluster"},
+	{"EDGE_STACK", "edge stacks"},
+	{"EDGE_STORAGE", "edge stacks"},
+	{"EDGE_DEPLOY", "edge stacks"},
+	{"EDGE_REGISTRY", "edge stacks"},
+	{"EDGE_IMAGE", "edge stacks"},
+	{"EDGE_SECURITY", "edge stacks"},
+	{"EDGE_OPA", "edge stacks"},
+	{"EDGE_JOB", "edge jobs"},
+	{"EDGE_NOTIFY", "notifications"},
+	{"EDGE_STATUS", "notifications"},
+	{"EDGE_HOST", "host"},
+	{"EDGE_POWER", "host"},
+	{"EDGE_FILE", "host"},
+	{"EDGE_SERVER_CA", "tls"},
+	{"EDGE_SERVER_PINS", "tls"},
+	{"EDGE_TLS", "tls"},
+	{"MTLS", "tls"},
+	{"TLS", "tls"},
+	{"FIPS", "tls"},
+	{"AWS", "aws"},
+	{"LOG", "logging"},
+	{"DOCKER", "runtime"},
+	{"EDGE", "edge"},
+	{"HTTP", "edge"},
+	{"PORTAINER", "edge"},
+}
+
+// SettingSubsystem returns the subsystem configured by the setting
+func SettingSubsystem(name string) string {
+	for _, s := range subsystems {
+		if strings.HasPrefix(name, s.prefix) {
+			return s.subsystem
+		}
+	}
+
+	return "agent"
+}
+
+// EffectiveSettings returns all the settings of the agent, including the ones left to their default, along with where
+// their value comes from, sorted by subsystem and name. The secrets are redacted. The options must be parsed first.
+func EffectiveSettings() []Setting {
+	var settings []Setting
+
+	seen := make(map[string]bool)
+
+	for _, flag := range kingpin.CommandLine.Model().Flags {
+		// The deprecated flags share their environment variable with the flag replacing them
+		if flag.Envar == "" || flag.Value == nil || seen[flag.Envar] {
+			continue
+		}
+
+		seen[flag.Envar] = true
+
+		setting := Setting{
+			Name:      flag.Envar,
+			Flag:      "--" + flag.Name,
+			Subsystem: SettingSubsystem(flag.Envar),
+			Value:     flag.Value.String(),
+			Default:   strings.Join(flag.Default, urlListSeparator),
+			Source:    SourceDefault,
+		}
+
+		switch {
+		case flagSet(flag.Envar):
+			setting.Source = SourceFlag
+		case os.Getenv(flag.Envar) != "":
+			setting.Source = SourceEnv
+		}
+
+		if redactedSettings[flag.Envar] && setting.Value != "" {
+			setting.Value = RedactedValue
+		}
+
+		settings = append(settings, setting)
+	}
+
+	SortSettings(settings)
+
+	return settings
+}
+
+// SortSettings sorts the settings by subsystem and name
+func SortSettings(settings []Setting) {
+	sort.SliceStable(settings, func(i, j int) bool {
+		if settings[i].Subsystem != settings[j].Subsystem {
+			return settings[i].Subsystem < settings[j].Subsystem
+		}
+
+		return settings[i].Name < settings[j].Name
+	})
+}
+
+// flagSet returns true when one of the flags of the environment variable is on the command line
+func flagSet(envar string) bool {
+	for _, flag := range kingpin.CommandLine.Model().Flags {
+		if flag.Envar != envar {
+			continue
+		}
+
+		for _, arg := range os.Args[1:] {
+			if arg == "--"+flag.Name || arg == "--no-"+flag.Name || strings.HasPrefix(arg, "--"+flag.Name+"=") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
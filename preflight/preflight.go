@@ -0,0 +1,315 @@
+// Package preflight validates the configuration of the agent at startup and reports the issues found along with how
+// to fix them. Only the fatal issues prevent the agent from starting.
+package preflight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"time"
+
+	"github.com/portainer/agent"
+	"github.com/portainer/agent/chaos"
+	"github.com/portainer/agent/edge/blobstore"
+	"github.com/portainer/agent/edge/client"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Severities of the checks
+const (
+	SeverityOK      = "ok"
+	SeverityWarning = "warning"
+	SeverityFatal   = "fatal"
+)
+
+// engineTimeout is how long the container engine is waited for
+const engineTimeout = 5 * time.Second
+
+// Check is the result of a preflight check
+type Check struct {
+	Name     string `json:"name"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	// Hint is how to fix the issue, empty when the check passed
+	Hint string `json:"hint,omitempty"`
+}
+
+// Report is the result of the preflight checks
+type Report struct {
+	Checks []Check `json:"checks"`
+}
+
+// Fatal returns true when one of the checks found an issue preventing the agent from starting
+func (report *Report) Fatal() bool {
+	for _, check := range report.Checks {
+		if check.Severity == SeverityFatal {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Log logs the checks, the failed ones with their hint
+func (report *Report) Log() {
+	warnings, fatals := 0, 0
+
+	for _, check := range report.Checks {
+		switch check.Severity {
+		case SeverityOK:
+			log.Debug().Str("check", check.Name).Msg(check.Message)
+		case SeverityWarning:
+			warnings++
+
+			log.Warn().Str("check", check.Name).Str("hint", check.Hint).Msg(check.Message)
+		case SeverityFatal:
+			fatals++
+
+			log.Error().Str("check", check.Name).Str("hint", check.Hint).Msg(check.Message)
+		}
+	}
+
+	log.Info().Int("checks", len(report.Checks)).Int("warnings", warnings).Int("fatal", fatals).Msg("preflight checks done")
+}
+
+func (report *Report) ok(name, message string) {
+	report.Checks = append(report.Checks, Check{Name: name, Severity: SeverityOK, Message: message})
+}
+
+func (report *Report) warn(name, message, hint string) {
+	report.Checks = append(report.Checks, Check{Name: name, Severity: SeverityWarning, Message: message, Hint: hint})
+}
+
+func (report *Report) fatal(name, message, hint string) {
+	report.Checks = append(report.Checks, Check{Name: name, Severity: SeverityFatal, Message: message, Hint: hint})
+}
+
+// Config is the configuration checked by Run
+type Config struct {
+	Options  *agent.Options
+	Platform agent.ContainerPlatform
+	// PingEngine returns an error when the Docker or Podman engine is not reachable
+	PingEngine func(ctx context.Context) error
+}
+
+// Run checks the configuration of the agent
+func Run(config Config) *Report {
+	report := &Report{}
+
+	checkEdge(report, config.Options, config.Platform)
+	checkTLSFiles(report, config.Options)
+	checkHeaders(report, config.Options)
+	checkStackCache(report, config.Options)
+	checkAssets(report, config.Options, config.Platform)
+	checkEngine(report, config)
+	checkChaos(report)
+
+	return report
+}
+
+// checkEdge checks the Edge key and identifier, and the settings excluded by the async mode
+func checkEdge(report *Report, options *agent.Options, platform agent.ContainerPlatform) {
+	if !options.EdgeMode {
+		if options.EdgeAsyncMode {
+			report.fatal("edge_async", "the Edge async mode is enabled but the Edge mode is not", "set EDGE=1 or unset EDGE_ASYNC")
+		}
+
+		if options.EdgeKey != "" || options.EdgeID != "" {
+			report.warn("edge_mode", "an Edge key or identifier is set but the Edge mode is not, they are ignored", "set EDGE=1 to deploy an Edge agent")
+		}
+
+		return
+	}
+
+	if options.EdgeID == "" {
+		report.fatal("edge_id", "the Edge mode requires an Edge identifier", "set EDGE_ID to the identifier given by the Portainer server, e.g. in the deployment command of the environment")
+	} else {
+		report.ok("edge_id", "Edge identifier set")
+	}
+
+	switch {
+	case options.EdgeKey != "":
+		report.ok("edge_key", "Edge key set")
+	case fileExists(filepath.Join(options.DataPath, agent.EdgeKeyFile)):
+		report.ok("edge_key", "Edge key found in the data folder")
+	default:
+		report.warn("edge_key", "no Edge key is set, the agent waits for it to be entered on its page or to be shared by its cluster", "set EDGE_KEY to the key given by the Portainer server, or EDGE_BOOTSTRAP_FILE to a provisioning file to enroll the agent")
+	}
+
+	if options.EdgeAsyncMode {
+		if options.EdgeInactivityTimeout != agent.DefaultEdgeSleepInterval {
+			report.warn("edge_async", "EDGE_INACTIVITY_TIMEOUT is ignored in Edge async mode, the agent opens no tunnel", "unset EDGE_INACTIVITY_TIMEOUT")
+		} else {
+			report.ok("edge_async", "Edge async mode settings consistent")
+		}
+	} else if platform == agent.PlatformContainerd {
+		// Portainer reaches the engine through the Docker API, only the async snapshots are supported
+		report.fatal("edge_async", "the containerd engine is only supported in Edge async mode", "set EDGE_ASYNC=1")
+	}
+}
+
+// checkTLSFiles checks the certificates and keys of the mTLS with the Portainer server and between agents
+func checkTLSFiles(report *Report, options *agent.Options) {
+	if (options.SSLCert == "") != (options.SSLKey == "") {
+		report.fatal("mtls", "only one of the mTLS certificate and key is set", "set both MTLS_SSL_CERT and MTLS_SSL_KEY, or none of them")
+	} else if options.SSLCert != "" {
+		missing := missingFiles(options.SSLCert, options.SSLKey, options.SSLCACert)
+
+		switch {
+		case len(missing) == 0:
+			report.ok("mtls", "mTLS files found")
+		case options.CertRetryInterval > 0 && !slices.Contains(missing, options.SSLCACert):
+			report.warn("mtls", fmt.Sprintf("the mTLS files %v are missing, the agent waits for them", missing), "check that the certificates are provisioned in the mounted folder")
+		default:
+			report.fatal("mtls", fmt.Sprintf("the mTLS files %v are missing", missing), "mount the certificates in the agent container and check the MTLS_SSL_CERT, MTLS_SSL_KEY and MTLS_SSL_CA paths")
+		}
+	}
+
+	switch {
+	case options.ClusterMTLSCACert == "" && options.ClusterMTLSCert == "" && options.ClusterMTLSKey == "":
+	case options.ClusterMTLSCACert == "" || options.ClusterMTLSCert == "" || options.ClusterMTLSKey == "":
+		report.fatal("cluster_mtls", "the mTLS between agents requires a CA, a certificate and a key", "set AGENT_CLUSTER_MTLS_CA, AGENT_CLUSTER_MTLS_CERT and AGENT_CLUSTER_MTLS_KEY")
+	default:
+		missing := missingFiles(options.ClusterMTLSCACert, options.ClusterMTLSCert, options.ClusterMTLSKey)
+		if len(missing) > 0 {
+			report.fatal("cluster_mtls", fmt.Sprintf("the mTLS files %v are missing", missing), "mount the certificates of the cluster in the agent container")
+
+			return
+		}
+
+		report.ok("cluster_mtls", "mTLS files between agents found")
+	}
+}
+
+// checkHeaders checks the extra headers of the requests sent to the Portainer server
+func checkHeaders(report *Report, options *agent.Options) {
+	if len(options.EdgeExtraHeaders) == 0 {
+		return
+	}
+
+	if _, err := client.ParseExtraHeaders(options.EdgeExtraHeaders); err != nil {
+		report.fatal("extra_headers", err.Error(), "set EDGE_EXTRA_HEADERS to a comma-separated list of Name: value headers, the headers of the agent cannot be overridden")
+
+		return
+	}
+
+	report.ok("extra_headers", "extra headers valid")
+}
+
+// checkStackCache checks the bucket of the s3 cache of the stack payloads
+func checkStackCache(report *Report, options *agent.Options) {
+	if !options.EdgeMode || options.EdgeStackCache != blobstore.BackendS3 {
+		return
+	}
+
+	if _, err := blobstore.NewS3Store(options.EdgeStackCacheS3URL, options.EdgeStackCacheS3Region, options.EdgeStackCacheS3AccessKey, options.EdgeStackCacheS3SecretKey); err != nil {
+		report.warn("stack_cache", err.Error()+", the cache of the stack payloads is disabled", "set EDGE_STACK_CACHE_S3_URL to the URL of the bucket, e.g. https://minio.site.local:9000/edge-cache")
+
+		return
+	}
+
+	report.ok("stack_cache", "s3 cache of the stack payloads configured")
+}
+
+// checkAssets checks the binaries the agent deploys the stacks with
+func checkAssets(report *Report, options *agent.Options, platform agent.ContainerPlatform) {
+	if !fileExists(options.AssetsPath) {
+		report.warn("assets", fmt.Sprintf("the assets folder %s does not exist, the stacks cannot be deployed", options.AssetsPath), "use the agent image or set ASSETS_PATH to the folder of the docker, docker-compose and kubectl binaries")
+
+		return
+	}
+
+	var binaries []string
+
+	switch platform {
+	case agent.PlatformDocker, agent.PlatformPodman:
+		binaries = []string{"docker", "docker-compose"}
+	case agent.PlatformKubernetes:
+		binaries = []string{"kubectl"}
+	default:
+		report.ok("assets", "assets folder found")
+
+		return
+	}
+
+	var missing []string
+	for _, binary := range binaries {
+		if runtime.GOOS == "windows" {
+			binary += ".exe"
+		}
+
+		if !fileExists(filepath.Join(options.AssetsPath, binary)) {
+			missing = append(missing, binary)
+		}
+	}
+
+	if len(missing) > 0 {
+		report.warn("assets", fmt.Sprintf("the binaries %v are missing from the assets folder, the stacks cannot be deployed", missing), "run the setup.sh script or use the agent image")
+
+		return
+	}
+
+	report.ok("assets", "assets found")
+}
+
+// checkEngine checks that the Docker or Podman engine is reachable
+func checkEngine(report *Report, config Config) {
+	if config.PingEngine == nil {
+		return
+	}
+
+	switch config.Platform {
+	case agent.PlatformDocker, agent.PlatformPodman:
+	default:
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), engineTimeout)
+	defer cancel()
+
+	if err := config.PingEngine(ctx); err != nil {
+		hint := "mount the engine socket in the agent container, e.g. -v /var/run/docker.sock:/var/run/docker.sock, or check DOCKER_HOST"
+		if runtime.GOOS == "windows" {
+			hint = `mount the engine named pipe in the agent container, e.g. -v \\.\pipe\docker_engine:\\.\pipe\docker_engine, or check DOCKER_HOST`
+		}
+
+		report.fatal("engine", "the container engine is not reachable: "+err.Error(), hint)
+
+		return
+	}
+
+	report.ok("engine", "container engine reachable")
+}
+
+// checkChaos warns that failures are injected in the deployment pipeline
+func checkChaos(report *Report) {
+	if !chaos.Enabled() {
+		return
+	}
+
+	report.warn("chaos", "the agent is a chaos build injecting failures in the deployment pipeline", "unset "+chaos.EnvKey+" or deploy an agent built without the chaos build tag")
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+
+	return !errors.Is(err, os.ErrNotExist)
+}
+
+// missingFiles returns the set paths that do not exist
+func missingFiles(paths ...string) []string {
+	var missing []string
+
+	for _, path := range paths {
+		if path != "" && !fileExists(path) {
+			missing = append(missing, path)
+		}
+	}
+
+	return missing
+}
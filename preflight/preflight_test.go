@@ -0,0 +1,105 @@
+package preflight
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/portainer/agent"
+	"github.com/stretchr/testify/assert"
+)
+
+func severities(report *Report) map[string]string {
+	result := make(map[string]string)
+	for _, check := range report.Checks {
+		result[check.Name] = check.Severity
+	}
+
+	return result
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name     string
+		options  agent.Options
+		platform agent.ContainerPlatform
+		ping     error
+		expected map[string]string
+		fatal    bool
+	}{
+		{
+			name:     "async mode without Edge mode",
+			options:  agent.Options{EdgeAsyncMode: true, AssetsPath: dir},
+			platform: agent.PlatformNomad,
+			expected: map[string]string{"edge_async": SeverityFatal},
+			fatal:    true,
+		},
+		{
+			name:     "Edge mode without key",
+			options:  agent.Options{EdgeMode: true, EdgeID: "id", DataPath: dir, AssetsPath: dir, EdgeInactivityTimeout: agent.DefaultEdgeSleepInterval},
+			platform: agent.PlatformNomad,
+			expected: map[string]string{"edge_id": SeverityOK, "edge_key": SeverityWarning},
+		},
+		{
+			name:     "containerd without async mode",
+			options:  agent.Options{EdgeMode: true, EdgeID: "id", EdgeKey: "key", AssetsPath: dir},
+			platform: agent.PlatformContainerd,
+			expected: map[string]string{"edge_async": SeverityFatal},
+			fatal:    true,
+		},
+		{
+			name:     "missing mTLS files waited for",
+			options:  agent.Options{SSLCert: filepath.Join(dir, "cert.pem"), SSLKey: filepath.Join(dir, "key.pem"), CertRetryInterval: 1, AssetsPath: dir},
+			platform: agent.PlatformNomad,
+			expected: map[string]string{"mtls": SeverityWarning},
+		},
+		{
+			name:     "partial cluster mTLS",
+			options:  agent.Options{ClusterMTLSCACert: filepath.Join(dir, "ca.pem"), AssetsPath: dir},
+			platform: agent.PlatformNomad,
+			expected: map[string]string{"cluster_mtls": SeverityFatal},
+			fatal:    true,
+		},
+		{
+			name:     "reserved extra header",
+			options:  agent.Options{EdgeExtraHeaders: []string{"X-PortainerAgent-EdgeID: other"}, AssetsPath: dir},
+			platform: agent.PlatformNomad,
+			expected: map[string]string{"extra_headers": SeverityFatal},
+			fatal:    true,
+		},
+		{
+			name:     "s3 stack cache without bucket",
+			options:  agent.Options{EdgeMode: true, EdgeID: "id", EdgeKey: "key", EdgeStackCache: "s3", EdgeStackCacheS3URL: "https://minio.site.local:9000", AssetsPath: dir, EdgeInactivityTimeout: agent.DefaultEdgeSleepInterval},
+			platform: agent.PlatformNomad,
+			expected: map[string]string{"stack_cache": SeverityWarning},
+		},
+		{
+			name:     "unreachable engine and missing assets",
+			options:  agent.Options{AssetsPath: filepath.Join(dir, "missing")},
+			platform: agent.PlatformDocker,
+			ping:     errors.New("connection refused"),
+			expected: map[string]string{"assets": SeverityWarning, "engine": SeverityFatal},
+			fatal:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := Run(Config{
+				Options:    &tt.options,
+				Platform:   tt.platform,
+				PingEngine: func(ctx context.Context) error { return tt.ping },
+			})
+
+			got := severities(report)
+			for name, severity := range tt.expected {
+				assert.Equal(t, severity, got[name], name)
+			}
+
+			assert.Equal(t, tt.fatal, report.Fatal())
+		})
+	}
+}
@@ -0,0 +1,58 @@
+// Package profile selects the subsystems of the agent according to the memory of the device. The standard profile
+// enables all of them, the minimal profile trades the memory-heavy ones for lighter modes on the 256-512MB ARMv6 and
+// ARMv7 devices. The profile defaults to the one of the build, e.g. go build -tags minimal, and is overridden with the
+// EDGE_PROFILE environment variable.
+package profile
+
+import (
+	"os"
+	"runtime/debug"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// Standard enables all the subsystems of the agent
+	Standard = "standard"
+	// Minimal sends the minimal snapshots, lets the deployer pull the images of the stacks and bounds the heap of the
+	// agent
+	Minimal = "minimal"
+	// Auto selects the mode of a subsystem matching the profile
+	Auto = "auto"
+)
+
+// minimalMemoryLimit is the soft memory limit of the agents running with the minimal profile, the garbage collector
+// runs more often as the heap gets close to it
+const minimalMemoryLimit = 64 << 20
+
+// Default returns the profile of the build
+func Default() string {
+	return buildProfile
+}
+
+// Resolve returns the mode of a subsystem, the mode matching the profile when it is auto
+func Resolve(mode, profile, standardMode, minimalMode string) string {
+	if mode != Auto && mode != "" {
+		return mode
+	}
+
+	if profile == Minimal {
+		return minimalMode
+	}
+
+	return standardMode
+}
+
+// Apply sets the runtime switches of the profile. The soft memory limit of the minimal profile is not set when the
+// GOMEMLIMIT environment variable sets it.
+func Apply(profile string) {
+	if profile != Minimal {
+		return
+	}
+
+	if _, ok := os.LookupEnv("GOMEMLIMIT"); !ok {
+		debug.SetMemoryLimit(minimalMemoryLimit)
+	}
+
+	log.Info().Str("profile", profile).Msg("running with the minimal profile")
+}
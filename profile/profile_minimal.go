@@ -0,0 +1,6 @@
+//go:build minimal
+
+package profile
+
+// The minimal builds default to the minimal profile
+const buildProfile = Minimal
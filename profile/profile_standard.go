@@ -0,0 +1,6 @@
+//go:build !minimal
+
+package profile
+
+// The builds default to the standard profile
+const buildProfile = Standard
@@ -0,0 +1,17 @@
+package profile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolve(t *testing.T) {
+	assert.Equal(t, "full", Resolve(Auto, Standard, "full", "minimal"))
+	assert.Equal(t, "minimal", Resolve(Auto, Minimal, "full", "minimal"))
+	assert.Equal(t, "minimal", Resolve("", Minimal, "full", "minimal"))
+
+	// The modes set explicitly override the profile
+	assert.Equal(t, "full", Resolve("full", Minimal, "full", "minimal"))
+	assert.Equal(t, "minimal", Resolve("minimal", Standard, "full", "minimal"))
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/portainer/agent"
 
 	"github.com/hashicorp/logutils"
+	"github.com/hashicorp/memberlist"
 	"github.com/hashicorp/serf/serf"
 	"github.com/rs/zerolog/log"
 )
@@ -31,12 +32,16 @@ const (
 type ClusterService struct {
 	runtimeConfiguration *agent.RuntimeConfiguration
 	cluster              *serf.Serf
+	encryption           EncryptionConfig
+	keyring              *memberlist.Keyring
+	rotation             keyRotation
 }
 
 // NewClusterService returns a pointer to a ClusterService.
-func NewClusterService(runtimeConfiguration *agent.RuntimeConfiguration) *ClusterService {
+func NewClusterService(runtimeConfiguration *agent.RuntimeConfiguration, encryption EncryptionConfig) *ClusterService {
 	return &ClusterService{
 		runtimeConfiguration: runtimeConfiguration,
+		encryption:           encryption,
 	}
 }
 
@@ -76,7 +81,18 @@ func (service *ClusterService) Create(advertiseAddr string, joinAddr []string, p
 	conf.ReconnectInterval = 10 * time.Second
 	conf.ReconnectTimeout = 1 * time.Minute
 
-	log.Debug().Str("advertise_address", advertiseAddr).Strs("join_address", joinAddr).Msg("")
+	keyring, err := newKeyring(service.encryption)
+	if err != nil {
+		return err
+	}
+
+	if keyring != nil {
+		conf.MemberlistConfig.Keyring = keyring
+		conf.KeyringFile = service.encryption.KeyringFile
+		service.keyring = keyring
+	}
+
+	log.Debug().Str("advertise_address", advertiseAddr).Strs("join_address", joinAddr).Bool("encrypted", keyring != nil).Msg("")
 
 	cluster, err := serf.Create(conf)
 	if err != nil {
@@ -0,0 +1,220 @@
+package serf
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/portainer/agent"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/hashicorp/serf/serf"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultKeyRotationOverlap is how long the previous encryption key is still accepted after a rotation,
+// so that the messages already sent with it and the members catching up are not rejected
+const DefaultKeyRotationOverlap = 10 * time.Minute
+
+// EncryptionConfig is the configuration of the encryption of the cluster gossip
+type EncryptionConfig struct {
+	// Key is the base64 encoded key used when the keyring file does not exist yet
+	Key string
+	// KeyringFile is where the keys are kept across restarts, the rotated keys take precedence over Key
+	KeyringFile string
+}
+
+// keyRotation tracks the removal of the previous key at the end of the overlap window
+type keyRotation struct {
+	mu      sync.Mutex
+	pending map[string]*time.Timer
+}
+
+// newKeyring returns the keyring of the cluster, nil when the gossip is not encrypted.
+// The first key of the keyring file is the primary key.
+func newKeyring(config EncryptionConfig) (*memberlist.Keyring, error) {
+	var keys []string
+
+	if config.KeyringFile != "" {
+		data, err := os.ReadFile(config.KeyringFile)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+
+		if err == nil {
+			if err := json.Unmarshal(data, &keys); err != nil {
+				return nil, fmt.Errorf("invalid keyring file: %w", err)
+			}
+		}
+	}
+
+	if len(keys) == 0 && config.Key != "" {
+		keys = []string{config.Key}
+	}
+
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	decoded := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		raw, err := decodeKey(key)
+		if err != nil {
+			return nil, err
+		}
+
+		decoded = append(decoded, raw)
+	}
+
+	return memberlist.NewKeyring(decoded, decoded[0])
+}
+
+func decodeKey(key string) ([]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cluster encryption key: %w", err)
+	}
+
+	if l := len(raw); l != 16 && l != 24 && l != 32 {
+		return nil, errors.New("invalid cluster encryption key: the key must be 16, 24 or 32 bytes long")
+	}
+
+	return raw, nil
+}
+
+// keyResponseError returns an error when a key operation failed on some members
+func keyResponseError(operation string, resp *serf.KeyResponse, err error) error {
+	if err == nil && resp.NumErr == 0 {
+		return nil
+	}
+
+	var messages []string
+	if resp != nil {
+		for node, message := range resp.Messages {
+			messages = append(messages, node+": "+message)
+		}
+	}
+
+	if err == nil {
+		err = fmt.Errorf("%d members out of %d failed", resp.NumErr, resp.NumNodes)
+	}
+
+	if len(messages) > 0 {
+		return fmt.Errorf("unable to %s the cluster encryption key: %w (%s)", operation, err, strings.Join(messages, ", "))
+	}
+
+	return fmt.Errorf("unable to %s the cluster encryption key: %w", operation, err)
+}
+
+// RotateEncryptionKey installs the key on all the members of the cluster and makes it their primary key.
+// The previous primary key is removed from all the members once the overlap window is over.
+func (service *ClusterService) RotateEncryptionKey(key string, overlap time.Duration) error {
+	if service.cluster == nil || !service.cluster.EncryptionEnabled() {
+		return errors.New("the cluster gossip is not encrypted, the encryption can only be enabled at startup")
+	}
+
+	raw, err := decodeKey(key)
+	if err != nil {
+		return err
+	}
+
+	previous := base64.StdEncoding.EncodeToString(service.keyring.GetPrimaryKey())
+	key = base64.StdEncoding.EncodeToString(raw)
+
+	if key == previous {
+		return nil
+	}
+
+	manager := service.cluster.KeyManager()
+
+	resp, err := manager.InstallKey(key)
+	if err := keyResponseError("install", resp, err); err != nil {
+		return err
+	}
+
+	resp, err = manager.UseKey(key)
+	if err := keyResponseError("use", resp, err); err != nil {
+		return err
+	}
+
+	log.Info().Dur("overlap", overlap).Msg("cluster encryption key rotated")
+
+	service.scheduleKeyRemoval(previous, overlap)
+
+	return nil
+}
+
+// scheduleKeyRemoval removes the key from all the members at the end of the overlap window
+func (service *ClusterService) scheduleKeyRemoval(key string, overlap time.Duration) {
+	service.rotation.mu.Lock()
+	defer service.rotation.mu.Unlock()
+
+	if service.rotation.pending == nil {
+		service.rotation.pending = make(map[string]*time.Timer)
+	}
+
+	if timer, ok := service.rotation.pending[key]; ok {
+		timer.Stop()
+	}
+
+	service.rotation.pending[key] = time.AfterFunc(overlap, func() {
+		service.rotation.mu.Lock()
+		delete(service.rotation.pending, key)
+		service.rotation.mu.Unlock()
+
+		// The key was made primary again by a later rotation
+		if key == base64.StdEncoding.EncodeToString(service.keyring.GetPrimaryKey()) {
+			return
+		}
+
+		resp, err := service.cluster.KeyManager().RemoveKey(key)
+		if err := keyResponseError("remove", resp, err); err != nil {
+			log.Error().Err(err).Msg("unable to remove the previous cluster encryption key")
+
+			return
+		}
+
+		log.Info().Msg("previous cluster encryption key removed")
+	})
+}
+
+// EncryptionKeys returns the number of members each key is installed on, the keys are identified
+// by a fingerprint so that they are not exposed
+func (service *ClusterService) EncryptionKeys() ([]agent.ClusterKey, error) {
+	if service.cluster == nil || !service.cluster.EncryptionEnabled() {
+		return nil, errors.New("the cluster gossip is not encrypted")
+	}
+
+	resp, err := service.cluster.KeyManager().ListKeys()
+	if err := keyResponseError("list", resp, err); err != nil {
+		return nil, err
+	}
+
+	primary := base64.StdEncoding.EncodeToString(service.keyring.GetPrimaryKey())
+
+	keys := make([]agent.ClusterKey, 0, len(resp.Keys))
+	for key, members := range resp.Keys {
+		keys = append(keys, agent.ClusterKey{
+			Fingerprint:  keyFingerprint(key),
+			Primary:      key == primary,
+			Members:      members,
+			TotalMembers: resp.NumNodes,
+		})
+	}
+
+	return keys, nil
+}
+
+// keyFingerprint identifies a base64 encoded key without exposing it
+func keyFingerprint(key string) string {
+	sum := sha256.Sum256([]byte(key))
+
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,36 @@
+package serf
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewKeyring(t *testing.T) {
+	envKey := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))
+	rotatedKey := base64.StdEncoding.EncodeToString([]byte("fedcba9876543210"))
+	keyringFile := filepath.Join(t.TempDir(), "keyring.json")
+
+	keyring, err := newKeyring(EncryptionConfig{KeyringFile: keyringFile})
+	require.NoError(t, err)
+	assert.Nil(t, keyring)
+
+	keyring, err = newKeyring(EncryptionConfig{Key: envKey, KeyringFile: keyringFile})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("0123456789abcdef"), keyring.GetPrimaryKey())
+
+	// The rotated keys kept in the keyring file take precedence
+	require.NoError(t, os.WriteFile(keyringFile, []byte(`["`+rotatedKey+`", "`+envKey+`"]`), 0600))
+
+	keyring, err = newKeyring(EncryptionConfig{Key: envKey, KeyringFile: keyringFile})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("fedcba9876543210"), keyring.GetPrimaryKey())
+	assert.Len(t, keyring.GetKeys(), 2)
+
+	_, err = newKeyring(EncryptionConfig{Key: base64.StdEncoding.EncodeToString([]byte("short"))})
+	assert.Error(t, err)
+}
@@ -0,0 +1,409 @@
+package systemd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/portainer/agent"
+	agentfs "github.com/portainer/agent/filesystem"
+	"github.com/portainer/portainer/pkg/libstack"
+	"github.com/rs/zerolog/log"
+)
+
+// UnitPath is the folder of the unit files of the host
+const UnitPath = "/etc/systemd/system"
+
+// Deployer represents a service to deploy the native workloads of the stacks as systemd units.
+// When the agent runs in a container, the host filesystem and the systemd socket of the host must be mounted.
+type Deployer struct {
+	unitPath string
+	run      func(ctx context.Context, name string, args ...string) ([]byte, error)
+}
+
+// NewDeployer returns a pointer to a new instance of Deployer writing the units to the host mounted
+// in the agent container, or to the filesystem of the agent when it runs on the host
+func NewDeployer() *Deployer {
+	unitPath := UnitPath
+	if _, err := os.Stat(filepath.Join(agent.HostRoot, UnitPath)); err == nil {
+		unitPath = filepath.Join(agent.HostRoot, UnitPath)
+	}
+
+	return &Deployer{
+		unitPath: unitPath,
+		run:      runCommand,
+	}
+}
+
+func runCommand(ctx context.Context, name string, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return output, nil
+}
+
+// Deploy writes the units of the stack file, removes the units no longer declared, and (re)starts the changed units
+func (d *Deployer) Deploy(ctx context.Context, name string, filePaths []string, options agent.DeployOptions) error {
+	if len(filePaths) == 0 {
+		return errors.New("missing systemd stack file paths")
+	}
+
+	file, err := parseStackFile(filePaths[0])
+	if err != nil {
+		return err
+	}
+
+	existing, err := d.stackUnits(name)
+	if err != nil {
+		return err
+	}
+
+	declared := make(map[string]bool)
+	var changed, unchanged []string
+
+	for _, unitName := range file.sortedUnitNames() {
+		fileName := unitFileName(name, unitName)
+		declared[fileName] = true
+
+		content := file.Units[unitName].render(name, unitName)
+
+		current, err := os.ReadFile(filepath.Join(d.unitPath, fileName))
+		if err == nil && string(current) == content {
+			unchanged = append(unchanged, fileName)
+
+			continue
+		}
+
+		if err := agentfs.WriteFile(d.unitPath, fileName, []byte(content), 0644); err != nil {
+			return fmt.Errorf("unable to write the unit %s: %w", fileName, err)
+		}
+
+		changed = append(changed, fileName)
+	}
+
+	var stale []string
+	for _, fileName := range existing {
+		if !declared[fileName] {
+			stale = append(stale, fileName)
+		}
+	}
+
+	if err := d.removeUnits(ctx, stale); err != nil {
+		return err
+	}
+
+	if _, err := d.run(ctx, "systemctl", "daemon-reload"); err != nil {
+		return fmt.Errorf("unable to reload systemd: %w", err)
+	}
+
+	if len(changed) > 0 {
+		if _, err := d.run(ctx, "systemctl", append([]string{"enable"}, changed...)...); err != nil {
+			return fmt.Errorf("unable to enable the units: %w", err)
+		}
+
+		if _, err := d.run(ctx, "systemctl", append([]string{"restart", "--no-block"}, changed...)...); err != nil {
+			return fmt.Errorf("unable to start the units: %w", err)
+		}
+	}
+
+	// The units stopped since the previous deployment are started again
+	if len(unchanged) > 0 {
+		if _, err := d.run(ctx, "systemctl", append([]string{"start", "--no-block"}, unchanged...)...); err != nil {
+			return fmt.Errorf("unable to start the units: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Remove stops, disables and removes the units of the stack
+func (d *Deployer) Remove(ctx context.Context, name string, filePaths []string, options agent.RemoveOptions) error {
+	units, err := d.stackUnits(name)
+	if err != nil {
+		return err
+	}
+
+	if err := d.removeUnits(ctx, units); err != nil {
+		return err
+	}
+
+	if _, err := d.run(ctx, "systemctl", "daemon-reload"); err != nil {
+		return fmt.Errorf("unable to reload systemd: %w", err)
+	}
+
+	return nil
+}
+
+// Pull is a dummy method for systemd, the binaries are managed by the stack files
+func (d *Deployer) Pull(ctx context.Context, name string, filePaths []string, options agent.PullOptions) error {
+	return nil
+}
+
+// Validate checks the stack name and parses the stack file
+func (d *Deployer) Validate(ctx context.Context, name string, filePaths []string, options agent.ValidateOptions) error {
+	if len(filePaths) == 0 {
+		return errors.New("missing systemd stack file paths")
+	}
+
+	if !validUnitName.MatchString(name) {
+		return fmt.Errorf("invalid stack name %q", name)
+	}
+
+	_, err := parseStackFile(filePaths[0])
+
+	return err
+}
+
+// WaitForStatus waits until the units of the stack reach the status, the health command of the
+// active units is run on the host through systemd-run
+func (d *Deployer) WaitForStatus(ctx context.Context, name string, status libstack.Status) <-chan libstack.WaitResult {
+	waitResultCh := make(chan libstack.WaitResult)
+	waitResult := libstack.WaitResult{
+		Status: status,
+	}
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				waitResult.ErrorMsg = fmt.Sprintf("failed to wait for status: %s", ctx.Err().Error())
+				waitResultCh <- waitResult
+
+				return
+			default:
+			}
+
+			time.Sleep(1 * time.Second)
+
+			aggregateStatus, errorMessage, err := d.stackStatus(ctx, name)
+			if err != nil {
+				log.Warn().Str("stack_name", name).Err(err).Msg("failed to retrieve the status of the units")
+
+				continue
+			}
+
+			if aggregateStatus == status {
+				waitResultCh <- waitResult
+
+				return
+			}
+
+			if status == libstack.StatusRunning && aggregateStatus == libstack.StatusCompleted {
+				waitResult.Status = libstack.StatusCompleted
+				waitResultCh <- waitResult
+
+				return
+			}
+
+			if errorMessage != "" {
+				waitResult.ErrorMsg = errorMessage
+				waitResultCh <- waitResult
+
+				return
+			}
+
+			log.Debug().
+				Str("stack_name", name).
+				Str("status", string(aggregateStatus)).
+				Msg("waiting for status")
+		}
+	}()
+
+	return waitResultCh
+}
+
+// stackStatus returns the status of the stack from the states of its units
+func (d *Deployer) stackStatus(ctx context.Context, name string) (libstack.Status, string, error) {
+	units, err := d.stackUnits(name)
+	if err != nil {
+		return "", "", err
+	}
+
+	if len(units) == 0 {
+		return libstack.StatusRemoved, "", nil
+	}
+
+	running, completed := 0, 0
+
+	for _, unit := range units {
+		state, err := d.unitState(ctx, unit)
+		if err != nil {
+			return "", "", err
+		}
+
+		switch {
+		case state.ActiveState == "failed" || (state.Result != "" && state.Result != "success"):
+			return libstack.StatusError, fmt.Sprintf("unit %s failed: %s", unit, state.Result), nil
+		case state.ActiveState == "active" && state.SubState == "running":
+			if err := d.checkHealth(ctx, unit); err != nil {
+				return libstack.StatusStarting, "", nil
+			}
+
+			running++
+		case state.ActiveState == "inactive" && state.Type == "oneshot" && state.Result == "success" && state.ExecMainStatus == "0":
+			completed++
+		case state.ActiveState == "activating" || state.ActiveState == "reloading" || state.SubState == "auto-restart":
+			return libstack.StatusStarting, "", nil
+		case state.ActiveState == "deactivating":
+			return libstack.StatusRemoving, "", nil
+		}
+	}
+
+	switch {
+	case running+completed < len(units):
+		return libstack.StatusUnknown, "", nil
+	case running == 0:
+		return libstack.StatusCompleted, "", nil
+	}
+
+	return libstack.StatusRunning, "", nil
+}
+
+type unitState struct {
+	Type           string
+	ActiveState    string
+	SubState       string
+	Result         string
+	ExecMainStatus string
+}
+
+func (d *Deployer) unitState(ctx context.Context, unit string) (unitState, error) {
+	output, err := d.run(ctx, "systemctl", "show", "--property=Type,ActiveState,SubState,Result,ExecMainStatus", unit)
+	if err != nil {
+		return unitState{}, err
+	}
+
+	var state unitState
+	for _, line := range strings.Split(string(output), "\n") {
+		key, value, _ := strings.Cut(strings.TrimSpace(line), "=")
+
+		switch key {
+		case "Type":
+			state.Type = value
+		case "ActiveState":
+			state.ActiveState = value
+		case "SubState":
+			state.SubState = value
+		case "Result":
+			state.Result = value
+		case "ExecMainStatus":
+			state.ExecMainStatus = value
+		}
+	}
+
+	return state, nil
+}
+
+// checkHealth runs the health command of the unit, if any, as the user of the unit
+func (d *Deployer) checkHealth(ctx context.Context, fileName string) error {
+	unit, err := d.declaredUnit(fileName)
+	if err != nil || unit == nil || unit.Health == nil {
+		return err
+	}
+
+	args := []string{"--wait", "--quiet", "--collect", "--service-type=exec"}
+	if unit.User != "" {
+		args = append(args, "--uid="+unit.User)
+	}
+
+	if unit.WorkingDirectory != "" {
+		args = append(args, "--working-directory="+unit.WorkingDirectory)
+	}
+
+	args = append(args, "/bin/sh", "-c", unit.Health.Command)
+
+	_, err = d.run(ctx, "systemd-run", args...)
+
+	return err
+}
+
+// declaredUnit returns the settings of the unit the health command needs, read from its unit file
+func (d *Deployer) declaredUnit(fileName string) (*Unit, error) {
+	content, err := os.ReadFile(filepath.Join(d.unitPath, fileName))
+	if err != nil {
+		return nil, err
+	}
+
+	unit := &Unit{}
+	for _, line := range strings.Split(string(content), "\n") {
+		key, value, _ := strings.Cut(line, "=")
+
+		switch key {
+		case healthDirective:
+			unit.Health = &Health{Command: strings.ReplaceAll(value, "%%", "%")}
+		case "User":
+			unit.User = value
+		case "WorkingDirectory":
+			unit.WorkingDirectory = value
+		}
+	}
+
+	return unit, nil
+}
+
+// stackUnits returns the unit files of the stack, the ones recording the stack among the files named after it. The
+// units written without the record are left as is.
+func (d *Deployer) stackUnits(name string) ([]string, error) {
+	// The name is part of the file names and of the pattern
+	if !validUnitName.MatchString(name) {
+		return nil, fmt.Errorf("invalid stack name %q", name)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(d.unitPath, unitPrefix+name+"-*.service"))
+	if err != nil {
+		return nil, err
+	}
+
+	record := stackDirective + "=" + name
+
+	units := make([]string, 0, len(paths))
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		for _, line := range strings.Split(string(content), "\n") {
+			if line == record {
+				units = append(units, filepath.Base(path))
+
+				break
+			}
+		}
+	}
+
+	return units, nil
+}
+
+func (d *Deployer) removeUnits(ctx context.Context, units []string) error {
+	if len(units) == 0 {
+		return nil
+	}
+
+	if _, err := d.run(ctx, "systemctl", append([]string{"disable", "--now"}, units...)...); err != nil {
+		return fmt.Errorf("unable to stop the units: %w", err)
+	}
+
+	for _, unit := range units {
+		if err := os.Remove(filepath.Join(d.unitPath, unit)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("unable to remove the unit %s: %w", unit, err)
+		}
+	}
+
+	return nil
+}
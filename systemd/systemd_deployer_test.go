@@ -0,0 +1,249 @@
+package systemd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/portainer/agent"
+	"github.com/portainer/portainer/pkg/libstack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSystemd struct {
+	commands []string
+	states   map[string]string
+	failing  map[string]bool
+}
+
+func (f *fakeSystemd) run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	command := name + " " + strings.Join(args, " ")
+	f.commands = append(f.commands, command)
+
+	if name == "systemctl" && args[0] == "show" {
+		return []byte(f.states[args[len(args)-1]]), nil
+	}
+
+	if f.failing[command] {
+		return nil, os.ErrPermission
+	}
+
+	return nil, nil
+}
+
+func newTestDeployer(t *testing.T) (*Deployer, *fakeSystemd) {
+	fake := &fakeSystemd{states: map[string]string{}, failing: map[string]bool{}}
+
+	return &Deployer{unitPath: t.TempDir(), run: fake.run}, fake
+}
+
+func writeStackFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "units.yml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+
+	return path
+}
+
+const collectorStack = `units:
+  collector:
+    exec: /opt/collector/bin/collector --rate 50%
+    user: collector
+    environment:
+      LOG_LEVEL: info
+      MOTD: say "hi"
+    health:
+      command: /opt/collector/bin/collector --check
+  backup:
+    exec: /opt/backup/run.sh
+    oneShot: true
+`
+
+func TestParseStackFile_invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"no units", "units: {}"},
+		{"invalid name", "units:\n  ../etc:\n    exec: /bin/true"},
+		{"missing exec", "units:\n  app:\n    user: app"},
+		{"invalid restart", "units:\n  app:\n    exec: /bin/true\n    restart: sometimes"},
+		{"line break", "units:\n  app:\n    exec: \"/bin/true\\nExecStartPre=/bin/rm -rf /\""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseStackFile(writeStackFile(t, tt.content))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestUnitRender(t *testing.T) {
+	file, err := parseStackFile(writeStackFile(t, collectorStack))
+	require.NoError(t, err)
+
+	content := file.Units["collector"].render("edge_telemetry", "collector")
+
+	assert.Contains(t, content, "Description=collector of the edge_telemetry Edge stack\n")
+	assert.Contains(t, content, stackDirective+"=edge_telemetry\n")
+	assert.Contains(t, content, "Restart=always\n")
+	assert.Contains(t, content, "ExecStart=/opt/collector/bin/collector --rate 50%%\n")
+	assert.Contains(t, content, "User=collector\n")
+	assert.Contains(t, content, "Environment=\"LOG_LEVEL=info\"\nEnvironment=\"MOTD=say \\\"hi\\\"\"\n")
+	assert.Contains(t, content, healthDirective+"=/opt/collector/bin/collector --check\n")
+
+	content = file.Units["backup"].render("edge_telemetry", "backup")
+
+	assert.Contains(t, content, "Type=oneshot\n")
+	assert.NotContains(t, content, "Restart=")
+}
+
+func TestDeploy(t *testing.T) {
+	deployer, fake := newTestDeployer(t)
+
+	// A unit of the previous version of the stack no longer declared
+	require.NoError(t, os.WriteFile(filepath.Join(deployer.unitPath, "portainer-edge_telemetry-legacy.service"), []byte("[Unit]\n"+stackDirective+"=edge_telemetry\n"), 0644))
+
+	stackFile := writeStackFile(t, collectorStack)
+
+	err := deployer.Deploy(context.Background(), "edge_telemetry", []string{stackFile}, agent.DeployOptions{})
+	require.NoError(t, err)
+
+	units, err := deployer.stackUnits("edge_telemetry")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"portainer-edge_telemetry-backup.service", "portainer-edge_telemetry-collector.service"}, units)
+
+	assert.Equal(t, []string{
+		"systemctl disable --now portainer-edge_telemetry-legacy.service",
+		"systemctl daemon-reload",
+		"systemctl enable portainer-edge_telemetry-backup.service portainer-edge_telemetry-collector.service",
+		"systemctl restart --no-block portainer-edge_telemetry-backup.service portainer-edge_telemetry-collector.service",
+	}, fake.commands)
+
+	// The unchanged units are not restarted
+	fake.commands = nil
+
+	err = deployer.Deploy(context.Background(), "edge_telemetry", []string{stackFile}, agent.DeployOptions{})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"systemctl daemon-reload",
+		"systemctl start --no-block portainer-edge_telemetry-backup.service portainer-edge_telemetry-collector.service",
+	}, fake.commands)
+}
+
+func TestDeploy_prefixedStackNames(t *testing.T) {
+	deployer, fake := newTestDeployer(t)
+
+	require.NoError(t, deployer.Deploy(context.Background(), "web-api", []string{writeStackFile(t, collectorStack)}, agent.DeployOptions{}))
+	require.NoError(t, deployer.Deploy(context.Background(), "web", []string{writeStackFile(t, "units:\n  server:\n    exec: /opt/web/server\n")}, agent.DeployOptions{}))
+
+	units, err := deployer.stackUnits("web")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"portainer-web-server.service"}, units)
+
+	// The units of web-api are neither stale units of web nor removed with it
+	fake.commands = nil
+
+	require.NoError(t, deployer.Remove(context.Background(), "web", nil, agent.RemoveOptions{}))
+
+	assert.Equal(t, []string{
+		"systemctl disable --now portainer-web-server.service",
+		"systemctl daemon-reload",
+	}, fake.commands)
+
+	units, err = deployer.stackUnits("web-api")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"portainer-web-api-backup.service", "portainer-web-api-collector.service"}, units)
+}
+
+func TestDeploy_invalidStackName(t *testing.T) {
+	deployer, fake := newTestDeployer(t)
+
+	for _, name := range []string{"../etc", "web/api", "web*", "-web", ""} {
+		err := deployer.Deploy(context.Background(), name, []string{writeStackFile(t, collectorStack)}, agent.DeployOptions{})
+		assert.ErrorContains(t, err, "invalid stack name", name)
+
+		err = deployer.Validate(context.Background(), name, []string{writeStackFile(t, collectorStack)}, agent.ValidateOptions{})
+		assert.ErrorContains(t, err, "invalid stack name", name)
+
+		assert.Error(t, deployer.Remove(context.Background(), name, nil, agent.RemoveOptions{}), name)
+	}
+
+	entries, err := os.ReadDir(deployer.unitPath)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+	assert.Empty(t, fake.commands)
+}
+
+func TestRemove(t *testing.T) {
+	deployer, fake := newTestDeployer(t)
+
+	stackFile := writeStackFile(t, collectorStack)
+	require.NoError(t, deployer.Deploy(context.Background(), "edge_telemetry", []string{stackFile}, agent.DeployOptions{}))
+
+	fake.commands = nil
+
+	err := deployer.Remove(context.Background(), "edge_telemetry", nil, agent.RemoveOptions{})
+	require.NoError(t, err)
+
+	units, err := deployer.stackUnits("edge_telemetry")
+	require.NoError(t, err)
+	assert.Empty(t, units)
+
+	assert.Equal(t, []string{
+		"systemctl disable --now portainer-edge_telemetry-backup.service portainer-edge_telemetry-collector.service",
+		"systemctl daemon-reload",
+	}, fake.commands)
+}
+
+func TestStackStatus(t *testing.T) {
+	const (
+		running   = "Type=simple\nActiveState=active\nSubState=running\nResult=success\nExecMainStatus=0\n"
+		completed = "Type=oneshot\nActiveState=inactive\nSubState=dead\nResult=success\nExecMainStatus=0\n"
+		starting  = "Type=simple\nActiveState=activating\nSubState=start\nResult=success\nExecMainStatus=0\n"
+		failed    = "Type=simple\nActiveState=failed\nSubState=failed\nResult=exit-code\nExecMainStatus=1\n"
+	)
+
+	healthCheck := "systemd-run --wait --quiet --collect --service-type=exec --uid=collector /bin/sh -c /opt/collector/bin/collector --check"
+
+	tests := []struct {
+		name       string
+		collector  string
+		backup     string
+		unhealthy  bool
+		status     libstack.Status
+		hasMessage bool
+	}{
+		{"running", running, completed, false, libstack.StatusRunning, false},
+		{"unhealthy", running, completed, true, libstack.StatusStarting, false},
+		{"starting", starting, completed, false, libstack.StatusStarting, false},
+		{"failed", failed, completed, false, libstack.StatusError, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deployer, fake := newTestDeployer(t)
+			require.NoError(t, deployer.Deploy(context.Background(), "edge_telemetry", []string{writeStackFile(t, collectorStack)}, agent.DeployOptions{}))
+
+			fake.states["portainer-edge_telemetry-collector.service"] = tt.collector
+			fake.states["portainer-edge_telemetry-backup.service"] = tt.backup
+			fake.failing[healthCheck] = tt.unhealthy
+
+			status, message, err := deployer.stackStatus(context.Background(), "edge_telemetry")
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.status, status)
+			assert.Equal(t, tt.hasMessage, message != "")
+		})
+	}
+
+	deployer, _ := newTestDeployer(t)
+
+	status, _, err := deployer.stackStatus(context.Background(), "edge_telemetry")
+	require.NoError(t, err)
+	assert.Equal(t, libstack.StatusRemoved, status)
+}
@@ -0,0 +1,216 @@
+package systemd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// unitPrefix is the prefix of the units managed by the agent, followed by the stack name and the unit name
+	unitPrefix = "portainer-"
+
+	// healthDirective records the health command in the unit file, systemd ignores the X- directives
+	healthDirective = "X-PortainerHealth"
+
+	// stackDirective records the stack of the unit in the unit file, the unit files of a stack whose name is
+	// followed by a dash, e.g. web-api for web, also match the file names of the units of the stack
+	stackDirective = "X-PortainerStack"
+)
+
+var validUnitName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// StackFile is the format of the stacks deployed as systemd units, e.g.
+//
+//	units:
+//	  collector:
+//	    description: Telemetry collector
+//	    exec: /opt/collector/bin/collector --config /opt/collector/config.yml
+//	    user: collector
+//	    environment:
+//	      LOG_LEVEL: info
+//	    health:
+//	      command: /opt/collector/bin/collector --check
+type StackFile struct {
+	Units map[string]Unit `yaml:"units"`
+}
+
+// Unit is a native workload of the stack, run as a systemd service
+type Unit struct {
+	Description      string            `yaml:"description"`
+	Exec             string            `yaml:"exec"`
+	WorkingDirectory string            `yaml:"workingDirectory"`
+	User             string            `yaml:"user"`
+	Group            string            `yaml:"group"`
+	Environment      map[string]string `yaml:"environment"`
+	// Restart is the systemd restart policy, always by default
+	Restart string `yaml:"restart"`
+	// OneShot units run to completion, like the jobs
+	OneShot bool     `yaml:"oneShot"`
+	After   []string `yaml:"after"`
+	Health  *Health  `yaml:"health"`
+}
+
+// Health is the check run once the unit is active, the unit is healthy when the command exits with 0
+type Health struct {
+	Command string `yaml:"command"`
+}
+
+// parseStackFile reads and validates the units of a stack file
+func parseStackFile(path string) (*StackFile, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file StackFile
+	if err := yaml.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("invalid systemd stack file: %w", err)
+	}
+
+	if len(file.Units) == 0 {
+		return nil, errors.New("invalid systemd stack file: no units declared")
+	}
+
+	for name, unit := range file.Units {
+		if !validUnitName.MatchString(name) {
+			return nil, fmt.Errorf("invalid unit name %q", name)
+		}
+
+		if strings.TrimSpace(unit.Exec) == "" {
+			return nil, fmt.Errorf("the exec command of the unit %s is required", name)
+		}
+
+		switch unit.Restart {
+		case "", "no", "always", "on-success", "on-failure", "on-abnormal", "on-abort", "on-watchdog":
+		default:
+			return nil, fmt.Errorf("invalid restart policy %q of the unit %s", unit.Restart, name)
+		}
+
+		if unit.Health != nil && strings.TrimSpace(unit.Health.Command) == "" {
+			return nil, fmt.Errorf("the health command of the unit %s is required", name)
+		}
+
+		// A line break would add directives to the rendered unit
+		if !unit.singleLine() {
+			return nil, fmt.Errorf("the settings of the unit %s cannot span several lines", name)
+		}
+	}
+
+	return &file, nil
+}
+
+func (unit Unit) singleLine() bool {
+	values := append([]string{unit.Description, unit.Exec, unit.WorkingDirectory, unit.User, unit.Group}, unit.After...)
+	if unit.Health != nil {
+		values = append(values, unit.Health.Command)
+	}
+
+	for key, value := range unit.Environment {
+		values = append(values, key, value)
+	}
+
+	for _, value := range values {
+		if strings.ContainsAny(value, "\r\n") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// unitFileName returns the name of the unit file of a unit of the stack
+func unitFileName(stackName, name string) string {
+	return unitPrefix + stackName + "-" + name + ".service"
+}
+
+// sortedUnitNames returns the names of the units of the stack file in a stable order
+func (file *StackFile) sortedUnitNames() []string {
+	names := make([]string, 0, len(file.Units))
+	for name := range file.Units {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// render returns the content of the unit file
+func (unit Unit) render(stackName, name string) string {
+	var b strings.Builder
+
+	description := unit.Description
+	if description == "" {
+		description = name + " of the " + stackName + " Edge stack"
+	}
+
+	b.WriteString("# Managed by the Portainer agent, changes are overwritten\n")
+	b.WriteString("[Unit]\n")
+	fmt.Fprintf(&b, "Description=%s\n", escape(description))
+	fmt.Fprintf(&b, "%s=%s\n", stackDirective, stackName)
+
+	for _, after := range unit.After {
+		fmt.Fprintf(&b, "After=%s\n", after)
+		fmt.Fprintf(&b, "Wants=%s\n", after)
+	}
+
+	b.WriteString("\n[Service]\n")
+
+	if unit.OneShot {
+		b.WriteString("Type=oneshot\n")
+		b.WriteString("RemainAfterExit=no\n")
+	} else {
+		b.WriteString("Type=simple\n")
+
+		restart := unit.Restart
+		if restart == "" {
+			restart = "always"
+		}
+		fmt.Fprintf(&b, "Restart=%s\n", restart)
+	}
+
+	fmt.Fprintf(&b, "ExecStart=%s\n", escape(unit.Exec))
+
+	if unit.WorkingDirectory != "" {
+		fmt.Fprintf(&b, "WorkingDirectory=%s\n", unit.WorkingDirectory)
+	}
+
+	if unit.User != "" {
+		fmt.Fprintf(&b, "User=%s\n", unit.User)
+	}
+
+	if unit.Group != "" {
+		fmt.Fprintf(&b, "Group=%s\n", unit.Group)
+	}
+
+	keys := make([]string, 0, len(unit.Environment))
+	for key := range unit.Environment {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(key + "=" + unit.Environment[key])
+		fmt.Fprintf(&b, "Environment=\"%s\"\n", escape(value))
+	}
+
+	if unit.Health != nil {
+		fmt.Fprintf(&b, "%s=%s\n", healthDirective, escape(unit.Health.Command))
+	}
+
+	b.WriteString("\n[Install]\n")
+	b.WriteString("WantedBy=multi-user.target\n")
+
+	return b.String()
+}
+
+// escape escapes the specifiers of systemd, the units are rendered as declared
+func escape(value string) string {
+	return strings.ReplaceAll(value, "%", "%%")
+}